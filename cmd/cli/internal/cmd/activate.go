@@ -43,7 +43,11 @@ var activateCmd = &cobra.Command{
 
 		log.Info().Msg("User activated")
 
-		return nil
+		if err := u.Load(ctx, dynamoDB, cfg.AWS.DynamoDB.Table.User); err != nil {
+			return err
+		}
+
+		return renderUser(cmd, u)
 	},
 }
 
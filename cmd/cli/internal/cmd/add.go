@@ -20,6 +20,7 @@ var addCmd = &cobra.Command{
 		email, _ := cmd.Flags().GetString("email")
 		firstName, _ := cmd.Flags().GetString("first-name")
 		lastName, _ := cmd.Flags().GetString("last-name")
+		attrs, _ := cmd.Flags().GetStringArray("attr")
 
 		ctx := cmd.Context()
 		log.Info().Msg("Executing the add command")
@@ -34,19 +35,26 @@ var addCmd = &cobra.Command{
 			return fmt.Errorf("Missing DynamoDB connection")
 		}
 
+		attributes, err := parseAttributes(ctx, dynamoDB,
+			cfg.AWS.DynamoDB.Table.User, attrs, nil)
+		if err != nil {
+			return err
+		}
+
 		nu := user.NewUser{
-			Email:     email,
-			FirstName: firstName,
-			LastName:  lastName,
+			Email:      email,
+			FirstName:  firstName,
+			LastName:   lastName,
+			Attributes: attributes,
 		}
 
-		_, err := user.Create(ctx, dynamoDB, &nu, cfg.AWS.DynamoDB.Table.User)
+		u, err := user.Create(ctx, dynamoDB, &nu, cfg.AWS.DynamoDB.Table.User)
 		if err != nil {
 			return err
 		}
 
 		log.Info().Msg("User created")
-		return nil
+		return renderUser(cmd, u)
 
 	},
 }
@@ -61,4 +69,6 @@ func init() {
 	addCmd.MarkFlagRequired("first-name")
 	addCmd.Flags().StringVarP(&lastName, "last-name", "l", "", "Last Name (required)")
 	addCmd.MarkFlagRequired("last-name")
+	addCmd.Flags().StringArray("attr", nil,
+		"Custom attribute name=value, can be repeated")
 }
@@ -0,0 +1,166 @@
+package cmd
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/rs/zerolog/log"
+
+	"github.com/aws/aws-sdk-go/service/dynamodb"
+	"github.com/roloum/users/internal/user"
+	"github.com/spf13/cobra"
+)
+
+// apikeyCmd groups the API key commands
+var apikeyCmd = &cobra.Command{
+	Use:   "apikey",
+	Short: "Manages the personal API keys of an user",
+}
+
+// apikeyCreateCmd creates an API key and prints it once
+var apikeyCreateCmd = &cobra.Command{
+	Use:   "create",
+	Short: "Creates an API key",
+	RunE: func(cmd *cobra.Command, args []string) error {
+
+		email, _ := cmd.Flags().GetString("email")
+		name, _ := cmd.Flags().GetString("name")
+		scopes, _ := cmd.Flags().GetStringSlice("scope")
+		expires, _ := cmd.Flags().GetDuration("expires-in")
+
+		ctx := cmd.Context()
+		log.Info().Msg("Executing the apikey create command")
+
+		cfg, ok := ctx.Value(ContextKey(CONFIG)).(Configuration)
+		if !ok {
+			return fmt.Errorf("Missing configuration")
+		}
+
+		dynamoDB, ok := ctx.Value(ContextKey(DYNAMO)).(*dynamodb.DynamoDB)
+		if !ok {
+			return fmt.Errorf("Missing DynamoDB connection")
+		}
+
+		u := &user.User{
+			Email: email,
+		}
+
+		k, secret, err := u.CreateAPIKey(ctx, dynamoDB,
+			cfg.AWS.DynamoDB.Table.User, name, scopes, expires)
+		if err != nil {
+			return err
+		}
+
+		log.Info().Msgf("API key created: %s", k.Prefix)
+
+		return render(cmd, struct {
+			*user.APIKey
+			Secret string `json:"secret"`
+		}{k, secret}, []string{"PREFIX", "NAME", "SECRET"},
+			[][]string{{k.Prefix, k.Name, secret}})
+	},
+}
+
+// apikeyListCmd lists the API keys of an user
+var apikeyListCmd = &cobra.Command{
+	Use:   "list",
+	Short: "Lists the API keys of an user",
+	RunE: func(cmd *cobra.Command, args []string) error {
+
+		email, _ := cmd.Flags().GetString("email")
+
+		ctx := cmd.Context()
+		log.Info().Msg("Executing the apikey list command")
+
+		cfg, ok := ctx.Value(ContextKey(CONFIG)).(Configuration)
+		if !ok {
+			return fmt.Errorf("Missing configuration")
+		}
+
+		dynamoDB, ok := ctx.Value(ContextKey(DYNAMO)).(*dynamodb.DynamoDB)
+		if !ok {
+			return fmt.Errorf("Missing DynamoDB connection")
+		}
+
+		u := &user.User{
+			Email: email,
+		}
+
+		keys, err := u.ListAPIKeys(ctx, dynamoDB, cfg.AWS.DynamoDB.Table.User)
+		if err != nil {
+			return err
+		}
+
+		rows := make([][]string, len(keys))
+		for i, k := range keys {
+			rows[i] = []string{k.Prefix, k.Name, strings.Join(k.Scopes, ","),
+				k.Created, k.Expires, k.LastUsed}
+		}
+
+		return render(cmd, keys, []string{"PREFIX", "NAME", "SCOPES", "CREATED",
+			"EXPIRES", "LAST USED"}, rows)
+	},
+}
+
+// apikeyRevokeCmd revokes an API key
+var apikeyRevokeCmd = &cobra.Command{
+	Use:   "revoke",
+	Short: "Revokes an API key",
+	RunE: func(cmd *cobra.Command, args []string) error {
+
+		email, _ := cmd.Flags().GetString("email")
+		prefix, _ := cmd.Flags().GetString("prefix")
+
+		ctx := cmd.Context()
+		log.Info().Msg("Executing the apikey revoke command")
+
+		cfg, ok := ctx.Value(ContextKey(CONFIG)).(Configuration)
+		if !ok {
+			return fmt.Errorf("Missing configuration")
+		}
+
+		dynamoDB, ok := ctx.Value(ContextKey(DYNAMO)).(*dynamodb.DynamoDB)
+		if !ok {
+			return fmt.Errorf("Missing DynamoDB connection")
+		}
+
+		u := &user.User{
+			Email: email,
+		}
+
+		if err := u.RevokeAPIKey(ctx, dynamoDB, cfg.AWS.DynamoDB.Table.User,
+			prefix); err != nil {
+			return err
+		}
+
+		log.Info().Msg("API key revoked")
+
+		return nil
+	},
+}
+
+func init() {
+	RootCmd.AddCommand(apikeyCmd)
+	apikeyCmd.AddCommand(apikeyCreateCmd)
+	apikeyCmd.AddCommand(apikeyListCmd)
+	apikeyCmd.AddCommand(apikeyRevokeCmd)
+
+	for _, c := range []*cobra.Command{apikeyCreateCmd, apikeyListCmd,
+		apikeyRevokeCmd} {
+		var email string
+		c.Flags().StringVarP(&email, "email", "e", "", "Email (required)")
+		c.MarkFlagRequired("email")
+	}
+
+	var name, prefix string
+	var scopes []string
+	apikeyCreateCmd.Flags().StringVarP(&name, "name", "n", "", "Name (required)")
+	apikeyCreateCmd.MarkFlagRequired("name")
+	apikeyCreateCmd.Flags().StringSliceVarP(&scopes, "scope", "s", nil,
+		"Scope, can be repeated")
+	apikeyCreateCmd.Flags().Duration("expires-in", 0,
+		"Lifetime of the key, e.g. 720h. Keys do not expire by default")
+
+	apikeyRevokeCmd.Flags().StringVarP(&prefix, "prefix", "p", "", "Key prefix (required)")
+	apikeyRevokeCmd.MarkFlagRequired("prefix")
+}
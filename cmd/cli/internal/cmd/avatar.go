@@ -0,0 +1,185 @@
+package cmd
+
+import (
+	"fmt"
+	"os"
+
+	"github.com/rs/zerolog/log"
+
+	"github.com/aws/aws-sdk-go/service/dynamodb"
+	"github.com/roloum/users/internal/avatar"
+	uaws "github.com/roloum/users/internal/aws"
+	"github.com/roloum/users/internal/user"
+	"github.com/spf13/cobra"
+)
+
+// avatarCmd groups the commands of the avatar images
+var avatarCmd = &cobra.Command{
+	Use:   "avatar",
+	Short: "Manages the avatar images of the users",
+}
+
+// avatarSetCmd processes an image file and makes it the avatar of an user
+var avatarSetCmd = &cobra.Command{
+	Use:   "set",
+	Short: "Sets the avatar of an user from an image file",
+	RunE: func(cmd *cobra.Command, args []string) error {
+
+		email, _ := cmd.Flags().GetString("email")
+		file, _ := cmd.Flags().GetString("file")
+
+		ctx := cmd.Context()
+		log.Info().Msg("Executing the avatar set command")
+
+		cfg, ok := ctx.Value(ContextKey(CONFIG)).(Configuration)
+		if !ok {
+			return fmt.Errorf("Missing configuration")
+		}
+
+		dynamoDB, ok := ctx.Value(ContextKey(DYNAMO)).(*dynamodb.DynamoDB)
+		if !ok {
+			return fmt.Errorf("Missing DynamoDB connection")
+		}
+
+		storage, err := getAvatarStorage(cmd, cfg)
+		if err != nil {
+			return err
+		}
+
+		f, err := os.Open(file)
+		if err != nil {
+			return usageError{err}
+		}
+		defer f.Close()
+
+		u := &user.User{Email: email}
+		if err := u.Load(ctx, dynamoDB, cfg.AWS.DynamoDB.Table.User); err != nil {
+			return err
+		}
+
+		nonce, err := avatar.NewNonce()
+		if err != nil {
+			return err
+		}
+
+		if err := avatar.Set(ctx, storage, dynamoDB,
+			cfg.AWS.DynamoDB.Table.User, u, nonce, f,
+			cfg.Avatar.MaxBytes); err != nil {
+			return err
+		}
+
+		log.Info().Msg("Avatar set")
+		return renderUser(cmd, u)
+	},
+}
+
+// avatarRemoveCmd removes the avatar of an user and deletes its images
+var avatarRemoveCmd = &cobra.Command{
+	Use:   "remove",
+	Short: "Removes the avatar of an user",
+	RunE: func(cmd *cobra.Command, args []string) error {
+
+		email, _ := cmd.Flags().GetString("email")
+
+		ctx := cmd.Context()
+		log.Info().Msg("Executing the avatar remove command")
+
+		cfg, ok := ctx.Value(ContextKey(CONFIG)).(Configuration)
+		if !ok {
+			return fmt.Errorf("Missing configuration")
+		}
+
+		dynamoDB, ok := ctx.Value(ContextKey(DYNAMO)).(*dynamodb.DynamoDB)
+		if !ok {
+			return fmt.Errorf("Missing DynamoDB connection")
+		}
+
+		storage, err := getAvatarStorage(cmd, cfg)
+		if err != nil {
+			return err
+		}
+
+		u := &user.User{Email: email}
+		if err := u.Load(ctx, dynamoDB, cfg.AWS.DynamoDB.Table.User); err != nil {
+			return err
+		}
+
+		if err := avatar.Remove(ctx, storage, dynamoDB,
+			cfg.AWS.DynamoDB.Table.User, u); err != nil {
+			return err
+		}
+
+		log.Info().Msg("Avatar removed")
+		return nil
+	},
+}
+
+// getAvatarStorage returns the storage of the configuration, or the directory
+// of --dir
+func getAvatarStorage(cmd *cobra.Command, cfg Configuration) (avatar.Storage,
+	error) {
+
+	if dir, _ := cmd.Flags().GetString("dir"); dir != "" {
+		cfg.Avatar.Storage = avatar.StorageFS
+		cfg.Avatar.Dir = dir
+	}
+
+	if cfg.Avatar.Storage == avatar.StorageFS {
+		return avatar.New(cfg.Avatar, nil)
+	}
+
+	sess, err := uaws.GetSession(cfg.AWS.Region)
+	if err != nil {
+		return nil, err
+	}
+
+	return avatar.New(cfg.Avatar,
+		uaws.GetS3WithEndpoint(sess, cfg.Avatar.Endpoint))
+}
+
+// purgeAvatar deletes the avatar images of an user about to be deleted. The
+// storage is only needed when the user has an avatar
+func purgeAvatar(cmd *cobra.Command, cfg Configuration,
+	dynamoDB *dynamodb.DynamoDB, u *user.User) error {
+
+	ctx := cmd.Context()
+
+	if err := u.Load(ctx, dynamoDB, cfg.AWS.DynamoDB.Table.User); err != nil {
+		if err.Error() == user.ErrorUserDoesNotExist {
+			return nil
+		}
+		return err
+	}
+
+	if len(u.Avatar) == 0 {
+		return nil
+	}
+
+	storage, err := getAvatarStorage(cmd, cfg)
+	if err != nil {
+		return err
+	}
+
+	return avatar.Purge(ctx, storage, dynamoDB, cfg.AWS.DynamoDB.Table.User, u)
+}
+
+func init() {
+	RootCmd.AddCommand(avatarCmd)
+	avatarCmd.AddCommand(avatarSetCmd)
+	avatarCmd.AddCommand(avatarRemoveCmd)
+
+	var dir string
+	avatarCmd.PersistentFlags().StringVar(&dir, "dir", "",
+		"Keeps the images in this directory instead of the configured storage")
+
+	var email, file string
+	avatarSetCmd.Flags().StringVarP(&email, "email", "e", "", "Email (required)")
+	avatarSetCmd.MarkFlagRequired("email")
+	avatarSetCmd.Flags().StringVarP(&file, "file", "f", "",
+		"JPEG, PNG or GIF image (required)")
+	avatarSetCmd.MarkFlagRequired("file")
+
+	avatarRemoveCmd.Flags().StringVarP(&email, "email", "e", "",
+		"Email (required)")
+	avatarRemoveCmd.MarkFlagRequired("email")
+}
@@ -0,0 +1,120 @@
+package cmd
+
+import (
+	"fmt"
+	"io"
+	"os"
+
+	"github.com/rs/zerolog/log"
+
+	"github.com/aws/aws-sdk-go/service/dynamodb"
+	"github.com/roloum/users/internal/backup"
+	"github.com/spf13/cobra"
+)
+
+// exportCmd dumps every item of the table to JSON Lines
+var exportCmd = &cobra.Command{
+	Use:   "export",
+	Short: "Exports every item of the table to a JSON Lines file",
+	RunE: func(cmd *cobra.Command, args []string) error {
+
+		file, _ := cmd.Flags().GetString("file")
+		segments, _ := cmd.Flags().GetInt("segments")
+
+		ctx := cmd.Context()
+		log.Info().Msg("Executing the export command")
+
+		cfg, ok := ctx.Value(ContextKey(CONFIG)).(Configuration)
+		if !ok {
+			return fmt.Errorf("Missing configuration")
+		}
+
+		dynamoDB, ok := ctx.Value(ContextKey(DYNAMO)).(*dynamodb.DynamoDB)
+		if !ok {
+			return fmt.Errorf("Missing DynamoDB connection")
+		}
+
+		var out io.Writer = cmd.OutOrStdout()
+		if file != "" {
+			f, err := os.Create(file)
+			if err != nil {
+				return err
+			}
+			defer f.Close()
+			out = f
+		}
+
+		count, err := backup.Export(ctx, dynamoDB, cfg.AWS.DynamoDB.Table.User,
+			segments, out)
+		if err != nil {
+			return err
+		}
+
+		log.Info().Msgf("Table exported, items: %d", count)
+
+		return nil
+	},
+}
+
+// restoreCmd replays a file written by the export command
+var restoreCmd = &cobra.Command{
+	Use:   "restore",
+	Short: "Restores the items of a file written by the export command",
+	RunE: func(cmd *cobra.Command, args []string) error {
+
+		file, _ := cmd.Flags().GetString("file")
+		policy, _ := cmd.Flags().GetString("policy")
+		concurrency, _ := cmd.Flags().GetInt("concurrency")
+
+		ctx := cmd.Context()
+		log.Info().Msg("Executing the restore command")
+
+		cfg, ok := ctx.Value(ContextKey(CONFIG)).(Configuration)
+		if !ok {
+			return fmt.Errorf("Missing configuration")
+		}
+
+		dynamoDB, ok := ctx.Value(ContextKey(DYNAMO)).(*dynamodb.DynamoDB)
+		if !ok {
+			return fmt.Errorf("Missing DynamoDB connection")
+		}
+
+		f, err := os.Open(file)
+		if err != nil {
+			return err
+		}
+		defer f.Close()
+
+		report, err := backup.Restore(ctx, dynamoDB,
+			cfg.AWS.DynamoDB.Table.User, f, policy, concurrency)
+		if err != nil {
+			return err
+		}
+
+		log.Info().Msgf("Table restored: %+v", *report)
+
+		if report.Failed > 0 {
+			return fmt.Errorf("%d items could not be restored", report.Failed)
+		}
+
+		return nil
+	},
+}
+
+func init() {
+	RootCmd.AddCommand(exportCmd)
+	RootCmd.AddCommand(restoreCmd)
+
+	var file, policy string
+	var segments, concurrency int
+	exportCmd.Flags().StringVarP(&file, "file", "f", "", "Output file, stdout by default")
+	exportCmd.Flags().IntVarP(&segments, "segments", "s", backup.DefaultSegments,
+		"Number of parallel scan segments")
+
+	restoreCmd.Flags().StringVarP(&file, "file", "f", "", "JSON Lines file (required)")
+	restoreCmd.MarkFlagRequired("file")
+	restoreCmd.Flags().StringVarP(&policy, "policy", "p", backup.PolicySkip,
+		"What to do with items that already exist: skip or overwrite")
+	restoreCmd.Flags().IntVarP(&concurrency, "concurrency", "c",
+		backup.DefaultConcurrency, "Number of items written at the same time")
+}
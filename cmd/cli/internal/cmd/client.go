@@ -0,0 +1,150 @@
+package cmd
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/rs/zerolog/log"
+
+	"github.com/aws/aws-sdk-go/service/dynamodb"
+	"github.com/roloum/users/internal/idp"
+	"github.com/spf13/cobra"
+)
+
+// clientCmd groups the OAuth client commands
+var clientCmd = &cobra.Command{
+	Use:   "client",
+	Short: "Manages the OAuth clients that sign in users with this service",
+}
+
+// clientAddCmd registers a client
+var clientAddCmd = &cobra.Command{
+	Use:   "add",
+	Short: "Registers a client",
+	RunE: func(cmd *cobra.Command, args []string) error {
+
+		name, _ := cmd.Flags().GetString("name")
+		redirectURIs, _ := cmd.Flags().GetStringSlice("redirect-uri")
+
+		ctx := cmd.Context()
+		log.Info().Msg("Executing the client add command")
+
+		cfg, ok := ctx.Value(ContextKey(CONFIG)).(Configuration)
+		if !ok {
+			return fmt.Errorf("Missing configuration")
+		}
+
+		dynamoDB, ok := ctx.Value(ContextKey(DYNAMO)).(*dynamodb.DynamoDB)
+		if !ok {
+			return fmt.Errorf("Missing DynamoDB connection")
+		}
+
+		c, secret, err := idp.CreateClient(ctx, dynamoDB,
+			cfg.AWS.DynamoDB.Table.User, name, redirectURIs)
+		if err != nil {
+			return err
+		}
+
+		log.Info().Msg("Client created")
+
+		return renderClientSecret(cmd, c, secret)
+	},
+}
+
+// clientListCmd lists the registered clients
+var clientListCmd = &cobra.Command{
+	Use:   "list",
+	Short: "Lists the registered clients",
+	RunE: func(cmd *cobra.Command, args []string) error {
+
+		ctx := cmd.Context()
+		log.Info().Msg("Executing the client list command")
+
+		cfg, ok := ctx.Value(ContextKey(CONFIG)).(Configuration)
+		if !ok {
+			return fmt.Errorf("Missing configuration")
+		}
+
+		dynamoDB, ok := ctx.Value(ContextKey(DYNAMO)).(*dynamodb.DynamoDB)
+		if !ok {
+			return fmt.Errorf("Missing DynamoDB connection")
+		}
+
+		clients, err := idp.ListClients(ctx, dynamoDB, cfg.AWS.DynamoDB.Table.User)
+		if err != nil {
+			return err
+		}
+
+		rows := make([][]string, len(clients))
+		for i, c := range clients {
+			rows[i] = []string{c.ID, c.Name, c.Created,
+				strings.Join(c.RedirectURIs, ",")}
+		}
+
+		return render(cmd, clients, []string{"CLIENT ID", "NAME", "CREATED",
+			"REDIRECT URIS"}, rows)
+	},
+}
+
+// clientRotateSecretCmd replaces the secret of a client
+var clientRotateSecretCmd = &cobra.Command{
+	Use:   "rotate-secret",
+	Short: "Replaces the secret of a client",
+	RunE: func(cmd *cobra.Command, args []string) error {
+
+		id, _ := cmd.Flags().GetString("id")
+
+		ctx := cmd.Context()
+		log.Info().Msg("Executing the client rotate-secret command")
+
+		cfg, ok := ctx.Value(ContextKey(CONFIG)).(Configuration)
+		if !ok {
+			return fmt.Errorf("Missing configuration")
+		}
+
+		dynamoDB, ok := ctx.Value(ContextKey(DYNAMO)).(*dynamodb.DynamoDB)
+		if !ok {
+			return fmt.Errorf("Missing DynamoDB connection")
+		}
+
+		c := &idp.Client{ID: id}
+
+		secret, err := c.RotateSecret(ctx, dynamoDB, cfg.AWS.DynamoDB.Table.User)
+		if err != nil {
+			return err
+		}
+
+		log.Info().Msg("Client secret rotated")
+
+		return renderClientSecret(cmd, c, secret)
+	},
+}
+
+//renderClientSecret writes the credentials of a client, the secret is only
+//shown once
+func renderClientSecret(cmd *cobra.Command, c *idp.Client,
+	secret string) error {
+	return render(cmd, struct {
+		ClientID     string `json:"client_id"`
+		ClientSecret string `json:"client_secret"`
+	}{c.ID, secret}, []string{"CLIENT ID", "CLIENT SECRET"},
+		[][]string{{c.ID, secret}})
+}
+
+func init() {
+	RootCmd.AddCommand(clientCmd)
+	clientCmd.AddCommand(clientAddCmd)
+	clientCmd.AddCommand(clientListCmd)
+	clientCmd.AddCommand(clientRotateSecretCmd)
+
+	var name, id string
+	var redirectURIs []string
+	clientAddCmd.Flags().StringVarP(&name, "name", "n", "", "Name (required)")
+	clientAddCmd.MarkFlagRequired("name")
+	clientAddCmd.Flags().StringSliceVarP(&redirectURIs, "redirect-uri", "r", nil,
+		"Redirect URI, can be repeated (required)")
+	clientAddCmd.MarkFlagRequired("redirect-uri")
+
+	clientRotateSecretCmd.Flags().StringVarP(&id, "id", "i", "", "Client ID (required)")
+	clientRotateSecretCmd.MarkFlagRequired("id")
+}
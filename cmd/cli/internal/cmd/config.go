@@ -0,0 +1,137 @@
+package cmd
+
+import (
+	"fmt"
+
+	"github.com/rs/zerolog/log"
+
+	"github.com/roloum/users/cmd/cli/internal/profile"
+	"github.com/spf13/cobra"
+)
+
+// configCmd groups the commands that manage the configuration file
+var configCmd = &cobra.Command{
+	Use:   "config",
+	Short: "Manages the profiles of the configuration file",
+	//The configuration file is edited before it has a region and a table
+	PersistentPreRunE: func(cmd *cobra.Command, args []string) error {
+		return nil
+	},
+}
+
+// configViewCmd prints the profiles
+var configViewCmd = &cobra.Command{
+	Use:   "view",
+	Short: "Prints the profiles of the configuration file",
+	RunE: func(cmd *cobra.Command, args []string) error {
+
+		log.Info().Msg("Executing the config view command")
+
+		path, err := profile.Path()
+		if err != nil {
+			return err
+		}
+
+		file, err := profile.Load(path)
+		if err != nil {
+			return err
+		}
+
+		current := file.Selected("")
+
+		var rows [][]string
+		for _, name := range file.Names() {
+			p := file.Profiles[name]
+
+			mark := ""
+			if name == current {
+				mark = "*"
+			}
+
+			rows = append(rows, []string{mark, name, p.Region, p.Table,
+				p.EndpointURL, p.LogLevel})
+		}
+
+		log.Debug().Msgf("Configuration file: %s", path)
+
+		return render(cmd, file, []string{"CURRENT", "NAME", "REGION", "TABLE",
+			"ENDPOINT URL", "LOG LEVEL"}, rows)
+	},
+}
+
+// configSetCmd sets a key of a profile
+var configSetCmd = &cobra.Command{
+	Use:   "set KEY VALUE",
+	Short: fmt.Sprintf("Sets a key of the profile: %v", profile.Keys),
+	Args:  cobra.ExactArgs(2),
+	RunE: func(cmd *cobra.Command, args []string) error {
+
+		name, _ := cmd.Flags().GetString("profile")
+
+		log.Info().Msg("Executing the config set command")
+
+		path, err := profile.Path()
+		if err != nil {
+			return err
+		}
+
+		file, err := profile.Load(path)
+		if err != nil {
+			return err
+		}
+
+		name = file.Selected(name)
+
+		if err := file.Set(name, args[0], args[1]); err != nil {
+			return usageError{err}
+		}
+
+		if err := file.Save(path); err != nil {
+			return err
+		}
+
+		log.Info().Msgf("Profile %s: %s set", name, args[0])
+
+		return nil
+	},
+}
+
+// configUseProfileCmd selects the current profile
+var configUseProfileCmd = &cobra.Command{
+	Use:   "use-profile NAME",
+	Short: "Makes the profile the current one",
+	Args:  cobra.ExactArgs(1),
+	RunE: func(cmd *cobra.Command, args []string) error {
+
+		log.Info().Msg("Executing the config use-profile command")
+
+		path, err := profile.Path()
+		if err != nil {
+			return err
+		}
+
+		file, err := profile.Load(path)
+		if err != nil {
+			return err
+		}
+
+		if err := file.Use(args[0]); err != nil {
+			return err
+		}
+
+		if err := file.Save(path); err != nil {
+			return err
+		}
+
+		log.Info().Msgf("Current profile: %s", args[0])
+
+		return nil
+	},
+}
+
+func init() {
+	RootCmd.AddCommand(configCmd)
+	configCmd.AddCommand(configViewCmd)
+	configCmd.AddCommand(configSetCmd)
+	configCmd.AddCommand(configUseProfileCmd)
+}
@@ -0,0 +1,88 @@
+package cmd
+
+import (
+	"errors"
+	"net/http"
+	"strings"
+
+	"github.com/aws/aws-sdk-go/aws/awserr"
+	"github.com/roloum/users/internal/apperr"
+	"github.com/roloum/users/internal/user"
+)
+
+//Exit codes returned by the CLI, so scripts can branch on them
+const (
+	//ExitOK the command succeeded
+	ExitOK = 0
+
+	//ExitError any error not listed below
+	ExitError = 1
+
+	//ExitUsage wrong flags or arguments
+	ExitUsage = 2
+
+	//ExitNotFound the user or item does not exist
+	ExitNotFound = 3
+
+	//ExitConflict the user already exists or is already in the requested state
+	ExitConflict = 4
+
+	//ExitInvalid the input did not pass validation
+	ExitInvalid = 5
+
+	//ExitUnavailable AWS could not be reached or rejected the request
+	ExitUnavailable = 6
+)
+
+//usageError wraps errors caused by wrong flags or arguments
+type usageError struct {
+	error
+}
+
+//exitCodes maps the HTTP status of the errors, registered in apperr by each
+//package, to exit codes
+var exitCodes = map[int]int{
+	http.StatusNotFound:              ExitNotFound,
+	http.StatusConflict:              ExitConflict,
+	http.StatusBadRequest:            ExitInvalid,
+	http.StatusUnprocessableEntity:   ExitInvalid,
+	http.StatusRequestEntityTooLarge: ExitInvalid,
+	http.StatusServiceUnavailable:    ExitUnavailable,
+	http.StatusGatewayTimeout:        ExitUnavailable,
+}
+
+//ExitCode returns the exit code for the error returned by a command
+func ExitCode(err error) int {
+	if err == nil {
+		return ExitOK
+	}
+
+	if _, ok := err.(usageError); ok {
+		return ExitUsage
+	}
+
+	if err.Error() == user.ErrorUserTableNameIsEmpty {
+		return ExitUsage
+	}
+
+	//Every AWS error, not only the retryable ones
+	var aerr awserr.Error
+	if errors.As(err, &aerr) {
+		return ExitUnavailable
+	}
+
+	if code, ok := exitCodes[apperr.From(err).Status]; ok {
+		return code
+	}
+
+	//Errors returned by cobra itself
+	msg := err.Error()
+	if strings.HasPrefix(msg, "required flag") ||
+		strings.HasPrefix(msg, "unknown command") ||
+		strings.HasPrefix(msg, "accepts ") ||
+		strings.HasPrefix(msg, "requires at least") {
+		return ExitUsage
+	}
+
+	return ExitError
+}
@@ -0,0 +1,148 @@
+package cmd
+
+import (
+	"fmt"
+	"io"
+	"os"
+
+	"github.com/rs/zerolog/log"
+
+	"github.com/aws/aws-sdk-go/service/dynamodb"
+	"github.com/roloum/users/internal/user"
+	"github.com/spf13/cobra"
+)
+
+// gdprCmd groups the data subject request commands
+var gdprCmd = &cobra.Command{
+	Use:   "gdpr",
+	Short: "Handles data subject access and erasure requests",
+}
+
+// gdprExportCmd writes everything stored about an user as JSON
+var gdprExportCmd = &cobra.Command{
+	Use:   "export",
+	Short: "Exports everything stored about an user",
+	RunE: func(cmd *cobra.Command, args []string) error {
+
+		email, _ := cmd.Flags().GetString("email")
+		file, _ := cmd.Flags().GetString("file")
+
+		ctx := cmd.Context()
+		log.Info().Msg("Executing the gdpr export command")
+
+		cfg, ok := ctx.Value(ContextKey(CONFIG)).(Configuration)
+		if !ok {
+			return fmt.Errorf("Missing configuration")
+		}
+
+		dynamoDB, ok := ctx.Value(ContextKey(DYNAMO)).(*dynamodb.DynamoDB)
+		if !ok {
+			return fmt.Errorf("Missing DynamoDB connection")
+		}
+
+		u := &user.User{
+			Email: email,
+		}
+
+		archive, err := u.Export(ctx, dynamoDB, cfg.AWS.DynamoDB.Table.User)
+		if err != nil {
+			return err
+		}
+
+		var out io.Writer = cmd.OutOrStdout()
+		if file != "" {
+			f, err := os.Create(file)
+			if err != nil {
+				return err
+			}
+			defer f.Close()
+			out = f
+		}
+
+		//The table and csv formats list the items by key, with the rest of
+		//the attributes as name=value
+		rows := make([][]string, len(archive.Items))
+		for i, item := range archive.Items {
+			attrs := map[string]interface{}{}
+			for name, v := range item {
+				if name != "pk" && name != "sk" {
+					attrs[name] = v
+				}
+			}
+			rows[i] = []string{fmt.Sprint(item["pk"]), fmt.Sprint(item["sk"]),
+				attributesColumn(attrs)}
+		}
+
+		if err := renderTo(cmd, out, archive, []string{"PK", "SK", "ATTRIBUTES"},
+			rows); err != nil {
+			return err
+		}
+
+		log.Info().Msgf("User exported, items: %d", len(archive.Items))
+
+		return nil
+	},
+}
+
+// gdprEraseCmd deletes everything stored about an user
+var gdprEraseCmd = &cobra.Command{
+	Use:   "erase",
+	Short: "Erases everything stored about an user and records a tombstone",
+	RunE: func(cmd *cobra.Command, args []string) error {
+
+		email, _ := cmd.Flags().GetString("email")
+		reason, _ := cmd.Flags().GetString("reason")
+
+		ctx := cmd.Context()
+		log.Info().Msg("Executing the gdpr erase command")
+
+		cfg, ok := ctx.Value(ContextKey(CONFIG)).(Configuration)
+		if !ok {
+			return fmt.Errorf("Missing configuration")
+		}
+
+		dynamoDB, ok := ctx.Value(ContextKey(DYNAMO)).(*dynamodb.DynamoDB)
+		if !ok {
+			return fmt.Errorf("Missing DynamoDB connection")
+		}
+
+		u := &user.User{
+			Email: email,
+		}
+
+		if err := purgeAvatar(cmd, cfg, dynamoDB, u); err != nil {
+			return err
+		}
+
+		if err := u.Erase(ctx, dynamoDB, cfg.AWS.DynamoDB.Table.User,
+			reason); err != nil {
+			log.Error().Msg(err.Error())
+			return err
+		}
+
+		hash := user.HashEmail(email)
+		log.Info().Msgf("User erased, tombstone: %s", hash)
+
+		return render(cmd, struct {
+			EmailHash string `json:"emailHash"`
+			Reason    string `json:"reason"`
+		}{hash, reason}, []string{"TOMBSTONE", "REASON"},
+			[][]string{{hash, reason}})
+	},
+}
+
+func init() {
+	RootCmd.AddCommand(gdprCmd)
+	gdprCmd.AddCommand(gdprExportCmd)
+	gdprCmd.AddCommand(gdprEraseCmd)
+
+	var email, file, reason string
+	gdprExportCmd.Flags().StringVarP(&email, "email", "e", "", "Email (required)")
+	gdprExportCmd.MarkFlagRequired("email")
+	gdprExportCmd.Flags().StringVarP(&file, "file", "f", "", "Output file in the --output format, stdout by default")
+
+	gdprEraseCmd.Flags().StringVarP(&email, "email", "e", "", "Email (required)")
+	gdprEraseCmd.MarkFlagRequired("email")
+	gdprEraseCmd.Flags().StringVarP(&reason, "reason", "r", "SubjectRequest",
+		"Reason recorded in the tombstone")
+}
@@ -0,0 +1,113 @@
+package cmd
+
+import (
+	"fmt"
+
+	"github.com/rs/zerolog/log"
+
+	"github.com/aws/aws-sdk-go/service/dynamodb"
+	"github.com/roloum/users/internal/user"
+	"github.com/spf13/cobra"
+)
+
+// getCmd prints an user
+var getCmd = &cobra.Command{
+	Use:   "get",
+	Short: "Prints an user",
+	Long: `Prints an user. With --deliveries it prints the email delivery history
+reported by SES instead, the latest first, after the delivery status`,
+	RunE: func(cmd *cobra.Command, args []string) error {
+
+		email, _ := cmd.Flags().GetString("email")
+		deliveries, _ := cmd.Flags().GetBool("deliveries")
+
+		ctx := cmd.Context()
+		log.Info().Msg("Executing the get command")
+
+		cfg, ok := ctx.Value(ContextKey(CONFIG)).(Configuration)
+		if !ok {
+			return fmt.Errorf("Missing configuration")
+		}
+
+		dynamoDB, ok := ctx.Value(ContextKey(DYNAMO)).(*dynamodb.DynamoDB)
+		if !ok {
+			return fmt.Errorf("Missing DynamoDB connection")
+		}
+
+		u := &user.User{
+			Email: email,
+		}
+
+		if err := u.Load(ctx, dynamoDB, cfg.AWS.DynamoDB.Table.User); err != nil {
+			return err
+		}
+
+		if deliveries {
+			return renderDeliveries(cmd, u, dynamoDB,
+				cfg.AWS.DynamoDB.Table.User)
+		}
+
+		return renderUser(cmd, u)
+	},
+}
+
+//renderDeliveries writes the delivery history of the user. The status of the
+//profile is part of the json and yaml documents, and is written to stderr for
+//the other formats
+func renderDeliveries(cmd *cobra.Command, u *user.User,
+	dynamoDB *dynamodb.DynamoDB, tableName string) error {
+
+	deliveries, err := u.Deliveries(cmd.Context(), dynamoDB, tableName)
+	if err != nil {
+		return err
+	}
+
+	rows := make([][]string, len(deliveries))
+	for i, d := range deliveries {
+		detail := d.BounceType
+		if d.ComplaintType != "" {
+			detail = d.ComplaintType
+		}
+		if d.BounceSubType != "" {
+			detail += "/" + d.BounceSubType
+		}
+		rows[i] = []string{d.Timestamp, d.Type, detail, d.Diagnostic,
+			d.MessageID}
+	}
+
+	if err := render(cmd, struct {
+		Email           string          `json:"email"`
+		EmailStatus     string          `json:"emailStatus,omitempty"`
+		EmailSuppressed string          `json:"emailSuppressed,omitempty"`
+		Deliveries      []user.Delivery `json:"deliveries"`
+	}{u.Email, u.EmailStatus, u.EmailSuppressed, deliveries},
+		[]string{"TIMESTAMP", "TYPE", "DETAIL", "DIAGNOSTIC", "MESSAGE ID"},
+		rows); err != nil {
+		return err
+	}
+
+	format, _ := cmd.Flags().GetString("output")
+	if format == OutputTable || format == OutputCSV {
+		status := u.EmailStatus
+		if status == "" {
+			status = "unknown"
+		}
+		if u.EmailSuppressed != "" {
+			status += ", suppressed after " + u.EmailSuppressed
+		}
+		fmt.Fprintf(cmd.ErrOrStderr(), "Email status: %s\n", status)
+	}
+
+	return nil
+}
+
+func init() {
+	RootCmd.AddCommand(getCmd)
+
+	var email string
+	var deliveries bool
+	getCmd.Flags().StringVarP(&email, "email", "e", "", "Email (required)")
+	getCmd.MarkFlagRequired("email")
+	getCmd.Flags().BoolVar(&deliveries, "deliveries", false,
+		"Prints the email delivery history")
+}
@@ -0,0 +1,118 @@
+package cmd
+
+import (
+	"fmt"
+	"io"
+	"os"
+	"strconv"
+
+	"github.com/rs/zerolog/log"
+
+	"github.com/aws/aws-sdk-go/service/dynamodb"
+	"github.com/roloum/users/internal/importer"
+	"github.com/spf13/cobra"
+)
+
+// importCmd creates users in bulk from a CSV or JSON Lines file
+var importCmd = &cobra.Command{
+	Use:   "import",
+	Short: "Imports users from a CSV or JSON Lines file",
+	RunE: func(cmd *cobra.Command, args []string) error {
+
+		file, _ := cmd.Flags().GetString("file")
+		format, _ := cmd.Flags().GetString("format")
+		report, _ := cmd.Flags().GetString("report")
+		concurrency, _ := cmd.Flags().GetInt("concurrency")
+		dryRun, _ := cmd.Flags().GetBool("dry-run")
+		activate, _ := cmd.Flags().GetBool("activate")
+		skipEmail, _ := cmd.Flags().GetBool("skip-activation-email")
+
+		ctx := cmd.Context()
+		log.Info().Msg("Executing the import command")
+
+		cfg, ok := ctx.Value(ContextKey(CONFIG)).(Configuration)
+		if !ok {
+			return fmt.Errorf("Missing configuration")
+		}
+
+		dynamoDB, ok := ctx.Value(ContextKey(DYNAMO)).(*dynamodb.DynamoDB)
+		if !ok {
+			return fmt.Errorf("Missing DynamoDB connection")
+		}
+
+		if format == "" {
+			var err error
+			if format, err = importer.Format(file); err != nil {
+				return err
+			}
+		}
+
+		f, err := os.Open(file)
+		if err != nil {
+			return err
+		}
+		defer f.Close()
+
+		rows, err := importer.Read(f, format)
+		if err != nil {
+			return err
+		}
+
+		log.Info().Msgf("Importing %d rows", len(rows))
+
+		results := importer.Run(ctx, dynamoDB, cfg.AWS.DynamoDB.Table.User, rows,
+			importer.Options{
+				Concurrency:         concurrency,
+				Retries:             importer.DefaultRetries,
+				DryRun:              dryRun,
+				Activate:            activate,
+				SkipActivationEmail: skipEmail,
+			})
+
+		var out io.Writer = cmd.OutOrStdout()
+		if report != "" {
+			r, err := os.Create(report)
+			if err != nil {
+				return err
+			}
+			defer r.Close()
+			out = r
+		}
+
+		lines := make([][]string, len(results))
+		for i, res := range results {
+			lines[i] = []string{strconv.Itoa(res.Line), res.Email, res.Status,
+				res.Error}
+		}
+
+		if err := renderTo(cmd, out, results, []string{"LINE", "EMAIL", "STATUS",
+			"ERROR"}, lines); err != nil {
+			return err
+		}
+
+		log.Info().Msgf("Import finished: %v", importer.Summary(results))
+
+		return nil
+	},
+}
+
+func init() {
+	RootCmd.AddCommand(importCmd)
+
+	var file, format, report string
+	var concurrency int
+	var dryRun, activate, skipEmail bool
+	importCmd.Flags().StringVarP(&file, "file", "f", "", "CSV or JSON Lines file (required)")
+	importCmd.MarkFlagRequired("file")
+	importCmd.Flags().StringVar(&format, "format", "",
+		"csv or jsonl, guessed from the file extension by default")
+	importCmd.Flags().StringVarP(&report, "report", "r", "",
+		"Per row report file in the --output format, stdout by default")
+	importCmd.Flags().IntVarP(&concurrency, "concurrency", "c",
+		importer.DefaultConcurrency, "Number of rows written at the same time")
+	importCmd.Flags().BoolVar(&dryRun, "dry-run", false, "Only validate the rows")
+	importCmd.Flags().BoolVar(&activate, "activate", false,
+		"Create the users as active accounts")
+	importCmd.Flags().BoolVar(&skipEmail, "skip-activation-email", false,
+		"Do not send the activation email")
+}
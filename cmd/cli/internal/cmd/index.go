@@ -0,0 +1,186 @@
+package cmd
+
+import (
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/rs/zerolog/log"
+
+	"github.com/aws/aws-sdk-go/service/dynamodb"
+	"github.com/roloum/users/internal/search"
+	"github.com/roloum/users/internal/user"
+	"github.com/spf13/cobra"
+)
+
+// indexCmd groups the commands of the local Bleve search index
+var indexCmd = &cobra.Command{
+	Use:   "index",
+	Short: "Manages the Bleve search index stored on disk",
+}
+
+// indexRebuildCmd rebuilds the index from a scan of the table
+var indexRebuildCmd = &cobra.Command{
+	Use:   "rebuild",
+	Short: "Rebuilds the search index from a scan of the table",
+	RunE: func(cmd *cobra.Command, args []string) error {
+
+		ctx := cmd.Context()
+		log.Info().Msg("Executing the index rebuild command")
+
+		cfg, ok := ctx.Value(ContextKey(CONFIG)).(Configuration)
+		if !ok {
+			return fmt.Errorf("Missing configuration")
+		}
+
+		dynamoDB, ok := ctx.Value(ContextKey(DYNAMO)).(*dynamodb.DynamoDB)
+		if !ok {
+			return fmt.Errorf("Missing DynamoDB connection")
+		}
+
+		path, err := indexPath(cmd, cfg)
+		if err != nil {
+			return err
+		}
+
+		count, err := search.Rebuild(ctx, dynamoDB,
+			cfg.AWS.DynamoDB.Table.User, path)
+		if err != nil {
+			return err
+		}
+
+		fmt.Fprintf(cmd.ErrOrStderr(), "Indexed %d users into %s\n", count, path)
+		return nil
+	},
+}
+
+// indexQueryCmd queries the index, it does not need the table
+var indexQueryCmd = &cobra.Command{
+	Use:   "query [TEXT]",
+	Short: "Queries the search index with fuzzy names and facets",
+	PersistentPreRunE: func(cmd *cobra.Command, args []string) error {
+		return nil
+	},
+	RunE: func(cmd *cobra.Command, args []string) error {
+
+		fuzziness, _ := cmd.Flags().GetInt("fuzziness")
+		domain, _ := cmd.Flags().GetString("domain")
+		month, _ := cmd.Flags().GetString("created-month")
+		limit, _ := cmd.Flags().GetInt("limit")
+		offset, _ := cmd.Flags().GetInt("offset")
+
+		ctx := cmd.Context()
+		log.Info().Msg("Executing the index query command")
+
+		cfg, ok := ctx.Value(ContextKey(CONFIG)).(Configuration)
+		if !ok {
+			return fmt.Errorf("Missing configuration")
+		}
+
+		path, err := indexPath(cmd, cfg)
+		if err != nil {
+			return err
+		}
+
+		q := search.Query{
+			Text:         strings.Join(args, " "),
+			Fuzziness:    fuzziness,
+			Domain:       domain,
+			CreatedMonth: month,
+			Limit:        limit,
+			Offset:       offset,
+		}
+		if cmd.Flags().Changed("active") {
+			active, _ := cmd.Flags().GetBool("active")
+			q.Active = &active
+		}
+
+		ix, err := search.OpenIndexReadOnly(path)
+		if err != nil {
+			return err
+		}
+		defer ix.Close()
+
+		res, err := ix.Query(ctx, q)
+		if err != nil {
+			return err
+		}
+
+		rows := make([][]string, len(res.Users))
+		for i, u := range res.Users {
+			rows[i] = userRow(u)
+		}
+
+		if err := render(cmd, res, userHeader, rows); err != nil {
+			return err
+		}
+
+		format, _ := cmd.Flags().GetString("output")
+		if format == OutputTable || format == OutputCSV {
+			renderFacets(cmd, res)
+		}
+
+		return nil
+	},
+}
+
+//indexPath returns the --path flag, USERS_SEARCH_INDEX_PATH by default
+func indexPath(cmd *cobra.Command, cfg Configuration) (string, error) {
+
+	path, _ := cmd.Flags().GetString("path")
+	if path == "" {
+		path = cfg.Search.Index.Path
+	}
+	if path == "" {
+		return "", usageError{fmt.Errorf(
+			"Missing index path, set --path or USERS_SEARCH_INDEX_PATH")}
+	}
+
+	return path, nil
+}
+
+//renderFacets writes the total and the facets to stderr, after the users
+func renderFacets(cmd *cobra.Command, res *search.Result) {
+
+	out := cmd.ErrOrStderr()
+	fmt.Fprintf(out, "Total: %d\n", res.Total)
+
+	names := make([]string, 0, len(res.Facets))
+	for name := range res.Facets {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	for _, name := range names {
+		terms := make([]string, len(res.Facets[name]))
+		for i, f := range res.Facets[name] {
+			terms[i] = f.Term + "=" + strconv.Itoa(f.Count)
+		}
+		fmt.Fprintf(out, "%s: %s\n", name, strings.Join(terms, " "))
+	}
+}
+
+func init() {
+	RootCmd.AddCommand(indexCmd)
+	indexCmd.AddCommand(indexRebuildCmd)
+	indexCmd.AddCommand(indexQueryCmd)
+
+	var path string
+	indexCmd.PersistentFlags().StringVar(&path, "path", "",
+		"Directory of the index, USERS_SEARCH_INDEX_PATH by default")
+
+	var fuzziness, limit, offset int
+	var domain, month string
+	var active bool
+	indexQueryCmd.Flags().IntVarP(&fuzziness, "fuzziness", "f",
+		search.DefaultFuzziness, "Typos allowed in each name term, up to 2")
+	indexQueryCmd.Flags().BoolVar(&active, "active", false,
+		"Only active users, --active=false for inactive ones")
+	indexQueryCmd.Flags().StringVarP(&domain, "domain", "d", "", "Email domain")
+	indexQueryCmd.Flags().StringVar(&month, "created-month", "",
+		"Created in the month, YYYY-MM")
+	indexQueryCmd.Flags().IntVarP(&limit, "limit", "l", user.SearchDefaultLimit,
+		"Maximum number of users")
+	indexQueryCmd.Flags().IntVar(&offset, "offset", 0, "Users skipped")
+}
@@ -0,0 +1,93 @@
+package cmd
+
+import (
+	"fmt"
+	"time"
+
+	"github.com/rs/zerolog/log"
+
+	"github.com/aws/aws-sdk-go/service/dynamodb"
+	"github.com/roloum/users/internal/user"
+	"github.com/spf13/cobra"
+)
+
+// listCmd prints a page of users
+var listCmd = &cobra.Command{
+	Use:   "list",
+	Short: "Lists users, one page at a time",
+	RunE: func(cmd *cobra.Command, args []string) error {
+
+		limit, _ := cmd.Flags().GetInt64("limit")
+		cursor, _ := cmd.Flags().GetString("cursor")
+		all, _ := cmd.Flags().GetBool("all")
+		domain, _ := cmd.Flags().GetString("domain")
+		from, _ := cmd.Flags().GetString("created-from")
+		to, _ := cmd.Flags().GetString("created-to")
+
+		ctx := cmd.Context()
+		log.Info().Msg("Executing the list command")
+
+		cfg, ok := ctx.Value(ContextKey(CONFIG)).(Configuration)
+		if !ok {
+			return fmt.Errorf("Missing configuration")
+		}
+
+		dynamoDB, ok := ctx.Value(ContextKey(DYNAMO)).(*dynamodb.DynamoDB)
+		if !ok {
+			return fmt.Errorf("Missing DynamoDB connection")
+		}
+
+		filter := user.Filter{
+			Domain:      domain,
+			CreatedFrom: from,
+			CreatedTo:   to,
+		}
+		for _, date := range []string{from, to} {
+			if _, err := time.Parse("2006-01-02", date); date != "" && err != nil {
+				return usageError{fmt.Errorf("Invalid date, expected YYYY-MM-DD: %s",
+					date)}
+			}
+		}
+		if cmd.Flags().Changed("active") {
+			active, _ := cmd.Flags().GetBool("active")
+			filter.Active = &active
+		}
+
+		var users []*user.User
+		for {
+			page, next, err := user.ListFiltered(ctx, dynamoDB,
+				cfg.AWS.DynamoDB.Table.User, filter, limit, cursor)
+			if err != nil {
+				return err
+			}
+
+			users = append(users, page...)
+			cursor = next
+
+			if !all || cursor == "" {
+				break
+			}
+		}
+
+		return renderUsers(cmd, users, cursor)
+	},
+}
+
+func init() {
+	RootCmd.AddCommand(listCmd)
+
+	var limit int64
+	var cursor, domain, from, to string
+	var all, active bool
+	listCmd.Flags().Int64VarP(&limit, "limit", "l", 50, "Users read per page")
+	listCmd.Flags().StringVarP(&cursor, "cursor", "c", "",
+		"Cursor of the page, printed with the previous page")
+	listCmd.Flags().BoolVarP(&all, "all", "a", false, "Read every page")
+	listCmd.Flags().BoolVar(&active, "active", false,
+		"Only active users, --active=false for inactive ones")
+	listCmd.Flags().StringVarP(&domain, "domain", "d", "", "Email domain")
+	listCmd.Flags().StringVar(&from, "created-from", "",
+		"Created on or after, YYYY-MM-DD")
+	listCmd.Flags().StringVar(&to, "created-to", "",
+		"Created on or before, YYYY-MM-DD")
+}
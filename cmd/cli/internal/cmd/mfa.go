@@ -0,0 +1,62 @@
+package cmd
+
+import (
+	"fmt"
+
+	"github.com/rs/zerolog/log"
+
+	"github.com/aws/aws-sdk-go/service/dynamodb"
+	"github.com/roloum/users/internal/user"
+	"github.com/spf13/cobra"
+)
+
+// mfaCmd groups the multi-factor authentication commands
+var mfaCmd = &cobra.Command{
+	Use:   "mfa",
+	Short: "Manages multi-factor authentication",
+}
+
+// mfaResetCmd disables MFA so the user can enroll again
+var mfaResetCmd = &cobra.Command{
+	Use:   "reset",
+	Short: "Disables MFA for an user",
+	RunE: func(cmd *cobra.Command, args []string) error {
+
+		email, _ := cmd.Flags().GetString("email")
+
+		ctx := cmd.Context()
+		log.Info().Msg("Executing the mfa reset command")
+
+		cfg, ok := ctx.Value(ContextKey(CONFIG)).(Configuration)
+		if !ok {
+			return fmt.Errorf("Missing configuration")
+		}
+
+		dynamoDB, ok := ctx.Value(ContextKey(DYNAMO)).(*dynamodb.DynamoDB)
+		if !ok {
+			return fmt.Errorf("Missing DynamoDB connection")
+		}
+
+		u := &user.User{
+			Email: email,
+		}
+
+		if err := u.ResetMFA(ctx, dynamoDB, cfg.AWS.DynamoDB.Table.User); err != nil {
+			log.Error().Msg(err.Error())
+			return err
+		}
+
+		log.Info().Msg("MFA reset")
+
+		return nil
+	},
+}
+
+func init() {
+	RootCmd.AddCommand(mfaCmd)
+	mfaCmd.AddCommand(mfaResetCmd)
+
+	var email string
+	mfaResetCmd.Flags().StringVarP(&email, "email", "e", "", "Email (required)")
+	mfaResetCmd.MarkFlagRequired("email")
+}
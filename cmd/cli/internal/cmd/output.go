@@ -0,0 +1,144 @@
+package cmd
+
+import (
+	"encoding/csv"
+	"encoding/json"
+	"fmt"
+	"io"
+	"sort"
+	"strconv"
+	"strings"
+	"text/tabwriter"
+
+	"github.com/roloum/users/internal/user"
+	"github.com/spf13/cobra"
+	"gopkg.in/yaml.v2"
+)
+
+const (
+	//OutputTable aligned columns for humans
+	OutputTable = "table"
+
+	//OutputJSON indented JSON
+	OutputJSON = "json"
+
+	//OutputYAML YAML with the same keys as the JSON output
+	OutputYAML = "yaml"
+
+	//OutputCSV comma separated values with a header row
+	OutputCSV = "csv"
+)
+
+//userHeader columns used by the table and csv outputs of users
+var userHeader = []string{"ID", "EMAIL", "FIRST NAME", "LAST NAME", "ACTIVE",
+	"CREATED", "ATTRIBUTES"}
+
+//render writes v in the format selected with --output. The table and csv
+//formats use header and rows, the json and yaml formats marshal v
+func render(cmd *cobra.Command, v interface{}, header []string,
+	rows [][]string) error {
+	return renderTo(cmd, cmd.OutOrStdout(), v, header, rows)
+}
+
+//renderTo is render writing to out, for the commands with a file flag
+func renderTo(cmd *cobra.Command, out io.Writer, v interface{},
+	header []string, rows [][]string) error {
+
+	format, _ := cmd.Flags().GetString("output")
+
+	switch format {
+	case OutputTable:
+		return renderTable(out, header, rows)
+	case OutputCSV:
+		w := csv.NewWriter(out)
+		if err := w.Write(header); err != nil {
+			return err
+		}
+		if err := w.WriteAll(rows); err != nil {
+			return err
+		}
+		return w.Error()
+	case OutputJSON:
+		enc := json.NewEncoder(out)
+		enc.SetIndent("", "  ")
+		return enc.Encode(v)
+	case OutputYAML:
+		return renderYAML(out, v)
+	}
+
+	return usageError{fmt.Errorf("Unknown output format: %s", format)}
+}
+
+func renderTable(out io.Writer, header []string, rows [][]string) error {
+	w := tabwriter.NewWriter(out, 0, 4, 2, ' ', 0)
+	fmt.Fprintln(w, strings.Join(header, "\t"))
+	for _, row := range rows {
+		fmt.Fprintln(w, strings.Join(row, "\t"))
+	}
+	return w.Flush()
+}
+
+//renderYAML goes through JSON so the keys are the json tags of v
+func renderYAML(out io.Writer, v interface{}) error {
+	b, err := json.Marshal(v)
+	if err != nil {
+		return err
+	}
+
+	var generic interface{}
+	if err := json.Unmarshal(b, &generic); err != nil {
+		return err
+	}
+
+	b, err = yaml.Marshal(generic)
+	if err != nil {
+		return err
+	}
+
+	_, err = out.Write(b)
+	return err
+}
+
+//renderUser writes a single user
+func renderUser(cmd *cobra.Command, u *user.User) error {
+	return render(cmd, u, userHeader, [][]string{userRow(u)})
+}
+
+//renderUsers writes a page of users. The cursor of the next page is part of
+//the json and yaml documents, and is written to stderr for the other formats
+func renderUsers(cmd *cobra.Command, users []*user.User, next string) error {
+
+	rows := make([][]string, len(users))
+	for i, u := range users {
+		rows[i] = userRow(u)
+	}
+
+	if err := render(cmd, struct {
+		Users []*user.User `json:"users"`
+		Next  string       `json:"next,omitempty"`
+	}{users, next}, userHeader, rows); err != nil {
+		return err
+	}
+
+	format, _ := cmd.Flags().GetString("output")
+	if next != "" && (format == OutputTable || format == OutputCSV) {
+		fmt.Fprintf(cmd.ErrOrStderr(), "Next page: --cursor %s\n", next)
+	}
+
+	return nil
+}
+
+func userRow(u *user.User) []string {
+	return []string{u.ID, u.Email, u.FirstName, u.LastName,
+		strconv.FormatBool(u.Active), u.Created, attributesColumn(u.Attributes)}
+}
+
+//attributesColumn joins the custom attributes as name=value, sorted by name
+func attributesColumn(attrs map[string]interface{}) string {
+	pairs := make([]string, 0, len(attrs))
+	for name, v := range attrs {
+		pairs = append(pairs, fmt.Sprintf("%s=%v", name, v))
+	}
+	sort.Strings(pairs)
+	return strings.Join(pairs, ";")
+}
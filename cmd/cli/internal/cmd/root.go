@@ -1,7 +1,15 @@
 package cmd
 
 import (
+	"fmt"
+	"os"
+
+	"github.com/roloum/users/cmd/cli/internal/profile"
+	"github.com/roloum/users/internal/avatar"
+	"github.com/roloum/users/internal/config"
+	"github.com/roloum/users/internal/user"
 	"github.com/spf13/cobra"
+	"github.com/spf13/pflag"
 )
 
 //ContextKey ...
@@ -17,16 +25,108 @@ const DYNAMO = "dynamo"
 var RootCmd = &cobra.Command{
 	Use:   "users",
 	Short: "A CLI User Manager",
+	PersistentPreRunE: func(cmd *cobra.Command, args []string) error {
+
+		cfg, ok := cmd.Context().Value(ContextKey(CONFIG)).(Configuration)
+		if !ok {
+			return fmt.Errorf("Missing configuration")
+		}
+
+		if cfg.AWS.Region == "" || cfg.AWS.DynamoDB.Table.User == "" {
+			return usageError{fmt.Errorf(
+				"Missing region or table in profile %s, set them with "+
+					"'users config set' or USERS_AWS_REGION and "+
+					"USERS_AWS_DYNAMODB_TABLE_USER", cfg.Profile)}
+		}
+
+		return nil
+	},
+}
+
+func init() {
+	var output, profileName, endpoint string
+	RootCmd.PersistentFlags().StringVarP(&output, "output", "o", OutputTable,
+		"Output format: table, json, yaml or csv")
+	RootCmd.PersistentFlags().StringVar(&profileName, "profile", "",
+		"Profile of the configuration file, USERS_PROFILE or the current one by default")
+	RootCmd.PersistentFlags().StringVar(&endpoint, "endpoint-url", "",
+		"DynamoDB endpoint, e.g. http://localhost:8000 for DynamoDB Local")
+
+	RootCmd.SilenceUsage = true
+	RootCmd.SetFlagErrorFunc(func(cmd *cobra.Command, err error) error {
+		return usageError{err}
+	})
 }
 
 //Configuration stores the configuration for the cli commads
 type Configuration struct {
-	AWS struct {
+	Profile string `ignored:"true"`
+	AWS     struct {
 		DynamoDB struct {
 			Table struct {
-				User string `required:"true"`
+				User string
 			}
+			Endpoint string
+		}
+		Region string
+	}
+	Search struct {
+		Index struct {
+			Path string
 		}
-		Region string `required:"true"`
 	}
+	Validation user.ValidationConfig
+	Avatar     avatar.Config
+	Sweep      user.SweepConfig
+}
+
+//LoadConfiguration resolves the configuration of the command line args.
+//Values come from the flags, then the USERS_* environment variables and then
+//the selected profile of the configuration file
+func LoadConfiguration(args []string) (Configuration, error) {
+
+	var cfg Configuration
+
+	//The flags are parsed by cobra once the context is built, the global ones
+	//are needed before
+	fs := pflag.NewFlagSet("global", pflag.ContinueOnError)
+	fs.ParseErrorsWhitelist.UnknownFlags = true
+	fs.Usage = func() {}
+	fs.AddFlagSet(RootCmd.PersistentFlags())
+	fs.Parse(args)
+
+	path, err := profile.Path()
+	if err != nil {
+		return cfg, err
+	}
+
+	file, err := profile.Load(path)
+	if err != nil {
+		return cfg, err
+	}
+
+	name, _ := fs.GetString("profile")
+	if name == "" {
+		name = os.Getenv("USERS_PROFILE")
+	}
+	cfg.Profile = file.Selected(name)
+
+	p := file.Profiles[cfg.Profile]
+	cfg.AWS.Region = p.Region
+	cfg.AWS.DynamoDB.Table.User = p.Table
+	cfg.AWS.DynamoDB.Endpoint = p.EndpointURL
+
+	if _, ok := os.LookupEnv("USERS_LOG_LEVEL"); !ok && p.LogLevel != "" {
+		config.SetLogLevel(p.LogLevel)
+	}
+
+	if err := config.Load(&cfg); err != nil {
+		return cfg, err
+	}
+
+	if fs.Changed("endpoint-url") {
+		cfg.AWS.DynamoDB.Endpoint, _ = fs.GetString("endpoint-url")
+	}
+
+	return cfg, nil
 }
@@ -0,0 +1,198 @@
+package cmd
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+
+	"github.com/rs/zerolog/log"
+
+	"github.com/aws/aws-sdk-go/service/dynamodb"
+	"github.com/roloum/users/internal/user"
+	"github.com/spf13/cobra"
+)
+
+// schemaHeader columns of the table and csv outputs of the schema
+var schemaHeader = []string{"NAME", "TYPE", "REQUIRED", "PATTERN", "VISIBILITY",
+	"DESCRIPTION"}
+
+// schemaCmd groups the commands of the custom attributes
+var schemaCmd = &cobra.Command{
+	Use:   "schema",
+	Short: "Manages the custom attributes of the profiles",
+}
+
+// schemaListCmd lists the attribute definitions
+var schemaListCmd = &cobra.Command{
+	Use:   "list",
+	Short: "Lists the custom attributes",
+	RunE: func(cmd *cobra.Command, args []string) error {
+
+		ctx := cmd.Context()
+		log.Info().Msg("Executing the schema list command")
+
+		cfg, ok := ctx.Value(ContextKey(CONFIG)).(Configuration)
+		if !ok {
+			return fmt.Errorf("Missing configuration")
+		}
+
+		dynamoDB, ok := ctx.Value(ContextKey(DYNAMO)).(*dynamodb.DynamoDB)
+		if !ok {
+			return fmt.Errorf("Missing DynamoDB connection")
+		}
+
+		schema, err := user.LoadSchema(ctx, dynamoDB, cfg.AWS.DynamoDB.Table.User)
+		if err != nil {
+			return err
+		}
+
+		attributes := make([]*user.Attribute, 0, len(schema))
+		rows := make([][]string, 0, len(schema))
+		for _, name := range schema.Names() {
+			a := schema[name]
+			attributes = append(attributes, a)
+			rows = append(rows, []string{a.Name, a.Type,
+				strconv.FormatBool(a.Required), a.Pattern, a.Visibility,
+				a.Description})
+		}
+
+		return render(cmd, struct {
+			Attributes []*user.Attribute `json:"attributes"`
+		}{attributes}, schemaHeader, rows)
+	},
+}
+
+// schemaSetCmd creates or replaces an attribute definition
+var schemaSetCmd = &cobra.Command{
+	Use:   "set NAME",
+	Short: "Creates or replaces a custom attribute",
+	Args:  cobra.ExactArgs(1),
+	RunE: func(cmd *cobra.Command, args []string) error {
+
+		a := &user.Attribute{Name: args[0]}
+		a.Type, _ = cmd.Flags().GetString("type")
+		a.Required, _ = cmd.Flags().GetBool("required")
+		a.Pattern, _ = cmd.Flags().GetString("pattern")
+		a.Visibility, _ = cmd.Flags().GetString("visibility")
+		a.Description, _ = cmd.Flags().GetString("description")
+
+		ctx := cmd.Context()
+		log.Info().Msg("Executing the schema set command")
+
+		cfg, ok := ctx.Value(ContextKey(CONFIG)).(Configuration)
+		if !ok {
+			return fmt.Errorf("Missing configuration")
+		}
+
+		dynamoDB, ok := ctx.Value(ContextKey(DYNAMO)).(*dynamodb.DynamoDB)
+		if !ok {
+			return fmt.Errorf("Missing DynamoDB connection")
+		}
+
+		if err := user.PutAttribute(ctx, dynamoDB, cfg.AWS.DynamoDB.Table.User,
+			a); err != nil {
+			return err
+		}
+
+		log.Info().Msg("Attribute saved")
+		return nil
+	},
+}
+
+// schemaDeleteCmd deletes an attribute definition
+var schemaDeleteCmd = &cobra.Command{
+	Use:   "delete NAME",
+	Short: "Deletes a custom attribute, the values stored are kept",
+	Args:  cobra.ExactArgs(1),
+	RunE: func(cmd *cobra.Command, args []string) error {
+
+		ctx := cmd.Context()
+		log.Info().Msg("Executing the schema delete command")
+
+		cfg, ok := ctx.Value(ContextKey(CONFIG)).(Configuration)
+		if !ok {
+			return fmt.Errorf("Missing configuration")
+		}
+
+		dynamoDB, ok := ctx.Value(ContextKey(DYNAMO)).(*dynamodb.DynamoDB)
+		if !ok {
+			return fmt.Errorf("Missing DynamoDB connection")
+		}
+
+		if err := user.DeleteAttribute(ctx, dynamoDB,
+			cfg.AWS.DynamoDB.Table.User, args[0]); err != nil {
+			return err
+		}
+
+		log.Info().Msg("Attribute deleted")
+		return nil
+	},
+}
+
+// parseAttributes applies the name=value pairs of --attr to attrs, converting
+// the values to the type in the schema. An empty value removes the attribute
+func parseAttributes(ctx context.Context, dynamoDB *dynamodb.DynamoDB,
+	tableName string, pairs []string,
+	attrs map[string]interface{}) (map[string]interface{}, error) {
+
+	if len(pairs) == 0 {
+		return attrs, nil
+	}
+
+	schema, err := user.LoadSchema(ctx, dynamoDB, tableName)
+	if err != nil {
+		return nil, err
+	}
+
+	if attrs == nil {
+		attrs = map[string]interface{}{}
+	}
+
+	for _, pair := range pairs {
+		kv := strings.SplitN(pair, "=", 2)
+		if len(kv) != 2 {
+			return nil, usageError{fmt.Errorf(
+				"Invalid attribute %s, expected name=value", pair)}
+		}
+
+		if kv[1] == "" {
+			delete(attrs, kv[0])
+			continue
+		}
+
+		a, ok := schema[kv[0]]
+		if !ok {
+			return nil, errors.New(user.ErrorUnknownAttribute)
+		}
+
+		v, err := a.Parse(kv[1])
+		if err != nil {
+			return nil, err
+		}
+		attrs[kv[0]] = v
+	}
+
+	return attrs, nil
+}
+
+func init() {
+	RootCmd.AddCommand(schemaCmd)
+	schemaCmd.AddCommand(schemaListCmd)
+	schemaCmd.AddCommand(schemaSetCmd)
+	schemaCmd.AddCommand(schemaDeleteCmd)
+
+	var attrType, pattern, visibility, description string
+	var required bool
+	schemaSetCmd.Flags().StringVarP(&attrType, "type", "t", user.AttributeString,
+		"Type: string, number or boolean")
+	schemaSetCmd.Flags().BoolVarP(&required, "required", "r", false,
+		"Every profile must have a value")
+	schemaSetCmd.Flags().StringVarP(&pattern, "pattern", "p", "",
+		"Regular expression the string values must match")
+	schemaSetCmd.Flags().StringVarP(&visibility, "visibility", "v",
+		user.VisibilityPrivate, "Who sees the attribute: public, private or admin")
+	schemaSetCmd.Flags().StringVarP(&description, "description", "d", "",
+		"Description")
+}
@@ -0,0 +1,109 @@
+package cmd
+
+import (
+	"fmt"
+
+	"github.com/rs/zerolog/log"
+
+	"github.com/aws/aws-sdk-go/service/dynamodb"
+	"github.com/roloum/users/internal/scim"
+	"github.com/roloum/users/internal/user"
+	"github.com/spf13/cobra"
+)
+
+// scimCmd groups the SCIM provisioning commands
+var scimCmd = &cobra.Command{
+	Use:   "scim",
+	Short: "Manages SCIM provisioning",
+}
+
+// scimTokenCmd creates the bearer token a tenant's identity provider uses
+var scimTokenCmd = &cobra.Command{
+	Use:   "token",
+	Short: "Creates a SCIM bearer token for a tenant",
+	RunE: func(cmd *cobra.Command, args []string) error {
+
+		tenant, _ := cmd.Flags().GetString("tenant")
+
+		ctx := cmd.Context()
+		log.Info().Msg("Executing the scim token command")
+
+		cfg, ok := ctx.Value(ContextKey(CONFIG)).(Configuration)
+		if !ok {
+			return fmt.Errorf("Missing configuration")
+		}
+
+		dynamoDB, ok := ctx.Value(ContextKey(DYNAMO)).(*dynamodb.DynamoDB)
+		if !ok {
+			return fmt.Errorf("Missing DynamoDB connection")
+		}
+
+		token, err := scim.CreateToken(ctx, dynamoDB, cfg.AWS.DynamoDB.Table.User,
+			tenant)
+		if err != nil {
+			return err
+		}
+
+		log.Info().Msg("SCIM token created")
+
+		return render(cmd, struct {
+			Tenant string `json:"tenant"`
+			Token  string `json:"token"`
+		}{tenant, token}, []string{"TENANT", "TOKEN"},
+			[][]string{{tenant, token}})
+	},
+}
+
+// scimAssignCmd makes a tenant the owner of an existing user, so its identity
+// provider can manage it
+var scimAssignCmd = &cobra.Command{
+	Use:   "assign",
+	Short: "Assigns an existing user to a SCIM tenant",
+	RunE: func(cmd *cobra.Command, args []string) error {
+
+		tenant, _ := cmd.Flags().GetString("tenant")
+		email, _ := cmd.Flags().GetString("email")
+
+		ctx := cmd.Context()
+		log.Info().Msg("Executing the scim assign command")
+
+		cfg, ok := ctx.Value(ContextKey(CONFIG)).(Configuration)
+		if !ok {
+			return fmt.Errorf("Missing configuration")
+		}
+
+		dynamoDB, ok := ctx.Value(ContextKey(DYNAMO)).(*dynamodb.DynamoDB)
+		if !ok {
+			return fmt.Errorf("Missing DynamoDB connection")
+		}
+
+		u := &user.User{Email: email}
+		if err := u.AssignTenant(ctx, dynamoDB, cfg.AWS.DynamoDB.Table.User,
+			tenant); err != nil {
+			return err
+		}
+
+		if err := u.Load(ctx, dynamoDB, cfg.AWS.DynamoDB.Table.User); err != nil {
+			return err
+		}
+
+		log.Info().Msg("User assigned")
+
+		return renderUser(cmd, u)
+	},
+}
+
+func init() {
+	RootCmd.AddCommand(scimCmd)
+	scimCmd.AddCommand(scimTokenCmd)
+	scimCmd.AddCommand(scimAssignCmd)
+
+	var tenant, email string
+	scimTokenCmd.Flags().StringVarP(&tenant, "tenant", "t", "", "Tenant (required)")
+	scimTokenCmd.MarkFlagRequired("tenant")
+
+	scimAssignCmd.Flags().StringVarP(&tenant, "tenant", "t", "", "Tenant (required)")
+	scimAssignCmd.Flags().StringVarP(&email, "email", "e", "", "Email (required)")
+	scimAssignCmd.MarkFlagRequired("tenant")
+	scimAssignCmd.MarkFlagRequired("email")
+}
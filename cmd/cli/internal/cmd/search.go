@@ -0,0 +1,52 @@
+package cmd
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/rs/zerolog/log"
+
+	"github.com/aws/aws-sdk-go/service/dynamodb"
+	"github.com/roloum/users/internal/user"
+	"github.com/spf13/cobra"
+)
+
+// searchCmd searches users by name, email prefix or domain
+var searchCmd = &cobra.Command{
+	Use:   "search QUERY",
+	Short: "Searches users by name prefix, email prefix or @domain",
+	Args:  cobra.MinimumNArgs(1),
+	RunE: func(cmd *cobra.Command, args []string) error {
+
+		limit, _ := cmd.Flags().GetInt("limit")
+
+		ctx := cmd.Context()
+		log.Info().Msg("Executing the search command")
+
+		cfg, ok := ctx.Value(ContextKey(CONFIG)).(Configuration)
+		if !ok {
+			return fmt.Errorf("Missing configuration")
+		}
+
+		dynamoDB, ok := ctx.Value(ContextKey(DYNAMO)).(*dynamodb.DynamoDB)
+		if !ok {
+			return fmt.Errorf("Missing DynamoDB connection")
+		}
+
+		users, err := user.Search(ctx, dynamoDB, cfg.AWS.DynamoDB.Table.User,
+			strings.Join(args, " "), limit)
+		if err != nil {
+			return err
+		}
+
+		return renderUsers(cmd, users, "")
+	},
+}
+
+func init() {
+	RootCmd.AddCommand(searchCmd)
+
+	var limit int
+	searchCmd.Flags().IntVarP(&limit, "limit", "l", user.SearchDefaultLimit,
+		"Maximum number of users")
+}
@@ -0,0 +1,110 @@
+package cmd
+
+import (
+	"context"
+	"fmt"
+	"strconv"
+	"time"
+
+	"github.com/rs/zerolog/log"
+
+	"github.com/aws/aws-sdk-go/service/dynamodb"
+	"github.com/roloum/users/internal/avatar"
+	"github.com/roloum/users/internal/user"
+	"github.com/spf13/cobra"
+)
+
+// sweepCmd reminds and deletes the users who never activated the account
+var sweepCmd = &cobra.Command{
+	Use:   "sweep",
+	Short: "Reminds the users who did not activate the account and deletes them after the cutoff",
+	Long: `Runs the sweep of the scheduled sweepUsers function: the users who signed
+up and did not activate the account are reminded on the USERS_SWEEP_REMINDERS
+schedule, by the notify function, and the accounts still inactive after
+USERS_SWEEP_CUTOFF are deleted, freeing the email. With --dry-run the actions
+are only reported`,
+	RunE: func(cmd *cobra.Command, args []string) error {
+
+		ctx := cmd.Context()
+		log.Info().Msg("Executing the sweep command")
+
+		cfg, ok := ctx.Value(ContextKey(CONFIG)).(Configuration)
+		if !ok {
+			return fmt.Errorf("Missing configuration")
+		}
+
+		dynamoDB, ok := ctx.Value(ContextKey(DYNAMO)).(*dynamodb.DynamoDB)
+		if !ok {
+			return fmt.Errorf("Missing DynamoDB connection")
+		}
+
+		sweep := cfg.Sweep
+		if cmd.Flags().Changed("dry-run") {
+			sweep.DryRun, _ = cmd.Flags().GetBool("dry-run")
+		}
+		if cmd.Flags().Changed("reminders") {
+			sweep.Reminders, _ = cmd.Flags().GetDurationSlice("reminders")
+		}
+		if cmd.Flags().Changed("cutoff") {
+			sweep.Cutoff, _ = cmd.Flags().GetDuration("cutoff")
+		}
+
+		if err := sweep.Validate(); err != nil {
+			return usageError{fmt.Errorf(
+				"The reminders must be increasing and before the cutoff: %v, %v",
+				sweep.Reminders, sweep.Cutoff)}
+		}
+
+		//The storage is only needed when a deleted account has an avatar
+		sweep.DeleteAvatar = func(ctx context.Context, urls map[string]string) {
+			storage, err := getAvatarStorage(cmd, cfg)
+			if err != nil {
+				log.Error().Err(err).Msg("Deleting avatar images")
+				return
+			}
+			avatar.DeleteImages(ctx, storage, urls)
+		}
+
+		summary, err := user.Sweep(ctx, dynamoDB, cfg.AWS.DynamoDB.Table.User,
+			sweep, time.Now())
+		if err != nil {
+			return err
+		}
+
+		var rows [][]string
+		for _, a := range summary.Actions {
+			reminder := ""
+			if a.Reminder > 0 {
+				reminder = strconv.Itoa(a.Reminder)
+			}
+			rows = append(rows, []string{a.Action, a.Email, a.ID, a.Created,
+				reminder, a.Deletion, a.Error})
+		}
+
+		if err := render(cmd, summary, []string{"ACTION", "EMAIL", "ID",
+			"CREATED", "REMINDER", "DELETION", "ERROR"}, rows); err != nil {
+			return err
+		}
+
+		if summary.Failed > 0 {
+			return fmt.Errorf("%d of %d sweep actions failed", summary.Failed,
+				len(summary.Actions))
+		}
+
+		return nil
+	},
+}
+
+func init() {
+	RootCmd.AddCommand(sweepCmd)
+
+	var dryRun bool
+	var reminders []time.Duration
+	var cutoff time.Duration
+	sweepCmd.Flags().BoolVar(&dryRun, "dry-run", false,
+		"Reports the actions without reminding or deleting, USERS_SWEEP_DRYRUN by default")
+	sweepCmd.Flags().DurationSliceVar(&reminders, "reminders", nil,
+		"Ages of the account at which each reminder is due, USERS_SWEEP_REMINDERS by default")
+	sweepCmd.Flags().DurationVar(&cutoff, "cutoff", 0,
+		"Age after which an inactive account is deleted, USERS_SWEEP_CUTOFF by default")
+}
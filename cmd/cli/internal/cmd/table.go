@@ -0,0 +1,251 @@
+package cmd
+
+import (
+	"errors"
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/rs/zerolog/log"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/dynamodb"
+	"github.com/roloum/users/internal/table"
+	"github.com/spf13/cobra"
+)
+
+// tableCmd groups the table provisioning commands
+var tableCmd = &cobra.Command{
+	Use:   "table",
+	Short: "Provisions and migrates the users table",
+}
+
+// tableCreateCmd creates the table with every index the code needs
+var tableCreateCmd = &cobra.Command{
+	Use:   "create",
+	Short: "Creates the table with its stream, TTL and indexes",
+	RunE: func(cmd *cobra.Command, args []string) error {
+
+		ctx := cmd.Context()
+		log.Info().Msg("Executing the table create command")
+
+		cfg, ok := ctx.Value(ContextKey(CONFIG)).(Configuration)
+		if !ok {
+			return fmt.Errorf("Missing configuration")
+		}
+
+		dynamoDB, ok := ctx.Value(ContextKey(DYNAMO)).(*dynamodb.DynamoDB)
+		if !ok {
+			return fmt.Errorf("Missing DynamoDB connection")
+		}
+
+		if err := table.Create(ctx, dynamoDB,
+			cfg.AWS.DynamoDB.Table.User); err != nil {
+			return err
+		}
+
+		//A new table needs no data migrations
+		if _, err := table.MarkApplied(ctx, dynamoDB,
+			cfg.AWS.DynamoDB.Table.User, table.Migrations); err != nil {
+			return err
+		}
+
+		log.Info().Msg("Table created")
+		return nil
+	},
+}
+
+// tableDescribeCmd prints the current state of the table
+var tableDescribeCmd = &cobra.Command{
+	Use:   "describe",
+	Short: "Describes the table and the applied migrations",
+	RunE: func(cmd *cobra.Command, args []string) error {
+
+		ctx := cmd.Context()
+		log.Info().Msg("Executing the table describe command")
+
+		cfg, ok := ctx.Value(ContextKey(CONFIG)).(Configuration)
+		if !ok {
+			return fmt.Errorf("Missing configuration")
+		}
+
+		dynamoDB, ok := ctx.Value(ContextKey(DYNAMO)).(*dynamodb.DynamoDB)
+		if !ok {
+			return fmt.Errorf("Missing DynamoDB connection")
+		}
+
+		d, err := table.Describe(ctx, dynamoDB, cfg.AWS.DynamoDB.Table.User)
+		if err != nil {
+			return err
+		}
+
+		applied, err := table.Applied(ctx, dynamoDB, cfg.AWS.DynamoDB.Table.User)
+		if err != nil {
+			return err
+		}
+
+		//The table and csv formats list the table, its indexes and the
+		//applied migrations, by status
+		rows := [][]string{{"table", aws.StringValue(d.Table.TableName),
+			aws.StringValue(d.Table.TableStatus)}}
+		for _, i := range d.Table.GlobalSecondaryIndexes {
+			rows = append(rows, []string{"index", aws.StringValue(i.IndexName),
+				aws.StringValue(i.IndexStatus)})
+		}
+		if d.TTL != nil {
+			rows = append(rows, []string{"ttl",
+				aws.StringValue(d.TTL.AttributeName),
+				aws.StringValue(d.TTL.TimeToLiveStatus)})
+		}
+
+		versions := make([]int, 0, len(applied))
+		for v := range applied {
+			versions = append(versions, v)
+		}
+		sort.Ints(versions)
+		for _, v := range versions {
+			m := applied[v]
+			rows = append(rows, []string{"migration",
+				fmt.Sprintf("%d %s", m.Version, m.Name), "applied " + m.Applied})
+		}
+
+		return render(cmd, struct {
+			*table.Description
+			Migrations map[int]table.AppliedMigration `json:"migrations"`
+		}{d, applied}, []string{"KIND", "NAME", "STATUS"}, rows)
+	},
+}
+
+// tableMigrateCmd adds the missing indexes and runs the pending migrations
+var tableMigrateCmd = &cobra.Command{
+	Use:   "migrate",
+	Short: "Adds the missing indexes and runs the pending data migrations",
+	RunE: func(cmd *cobra.Command, args []string) error {
+
+		dryRun, _ := cmd.Flags().GetBool("dry-run")
+
+		ctx := cmd.Context()
+		log.Info().Msg("Executing the table migrate command")
+
+		cfg, ok := ctx.Value(ContextKey(CONFIG)).(Configuration)
+		if !ok {
+			return fmt.Errorf("Missing configuration")
+		}
+
+		dynamoDB, ok := ctx.Value(ContextKey(DYNAMO)).(*dynamodb.DynamoDB)
+		if !ok {
+			return fmt.Errorf("Missing DynamoDB connection")
+		}
+
+		if !dryRun {
+			created, err := table.Sync(ctx, dynamoDB, cfg.AWS.DynamoDB.Table.User)
+			if err != nil {
+				return err
+			}
+			log.Info().Msgf("Indexes created: %v", created)
+		}
+
+		migrations, err := table.Migrate(ctx, dynamoDB,
+			cfg.AWS.DynamoDB.Table.User, table.Migrations, dryRun)
+
+		status := "applied"
+		if dryRun {
+			status = "pending"
+		}
+
+		type migration struct {
+			Version int    `json:"version"`
+			Name    string `json:"name"`
+			Status  string `json:"status"`
+		}
+
+		list := make([]migration, len(migrations))
+		rows := make([][]string, len(migrations))
+		for i, m := range migrations {
+			list[i] = migration{m.Version, m.Name, status}
+			rows[i] = []string{status, strconv.Itoa(m.Version), m.Name}
+		}
+
+		if rerr := render(cmd, list, []string{"STATUS", "VERSION", "NAME"},
+			rows); rerr != nil && err == nil {
+			err = rerr
+		}
+
+		return err
+	},
+}
+
+// tableCanonicalizeCmd moves the users to the key of their canonical email
+var tableCanonicalizeCmd = &cobra.Command{
+	Use:   "canonicalize",
+	Short: "Reports the users whose key is not their canonical email and the collisions",
+	Long: `Reports the users created before the keys were their canonical email
+(lowercase, IDNA domain and, with USERS_EMAIL_CANONICAL_GMAIL, Gmail rules)
+and the different users sharing a canonical email. With --apply the users
+without collisions are moved to their canonical key. The collisions must be
+resolved by hand, merging or deleting one of the accounts`,
+	RunE: func(cmd *cobra.Command, args []string) error {
+
+		apply, _ := cmd.Flags().GetBool("apply")
+
+		ctx := cmd.Context()
+		log.Info().Msg("Executing the table canonicalize command")
+
+		cfg, ok := ctx.Value(ContextKey(CONFIG)).(Configuration)
+		if !ok {
+			return fmt.Errorf("Missing configuration")
+		}
+
+		dynamoDB, ok := ctx.Value(ContextKey(DYNAMO)).(*dynamodb.DynamoDB)
+		if !ok {
+			return fmt.Errorf("Missing DynamoDB connection")
+		}
+
+		report, err := table.CanonicalizeEmails(ctx, dynamoDB,
+			cfg.AWS.DynamoDB.Table.User, apply)
+		if report != nil {
+			var rows [][]string
+			for _, email := range report.Pending {
+				rows = append(rows, []string{"pending", email, ""})
+			}
+			for _, email := range report.Rekeyed {
+				rows = append(rows, []string{"rekeyed", email, ""})
+			}
+			for _, c := range report.Collisions {
+				rows = append(rows, []string{"collision", c.Canonical,
+					strings.Join(c.Emails, ",")})
+			}
+
+			if rerr := render(cmd, report, []string{"STATUS", "EMAIL",
+				"COLLIDING EMAILS"}, rows); rerr != nil && err == nil {
+				err = rerr
+			}
+		}
+		if err != nil {
+			return err
+		}
+
+		if len(report.Collisions) > 0 {
+			return errors.New(table.ErrorEmailCollisions)
+		}
+
+		return nil
+	},
+}
+
+func init() {
+	RootCmd.AddCommand(tableCmd)
+	tableCmd.AddCommand(tableCreateCmd)
+	tableCmd.AddCommand(tableDescribeCmd)
+	tableCmd.AddCommand(tableMigrateCmd)
+	tableCmd.AddCommand(tableCanonicalizeCmd)
+
+	var dryRun bool
+	tableMigrateCmd.Flags().BoolVar(&dryRun, "dry-run", false,
+		"Only list the pending migrations")
+
+	var apply bool
+	tableCanonicalizeCmd.Flags().BoolVar(&apply, "apply", false,
+		"Move the users without collisions to their canonical key")
+}
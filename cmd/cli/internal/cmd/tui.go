@@ -0,0 +1,48 @@
+package cmd
+
+import (
+	"fmt"
+
+	"github.com/rs/zerolog"
+	"github.com/rs/zerolog/log"
+
+	"github.com/aws/aws-sdk-go/service/dynamodb"
+	tea "github.com/charmbracelet/bubbletea"
+	"github.com/roloum/users/cmd/cli/internal/tui"
+	"github.com/spf13/cobra"
+)
+
+// tuiCmd starts the terminal UI
+var tuiCmd = &cobra.Command{
+	Use:   "tui",
+	Short: "Browses and administers users in a terminal UI",
+	RunE: func(cmd *cobra.Command, args []string) error {
+
+		ctx := cmd.Context()
+		log.Info().Msg("Executing the tui command")
+
+		cfg, ok := ctx.Value(ContextKey(CONFIG)).(Configuration)
+		if !ok {
+			return fmt.Errorf("Missing configuration")
+		}
+
+		dynamoDB, ok := ctx.Value(ContextKey(DYNAMO)).(*dynamodb.DynamoDB)
+		if !ok {
+			return fmt.Errorf("Missing DynamoDB connection")
+		}
+
+		//Log lines would be drawn over the UI
+		logger := log.Logger
+		log.Logger = zerolog.Nop()
+		defer func() {
+			log.Logger = logger
+		}()
+
+		return tea.NewProgram(tui.New(ctx, dynamoDB, cfg.AWS.DynamoDB.Table.User),
+			tea.WithAltScreen()).Start()
+	},
+}
+
+func init() {
+	RootCmd.AddCommand(tuiCmd)
+}
@@ -0,0 +1,202 @@
+package cmd
+
+import (
+	"fmt"
+
+	"github.com/rs/zerolog/log"
+
+	"github.com/aws/aws-sdk-go/service/dynamodb"
+	"github.com/roloum/users/internal/user"
+	"github.com/spf13/cobra"
+)
+
+// updateCmd changes the name of an user
+var updateCmd = &cobra.Command{
+	Use:   "update",
+	Short: "Updates the first and last name and the custom attributes of an user",
+	RunE: func(cmd *cobra.Command, args []string) error {
+
+		email, _ := cmd.Flags().GetString("email")
+		firstName, _ := cmd.Flags().GetString("first-name")
+		lastName, _ := cmd.Flags().GetString("last-name")
+		attrs, _ := cmd.Flags().GetStringArray("attr")
+
+		ctx := cmd.Context()
+		log.Info().Msg("Executing the update command")
+
+		cfg, ok := ctx.Value(ContextKey(CONFIG)).(Configuration)
+		if !ok {
+			return fmt.Errorf("Missing configuration")
+		}
+
+		dynamoDB, ok := ctx.Value(ContextKey(DYNAMO)).(*dynamodb.DynamoDB)
+		if !ok {
+			return fmt.Errorf("Missing DynamoDB connection")
+		}
+
+		u := &user.User{
+			Email: email,
+		}
+
+		err := u.Load(ctx, dynamoDB, cfg.AWS.DynamoDB.Table.User)
+		if err != nil {
+			return err
+		}
+
+		if firstName != "" {
+			u.FirstName = firstName
+		}
+		if lastName != "" {
+			u.LastName = lastName
+		}
+
+		u.Attributes, err = parseAttributes(ctx, dynamoDB,
+			cfg.AWS.DynamoDB.Table.User, attrs, u.Attributes)
+		if err != nil {
+			return err
+		}
+
+		if err := u.Update(ctx, dynamoDB, cfg.AWS.DynamoDB.Table.User); err != nil {
+			return err
+		}
+
+		log.Info().Msg("User updated")
+		return renderUser(cmd, u)
+	},
+}
+
+// deleteCmd deletes an user and every row in its partition
+var deleteCmd = &cobra.Command{
+	Use:   "delete",
+	Short: "Deletes an user along with its tokens, sessions and keys",
+	RunE: func(cmd *cobra.Command, args []string) error {
+
+		email, _ := cmd.Flags().GetString("email")
+
+		ctx := cmd.Context()
+		log.Info().Msg("Executing the delete command")
+
+		cfg, ok := ctx.Value(ContextKey(CONFIG)).(Configuration)
+		if !ok {
+			return fmt.Errorf("Missing configuration")
+		}
+
+		dynamoDB, ok := ctx.Value(ContextKey(DYNAMO)).(*dynamodb.DynamoDB)
+		if !ok {
+			return fmt.Errorf("Missing DynamoDB connection")
+		}
+
+		u := &user.User{
+			Email: email,
+		}
+
+		if err := u.Load(ctx, dynamoDB, cfg.AWS.DynamoDB.Table.User); err != nil {
+			return err
+		}
+
+		if err := purgeAvatar(cmd, cfg, dynamoDB, u); err != nil {
+			return err
+		}
+
+		if err := u.Delete(ctx, dynamoDB, cfg.AWS.DynamoDB.Table.User); err != nil {
+			return err
+		}
+
+		log.Info().Msg("User deleted")
+		return nil
+	},
+}
+
+// resendActivationCmd mails a new activation link
+var resendActivationCmd = &cobra.Command{
+	Use:   "resend-activation",
+	Short: "Sends the activation email again to an inactive user",
+	RunE: func(cmd *cobra.Command, args []string) error {
+
+		email, _ := cmd.Flags().GetString("email")
+
+		ctx := cmd.Context()
+		log.Info().Msg("Executing the resend-activation command")
+
+		cfg, ok := ctx.Value(ContextKey(CONFIG)).(Configuration)
+		if !ok {
+			return fmt.Errorf("Missing configuration")
+		}
+
+		dynamoDB, ok := ctx.Value(ContextKey(DYNAMO)).(*dynamodb.DynamoDB)
+		if !ok {
+			return fmt.Errorf("Missing DynamoDB connection")
+		}
+
+		u := &user.User{
+			Email: email,
+		}
+
+		if err := u.ResendActivation(ctx, dynamoDB,
+			cfg.AWS.DynamoDB.Table.User); err != nil {
+			return err
+		}
+
+		log.Info().Msg("Activation email queued")
+		return nil
+	},
+}
+
+// unsuppressCmd allows the emails to an address suppressed by feedbackUser
+var unsuppressCmd = &cobra.Command{
+	Use:   "unsuppress",
+	Short: "Sends email again to an address suppressed after a hard bounce or a complaint",
+	RunE: func(cmd *cobra.Command, args []string) error {
+
+		email, _ := cmd.Flags().GetString("email")
+
+		ctx := cmd.Context()
+		log.Info().Msg("Executing the unsuppress command")
+
+		cfg, ok := ctx.Value(ContextKey(CONFIG)).(Configuration)
+		if !ok {
+			return fmt.Errorf("Missing configuration")
+		}
+
+		dynamoDB, ok := ctx.Value(ContextKey(DYNAMO)).(*dynamodb.DynamoDB)
+		if !ok {
+			return fmt.Errorf("Missing DynamoDB connection")
+		}
+
+		u := &user.User{
+			Email: email,
+		}
+
+		if err := u.Unsuppress(ctx, dynamoDB,
+			cfg.AWS.DynamoDB.Table.User); err != nil {
+			return err
+		}
+
+		log.Info().Msg("Emails unsuppressed")
+		return nil
+	},
+}
+
+func init() {
+	RootCmd.AddCommand(updateCmd)
+	RootCmd.AddCommand(deleteCmd)
+	RootCmd.AddCommand(resendActivationCmd)
+	RootCmd.AddCommand(unsuppressCmd)
+
+	var email, firstName, lastName string
+	updateCmd.Flags().StringVarP(&email, "email", "e", "", "Email (required)")
+	updateCmd.MarkFlagRequired("email")
+	updateCmd.Flags().StringVarP(&firstName, "first-name", "f", "", "First Name")
+	updateCmd.Flags().StringVarP(&lastName, "last-name", "l", "", "Last Name")
+	updateCmd.Flags().StringArray("attr", nil,
+		"Custom attribute name=value, can be repeated, an empty value removes it")
+
+	deleteCmd.Flags().StringVarP(&email, "email", "e", "", "Email (required)")
+	deleteCmd.MarkFlagRequired("email")
+
+	resendActivationCmd.Flags().StringVarP(&email, "email", "e", "", "Email (required)")
+	resendActivationCmd.MarkFlagRequired("email")
+
+	unsuppressCmd.Flags().StringVarP(&email, "email", "e", "", "Email (required)")
+	unsuppressCmd.MarkFlagRequired("email")
+}
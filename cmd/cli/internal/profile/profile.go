@@ -0,0 +1,174 @@
+//Package profile reads and writes the CLI configuration file, which holds
+//named profiles such as one per stage or one for DynamoDB Local
+package profile
+
+import (
+	"errors"
+	"io/ioutil"
+	"net/http"
+	"os"
+	"path/filepath"
+	"sort"
+
+	"github.com/roloum/users/internal/apperr"
+	"gopkg.in/yaml.v2"
+)
+
+const (
+	//Default name of the profile used when none is selected
+	Default = "default"
+
+	//KeyRegion AWS region
+	KeyRegion = "region"
+
+	//KeyTable name of the users table
+	KeyTable = "table"
+
+	//KeyEndpointURL DynamoDB endpoint, e.g. http://localhost:8000
+	KeyEndpointURL = "endpoint-url"
+
+	//KeyLogLevel log level: fatal, error, warn, info, debug or trace
+	KeyLogLevel = "log-level"
+
+	//ErrorUnknownKey Returned when setting a key that profiles do not have
+	ErrorUnknownKey = "UnknownProfileKey"
+
+	//ErrorProfileDoesNotExist Returned when selecting a profile not in the file
+	ErrorProfileDoesNotExist = "ProfileDoesNotExist"
+)
+
+//init registers the HTTP status of the errors of the package
+func init() {
+	apperr.Register(http.StatusNotFound, ErrorProfileDoesNotExist)
+	apperr.Register(http.StatusUnprocessableEntity, ErrorUnknownKey)
+}
+
+//Keys lists the keys of a profile
+var Keys = []string{KeyRegion, KeyTable, KeyEndpointURL, KeyLogLevel}
+
+//Profile is a named set of settings
+type Profile struct {
+	Region      string `yaml:"region,omitempty" json:"region,omitempty"`
+	Table       string `yaml:"table,omitempty" json:"table,omitempty"`
+	EndpointURL string `yaml:"endpointUrl,omitempty" json:"endpointUrl,omitempty"`
+	LogLevel    string `yaml:"logLevel,omitempty" json:"logLevel,omitempty"`
+}
+
+//File is the content of the configuration file
+type File struct {
+	Current  string             `yaml:"current,omitempty" json:"current,omitempty"`
+	Profiles map[string]Profile `yaml:"profiles,omitempty" json:"profiles,omitempty"`
+}
+
+//Path returns the location of the configuration file: USERS_CONFIG when set,
+//otherwise config.yaml in $XDG_CONFIG_HOME/users or ~/.config/users
+func Path() (string, error) {
+	if path := os.Getenv("USERS_CONFIG"); path != "" {
+		return path, nil
+	}
+
+	dir := os.Getenv("XDG_CONFIG_HOME")
+	if dir == "" {
+		home, err := os.UserHomeDir()
+		if err != nil {
+			return "", err
+		}
+		dir = filepath.Join(home, ".config")
+	}
+
+	return filepath.Join(dir, "users", "config.yaml"), nil
+}
+
+//Load reads the file, a file that does not exist has no profiles
+func Load(path string) (*File, error) {
+
+	f := &File{Profiles: map[string]Profile{}}
+
+	b, err := ioutil.ReadFile(path)
+	if os.IsNotExist(err) {
+		return f, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	if err := yaml.Unmarshal(b, f); err != nil {
+		return nil, err
+	}
+	if f.Profiles == nil {
+		f.Profiles = map[string]Profile{}
+	}
+
+	return f, nil
+}
+
+//Save writes the file, readable only by the owner
+func (f *File) Save(path string) error {
+
+	b, err := yaml.Marshal(f)
+	if err != nil {
+		return err
+	}
+
+	if err := os.MkdirAll(filepath.Dir(path), 0700); err != nil {
+		return err
+	}
+
+	return ioutil.WriteFile(path, b, 0600)
+}
+
+//Selected returns the name of the profile to use: name when not empty,
+//otherwise the current profile of the file or Default
+func (f *File) Selected(name string) string {
+	switch {
+	case name != "":
+		return name
+	case f.Current != "":
+		return f.Current
+	}
+	return Default
+}
+
+//Names returns the names of the profiles, sorted
+func (f *File) Names() []string {
+	names := make([]string, 0, len(f.Profiles))
+	for name := range f.Profiles {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+//Set sets the key of the profile, creating the profile if needed
+func (f *File) Set(name, key, value string) error {
+
+	p := f.Profiles[name]
+
+	switch key {
+	case KeyRegion:
+		p.Region = value
+	case KeyTable:
+		p.Table = value
+	case KeyEndpointURL:
+		p.EndpointURL = value
+	case KeyLogLevel:
+		p.LogLevel = value
+	default:
+		return errors.New(ErrorUnknownKey)
+	}
+
+	f.Profiles[name] = p
+
+	return nil
+}
+
+//Use makes the profile the current one
+func (f *File) Use(name string) error {
+	if _, ok := f.Profiles[name]; !ok {
+		return errors.New(ErrorProfileDoesNotExist)
+	}
+
+	f.Current = name
+
+	return nil
+}
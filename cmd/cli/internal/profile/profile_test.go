@@ -0,0 +1,60 @@
+package profile
+
+import (
+	"errors"
+	"path/filepath"
+	"reflect"
+	"testing"
+)
+
+//TestFile Tests setting, selecting and saving profiles
+func TestFile(t *testing.T) {
+
+	path := filepath.Join(t.TempDir(), "users", "config.yaml")
+
+	f, err := Load(path)
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	if f.Selected("") != Default {
+		t.Errorf("Expected: %v. Received: %v", Default, f.Selected(""))
+	}
+
+	if err := f.Use("local"); !reflect.DeepEqual(err,
+		errors.New(ErrorProfileDoesNotExist)) {
+		t.Errorf("Expected: %v. Received: %v", ErrorProfileDoesNotExist, err)
+	}
+
+	if err := f.Set("local", "bogus", "x"); !reflect.DeepEqual(err,
+		errors.New(ErrorUnknownKey)) {
+		t.Errorf("Expected: %v. Received: %v", ErrorUnknownKey, err)
+	}
+
+	for key, value := range map[string]string{
+		KeyRegion:      "us-east-1",
+		KeyTable:       "users",
+		KeyEndpointURL: "http://localhost:8000",
+		KeyLogLevel:    "debug",
+	} {
+		if err := f.Set("local", key, value); err != nil {
+			t.Fatalf("Unexpected error: %v", err)
+		}
+	}
+	if err := f.Use("local"); err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	if err := f.Save(path); err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+
+	loaded, err := Load(path)
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	if !reflect.DeepEqual(loaded, f) {
+		t.Errorf("Expected: %+v. Received: %+v", f, loaded)
+	}
+	if loaded.Selected("") != "local" || loaded.Selected("prod") != "prod" {
+		t.Errorf("Unexpected profile selected")
+	}
+}
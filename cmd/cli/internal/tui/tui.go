@@ -0,0 +1,553 @@
+//Package tui is a terminal UI to browse and administer users. Every action
+//calls the same internal/user functions as the cobra commands
+package tui
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"unicode/utf8"
+
+	"github.com/aws/aws-sdk-go/service/dynamodb/dynamodbiface"
+	"github.com/charmbracelet/bubbles/textinput"
+	tea "github.com/charmbracelet/bubbletea"
+
+	"github.com/roloum/users/internal/user"
+)
+
+const (
+	//pageSize users read per request
+	pageSize = 100
+
+	//listWidth width of the list column
+	listWidth = 50
+
+	//help keys shown in the footer
+	help = "↑/↓ move  / search  a activate  r resend  e edit  d delete  q quit"
+)
+
+type mode int
+
+const (
+	modeList mode = iota
+	modeSearch
+	modeEdit
+	modeConfirmDelete
+)
+
+//pageMsg carries a page of users
+type pageMsg struct {
+	users []*user.User
+	next  string
+	err   error
+}
+
+//tokensMsg carries the pending tokens of an user
+type tokensMsg struct {
+	email  string
+	tokens []user.PendingToken
+	err    error
+}
+
+//actionMsg carries the outcome of an action on an user
+type actionMsg struct {
+	status  string
+	user    *user.User
+	deleted string
+	err     error
+}
+
+//Model is the state of the terminal UI
+type Model struct {
+	ctx       context.Context
+	svc       dynamodbiface.DynamoDBAPI
+	tableName string
+
+	users    []*user.User
+	visible  []*user.User
+	selected int
+	next     string
+	loading  bool
+
+	tokens map[string][]user.PendingToken
+
+	mode      mode
+	search    textinput.Model
+	firstName textinput.Model
+	lastName  textinput.Model
+
+	status string
+	height int
+}
+
+//New returns the model of the terminal UI
+func New(ctx context.Context, svc dynamodbiface.DynamoDBAPI,
+	tableName string) Model {
+
+	search := textinput.New()
+	search.Prompt = "/ "
+	search.Placeholder = "email or name"
+
+	firstName := textinput.New()
+	firstName.Prompt = "First name: "
+
+	lastName := textinput.New()
+	lastName.Prompt = "Last name:  "
+
+	return Model{
+		ctx:       ctx,
+		svc:       svc,
+		tableName: tableName,
+		tokens:    map[string][]user.PendingToken{},
+		search:    search,
+		firstName: firstName,
+		lastName:  lastName,
+		loading:   true,
+		height:    24,
+	}
+}
+
+//Init loads the first page of users
+func (m Model) Init() tea.Cmd {
+	return m.loadPage("")
+}
+
+//Update handles messages and key presses
+func (m Model) Update(msg tea.Msg) (tea.Model, tea.Cmd) {
+
+	switch msg := msg.(type) {
+
+	case tea.WindowSizeMsg:
+		m.height = msg.Height
+		return m, nil
+
+	case pageMsg:
+		m.loading = false
+		if msg.err != nil {
+			m.status = "Error: " + msg.err.Error()
+			return m, nil
+		}
+		m.users = append(m.users, msg.users...)
+		m.next = msg.next
+		m.filter()
+		return m, m.loadSelectedTokens()
+
+	case tokensMsg:
+		if msg.err != nil {
+			m.status = "Error: " + msg.err.Error()
+			return m, nil
+		}
+		m.tokens[msg.email] = msg.tokens
+		return m, nil
+
+	case actionMsg:
+		if msg.err != nil {
+			m.status = "Error: " + msg.err.Error()
+			return m, nil
+		}
+		m.status = msg.status
+		m.apply(msg)
+		return m, m.loadSelectedTokens()
+
+	case tea.KeyMsg:
+		if msg.Type == tea.KeyCtrlC {
+			return m, tea.Quit
+		}
+
+		switch m.mode {
+		case modeSearch:
+			return m.updateSearch(msg)
+		case modeEdit:
+			return m.updateEdit(msg)
+		case modeConfirmDelete:
+			return m.updateConfirmDelete(msg)
+		}
+		return m.updateList(msg)
+	}
+
+	return m, nil
+}
+
+func (m Model) updateList(msg tea.KeyMsg) (tea.Model, tea.Cmd) {
+
+	u := m.current()
+
+	switch msg.String() {
+	case "q":
+		return m, tea.Quit
+
+	case "up", "k":
+		if m.selected > 0 {
+			m.selected--
+		}
+		return m, m.loadSelectedTokens()
+
+	case "down", "j":
+		if m.selected < len(m.visible)-1 {
+			m.selected++
+		} else if m.next != "" && !m.loading {
+			m.loading = true
+			return m, m.loadPage(m.next)
+		}
+		return m, m.loadSelectedTokens()
+
+	case "/":
+		m.mode = modeSearch
+		return m, m.search.Focus()
+
+	case "esc":
+		m.search.SetValue("")
+		m.filter()
+		return m, m.loadSelectedTokens()
+
+	case "a":
+		if u != nil {
+			return m, m.activate(u)
+		}
+
+	case "r":
+		if u != nil {
+			return m, m.resend(u)
+		}
+
+	case "e":
+		if u != nil {
+			m.mode = modeEdit
+			m.firstName.SetValue(u.FirstName)
+			m.lastName.SetValue(u.LastName)
+			m.lastName.Blur()
+			return m, m.firstName.Focus()
+		}
+
+	case "d":
+		if u != nil {
+			m.mode = modeConfirmDelete
+		}
+	}
+
+	return m, nil
+}
+
+func (m Model) updateSearch(msg tea.KeyMsg) (tea.Model, tea.Cmd) {
+
+	switch msg.Type {
+	case tea.KeyEsc:
+		m.search.SetValue("")
+		fallthrough
+	case tea.KeyEnter:
+		m.mode = modeList
+		m.search.Blur()
+		m.filter()
+		return m, m.loadSelectedTokens()
+	}
+
+	var cmd tea.Cmd
+	m.search, cmd = m.search.Update(msg)
+	m.filter()
+
+	return m, cmd
+}
+
+func (m Model) updateEdit(msg tea.KeyMsg) (tea.Model, tea.Cmd) {
+
+	switch msg.Type {
+	case tea.KeyEsc:
+		m.mode = modeList
+		m.firstName.Blur()
+		m.lastName.Blur()
+		return m, nil
+
+	case tea.KeyTab, tea.KeyShiftTab, tea.KeyUp, tea.KeyDown:
+		if m.firstName.Focused() {
+			m.firstName.Blur()
+			return m, m.lastName.Focus()
+		}
+		m.lastName.Blur()
+		return m, m.firstName.Focus()
+
+	case tea.KeyEnter:
+		m.mode = modeList
+		m.firstName.Blur()
+		m.lastName.Blur()
+		if u := m.current(); u != nil {
+			return m, m.update(u, m.firstName.Value(), m.lastName.Value())
+		}
+		return m, nil
+	}
+
+	var cmd tea.Cmd
+	if m.firstName.Focused() {
+		m.firstName, cmd = m.firstName.Update(msg)
+	} else {
+		m.lastName, cmd = m.lastName.Update(msg)
+	}
+
+	return m, cmd
+}
+
+func (m Model) updateConfirmDelete(msg tea.KeyMsg) (tea.Model, tea.Cmd) {
+
+	m.mode = modeList
+
+	if u := m.current(); u != nil && msg.String() == "y" {
+		return m, m.delete(u)
+	}
+
+	m.status = "Delete cancelled"
+
+	return m, nil
+}
+
+//apply reflects the outcome of an action in the list
+func (m *Model) apply(msg actionMsg) {
+
+	for i, u := range m.users {
+		switch {
+		case msg.deleted != "" && u.Email == msg.deleted:
+			m.users = append(m.users[:i], m.users[i+1:]...)
+			delete(m.tokens, msg.deleted)
+			m.filter()
+			return
+		case msg.user != nil && u.Email == msg.user.Email:
+			m.users[i] = msg.user
+			delete(m.tokens, u.Email)
+			m.filter()
+			return
+		}
+	}
+}
+
+//filter keeps the users matching the search and clamps the selection
+func (m *Model) filter() {
+
+	query := strings.ToLower(strings.TrimSpace(m.search.Value()))
+
+	m.visible = nil
+	for _, u := range m.users {
+		if match(u, query) {
+			m.visible = append(m.visible, u)
+		}
+	}
+
+	if m.selected >= len(m.visible) {
+		m.selected = len(m.visible) - 1
+	}
+	if m.selected < 0 {
+		m.selected = 0
+	}
+}
+
+//match tells whether the email or name of the user contains the query
+func match(u *user.User, query string) bool {
+	if query == "" {
+		return true
+	}
+	return strings.Contains(strings.ToLower(u.Email), query) ||
+		strings.Contains(strings.ToLower(u.FirstName+" "+u.LastName), query)
+}
+
+//current returns the selected user, nil when the list is empty
+func (m Model) current() *user.User {
+	if m.selected < len(m.visible) {
+		return m.visible[m.selected]
+	}
+	return nil
+}
+
+func (m Model) loadPage(cursor string) tea.Cmd {
+	return func() tea.Msg {
+		users, next, err := user.List(m.ctx, m.svc, m.tableName, pageSize, cursor)
+		return pageMsg{users: users, next: next, err: err}
+	}
+}
+
+//loadSelectedTokens loads the pending tokens of the selected user once
+func (m Model) loadSelectedTokens() tea.Cmd {
+
+	u := m.current()
+	if u == nil {
+		return nil
+	}
+	if _, ok := m.tokens[u.Email]; ok {
+		return nil
+	}
+
+	email := u.Email
+	return func() tea.Msg {
+		u := &user.User{Email: email}
+		tokens, err := u.PendingTokens(m.ctx, m.svc, m.tableName)
+		return tokensMsg{email: email, tokens: tokens, err: err}
+	}
+}
+
+func (m Model) activate(selected *user.User) tea.Cmd {
+	u := *selected
+	return func() tea.Msg {
+		if err := u.Activate(m.ctx, m.svc, m.tableName, u.ID); err != nil {
+			return actionMsg{err: err}
+		}
+		u.Active = true
+		return actionMsg{status: "Activated " + u.Email, user: &u}
+	}
+}
+
+func (m Model) resend(selected *user.User) tea.Cmd {
+	u := *selected
+	return func() tea.Msg {
+		if err := u.ResendActivation(m.ctx, m.svc, m.tableName); err != nil {
+			return actionMsg{err: err}
+		}
+		return actionMsg{status: "Activation email queued for " + u.Email,
+			user: &u}
+	}
+}
+
+func (m Model) update(selected *user.User, firstName, lastName string) tea.Cmd {
+	u := *selected
+	u.FirstName = strings.TrimSpace(firstName)
+	u.LastName = strings.TrimSpace(lastName)
+	return func() tea.Msg {
+		if err := u.Update(m.ctx, m.svc, m.tableName); err != nil {
+			return actionMsg{err: err}
+		}
+		return actionMsg{status: "Updated " + u.Email, user: &u}
+	}
+}
+
+func (m Model) delete(selected *user.User) tea.Cmd {
+	u := *selected
+	return func() tea.Msg {
+		if err := u.Delete(m.ctx, m.svc, m.tableName); err != nil {
+			return actionMsg{err: err}
+		}
+		return actionMsg{status: "Deleted " + u.Email, deleted: u.Email}
+	}
+}
+
+//View renders the list next to the detail pane of the selected user
+func (m Model) View() string {
+
+	var b strings.Builder
+
+	more := ""
+	if m.next != "" {
+		more = ", more below"
+	}
+	if m.loading {
+		more = ", loading"
+	}
+	fmt.Fprintf(&b, "Users: %d of %d loaded%s\n", len(m.visible), len(m.users),
+		more)
+	b.WriteString(m.search.View() + "\n\n")
+
+	rows := m.height - 6
+	if rows < 1 {
+		rows = 1
+	}
+
+	//Scroll so the selection is always visible
+	start := 0
+	if m.selected >= rows {
+		start = m.selected - rows + 1
+	}
+
+	var left []string
+	for i := start; i < len(m.visible) && i < start+rows; i++ {
+		u := m.visible[i]
+
+		cursor, active := "  ", " "
+		if i == m.selected {
+			cursor = "> "
+		}
+		if u.Active {
+			active = "✓"
+		}
+
+		left = append(left, cursor+active+" "+u.Email)
+	}
+
+	right := m.detail()
+
+	for i := 0; i < rows && (i < len(left) || i < len(right)); i++ {
+		var l, r string
+		if i < len(left) {
+			l = left[i]
+		}
+		if i < len(right) {
+			r = right[i]
+		}
+		b.WriteString(pad(l, listWidth) + " │ " + r + "\n")
+	}
+
+	b.WriteString("\n" + m.footer() + "\n")
+
+	return b.String()
+}
+
+//detail returns the lines of the detail pane
+func (m Model) detail() []string {
+
+	u := m.current()
+	if u == nil {
+		return []string{"No users"}
+	}
+
+	lines := []string{
+		"Email:      " + u.Email,
+		"ID:         " + u.ID,
+		"First name: " + u.FirstName,
+		"Last name:  " + u.LastName,
+		fmt.Sprintf("Active:     %v", u.Active),
+		"Created:    " + u.Created,
+		"",
+	}
+
+	tokens, ok := m.tokens[u.Email]
+	switch {
+	case !ok:
+		lines = append(lines, "Pending tokens: loading")
+	case len(tokens) == 0:
+		lines = append(lines, "Pending tokens: none")
+	default:
+		lines = append(lines, "Pending tokens:")
+		for _, t := range tokens {
+			line := "  " + t.Kind
+			if t.Token != "" {
+				line += " " + t.Token
+			}
+			if t.Expires != "" {
+				line += " expires " + t.Expires
+			}
+			lines = append(lines, line)
+		}
+	}
+
+	if m.mode == modeEdit {
+		lines = append(lines, "", m.firstName.View(), m.lastName.View(),
+			"enter save  tab next field  esc cancel")
+	}
+
+	return lines
+}
+
+func (m Model) footer() string {
+	switch m.mode {
+	case modeConfirmDelete:
+		return fmt.Sprintf("Delete %s and all its rows? y/n", m.current().Email)
+	case modeSearch:
+		return "enter done  esc clear"
+	}
+	if m.status != "" {
+		return m.status + "\n" + help
+	}
+	return help
+}
+
+//pad truncates or pads s to w runes
+func pad(s string, w int) string {
+	n := utf8.RuneCountInString(s)
+	if n > w {
+		return string([]rune(s)[:w-1]) + "…"
+	}
+	return s + strings.Repeat(" ", w-n)
+}
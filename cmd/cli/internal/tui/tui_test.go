@@ -0,0 +1,124 @@
+package tui
+
+import (
+	"context"
+	"testing"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/dynamodb"
+	tea "github.com/charmbracelet/bubbletea"
+	"github.com/rs/zerolog"
+
+	"github.com/roloum/users/internal/test"
+	"github.com/roloum/users/internal/user"
+)
+
+func init() {
+	zerolog.SetGlobalLevel(zerolog.Disabled)
+}
+
+//keys returns the key presses of the string
+func keys(s string) []tea.KeyMsg {
+	var msgs []tea.KeyMsg
+	for _, r := range s {
+		msgs = append(msgs, tea.KeyMsg{Type: tea.KeyRunes, Runes: []rune{r}})
+	}
+	return msgs
+}
+
+//send applies the messages to the model. The commands returned are not run,
+//since most of them are cursor blinks
+func send(m tea.Model, msgs ...tea.Msg) (tea.Model, tea.Cmd) {
+	var cmd tea.Cmd
+	for _, msg := range msgs {
+		m, cmd = m.Update(msg)
+	}
+	return m, cmd
+}
+
+//TestSearch Tests that the list is filtered as the query is typed
+func TestSearch(t *testing.T) {
+
+	mock := &test.MockDynamoDB{QueryOutput: &dynamodb.QueryOutput{}}
+
+	var m tea.Model = New(context.Background(), mock, "users")
+	m, _ = send(m, pageMsg{users: []*user.User{
+		{Email: "john@acme.com", FirstName: "John", LastName: "Smith"},
+		{Email: "jane@other.com", FirstName: "Jane", LastName: "Smith"},
+		{Email: "bob@other.com", FirstName: "Bob", LastName: "Jones"},
+	}})
+
+	tests := []struct {
+		desc     string
+		query    string
+		expected int
+	}{
+		{desc: "Email", query: "acme", expected: 1},
+		{desc: "Name", query: "smith", expected: 2},
+		{desc: "FullName", query: "jane smith", expected: 1},
+		{desc: "NoMatch", query: "nobody", expected: 0},
+	}
+
+	for _, tc := range tests {
+		t.Run(tc.desc, func(t *testing.T) {
+			var msgs []tea.Msg
+			msgs = append(msgs, keys("/")[0])
+			for _, k := range keys(tc.query) {
+				msgs = append(msgs, k)
+			}
+
+			result, _ := send(m, msgs...)
+			if len(result.(Model).visible) != tc.expected {
+				t.Errorf("Expected: %d. Received: %d", tc.expected,
+					len(result.(Model).visible))
+			}
+		})
+	}
+}
+
+//TestDelete Tests that a confirmed delete removes the user from the list
+func TestDelete(t *testing.T) {
+
+	mock := &test.MockDynamoDB{QueryOutput: &dynamodb.QueryOutput{
+		Items: []map[string]*dynamodb.AttributeValue{
+			{
+				"pk": {S: aws.String("USER#john@acme.com")},
+				"sk": {S: aws.String("PROFILE#")},
+			},
+		},
+	}}
+
+	var m tea.Model = New(context.Background(), mock, "users")
+	m, _ = send(m, pageMsg{users: []*user.User{
+		{Email: "john@acme.com"},
+		{Email: "jane@other.com"},
+	}})
+
+	tests := []struct {
+		desc     string
+		keys     string
+		expected int
+	}{
+		{desc: "Confirmed", keys: "dy", expected: 1},
+		{desc: "Cancelled", keys: "dn", expected: 2},
+	}
+
+	for _, tc := range tests {
+		t.Run(tc.desc, func(t *testing.T) {
+			var msgs []tea.Msg
+			for _, k := range keys(tc.keys) {
+				msgs = append(msgs, k)
+			}
+
+			result, cmd := send(m, msgs...)
+			if cmd != nil {
+				result, _ = send(result, cmd())
+			}
+
+			if len(result.(Model).users) != tc.expected {
+				t.Errorf("Expected: %d. Received: %d", tc.expected,
+					len(result.(Model).users))
+			}
+		})
+	}
+}
@@ -6,7 +6,7 @@ import (
 
 	"github.com/roloum/users/cmd/cli/internal/cmd"
 	uaws "github.com/roloum/users/internal/aws"
-	"github.com/roloum/users/internal/config"
+	"github.com/roloum/users/internal/user"
 	"github.com/rs/zerolog/log"
 )
 
@@ -14,26 +14,32 @@ func main() {
 
 	if err := run(); err != nil {
 		log.Error().Msgf("Main: %s", err)
-		os.Exit(1)
+		os.Exit(cmd.ExitCode(err))
 	}
 
 }
 
 func run() error {
 
-	var cfg cmd.Configuration
-	err := config.Load(&cfg)
+	cfg, err := cmd.LoadConfiguration(os.Args[1:])
 	if err != nil {
 		return err
 	}
+	if err := user.Configure(cfg.Validation); err != nil {
+		return err
+	}
+
 	ctx := context.WithValue(context.Background(), cmd.ContextKey(cmd.CONFIG), cfg)
 
-	sess, err := uaws.GetSession(cfg.AWS.Region)
-	if err != nil {
-		return err
+	//The config commands work without a region
+	if cfg.AWS.Region != "" {
+		sess, err := uaws.GetSession(cfg.AWS.Region)
+		if err != nil {
+			return err
+		}
+		dynamo := uaws.GetDynamoDBWithEndpoint(sess, cfg.AWS.DynamoDB.Endpoint)
+		ctx = context.WithValue(ctx, cmd.ContextKey(cmd.DYNAMO), dynamo)
 	}
-	dynamo := uaws.GetDynamoDB(sess)
-	ctx = context.WithValue(ctx, cmd.ContextKey(cmd.DYNAMO), dynamo)
 
 	if err := cmd.RootCmd.ExecuteContext(ctx); err != nil {
 		return err
@@ -3,14 +3,15 @@ package main
 import (
 	"context"
 	"encoding/json"
+	"errors"
 	"net/http"
-	"strings"
 
 	"github.com/rs/zerolog/log"
 
 	"github.com/aws/aws-lambda-go/events"
 	"github.com/aws/aws-lambda-go/lambda"
 	"github.com/aws/aws-sdk-go/service/dynamodb"
+	"github.com/roloum/users/internal/apperr"
 	uaws "github.com/roloum/users/internal/aws"
 	"github.com/roloum/users/internal/config"
 	"github.com/roloum/users/internal/user"
@@ -27,6 +28,10 @@ const (
 	ErrorTokenIsEmpty = "TokenIsEmpty"
 )
 
+func init() {
+	apperr.Register(http.StatusUnprocessableEntity, ErrorTokenIsEmpty)
+}
+
 type (
 
 	// activateResponse
@@ -60,15 +65,14 @@ func Handler(ctx context.Context, dynamoDB *dynamodb.DynamoDB,
 
 	email := request.QueryStringParameters["email"]
 	if email == "" {
-		return getResponse(http.StatusUnprocessableEntity, ErrorEmailIsEmpty)
+		return getProblem(errors.New(ErrorEmailIsEmpty), request)
 	}
 
 	token := request.QueryStringParameters["token"]
 	if token == "" {
-		return getResponse(http.StatusUnprocessableEntity, ErrorTokenIsEmpty)
+		return getProblem(errors.New(ErrorTokenIsEmpty), request)
 	}
 
-	email = strings.ToLower(email)
 	log.Info().Msgf("Activating account: %s", email)
 
 	u := &user.User{
@@ -77,7 +81,7 @@ func Handler(ctx context.Context, dynamoDB *dynamodb.DynamoDB,
 
 	err := u.Activate(ctx, dynamoDB, cfg.AWS.DynamoDB.Table.User, token)
 	if err != nil {
-		return getResponse(http.StatusUnprocessableEntity, err.Error())
+		return getProblem(err, request)
 	}
 
 	log.Info().Msg("User Activated")
@@ -85,6 +89,12 @@ func Handler(ctx context.Context, dynamoDB *dynamodb.DynamoDB,
 	return getResponse(http.StatusCreated, MsgUserActivated)
 }
 
+// getProblem builds the application/problem+json response of err
+func getProblem(err error, request events.APIGatewayProxyRequest) (
+	Response, error) {
+	return Response(apperr.Response(err, request.Path)), nil
+}
+
 // getResponse builds an API Gateway Response
 func getResponse(statusCode int, message string) (Response, error) {
 
@@ -0,0 +1,131 @@
+//Lambda function that processes the avatar images uploaded with the URLs
+//presigned by POST /users/me/avatar. It is triggered by the ObjectCreated
+//events of the uploads/ prefix of the avatar bucket:
+// - the upload is validated, cropped, resized to the standard sizes and
+//   stored as JPEG without its EXIF metadata under avatars/
+// - the URLs are saved on the PROFILE row and the previous images deleted
+// - the upload is deleted, also when it is not a valid image
+package main
+
+import (
+	"context"
+	"errors"
+	"net/url"
+
+	"github.com/rs/zerolog/log"
+
+	"github.com/aws/aws-lambda-go/events"
+	"github.com/aws/aws-lambda-go/lambda"
+	"github.com/aws/aws-sdk-go/service/dynamodb"
+	"github.com/roloum/users/internal/apperr"
+	"github.com/roloum/users/internal/avatar"
+	uaws "github.com/roloum/users/internal/aws"
+	"github.com/roloum/users/internal/config"
+	"github.com/roloum/users/internal/user"
+)
+
+type configuration struct {
+	AWS struct {
+		DynamoDB struct {
+			Table struct {
+				User string `required:"true"`
+			}
+		}
+		Region string `required:"true"`
+	}
+	Avatar avatar.Config
+}
+
+//handler processes every upload of the event. An error makes Lambda retry the
+//event, so only the transient failures are returned; an upload that can not
+//become an avatar is logged and deleted
+func handler(ctx context.Context, dynamoDB *dynamodb.DynamoDB,
+	storage avatar.Storage, e events.S3Event, cfg configuration) error {
+
+	for _, record := range e.Records {
+
+		//The keys of the events are URL encoded
+		key, err := url.QueryUnescape(record.S3.Object.Key)
+		if err != nil {
+			key = record.S3.Object.Key
+		}
+
+		err = process(ctx, dynamoDB, storage, key, record.S3.Object.Size, cfg)
+		switch {
+		case apperr.IsRetryable(err):
+			log.Error().Err(err).Msgf("Processing upload: %s", key)
+			return err
+		case err != nil && apperr.From(err).Status >= 500:
+			log.Error().Err(err).Msgf("Discarding upload: %s", key)
+		case err != nil:
+			log.Warn().Msgf("Rejected upload %s: %s", key, err.Error())
+		}
+
+		if err := storage.Delete(ctx, key); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+//process makes the uploaded image the avatar of the user of its key
+func process(ctx context.Context, dynamoDB *dynamodb.DynamoDB,
+	storage avatar.Storage, key string, size int64, cfg configuration) error {
+
+	userID, nonce, err := avatar.ParseUploadKey(key)
+	if err != nil {
+		return err
+	}
+
+	if size > cfg.Avatar.MaxBytes {
+		return errors.New(avatar.ErrorImageTooLarge)
+	}
+
+	u, err := user.LoadByID(ctx, dynamoDB, cfg.AWS.DynamoDB.Table.User, userID)
+	if err != nil {
+		return err
+	}
+
+	body, err := storage.Get(ctx, key)
+	if err != nil {
+		return err
+	}
+	defer body.Close()
+
+	if err := avatar.Set(ctx, storage, dynamoDB, cfg.AWS.DynamoDB.Table.User,
+		u, nonce, body, cfg.Avatar.MaxBytes); err != nil {
+		return err
+	}
+
+	log.Info().Msgf("Avatar set: %s", u.Email)
+	return nil
+}
+
+func initHandler(ctx context.Context, e events.S3Event) error {
+
+	var cfg configuration
+	err := config.Load(&cfg)
+	if err != nil {
+		return err
+	}
+
+	log.Debug().Msg("initHandler function")
+
+	sess, err := uaws.GetSession(cfg.AWS.Region)
+	if err != nil {
+		return err
+	}
+
+	storage, err := avatar.New(cfg.Avatar,
+		uaws.GetS3WithEndpoint(sess, cfg.Avatar.Endpoint))
+	if err != nil {
+		return err
+	}
+
+	return handler(ctx, uaws.GetDynamoDB(sess), storage, e, cfg)
+}
+
+func main() {
+	lambda.Start(initHandler)
+}
@@ -11,6 +11,7 @@ import (
 	"github.com/aws/aws-lambda-go/events"
 	"github.com/aws/aws-lambda-go/lambda"
 	"github.com/aws/aws-sdk-go/service/dynamodb"
+	"github.com/roloum/users/internal/apperr"
 	uaws "github.com/roloum/users/internal/aws"
 	"github.com/roloum/users/internal/config"
 	"github.com/roloum/users/internal/user"
@@ -27,6 +28,8 @@ type (
 		Email     string `json:"email,omitempty"`
 		FirstName string `json:"firstName,omitempty"`
 		LastName  string `json:"lastName,omitempty"`
+
+		Attributes map[string]interface{} `json:"attributes,omitempty"`
 	}
 
 	// createResponse
@@ -51,6 +54,7 @@ type (
 			}
 			Region string `required:"true"`
 		}
+		Validation user.ValidationConfig
 	}
 )
 
@@ -62,25 +66,44 @@ func Handler(ctx context.Context, dynamoDB *dynamodb.DynamoDB,
 	log.Debug().Msg("Unmarshalling request")
 	var body createRequest
 	if err := json.Unmarshal([]byte(request.Body), &body); err != nil {
-		return getResponse(http.StatusUnprocessableEntity, err.Error(), nil)
+		return getProblem(err, request)
+	}
+
+	schema, err := user.GetSchema(ctx, dynamoDB, cfg.AWS.DynamoDB.Table.User)
+	if err != nil {
+		return getProblem(err, request)
+	}
+
+	// The admin attributes are set by the admins only
+	if fields := schema.Writable(body.Attributes,
+		user.VisibilityPrivate); len(fields) > 0 {
+		return getProblem(apperr.Validation(fields...), request)
 	}
 
 	newUser := &user.NewUser{
-		Email:     body.Email,
-		FirstName: body.FirstName,
-		LastName:  body.LastName,
+		Email:      body.Email,
+		FirstName:  body.FirstName,
+		LastName:   body.LastName,
+		Attributes: body.Attributes,
 	}
 
 	u, err := user.Create(ctx, dynamoDB, newUser, cfg.AWS.DynamoDB.Table.User)
 	if err != nil {
-		return getResponse(http.StatusUnprocessableEntity, err.Error(), nil)
+		return getProblem(err, request)
 	}
+	u.Attributes = schema.Visible(u.Attributes, user.VisibilityPrivate)
 
 	log.Info().Msg("User Created")
 
 	return getResponse(http.StatusCreated, MsgUserCreated, u)
 }
 
+// getProblem builds the application/problem+json response of err
+func getProblem(err error, request events.APIGatewayProxyRequest) (
+	Response, error) {
+	return Response(apperr.Response(err, request.Path)), nil
+}
+
 // getResponse builds an API Gateway Response
 func getResponse(statusCode int, message string, u *user.User) (
 	Response, error) {
@@ -118,6 +141,10 @@ func initHandler(ctx context.Context, request events.APIGatewayProxyRequest) (
 
 	log.Debug().Msg("initHandler function")
 
+	if err := user.Configure(cfg.Validation); err != nil {
+		return Response{}, err
+	}
+
 	sess, err := uaws.GetSession(cfg.AWS.Region)
 	if err != nil {
 		return Response{}, err
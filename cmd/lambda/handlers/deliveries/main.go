@@ -0,0 +1,151 @@
+//Lambda function showing the delivery of the emails of an user to the support
+//tools:
+// - GET /users/deliveries?email=john@acme.com
+//Requests are authenticated with an API key holding the users:deliveries scope
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+
+	"github.com/rs/zerolog/log"
+
+	"github.com/aws/aws-lambda-go/events"
+	"github.com/aws/aws-lambda-go/lambda"
+	"github.com/aws/aws-sdk-go/service/dynamodb"
+	"github.com/roloum/users/internal/apperr"
+	"github.com/roloum/users/internal/auth"
+	uaws "github.com/roloum/users/internal/aws"
+	"github.com/roloum/users/internal/config"
+	"github.com/roloum/users/internal/user"
+)
+
+const (
+	//MsgOK message returned when the request succeeds
+	MsgOK = "OK"
+
+	//ErrorEmailIsEmpty message returned if email is empty
+	ErrorEmailIsEmpty = "EmailIsEmpty"
+)
+
+type (
+	// deliveriesResponse
+	deliveriesResponse struct {
+		StatusCode      int             `json:"status"`
+		Message         string          `json:"message"`
+		Email           string          `json:"email,omitempty"`
+		EmailStatus     string          `json:"emailStatus,omitempty"`
+		EmailSuppressed string          `json:"emailSuppressed,omitempty"`
+		Deliveries      []user.Delivery `json:"deliveries,omitempty"`
+	}
+
+	// Response is of type APIGatewayProxyResponse since we're leveraging the
+	// AWS Lambda Proxy Request functionality (default behavior)
+	//
+	// https://serverless.com/framework/docs/providers/aws/events/apigateway/#lambda-proxy-integration
+	Response events.APIGatewayProxyResponse
+
+	configuration struct {
+		AWS struct {
+			DynamoDB struct {
+				Table struct {
+					User string `required:"true"`
+				}
+			}
+			Region string `required:"true"`
+		}
+	}
+)
+
+// Handler is our lambda handler invoked by the `lambda.Start` function call
+func Handler(ctx context.Context, dynamoDB *dynamodb.DynamoDB,
+	request events.APIGatewayProxyRequest,
+	cfg configuration) (Response, error) {
+
+	p, err := auth.Authenticate(ctx, dynamoDB, cfg.AWS.DynamoDB.Table.User,
+		request.Headers)
+	if err != nil {
+		return getProblem(err, request)
+	}
+
+	if err := p.Require(auth.ScopeUsersDeliveries); err != nil {
+		return getProblem(err, request)
+	}
+
+	email := request.QueryStringParameters["email"]
+	if email == "" {
+		return getProblem(errors.New(ErrorEmailIsEmpty), request)
+	}
+
+	log.Info().Msgf("Deliveries of %s by %s", email, p.User.Email)
+
+	u := &user.User{
+		Email: email,
+	}
+
+	if err := u.Load(ctx, dynamoDB, cfg.AWS.DynamoDB.Table.User); err != nil {
+		return getProblem(err, request)
+	}
+
+	deliveries, err := u.Deliveries(ctx, dynamoDB, cfg.AWS.DynamoDB.Table.User)
+	if err != nil {
+		return getProblem(err, request)
+	}
+
+	return getResponse(http.StatusOK, &deliveriesResponse{Message: MsgOK,
+		Email: u.Email, EmailStatus: u.EmailStatus,
+		EmailSuppressed: u.EmailSuppressed, Deliveries: deliveries})
+}
+
+// getProblem builds the application/problem+json response of err
+func getProblem(err error, request events.APIGatewayProxyRequest) (
+	Response, error) {
+	return Response(apperr.Response(err, request.Path)), nil
+}
+
+// getResponse builds an API Gateway Response
+func getResponse(statusCode int, resp *deliveriesResponse) (Response, error) {
+
+	headers := map[string]string{
+		"Content-Type": "application/json",
+	}
+
+	resp.StatusCode = statusCode
+
+	js, err := json.Marshal(resp)
+	if err != nil {
+		return Response{StatusCode: http.StatusInternalServerError}, err
+	}
+
+	log.Debug().Msgf("status_code: %d, message: %s", resp.StatusCode, resp.Message)
+
+	return Response{Headers: headers, Body: string(js),
+		StatusCode: resp.StatusCode}, nil
+}
+
+func initHandler(ctx context.Context, request events.APIGatewayProxyRequest) (
+	Response, error) {
+
+	//Config holds the configuration for the application
+	var cfg configuration
+	err := config.Load(&cfg)
+	if err != nil {
+		return Response{}, err
+	}
+
+	log.Debug().Msg("initHandler function")
+
+	sess, err := uaws.GetSession(cfg.AWS.Region)
+	if err != nil {
+		return Response{}, err
+	}
+
+	return Handler(ctx, uaws.GetDynamoDB(sess), request, cfg)
+
+}
+
+func main() {
+	lambda.Start(initHandler)
+}
@@ -0,0 +1,98 @@
+//Lambda function that tracks the delivery of the emails. It is subscribed to
+//the SNS topic of the SES bounce, complaint and delivery notifications:
+// - every delivery is added to the DELIVERY# history of the user and becomes
+//   the emailStatus of the profile
+// - hard bounces and complaints set emailSuppressed, and the notify function
+//   stops sending email to the address
+package main
+
+import (
+	"context"
+	"time"
+
+	"github.com/rs/zerolog/log"
+
+	"github.com/aws/aws-lambda-go/events"
+	"github.com/aws/aws-lambda-go/lambda"
+	"github.com/aws/aws-sdk-go/service/dynamodb/dynamodbiface"
+	"github.com/roloum/users/internal/apperr"
+	uaws "github.com/roloum/users/internal/aws"
+	"github.com/roloum/users/internal/config"
+	"github.com/roloum/users/internal/notify"
+	"github.com/roloum/users/internal/user"
+)
+
+type configuration struct {
+	AWS struct {
+		DynamoDB struct {
+			Table struct {
+				User string `required:"true"`
+			}
+		}
+		Region string `required:"true"`
+	}
+	Delivery struct {
+		TTL time.Duration `default:"2160h"`
+	}
+}
+
+//handler records the deliveries of every notification. An error makes Lambda
+//retry the event, so only the transient failures are returned; notifications
+//that can not be parsed and addresses of no user are logged and dropped
+func handler(ctx context.Context, dynamoDB dynamodbiface.DynamoDBAPI,
+	e events.SNSEvent, cfg configuration) error {
+
+	for _, record := range e.Records {
+
+		deliveries, err := notify.ParseFeedback(record.SNS.Message)
+		if err != nil {
+			log.Warn().Msgf("Discarding notification %s: %s",
+				record.SNS.MessageID, err.Error())
+			continue
+		}
+
+		for _, d := range deliveries {
+			err := user.RecordDelivery(ctx, dynamoDB,
+				cfg.AWS.DynamoDB.Table.User, d, cfg.Delivery.TTL)
+			switch {
+			case err != nil && err.Error() == user.ErrorUserDoesNotExist:
+				log.Warn().Msgf("%s of %s is not an user, skipping %s",
+					d.Type, d.MessageID, d.Email)
+			case apperr.IsRetryable(err):
+				log.Error().Err(err).Msgf("Recording %s of %s", d.Type,
+					d.MessageID)
+				return err
+			case err != nil:
+				log.Error().Err(err).Msgf("Discarding %s of %s", d.Type,
+					d.MessageID)
+			case d.Suppresses():
+				log.Warn().Msgf("Suppressed emails to %s after %s %s", d.Email,
+					d.Type, d.BounceType)
+			}
+		}
+	}
+
+	return nil
+}
+
+func initHandler(ctx context.Context, e events.SNSEvent) error {
+
+	var cfg configuration
+	err := config.Load(&cfg)
+	if err != nil {
+		return err
+	}
+
+	log.Debug().Msg("initHandler function")
+
+	sess, err := uaws.GetSession(cfg.AWS.Region)
+	if err != nil {
+		return err
+	}
+
+	return handler(ctx, uaws.GetDynamoDB(sess), e, cfg)
+}
+
+func main() {
+	lambda.Start(initHandler)
+}
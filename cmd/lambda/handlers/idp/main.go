@@ -0,0 +1,413 @@
+//Lambda function that makes this service an OpenID Connect provider for other
+//applications. It serves discovery, JWKS, authorize, token and userinfo
+package main
+
+import (
+	"context"
+	"encoding/base64"
+	"encoding/json"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+
+	"github.com/rs/zerolog/log"
+
+	"github.com/aws/aws-lambda-go/events"
+	"github.com/aws/aws-lambda-go/lambda"
+	"github.com/aws/aws-sdk-go/service/dynamodb/dynamodbiface"
+	"github.com/roloum/users/internal/apperr"
+	uaws "github.com/roloum/users/internal/aws"
+	"github.com/roloum/users/internal/config"
+	"github.com/roloum/users/internal/idp"
+	"github.com/roloum/users/internal/oidc"
+	"github.com/roloum/users/internal/user"
+)
+
+const (
+	//SessionCookie name of the cookie holding the session token
+	SessionCookie = "session"
+)
+
+type (
+
+	// errorResponse is the OAuth 2.0 error response
+	errorResponse struct {
+		Error       string `json:"error"`
+		Description string `json:"error_description,omitempty"`
+	}
+
+	// Response is of type APIGatewayProxyResponse since we're leveraging the
+	// AWS Lambda Proxy Request functionality (default behavior)
+	//
+	// https://serverless.com/framework/docs/providers/aws/events/apigateway/#lambda-proxy-integration
+	Response events.APIGatewayProxyResponse
+
+	configuration struct {
+		AWS struct {
+			DynamoDB struct {
+				Table struct {
+					User string `required:"true"`
+				}
+			}
+			Region string `required:"true"`
+		}
+		IDP struct {
+			Issuer    string        `required:"true"`
+			Key       string        `required:"true"`
+			KeyID     string        `default:"1"`
+			CodeTTL   time.Duration `default:"1m"`
+			AccessTTL time.Duration `default:"1h"`
+			IDTTL     time.Duration `default:"1h"`
+		}
+	}
+)
+
+// Handler is our lambda handler invoked by the `lambda.Start` function call
+func Handler(ctx context.Context, dynamoDB dynamodbiface.DynamoDBAPI,
+	provider *idp.Provider, request events.APIGatewayProxyRequest,
+	cfg configuration) (Response, error) {
+
+	log.Debug().Msgf("Resource: %s", request.Resource)
+
+	switch request.Resource {
+	case "/.well-known/openid-configuration":
+		return getResponse(http.StatusOK, provider.Discovery())
+	case "/oauth/jwks":
+		return getResponse(http.StatusOK, provider.JWKS())
+	case "/oauth/authorize":
+		return authorize(ctx, dynamoDB, request, cfg)
+	case "/oauth/token":
+		return token(ctx, dynamoDB, provider, request, cfg)
+	case "/oauth/userinfo":
+		return userInfo(ctx, dynamoDB, provider, request, cfg)
+	}
+
+	return getError(http.StatusNotFound, "invalid_request", "unknown endpoint")
+}
+
+// authorize issues an authorization code to the client for the user signed in
+// with a session, and redirects back to the client
+func authorize(ctx context.Context, dynamoDB dynamodbiface.DynamoDBAPI,
+	request events.APIGatewayProxyRequest, cfg configuration) (Response, error) {
+
+	q := request.QueryStringParameters
+
+	client, err := idp.LoadClient(ctx, dynamoDB, cfg.AWS.DynamoDB.Table.User,
+		q["client_id"])
+	if err != nil {
+		return getErrorFrom(err, http.StatusBadRequest, "invalid_request",
+			err.Error())
+	}
+
+	//Errors are only redirected once the redirect URI is known to be valid
+	if !client.ValidRedirectURI(q["redirect_uri"]) {
+		return getError(http.StatusBadRequest, "invalid_request",
+			"redirect_uri is not registered")
+	}
+
+	if q["response_type"] != "code" {
+		return redirect(q["redirect_uri"], url.Values{
+			"error": {"unsupported_response_type"}, "state": {q["state"]}})
+	}
+
+	if !hasScope(q["scope"], "openid") {
+		return redirect(q["redirect_uri"], url.Values{
+			"error": {"invalid_scope"}, "state": {q["state"]}})
+	}
+
+	u, err := user.LoadSession(ctx, dynamoDB, cfg.AWS.DynamoDB.Table.User,
+		sessionToken(request))
+	if err != nil {
+		log.Debug().Msg(err.Error())
+		return redirect(q["redirect_uri"], url.Values{
+			"error": {"login_required"}, "state": {q["state"]}})
+	}
+
+	code, err := oidc.RandomString(32)
+	if err != nil {
+		return getErrorFrom(err, http.StatusInternalServerError,
+			"server_error", "")
+	}
+
+	method := q["code_challenge_method"]
+	if q["code_challenge"] != "" && method == "" {
+		method = "plain"
+	}
+
+	authCode := &idp.AuthCode{
+		Code:          code,
+		ClientID:      client.ID,
+		Email:         u.Email,
+		RedirectURI:   q["redirect_uri"],
+		Scope:         q["scope"],
+		Nonce:         q["nonce"],
+		Challenge:     q["code_challenge"],
+		ChallengeType: method,
+	}
+	if err := authCode.Save(ctx, dynamoDB, cfg.AWS.DynamoDB.Table.User,
+		cfg.IDP.CodeTTL); err != nil {
+		return getErrorFrom(err, http.StatusInternalServerError,
+			"server_error", "")
+	}
+
+	log.Info().Msgf("Authorization code issued to client: %s", client.ID)
+
+	v := url.Values{"code": {code}}
+	if q["state"] != "" {
+		v.Set("state", q["state"])
+	}
+	return redirect(q["redirect_uri"], v)
+}
+
+// token exchanges an authorization code for the ID and access tokens
+func token(ctx context.Context, dynamoDB dynamodbiface.DynamoDBAPI,
+	provider *idp.Provider, request events.APIGatewayProxyRequest,
+	cfg configuration) (Response, error) {
+
+	body := request.Body
+	if request.IsBase64Encoded {
+		b, err := base64.StdEncoding.DecodeString(body)
+		if err != nil {
+			return getError(http.StatusBadRequest, "invalid_request", err.Error())
+		}
+		body = string(b)
+	}
+
+	form, err := url.ParseQuery(body)
+	if err != nil {
+		return getError(http.StatusBadRequest, "invalid_request", err.Error())
+	}
+
+	if form.Get("grant_type") != "authorization_code" {
+		return getError(http.StatusBadRequest, "unsupported_grant_type", "")
+	}
+
+	clientID, secret := clientCredentials(request, form)
+
+	client, err := idp.LoadClient(ctx, dynamoDB, cfg.AWS.DynamoDB.Table.User,
+		clientID)
+	if err != nil {
+		return getErrorFrom(err, http.StatusUnauthorized, "invalid_client", "")
+	}
+	if !client.Authenticate(secret) {
+		return getError(http.StatusUnauthorized, "invalid_client", "")
+	}
+
+	code, err := idp.ConsumeCode(ctx, dynamoDB, cfg.AWS.DynamoDB.Table.User,
+		form.Get("code"), client.ID)
+	if err != nil {
+		return getErrorFrom(err, http.StatusBadRequest, idp.ErrorInvalidGrant,
+			err.Error())
+	}
+
+	if code.RedirectURI != form.Get("redirect_uri") ||
+		!code.VerifyChallenge(form.Get("code_verifier")) {
+		return getError(http.StatusBadRequest, idp.ErrorInvalidGrant, "")
+	}
+
+	u := &user.User{Email: code.Email}
+	if err := u.Load(ctx, dynamoDB, cfg.AWS.DynamoDB.Table.User); err != nil {
+		return getErrorFrom(err, http.StatusBadRequest, idp.ErrorInvalidGrant,
+			err.Error())
+	}
+	if !u.Active {
+		return getError(http.StatusBadRequest, idp.ErrorInvalidGrant,
+			"user is deactivated")
+	}
+
+	tokens, err := provider.IssueTokens(u, code)
+	if err != nil {
+		return getErrorFrom(err, http.StatusInternalServerError,
+			"server_error", "")
+	}
+
+	log.Info().Msgf("Tokens issued to client: %s", client.ID)
+
+	return getResponse(http.StatusOK, tokens)
+}
+
+// userInfo returns the claims of the user owning the access token
+func userInfo(ctx context.Context, dynamoDB dynamodbiface.DynamoDBAPI,
+	provider *idp.Provider, request events.APIGatewayProxyRequest,
+	cfg configuration) (Response, error) {
+
+	claims, err := provider.VerifyAccessToken(bearerToken(request))
+	if err != nil {
+		return getError(http.StatusUnauthorized, idp.ErrorInvalidToken, "")
+	}
+
+	u := &user.User{Email: claims.Email}
+	if err := u.Load(ctx, dynamoDB, cfg.AWS.DynamoDB.Table.User); err != nil {
+		return getErrorFrom(err, http.StatusUnauthorized, idp.ErrorInvalidToken,
+			"")
+	}
+	if u.ID != claims.Subject || !u.Active {
+		return getError(http.StatusUnauthorized, idp.ErrorInvalidToken, "")
+	}
+
+	return getResponse(http.StatusOK, idp.NewUserInfo(u))
+}
+
+// clientCredentials reads the client credentials from the basic authorization
+// header or, failing that, from the form
+func clientCredentials(request events.APIGatewayProxyRequest,
+	form url.Values) (string, string) {
+
+	auth := header(request, "Authorization")
+	if strings.HasPrefix(auth, "Basic ") {
+		b, err := base64.StdEncoding.DecodeString(strings.TrimPrefix(auth, "Basic "))
+		if err == nil {
+			parts := strings.SplitN(string(b), ":", 2)
+			if len(parts) == 2 {
+				id, _ := url.QueryUnescape(parts[0])
+				secret, _ := url.QueryUnescape(parts[1])
+				return id, secret
+			}
+		}
+	}
+
+	return form.Get("client_id"), form.Get("client_secret")
+}
+
+// sessionToken reads the session from the bearer token or the session cookie
+func sessionToken(request events.APIGatewayProxyRequest) string {
+	if t := bearerToken(request); t != "" {
+		return t
+	}
+
+	r := http.Request{Header: http.Header{"Cookie": {header(request, "Cookie")}}}
+	if c, err := r.Cookie(SessionCookie); err == nil {
+		return c.Value
+	}
+	return ""
+}
+
+func bearerToken(request events.APIGatewayProxyRequest) string {
+	auth := header(request, "Authorization")
+	if strings.HasPrefix(auth, "Bearer ") {
+		return strings.TrimPrefix(auth, "Bearer ")
+	}
+	return ""
+}
+
+// header returns a request header regardless of its case
+func header(request events.APIGatewayProxyRequest, name string) string {
+	for k, v := range request.Headers {
+		if strings.EqualFold(k, name) {
+			return v
+		}
+	}
+	return ""
+}
+
+func hasScope(scope, value string) bool {
+	for _, s := range strings.Fields(scope) {
+		if s == value {
+			return true
+		}
+	}
+	return false
+}
+
+// redirect builds a redirection to the client with the given parameters
+func redirect(uri string, params url.Values) (Response, error) {
+	u, err := url.Parse(uri)
+	if err != nil {
+		return getError(http.StatusBadRequest, "invalid_request", err.Error())
+	}
+
+	q := u.Query()
+	for k, v := range params {
+		if len(v) > 0 && v[0] != "" {
+			q.Set(k, v[0])
+		}
+	}
+	u.RawQuery = q.Encode()
+
+	return Response{
+		StatusCode: http.StatusFound,
+		Headers: map[string]string{
+			"Location":      u.String(),
+			"Cache-Control": "no-store",
+		},
+	}, nil
+}
+
+// getErrorFrom builds the OAuth 2.0 error response for err. Infrastructure
+// faults are reported as temporarily_unavailable or server_error rather than
+// blaming the client
+func getErrorFrom(err error, statusCode int, code, description string) (
+	Response, error) {
+
+	e := apperr.From(err)
+	switch {
+	case e.Retryable:
+		log.Error().Err(err).Msg("Request failed")
+		return getError(http.StatusServiceUnavailable,
+			"temporarily_unavailable", "")
+	case e.Status >= http.StatusInternalServerError:
+		log.Error().Err(err).Msg("Request failed")
+		return getError(http.StatusInternalServerError, "server_error", "")
+	}
+
+	return getError(statusCode, code, description)
+}
+
+// getError builds an OAuth 2.0 error response
+func getError(statusCode int, code, description string) (Response, error) {
+	return getResponse(statusCode, &errorResponse{
+		Error:       code,
+		Description: description,
+	})
+}
+
+// getResponse builds an API Gateway Response
+func getResponse(statusCode int, body interface{}) (Response, error) {
+
+	headers := map[string]string{
+		"Content-Type":  "application/json",
+		"Cache-Control": "no-store",
+	}
+
+	js, err := json.Marshal(body)
+	if err != nil {
+		return Response{StatusCode: http.StatusInternalServerError}, err
+	}
+
+	log.Debug().Msgf("status_code: %d", statusCode)
+
+	return Response{Headers: headers, Body: string(js),
+		StatusCode: statusCode}, nil
+}
+
+func initHandler(ctx context.Context, request events.APIGatewayProxyRequest) (
+	Response, error) {
+
+	//Config holds the configuration for the application
+	var cfg configuration
+	err := config.Load(&cfg)
+	if err != nil {
+		return Response{}, err
+	}
+
+	log.Debug().Msg("initHandler function")
+
+	provider, err := idp.NewProvider(cfg.IDP.Issuer, cfg.IDP.KeyID,
+		cfg.IDP.Key, cfg.IDP.AccessTTL, cfg.IDP.IDTTL)
+	if err != nil {
+		return Response{}, err
+	}
+
+	sess, err := uaws.GetSession(cfg.AWS.Region)
+	if err != nil {
+		return Response{}, err
+	}
+
+	return Handler(ctx, uaws.GetDynamoDB(sess), provider, request, cfg)
+
+}
+
+func main() {
+	lambda.Start(initHandler)
+}
@@ -0,0 +1,183 @@
+package main
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/hex"
+	"encoding/json"
+	"encoding/pem"
+	"net/http"
+	"net/url"
+	"testing"
+	"time"
+
+	"github.com/aws/aws-lambda-go/events"
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/dynamodb"
+
+	"github.com/roloum/users/internal/idp"
+	"github.com/roloum/users/internal/test"
+	"github.com/roloum/users/internal/user"
+)
+
+const (
+	clientID     = "client"
+	clientSecret = "secret"
+	redirectURI  = "https://client.example.com/callback"
+	email        = "a@user.com"
+	userID       = "1234"
+)
+
+func newProvider(t *testing.T) *idp.Provider {
+	key, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatal(err)
+	}
+	keyPEM := pem.EncodeToMemory(&pem.Block{
+		Type:  "RSA PRIVATE KEY",
+		Bytes: x509.MarshalPKCS1PrivateKey(key),
+	})
+
+	p, err := idp.NewProvider("https://users.example.com/", "1",
+		string(keyPEM), time.Hour, time.Hour)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return p
+}
+
+func newTable(active bool) *test.MockTable {
+	sum := sha256.Sum256([]byte(clientSecret))
+
+	return &test.MockTable{
+		MockDynamoDB: &test.MockDynamoDB{
+			DeleteItemOutput: &dynamodb.DeleteItemOutput{
+				Attributes: map[string]*dynamodb.AttributeValue{
+					"clientId":    {S: aws.String(clientID)},
+					"email":       {S: aws.String(email)},
+					"redirectURI": {S: aws.String(redirectURI)},
+					"scope":       {S: aws.String("openid")},
+				},
+			},
+		},
+		Items: map[string]map[string]*dynamodb.AttributeValue{
+			idp.DynamoDBPrefixClient: {
+				"id":           {S: aws.String(clientID)},
+				"secretHash":   {S: aws.String(hex.EncodeToString(sum[:]))},
+				"redirectURIs": {SS: []*string{aws.String(redirectURI)}},
+			},
+			user.DynamoDBPrefixProfile: {
+				"id":     {S: aws.String(userID)},
+				"email":  {S: aws.String(email)},
+				"active": {BOOL: aws.Bool(active)},
+			},
+		},
+	}
+}
+
+//TestHandlerToken Tests that tokens are only issued to active users
+func TestHandlerToken(t *testing.T) {
+
+	var cfg configuration
+	cfg.AWS.DynamoDB.Table.User = "User"
+
+	provider := newProvider(t)
+
+	form := url.Values{
+		"grant_type":    {"authorization_code"},
+		"code":          {"code"},
+		"redirect_uri":  {redirectURI},
+		"client_id":     {clientID},
+		"client_secret": {clientSecret},
+	}
+	request := events.APIGatewayProxyRequest{Resource: "/oauth/token",
+		Path: "/oauth/token", HTTPMethod: http.MethodPost, Body: form.Encode()}
+
+	tests := []struct {
+		desc   string
+		active bool
+		status int
+		error  string
+	}{
+		{desc: "Active", active: true, status: http.StatusOK},
+		{desc: "Deactivated", status: http.StatusBadRequest,
+			error: idp.ErrorInvalidGrant},
+	}
+
+	for _, tc := range tests {
+		t.Run(tc.desc, func(t *testing.T) {
+			response, err := Handler(context.Background(), newTable(tc.active),
+				provider, request, cfg)
+			if err != nil {
+				t.Fatalf("Expected: %v. Received: %v", nil, err)
+			}
+			if response.StatusCode != tc.status {
+				t.Fatalf("Expected: %v. Received: %v %v", tc.status,
+					response.StatusCode, response.Body)
+			}
+
+			var body errorResponse
+			if err := json.Unmarshal([]byte(response.Body), &body); err != nil {
+				t.Fatal(err)
+			}
+			if body.Error != tc.error {
+				t.Errorf("Expected: %v. Received: %v", tc.error, body.Error)
+			}
+		})
+	}
+}
+
+//TestHandlerUserInfo Tests that the claims are only returned for active users
+func TestHandlerUserInfo(t *testing.T) {
+
+	var cfg configuration
+	cfg.AWS.DynamoDB.Table.User = "User"
+
+	provider := newProvider(t)
+
+	tokens, err := provider.IssueTokens(&user.User{ID: userID, Email: email},
+		&idp.AuthCode{ClientID: clientID, Scope: "openid"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	request := events.APIGatewayProxyRequest{Resource: "/oauth/userinfo",
+		Path: "/oauth/userinfo", HTTPMethod: http.MethodGet,
+		Headers: map[string]string{
+			"Authorization": "Bearer " + tokens.AccessToken}}
+
+	tests := []struct {
+		desc   string
+		active bool
+		status int
+		error  string
+	}{
+		{desc: "Active", active: true, status: http.StatusOK},
+		{desc: "Deactivated", status: http.StatusUnauthorized,
+			error: idp.ErrorInvalidToken},
+	}
+
+	for _, tc := range tests {
+		t.Run(tc.desc, func(t *testing.T) {
+			response, err := Handler(context.Background(), newTable(tc.active),
+				provider, request, cfg)
+			if err != nil {
+				t.Fatalf("Expected: %v. Received: %v", nil, err)
+			}
+			if response.StatusCode != tc.status {
+				t.Fatalf("Expected: %v. Received: %v %v", tc.status,
+					response.StatusCode, response.Body)
+			}
+
+			var body errorResponse
+			if err := json.Unmarshal([]byte(response.Body), &body); err != nil {
+				t.Fatal(err)
+			}
+			if body.Error != tc.error {
+				t.Errorf("Expected: %v. Received: %v", tc.error, body.Error)
+			}
+		})
+	}
+}
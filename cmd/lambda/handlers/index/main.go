@@ -0,0 +1,61 @@
+//Lambda function that keeps the Bleve search index up to date with the user
+//profiles. It consumes the DynamoDB stream alongside notifyUser:
+// - INSERT and MODIFY of a profile row index the new image
+// - REMOVE of a profile row deletes the user from the index
+//The index lives on the EFS file system mounted at USERS_SEARCH_INDEX_PATH.
+//While `users index rebuild` runs, the records are journaled for the new index
+package main
+
+import (
+	"context"
+
+	"github.com/rs/zerolog/log"
+
+	"github.com/aws/aws-lambda-go/events"
+	"github.com/aws/aws-lambda-go/lambda"
+	"github.com/roloum/users/internal/config"
+	"github.com/roloum/users/internal/search"
+)
+
+type configuration struct {
+	Search struct {
+		Index struct {
+			Path string `required:"true"`
+		}
+	}
+}
+
+//handler applies the batch of records. An error makes Lambda retry the batch,
+//which is safe since indexing a profile twice is idempotent
+func handler(ctx context.Context, e events.DynamoDBEvent,
+	cfg configuration) error {
+
+	//The index is opened for each batch so searches, and a rebuild, can open
+	//it in between
+	count, err := search.ApplyTo(cfg.Search.Index.Path, e.Records)
+	if err != nil {
+		log.Error().Msg(err.Error())
+		return err
+	}
+
+	log.Info().Msgf("Indexed %d of %d records", count, len(e.Records))
+
+	return nil
+}
+
+func initHandler(ctx context.Context, e events.DynamoDBEvent) error {
+
+	var cfg configuration
+	err := config.Load(&cfg)
+	if err != nil {
+		return err
+	}
+
+	log.Debug().Msg("initHandler function")
+
+	return handler(ctx, e, cfg)
+}
+
+func main() {
+	lambda.Start(initHandler)
+}
@@ -0,0 +1,272 @@
+//Lambda function that signs in an user with a magic link:
+// - POST requests a link that is mailed by the notify function
+// - GET exchanges the link for a session. Users with MFA enabled get a session
+//   that requires the MFA code
+// - POST /users/magic/mfa {"token": "...", "code": "123456"} exchanges that
+//   session and the code for a full session
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"time"
+
+	"github.com/rs/zerolog/log"
+
+	"github.com/aws/aws-lambda-go/events"
+	"github.com/aws/aws-lambda-go/lambda"
+	"github.com/aws/aws-sdk-go/service/dynamodb/dynamodbiface"
+	"github.com/roloum/users/internal/apperr"
+	uaws "github.com/roloum/users/internal/aws"
+	"github.com/roloum/users/internal/config"
+	"github.com/roloum/users/internal/user"
+)
+
+const (
+	//MsgMagicLinkSent message returned when the magic link is requested
+	MsgMagicLinkSent = "MagicLinkSent"
+
+	//MsgSignedIn message returned when the magic link is exchanged
+	MsgSignedIn = "SignedIn"
+
+	//MsgMFARequired message returned when the session requires the MFA code
+	MsgMFARequired = "MFARequired"
+
+	//ErrorEmailIsEmpty message returned if email is empty
+	ErrorEmailIsEmpty = "EmailIsEmpty"
+
+	//ErrorTokenIsEmpty message returned if token is empty
+	ErrorTokenIsEmpty = "TokenIsEmpty"
+
+	//ErrorCodeIsEmpty message returned if the MFA code is empty
+	ErrorCodeIsEmpty = "CodeIsEmpty"
+)
+
+func init() {
+	apperr.Register(http.StatusUnprocessableEntity, ErrorTokenIsEmpty,
+		ErrorCodeIsEmpty)
+}
+
+type (
+	// magicRequest
+	magicRequest struct {
+		Email string `json:"email,omitempty"`
+	}
+
+	// mfaRequest is the session waiting for the MFA code, and the code
+	mfaRequest struct {
+		Token string `json:"token"`
+		Code  string `json:"code"`
+	}
+
+	// magicResponse
+	magicResponse struct {
+		StatusCode int           `json:"status"`
+		Message    string        `json:"message"`
+		Session    *user.Session `json:"session,omitempty"`
+	}
+
+	// Response is of type APIGatewayProxyResponse since we're leveraging the
+	// AWS Lambda Proxy Request functionality (default behavior)
+	//
+	// https://serverless.com/framework/docs/providers/aws/events/apigateway/#lambda-proxy-integration
+	Response events.APIGatewayProxyResponse
+
+	configuration struct {
+		AWS struct {
+			DynamoDB struct {
+				Table struct {
+					User string `required:"true"`
+				}
+			}
+			Region string `required:"true"`
+		}
+		Magic struct {
+			TTL time.Duration `default:"15m"`
+			Key string        `required:"true"`
+		}
+		Session struct {
+			TTL time.Duration `default:"24h"`
+		}
+		MFA struct {
+			Key string
+		}
+	}
+)
+
+// Handler is our lambda handler invoked by the `lambda.Start` function call
+func Handler(ctx context.Context, dynamoDB dynamodbiface.DynamoDBAPI,
+	request events.APIGatewayProxyRequest,
+	cfg configuration) (Response, error) {
+
+	if request.HTTPMethod == http.MethodPost {
+		if request.Resource == "/users/magic/mfa" {
+			return completeMFA(ctx, dynamoDB, request, cfg)
+		}
+		return requestLink(ctx, dynamoDB, request, cfg)
+	}
+
+	return exchangeLink(ctx, dynamoDB, request, cfg)
+}
+
+// requestLink inserts the magic link row. The response does not reveal
+// whether the account exists
+func requestLink(ctx context.Context, dynamoDB dynamodbiface.DynamoDBAPI,
+	request events.APIGatewayProxyRequest,
+	cfg configuration) (Response, error) {
+
+	log.Debug().Msg("Unmarshalling request")
+	var body magicRequest
+	if err := json.Unmarshal([]byte(request.Body), &body); err != nil {
+		return getProblem(err, request)
+	}
+
+	if body.Email == "" {
+		return getProblem(errors.New(ErrorEmailIsEmpty), request)
+	}
+
+	u := &user.User{
+		Email: body.Email,
+	}
+
+	err := u.RequestMagicLink(ctx, dynamoDB, cfg.AWS.DynamoDB.Table.User,
+		cfg.Magic.Key, cfg.Magic.TTL)
+	if err != nil && err.Error() != user.ErrorUserDoesNotExist {
+		return getProblem(err, request)
+	}
+
+	log.Info().Msg("Magic link requested")
+
+	return getResponse(http.StatusAccepted, MsgMagicLinkSent, nil)
+}
+
+// exchangeLink exchanges the magic link for a session, or for the session
+// waiting for the MFA code of the accounts with MFA enabled
+func exchangeLink(ctx context.Context, dynamoDB dynamodbiface.DynamoDBAPI,
+	request events.APIGatewayProxyRequest,
+	cfg configuration) (Response, error) {
+
+	email := request.QueryStringParameters["email"]
+	if email == "" {
+		return getProblem(errors.New(ErrorEmailIsEmpty), request)
+	}
+
+	token := request.QueryStringParameters["token"]
+	if token == "" {
+		return getProblem(errors.New(ErrorTokenIsEmpty), request)
+	}
+
+	log.Info().Msgf("Signing in with magic link: %s", email)
+
+	u := &user.User{
+		Email: email,
+	}
+
+	if err := u.CheckMagicLink(ctx, dynamoDB, cfg.AWS.DynamoDB.Table.User,
+		token); err != nil {
+		return getProblem(err, request)
+	}
+
+	session, err := u.ExchangeMagicLink(ctx, dynamoDB,
+		cfg.AWS.DynamoDB.Table.User, token, cfg.Session.TTL)
+	if err != nil {
+		return getProblem(err, request)
+	}
+
+	if session.MFARequired {
+		log.Info().Msg("MFA code required")
+		return getResponse(http.StatusOK, MsgMFARequired, session)
+	}
+
+	log.Info().Msg("User signed in")
+
+	return getResponse(http.StatusOK, MsgSignedIn, session)
+}
+
+// completeMFA exchanges the session waiting for the MFA code for a full one.
+// The code goes in the body so it is not logged with the URL
+func completeMFA(ctx context.Context, dynamoDB dynamodbiface.DynamoDBAPI,
+	request events.APIGatewayProxyRequest, cfg configuration) (Response, error) {
+
+	log.Debug().Msg("Unmarshalling request")
+	var body mfaRequest
+	if err := json.Unmarshal([]byte(request.Body), &body); err != nil {
+		return getProblem(err, request)
+	}
+
+	if body.Token == "" {
+		return getProblem(errors.New(ErrorTokenIsEmpty), request)
+	}
+	if body.Code == "" {
+		return getProblem(errors.New(ErrorCodeIsEmpty), request)
+	}
+
+	session, err := user.CompleteMFA(ctx, dynamoDB,
+		cfg.AWS.DynamoDB.Table.User, body.Token, cfg.MFA.Key, body.Code,
+		cfg.Session.TTL)
+	if err != nil {
+		return getProblem(err, request)
+	}
+
+	log.Info().Msg("User signed in")
+
+	return getResponse(http.StatusOK, MsgSignedIn, session)
+}
+
+// getProblem builds the application/problem+json response of err
+func getProblem(err error, request events.APIGatewayProxyRequest) (
+	Response, error) {
+	return Response(apperr.Response(err, request.Path)), nil
+}
+
+// getResponse builds an API Gateway Response
+func getResponse(statusCode int, message string, s *user.Session) (
+	Response, error) {
+
+	headers := map[string]string{
+		"Content-Type": "application/json",
+	}
+
+	resp := &magicResponse{
+		StatusCode: statusCode,
+		Message:    message,
+		Session:    s,
+	}
+
+	js, err := json.Marshal(resp)
+	if err != nil {
+		return Response{StatusCode: http.StatusInternalServerError}, err
+	}
+
+	log.Debug().Msgf("status_code: %d, message: %s", resp.StatusCode, resp.Message)
+
+	return Response{Headers: headers, Body: string(js),
+		StatusCode: resp.StatusCode}, nil
+}
+
+func initHandler(ctx context.Context, request events.APIGatewayProxyRequest) (
+	Response, error) {
+
+	//Config holds the configuration for the application
+	var cfg configuration
+	err := config.Load(&cfg)
+	if err != nil {
+		return Response{}, err
+	}
+
+	log.Debug().Msg("initHandler function")
+
+	sess, err := uaws.GetSession(cfg.AWS.Region)
+	if err != nil {
+		return Response{}, err
+	}
+
+	return Handler(ctx, uaws.GetDynamoDB(sess), request, cfg)
+
+}
+
+func main() {
+	lambda.Start(initHandler)
+}
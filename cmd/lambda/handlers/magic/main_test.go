@@ -0,0 +1,93 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"strconv"
+	"testing"
+	"time"
+
+	"github.com/aws/aws-lambda-go/events"
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/dynamodb"
+
+	"github.com/roloum/users/internal/test"
+	"github.com/roloum/users/internal/user"
+)
+
+//TestHandlerMFA Tests that the magic link of an account with MFA is exchanged
+//for a session waiting for the code, sent in the body of another request
+func TestHandlerMFA(t *testing.T) {
+
+	var cfg configuration
+	cfg.AWS.DynamoDB.Table.User = "User"
+	cfg.Session.TTL = time.Hour
+
+	exchange := events.APIGatewayProxyRequest{Resource: "/users/magic",
+		Path: "/users/magic", HTTPMethod: http.MethodGet,
+		QueryStringParameters: map[string]string{"email": "a@user.com",
+			"token": "token"}}
+
+	tests := []struct {
+		desc    string
+		request events.APIGatewayProxyRequest
+		mfa     bool
+		status  int
+		message string
+	}{
+		{desc: "SignedIn", request: exchange, status: http.StatusOK,
+			message: MsgSignedIn},
+		{desc: "MFARequired", request: exchange, mfa: true,
+			status: http.StatusOK, message: MsgMFARequired},
+		{desc: ErrorCodeIsEmpty, request: events.APIGatewayProxyRequest{
+			Resource: "/users/magic/mfa", Path: "/users/magic/mfa",
+			HTTPMethod: http.MethodPost, Body: `{"token": "session"}`},
+			status: http.StatusUnprocessableEntity},
+	}
+
+	for _, tc := range tests {
+		t.Run(tc.desc, func(t *testing.T) {
+			mock := &test.MockTable{MockDynamoDB: &test.MockDynamoDB{},
+				Items: map[string]map[string]*dynamodb.AttributeValue{
+					user.DynamoDBPrefixMagic: {
+						"ttl": {N: aws.String(strconv.FormatInt(
+							time.Now().Add(time.Hour).Unix(), 10))},
+					},
+					user.DynamoDBPrefixProfile: {
+						"email":  {S: aws.String("a@user.com")},
+						"active": {BOOL: aws.Bool(true)},
+					},
+				}}
+			if tc.mfa {
+				mock.Items[user.DynamoDBPrefixMFA] = map[string]*dynamodb.AttributeValue{
+					"confirmed": {BOOL: aws.Bool(true)},
+				}
+			}
+
+			response, err := Handler(context.Background(), mock, tc.request, cfg)
+			if err != nil {
+				t.Fatalf("Expected: %v. Received: %v", nil, err)
+			}
+			if response.StatusCode != tc.status {
+				t.Fatalf("Expected: %v. Received: %v %v", tc.status,
+					response.StatusCode, response.Body)
+			}
+			if tc.message == "" {
+				return
+			}
+
+			var body magicResponse
+			if err := json.Unmarshal([]byte(response.Body), &body); err != nil {
+				t.Fatal(err)
+			}
+			if body.Message != tc.message {
+				t.Errorf("Expected: %v. Received: %v", tc.message, body.Message)
+			}
+			if body.Session == nil || body.Session.MFARequired != tc.mfa {
+				t.Errorf("Expected mfaRequired: %v. Received: %+v", tc.mfa,
+					body.Session)
+			}
+		})
+	}
+}
@@ -0,0 +1,105 @@
+//Lambda function that sends the emails queued by the notify function when
+//USERS_NOTIFY_QUEUE_BACKEND=sqs. It is subscribed to the mail queue:
+// - the emails are sent at the USERS_NOTIFY_QUEUE_RATE per second, the
+//   MaxSendRate of the SES quota by default. The function runs one at a time
+//   so the rate holds for the account
+// - jobs past their expiration, such as magic links, are dropped unsent
+// - jobs scheduled later than the SQS delay allows are enqueued again
+// - throttled and transient failures are retried with a jittered backoff,
+//   the others and the jobs failing USERS_NOTIFY_QUEUE_MAXATTEMPTS times are
+//   moved to the dead-letter queue
+package main
+
+import (
+	"context"
+
+	"github.com/rs/zerolog/log"
+
+	"github.com/aws/aws-lambda-go/events"
+	"github.com/aws/aws-lambda-go/lambda"
+	"github.com/aws/aws-sdk-go/service/ses"
+	"github.com/aws/aws-sdk-go/service/sqs"
+	uaws "github.com/roloum/users/internal/aws"
+	"github.com/roloum/users/internal/config"
+	"github.com/roloum/users/internal/notify"
+)
+
+type configuration struct {
+	AWS struct {
+		Region string `required:"true"`
+	}
+	Email struct {
+		Sender string `required:"true"`
+	}
+	Notify struct {
+		Queue notify.QueueConfig
+	}
+}
+
+//limiter is kept between the invocations of a warm function
+var limiter *notify.Limiter
+
+//handler sends the jobs of the event. Every job is deleted once handled, so
+//when a queue operation fails and the batch is received again only the jobs
+//left are sent. Messages that are not jobs are dropped
+func handler(ctx context.Context, worker *notify.Worker,
+	e events.SQSEvent) error {
+
+	for _, record := range e.Records {
+
+		job, err := notify.ParseJob(record.Body, record.ReceiptHandle)
+		if err != nil {
+			log.Warn().Msgf("Discarding message %s: %s", record.MessageId,
+				err.Error())
+			continue
+		}
+
+		if err := worker.Process(ctx, job); err != nil {
+			log.Error().Err(err).Msgf("Processing job %s", job.ID)
+			return err
+		}
+	}
+
+	return nil
+}
+
+func initHandler(ctx context.Context, e events.SQSEvent) error {
+
+	var cfg configuration
+	err := config.Load(&cfg)
+	if err != nil {
+		return err
+	}
+
+	log.Debug().Msg("initHandler function")
+
+	sess, err := uaws.GetSession(cfg.AWS.Region)
+	if err != nil {
+		return err
+	}
+
+	svc := ses.New(sess)
+
+	if limiter == nil {
+		rate := cfg.Notify.Queue.Rate
+		if rate == 0 {
+			if rate, err = notify.SendRate(ctx, svc); err != nil {
+				return err
+			}
+			log.Info().Msgf("Sending at the SES rate of %v emails per second",
+				rate)
+		}
+		limiter = notify.NewLimiter(rate)
+	}
+
+	queue := notify.NewSQS(sqs.New(sess), cfg.Notify.Queue.URL,
+		cfg.Notify.Queue.DeadLetterURL)
+	worker := notify.NewWorker(queue, notify.NewEmail(svc, cfg.Email.Sender),
+		limiter, cfg.Notify.Queue)
+
+	return handler(ctx, worker, e)
+}
+
+func main() {
+	lambda.Start(initHandler)
+}
@@ -0,0 +1,468 @@
+//Lambda function serving the endpoints of the signed in user. Requests are
+//authenticated with a session or a personal API key:
+// - GET /users/me returns the profile
+// - DELETE /users/me erases the account
+// - GET /users/me/export exports everything stored about the user
+// - GET|POST /users/me/apikeys lists and creates API keys
+// - DELETE /users/me/apikeys/{prefix} revokes an API key
+// - POST /users/me/avatar presigns the upload of an avatar image, processed
+//   by avatarUser
+// - DELETE /users/me/avatar removes the avatar
+// - GET|PUT /users/me/preferences reads and saves the notification
+//   categories and channels, locale, timezone and marketing consent
+// - POST /users/me/phone sends a verification code to a phone number by SMS,
+//   POST /users/me/phone/verify checks it, DELETE /users/me/phone removes it
+// - POST /users/me/mfa enrolls an authenticator app, POST /users/me/mfa/confirm
+//   checks its first code, enables MFA and returns the recovery codes
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"time"
+
+	"github.com/rs/zerolog/log"
+
+	"github.com/aws/aws-lambda-go/events"
+	"github.com/aws/aws-lambda-go/lambda"
+	"github.com/aws/aws-sdk-go/service/dynamodb/dynamodbiface"
+	"github.com/roloum/users/internal/apperr"
+	"github.com/roloum/users/internal/auth"
+	"github.com/roloum/users/internal/avatar"
+	uaws "github.com/roloum/users/internal/aws"
+	"github.com/roloum/users/internal/config"
+	"github.com/roloum/users/internal/user"
+)
+
+const (
+	//MsgOK message returned when the request succeeds
+	MsgOK = "OK"
+
+	//MsgAPIKeyCreated message returned when an API key is created
+	MsgAPIKeyCreated = "APIKeyCreated"
+
+	//MsgAPIKeyRevoked message returned when an API key is revoked
+	MsgAPIKeyRevoked = "APIKeyRevoked"
+
+	//MsgUserErased message returned when the account is erased
+	MsgUserErased = "UserErased"
+
+	//MsgAvatarUpload message returned with the presigned upload of an avatar
+	MsgAvatarUpload = "AvatarUpload"
+
+	//MsgAvatarRemoved message returned when the avatar is removed
+	MsgAvatarRemoved = "AvatarRemoved"
+
+	//MsgPreferencesSaved message returned when the preferences are saved
+	MsgPreferencesSaved = "PreferencesSaved"
+
+	//MsgPhoneCodeSent message returned when the verification code is sent
+	MsgPhoneCodeSent = "PhoneCodeSent"
+
+	//MsgPhoneVerified message returned when the phone is verified
+	MsgPhoneVerified = "PhoneVerified"
+
+	//MsgPhoneRemoved message returned when the phone is removed
+	MsgPhoneRemoved = "PhoneRemoved"
+
+	//MsgMFAEnrolled message returned with the secret of the authenticator app
+	MsgMFAEnrolled = "MFAEnrolled"
+
+	//MsgMFAEnabled message returned with the recovery codes once MFA is
+	//enabled
+	MsgMFAEnabled = "MFAEnabled"
+
+	//ErasureReasonSelfService reason recorded in the tombstone when the user
+	//erases the account
+	ErasureReasonSelfService = "SelfService"
+
+	//ErrorSessionRequired message returned when an API key is used to manage
+	//API keys
+	ErrorSessionRequired = "SessionRequired"
+
+	//ErrorUnknownEndpoint message returned for an unknown path or method
+	ErrorUnknownEndpoint = "UnknownEndpoint"
+)
+
+func init() {
+	apperr.Register(http.StatusForbidden, ErrorSessionRequired)
+	apperr.Register(http.StatusNotFound, ErrorUnknownEndpoint)
+}
+
+type (
+	// apiKeyRequest
+	apiKeyRequest struct {
+		Name      string   `json:"name"`
+		Scopes    []string `json:"scopes"`
+		ExpiresIn int64    `json:"expiresIn"`
+	}
+
+	// avatarRequest
+	avatarRequest struct {
+		ContentType string `json:"contentType"`
+	}
+
+	// phoneRequest
+	phoneRequest struct {
+		Phone string `json:"phone"`
+		Code  string `json:"code"`
+	}
+
+	// mfaRequest is the first code of the authenticator app
+	mfaRequest struct {
+		Code string `json:"code"`
+	}
+
+	// meResponse
+	meResponse struct {
+		StatusCode  int               `json:"status"`
+		Message     string            `json:"message"`
+		User        *user.User        `json:"user,omitempty"`
+		APIKeys     []*user.APIKey    `json:"apiKeys,omitempty"`
+		APIKey      *user.APIKey      `json:"apiKey,omitempty"`
+		Secret      string            `json:"secret,omitempty"`
+		Archive     *user.Archive     `json:"archive,omitempty"`
+		Upload      *avatar.Upload    `json:"upload,omitempty"`
+		Preferences *user.Preferences `json:"preferences,omitempty"`
+
+		MFA           *user.MFAEnrollment `json:"mfa,omitempty"`
+		RecoveryCodes []string            `json:"recoveryCodes,omitempty"`
+	}
+
+	// Response is of type APIGatewayProxyResponse since we're leveraging the
+	// AWS Lambda Proxy Request functionality (default behavior)
+	//
+	// https://serverless.com/framework/docs/providers/aws/events/apigateway/#lambda-proxy-integration
+	Response events.APIGatewayProxyResponse
+
+	configuration struct {
+		AWS struct {
+			DynamoDB struct {
+				Table struct {
+					User string `required:"true"`
+				}
+			}
+			Region string `required:"true"`
+		}
+		Avatar avatar.Config
+		Phone  struct {
+			Key string        `required:"true"`
+			TTL time.Duration `default:"10m"`
+		}
+		MFA struct {
+			Key    string `required:"true"`
+			Issuer string `default:"users"`
+		}
+	}
+)
+
+// Handler is our lambda handler invoked by the `lambda.Start` function call
+func Handler(ctx context.Context, dynamoDB dynamodbiface.DynamoDBAPI,
+	storage avatar.Storage, request events.APIGatewayProxyRequest,
+	cfg configuration) (Response, error) {
+
+	p, err := auth.Authenticate(ctx, dynamoDB, cfg.AWS.DynamoDB.Table.User,
+		request.Headers)
+	if err != nil {
+		return getProblem(err, request)
+	}
+
+	log.Info().Msgf("%s %s: %s", request.HTTPMethod, request.Resource,
+		p.User.Email)
+
+	switch request.Resource + " " + request.HTTPMethod {
+	case "/users/me GET":
+		if err := p.Require(auth.ScopeProfileRead); err != nil {
+			return getProblem(err, request)
+		}
+		schema, err := user.GetSchema(ctx, dynamoDB,
+			cfg.AWS.DynamoDB.Table.User)
+		if err != nil {
+			return getProblem(err, request)
+		}
+		p.User.Attributes = schema.Visible(p.User.Attributes,
+			user.VisibilityPrivate)
+		return getResponse(http.StatusOK, &meResponse{Message: MsgOK, User: p.User})
+
+	case "/users/me DELETE":
+		if p.APIKey != nil {
+			return getProblem(errors.New(ErrorSessionRequired), request)
+		}
+		if err := avatar.Purge(ctx, storage, dynamoDB,
+			cfg.AWS.DynamoDB.Table.User, p.User); err != nil {
+			return getProblem(err, request)
+		}
+		if err := p.User.Erase(ctx, dynamoDB, cfg.AWS.DynamoDB.Table.User,
+			ErasureReasonSelfService); err != nil {
+			return getProblem(err, request)
+		}
+		return getResponse(http.StatusOK, &meResponse{Message: MsgUserErased})
+
+	case "/users/me/export GET":
+		if err := p.Require(auth.ScopeProfileRead); err != nil {
+			return getProblem(err, request)
+		}
+		archive, err := p.User.Export(ctx, dynamoDB, cfg.AWS.DynamoDB.Table.User)
+		if err != nil {
+			return getProblem(err, request)
+		}
+		return getResponse(http.StatusOK, &meResponse{Message: MsgOK,
+			Archive: archive})
+
+	case "/users/me/apikeys GET":
+		if err := p.Require(auth.ScopeProfileRead); err != nil {
+			return getProblem(err, request)
+		}
+		keys, err := p.User.ListAPIKeys(ctx, dynamoDB, cfg.AWS.DynamoDB.Table.User)
+		if err != nil {
+			return getProblem(err, request)
+		}
+		return getResponse(http.StatusOK, &meResponse{Message: MsgOK, APIKeys: keys})
+
+	case "/users/me/apikeys POST":
+		return createAPIKey(ctx, dynamoDB, p, request, cfg)
+
+	case "/users/me/apikeys/{prefix} DELETE":
+		if p.APIKey != nil {
+			return getProblem(errors.New(ErrorSessionRequired), request)
+		}
+		if err := p.User.RevokeAPIKey(ctx, dynamoDB, cfg.AWS.DynamoDB.Table.User,
+			request.PathParameters["prefix"]); err != nil {
+			return getProblem(err, request)
+		}
+		return getResponse(http.StatusOK, &meResponse{Message: MsgAPIKeyRevoked})
+
+	case "/users/me/avatar POST":
+		if err := p.Require(auth.ScopeProfileWrite); err != nil {
+			return getProblem(err, request)
+		}
+		var body avatarRequest
+		if err := json.Unmarshal([]byte(request.Body), &body); err != nil {
+			return getProblem(err, request)
+		}
+		upload, err := avatar.RequestUpload(ctx, storage, cfg.Avatar, p.User,
+			body.ContentType)
+		if err != nil {
+			return getProblem(err, request)
+		}
+		return getResponse(http.StatusOK, &meResponse{Message: MsgAvatarUpload,
+			Upload: upload})
+
+	case "/users/me/avatar DELETE":
+		if err := p.Require(auth.ScopeProfileWrite); err != nil {
+			return getProblem(err, request)
+		}
+		if err := avatar.Remove(ctx, storage, dynamoDB,
+			cfg.AWS.DynamoDB.Table.User, p.User); err != nil {
+			return getProblem(err, request)
+		}
+		return getResponse(http.StatusOK, &meResponse{Message: MsgAvatarRemoved})
+
+	case "/users/me/preferences GET":
+		if err := p.Require(auth.ScopeProfileRead); err != nil {
+			return getProblem(err, request)
+		}
+		prefs, err := p.User.LoadPreferences(ctx, dynamoDB,
+			cfg.AWS.DynamoDB.Table.User)
+		if err != nil {
+			return getProblem(err, request)
+		}
+		return getResponse(http.StatusOK, &meResponse{Message: MsgOK,
+			Preferences: prefs})
+
+	case "/users/me/preferences PUT":
+		if err := p.Require(auth.ScopeProfileWrite); err != nil {
+			return getProblem(err, request)
+		}
+		var prefs user.Preferences
+		if err := json.Unmarshal([]byte(request.Body), &prefs); err != nil {
+			return getProblem(err, request)
+		}
+		if err := p.User.SavePreferences(ctx, dynamoDB,
+			cfg.AWS.DynamoDB.Table.User, &prefs); err != nil {
+			return getProblem(err, request)
+		}
+		return getResponse(http.StatusOK, &meResponse{
+			Message: MsgPreferencesSaved, Preferences: &prefs})
+
+	case "/users/me/phone POST", "/users/me/phone/verify POST":
+		return phone(ctx, dynamoDB, p, request, cfg)
+
+	case "/users/me/phone DELETE":
+		if err := p.Require(auth.ScopeProfileWrite); err != nil {
+			return getProblem(err, request)
+		}
+		if err := p.User.RemovePhone(ctx, dynamoDB,
+			cfg.AWS.DynamoDB.Table.User); err != nil {
+			return getProblem(err, request)
+		}
+		return getResponse(http.StatusOK, &meResponse{Message: MsgPhoneRemoved})
+
+	case "/users/me/mfa POST", "/users/me/mfa/confirm POST":
+		return mfa(ctx, dynamoDB, p, request, cfg)
+	}
+
+	return getProblem(errors.New(ErrorUnknownEndpoint), request)
+}
+
+// createAPIKey creates an API key. Keys can only be created with a session, so
+// a leaked key can not be used to mint new ones
+func createAPIKey(ctx context.Context, dynamoDB dynamodbiface.DynamoDBAPI,
+	p *auth.Principal, request events.APIGatewayProxyRequest,
+	cfg configuration) (Response, error) {
+
+	if p.APIKey != nil {
+		return getProblem(errors.New(ErrorSessionRequired), request)
+	}
+
+	log.Debug().Msg("Unmarshalling request")
+	var body apiKeyRequest
+	if err := json.Unmarshal([]byte(request.Body), &body); err != nil {
+		return getProblem(err, request)
+	}
+
+	if err := auth.CheckSelfServiceScopes(body.Scopes); err != nil {
+		return getProblem(err, request)
+	}
+
+	k, secret, err := p.User.CreateAPIKey(ctx, dynamoDB,
+		cfg.AWS.DynamoDB.Table.User, body.Name, body.Scopes,
+		time.Duration(body.ExpiresIn)*time.Second)
+	if err != nil {
+		return getProblem(err, request)
+	}
+
+	log.Info().Msg("API key created")
+
+	return getResponse(http.StatusCreated, &meResponse{Message: MsgAPIKeyCreated,
+		APIKey: k, Secret: secret})
+}
+
+// phone requests the verification code of a phone number, sent by SMS by the
+// notify function, or checks it
+func phone(ctx context.Context, dynamoDB dynamodbiface.DynamoDBAPI,
+	p *auth.Principal, request events.APIGatewayProxyRequest,
+	cfg configuration) (Response, error) {
+
+	if err := p.Require(auth.ScopeProfileWrite); err != nil {
+		return getProblem(err, request)
+	}
+
+	log.Debug().Msg("Unmarshalling request")
+	var body phoneRequest
+	if err := json.Unmarshal([]byte(request.Body), &body); err != nil {
+		return getProblem(err, request)
+	}
+
+	if request.Resource == "/users/me/phone" {
+		if err := p.User.RequestPhoneVerification(ctx, dynamoDB,
+			cfg.AWS.DynamoDB.Table.User, body.Phone, cfg.Phone.Key,
+			cfg.Phone.TTL); err != nil {
+			return getProblem(err, request)
+		}
+		return getResponse(http.StatusAccepted,
+			&meResponse{Message: MsgPhoneCodeSent})
+	}
+
+	if err := p.User.VerifyPhone(ctx, dynamoDB, cfg.AWS.DynamoDB.Table.User,
+		cfg.Phone.Key, body.Code); err != nil {
+		return getProblem(err, request)
+	}
+
+	return getResponse(http.StatusOK, &meResponse{Message: MsgPhoneVerified})
+}
+
+// mfa enrolls the authenticator app of the user or confirms it with its first
+// code. Like the API keys, MFA can only be set up with a session
+func mfa(ctx context.Context, dynamoDB dynamodbiface.DynamoDBAPI,
+	p *auth.Principal, request events.APIGatewayProxyRequest,
+	cfg configuration) (Response, error) {
+
+	if p.APIKey != nil {
+		return getProblem(errors.New(ErrorSessionRequired), request)
+	}
+
+	if request.Resource == "/users/me/mfa" {
+		enrollment, err := p.User.EnrollMFA(ctx, dynamoDB,
+			cfg.AWS.DynamoDB.Table.User, cfg.MFA.Issuer, cfg.MFA.Key)
+		if err != nil {
+			return getProblem(err, request)
+		}
+		return getResponse(http.StatusOK, &meResponse{Message: MsgMFAEnrolled,
+			MFA: enrollment})
+	}
+
+	log.Debug().Msg("Unmarshalling request")
+	var body mfaRequest
+	if err := json.Unmarshal([]byte(request.Body), &body); err != nil {
+		return getProblem(err, request)
+	}
+
+	codes, err := p.User.ConfirmMFA(ctx, dynamoDB, cfg.AWS.DynamoDB.Table.User,
+		cfg.MFA.Key, body.Code)
+	if err != nil {
+		return getProblem(err, request)
+	}
+
+	return getResponse(http.StatusOK, &meResponse{Message: MsgMFAEnabled,
+		RecoveryCodes: codes})
+}
+
+// getProblem builds the application/problem+json response of err
+func getProblem(err error, request events.APIGatewayProxyRequest) (
+	Response, error) {
+	return Response(apperr.Response(err, request.Path)), nil
+}
+
+// getResponse builds an API Gateway Response
+func getResponse(statusCode int, resp *meResponse) (Response, error) {
+
+	headers := map[string]string{
+		"Content-Type": "application/json",
+	}
+
+	resp.StatusCode = statusCode
+
+	js, err := json.Marshal(resp)
+	if err != nil {
+		return Response{StatusCode: http.StatusInternalServerError}, err
+	}
+
+	log.Debug().Msgf("status_code: %d, message: %s", resp.StatusCode, resp.Message)
+
+	return Response{Headers: headers, Body: string(js),
+		StatusCode: resp.StatusCode}, nil
+}
+
+func initHandler(ctx context.Context, request events.APIGatewayProxyRequest) (
+	Response, error) {
+
+	//Config holds the configuration for the application
+	var cfg configuration
+	err := config.Load(&cfg)
+	if err != nil {
+		return Response{}, err
+	}
+
+	log.Debug().Msg("initHandler function")
+
+	sess, err := uaws.GetSession(cfg.AWS.Region)
+	if err != nil {
+		return Response{}, err
+	}
+
+	storage, err := avatar.New(cfg.Avatar,
+		uaws.GetS3WithEndpoint(sess, cfg.Avatar.Endpoint))
+	if err != nil {
+		return Response{}, err
+	}
+
+	return Handler(ctx, uaws.GetDynamoDB(sess), storage, request, cfg)
+
+}
+
+func main() {
+	lambda.Start(initHandler)
+}
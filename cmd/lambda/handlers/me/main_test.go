@@ -0,0 +1,129 @@
+package main
+
+import (
+	"context"
+	"encoding/base64"
+	"encoding/json"
+	"net/http"
+	"strconv"
+	"testing"
+	"time"
+
+	"github.com/aws/aws-lambda-go/events"
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/dynamodb"
+
+	"github.com/roloum/users/internal/seal"
+	"github.com/roloum/users/internal/test"
+	"github.com/roloum/users/internal/totp"
+	"github.com/roloum/users/internal/user"
+)
+
+//TestHandlerMFA Tests enrolling and confirming MFA
+func TestHandlerMFA(t *testing.T) {
+
+	key := base64.StdEncoding.EncodeToString(make([]byte, 32))
+
+	var cfg configuration
+	cfg.AWS.DynamoDB.Table.User = "User"
+	cfg.MFA.Key = key
+	cfg.MFA.Issuer = "users"
+
+	secret, err := totp.GenerateSecret()
+	if err != nil {
+		t.Fatal(err)
+	}
+	sealed, err := seal.Seal(key, []byte(secret))
+	if err != nil {
+		t.Fatal(err)
+	}
+	code, err := totp.Code(secret, time.Now())
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	email := "a@user.com"
+	token := base64.RawURLEncoding.EncodeToString([]byte(email)) + ".secret"
+
+	items := func(confirmed bool) map[string]map[string]*dynamodb.AttributeValue {
+		return map[string]map[string]*dynamodb.AttributeValue{
+			user.DynamoDBPrefixSession: {
+				"ttl": {N: aws.String(strconv.FormatInt(
+					time.Now().Add(time.Hour).Unix(), 10))},
+			},
+			user.DynamoDBPrefixProfile: {
+				"email":  {S: aws.String(email)},
+				"active": {BOOL: aws.Bool(true)},
+			},
+			user.DynamoDBPrefixMFA: {
+				"secret":    {S: aws.String(sealed)},
+				"confirmed": {BOOL: aws.Bool(confirmed)},
+			},
+		}
+	}
+
+	tests := []struct {
+		desc      string
+		resource  string
+		body      string
+		token     string
+		confirmed bool
+		status    int
+		message   string
+	}{
+		{desc: "Enroll", resource: "/users/me/mfa", token: token,
+			status: http.StatusOK, message: MsgMFAEnrolled},
+		{desc: "Confirm", resource: "/users/me/mfa/confirm", token: token,
+			body: `{"code": "` + code + `"}`, status: http.StatusOK,
+			message: MsgMFAEnabled},
+		{desc: user.ErrorInvalidMFACode, resource: "/users/me/mfa/confirm",
+			token: token, body: `{"code": "abcdef"}`,
+			status: http.StatusUnauthorized},
+		{desc: user.ErrorMFAAlreadyEnabled, resource: "/users/me/mfa/confirm",
+			token: token, body: `{"code": "` + code + `"}`, confirmed: true,
+			status: http.StatusConflict},
+		{desc: "Unauthorized", resource: "/users/me/mfa",
+			status: http.StatusUnauthorized},
+	}
+
+	for _, tc := range tests {
+		t.Run(tc.desc, func(t *testing.T) {
+			mock := &test.MockTable{MockDynamoDB: &test.MockDynamoDB{},
+				Items: items(tc.confirmed)}
+
+			request := events.APIGatewayProxyRequest{Resource: tc.resource,
+				Path: tc.resource, HTTPMethod: http.MethodPost, Body: tc.body,
+				Headers: map[string]string{"Authorization": "Bearer " + tc.token}}
+
+			response, err := Handler(context.Background(), mock, nil, request,
+				cfg)
+			if err != nil {
+				t.Fatalf("Expected: %v. Received: %v", nil, err)
+			}
+			if response.StatusCode != tc.status {
+				t.Fatalf("Expected: %v. Received: %v %v", tc.status,
+					response.StatusCode, response.Body)
+			}
+			if tc.message == "" {
+				return
+			}
+
+			var body meResponse
+			if err := json.Unmarshal([]byte(response.Body), &body); err != nil {
+				t.Fatal(err)
+			}
+			if body.Message != tc.message {
+				t.Errorf("Expected: %v. Received: %v", tc.message, body.Message)
+			}
+			if tc.message == MsgMFAEnrolled && (body.MFA == nil ||
+				body.MFA.Secret == "") {
+				t.Errorf("Expected the secret. Received: %v", body.MFA)
+			}
+			if tc.message == MsgMFAEnabled &&
+				len(body.RecoveryCodes) != user.MFARecoveryCodes {
+				t.Errorf("Expected: %v. Received: %v", user.MFARecoveryCodes,
+					len(body.RecoveryCodes))
+			}
+		})
+	}
+}
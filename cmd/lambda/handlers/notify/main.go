@@ -1,31 +1,42 @@
-//Lambda function that sends an email to the user after:
+//Lambda function that notifies the user after:
 // - user is created
 // - user is verified
+// - user requests a magic link
+// - user requests the verification code of a phone number
+// - the sweep reminds the user to activate the account
+//The activation, reminder and magic link emails and the SMS codes are
+//mandatory. The others belong to a notification category the user can
+//disable, and are sent through the channels of the category in the
+//preferences; their emails carry the RFC 8058 one-click List-Unsubscribe
+//headers. No email is sent to the addresses suppressed after a hard bounce or
+//a complaint
 package main
 
 import (
 	"context"
-	"errors"
 	"fmt"
 	"net/http"
+	"net/url"
+	"time"
 
 	"github.com/rs/zerolog/log"
 
 	"github.com/aws/aws-lambda-go/events"
 	"github.com/aws/aws-lambda-go/lambda"
-	"github.com/aws/aws-sdk-go/aws"
-	"github.com/aws/aws-sdk-go/aws/awserr"
-	"github.com/aws/aws-sdk-go/service/ses"
+	"github.com/aws/aws-sdk-go/service/dynamodb"
 	uaws "github.com/roloum/users/internal/aws"
 	"github.com/roloum/users/internal/config"
+	"github.com/roloum/users/internal/notify"
 	"github.com/roloum/users/internal/user"
 )
 
-//CHARSET Character encoding for email
-const CHARSET = "UTF-8"
-
 type configuration struct {
 	AWS struct {
+		DynamoDB struct {
+			Table struct {
+				User string `required:"true"`
+			}
+		}
 		Region string `required:"true"`
 	}
 	Email struct {
@@ -33,10 +44,25 @@ type configuration struct {
 		Activate struct {
 			URL string `required:"true"`
 		}
+		Magic struct {
+			URL string `required:"true"`
+		}
+		Unsubscribe struct {
+			URL string `required:"true"`
+			Key string `required:"true"`
+		}
+	}
+	Magic struct {
+		Key string `required:"true"`
+	}
+	Phone struct {
+		Key string `required:"true"`
 	}
+	Notify notify.Config
 }
 
-func handler(ctx context.Context, e events.DynamoDBEvent, svc *ses.SES,
+func handler(ctx context.Context, e events.DynamoDBEvent,
+	dispatcher *notify.Dispatcher, dynamoDB *dynamodb.DynamoDB,
 	cfg configuration) error {
 
 	for _, v := range e.Records {
@@ -44,10 +70,23 @@ func handler(ctx context.Context, e events.DynamoDBEvent, svc *ses.SES,
 
 		log.Debug().Msgf("Record keys: %+v", v.Change.Keys)
 
+		//Rows copied by a restore or a migration were notified when the user
+		//wrote them
+		if user.IsMigratedChange(v.Change) {
+			log.Info().Msg("Migrated row, skipping notifications")
+			continue
+		}
+
 		//New Token row? Send activation email
 		if user.IsUserTokenKeys(v.Change.Keys) &&
 			events.DynamoDBOperationType(v.EventName) == events.DynamoDBOperationTypeInsert {
 
+			if silent, ok := v.Change.NewImage[user.DynamoDBAttributeSilent]; ok &&
+				silent.DataType() == events.DataTypeBoolean && silent.Boolean() {
+				log.Info().Msg("Silent token, skipping activation email")
+				continue
+			}
+
 			var u user.User
 
 			log.Debug().Msg("Unmarshalling user struct")
@@ -60,27 +99,100 @@ func handler(ctx context.Context, e events.DynamoDBEvent, svc *ses.SES,
 
 			log.Info().Msgf("Sending activation email for %s", u.Email)
 
-			log.Debug().Msg("Building activation URL")
+			link, err := activateURL(ctx, u.Email, u.ID, cfg)
+			if err != nil {
+				log.Fatal().Msg(err.Error())
+			}
+
+			if err := dispatcher.Send(ctx, notify.Recipient{Email: u.Email},
+				&notify.Message{
+					Category: user.NotificationAccount,
+					Subject:  "Activate account",
+					HTML:     fmt.Sprintf("<a href=\"%s\">Click here to Activate</a>", link),
+					Text:     fmt.Sprintf("Click here to Activate: \"%s\"", link),
+				}, []string{user.ChannelEmail}); err != nil {
+				log.Fatal().Msg(err.Error())
+			}
+
+		} else if user.IsUserMagicKeys(v.Change.Keys) &&
+			events.DynamoDBOperationType(v.EventName) == events.DynamoDBOperationTypeInsert {
+
+			var m user.MagicLink
+
+			log.Debug().Msg("Unmarshalling magic link struct")
+
+			err := uaws.UnmarshalStreamImage(v.Change.NewImage, &m)
+			if err != nil {
+				log.Fatal().Msg(err.Error())
+			}
+
+			log.Info().Msgf("Sending magic link email for %s", m.Email)
 
 			req, err := http.NewRequestWithContext(ctx, http.MethodGet,
-				cfg.Email.Activate.URL, nil)
+				cfg.Email.Magic.URL, nil)
 			if err != nil {
 				log.Fatal().Msg(err.Error())
 			}
+			token, err := m.OpenToken(cfg.Magic.Key)
+			if err != nil {
+				log.Fatal().Msg(err.Error())
+			}
+
 			q := req.URL.Query()
-			q.Add("email", u.Email)
-			//Using the uuid as token
-			q.Add("token", u.ID)
+			q.Add("email", m.Email)
+			q.Add("token", token)
 			req.URL.RawQuery = q.Encode()
 			req.URL.Scheme = "https"
 
-			if err := sendEmail(
-				"Activate account",
-				fmt.Sprintf("<a href=\"%s\">Click here to Activate</a>", req.URL.String()),
-				fmt.Sprintf("Click here to Activate: \"%s\"", req.URL.String()),
-				u.Email,
-				cfg.Email.Sender,
-				svc); err != nil {
+			//A link mailed after it expires no longer signs in
+			msg := &notify.Message{
+				Category: user.NotificationAccount,
+				Subject:  "Sign in",
+				HTML:     fmt.Sprintf("<a href=\"%s\">Click here to sign in</a>", req.URL.String()),
+				Text:     fmt.Sprintf("Click here to sign in: \"%s\"", req.URL.String()),
+			}
+			if m.TTL > 0 {
+				msg.ExpiresAt = time.Unix(m.TTL, 0)
+			}
+
+			if err := dispatcher.Send(ctx, notify.Recipient{Email: m.Email}, msg,
+				[]string{user.ChannelEmail}); err != nil {
+				log.Fatal().Msg(err.Error())
+			}
+
+		} else if user.IsUserPhoneKeys(v.Change.Keys) &&
+			(events.DynamoDBOperationType(v.EventName) == events.DynamoDBOperationTypeInsert ||
+				events.DynamoDBOperationType(v.EventName) == events.DynamoDBOperationTypeModify) {
+
+			var old, p user.PhoneVerification
+			if err := uaws.UnmarshalStreamImage(v.Change.NewImage, &p); err != nil {
+				log.Fatal().Msg(err.Error())
+			}
+			if len(v.Change.OldImage) > 0 {
+				if err := uaws.UnmarshalStreamImage(v.Change.OldImage, &old); err != nil {
+					log.Fatal().Msg(err.Error())
+				}
+			}
+
+			//Wrong codes only increment the attempts of the row
+			if old.Code == p.Code {
+				continue
+			}
+
+			log.Info().Msgf("Sending phone verification code for %s", p.Email)
+
+			code, err := p.OpenCode(cfg.Phone.Key)
+			if err != nil {
+				log.Fatal().Msg(err.Error())
+			}
+
+			if err := dispatcher.Send(ctx,
+				notify.Recipient{Email: p.Email, Phone: p.Phone},
+				&notify.Message{
+					Category: user.NotificationAccount,
+					Subject:  "Verification code",
+					Text:     fmt.Sprintf("Your verification code is %s", code),
+				}, []string{user.ChannelSMS}); err != nil {
 				log.Fatal().Msg(err.Error())
 			}
 
@@ -100,18 +212,77 @@ func handler(ctx context.Context, e events.DynamoDBEvent, svc *ses.SES,
 
 			log.Debug().Msgf("Old_u.active=%v, New_u.active=%v", old.Active, new.Active)
 
+			//Sweep reminding the user to activate the account?
+			var oldSweep, newSweep user.InactiveUser
+			if err := uaws.UnmarshalStreamImage(v.Change.OldImage, &oldSweep); err != nil {
+				log.Fatal().Msg(err.Error())
+			}
+			if err := uaws.UnmarshalStreamImage(v.Change.NewImage, &newSweep); err != nil {
+				log.Fatal().Msg(err.Error())
+			}
+
+			if user.IsReminder(&oldSweep, &newSweep) {
+
+				link, err := activateURL(ctx, newSweep.Email, newSweep.ID, cfg)
+				if err != nil {
+					log.Fatal().Msg(err.Error())
+				}
+
+				deletion := newSweep.Deletion
+				if d, err := time.Parse(time.RFC3339, deletion); err == nil {
+					deletion = d.Format("January 2, 2006")
+				}
+
+				log.Info().Msgf("Sending activation reminder %d for %s",
+					newSweep.Reminders, newSweep.Email)
+
+				if err := dispatcher.Send(ctx, notify.Recipient{Email: newSweep.Email},
+					&notify.Message{
+						Category: user.NotificationAccount,
+						Subject:  "Reminder: activate your account",
+						HTML: fmt.Sprintf("<a href=\"%s\">Click here to Activate</a>"+
+							"<p>The account will be deleted on %s if it is not activated.</p>",
+							link, deletion),
+						Text: fmt.Sprintf("Click here to Activate: \"%s\"\n"+
+							"The account will be deleted on %s if it is not activated.",
+							link, deletion),
+					}, []string{user.ChannelEmail}); err != nil {
+					log.Fatal().Msg(err.Error())
+				}
+				continue
+			}
+
 			//User activating account
 			if !old.Active && new.Active {
 
-				log.Info().Msgf("Sending welcome email for %s", new.Email)
+				prefs, err := new.LoadPreferences(ctx, dynamoDB,
+					cfg.AWS.DynamoDB.Table.User)
+				if err != nil {
+					log.Fatal().Msg(err.Error())
+				}
+
+				if !prefs.Allows(user.NotificationProduct) {
+					log.Info().Msgf("%s disabled %s notifications, skipping welcome",
+						new.Email, user.NotificationProduct)
+					continue
+				}
 
-				if err := sendEmail(
-					"Welcome user",
-					"Hello World!",
-					"Hello World!",
-					new.Email,
-					cfg.Email.Sender,
-					svc); err != nil {
+				unsubscribe, err := unsubscribeURL(&new, user.NotificationProduct,
+					cfg)
+				if err != nil {
+					log.Fatal().Msg(err.Error())
+				}
+
+				log.Info().Msgf("Sending welcome message for %s", new.Email)
+
+				if err := dispatcher.Send(ctx, notify.RecipientOf(&new),
+					&notify.Message{
+						Category:       user.NotificationProduct,
+						Subject:        "Welcome user",
+						HTML:           "Hello World!",
+						Text:           "Hello World!",
+						UnsubscribeURL: unsubscribe,
+					}, prefs.ChannelsFor(user.NotificationProduct)); err != nil {
 					log.Fatal().Msg(err.Error())
 				}
 			}
@@ -119,58 +290,52 @@ func handler(ctx context.Context, e events.DynamoDBEvent, svc *ses.SES,
 		}
 	}
 
-	return nil
+	//The emails of a memory queue are sent before returning
+	return dispatcher.Flush(ctx)
 }
 
-func sendEmail(subject, htmlBody, textBody, recipient, sender string,
-	svc *ses.SES) error {
-	log.Debug().Str("sender", sender).
-		Str("nemail", recipient).
-		Str("subject", subject).
-		Str("body", textBody).
-		Msg("Sending email")
-
-	// Email input
-	input := &ses.SendEmailInput{
-		Destination: &ses.Destination{
-			ToAddresses: []*string{
-				aws.String(recipient),
-			},
-		},
-		Message: &ses.Message{
-			Body: &ses.Body{
-				Html: &ses.Content{
-					Charset: aws.String(CHARSET),
-					Data:    aws.String(htmlBody),
-				},
-				Text: &ses.Content{
-					Charset: aws.String(CHARSET),
-					Data:    aws.String(textBody),
-				},
-			},
-			Subject: &ses.Content{
-				Charset: aws.String(CHARSET),
-				Data:    aws.String(subject),
-			},
-		},
-		Source: aws.String(sender),
+//activateURL returns the activation link of the user, the user id is the
+//token
+func activateURL(ctx context.Context, email, token string,
+	cfg configuration) (string, error) {
+
+	log.Debug().Msg("Building activation URL")
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet,
+		cfg.Email.Activate.URL, nil)
+	if err != nil {
+		return "", err
 	}
+	q := req.URL.Query()
+	q.Add("email", email)
+	q.Add("token", token)
+	req.URL.RawQuery = q.Encode()
+	req.URL.Scheme = "https"
+
+	return req.URL.String(), nil
+}
 
-	// Attempt to send the email.
-	result, err := svc.SendEmail(input)
+//unsubscribeURL returns the signed link unsubscribing the user from the
+//category
+func unsubscribeURL(u *user.User, category string,
+	cfg configuration) (string, error) {
 
-	// Display error messages if they occur.
+	token, err := user.UnsubscribeToken(cfg.Email.Unsubscribe.Key, u.ID,
+		category)
 	if err != nil {
-		if aerr, ok := err.(awserr.Error); ok {
-			return errors.New(aerr.Code())
-		}
-		return err
+		return "", err
 	}
 
-	log.Debug().Msgf("%+v", result)
-	log.Info().Msg("Email sent")
+	link, err := url.Parse(cfg.Email.Unsubscribe.URL)
+	if err != nil {
+		return "", err
+	}
+	link.Scheme = "https"
+	q := link.Query()
+	q.Set("token", token)
+	link.RawQuery = q.Encode()
 
-	return nil
+	return link.String(), nil
 }
 
 func initHandler(ctx context.Context, e events.DynamoDBEvent) error {
@@ -187,7 +352,21 @@ func initHandler(ctx context.Context, e events.DynamoDBEvent) error {
 		return err
 	}
 
-	return handler(ctx, e, ses.New(sess), cfg)
+	dispatcher, err := notify.New(cfg.Notify, cfg.Email.Sender, sess)
+	if err != nil {
+		return err
+	}
+
+	dynamoDB := uaws.GetDynamoDB(sess)
+
+	//No email is sent to the addresses that hard bounced or complained
+	dispatcher.SetSuppressor(notify.SuppressorFunc(
+		func(ctx context.Context, email string) (bool, error) {
+			return user.EmailSuppressed(ctx, dynamoDB,
+				cfg.AWS.DynamoDB.Table.User, email)
+		}))
+
+	return handler(ctx, e, dispatcher, dynamoDB, cfg)
 
 }
 
@@ -0,0 +1,289 @@
+//Lambda function that signs in an user with an external identity provider
+//using the authorization code flow with PKCE:
+// - /users/oidc/{provider}/authorize redirects to the provider
+// - /users/oidc/{provider}/callback exchanges the code for a session. Users
+//   with MFA enabled get a session that requires the MFA code
+// - POST /users/oidc/{provider}/mfa {"token": "...", "code": "123456"}
+//   exchanges that session and the code for a full session
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"time"
+
+	"github.com/rs/zerolog/log"
+
+	"github.com/aws/aws-lambda-go/events"
+	"github.com/aws/aws-lambda-go/lambda"
+	"github.com/aws/aws-sdk-go/service/dynamodb"
+	"github.com/roloum/users/internal/apperr"
+	uaws "github.com/roloum/users/internal/aws"
+	"github.com/roloum/users/internal/config"
+	"github.com/roloum/users/internal/oidc"
+	"github.com/roloum/users/internal/user"
+)
+
+const (
+	//MsgSignedIn message returned when the user signs in
+	MsgSignedIn = "SignedIn"
+
+	//MsgMFARequired message returned when the session requires the MFA code
+	MsgMFARequired = "MFARequired"
+
+	//ErrorUnknownAction message returned for an unknown path
+	ErrorUnknownAction = "UnknownAction"
+
+	//ErrorCodeIsEmpty message returned if the code or state are empty, or the
+	//token or MFA code
+	ErrorCodeIsEmpty = "CodeIsEmpty"
+
+	//ErrorAccessDenied message returned when the provider reports an error
+	ErrorAccessDenied = "AccessDenied"
+
+	//ErrorProviderUnavailable message returned when the discovery document of
+	//the provider can not be retrieved
+	ErrorProviderUnavailable = "ProviderUnavailable"
+)
+
+func init() {
+	apperr.Register(http.StatusNotFound, ErrorUnknownAction)
+	apperr.Register(http.StatusUnprocessableEntity, ErrorCodeIsEmpty)
+	apperr.Register(http.StatusUnauthorized, ErrorAccessDenied)
+}
+
+type (
+
+	// oidcResponse
+	oidcResponse struct {
+		StatusCode int           `json:"status"`
+		Message    string        `json:"message"`
+		User       *user.User    `json:"user,omitempty"`
+		Session    *user.Session `json:"session,omitempty"`
+	}
+
+	// mfaRequest is the session waiting for the MFA code, and the code
+	mfaRequest struct {
+		Token string `json:"token"`
+		Code  string `json:"code"`
+	}
+
+	// Response is of type APIGatewayProxyResponse since we're leveraging the
+	// AWS Lambda Proxy Request functionality (default behavior)
+	//
+	// https://serverless.com/framework/docs/providers/aws/events/apigateway/#lambda-proxy-integration
+	Response events.APIGatewayProxyResponse
+
+	configuration struct {
+		AWS struct {
+			DynamoDB struct {
+				Table struct {
+					User string `required:"true"`
+				}
+			}
+			Region string `required:"true"`
+		}
+		OIDC struct {
+			Providers oidc.Providers `required:"true"`
+			StateTTL  time.Duration  `default:"10m"`
+		}
+		Session struct {
+			TTL time.Duration `default:"24h"`
+		}
+		MFA struct {
+			Key string
+		}
+	}
+)
+
+// Handler is our lambda handler invoked by the `lambda.Start` function call
+func Handler(ctx context.Context, dynamoDB *dynamodb.DynamoDB,
+	client *http.Client, request events.APIGatewayProxyRequest,
+	cfg configuration) (Response, error) {
+
+	if request.PathParameters["action"] == "mfa" &&
+		request.HTTPMethod == http.MethodPost {
+		return completeMFA(ctx, dynamoDB, request, cfg)
+	}
+
+	provider, err := cfg.OIDC.Providers.Get(request.PathParameters["provider"])
+	if err != nil {
+		return getProblem(err, request)
+	}
+
+	if err := provider.Discover(ctx, client); err != nil {
+		return getProblem(&apperr.Error{Code: ErrorProviderUnavailable,
+			Status: http.StatusBadGateway, Retryable: true, Err: err}, request)
+	}
+
+	switch request.PathParameters["action"] {
+	case "authorize":
+		return authorize(ctx, dynamoDB, provider, request, cfg)
+	case "callback":
+		return callback(ctx, dynamoDB, client, provider, request, cfg)
+	}
+
+	return getProblem(errors.New(ErrorUnknownAction), request)
+}
+
+// authorize stores the state and redirects the user to the provider
+func authorize(ctx context.Context, dynamoDB *dynamodb.DynamoDB,
+	provider *oidc.Provider, request events.APIGatewayProxyRequest,
+	cfg configuration) (Response, error) {
+
+	state, challenge, err := oidc.NewState(provider.Name)
+	if err != nil {
+		return getProblem(err, request)
+	}
+
+	if err := state.Save(ctx, dynamoDB, cfg.AWS.DynamoDB.Table.User,
+		cfg.OIDC.StateTTL); err != nil {
+		return getProblem(err, request)
+	}
+
+	log.Info().Msgf("Redirecting to provider: %s", provider.Name)
+
+	return Response{
+		StatusCode: http.StatusFound,
+		Headers: map[string]string{
+			"Location":      provider.AuthCodeURL(state.State, state.Nonce, challenge),
+			"Cache-Control": "no-store",
+		},
+	}, nil
+}
+
+// callback validates the state, exchanges the code and signs in the user
+func callback(ctx context.Context, dynamoDB *dynamodb.DynamoDB,
+	client *http.Client, provider *oidc.Provider,
+	request events.APIGatewayProxyRequest, cfg configuration) (Response, error) {
+
+	if e := request.QueryStringParameters["error"]; e != "" {
+		log.Info().Msgf("Provider returned error: %s", e)
+		return getProblem(errors.New(ErrorAccessDenied), request)
+	}
+
+	code := request.QueryStringParameters["code"]
+	stateParam := request.QueryStringParameters["state"]
+	if code == "" || stateParam == "" {
+		return getProblem(errors.New(ErrorCodeIsEmpty), request)
+	}
+
+	state, err := oidc.ConsumeState(ctx, dynamoDB, cfg.AWS.DynamoDB.Table.User,
+		stateParam, provider.Name)
+	if err != nil {
+		return getProblem(err, request)
+	}
+
+	id, err := provider.Identity(ctx, client, code, state.Verifier, state.Nonce)
+	if err != nil {
+		return getProblem(err, request)
+	}
+
+	u, session, err := user.SignInWithIdentity(ctx, dynamoDB,
+		cfg.AWS.DynamoDB.Table.User, &user.Identity{
+			Provider:      id.Provider,
+			Subject:       id.Subject,
+			Email:         id.Email,
+			EmailVerified: id.EmailVerified,
+			FirstName:     id.GivenName,
+			LastName:      id.FamilyName,
+		}, cfg.Session.TTL)
+	if err != nil {
+		return getProblem(err, request)
+	}
+
+	if session.MFARequired {
+		log.Info().Msg("MFA code required")
+		return getResponse(http.StatusOK, MsgMFARequired, nil, session)
+	}
+
+	log.Info().Msg("User signed in")
+
+	return getResponse(http.StatusOK, MsgSignedIn, u, session)
+}
+
+// completeMFA exchanges the session waiting for the MFA code for a full one
+func completeMFA(ctx context.Context, dynamoDB *dynamodb.DynamoDB,
+	request events.APIGatewayProxyRequest, cfg configuration) (Response, error) {
+
+	var body mfaRequest
+	if err := json.Unmarshal([]byte(request.Body), &body); err != nil {
+		return getProblem(err, request)
+	}
+
+	if body.Token == "" || body.Code == "" {
+		return getProblem(errors.New(ErrorCodeIsEmpty), request)
+	}
+
+	session, err := user.CompleteMFA(ctx, dynamoDB,
+		cfg.AWS.DynamoDB.Table.User, body.Token, cfg.MFA.Key, body.Code,
+		cfg.Session.TTL)
+	if err != nil {
+		return getProblem(err, request)
+	}
+
+	log.Info().Msg("User signed in")
+
+	return getResponse(http.StatusOK, MsgSignedIn, nil, session)
+}
+
+// getProblem builds the application/problem+json response of err
+func getProblem(err error, request events.APIGatewayProxyRequest) (
+	Response, error) {
+	return Response(apperr.Response(err, request.Path)), nil
+}
+
+// getResponse builds an API Gateway Response
+func getResponse(statusCode int, message string, u *user.User,
+	s *user.Session) (Response, error) {
+
+	headers := map[string]string{
+		"Content-Type": "application/json",
+	}
+
+	resp := &oidcResponse{
+		StatusCode: statusCode,
+		Message:    message,
+		User:       u,
+		Session:    s,
+	}
+
+	js, err := json.Marshal(resp)
+	if err != nil {
+		return Response{StatusCode: http.StatusInternalServerError}, err
+	}
+
+	log.Debug().Msgf("status_code: %d, message: %s", resp.StatusCode, resp.Message)
+
+	return Response{Headers: headers, Body: string(js),
+		StatusCode: resp.StatusCode}, nil
+}
+
+func initHandler(ctx context.Context, request events.APIGatewayProxyRequest) (
+	Response, error) {
+
+	//Config holds the configuration for the application
+	var cfg configuration
+	err := config.Load(&cfg)
+	if err != nil {
+		return Response{}, err
+	}
+
+	log.Debug().Msg("initHandler function")
+
+	sess, err := uaws.GetSession(cfg.AWS.Region)
+	if err != nil {
+		return Response{}, err
+	}
+
+	client := &http.Client{Timeout: 10 * time.Second}
+
+	return Handler(ctx, uaws.GetDynamoDB(sess), client, request, cfg)
+
+}
+
+func main() {
+	lambda.Start(initHandler)
+}
@@ -0,0 +1,307 @@
+//Lambda function implementing the SCIM 2.0 /Users endpoint, used by identity
+//providers such as Okta or Azure AD to provision users
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"strconv"
+	"strings"
+
+	"github.com/rs/zerolog/log"
+
+	"github.com/aws/aws-lambda-go/events"
+	"github.com/aws/aws-lambda-go/lambda"
+	"github.com/aws/aws-sdk-go/service/dynamodb"
+	"github.com/roloum/users/internal/avatar"
+	uaws "github.com/roloum/users/internal/aws"
+	"github.com/roloum/users/internal/config"
+	"github.com/roloum/users/internal/scim"
+	"github.com/roloum/users/internal/user"
+)
+
+type (
+
+	// Response is of type APIGatewayProxyResponse since we're leveraging the
+	// AWS Lambda Proxy Request functionality (default behavior)
+	//
+	// https://serverless.com/framework/docs/providers/aws/events/apigateway/#lambda-proxy-integration
+	Response events.APIGatewayProxyResponse
+
+	configuration struct {
+		AWS struct {
+			DynamoDB struct {
+				Table struct {
+					User string `required:"true"`
+				}
+			}
+			Region string `required:"true"`
+		}
+		SCIM struct {
+			BaseURL string `required:"true"`
+		}
+		Validation user.ValidationConfig
+		Avatar     avatar.Config
+	}
+)
+
+// maxCount is the number of users returned when the request asks for more,
+// or does not say
+const maxCount = 100
+
+// Handler is our lambda handler invoked by the `lambda.Start` function call.
+// Every operation is bound to the tenant of the bearer token, the users of
+// other tenants are not found
+func Handler(ctx context.Context, dynamoDB *dynamodb.DynamoDB,
+	storage avatar.Storage, request events.APIGatewayProxyRequest,
+	cfg configuration) (Response, error) {
+
+	tenant, err := scim.Authenticate(ctx, dynamoDB, cfg.AWS.DynamoDB.Table.User,
+		bearerToken(request))
+	if err != nil {
+		return getError(http.StatusUnauthorized, "", err.Error())
+	}
+
+	log.Info().Msgf("SCIM %s %s, tenant: %s", request.HTTPMethod,
+		request.Path, tenant)
+
+	id := request.PathParameters["id"]
+
+	switch {
+	case id == "" && request.HTTPMethod == http.MethodGet:
+		return list(ctx, dynamoDB, tenant, request, cfg)
+	case id == "" && request.HTTPMethod == http.MethodPost:
+		return create(ctx, dynamoDB, tenant, request, cfg)
+	case id != "" && request.HTTPMethod == http.MethodGet:
+		return get(ctx, dynamoDB, tenant, id, cfg)
+	case id != "" && request.HTTPMethod == http.MethodPatch:
+		return patch(ctx, dynamoDB, tenant, id, request, cfg)
+	case id != "" && request.HTTPMethod == http.MethodDelete:
+		return remove(ctx, dynamoDB, storage, tenant, id, cfg)
+	}
+
+	return getError(http.StatusMethodNotAllowed, "", "")
+}
+
+// list returns a page of the users of the tenant matching the filter
+func list(ctx context.Context, dynamoDB *dynamodb.DynamoDB, tenant string,
+	request events.APIGatewayProxyRequest, cfg configuration) (Response, error) {
+
+	q := request.QueryStringParameters
+
+	startIndex, count := 1, maxCount
+	if v, err := strconv.Atoi(q["startIndex"]); err == nil && v > 1 {
+		startIndex = v
+	}
+	if v, err := strconv.Atoi(q["count"]); err == nil && v >= 0 && v < maxCount {
+		count = v
+	}
+
+	resources := []*scim.User{}
+
+	if filter := q["filter"]; filter != "" {
+		email, err := scim.ParseFilter(filter)
+		if err != nil {
+			return getErrorFrom(err)
+		}
+
+		u := &user.User{Email: email}
+		err = u.Load(ctx, dynamoDB, cfg.AWS.DynamoDB.Table.User)
+		if err != nil && err.Error() != user.ErrorUserDoesNotExist {
+			return getErrorFrom(err)
+		}
+
+		total := 0
+		if err == nil && u.InTenant(tenant) {
+			total = 1
+			if startIndex == 1 && count > 0 {
+				resources = append(resources, scim.NewUserResource(u,
+					cfg.SCIM.BaseURL))
+			}
+		}
+
+		return getResponse(http.StatusOK, scim.NewListResponse(resources, total,
+			startIndex))
+	}
+
+	users, total, err := user.ListByTenant(ctx, dynamoDB,
+		cfg.AWS.DynamoDB.Table.User, tenant, startIndex, count)
+	if err != nil {
+		return getErrorFrom(err)
+	}
+	for _, u := range users {
+		resources = append(resources, scim.NewUserResource(u, cfg.SCIM.BaseURL))
+	}
+
+	return getResponse(http.StatusOK, scim.NewListResponse(resources, total,
+		startIndex))
+}
+
+// create provisions an user of the tenant. Provisioned users are active unless
+// the request says otherwise, and no activation email is sent
+func create(ctx context.Context, dynamoDB *dynamodb.DynamoDB, tenant string,
+	request events.APIGatewayProxyRequest, cfg configuration) (Response, error) {
+
+	var resource scim.User
+	if err := json.Unmarshal([]byte(request.Body), &resource); err != nil {
+		return getError(http.StatusBadRequest, "invalidSyntax", err.Error())
+	}
+
+	active := resource.Active == nil || *resource.Active
+
+	u, err := user.Provision(ctx, dynamoDB, resource.NewUser(),
+		cfg.AWS.DynamoDB.Table.User, tenant, active)
+	if err != nil {
+		return getErrorFrom(err)
+	}
+
+	log.Info().Msg("User provisioned")
+
+	return getResponse(http.StatusCreated, scim.NewUserResource(u, cfg.SCIM.BaseURL))
+}
+
+// get returns an user of the tenant by ID
+func get(ctx context.Context, dynamoDB *dynamodb.DynamoDB, tenant, id string,
+	cfg configuration) (Response, error) {
+
+	u, err := user.LoadByTenant(ctx, dynamoDB, cfg.AWS.DynamoDB.Table.User,
+		tenant, id)
+	if err != nil {
+		return getErrorFrom(err)
+	}
+
+	return getResponse(http.StatusOK, scim.NewUserResource(u, cfg.SCIM.BaseURL))
+}
+
+// patch modifies the name or the active flag of an user of the tenant
+func patch(ctx context.Context, dynamoDB *dynamodb.DynamoDB, tenant, id string,
+	request events.APIGatewayProxyRequest, cfg configuration) (Response, error) {
+
+	var op scim.PatchOp
+	if err := json.Unmarshal([]byte(request.Body), &op); err != nil {
+		return getError(http.StatusBadRequest, "invalidSyntax", err.Error())
+	}
+
+	u, err := user.LoadByTenant(ctx, dynamoDB, cfg.AWS.DynamoDB.Table.User,
+		tenant, id)
+	if err != nil {
+		return getErrorFrom(err)
+	}
+
+	if err := op.Apply(u); err != nil {
+		return getErrorFrom(err)
+	}
+
+	if err := u.Update(ctx, dynamoDB, cfg.AWS.DynamoDB.Table.User); err != nil {
+		return getErrorFrom(err)
+	}
+
+	log.Info().Msg("User patched")
+
+	return getResponse(http.StatusOK, scim.NewUserResource(u, cfg.SCIM.BaseURL))
+}
+
+// remove deprovisions an user of the tenant, deleting every row in its
+// partition and the images of its avatar
+func remove(ctx context.Context, dynamoDB *dynamodb.DynamoDB,
+	storage avatar.Storage, tenant, id string, cfg configuration) (Response,
+	error) {
+
+	u, err := user.LoadByTenant(ctx, dynamoDB, cfg.AWS.DynamoDB.Table.User,
+		tenant, id)
+	if err != nil {
+		return getErrorFrom(err)
+	}
+
+	if err := avatar.Purge(ctx, storage, dynamoDB, cfg.AWS.DynamoDB.Table.User,
+		u); err != nil {
+		return getErrorFrom(err)
+	}
+
+	if err := u.Delete(ctx, dynamoDB, cfg.AWS.DynamoDB.Table.User); err != nil {
+		return getErrorFrom(err)
+	}
+
+	log.Info().Msg("User deprovisioned")
+
+	return Response{StatusCode: http.StatusNoContent}, nil
+}
+
+func bearerToken(request events.APIGatewayProxyRequest) string {
+	for k, v := range request.Headers {
+		if strings.EqualFold(k, "Authorization") &&
+			strings.HasPrefix(v, "Bearer ") {
+			return strings.TrimPrefix(v, "Bearer ")
+		}
+	}
+	return ""
+}
+
+// getErrorFrom builds the SCIM error response for err
+func getErrorFrom(err error) (Response, error) {
+	status, scimType := scim.StatusFromError(err)
+	if status >= http.StatusInternalServerError {
+		log.Error().Msg(err.Error())
+		return getError(status, scimType, http.StatusText(status))
+	}
+	return getError(status, scimType, err.Error())
+}
+
+// getError builds a SCIM error response
+func getError(statusCode int, scimType, detail string) (Response, error) {
+	return getResponse(statusCode, scim.NewError(statusCode, scimType, detail))
+}
+
+// getResponse builds an API Gateway Response
+func getResponse(statusCode int, body interface{}) (Response, error) {
+
+	headers := map[string]string{
+		"Content-Type": "application/scim+json",
+	}
+
+	js, err := json.Marshal(body)
+	if err != nil {
+		return Response{StatusCode: http.StatusInternalServerError}, err
+	}
+
+	log.Debug().Msgf("status_code: %d", statusCode)
+
+	return Response{Headers: headers, Body: string(js),
+		StatusCode: statusCode}, nil
+}
+
+func initHandler(ctx context.Context, request events.APIGatewayProxyRequest) (
+	Response, error) {
+
+	//Config holds the configuration for the application
+	var cfg configuration
+	err := config.Load(&cfg)
+	if err != nil {
+		return Response{}, err
+	}
+
+	log.Debug().Msg("initHandler function")
+
+	if err := user.Configure(cfg.Validation); err != nil {
+		return Response{}, err
+	}
+
+	sess, err := uaws.GetSession(cfg.AWS.Region)
+	if err != nil {
+		return Response{}, err
+	}
+
+	storage, err := avatar.New(cfg.Avatar,
+		uaws.GetS3WithEndpoint(sess, cfg.Avatar.Endpoint))
+	if err != nil {
+		return Response{}, err
+	}
+
+	return Handler(ctx, uaws.GetDynamoDB(sess), storage, request, cfg)
+
+}
+
+func main() {
+	lambda.Start(initHandler)
+}
@@ -0,0 +1,156 @@
+//Lambda function searching users for the support tools:
+// - GET /users/search?q=smith @acme.com&limit=25
+//Requests are authenticated with an API key holding the users:search scope
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"strconv"
+
+	"github.com/rs/zerolog/log"
+
+	"github.com/aws/aws-lambda-go/events"
+	"github.com/aws/aws-lambda-go/lambda"
+	"github.com/aws/aws-sdk-go/service/dynamodb"
+	"github.com/roloum/users/internal/apperr"
+	"github.com/roloum/users/internal/auth"
+	uaws "github.com/roloum/users/internal/aws"
+	"github.com/roloum/users/internal/config"
+	"github.com/roloum/users/internal/search"
+	"github.com/roloum/users/internal/user"
+)
+
+const (
+	//MsgOK message returned when the request succeeds
+	MsgOK = "OK"
+
+	//ErrorInvalidLimit message returned when the limit is not a number
+	ErrorInvalidLimit = "InvalidLimit"
+)
+
+func init() {
+	apperr.Register(http.StatusBadRequest, ErrorInvalidLimit)
+}
+
+type (
+	// searchResponse
+	searchResponse struct {
+		StatusCode int          `json:"status"`
+		Message    string       `json:"message"`
+		Users      []*user.User `json:"users,omitempty"`
+	}
+
+	// Response is of type APIGatewayProxyResponse since we're leveraging the
+	// AWS Lambda Proxy Request functionality (default behavior)
+	//
+	// https://serverless.com/framework/docs/providers/aws/events/apigateway/#lambda-proxy-integration
+	Response events.APIGatewayProxyResponse
+
+	configuration struct {
+		AWS struct {
+			DynamoDB struct {
+				Table struct {
+					User string `required:"true"`
+				}
+			}
+			Region string `required:"true"`
+		}
+		Search search.Config
+	}
+)
+
+// Handler is our lambda handler invoked by the `lambda.Start` function call
+func Handler(ctx context.Context, dynamoDB *dynamodb.DynamoDB,
+	request events.APIGatewayProxyRequest,
+	cfg configuration) (Response, error) {
+
+	p, err := auth.Authenticate(ctx, dynamoDB, cfg.AWS.DynamoDB.Table.User,
+		request.Headers)
+	if err != nil {
+		return getProblem(err, request)
+	}
+
+	if err := p.Require(auth.ScopeUsersSearch); err != nil {
+		return getProblem(err, request)
+	}
+
+	limit := user.SearchDefaultLimit
+	if l, ok := request.QueryStringParameters["limit"]; ok {
+		if limit, err = strconv.Atoi(l); err != nil {
+			return getProblem(errors.New(ErrorInvalidLimit), request)
+		}
+	}
+
+	query := request.QueryStringParameters["q"]
+
+	log.Info().Msgf("Search by %s: %s", p.User.Email, query)
+
+	backend, err := search.New(cfg.Search, dynamoDB,
+		cfg.AWS.DynamoDB.Table.User)
+	if err != nil {
+		return getProblem(err, request)
+	}
+	defer backend.Close()
+
+	users, err := backend.Search(ctx, query, limit)
+	if err != nil {
+		return getProblem(err, request)
+	}
+
+	return getResponse(http.StatusOK, &searchResponse{Message: MsgOK,
+		Users: users})
+}
+
+// getProblem builds the application/problem+json response of err
+func getProblem(err error, request events.APIGatewayProxyRequest) (
+	Response, error) {
+	return Response(apperr.Response(err, request.Path)), nil
+}
+
+// getResponse builds an API Gateway Response
+func getResponse(statusCode int, resp *searchResponse) (Response, error) {
+
+	headers := map[string]string{
+		"Content-Type": "application/json",
+	}
+
+	resp.StatusCode = statusCode
+
+	js, err := json.Marshal(resp)
+	if err != nil {
+		return Response{StatusCode: http.StatusInternalServerError}, err
+	}
+
+	log.Debug().Msgf("status_code: %d, message: %s", resp.StatusCode, resp.Message)
+
+	return Response{Headers: headers, Body: string(js),
+		StatusCode: resp.StatusCode}, nil
+}
+
+func initHandler(ctx context.Context, request events.APIGatewayProxyRequest) (
+	Response, error) {
+
+	//Config holds the configuration for the application
+	var cfg configuration
+	err := config.Load(&cfg)
+	if err != nil {
+		return Response{}, err
+	}
+
+	log.Debug().Msg("initHandler function")
+
+	sess, err := uaws.GetSession(cfg.AWS.Region)
+	if err != nil {
+		return Response{}, err
+	}
+
+	return Handler(ctx, uaws.GetDynamoDB(sess), request, cfg)
+
+}
+
+func main() {
+	lambda.Start(initHandler)
+}
@@ -0,0 +1,91 @@
+//Lambda function that sweeps the users who signed up and never activated the
+//account. It runs on a schedule event:
+// - the users are reminded on the USERS_SWEEP_REMINDERS schedule, the notify
+//   function mails the reminders
+// - the accounts still inactive after USERS_SWEEP_CUTOFF are deleted, with the
+//   images of their avatar, freeing the email for a new sign up
+//The summary of the sweep is logged as JSON
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"time"
+
+	"github.com/rs/zerolog/log"
+
+	"github.com/aws/aws-lambda-go/events"
+	"github.com/aws/aws-lambda-go/lambda"
+	"github.com/aws/aws-sdk-go/service/dynamodb/dynamodbiface"
+	"github.com/roloum/users/internal/avatar"
+	uaws "github.com/roloum/users/internal/aws"
+	"github.com/roloum/users/internal/config"
+	"github.com/roloum/users/internal/user"
+)
+
+type configuration struct {
+	AWS struct {
+		DynamoDB struct {
+			Table struct {
+				User string `required:"true"`
+			}
+		}
+		Region string `required:"true"`
+	}
+	Sweep  user.SweepConfig
+	Avatar avatar.Config
+}
+
+//handler runs the sweep. The failed actions are in the summary and retried by
+//the next run, only an error reading the inactive users fails the invocation
+func handler(ctx context.Context, dynamoDB dynamodbiface.DynamoDBAPI,
+	e events.CloudWatchEvent, cfg configuration) (*user.SweepSummary, error) {
+
+	log.Info().Msgf("Sweep scheduled at %s", e.Time.Format(time.RFC3339))
+
+	summary, err := user.Sweep(ctx, dynamoDB, cfg.AWS.DynamoDB.Table.User,
+		cfg.Sweep, time.Now())
+	if err != nil {
+		return nil, err
+	}
+
+	js, err := json.Marshal(summary)
+	if err != nil {
+		return nil, err
+	}
+	log.Info().RawJSON("summary", js).Msg("Sweep summary")
+
+	return summary, nil
+}
+
+func initHandler(ctx context.Context, e events.CloudWatchEvent) (
+	*user.SweepSummary, error) {
+
+	var cfg configuration
+	err := config.Load(&cfg)
+	if err != nil {
+		return nil, err
+	}
+
+	log.Debug().Msg("initHandler function")
+
+	sess, err := uaws.GetSession(cfg.AWS.Region)
+	if err != nil {
+		return nil, err
+	}
+
+	storage, err := avatar.New(cfg.Avatar,
+		uaws.GetS3WithEndpoint(sess, cfg.Avatar.Endpoint))
+	if err != nil {
+		return nil, err
+	}
+	cfg.Sweep.DeleteAvatar = func(ctx context.Context, urls map[string]string) {
+		avatar.DeleteImages(ctx, storage, urls)
+	}
+
+	return handler(ctx, uaws.GetDynamoDB(sess), e, cfg)
+}
+
+func main() {
+	lambda.Start(initHandler)
+}
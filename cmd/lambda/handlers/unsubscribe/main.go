@@ -0,0 +1,150 @@
+//Lambda function of the unsubscribe links of the emails, signed by notifyUser:
+// - GET shows a page confirming the unsubscription with a button, so link
+//   scanners opening the URL do not unsubscribe the user
+// - POST unsubscribes, it is the RFC 8058 one-click request mail clients send
+//   with the body List-Unsubscribe=One-Click
+package main
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"html/template"
+	"net/http"
+
+	"github.com/rs/zerolog/log"
+
+	"github.com/aws/aws-lambda-go/events"
+	"github.com/aws/aws-lambda-go/lambda"
+	"github.com/aws/aws-sdk-go/service/dynamodb"
+	"github.com/roloum/users/internal/apperr"
+	uaws "github.com/roloum/users/internal/aws"
+	"github.com/roloum/users/internal/config"
+	"github.com/roloum/users/internal/user"
+)
+
+const (
+	//ErrorTokenIsEmpty message returned if token is empty
+	ErrorTokenIsEmpty = "TokenIsEmpty"
+)
+
+func init() {
+	apperr.Register(http.StatusBadRequest, ErrorTokenIsEmpty)
+}
+
+//page is the HTML of the confirmation and of the result
+var page = template.Must(template.New("unsubscribe").Parse(`<!DOCTYPE html>
+<html>
+<head><meta charset="utf-8"><title>Unsubscribe</title></head>
+<body>
+{{if .Done}}<p>You will no longer receive {{.Category}} emails.</p>
+{{else}}<form method="post">
+<p>Stop receiving {{.Category}} emails?</p>
+<button type="submit" name="List-Unsubscribe" value="One-Click">Unsubscribe</button>
+</form>
+{{end}}</body>
+</html>
+`))
+
+type (
+	// Response is of type APIGatewayProxyResponse since we're leveraging the
+	// AWS Lambda Proxy Request functionality (default behavior)
+	//
+	// https://serverless.com/framework/docs/providers/aws/events/apigateway/#lambda-proxy-integration
+	Response events.APIGatewayProxyResponse
+
+	configuration struct {
+		AWS struct {
+			DynamoDB struct {
+				Table struct {
+					User string `required:"true"`
+				}
+			}
+			Region string `required:"true"`
+		}
+		Email struct {
+			Unsubscribe struct {
+				Key string `required:"true"`
+			}
+		}
+	}
+)
+
+// Handler is our lambda handler invoked by the `lambda.Start` function call
+func Handler(ctx context.Context, dynamoDB *dynamodb.DynamoDB,
+	request events.APIGatewayProxyRequest,
+	cfg configuration) (Response, error) {
+
+	token := request.QueryStringParameters["token"]
+	if token == "" {
+		return getProblem(errors.New(ErrorTokenIsEmpty), request)
+	}
+
+	if request.HTTPMethod != http.MethodPost {
+		_, category, err := user.ParseUnsubscribeToken(
+			cfg.Email.Unsubscribe.Key, token)
+		if err != nil {
+			return getProblem(err, request)
+		}
+		return getPage(category, false)
+	}
+
+	u, category, err := user.Unsubscribe(ctx, dynamoDB,
+		cfg.AWS.DynamoDB.Table.User, cfg.Email.Unsubscribe.Key, token)
+	if err != nil {
+		return getProblem(err, request)
+	}
+
+	log.Info().Msgf("Unsubscribed %s from %s", u.Email, category)
+
+	return getPage(category, true)
+}
+
+// getProblem builds the application/problem+json response of err
+func getProblem(err error, request events.APIGatewayProxyRequest) (
+	Response, error) {
+	return Response(apperr.Response(err, request.Path)), nil
+}
+
+// getPage builds the HTML response of the confirmation or the result
+func getPage(category string, done bool) (Response, error) {
+
+	var body bytes.Buffer
+	if err := page.Execute(&body, struct {
+		Category string
+		Done     bool
+	}{category, done}); err != nil {
+		return Response{StatusCode: http.StatusInternalServerError}, err
+	}
+
+	return Response{StatusCode: http.StatusOK, Body: body.String(),
+		Headers: map[string]string{
+			"Content-Type":  "text/html; charset=utf-8",
+			"Cache-Control": "no-store",
+		}}, nil
+}
+
+func initHandler(ctx context.Context, request events.APIGatewayProxyRequest) (
+	Response, error) {
+
+	//Config holds the configuration for the application
+	var cfg configuration
+	err := config.Load(&cfg)
+	if err != nil {
+		return Response{}, err
+	}
+
+	log.Debug().Msg("initHandler function")
+
+	sess, err := uaws.GetSession(cfg.AWS.Region)
+	if err != nil {
+		return Response{}, err
+	}
+
+	return Handler(ctx, uaws.GetDynamoDB(sess), request, cfg)
+
+}
+
+func main() {
+	lambda.Start(initHandler)
+}
@@ -5,11 +5,18 @@ go 1.15
 require (
 	github.com/aws/aws-lambda-go v1.19.1
 	github.com/aws/aws-sdk-go v1.35.28
+	github.com/blevesearch/bleve/v2 v2.0.7
+	github.com/charmbracelet/bubbles v0.10.3
+	github.com/charmbracelet/bubbletea v0.20.0
 	github.com/go-playground/validator/v10 v10.4.1
 	github.com/google/uuid v1.1.2
 	github.com/kelseyhightower/envconfig v1.4.0
 	github.com/mcnijman/go-emailaddress v1.1.0
 	github.com/rs/zerolog v1.20.0
+	github.com/skip2/go-qrcode v0.0.0-20200617195104-da1b6568686e
 	github.com/spf13/cobra v1.1.1
-	golang.org/x/sys v0.0.0-20190626221950-04f50cda93cb // indirect
+	github.com/spf13/pflag v1.0.5
+	golang.org/x/net v0.0.0-20200822124328-c89045814202
+	golang.org/x/text v0.3.2
+	gopkg.in/yaml.v2 v2.2.8
 )
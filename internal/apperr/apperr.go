@@ -0,0 +1,201 @@
+//Package apperr is the error model shared by the packages and the handlers.
+//An Error carries a machine code, the HTTP status, whether retrying can
+//succeed and the details of each invalid field. The packages keep returning
+//their ErrorXxx codes and register the status of each one, so From can turn
+//any error into an Error, and the handlers into an RFC 7807 problem
+package apperr
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/awserr"
+	"github.com/aws/aws-sdk-go/aws/request"
+	"github.com/aws/aws-sdk-go/service/dynamodb"
+)
+
+const (
+	//ErrorValidation Returned when one or more fields are invalid, the fields
+	//are listed in Fields
+	ErrorValidation = "ValidationFailed"
+
+	//ErrorInvalidBody Returned when the request body is not valid JSON
+	ErrorInvalidBody = "InvalidRequestBody"
+
+	//ErrorServiceUnavailable Returned when AWS throttles or fails the request,
+	//it can be retried
+	ErrorServiceUnavailable = "ServiceUnavailable"
+
+	//ErrorTimeout Returned when the request ran out of time, it can be retried
+	ErrorTimeout = "Timeout"
+
+	//ErrorInternal Returned for the errors that are not known, the cause is
+	//logged but never sent to the client
+	ErrorInternal = "InternalError"
+)
+
+//statuses maps the registered codes to their HTTP status
+var statuses = map[string]int{
+	ErrorValidation:         http.StatusUnprocessableEntity,
+	ErrorInvalidBody:        http.StatusBadRequest,
+	ErrorServiceUnavailable: http.StatusServiceUnavailable,
+	ErrorTimeout:            http.StatusGatewayTimeout,
+	ErrorInternal:           http.StatusInternalServerError,
+}
+
+//FieldError describes an invalid field
+type FieldError struct {
+	Field string `json:"field"`
+	Code  string `json:"code"`
+}
+
+//Error is an error with a machine code. Error() returns the code, so the
+//comparisons with the ErrorXxx constants keep working
+type Error struct {
+	Code      string
+	Status    int
+	Retryable bool
+	Detail    string
+	Fields    []FieldError
+	Err       error
+}
+
+//Error implements error
+func (e *Error) Error() string {
+	return e.Code
+}
+
+//Unwrap returns the cause
+func (e *Error) Unwrap() error {
+	return e.Err
+}
+
+//Is reports whether target has the same code, either another Error or a
+//plain error built from the code, as in errors.New(user.ErrorDuplicateUser)
+func (e *Error) Is(target error) bool {
+	if t, ok := target.(*Error); ok {
+		return t.Code == e.Code
+	}
+	return target.Error() == e.Code
+}
+
+//Register sets the HTTP status of the codes. Packages call it from init
+func Register(status int, codes ...string) {
+	for _, c := range codes {
+		statuses[c] = status
+	}
+}
+
+//Status returns the HTTP status registered for the code, 500 when there is
+//none
+func Status(code string) int {
+	if s, ok := statuses[code]; ok {
+		return s
+	}
+	return http.StatusInternalServerError
+}
+
+//New returns an Error with the registered status of the code
+func New(code string) *Error {
+	return &Error{Code: code, Status: Status(code)}
+}
+
+//Validation returns the ErrorValidation listing every invalid field
+func Validation(fields ...FieldError) *Error {
+	e := New(ErrorValidation)
+	e.Fields = fields
+	return e
+}
+
+//From classifies err:
+// - an Error is returned as is
+// - AWS throttling and server faults are retryable 503
+// - a registered code gets its status
+// - JSON syntax errors are 400
+// - anything else is a 500 wrapping err
+func From(err error) *Error {
+
+	if err == nil {
+		return nil
+	}
+
+	var e *Error
+	if errors.As(err, &e) {
+		if e.Status == 0 {
+			e.Status = Status(e.Code)
+		}
+		return e
+	}
+
+	if errors.Is(err, context.DeadlineExceeded) {
+		return &Error{Code: ErrorTimeout, Status: http.StatusGatewayTimeout,
+			Retryable: true, Err: err}
+	}
+
+	var aerr awserr.Error
+	if errors.As(err, &aerr) {
+		if retryableAWS(aerr) {
+			return &Error{Code: ErrorServiceUnavailable,
+				Status: http.StatusServiceUnavailable, Retryable: true, Err: err}
+		}
+		return &Error{Code: ErrorInternal, Status: http.StatusInternalServerError,
+			Err: err}
+	}
+
+	if s, ok := statuses[err.Error()]; ok {
+		return &Error{Code: err.Error(), Status: s, Err: err}
+	}
+
+	var serr *json.SyntaxError
+	var terr *json.UnmarshalTypeError
+	if errors.As(err, &serr) || errors.As(err, &terr) {
+		return &Error{Code: ErrorInvalidBody, Status: http.StatusBadRequest,
+			Detail: err.Error(), Err: err}
+	}
+
+	return &Error{Code: ErrorInternal, Status: http.StatusInternalServerError,
+		Err: err}
+}
+
+//IsRetryable tells whether retrying the request that failed with err can
+//succeed
+func IsRetryable(err error) bool {
+	e := From(err)
+	return e != nil && e.Retryable
+}
+
+//retryableAWS tells whether the AWS error is transient
+func retryableAWS(aerr awserr.Error) bool {
+
+	switch aerr.Code() {
+	case dynamodb.ErrCodeProvisionedThroughputExceededException,
+		dynamodb.ErrCodeRequestLimitExceeded,
+		dynamodb.ErrCodeInternalServerError,
+		dynamodb.ErrCodeTransactionInProgressException,
+		"ThrottlingException", "Throttling", "ServiceUnavailable", "RequestTimeout",
+		request.ErrCodeResponseTimeout, request.ErrCodeRequestError:
+		return true
+	}
+
+	//A transaction canceled by throttling or a concurrent transaction can be
+	//retried, one canceled by a failed condition can not
+	if terr, ok := aerr.(*dynamodb.TransactionCanceledException); ok {
+		for _, r := range terr.CancellationReasons {
+			switch aws.StringValue(r.Code) {
+			case "ThrottlingError", "TransactionConflict",
+				"ProvisionedThroughputExceeded":
+				return true
+			}
+		}
+		return false
+	}
+
+	if rerr, ok := aerr.(awserr.RequestFailure); ok {
+		return rerr.StatusCode() >= http.StatusInternalServerError
+	}
+
+	return false
+}
@@ -0,0 +1,144 @@
+package apperr
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"reflect"
+	"testing"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/awserr"
+	"github.com/aws/aws-sdk-go/service/dynamodb"
+)
+
+//TestFrom Tests the classification of the errors
+func TestFrom(t *testing.T) {
+
+	Register(http.StatusConflict, "TestDuplicate")
+
+	var js map[string]string
+	jerr := json.Unmarshal([]byte("{"), &js)
+
+	tests := []struct {
+		desc      string
+		err       error
+		code      string
+		status    int
+		retryable bool
+	}{
+		{desc: "Registered", err: errors.New("TestDuplicate"),
+			code: "TestDuplicate", status: http.StatusConflict},
+		{desc: "Wrapped", err: fmt.Errorf("saving: %w", New("TestDuplicate")),
+			code: "TestDuplicate", status: http.StatusConflict},
+		{desc: "Validation", err: Validation(FieldError{Field: "email",
+			Code: "InvalidEmail"}), code: ErrorValidation,
+			status: http.StatusUnprocessableEntity},
+		{desc: "Throttled", err: awserr.New(
+			dynamodb.ErrCodeProvisionedThroughputExceededException, "", nil),
+			code: ErrorServiceUnavailable, status: http.StatusServiceUnavailable,
+			retryable: true},
+		{desc: "TransactionThrottled", err: &dynamodb.TransactionCanceledException{
+			CancellationReasons: []*dynamodb.CancellationReason{
+				{Code: aws.String("None")}, {Code: aws.String("ThrottlingError")}}},
+			code: ErrorServiceUnavailable, status: http.StatusServiceUnavailable,
+			retryable: true},
+		{desc: "TransactionCanceled", err: &dynamodb.TransactionCanceledException{
+			CancellationReasons: []*dynamodb.CancellationReason{
+				{Code: aws.String("ValidationError")}}},
+			code: ErrorInternal, status: http.StatusInternalServerError},
+		{desc: "AWSFault", err: awserr.New(
+			dynamodb.ErrCodeResourceNotFoundException, "", nil),
+			code: ErrorInternal, status: http.StatusInternalServerError},
+		{desc: "Deadline", err: context.DeadlineExceeded, code: ErrorTimeout,
+			status: http.StatusGatewayTimeout, retryable: true},
+		{desc: "InvalidJSON", err: jerr, code: ErrorInvalidBody,
+			status: http.StatusBadRequest},
+		{desc: "Unknown", err: errors.New("boom"), code: ErrorInternal,
+			status: http.StatusInternalServerError},
+	}
+
+	for _, tc := range tests {
+		t.Run(tc.desc, func(t *testing.T) {
+			e := From(tc.err)
+			if e.Code != tc.code || e.Status != tc.status ||
+				e.Retryable != tc.retryable {
+				t.Errorf("Expected: %v %v %v. Received: %v %v %v", tc.code,
+					tc.status, tc.retryable, e.Code, e.Status, e.Retryable)
+			}
+		})
+	}
+
+	if From(nil) != nil {
+		t.Error("Expected: nil")
+	}
+}
+
+//TestIs Tests matching the errors by code
+func TestIs(t *testing.T) {
+
+	err := fmt.Errorf("creating: %w", New("TestDuplicate"))
+
+	if !errors.Is(err, errors.New("TestDuplicate")) {
+		t.Error("Expected the plain error to match")
+	}
+	if !errors.Is(err, New("TestDuplicate")) {
+		t.Error("Expected the Error to match")
+	}
+	if errors.Is(err, errors.New("Other")) {
+		t.Error("Expected another code not to match")
+	}
+
+	var e *Error
+	if !errors.As(err, &e) || e.Code != "TestDuplicate" {
+		t.Errorf("Expected: TestDuplicate. Received: %v", e)
+	}
+}
+
+//TestResponse Tests the problem document of the response
+func TestResponse(t *testing.T) {
+
+	t.Run("Validation", func(t *testing.T) {
+		res := Response(Validation(FieldError{Field: "email",
+			Code: "InvalidEmail"}), "/users")
+
+		expected := Problem{Type: "urn:users:error:ValidationFailed",
+			Title: "Unprocessable Entity", Status: http.StatusUnprocessableEntity,
+			Instance: "/users", Code: ErrorValidation,
+			Errors: []FieldError{{Field: "email", Code: "InvalidEmail"}}}
+
+		var p Problem
+		if err := json.Unmarshal([]byte(res.Body), &p); err != nil {
+			t.Fatal(err)
+		}
+		if !reflect.DeepEqual(p, expected) {
+			t.Errorf("Expected: %+v. Received: %+v", expected, p)
+		}
+		if res.StatusCode != http.StatusUnprocessableEntity ||
+			res.Headers["Content-Type"] != ContentTypeProblem {
+			t.Errorf("Unexpected response: %+v", res)
+		}
+	})
+
+	t.Run("Retryable", func(t *testing.T) {
+		res := Response(awserr.New(dynamodb.ErrCodeRequestLimitExceeded, "",
+			nil), "/users")
+		if res.StatusCode != http.StatusServiceUnavailable ||
+			res.Headers["Retry-After"] == "" {
+			t.Errorf("Unexpected response: %+v", res)
+		}
+	})
+
+	t.Run("CauseIsHidden", func(t *testing.T) {
+		res := Response(errors.New("table users is gone"), "/users")
+		var p Problem
+		if err := json.Unmarshal([]byte(res.Body), &p); err != nil {
+			t.Fatal(err)
+		}
+		if p.Detail != "" || p.Code != ErrorInternal {
+			t.Errorf("Unexpected problem: %+v", p)
+		}
+	})
+}
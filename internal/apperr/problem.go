@@ -0,0 +1,76 @@
+package apperr
+
+import (
+	"encoding/json"
+	"net/http"
+
+	"github.com/rs/zerolog/log"
+
+	"github.com/aws/aws-lambda-go/events"
+)
+
+const (
+	//ContentTypeProblem media type of the problem documents
+	ContentTypeProblem = "application/problem+json"
+
+	//typePrefix prefix of the problem type, followed by the code
+	typePrefix = "urn:users:error:"
+)
+
+//Problem is the RFC 7807 problem document. Code, Retryable and Errors are
+//extension members
+type Problem struct {
+	Type      string       `json:"type"`
+	Title     string       `json:"title"`
+	Status    int          `json:"status"`
+	Detail    string       `json:"detail,omitempty"`
+	Instance  string       `json:"instance,omitempty"`
+	Code      string       `json:"code"`
+	Retryable bool         `json:"retryable,omitempty"`
+	Errors    []FieldError `json:"errors,omitempty"`
+}
+
+//Problem returns the problem document of the error. The cause of a 5xx is not
+//part of it
+func (e *Error) Problem(instance string) *Problem {
+	return &Problem{
+		Type:      typePrefix + e.Code,
+		Title:     http.StatusText(e.Status),
+		Status:    e.Status,
+		Detail:    e.Detail,
+		Instance:  instance,
+		Code:      e.Code,
+		Retryable: e.Retryable,
+		Errors:    e.Fields,
+	}
+}
+
+//Response returns the API Gateway response with the problem document of err.
+//Server errors are logged with their cause
+func Response(err error, instance string) events.APIGatewayProxyResponse {
+
+	e := From(err)
+
+	if e.Status >= http.StatusInternalServerError {
+		log.Error().Err(e.Err).Str("code", e.Code).Msg("Request failed")
+	} else {
+		log.Debug().Str("code", e.Code).Int("status", e.Status).
+			Msg("Request rejected")
+	}
+
+	headers := map[string]string{
+		"Content-Type": ContentTypeProblem,
+	}
+	if e.Retryable {
+		headers["Retry-After"] = "1"
+	}
+
+	js, jerr := json.Marshal(e.Problem(instance))
+	if jerr != nil {
+		return events.APIGatewayProxyResponse{
+			StatusCode: http.StatusInternalServerError}
+	}
+
+	return events.APIGatewayProxyResponse{Headers: headers, Body: string(js),
+		StatusCode: e.Status}
+}
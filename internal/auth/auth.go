@@ -0,0 +1,131 @@
+//Package auth authenticates the requests to the user endpoints, which accept
+//either a session or a personal API key as bearer token
+package auth
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"strings"
+
+	"github.com/rs/zerolog/log"
+
+	"github.com/aws/aws-sdk-go/service/dynamodb/dynamodbiface"
+
+	"github.com/roloum/users/internal/apperr"
+	"github.com/roloum/users/internal/user"
+)
+
+const (
+	//ScopeProfileRead allows reading the profile
+	ScopeProfileRead = "profile:read"
+
+	//ScopeProfileWrite allows modifying the profile
+	ScopeProfileWrite = "profile:write"
+
+	//ScopeUsersSearch allows searching every user. It is an admin scope, only
+	//granted to API keys created with the CLI
+	ScopeUsersSearch = "users:search"
+
+	//ScopeUsersDeliveries allows reading the email delivery history of every
+	//user. It is an admin scope
+	ScopeUsersDeliveries = "users:deliveries"
+
+	//ErrorUnauthorized Returned when the request has no valid credentials
+	ErrorUnauthorized = "Unauthorized"
+
+	//ErrorForbidden Returned when the credentials lack the scope required
+	ErrorForbidden = "Forbidden"
+
+	//ErrorScopeNotAllowed Returned when a user asks for an admin scope
+	ErrorScopeNotAllowed = "ScopeNotAllowed"
+)
+
+//init registers the HTTP status of the errors of the package
+func init() {
+	apperr.Register(http.StatusUnauthorized, ErrorUnauthorized)
+	apperr.Register(http.StatusForbidden, ErrorForbidden, ErrorScopeNotAllowed)
+}
+
+//adminScopes are never granted to sessions nor to self-service API keys
+var adminScopes = map[string]bool{
+	ScopeUsersSearch:     true,
+	ScopeUsersDeliveries: true,
+}
+
+//Principal is the authenticated user. Sessions are granted every scope but
+//the admin ones, API keys only the scopes they were created with
+type Principal struct {
+	User   *user.User
+	APIKey *user.APIKey
+}
+
+//HasScope returns true when the principal is allowed the scope
+func (p *Principal) HasScope(scope string) bool {
+	if p.APIKey == nil {
+		return !adminScopes[scope]
+	}
+	for _, s := range p.APIKey.Scopes {
+		if s == scope {
+			return true
+		}
+	}
+	return false
+}
+
+//Require returns ErrorForbidden when the principal lacks the scope
+func (p *Principal) Require(scope string) error {
+	if !p.HasScope(scope) {
+		return errors.New(ErrorForbidden)
+	}
+	return nil
+}
+
+//CheckSelfServiceScopes returns ErrorScopeNotAllowed when a user creating
+//their own API key asks for an admin scope
+func CheckSelfServiceScopes(scopes []string) error {
+	for _, s := range scopes {
+		if adminScopes[s] {
+			return errors.New(ErrorScopeNotAllowed)
+		}
+	}
+	return nil
+}
+
+//Authenticate authenticates the bearer token of the Authorization header
+func Authenticate(ctx context.Context, svc dynamodbiface.DynamoDBAPI,
+	tableName string, headers map[string]string) (*Principal, error) {
+
+	token := BearerToken(headers)
+	if token == "" {
+		return nil, errors.New(ErrorUnauthorized)
+	}
+
+	if strings.HasPrefix(token, user.APIKeyPrefix) {
+		u, k, err := user.AuthenticateAPIKey(ctx, svc, tableName, token)
+		if err != nil {
+			log.Debug().Msg(err.Error())
+			return nil, errors.New(ErrorUnauthorized)
+		}
+		return &Principal{User: u, APIKey: k}, nil
+	}
+
+	u, err := user.LoadSession(ctx, svc, tableName, token)
+	if err != nil {
+		log.Debug().Msg(err.Error())
+		return nil, errors.New(ErrorUnauthorized)
+	}
+
+	return &Principal{User: u}, nil
+}
+
+//BearerToken returns the bearer token of the Authorization header
+func BearerToken(headers map[string]string) string {
+	for k, v := range headers {
+		if strings.EqualFold(k, "Authorization") &&
+			strings.HasPrefix(v, "Bearer ") {
+			return strings.TrimSpace(strings.TrimPrefix(v, "Bearer "))
+		}
+	}
+	return ""
+}
@@ -0,0 +1,264 @@
+//Package avatar processes the avatar images of the users and keeps them in a
+//storage: S3 (or an S3 compatible server) for the Lambda functions and a
+//directory for the CLI
+package avatar
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/rs/zerolog/log"
+
+	"github.com/aws/aws-sdk-go/service/dynamodb/dynamodbiface"
+	"github.com/aws/aws-sdk-go/service/s3/s3iface"
+
+	"github.com/roloum/users/internal/apperr"
+	"github.com/roloum/users/internal/user"
+)
+
+const (
+	//StorageS3 keeps the images in an S3 bucket
+	StorageS3 = "s3"
+
+	//StorageFS keeps the images in a directory
+	StorageFS = "fs"
+
+	//UploadPrefix Prefix of the keys of the uploaded images, waiting to be
+	//processed
+	UploadPrefix = "uploads/"
+
+	//AvatarPrefix Prefix of the keys of the processed images
+	AvatarPrefix = "avatars/"
+
+	//ErrorInvalidImage Returned when the image can not be decoded or is not a
+	//JPEG, PNG or GIF
+	ErrorInvalidImage = "InvalidImage"
+
+	//ErrorImageTooLarge Returned when the file or the dimensions of the image
+	//exceed the limits
+	ErrorImageTooLarge = "ImageTooLarge"
+
+	//ErrorUnsupportedContentType Returned when requesting an upload of a type
+	//other than image/jpeg, image/png or image/gif
+	ErrorUnsupportedContentType = "UnsupportedContentType"
+
+	//ErrorUploadNotSupported Returned when the storage can not presign uploads
+	ErrorUploadNotSupported = "UploadNotSupported"
+
+	//ErrorInvalidUploadKey Returned for an uploaded object outside of
+	//uploads/<user id>/
+	ErrorInvalidUploadKey = "InvalidUploadKey"
+)
+
+//ContentTypes are the types accepted for the uploads
+var ContentTypes = map[string]bool{
+	"image/jpeg": true,
+	"image/png":  true,
+	"image/gif":  true,
+}
+
+//Config is the configuration of the avatar storage
+type Config struct {
+	//Storage is s3 or fs
+	Storage string `default:"s3"`
+
+	//Bucket of the s3 storage
+	Bucket string
+
+	//Endpoint of an S3 compatible server, such as MinIO, instead of AWS
+	Endpoint string
+
+	//Dir of the fs storage
+	Dir string
+
+	//BaseURL the URLs of the images start with, such as a CloudFront
+	//distribution. Defaults to the bucket URL or file:// and the directory
+	BaseURL string
+
+	//MaxBytes is the largest upload accepted
+	MaxBytes int64 `default:"5242880"`
+
+	//UploadTTL is how long a presigned upload URL is valid
+	UploadTTL time.Duration `default:"15m"`
+}
+
+//Upload is a presigned request to upload an image
+type Upload struct {
+	URL     string            `json:"url"`
+	Method  string            `json:"method"`
+	Headers map[string]string `json:"headers"`
+	Expires time.Time         `json:"expires"`
+}
+
+//Storage keeps the images by key
+type Storage interface {
+	//PresignUpload returns a request the client sends to upload the object
+	PresignUpload(ctx context.Context, key, contentType string,
+		ttl time.Duration) (*Upload, error)
+
+	Get(ctx context.Context, key string) (io.ReadCloser, error)
+	Put(ctx context.Context, key, contentType string, body []byte) error
+	Delete(ctx context.Context, key string) error
+
+	//URL returns the public URL of the object
+	URL(key string) string
+
+	//Key returns the key of a URL returned by URL, or an empty string
+	Key(url string) string
+}
+
+//init registers the HTTP status of the errors of the package
+func init() {
+	apperr.Register(http.StatusUnprocessableEntity, ErrorInvalidImage,
+		ErrorUnsupportedContentType)
+	apperr.Register(http.StatusRequestEntityTooLarge, ErrorImageTooLarge)
+	apperr.Register(http.StatusNotImplemented, ErrorUploadNotSupported)
+	apperr.Register(http.StatusBadRequest, ErrorInvalidUploadKey)
+}
+
+//New returns the storage of the configuration. svc is only used by s3
+func New(cfg Config, svc s3iface.S3API) (Storage, error) {
+	switch cfg.Storage {
+	case StorageS3, "":
+		if cfg.Bucket == "" {
+			return nil, errors.New("Missing avatar bucket")
+		}
+		return NewS3(svc, cfg.Bucket, cfg.Endpoint, cfg.BaseURL), nil
+	case StorageFS:
+		if cfg.Dir == "" {
+			return nil, errors.New("Missing avatar directory")
+		}
+		return NewFS(cfg.Dir, cfg.BaseURL), nil
+	}
+	return nil, fmt.Errorf("Unknown avatar storage: %s", cfg.Storage)
+}
+
+//RequestUpload presigns the upload of an image of the user, processed later
+//by the S3 event of its key
+func RequestUpload(ctx context.Context, storage Storage, cfg Config,
+	u *user.User, contentType string) (*Upload, error) {
+
+	if !ContentTypes[contentType] {
+		return nil, errors.New(ErrorUnsupportedContentType)
+	}
+
+	nonce, err := randomID()
+	if err != nil {
+		return nil, err
+	}
+
+	return storage.PresignUpload(ctx, UploadKey(u.ID, nonce), contentType,
+		cfg.UploadTTL)
+}
+
+//UploadKey returns the key of an upload of the user
+func UploadKey(userID, nonce string) string {
+	return UploadPrefix + userID + "/" + nonce
+}
+
+//ParseUploadKey returns the user id and nonce of an upload key
+func ParseUploadKey(key string) (string, string, error) {
+	parts := strings.Split(strings.TrimPrefix(key, UploadPrefix), "/")
+	if !strings.HasPrefix(key, UploadPrefix) || len(parts) != 2 ||
+		parts[0] == "" || parts[1] == "" {
+		return "", "", errors.New(ErrorInvalidUploadKey)
+	}
+	return parts[0], parts[1], nil
+}
+
+//imageKey returns the key of the image of a size. The nonce changes with
+//every upload, so caches never serve the previous avatar
+func imageKey(userID, nonce string, size int) string {
+	return fmt.Sprintf("%s%s/%s-%d.jpg", AvatarPrefix, userID, nonce, size)
+}
+
+//Set processes the image read from r and makes it the avatar of the user:
+//stores every size, saves their URLs on the profile and deletes the images
+//of the previous avatar
+func Set(ctx context.Context, storage Storage, svc dynamodbiface.DynamoDBAPI,
+	tableName string, u *user.User, nonce string, r io.Reader,
+	maxBytes int64) error {
+
+	images, err := Process(r, maxBytes)
+	if err != nil {
+		return err
+	}
+
+	urls := make(map[string]string, len(images))
+	for _, size := range Sizes {
+		key := imageKey(u.ID, nonce, size)
+		if err := storage.Put(ctx, key, "image/jpeg", images[size]); err != nil {
+			return err
+		}
+		urls[strconv.Itoa(size)] = storage.URL(key)
+	}
+
+	old, err := u.SetAvatar(ctx, svc, tableName, urls)
+	if err != nil {
+		return err
+	}
+
+	DeleteImages(ctx, storage, old)
+	return nil
+}
+
+//Remove removes the avatar of the user and deletes its images
+func Remove(ctx context.Context, storage Storage,
+	svc dynamodbiface.DynamoDBAPI, tableName string, u *user.User) error {
+
+	old, err := u.SetAvatar(ctx, svc, tableName, nil)
+	if err != nil {
+		return err
+	}
+
+	DeleteImages(ctx, storage, old)
+	return nil
+}
+
+//Purge deletes the avatar images of an user about to be deleted. An user
+//whose profile is already gone is not an error, the deletion goes on
+func Purge(ctx context.Context, storage Storage,
+	svc dynamodbiface.DynamoDBAPI, tableName string, u *user.User) error {
+
+	err := Remove(ctx, storage, svc, tableName, u)
+	if err != nil && err.Error() == user.ErrorUserDoesNotExist {
+		return nil
+	}
+	return err
+}
+
+//DeleteImages deletes the images of the URLs. The failures are only logged,
+//the profile no longer points to them
+func DeleteImages(ctx context.Context, storage Storage,
+	urls map[string]string) {
+
+	for _, url := range urls {
+		key := storage.Key(url)
+		if key == "" {
+			continue
+		}
+		if err := storage.Delete(ctx, key); err != nil {
+			log.Warn().Err(err).Msgf("Deleting avatar image: %s", key)
+		}
+	}
+}
+
+//readAll reads up to maxBytes from r
+func readAll(r io.Reader, maxBytes int64) ([]byte, error) {
+	var buf bytes.Buffer
+	n, err := buf.ReadFrom(io.LimitReader(r, maxBytes+1))
+	if err != nil {
+		return nil, err
+	}
+	if n > maxBytes {
+		return nil, errors.New(ErrorImageTooLarge)
+	}
+	return buf.Bytes(), nil
+}
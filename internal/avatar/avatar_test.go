@@ -0,0 +1,392 @@
+package avatar
+
+import (
+	"bytes"
+	"context"
+	"encoding/binary"
+	"errors"
+	"image"
+	"image/color"
+	"image/jpeg"
+	"image/png"
+	"io/ioutil"
+	"net/url"
+	"path/filepath"
+	"reflect"
+	"strconv"
+	"strings"
+	"testing"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/awserr"
+	"github.com/aws/aws-sdk-go/aws/credentials"
+	"github.com/aws/aws-sdk-go/aws/session"
+	"github.com/aws/aws-sdk-go/service/dynamodb"
+	"github.com/aws/aws-sdk-go/service/dynamodb/dynamodbattribute"
+
+	uaws "github.com/roloum/users/internal/aws"
+	"github.com/roloum/users/internal/test"
+	"github.com/roloum/users/internal/user"
+)
+
+//halves returns a w x h image, red on the left half and blue on the right
+func halves(w, h int) *image.RGBA {
+	img := image.NewRGBA(image.Rect(0, 0, w, h))
+	for y := 0; y < h; y++ {
+		for x := 0; x < w; x++ {
+			c := color.RGBA{R: 255, A: 255}
+			if x >= w/2 {
+				c = color.RGBA{B: 255, A: 255}
+			}
+			img.Set(x, y, c)
+		}
+	}
+	return img
+}
+
+//withOrientation returns a JPEG of the image with an EXIF segment holding
+//the orientation, right after the start of image marker
+func withOrientation(t *testing.T, img image.Image, orientation int) []byte {
+	var buf bytes.Buffer
+	if err := jpeg.Encode(&buf, img, nil); err != nil {
+		t.Fatal(err)
+	}
+
+	tiff := []byte("MM\x00\x2a\x00\x00\x00\x08\x00\x01")
+	entry := make([]byte, 12)
+	binary.BigEndian.PutUint16(entry[0:], 0x0112)
+	binary.BigEndian.PutUint16(entry[2:], 3)
+	binary.BigEndian.PutUint32(entry[4:], 1)
+	binary.BigEndian.PutUint16(entry[8:], uint16(orientation))
+	tiff = append(append(tiff, entry...), 0, 0, 0, 0)
+
+	segment := append([]byte("Exif\x00\x00"), tiff...)
+	header := []byte{0xFF, 0xE1, 0, 0}
+	binary.BigEndian.PutUint16(header[2:], uint16(len(segment)+2))
+
+	data := buf.Bytes()
+	out := append([]byte{}, data[:2]...)
+	out = append(append(out, header...), segment...)
+	return append(out, data[2:]...)
+}
+
+func encodePNG(t *testing.T, img image.Image) []byte {
+	var buf bytes.Buffer
+	if err := png.Encode(&buf, img); err != nil {
+		t.Fatal(err)
+	}
+	return buf.Bytes()
+}
+
+//isRed tells whether the pixel is mostly red
+func isRed(c color.Color) bool {
+	r, _, b, _ := c.RGBA()
+	return r > 0xC000 && b < 0x4000
+}
+
+//TestProcess Tests decoding, limits and the sizes of the images
+func TestProcess(t *testing.T) {
+
+	tests := []struct {
+		desc     string
+		data     []byte
+		maxBytes int64
+		err      error
+	}{
+		{desc: "PNG", data: encodePNG(t, halves(300, 200)), maxBytes: 1 << 20},
+		{desc: "Small", data: encodePNG(t, halves(10, 10)), maxBytes: 1 << 20},
+		{desc: "NotAnImage", data: []byte("GIF89a nope"), maxBytes: 1 << 20,
+			err: errors.New(ErrorInvalidImage)},
+		{desc: "FileTooLarge", data: encodePNG(t, halves(300, 200)),
+			maxBytes: 100, err: errors.New(ErrorImageTooLarge)},
+		{desc: "DimensionsTooLarge",
+			data:     encodePNG(t, image.NewGray(image.Rect(0, 0, MaxDimension+1, 1))),
+			maxBytes: 1 << 20, err: errors.New(ErrorImageTooLarge)},
+	}
+
+	for _, tc := range tests {
+		t.Run(tc.desc, func(t *testing.T) {
+			images, err := Process(bytes.NewReader(tc.data), tc.maxBytes)
+			if !reflect.DeepEqual(err, tc.err) {
+				t.Fatalf("Expected: %v. Received: %v", tc.err, err)
+			}
+			if err != nil {
+				return
+			}
+
+			for _, size := range Sizes {
+				cfg, format, err := image.DecodeConfig(bytes.NewReader(images[size]))
+				if err != nil {
+					t.Fatal(err)
+				}
+				if format != "jpeg" || cfg.Width != size || cfg.Height != size {
+					t.Errorf("Expected: jpeg %dx%d. Received: %s %dx%d", size,
+						size, format, cfg.Width, cfg.Height)
+				}
+			}
+		})
+	}
+}
+
+//TestProcessOrientation Tests that the EXIF orientation is applied and the
+//EXIF segment is dropped
+func TestProcessOrientation(t *testing.T) {
+
+	//The red half ends on the left unrotated, on top after rotating 90°
+	//clockwise and at the bottom after rotating 90° counterclockwise
+	tests := []struct {
+		orientation int
+		expected    string
+	}{
+		{orientation: 1, expected: "left"},
+		{orientation: 6, expected: "top"},
+		{orientation: 8, expected: "bottom"},
+	}
+
+	for _, tc := range tests {
+		data := withOrientation(t, halves(200, 200), tc.orientation)
+		if o := exifOrientation(data); o != tc.orientation {
+			t.Fatalf("Expected: %v. Received: %v", tc.orientation, o)
+		}
+
+		images, err := Process(bytes.NewReader(data), 1<<20)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		out := images[64]
+		if bytes.Contains(out, []byte("Exif")) {
+			t.Errorf("Expected the EXIF segment to be dropped")
+		}
+
+		img, err := jpeg.Decode(bytes.NewReader(out))
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		side := ""
+		switch {
+		case isRed(img.At(2, 32)) && isRed(img.At(2, 60)) && isRed(img.At(2, 4)):
+			side = "left"
+		case isRed(img.At(32, 2)) && isRed(img.At(60, 2)) && isRed(img.At(4, 2)):
+			side = "top"
+		case isRed(img.At(32, 61)) && isRed(img.At(60, 61)) && isRed(img.At(4, 61)):
+			side = "bottom"
+		}
+		if side != tc.expected {
+			t.Errorf("Orientation %d. Expected: %v. Received: %v",
+				tc.orientation, tc.expected, side)
+		}
+	}
+}
+
+//TestParseUploadKey Tests the keys accepted by the avatar handler
+func TestParseUploadKey(t *testing.T) {
+
+	tests := []struct {
+		key   string
+		id    string
+		nonce string
+		err   error
+	}{
+		{key: UploadKey("u1", "n1"), id: "u1", nonce: "n1"},
+		{key: "uploads/u1", err: errors.New(ErrorInvalidUploadKey)},
+		{key: "uploads/u1/n1/x", err: errors.New(ErrorInvalidUploadKey)},
+		{key: "avatars/u1/n1", err: errors.New(ErrorInvalidUploadKey)},
+	}
+
+	for _, tc := range tests {
+		t.Run(tc.key, func(t *testing.T) {
+			id, nonce, err := ParseUploadKey(tc.key)
+			if id != tc.id || nonce != tc.nonce || !reflect.DeepEqual(err, tc.err) {
+				t.Errorf("Expected: %v %v %v. Received: %v %v %v", tc.id,
+					tc.nonce, tc.err, id, nonce, err)
+			}
+		})
+	}
+}
+
+//TestFS Tests the filesystem storage
+func TestFS(t *testing.T) {
+
+	dir, err := ioutil.TempDir("", "avatar")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	ctx := context.Background()
+	s := NewFS(dir, "http://localhost:8080/")
+
+	if err := s.Put(ctx, "avatars/u1/a-64.jpg", "image/jpeg",
+		[]byte("jpeg")); err != nil {
+		t.Fatal(err)
+	}
+
+	r, err := s.Get(ctx, "avatars/u1/a-64.jpg")
+	if err != nil {
+		t.Fatal(err)
+	}
+	body, _ := ioutil.ReadAll(r)
+	r.Close()
+	if string(body) != "jpeg" {
+		t.Errorf("Expected: jpeg. Received: %s", body)
+	}
+
+	u := s.URL("avatars/u1/a-64.jpg")
+	if expected := "http://localhost:8080/avatars/u1/a-64.jpg"; u != expected {
+		t.Errorf("Expected: %v. Received: %v", expected, u)
+	}
+	if key := s.Key(u); key != "avatars/u1/a-64.jpg" {
+		t.Errorf("Expected: %v. Received: %v", "avatars/u1/a-64.jpg", key)
+	}
+
+	if p := s.path("../../etc/passwd"); !strings.HasPrefix(p, dir) {
+		t.Errorf("Expected a path in %s. Received: %s", dir, p)
+	}
+
+	if err := s.Delete(ctx, "avatars/u1/a-64.jpg"); err != nil {
+		t.Fatal(err)
+	}
+	if err := s.Delete(ctx, "avatars/u1/a-64.jpg"); err != nil {
+		t.Errorf("Expected: <nil>. Received: %v", err)
+	}
+
+	_, err = s.PresignUpload(ctx, "uploads/u1/n", "image/png", 0)
+	if !reflect.DeepEqual(err, errors.New(ErrorUploadNotSupported)) {
+		t.Errorf("Expected: %v. Received: %v", ErrorUploadNotSupported, err)
+	}
+}
+
+//TestS3PresignUpload Tests presigning an upload to an S3 compatible server
+func TestS3PresignUpload(t *testing.T) {
+
+	sess := session.Must(session.NewSession(aws.NewConfig().
+		WithRegion("us-east-1").
+		WithCredentials(credentials.NewStaticCredentials("id", "secret", ""))))
+	endpoint := "http://localhost:9000"
+	s := NewS3(uaws.GetS3WithEndpoint(sess, endpoint), "avatars", endpoint, "")
+
+	upload, err := RequestUpload(context.Background(), s,
+		Config{UploadTTL: 900e9}, &user.User{ID: "u1"}, "image/png")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	parsed, err := url.Parse(upload.URL)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if parsed.Host != "localhost:9000" ||
+		!strings.HasPrefix(parsed.Path, "/avatars/uploads/u1/") ||
+		parsed.Query().Get("X-Amz-Signature") == "" {
+		t.Errorf("Unexpected presigned URL: %s", upload.URL)
+	}
+	if upload.Method != "PUT" || upload.Headers["Content-Type"] != "image/png" {
+		t.Errorf("Unexpected upload: %v", upload)
+	}
+
+	_, err = RequestUpload(context.Background(), s, Config{},
+		&user.User{ID: "u1"}, "image/svg+xml")
+	if !reflect.DeepEqual(err, errors.New(ErrorUnsupportedContentType)) {
+		t.Errorf("Expected: %v. Received: %v", ErrorUnsupportedContentType, err)
+	}
+
+	if expected := "http://localhost:9000/avatars/x.jpg"; s.URL("x.jpg") != expected {
+		t.Errorf("Expected: %v. Received: %v", expected, s.URL("x.jpg"))
+	}
+}
+
+//TestSet Tests storing the images and deleting the previous ones
+func TestSet(t *testing.T) {
+
+	dir, err := ioutil.TempDir("", "avatar")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	ctx := context.Background()
+	s := NewFS(dir, "http://localhost:8080")
+	if err := s.Put(ctx, "avatars/u1/old-64.jpg", "image/jpeg",
+		[]byte("old")); err != nil {
+		t.Fatal(err)
+	}
+
+	old, err := dynamodbattribute.Marshal(map[string]string{
+		"64": s.URL("avatars/u1/old-64.jpg")})
+	if err != nil {
+		t.Fatal(err)
+	}
+	mock := &test.MockDynamoDB{UpdateItemOutput: &dynamodb.UpdateItemOutput{
+		Attributes: map[string]*dynamodb.AttributeValue{"avatar": old}}}
+
+	u := &user.User{ID: "u1", Email: "a@user.com"}
+	err = Set(ctx, s, mock, "User", u, "new",
+		bytes.NewReader(encodePNG(t, halves(100, 100))), 1<<20)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for _, size := range Sizes {
+		key := imageKey("u1", "new", size)
+		if u.Avatar[strconv.Itoa(size)] != s.URL(key) {
+			t.Errorf("Missing URL of size %d: %v", size, u.Avatar)
+		}
+	}
+
+	if _, err := ioutil.ReadFile(filepath.Join(dir, "avatars", "u1",
+		"old-64.jpg")); err == nil {
+		t.Errorf("Expected the previous image to be deleted")
+	}
+}
+
+//TestPurge Tests deleting the images of an user about to be deleted
+func TestPurge(t *testing.T) {
+
+	dir, err := ioutil.TempDir("", "avatar")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	ctx := context.Background()
+	s := NewFS(dir, "http://localhost:8080")
+	if err := s.Put(ctx, "avatars/u1/a-64.jpg", "image/jpeg",
+		[]byte("a")); err != nil {
+		t.Fatal(err)
+	}
+
+	old, err := dynamodbattribute.Marshal(map[string]string{
+		"64": s.URL("avatars/u1/a-64.jpg")})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		desc string
+		mock *test.MockDynamoDB
+	}{
+		{
+			desc: "Avatar",
+			mock: &test.MockDynamoDB{UpdateItemOutput: &dynamodb.UpdateItemOutput{
+				Attributes: map[string]*dynamodb.AttributeValue{"avatar": old}}},
+		},
+		{
+			desc: user.ErrorUserDoesNotExist,
+			mock: &test.MockDynamoDB{OutputError: awserr.New(
+				dynamodb.ErrCodeConditionalCheckFailedException, "", nil)},
+		},
+	}
+
+	for _, tc := range tests {
+		t.Run(tc.desc, func(t *testing.T) {
+			u := &user.User{ID: "u1", Email: "a@user.com"}
+			if err := Purge(ctx, s, tc.mock, "User", u); err != nil {
+				t.Errorf("Expected: %v. Received: %v", nil, err)
+			}
+		})
+	}
+
+	if _, err := ioutil.ReadFile(filepath.Join(dir, "avatars", "u1",
+		"a-64.jpg")); err == nil {
+		t.Errorf("Expected the image to be deleted")
+	}
+}
@@ -0,0 +1,81 @@
+package avatar
+
+import (
+	"context"
+	"errors"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+//FS keeps the images in a directory, for the CLI and for running without S3.
+//The directory is expected to be served at the base URL
+type FS struct {
+	dir     string
+	baseURL string
+}
+
+//NewFS returns the storage of the directory. Without baseURL the URLs are
+//file:// URLs of the directory
+func NewFS(dir, baseURL string) *FS {
+	if baseURL == "" {
+		abs, err := filepath.Abs(dir)
+		if err != nil {
+			abs = dir
+		}
+		baseURL = "file://" + filepath.ToSlash(abs)
+	}
+	return &FS{dir: dir, baseURL: strings.TrimSuffix(baseURL, "/")}
+}
+
+//PresignUpload is not supported, the images are set with the CLI
+func (s *FS) PresignUpload(ctx context.Context, key, contentType string,
+	ttl time.Duration) (*Upload, error) {
+	return nil, errors.New(ErrorUploadNotSupported)
+}
+
+//Get opens the file of the key
+func (s *FS) Get(ctx context.Context, key string) (io.ReadCloser, error) {
+	return os.Open(s.path(key))
+}
+
+//Put writes the file of the key, creating its directories
+func (s *FS) Put(ctx context.Context, key, contentType string,
+	body []byte) error {
+
+	path := s.path(key)
+	if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+		return err
+	}
+	return ioutil.WriteFile(path, body, 0644)
+}
+
+//Delete removes the file of the key, a missing one is not an error
+func (s *FS) Delete(ctx context.Context, key string) error {
+	if err := os.Remove(s.path(key)); err != nil && !os.IsNotExist(err) {
+		return err
+	}
+	return nil
+}
+
+//URL returns the URL of the file
+func (s *FS) URL(key string) string {
+	return s.baseURL + "/" + key
+}
+
+//Key returns the key of a URL of the directory
+func (s *FS) Key(url string) string {
+	if !strings.HasPrefix(url, s.baseURL+"/") {
+		return ""
+	}
+	return strings.TrimPrefix(url, s.baseURL+"/")
+}
+
+//path returns the file of the key, which can not leave the directory
+func (s *FS) path(key string) string {
+	return filepath.Join(s.dir, filepath.FromSlash(
+		filepath.Clean("/"+key)))
+}
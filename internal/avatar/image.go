@@ -0,0 +1,257 @@
+package avatar
+
+import (
+	"bytes"
+	"crypto/rand"
+	"encoding/binary"
+	"encoding/hex"
+	"errors"
+	"image"
+	"image/draw"
+	"image/jpeg"
+	"io"
+
+	//Decoders of the accepted formats
+	_ "image/gif"
+	_ "image/png"
+)
+
+//Sizes are the sides in pixels of the square images stored for each avatar
+var Sizes = []int{64, 128, 256, 512}
+
+//MaxDimension is the largest width or height decoded, guarding against small
+//files that expand to huge images
+const MaxDimension = 4096
+
+//Quality of the JPEG images stored
+const Quality = 85
+
+//Process decodes a JPEG, PNG or GIF image of up to maxBytes and returns a
+//JPEG image of each size, by size. The images are cropped to a centered
+//square and rotated as the EXIF orientation says. Re-encoding drops the EXIF
+//and any other metadata of the original
+func Process(r io.Reader, maxBytes int64) (map[int][]byte, error) {
+
+	data, err := readAll(r, maxBytes)
+	if err != nil {
+		return nil, err
+	}
+
+	cfg, format, err := image.DecodeConfig(bytes.NewReader(data))
+	if err != nil {
+		return nil, errors.New(ErrorInvalidImage)
+	}
+	if cfg.Width > MaxDimension || cfg.Height > MaxDimension {
+		return nil, errors.New(ErrorImageTooLarge)
+	}
+	if cfg.Width < 1 || cfg.Height < 1 {
+		return nil, errors.New(ErrorInvalidImage)
+	}
+
+	img, _, err := image.Decode(bytes.NewReader(data))
+	if err != nil {
+		return nil, errors.New(ErrorInvalidImage)
+	}
+
+	square := toSquare(img)
+	if format == "jpeg" {
+		square = orient(square, exifOrientation(data))
+	}
+
+	images := make(map[int][]byte, len(Sizes))
+	for _, size := range Sizes {
+		var buf bytes.Buffer
+		if err := jpeg.Encode(&buf, resize(square, size),
+			&jpeg.Options{Quality: Quality}); err != nil {
+			return nil, err
+		}
+		images[size] = buf.Bytes()
+	}
+
+	return images, nil
+}
+
+//toSquare crops the centered square of the image over a white background,
+//so transparent pixels are white once encoded as JPEG
+func toSquare(img image.Image) *image.RGBA {
+	b := img.Bounds()
+	side := b.Dx()
+	if b.Dy() < side {
+		side = b.Dy()
+	}
+	origin := image.Pt(b.Min.X+(b.Dx()-side)/2, b.Min.Y+(b.Dy()-side)/2)
+
+	dst := image.NewRGBA(image.Rect(0, 0, side, side))
+	draw.Draw(dst, dst.Bounds(), image.White, image.Point{}, draw.Src)
+	draw.Draw(dst, dst.Bounds(), img, origin, draw.Over)
+	return dst
+}
+
+//resize scales the square image to size x size. Each pixel is the average of
+//the source pixels it covers, or the nearest one when enlarging
+func resize(src *image.RGBA, size int) *image.RGBA {
+	dst := image.NewRGBA(image.Rect(0, 0, size, size))
+	n := src.Bounds().Dx()
+
+	for y := 0; y < size; y++ {
+		sy0, sy1 := span(y, size, n)
+		for x := 0; x < size; x++ {
+			sx0, sx1 := span(x, size, n)
+
+			var sum [4]int
+			for sy := sy0; sy < sy1; sy++ {
+				off := src.PixOffset(sx0, sy)
+				for sx := sx0; sx < sx1; sx++ {
+					for c := 0; c < 4; c++ {
+						sum[c] += int(src.Pix[off+c])
+					}
+					off += 4
+				}
+			}
+
+			count := (sy1 - sy0) * (sx1 - sx0)
+			off := dst.PixOffset(x, y)
+			for c := 0; c < 4; c++ {
+				dst.Pix[off+c] = uint8(sum[c] / count)
+			}
+		}
+	}
+
+	return dst
+}
+
+//span returns the range of source pixels covered by the pixel i of size
+func span(i, size, n int) (int, int) {
+	lo := i * n / size
+	hi := (i + 1) * n / size
+	if hi <= lo {
+		hi = lo + 1
+	}
+	return lo, hi
+}
+
+//orient transforms the square image as the EXIF orientation (1 to 8) says,
+//the one cameras store instead of rotating the pixels
+func orient(src *image.RGBA, orientation int) *image.RGBA {
+	if orientation < 2 || orientation > 8 {
+		return src
+	}
+
+	n := src.Bounds().Dx()
+	last := n - 1
+	dst := image.NewRGBA(src.Bounds())
+
+	for y := 0; y < n; y++ {
+		for x := 0; x < n; x++ {
+			var sx, sy int
+			switch orientation {
+			case 2:
+				sx, sy = last-x, y
+			case 3:
+				sx, sy = last-x, last-y
+			case 4:
+				sx, sy = x, last-y
+			case 5:
+				sx, sy = y, x
+			case 6:
+				sx, sy = y, last-x
+			case 7:
+				sx, sy = last-y, last-x
+			case 8:
+				sx, sy = last-y, x
+			}
+			copy(dst.Pix[dst.PixOffset(x, y):dst.PixOffset(x, y)+4],
+				src.Pix[src.PixOffset(sx, sy):src.PixOffset(sx, sy)+4])
+		}
+	}
+
+	return dst
+}
+
+//exifOrientation returns the orientation tag of the EXIF segment of a JPEG
+//file, 1 when there is none
+func exifOrientation(data []byte) int {
+	if len(data) < 4 || data[0] != 0xFF || data[1] != 0xD8 {
+		return 1
+	}
+
+	for i := 2; i+4 <= len(data); {
+		if data[i] != 0xFF {
+			return 1
+		}
+		marker := data[i+1]
+		if marker == 0xDA || marker == 0xD9 {
+			//The metadata segments come before the start of scan
+			return 1
+		}
+
+		length := int(binary.BigEndian.Uint16(data[i+2:]))
+		if length < 2 || i+2+length > len(data) {
+			return 1
+		}
+
+		segment := data[i+4 : i+2+length]
+		if marker == 0xE1 && len(segment) > 6 &&
+			string(segment[:6]) == "Exif\x00\x00" {
+			return tiffOrientation(segment[6:])
+		}
+
+		i += 2 + length
+	}
+
+	return 1
+}
+
+//tiffOrientation reads the orientation tag (0x0112) of the first IFD of the
+//TIFF structure of the EXIF segment
+func tiffOrientation(t []byte) int {
+	if len(t) < 8 {
+		return 1
+	}
+
+	var order binary.ByteOrder
+	switch string(t[:2]) {
+	case "II":
+		order = binary.LittleEndian
+	case "MM":
+		order = binary.BigEndian
+	default:
+		return 1
+	}
+
+	ifd := int(order.Uint32(t[4:]))
+	if ifd < 8 || ifd+2 > len(t) {
+		return 1
+	}
+
+	entries := int(order.Uint16(t[ifd:]))
+	for k := 0; k < entries; k++ {
+		e := ifd + 2 + 12*k
+		if e+12 > len(t) {
+			return 1
+		}
+		if order.Uint16(t[e:]) == 0x0112 {
+			o := int(order.Uint16(t[e+8:]))
+			if o < 1 || o > 8 {
+				return 1
+			}
+			return o
+		}
+	}
+
+	return 1
+}
+
+//randomID returns a random hex identifier for the keys of an upload
+func randomID() (string, error) {
+	b := make([]byte, 12)
+	if _, err := rand.Read(b); err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(b), nil
+}
+
+//NewNonce returns the identifier of the images of a new avatar
+func NewNonce() (string, error) {
+	return randomID()
+}
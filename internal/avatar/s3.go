@@ -0,0 +1,105 @@
+package avatar
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"io"
+	"strings"
+	"time"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/s3"
+	"github.com/aws/aws-sdk-go/service/s3/s3iface"
+)
+
+//S3 keeps the images in a bucket of S3 or of an S3 compatible server
+type S3 struct {
+	svc     s3iface.S3API
+	bucket  string
+	baseURL string
+}
+
+//NewS3 returns the storage of the bucket. Without baseURL the URLs are the
+//ones of the bucket, by path on the endpoint when there is one
+func NewS3(svc s3iface.S3API, bucket, endpoint, baseURL string) *S3 {
+	if baseURL == "" {
+		if endpoint != "" {
+			baseURL = strings.TrimSuffix(endpoint, "/") + "/" + bucket
+		} else {
+			baseURL = fmt.Sprintf("https://%s.s3.amazonaws.com", bucket)
+		}
+	}
+	return &S3{svc: svc, bucket: bucket,
+		baseURL: strings.TrimSuffix(baseURL, "/")}
+}
+
+//PresignUpload presigns a PUT of the object with the content type. The size
+//is checked when the upload is processed, a presigned PUT can not limit it
+func (s *S3) PresignUpload(ctx context.Context, key, contentType string,
+	ttl time.Duration) (*Upload, error) {
+
+	req, _ := s.svc.PutObjectRequest(&s3.PutObjectInput{
+		Bucket:      aws.String(s.bucket),
+		Key:         aws.String(key),
+		ContentType: aws.String(contentType),
+	})
+	req.SetContext(ctx)
+
+	url, err := req.Presign(ttl)
+	if err != nil {
+		return nil, err
+	}
+
+	return &Upload{URL: url, Method: "PUT",
+		Headers: map[string]string{"Content-Type": contentType},
+		Expires: time.Now().Add(ttl).UTC()}, nil
+}
+
+//Get returns the content of the object
+func (s *S3) Get(ctx context.Context, key string) (io.ReadCloser, error) {
+	out, err := s.svc.GetObjectWithContext(ctx, &s3.GetObjectInput{
+		Bucket: aws.String(s.bucket),
+		Key:    aws.String(key),
+	})
+	if err != nil {
+		return nil, err
+	}
+	return out.Body, nil
+}
+
+//Put stores the object
+func (s *S3) Put(ctx context.Context, key, contentType string,
+	body []byte) error {
+
+	_, err := s.svc.PutObjectWithContext(ctx, &s3.PutObjectInput{
+		Bucket:       aws.String(s.bucket),
+		Key:          aws.String(key),
+		ContentType:  aws.String(contentType),
+		CacheControl: aws.String("public, max-age=31536000, immutable"),
+		Body:         bytes.NewReader(body),
+	})
+	return err
+}
+
+//Delete deletes the object
+func (s *S3) Delete(ctx context.Context, key string) error {
+	_, err := s.svc.DeleteObjectWithContext(ctx, &s3.DeleteObjectInput{
+		Bucket: aws.String(s.bucket),
+		Key:    aws.String(key),
+	})
+	return err
+}
+
+//URL returns the URL of the object
+func (s *S3) URL(key string) string {
+	return s.baseURL + "/" + key
+}
+
+//Key returns the key of a URL of the bucket
+func (s *S3) Key(url string) string {
+	if !strings.HasPrefix(url, s.baseURL+"/") {
+		return ""
+	}
+	return strings.TrimPrefix(url, s.baseURL+"/")
+}
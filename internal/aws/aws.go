@@ -10,6 +10,7 @@ import (
 	"github.com/aws/aws-sdk-go/aws/session"
 	"github.com/aws/aws-sdk-go/service/dynamodb"
 	"github.com/aws/aws-sdk-go/service/dynamodb/dynamodbattribute"
+	"github.com/aws/aws-sdk-go/service/s3"
 )
 
 //GetSession returns an AWS session
@@ -35,6 +36,16 @@ func GetDynamoDB(sess *session.Session) *dynamodb.DynamoDB {
 	return dynamoSvc
 }
 
+//GetDynamoDBWithEndpoint returns a DynamoDB connection to the endpoint, such
+//as DynamoDB Local. An empty endpoint uses the AWS one
+func GetDynamoDBWithEndpoint(sess *session.Session, endpoint string) *dynamodb.DynamoDB {
+	if endpoint == "" {
+		return GetDynamoDB(sess)
+	}
+
+	return dynamodb.New(sess, aws.NewConfig().WithEndpoint(endpoint))
+}
+
 // UnmarshalStreamImage converts events.DynamoDBAttributeValue to struct
 func UnmarshalStreamImage(image map[string]events.DynamoDBAttributeValue,
 	out interface{}) error {
@@ -57,3 +68,15 @@ func UnmarshalStreamImage(image map[string]events.DynamoDBAttributeValue,
 	return dynamodbattribute.UnmarshalMap(attributeMap, &out)
 
 }
+
+//GetS3WithEndpoint returns an S3 connection to the endpoint, such as a local
+//S3 compatible server, addressing the buckets by path. An empty endpoint uses
+//the AWS one
+func GetS3WithEndpoint(sess *session.Session, endpoint string) *s3.S3 {
+	if endpoint == "" {
+		return s3.New(sess)
+	}
+
+	return s3.New(sess, aws.NewConfig().WithEndpoint(endpoint).
+		WithS3ForcePathStyle(true))
+}
@@ -0,0 +1,60 @@
+package aws
+
+import (
+	"context"
+	"math/rand"
+	"time"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/awserr"
+	"github.com/aws/aws-sdk-go/service/dynamodb"
+)
+
+//IsThrottled tells whether DynamoDB rejected the request for exceeding the
+//provisioned or account capacity. Transactions canceled by throttling or by a
+//concurrent transaction can be retried as well
+func IsThrottled(err error) bool {
+	if terr, ok := err.(*dynamodb.TransactionCanceledException); ok {
+		for _, r := range terr.CancellationReasons {
+			switch aws.StringValue(r.Code) {
+			case "ThrottlingError", "ProvisionedThroughputExceeded",
+				"TransactionConflict":
+				return true
+			}
+		}
+		return false
+	}
+
+	if aerr, ok := err.(awserr.Error); ok {
+		switch aerr.Code() {
+		case dynamodb.ErrCodeProvisionedThroughputExceededException,
+			dynamodb.ErrCodeRequestLimitExceeded, "ThrottlingException":
+			return true
+		}
+	}
+	return false
+}
+
+//Retry calls fn until it succeeds, fails with an error that is not a
+//throttling error or the retries are exhausted. Waits between calls grow
+//exponentially from base, with full jitter
+func Retry(ctx context.Context, retries int, base time.Duration,
+	fn func() error) error {
+
+	for attempt := 0; ; attempt++ {
+		err := fn()
+		if err == nil || !IsThrottled(err) || attempt >= retries {
+			return err
+		}
+
+		max := base << uint(attempt)
+		t := time.NewTimer(time.Duration(rand.Int63n(int64(max))) + time.Millisecond)
+
+		select {
+		case <-ctx.Done():
+			t.Stop()
+			return ctx.Err()
+		case <-t.C:
+		}
+	}
+}
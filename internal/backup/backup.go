@@ -0,0 +1,272 @@
+//Package backup dumps the whole table to JSON Lines and replays such dumps
+package backup
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/rs/zerolog/log"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/awserr"
+	"github.com/aws/aws-sdk-go/service/dynamodb"
+	"github.com/aws/aws-sdk-go/service/dynamodb/dynamodbiface"
+
+	"github.com/roloum/users/internal/apperr"
+	uaws "github.com/roloum/users/internal/aws"
+	"github.com/roloum/users/internal/user"
+)
+
+const (
+	//PolicySkip keeps the items that already exist in the table
+	PolicySkip = "skip"
+
+	//PolicyOverwrite replaces the items that already exist in the table
+	PolicyOverwrite = "overwrite"
+
+	//ErrorUnknownPolicy Returned when the restore policy is not supported
+	ErrorUnknownPolicy = "UnknownRestorePolicy"
+
+	//DefaultSegments number of parallel scan segments
+	DefaultSegments = 4
+
+	//DefaultConcurrency number of items written at the same time
+	DefaultConcurrency = 8
+
+	//DefaultRetries number of retries when DynamoDB throttles a request
+	DefaultRetries = 5
+
+	//maxLineSize largest item DynamoDB accepts is 400KB, which can take
+	//several times that size once encoded
+	maxLineSize = 4 * 1024 * 1024
+)
+
+//init registers the HTTP status of the errors of the package
+func init() {
+	apperr.Register(http.StatusUnprocessableEntity, ErrorUnknownPolicy)
+}
+
+//backoffBase initial wait before retrying a throttled write
+var backoffBase = 100 * time.Millisecond
+
+//Item is a table item
+type Item map[string]*dynamodb.AttributeValue
+
+//MarshalJSON encodes the item in the DynamoDB JSON format, the same used by
+//the AWS CLI, e.g. {"pk":{"S":"USER#..."},"active":{"BOOL":true}}
+func (i Item) MarshalJSON() ([]byte, error) {
+	m := make(map[string]interface{}, len(i))
+	for k, v := range i {
+		m[k] = attributeJSON(v)
+	}
+	return json.Marshal(m)
+}
+
+//attributeJSON returns the attribute value as a single key map
+func attributeJSON(av *dynamodb.AttributeValue) interface{} {
+	switch {
+	case av.S != nil:
+		return map[string]interface{}{"S": *av.S}
+	case av.N != nil:
+		return map[string]interface{}{"N": *av.N}
+	case av.BOOL != nil:
+		return map[string]interface{}{"BOOL": *av.BOOL}
+	case av.NULL != nil:
+		return map[string]interface{}{"NULL": *av.NULL}
+	case av.B != nil:
+		return map[string]interface{}{"B": av.B}
+	case av.SS != nil:
+		return map[string]interface{}{"SS": aws.StringValueSlice(av.SS)}
+	case av.NS != nil:
+		return map[string]interface{}{"NS": aws.StringValueSlice(av.NS)}
+	case av.BS != nil:
+		return map[string]interface{}{"BS": av.BS}
+	case av.L != nil:
+		l := make([]interface{}, len(av.L))
+		for i, v := range av.L {
+			l[i] = attributeJSON(v)
+		}
+		return map[string]interface{}{"L": l}
+	case av.M != nil:
+		m := make(map[string]interface{}, len(av.M))
+		for k, v := range av.M {
+			m[k] = attributeJSON(v)
+		}
+		return map[string]interface{}{"M": m}
+	}
+	return map[string]interface{}{"NULL": true}
+}
+
+//Export scans the table in parallel segments and writes every item, one per
+//line. Lines are not sorted. Returns the number of items written
+func Export(ctx context.Context, svc dynamodbiface.DynamoDBAPI,
+	tableName string, segments int, w io.Writer) (int, error) {
+
+	if segments < 1 {
+		segments = DefaultSegments
+	}
+
+	var (
+		mu    sync.Mutex
+		count int
+		wg    sync.WaitGroup
+	)
+	enc := json.NewEncoder(w)
+	errs := make([]error, segments)
+
+	for s := 0; s < segments; s++ {
+		wg.Add(1)
+
+		go func(s int) {
+			defer wg.Done()
+
+			input := &dynamodb.ScanInput{
+				TableName:     aws.String(tableName),
+				Segment:       aws.Int64(int64(s)),
+				TotalSegments: aws.Int64(int64(segments)),
+			}
+
+			var encErr error
+			err := svc.ScanPagesWithContext(ctx, input,
+				func(page *dynamodb.ScanOutput, last bool) bool {
+					mu.Lock()
+					defer mu.Unlock()
+
+					for _, item := range page.Items {
+						if encErr = enc.Encode(Item(item)); encErr != nil {
+							return false
+						}
+						count++
+					}
+					return true
+				})
+			if err == nil {
+				err = encErr
+			}
+			errs[s] = err
+
+			log.Debug().Msgf("Segment %d done", s)
+		}(s)
+	}
+	wg.Wait()
+
+	for _, err := range errs {
+		if err != nil {
+			return count, err
+		}
+	}
+
+	return count, nil
+}
+
+//Report counts the items replayed by Restore
+type Report struct {
+	Written int `json:"written"`
+	Skipped int `json:"skipped"`
+	Failed  int `json:"failed"`
+}
+
+//Restore writes every item read from r. With PolicySkip the items already in
+//the table are kept, with PolicyOverwrite they are replaced. The items are
+//stamped with the time of the restore in the migrated attribute, so the
+//notify handler does not send again the activation emails, magic links and
+//phone codes of the restored rows
+func Restore(ctx context.Context, svc dynamodbiface.DynamoDBAPI,
+	tableName string, r io.Reader, policy string, concurrency int) (
+	*Report, error) {
+
+	if policy != PolicySkip && policy != PolicyOverwrite {
+		return nil, errors.New(ErrorUnknownPolicy)
+	}
+	if concurrency < 1 {
+		concurrency = DefaultConcurrency
+	}
+
+	var (
+		mu     sync.Mutex
+		report Report
+		wg     sync.WaitGroup
+	)
+	sem := make(chan struct{}, concurrency)
+
+	scanner := bufio.NewScanner(r)
+	scanner.Buffer(make([]byte, 64*1024), maxLineSize)
+
+	migrated := time.Now().UTC().Format(time.RFC3339Nano)
+
+	for line := 1; scanner.Scan(); line++ {
+		if len(scanner.Bytes()) == 0 {
+			continue
+		}
+
+		var item Item
+		if err := json.Unmarshal(scanner.Bytes(), &item); err != nil {
+			wg.Wait()
+			return &report, fmt.Errorf("line %d: %v", line, err)
+		}
+		item[user.DynamoDBAttributeMigrated] = &dynamodb.AttributeValue{
+			S: aws.String(migrated)}
+
+		wg.Add(1)
+		sem <- struct{}{}
+
+		go func(line int, item Item) {
+			defer func() {
+				<-sem
+				wg.Done()
+			}()
+
+			written, err := put(ctx, svc, tableName, item, policy)
+
+			mu.Lock()
+			defer mu.Unlock()
+
+			switch {
+			case err != nil:
+				log.Error().Msgf("Line %d: %s", line, err)
+				report.Failed++
+			case written:
+				report.Written++
+			default:
+				report.Skipped++
+			}
+		}(line, item)
+	}
+	wg.Wait()
+
+	return &report, scanner.Err()
+}
+
+//put writes the item, returns false when the policy is PolicySkip and the
+//item already exists
+func put(ctx context.Context, svc dynamodbiface.DynamoDBAPI, tableName string,
+	item Item, policy string) (bool, error) {
+
+	input := &dynamodb.PutItemInput{
+		TableName: aws.String(tableName),
+		Item:      item,
+	}
+	if policy == PolicySkip {
+		input.ConditionExpression = aws.String(
+			"attribute_not_exists(pk) and attribute_not_exists(sk)")
+	}
+
+	err := uaws.Retry(ctx, DefaultRetries, backoffBase, func() error {
+		_, err := svc.PutItemWithContext(ctx, input)
+		return err
+	})
+
+	if aerr, ok := err.(awserr.Error); ok &&
+		aerr.Code() == dynamodb.ErrCodeConditionalCheckFailedException {
+		return false, nil
+	}
+
+	return err == nil, err
+}
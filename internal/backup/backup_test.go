@@ -0,0 +1,106 @@
+package backup
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/awserr"
+	"github.com/aws/aws-sdk-go/service/dynamodb"
+
+	"github.com/roloum/users/internal/test"
+)
+
+const table = "users"
+
+//TestExport Tests that exported items are encoded in the DynamoDB JSON format
+func TestExport(t *testing.T) {
+
+	mock := &test.MockDynamoDB{ScanOutput: &dynamodb.ScanOutput{
+		Items: []map[string]*dynamodb.AttributeValue{
+			{
+				"pk":     {S: aws.String("USER#test@user.com")},
+				"active": {BOOL: aws.Bool(true)},
+				"ttl":    {N: aws.String("10")},
+				"scopes": {L: []*dynamodb.AttributeValue{{S: aws.String("a")}}},
+			},
+		},
+	}}
+
+	var buf bytes.Buffer
+	count, err := Export(context.Background(), mock, table, 2, &buf)
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	if count != 1 {
+		t.Errorf("Expected: 1 item. Received: %d", count)
+	}
+
+	expected := `{"active":{"BOOL":true},"pk":{"S":"USER#test@user.com"},` +
+		`"scopes":{"L":[{"S":"a"}]},"ttl":{"N":"10"}}` + "\n"
+	if buf.String() != expected {
+		t.Errorf("Expected: %s. Received: %s", expected, buf.String())
+	}
+}
+
+//TestRestore Tests the restore policies
+func TestRestore(t *testing.T) {
+
+	backoffBase = time.Millisecond
+
+	input := `{"pk":{"S":"USER#test@user.com"},"sk":{"S":"PROFILE#"}}` + "\n\n" +
+		`{"pk":{"S":"USER#other@user.com"},"sk":{"S":"PROFILE#"}}` + "\n"
+
+	tests := []struct {
+		desc     string
+		mock     *test.MockDynamoDB
+		policy   string
+		expected *Report
+		err      error
+	}{
+		{
+			desc:     PolicyOverwrite,
+			mock:     &test.MockDynamoDB{PutItemOutput: &dynamodb.PutItemOutput{}},
+			policy:   PolicyOverwrite,
+			expected: &Report{Written: 2},
+		},
+		{
+			desc: PolicySkip,
+			mock: &test.MockDynamoDB{OutputError: awserr.New(
+				dynamodb.ErrCodeConditionalCheckFailedException, "", nil)},
+			policy:   PolicySkip,
+			expected: &Report{Skipped: 2},
+		},
+		{
+			desc: "Throttled",
+			mock: &test.MockDynamoDB{OutputError: awserr.New(
+				dynamodb.ErrCodeProvisionedThroughputExceededException, "", nil)},
+			policy:   PolicySkip,
+			expected: &Report{Failed: 2},
+		},
+		{
+			desc:   ErrorUnknownPolicy,
+			mock:   &test.MockDynamoDB{},
+			policy: "merge",
+			err:    errors.New(ErrorUnknownPolicy),
+		},
+	}
+
+	for _, tc := range tests {
+		t.Run(tc.desc, func(t *testing.T) {
+			report, err := Restore(context.Background(), tc.mock, table,
+				strings.NewReader(input), tc.policy, 2)
+			if !reflect.DeepEqual(err, tc.err) {
+				t.Fatalf("Expected: %v. Received: %v", tc.err, err)
+			}
+			if !reflect.DeepEqual(report, tc.expected) {
+				t.Errorf("Expected: %+v. Received: %+v", tc.expected, report)
+			}
+		})
+	}
+}
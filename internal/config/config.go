@@ -16,9 +16,16 @@ func init() {
 		log.Logger = log.Output(zerolog.ConsoleWriter{Out: os.Stderr})
 	}
 
-	//Set log level, default value which is Info
+	SetLogLevel(os.Getenv("USERS_LOG_LEVEL"))
+
+	log.Debug().Msg("Log level set")
+}
+
+//SetLogLevel sets the global log level, Info when the level is not known
+func SetLogLevel(level string) {
+
 	var logLevel zerolog.Level
-	switch os.Getenv("USERS_LOG_LEVEL") {
+	switch level {
 	case "fatal":
 		logLevel = zerolog.FatalLevel
 	case "error":
@@ -36,8 +43,6 @@ func init() {
 	}
 
 	zerolog.SetGlobalLevel(logLevel)
-
-	log.Debug().Msg("Log level set")
 }
 
 //Load Loads the configuration into the Config struct
@@ -0,0 +1,252 @@
+package idp
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/base64"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/rs/zerolog/log"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/awserr"
+	"github.com/aws/aws-sdk-go/service/dynamodb"
+	"github.com/aws/aws-sdk-go/service/dynamodb/dynamodbattribute"
+	"github.com/aws/aws-sdk-go/service/dynamodb/dynamodbiface"
+	"github.com/google/uuid"
+
+	"github.com/roloum/users/internal/apperr"
+)
+
+const (
+	//DynamoDBPrefixClient Prefix added to the keys of an OAuth client row
+	DynamoDBPrefixClient = "CLIENT"
+
+	//DynamoDBTypeClient identifies the OAuth client rows in dynamoDB
+	DynamoDBTypeClient = "OAuthClient"
+
+	//DynamoDBIndexInverted Global secondary index with sk as partition key
+	DynamoDBIndexInverted = "InvertedIndex"
+
+	//ErrorClientDoesNotExist Returned when the client is not registered
+	ErrorClientDoesNotExist = "ClientDoesNotExist"
+
+	//ErrorClientNameIsEmpty Returned when registering a client without name
+	ErrorClientNameIsEmpty = "ClientNameIsEmpty"
+
+	//ErrorRedirectURIIsEmpty Returned when registering a client without
+	//redirect URIs
+	ErrorRedirectURIIsEmpty = "RedirectURIIsEmpty"
+)
+
+//init registers the HTTP status of the errors of the package
+func init() {
+	apperr.Register(http.StatusNotFound, ErrorClientDoesNotExist)
+	apperr.Register(http.StatusUnprocessableEntity, ErrorClientNameIsEmpty,
+		ErrorRedirectURIIsEmpty)
+	apperr.Register(http.StatusBadRequest, ErrorInvalidGrant)
+	apperr.Register(http.StatusUnauthorized, ErrorInvalidToken)
+}
+
+//Client is an application registered to sign in users with this service
+type Client struct {
+	ID           string   `json:"id"`
+	Name         string   `json:"name"`
+	RedirectURIs []string `json:"redirectURIs" dynamodbav:"redirectURIs,stringset"`
+	Created      string   `json:"created"`
+	SecretHash   string   `json:"-" dynamodbav:"secretHash"`
+}
+
+//CreateClient registers a client and returns it along with its secret. The
+//secret is only stored hashed and can not be retrieved later
+func CreateClient(ctx context.Context, svc dynamodbiface.DynamoDBAPI,
+	tableName, name string, redirectURIs []string) (*Client, string, error) {
+
+	log.Info().Msgf("Creating client: %s", name)
+
+	if name == "" {
+		return nil, "", errors.New(ErrorClientNameIsEmpty)
+	}
+	if len(redirectURIs) == 0 {
+		return nil, "", errors.New(ErrorRedirectURIIsEmpty)
+	}
+
+	secret, hash, err := newClientSecret()
+	if err != nil {
+		return nil, "", err
+	}
+
+	c := &Client{
+		ID:           uuid.New().String(),
+		Name:         name,
+		RedirectURIs: redirectURIs,
+		Created:      time.Now().Format("2006-01-02"),
+		SecretHash:   hash,
+	}
+
+	_, err = svc.PutItemWithContext(ctx, &dynamodb.PutItemInput{
+		TableName: aws.String(tableName),
+		Item: map[string]*dynamodb.AttributeValue{
+			"pk":           {S: aws.String(getClientPK(c.ID))},
+			"sk":           {S: aws.String(getClientSK())},
+			"id":           {S: aws.String(c.ID)},
+			"name":         {S: aws.String(c.Name)},
+			"redirectURIs": {SS: aws.StringSlice(c.RedirectURIs)},
+			"secretHash":   {S: aws.String(c.SecretHash)},
+			"created":      {S: aws.String(c.Created)},
+			"type":         {S: aws.String(DynamoDBTypeClient)},
+		},
+		ConditionExpression: aws.String("attribute_not_exists(pk) and attribute_not_exists(sk)"),
+	})
+	if err != nil {
+		return nil, "", err
+	}
+
+	return c, secret, nil
+}
+
+//LoadClient loads a client by ID
+func LoadClient(ctx context.Context, svc dynamodbiface.DynamoDBAPI,
+	tableName, id string) (*Client, error) {
+
+	log.Debug().Msgf("Loading client: %s", id)
+
+	result, err := svc.GetItemWithContext(ctx, &dynamodb.GetItemInput{
+		TableName: aws.String(tableName),
+		Key: map[string]*dynamodb.AttributeValue{
+			"pk": {S: aws.String(getClientPK(id))},
+			"sk": {S: aws.String(getClientSK())},
+		},
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	if result.Item == nil {
+		return nil, errors.New(ErrorClientDoesNotExist)
+	}
+
+	var c Client
+	if err := dynamodbattribute.UnmarshalMap(result.Item, &c); err != nil {
+		return nil, err
+	}
+
+	return &c, nil
+}
+
+//ListClients returns all the registered clients
+func ListClients(ctx context.Context, svc dynamodbiface.DynamoDBAPI,
+	tableName string) ([]*Client, error) {
+
+	log.Debug().Msg("Listing clients")
+
+	var clients []*Client
+
+	err := svc.QueryPagesWithContext(ctx, &dynamodb.QueryInput{
+		TableName:              aws.String(tableName),
+		IndexName:              aws.String(DynamoDBIndexInverted),
+		KeyConditionExpression: aws.String("sk = :sk"),
+		ExpressionAttributeValues: map[string]*dynamodb.AttributeValue{
+			":sk": {S: aws.String(getClientSK())},
+		},
+	}, func(page *dynamodb.QueryOutput, last bool) bool {
+		for _, item := range page.Items {
+			var c Client
+			if err := dynamodbattribute.UnmarshalMap(item, &c); err != nil {
+				log.Error().Msg(err.Error())
+				continue
+			}
+			clients = append(clients, &c)
+		}
+		return true
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return clients, nil
+}
+
+//RotateSecret replaces the secret of the client and returns the new one
+func (c *Client) RotateSecret(ctx context.Context,
+	svc dynamodbiface.DynamoDBAPI, tableName string) (string, error) {
+
+	log.Info().Msgf("Rotating secret of client: %s", c.ID)
+
+	secret, hash, err := newClientSecret()
+	if err != nil {
+		return "", err
+	}
+
+	_, err = svc.UpdateItemWithContext(ctx, &dynamodb.UpdateItemInput{
+		TableName: aws.String(tableName),
+		Key: map[string]*dynamodb.AttributeValue{
+			"pk": {S: aws.String(getClientPK(c.ID))},
+			"sk": {S: aws.String(getClientSK())},
+		},
+		ExpressionAttributeNames: map[string]*string{
+			"#S": aws.String("secretHash"),
+		},
+		ExpressionAttributeValues: map[string]*dynamodb.AttributeValue{
+			":hash": {S: aws.String(hash)},
+		},
+		UpdateExpression:    aws.String("SET #S = :hash"),
+		ConditionExpression: aws.String("attribute_exists(pk)"),
+	})
+	if err != nil {
+		if aerr, ok := err.(awserr.Error); ok &&
+			aerr.Code() == dynamodb.ErrCodeConditionalCheckFailedException {
+			return "", errors.New(ErrorClientDoesNotExist)
+		}
+		return "", err
+	}
+
+	c.SecretHash = hash
+
+	return secret, nil
+}
+
+//Authenticate verifies the client secret
+func (c *Client) Authenticate(secret string) bool {
+	return subtle.ConstantTimeCompare([]byte(hashSecret(secret)),
+		[]byte(c.SecretHash)) == 1
+}
+
+//ValidRedirectURI returns true when the URI is registered for the client
+func (c *Client) ValidRedirectURI(uri string) bool {
+	for _, r := range c.RedirectURIs {
+		if r == uri {
+			return true
+		}
+	}
+	return false
+}
+
+func newClientSecret() (string, string, error) {
+	b := make([]byte, 32)
+	if _, err := rand.Read(b); err != nil {
+		return "", "", err
+	}
+	secret := base64.RawURLEncoding.EncodeToString(b)
+	return secret, hashSecret(secret), nil
+}
+
+func hashSecret(secret string) string {
+	sum := sha256.Sum256([]byte(strings.TrimSpace(secret)))
+	return hex.EncodeToString(sum[:])
+}
+
+func getClientPK(id string) string {
+	return fmt.Sprintf("%s#%s", DynamoDBPrefixClient, id)
+}
+
+func getClientSK() string {
+	return fmt.Sprintf("%s#", DynamoDBPrefixClient)
+}
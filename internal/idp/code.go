@@ -0,0 +1,136 @@
+package idp
+
+import (
+	"context"
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"strconv"
+	"time"
+
+	"github.com/rs/zerolog/log"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/awserr"
+	"github.com/aws/aws-sdk-go/service/dynamodb"
+	"github.com/aws/aws-sdk-go/service/dynamodb/dynamodbattribute"
+	"github.com/aws/aws-sdk-go/service/dynamodb/dynamodbiface"
+)
+
+const (
+	//DynamoDBPrefixCode Prefix added to the keys of an authorization code row
+	DynamoDBPrefixCode = "CODE"
+
+	//DynamoDBTypeCode identifies the authorization code rows in dynamoDB
+	DynamoDBTypeCode = "AuthorizationCode"
+
+	//ErrorInvalidGrant Returned when the code is unknown, expired, was issued
+	//to another client or the PKCE verifier does not match
+	ErrorInvalidGrant = "invalid_grant"
+)
+
+//AuthCode is the authorization code handed to the client after the user
+//signs in
+type AuthCode struct {
+	Code          string `json:"code"`
+	ClientID      string `json:"clientId"`
+	Email         string `json:"email"`
+	RedirectURI   string `json:"redirectURI"`
+	Scope         string `json:"scope"`
+	Nonce         string `json:"nonce"`
+	Challenge     string `json:"challenge"`
+	ChallengeType string `json:"challengeType"`
+}
+
+//Save stores the code, which expires after ttl
+func (a *AuthCode) Save(ctx context.Context, svc dynamodbiface.DynamoDBAPI,
+	tableName string, ttl time.Duration) error {
+
+	log.Debug().Msgf("Saving authorization code for client: %s", a.ClientID)
+
+	item, err := dynamodbattribute.MarshalMap(a)
+	if err != nil {
+		return err
+	}
+
+	item["pk"] = &dynamodb.AttributeValue{S: aws.String(getCodePK(a.Code))}
+	item["sk"] = &dynamodb.AttributeValue{S: aws.String(getCodeSK())}
+	item["ttl"] = &dynamodb.AttributeValue{
+		N: aws.String(strconv.FormatInt(time.Now().Add(ttl).Unix(), 10))}
+	item["type"] = &dynamodb.AttributeValue{S: aws.String(DynamoDBTypeCode)}
+
+	_, err = svc.PutItemWithContext(ctx, &dynamodb.PutItemInput{
+		TableName:           aws.String(tableName),
+		Item:                item,
+		ConditionExpression: aws.String("attribute_not_exists(pk) and attribute_not_exists(sk)"),
+	})
+
+	return err
+}
+
+//ConsumeCode deletes the code issued to the client and returns it. A code can
+//only be exchanged once
+func ConsumeCode(ctx context.Context, svc dynamodbiface.DynamoDBAPI,
+	tableName, code, clientID string) (*AuthCode, error) {
+
+	log.Debug().Msgf("Consuming authorization code for client: %s", clientID)
+
+	result, err := svc.DeleteItemWithContext(ctx, &dynamodb.DeleteItemInput{
+		TableName: aws.String(tableName),
+		Key: map[string]*dynamodb.AttributeValue{
+			"pk": {S: aws.String(getCodePK(code))},
+			"sk": {S: aws.String(getCodeSK())},
+		},
+		ExpressionAttributeNames: map[string]*string{
+			"#C": aws.String("clientId"),
+			"#T": aws.String("ttl"),
+		},
+		ExpressionAttributeValues: map[string]*dynamodb.AttributeValue{
+			":client": {S: aws.String(clientID)},
+			":now":    {N: aws.String(strconv.FormatInt(time.Now().Unix(), 10))},
+		},
+		ConditionExpression: aws.String("#C = :client AND #T > :now"),
+		ReturnValues:        aws.String(dynamodb.ReturnValueAllOld),
+	})
+	if err != nil {
+		if aerr, ok := err.(awserr.Error); ok &&
+			aerr.Code() == dynamodb.ErrCodeConditionalCheckFailedException {
+			return nil, errors.New(ErrorInvalidGrant)
+		}
+		return nil, err
+	}
+
+	var a AuthCode
+	if err := dynamodbattribute.UnmarshalMap(result.Attributes, &a); err != nil {
+		return nil, err
+	}
+
+	return &a, nil
+}
+
+//VerifyChallenge verifies the PKCE code verifier against the challenge sent to
+//the authorize endpoint. Codes issued without a challenge accept no verifier
+func (a *AuthCode) VerifyChallenge(verifier string) bool {
+	switch a.ChallengeType {
+	case "":
+		return verifier == ""
+	case "plain":
+		return subtle.ConstantTimeCompare([]byte(verifier), []byte(a.Challenge)) == 1
+	case "S256":
+		sum := sha256.Sum256([]byte(verifier))
+		return subtle.ConstantTimeCompare(
+			[]byte(base64.RawURLEncoding.EncodeToString(sum[:])),
+			[]byte(a.Challenge)) == 1
+	}
+	return false
+}
+
+func getCodePK(code string) string {
+	return fmt.Sprintf("%s#%s", DynamoDBPrefixCode, code)
+}
+
+func getCodeSK() string {
+	return fmt.Sprintf("%s#", DynamoDBPrefixCode)
+}
@@ -0,0 +1,220 @@
+//Package idp implements an OpenID Connect provider on top of the user store,
+//so other services can sign in their users with this one
+package idp
+
+import (
+	"crypto/rsa"
+	"crypto/x509"
+	"encoding/pem"
+	"errors"
+	"strings"
+	"time"
+
+	"github.com/roloum/users/internal/jwt"
+	"github.com/roloum/users/internal/user"
+)
+
+const (
+	//ErrorInvalidSigningKey Returned when the signing key is not a PEM encoded
+	//RSA private key
+	ErrorInvalidSigningKey = "InvalidSigningKey"
+
+	//ErrorInvalidToken Returned when the access token does not validate
+	ErrorInvalidToken = "invalid_token"
+
+	//TokenUseAccess is the token_use claim of the access tokens. ID tokens,
+	//signed with the same key, do not have it and are not access tokens
+	TokenUseAccess = "access"
+)
+
+//Provider issues and verifies the tokens
+type Provider struct {
+	Issuer    string
+	KeyID     string
+	Key       *rsa.PrivateKey
+	AccessTTL time.Duration
+	IDTTL     time.Duration
+}
+
+//Discovery is the OpenID provider metadata
+type Discovery struct {
+	Issuer                            string   `json:"issuer"`
+	AuthorizationEndpoint             string   `json:"authorization_endpoint"`
+	TokenEndpoint                     string   `json:"token_endpoint"`
+	UserInfoEndpoint                  string   `json:"userinfo_endpoint"`
+	JWKSURI                           string   `json:"jwks_uri"`
+	ResponseTypesSupported            []string `json:"response_types_supported"`
+	SubjectTypesSupported             []string `json:"subject_types_supported"`
+	IDTokenSigningAlgValuesSupported  []string `json:"id_token_signing_alg_values_supported"`
+	ScopesSupported                   []string `json:"scopes_supported"`
+	TokenEndpointAuthMethodsSupported []string `json:"token_endpoint_auth_methods_supported"`
+	ClaimsSupported                   []string `json:"claims_supported"`
+	CodeChallengeMethodsSupported     []string `json:"code_challenge_methods_supported"`
+	GrantTypesSupported               []string `json:"grant_types_supported"`
+}
+
+//UserInfo are the standard claims about the user
+type UserInfo struct {
+	Subject       string `json:"sub"`
+	Email         string `json:"email,omitempty"`
+	EmailVerified bool   `json:"email_verified"`
+	GivenName     string `json:"given_name,omitempty"`
+	FamilyName    string `json:"family_name,omitempty"`
+}
+
+//IDTokenClaims are the claims of the ID token
+type IDTokenClaims struct {
+	Issuer   string `json:"iss"`
+	Audience string `json:"aud"`
+	Expires  int64  `json:"exp"`
+	IssuedAt int64  `json:"iat"`
+	Nonce    string `json:"nonce,omitempty"`
+	UserInfo
+}
+
+//AccessTokenClaims are the claims of the access token. The email is included
+//so the userinfo endpoint can load the profile
+type AccessTokenClaims struct {
+	TokenUse string `json:"token_use"`
+	Issuer   string `json:"iss"`
+	Subject  string `json:"sub"`
+	ClientID string `json:"client_id"`
+	Email    string `json:"email"`
+	Scope    string `json:"scope"`
+	Expires  int64  `json:"exp"`
+	IssuedAt int64  `json:"iat"`
+}
+
+//TokenResponse is the response of the token endpoint
+type TokenResponse struct {
+	AccessToken string `json:"access_token"`
+	TokenType   string `json:"token_type"`
+	ExpiresIn   int64  `json:"expires_in"`
+	IDToken     string `json:"id_token"`
+	Scope       string `json:"scope,omitempty"`
+}
+
+//NewProvider returns a provider signing with the PEM encoded RSA key
+func NewProvider(issuer, keyID, keyPEM string, accessTTL,
+	idTTL time.Duration) (*Provider, error) {
+
+	block, _ := pem.Decode([]byte(keyPEM))
+	if block == nil {
+		return nil, errors.New(ErrorInvalidSigningKey)
+	}
+
+	var key *rsa.PrivateKey
+	if k, err := x509.ParsePKCS1PrivateKey(block.Bytes); err == nil {
+		key = k
+	} else if k, err := x509.ParsePKCS8PrivateKey(block.Bytes); err == nil {
+		rk, ok := k.(*rsa.PrivateKey)
+		if !ok {
+			return nil, errors.New(ErrorInvalidSigningKey)
+		}
+		key = rk
+	} else {
+		return nil, errors.New(ErrorInvalidSigningKey)
+	}
+
+	return &Provider{
+		Issuer:    strings.TrimSuffix(issuer, "/"),
+		KeyID:     keyID,
+		Key:       key,
+		AccessTTL: accessTTL,
+		IDTTL:     idTTL,
+	}, nil
+}
+
+//Discovery returns the provider metadata
+func (p *Provider) Discovery() *Discovery {
+	return &Discovery{
+		Issuer:                            p.Issuer,
+		AuthorizationEndpoint:             p.Issuer + "/oauth/authorize",
+		TokenEndpoint:                     p.Issuer + "/oauth/token",
+		UserInfoEndpoint:                  p.Issuer + "/oauth/userinfo",
+		JWKSURI:                           p.Issuer + "/oauth/jwks",
+		ResponseTypesSupported:            []string{"code"},
+		SubjectTypesSupported:             []string{"public"},
+		IDTokenSigningAlgValuesSupported:  []string{jwt.AlgRS256},
+		ScopesSupported:                   []string{"openid", "email", "profile"},
+		TokenEndpointAuthMethodsSupported: []string{"client_secret_basic", "client_secret_post"},
+		ClaimsSupported: []string{"sub", "email", "email_verified",
+			"given_name", "family_name"},
+		CodeChallengeMethodsSupported: []string{"S256", "plain"},
+		GrantTypesSupported:           []string{"authorization_code"},
+	}
+}
+
+//JWKS returns the public keys that verify the tokens
+func (p *Provider) JWKS() jwt.JWKS {
+	return jwt.JWKS{Keys: []jwt.JWK{jwt.NewRSAJWK(p.KeyID, &p.Key.PublicKey)}}
+}
+
+//NewUserInfo maps the user to the standard claims. The email is only verified
+//once the account is active
+func NewUserInfo(u *user.User) UserInfo {
+	return UserInfo{
+		Subject:       u.ID,
+		Email:         u.Email,
+		EmailVerified: u.Active,
+		GivenName:     u.FirstName,
+		FamilyName:    u.LastName,
+	}
+}
+
+//IssueTokens returns the ID and access tokens of the user for the code
+func (p *Provider) IssueTokens(u *user.User, code *AuthCode) (*TokenResponse,
+	error) {
+
+	now := time.Now()
+
+	idToken, err := jwt.Sign(p.Key, p.KeyID, IDTokenClaims{
+		Issuer:   p.Issuer,
+		Audience: code.ClientID,
+		Expires:  now.Add(p.IDTTL).Unix(),
+		IssuedAt: now.Unix(),
+		Nonce:    code.Nonce,
+		UserInfo: NewUserInfo(u),
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	accessToken, err := jwt.Sign(p.Key, p.KeyID, AccessTokenClaims{
+		TokenUse: TokenUseAccess,
+		Issuer:   p.Issuer,
+		Subject:  u.ID,
+		ClientID: code.ClientID,
+		Email:    u.Email,
+		Scope:    code.Scope,
+		Expires:  now.Add(p.AccessTTL).Unix(),
+		IssuedAt: now.Unix(),
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return &TokenResponse{
+		AccessToken: accessToken,
+		TokenType:   "Bearer",
+		ExpiresIn:   int64(p.AccessTTL.Seconds()),
+		IDToken:     idToken,
+		Scope:       code.Scope,
+	}, nil
+}
+
+//VerifyAccessToken verifies an access token issued by the provider. Other
+//tokens it signs, like the ID tokens, are rejected
+func (p *Provider) VerifyAccessToken(token string) (*AccessTokenClaims, error) {
+	var claims AccessTokenClaims
+	if err := jwt.Verify(token, p.JWKS(), &claims); err != nil {
+		return nil, errors.New(ErrorInvalidToken)
+	}
+
+	if claims.TokenUse != TokenUseAccess || claims.Issuer != p.Issuer ||
+		claims.ClientID == "" || claims.Expires < time.Now().Unix() {
+		return nil, errors.New(ErrorInvalidToken)
+	}
+
+	return &claims, nil
+}
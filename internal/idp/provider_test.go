@@ -0,0 +1,142 @@
+package idp
+
+import (
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/x509"
+	"encoding/pem"
+	"errors"
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/roloum/users/internal/jwt"
+	"github.com/roloum/users/internal/user"
+)
+
+const Issuer = "https://users.example.com"
+
+func newProvider(t *testing.T, ttl time.Duration) *Provider {
+	key, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	keyPEM := pem.EncodeToMemory(&pem.Block{
+		Type:  "RSA PRIVATE KEY",
+		Bytes: x509.MarshalPKCS1PrivateKey(key),
+	})
+
+	p, err := NewProvider(Issuer+"/", "1", string(keyPEM), ttl, ttl)
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	return p
+}
+
+//TestIssueTokens Tests the claims of the issued tokens
+func TestIssueTokens(t *testing.T) {
+
+	p := newProvider(t, time.Hour)
+
+	u := &user.User{
+		ID:        "1234",
+		Email:     "test@user.com",
+		FirstName: "Test",
+		LastName:  "User",
+		Active:    true,
+	}
+
+	tokens, err := p.IssueTokens(u, &AuthCode{ClientID: "client", Nonce: "nonce",
+		Scope: "openid email"})
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+
+	t.Run("IDToken", func(t *testing.T) {
+		var claims IDTokenClaims
+		if err := jwt.Verify(tokens.IDToken, p.JWKS(), &claims); err != nil {
+			t.Fatalf("Unexpected error: %v", err)
+		}
+
+		expected := UserInfo{
+			Subject:       "1234",
+			Email:         "test@user.com",
+			EmailVerified: true,
+			GivenName:     "Test",
+			FamilyName:    "User",
+		}
+		if !reflect.DeepEqual(claims.UserInfo, expected) {
+			t.Errorf("Expected: %+v. Received: %+v", expected, claims.UserInfo)
+		}
+		if claims.Issuer != Issuer || claims.Audience != "client" ||
+			claims.Nonce != "nonce" {
+			t.Errorf("Unexpected claims: %+v", claims)
+		}
+	})
+
+	t.Run("AccessToken", func(t *testing.T) {
+		claims, err := p.VerifyAccessToken(tokens.AccessToken)
+		if err != nil {
+			t.Fatalf("Unexpected error: %v", err)
+		}
+		if claims.Subject != u.ID || claims.Email != u.Email {
+			t.Errorf("Unexpected claims: %+v", claims)
+		}
+	})
+
+	t.Run("IDTokenIsNotAccessToken", func(t *testing.T) {
+		_, err := p.VerifyAccessToken(tokens.IDToken)
+		if !reflect.DeepEqual(err, errors.New(ErrorInvalidToken)) {
+			t.Errorf("Expected: %v. Received: %v", ErrorInvalidToken, err)
+		}
+	})
+
+	t.Run("OtherProvider", func(t *testing.T) {
+		other := newProvider(t, time.Hour)
+		_, err := other.VerifyAccessToken(tokens.AccessToken)
+		if !reflect.DeepEqual(err, errors.New(ErrorInvalidToken)) {
+			t.Errorf("Expected: %v. Received: %v", ErrorInvalidToken, err)
+		}
+	})
+
+	t.Run("Expired", func(t *testing.T) {
+		expired := newProvider(t, -time.Minute)
+		tokens, _ := expired.IssueTokens(u, &AuthCode{ClientID: "client"})
+		_, err := expired.VerifyAccessToken(tokens.AccessToken)
+		if !reflect.DeepEqual(err, errors.New(ErrorInvalidToken)) {
+			t.Errorf("Expected: %v. Received: %v", ErrorInvalidToken, err)
+		}
+	})
+}
+
+//TestVerifyChallenge Tests the PKCE verification
+func TestVerifyChallenge(t *testing.T) {
+
+	tests := []struct {
+		desc     string
+		code     *AuthCode
+		verifier string
+		valid    bool
+	}{
+		{desc: "NoChallenge", code: &AuthCode{}, verifier: "", valid: true},
+		{desc: "UnexpectedVerifier", code: &AuthCode{}, verifier: "x", valid: false},
+		{desc: "Plain", code: &AuthCode{Challenge: "abc", ChallengeType: "plain"},
+			verifier: "abc", valid: true},
+		{desc: "S256", code: &AuthCode{
+			Challenge:     "E9Melhoa2OwvFrEMTJguCHaoeK1t8URWbuGJSstw-cM",
+			ChallengeType: "S256"},
+			verifier: "dBjftJeZ4CVP-mB92K27uhbUJU1p1r_wW1gFWFOEjXk", valid: true},
+		{desc: "S256Mismatch", code: &AuthCode{
+			Challenge:     "E9Melhoa2OwvFrEMTJguCHaoeK1t8URWbuGJSstw-cM",
+			ChallengeType: "S256"},
+			verifier: "abc", valid: false},
+	}
+
+	for _, tc := range tests {
+		t.Run(tc.desc, func(t *testing.T) {
+			if result := tc.code.VerifyChallenge(tc.verifier); result != tc.valid {
+				t.Errorf("Expected: %v. Received: %v", tc.valid, result)
+			}
+		})
+	}
+}
@@ -0,0 +1,303 @@
+//Package importer creates users in bulk from CSV or JSON Lines files
+package importer
+
+import (
+	"bufio"
+	"context"
+	"encoding/csv"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/aws/aws-sdk-go/service/dynamodb/dynamodbiface"
+
+	"github.com/roloum/users/internal/apperr"
+	uaws "github.com/roloum/users/internal/aws"
+	"github.com/roloum/users/internal/user"
+)
+
+const (
+	//FormatCSV comma separated values with a header row
+	FormatCSV = "csv"
+
+	//FormatJSONL one JSON object per line
+	FormatJSONL = "jsonl"
+
+	//StatusCreated the user was created
+	StatusCreated = "created"
+
+	//StatusValid the row passed validation in a dry run
+	StatusValid = "valid"
+
+	//StatusDuplicate the user already exists
+	StatusDuplicate = "duplicate"
+
+	//StatusInvalid the row did not pass validation
+	StatusInvalid = "invalid"
+
+	//StatusFailed the user could not be written
+	StatusFailed = "failed"
+
+	//ErrorUnknownFormat Returned when the file format is not supported
+	ErrorUnknownFormat = "UnknownImportFormat"
+
+	//ErrorMissingColumn Returned when the CSV header lacks a required column
+	ErrorMissingColumn = "MissingImportColumn"
+
+	//ErrorMalformedRow Reported for the lines that can not be parsed or lack
+	//some of the columns
+	ErrorMalformedRow = "MalformedImportRow"
+
+	//DefaultConcurrency number of rows written at the same time
+	DefaultConcurrency = 4
+
+	//DefaultRetries number of retries when DynamoDB throttles a write
+	DefaultRetries = 5
+)
+
+//init registers the HTTP status of the errors of the package
+func init() {
+	apperr.Register(http.StatusUnprocessableEntity, ErrorUnknownFormat,
+		ErrorMissingColumn)
+}
+
+//backoffBase initial wait before retrying a throttled write
+var backoffBase = 100 * time.Millisecond
+
+//Row is a NewUser read from the input file, along with its line number.
+//Error is set for the lines that could not be read, which are reported as
+//invalid without stopping the import
+type Row struct {
+	Line  int
+	Error string
+	user.NewUser
+}
+
+//Result reports the outcome of importing a row
+type Result struct {
+	Line   int    `json:"line"`
+	Email  string `json:"email"`
+	Status string `json:"status"`
+	Error  string `json:"error,omitempty"`
+}
+
+//Options controls how the rows are imported
+type Options struct {
+	//Concurrency number of rows written at the same time
+	Concurrency int
+	//Retries number of retries when DynamoDB throttles a write
+	Retries int
+	//DryRun only validates the rows
+	DryRun bool
+	//Activate creates the users as active accounts
+	Activate bool
+	//SkipActivationEmail creates inactive users without mailing the token
+	SkipActivationEmail bool
+}
+
+//Format returns the format matching the file extension
+func Format(file string) (string, error) {
+	switch {
+	case strings.HasSuffix(file, ".csv"):
+		return FormatCSV, nil
+	case strings.HasSuffix(file, ".jsonl"), strings.HasSuffix(file, ".ndjson"),
+		strings.HasSuffix(file, ".json"):
+		return FormatJSONL, nil
+	}
+	return "", errors.New(ErrorUnknownFormat)
+}
+
+//Read parses the rows in r. CSV files need a header row with the email,
+//firstName and lastName columns. Malformed lines are returned as rows with an
+//error
+func Read(r io.Reader, format string) ([]Row, error) {
+	switch format {
+	case FormatCSV:
+		return readCSV(r)
+	case FormatJSONL:
+		return readJSONL(r)
+	}
+	return nil, errors.New(ErrorUnknownFormat)
+}
+
+func readCSV(r io.Reader) ([]Row, error) {
+	cr := csv.NewReader(r)
+	cr.TrimLeadingSpace = true
+	//The lines with more or fewer fields are checked against the columns
+	cr.FieldsPerRecord = -1
+
+	header, err := cr.Read()
+	if err != nil {
+		return nil, err
+	}
+
+	columns := map[string]int{}
+	for i, name := range header {
+		columns[strings.TrimSpace(name)] = i
+	}
+	for _, name := range []string{"email", "firstName", "lastName"} {
+		if _, ok := columns[name]; !ok {
+			return nil, fmt.Errorf("%s: %s", ErrorMissingColumn, name)
+		}
+	}
+
+	need := 0
+	for _, i := range columns {
+		if i >= need {
+			need = i + 1
+		}
+	}
+
+	var rows []Row
+	for line := 2; ; line++ {
+		record, err := cr.Read()
+		if err == io.EOF {
+			break
+		}
+		var perr *csv.ParseError
+		if errors.As(err, &perr) {
+			rows = append(rows, Row{Line: line,
+				Error: fmt.Sprintf("%s: %v", ErrorMalformedRow, perr.Err)})
+			continue
+		}
+		if err != nil {
+			return nil, err
+		}
+
+		if len(record) < need {
+			rows = append(rows, Row{Line: line, Error: ErrorMalformedRow})
+			continue
+		}
+
+		rows = append(rows, Row{
+			Line: line,
+			NewUser: user.NewUser{
+				Email:     record[columns["email"]],
+				FirstName: record[columns["firstName"]],
+				LastName:  record[columns["lastName"]],
+			},
+		})
+	}
+
+	return rows, nil
+}
+
+func readJSONL(r io.Reader) ([]Row, error) {
+	scanner := bufio.NewScanner(r)
+
+	var rows []Row
+	for line := 1; scanner.Scan(); line++ {
+		text := strings.TrimSpace(scanner.Text())
+		if text == "" {
+			continue
+		}
+
+		var nu user.NewUser
+		if err := json.Unmarshal([]byte(text), &nu); err != nil {
+			rows = append(rows, Row{Line: line,
+				Error: fmt.Sprintf("%s: %v", ErrorMalformedRow, err)})
+			continue
+		}
+
+		rows = append(rows, Row{Line: line, NewUser: nu})
+	}
+
+	return rows, scanner.Err()
+}
+
+//Run imports the rows and returns one result per row, in the same order
+func Run(ctx context.Context, svc dynamodbiface.DynamoDBAPI, tableName string,
+	rows []Row, opts Options) []Result {
+
+	if opts.Concurrency < 1 {
+		opts.Concurrency = DefaultConcurrency
+	}
+
+	results := make([]Result, len(rows))
+	sem := make(chan struct{}, opts.Concurrency)
+
+	var wg sync.WaitGroup
+	for i := range rows {
+		wg.Add(1)
+		sem <- struct{}{}
+
+		go func(i int) {
+			defer func() {
+				<-sem
+				wg.Done()
+			}()
+			results[i] = importRow(ctx, svc, tableName, &rows[i], opts)
+		}(i)
+	}
+	wg.Wait()
+
+	return results
+}
+
+//importRow validates and creates the user of a single row
+func importRow(ctx context.Context, svc dynamodbiface.DynamoDBAPI,
+	tableName string, row *Row, opts Options) Result {
+
+	res := Result{Line: row.Line, Email: row.Email}
+
+	if row.Error != "" {
+		res.Status, res.Error = StatusInvalid, row.Error
+		return res
+	}
+
+	if err := user.Validate(ctx, &row.NewUser); err != nil {
+		res.Status, res.Error = StatusInvalid, fieldCodes(err)
+		return res
+	}
+
+	if opts.DryRun {
+		res.Status = StatusValid
+		return res
+	}
+
+	err := uaws.Retry(ctx, opts.Retries, backoffBase, func() error {
+		_, err := user.Import(ctx, svc, &row.NewUser, tableName, opts.Activate,
+			opts.SkipActivationEmail)
+		return err
+	})
+
+	switch {
+	case err == nil:
+		res.Status = StatusCreated
+	case err.Error() == user.ErrorDuplicateUser:
+		res.Status, res.Error = StatusDuplicate, err.Error()
+	default:
+		res.Status, res.Error = StatusFailed, err.Error()
+	}
+
+	return res
+}
+
+//fieldCodes returns the codes of the invalid fields, comma separated
+func fieldCodes(err error) string {
+
+	e := apperr.From(err)
+	if len(e.Fields) == 0 {
+		return err.Error()
+	}
+
+	codes := make([]string, len(e.Fields))
+	for i, f := range e.Fields {
+		codes[i] = f.Code
+	}
+	return strings.Join(codes, ",")
+}
+
+//Summary counts the results by status
+func Summary(results []Result) map[string]int {
+	summary := map[string]int{}
+	for _, r := range results {
+		summary[r.Status]++
+	}
+	return summary
+}
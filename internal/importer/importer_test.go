@@ -0,0 +1,175 @@
+package importer
+
+import (
+	"context"
+	"errors"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/awserr"
+	"github.com/aws/aws-sdk-go/service/dynamodb"
+
+	"github.com/roloum/users/internal/test"
+	"github.com/roloum/users/internal/user"
+)
+
+const table = "users"
+
+//TestRead Tests reading CSV and JSON Lines files
+func TestRead(t *testing.T) {
+
+	expected := []Row{
+		{Line: 2, NewUser: user.NewUser{Email: "test@user.com",
+			FirstName: "Test", LastName: "User"}},
+	}
+
+	tests := []struct {
+		desc     string
+		format   string
+		input    string
+		expected []Row
+		err      error
+	}{
+		{
+			desc:     FormatCSV,
+			format:   FormatCSV,
+			input:    "lastName,email,firstName\nUser,test@user.com,Test\n",
+			expected: expected,
+		},
+		{
+			desc:   ErrorMissingColumn,
+			format: FormatCSV,
+			input:  "email,firstName\ntest@user.com,Test\n",
+			err:    errors.New(ErrorMissingColumn + ": lastName"),
+		},
+		{
+			desc:   ErrorMalformedRow,
+			format: FormatCSV,
+			input: "email,firstName,lastName\ntest@user.com,Test\n" +
+				"test@user.com,Test,User,Extra\n\"bad,Test,User\n",
+			expected: []Row{
+				{Line: 2, Error: ErrorMalformedRow},
+				{Line: 3, NewUser: user.NewUser{Email: "test@user.com",
+					FirstName: "Test", LastName: "User"}},
+				{Line: 4, Error: ErrorMalformedRow + ": extraneous or missing \" in quoted-field"},
+			},
+		},
+		{
+			desc:   FormatJSONL,
+			format: FormatJSONL,
+			input: "\n" + `{"email":"test@user.com","firstName":"Test","lastName":"User"}` +
+				"\n",
+			expected: expected,
+		},
+		{
+			desc:   ErrorUnknownFormat,
+			format: "xml",
+			err:    errors.New(ErrorUnknownFormat),
+		},
+	}
+
+	for _, tc := range tests {
+		t.Run(tc.desc, func(t *testing.T) {
+			rows, err := Read(strings.NewReader(tc.input), tc.format)
+			if !reflect.DeepEqual(err, tc.err) {
+				t.Fatalf("Expected: %v. Received: %v", tc.err, err)
+			}
+			if !reflect.DeepEqual(rows, tc.expected) {
+				t.Errorf("Expected: %+v. Received: %+v", tc.expected, rows)
+			}
+		})
+	}
+}
+
+//TestRun Tests importing rows
+func TestRun(t *testing.T) {
+
+	backoffBase = time.Millisecond
+
+	rows := []Row{
+		{Line: 1, NewUser: user.NewUser{Email: "test@user.com",
+			FirstName: "Test", LastName: "User"}},
+		{Line: 2, NewUser: user.NewUser{Email: "invalid",
+			FirstName: "Test", LastName: "User"}},
+	}
+
+	throttled := &dynamodb.TransactionCanceledException{
+		CancellationReasons: []*dynamodb.CancellationReason{
+			{Code: aws.String("ThrottlingError")}},
+	}
+
+	tests := []struct {
+		desc     string
+		mock     *test.MockDynamoDB
+		opts     Options
+		expected []Result
+	}{
+		{
+			desc: StatusCreated,
+			mock: &test.MockDynamoDB{
+				TransactWriteItemsOutput: &dynamodb.TransactWriteItemsOutput{}},
+			expected: []Result{
+				{Line: 1, Email: "test@user.com", Status: StatusCreated},
+				{Line: 2, Email: "invalid", Status: StatusInvalid,
+					Error: user.ErrorInvalidEmail},
+			},
+		},
+		{
+			desc: StatusValid,
+			mock: &test.MockDynamoDB{OutputError: errors.New("Unexpected")},
+			opts: Options{DryRun: true},
+			expected: []Result{
+				{Line: 1, Email: "test@user.com", Status: StatusValid},
+				{Line: 2, Email: "invalid", Status: StatusInvalid,
+					Error: user.ErrorInvalidEmail},
+			},
+		},
+		{
+			desc: StatusDuplicate,
+			mock: &test.MockDynamoDB{OutputError: &dynamodb.TransactionCanceledException{
+				CancellationReasons: []*dynamodb.CancellationReason{
+					{Code: aws.String("ConditionalCheckFailed")}}}},
+			expected: []Result{
+				{Line: 1, Email: "test@user.com", Status: StatusDuplicate,
+					Error: user.ErrorDuplicateUser},
+				{Line: 2, Email: "invalid", Status: StatusInvalid,
+					Error: user.ErrorInvalidEmail},
+			},
+		},
+		{
+			desc: "TransactionThrottled",
+			mock: &test.MockDynamoDB{OutputError: throttled},
+			opts: Options{Retries: 2},
+			expected: []Result{
+				{Line: 1, Email: "test@user.com", Status: StatusFailed,
+					Error: throttled.Error()},
+				{Line: 2, Email: "invalid", Status: StatusInvalid,
+					Error: user.ErrorInvalidEmail},
+			},
+		},
+		{
+			desc: StatusFailed,
+			mock: &test.MockDynamoDB{OutputError: awserr.New(
+				dynamodb.ErrCodeProvisionedThroughputExceededException, "", nil)},
+			opts: Options{Retries: 2},
+			expected: []Result{
+				{Line: 1, Email: "test@user.com", Status: StatusFailed,
+					Error: dynamodb.ErrCodeProvisionedThroughputExceededException + ": "},
+				{Line: 2, Email: "invalid", Status: StatusInvalid,
+					Error: user.ErrorInvalidEmail},
+			},
+		},
+	}
+
+	for _, tc := range tests {
+		t.Run(tc.desc, func(t *testing.T) {
+			results := Run(context.Background(), tc.mock, table, rows, tc.opts)
+			if !reflect.DeepEqual(results, tc.expected) {
+				t.Errorf("Expected: %+v. Received: %+v", tc.expected, results)
+			}
+		})
+	}
+}
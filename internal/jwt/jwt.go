@@ -0,0 +1,217 @@
+//Package jwt signs and verifies the JSON Web Tokens used by OpenID Connect.
+//Only RS256 and ES256 are supported
+package jwt
+
+import (
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"math/big"
+	"strings"
+)
+
+const (
+	//AlgRS256 RSASSA-PKCS1-v1_5 using SHA-256
+	AlgRS256 = "RS256"
+
+	//AlgES256 ECDSA using P-256 and SHA-256
+	AlgES256 = "ES256"
+
+	//ErrorMalformedToken Returned when the token can not be decoded
+	ErrorMalformedToken = "MalformedToken"
+
+	//ErrorUnsupportedAlgorithm Returned when the token is not signed with a
+	//supported algorithm
+	ErrorUnsupportedAlgorithm = "UnsupportedAlgorithm"
+
+	//ErrorUnknownKey Returned when the key that signed the token is not in the
+	//key set
+	ErrorUnknownKey = "UnknownKey"
+
+	//ErrorInvalidSignature Returned when the signature does not verify
+	ErrorInvalidSignature = "InvalidSignature"
+)
+
+//Header is the JOSE header of a token
+type Header struct {
+	Alg string `json:"alg"`
+	Kid string `json:"kid,omitempty"`
+	Typ string `json:"typ,omitempty"`
+}
+
+//JWK is a public JSON Web Key
+type JWK struct {
+	Kty string `json:"kty"`
+	Kid string `json:"kid,omitempty"`
+	Use string `json:"use,omitempty"`
+	Alg string `json:"alg,omitempty"`
+	N   string `json:"n,omitempty"`
+	E   string `json:"e,omitempty"`
+	Crv string `json:"crv,omitempty"`
+	X   string `json:"x,omitempty"`
+	Y   string `json:"y,omitempty"`
+}
+
+//JWKS is a JSON Web Key Set
+type JWKS struct {
+	Keys []JWK `json:"keys"`
+}
+
+//NewRSAJWK returns the JWK of an RSA public key
+func NewRSAJWK(kid string, pub *rsa.PublicKey) JWK {
+	return JWK{
+		Kty: "RSA",
+		Kid: kid,
+		Use: "sig",
+		Alg: AlgRS256,
+		N:   base64.RawURLEncoding.EncodeToString(pub.N.Bytes()),
+		E:   base64.RawURLEncoding.EncodeToString(big.NewInt(int64(pub.E)).Bytes()),
+	}
+}
+
+//PublicKey returns the public key described by the JWK
+func (k JWK) PublicKey() (crypto.PublicKey, error) {
+	switch k.Kty {
+	case "RSA":
+		n, err := base64.RawURLEncoding.DecodeString(k.N)
+		if err != nil {
+			return nil, err
+		}
+		e, err := base64.RawURLEncoding.DecodeString(k.E)
+		if err != nil {
+			return nil, err
+		}
+		return &rsa.PublicKey{
+			N: new(big.Int).SetBytes(n),
+			E: int(new(big.Int).SetBytes(e).Int64()),
+		}, nil
+	case "EC":
+		if k.Crv != "P-256" {
+			return nil, errors.New(ErrorUnsupportedAlgorithm)
+		}
+		x, err := base64.RawURLEncoding.DecodeString(k.X)
+		if err != nil {
+			return nil, err
+		}
+		y, err := base64.RawURLEncoding.DecodeString(k.Y)
+		if err != nil {
+			return nil, err
+		}
+		return &ecdsa.PublicKey{
+			Curve: elliptic.P256(),
+			X:     new(big.Int).SetBytes(x),
+			Y:     new(big.Int).SetBytes(y),
+		}, nil
+	}
+	return nil, errors.New(ErrorUnsupportedAlgorithm)
+}
+
+//Find returns the key with the given kid. When kid is empty and the set has a
+//single key, that key is returned
+func (s JWKS) Find(kid string) (JWK, bool) {
+	if kid == "" && len(s.Keys) == 1 {
+		return s.Keys[0], true
+	}
+	for _, k := range s.Keys {
+		if k.Kid == kid {
+			return k, true
+		}
+	}
+	return JWK{}, false
+}
+
+//Sign returns a RS256 signed token with the given claims
+func Sign(key *rsa.PrivateKey, kid string, claims interface{}) (string, error) {
+	header, err := json.Marshal(Header{Alg: AlgRS256, Kid: kid, Typ: "JWT"})
+	if err != nil {
+		return "", err
+	}
+	payload, err := json.Marshal(claims)
+	if err != nil {
+		return "", err
+	}
+
+	signingInput := base64.RawURLEncoding.EncodeToString(header) + "." +
+		base64.RawURLEncoding.EncodeToString(payload)
+
+	digest := sha256.Sum256([]byte(signingInput))
+	sig, err := rsa.SignPKCS1v15(rand.Reader, key, crypto.SHA256, digest[:])
+	if err != nil {
+		return "", err
+	}
+
+	return signingInput + "." + base64.RawURLEncoding.EncodeToString(sig), nil
+}
+
+//Verify verifies the signature of the token with the key set and unmarshals
+//the payload into claims. Validating the claims is up to the caller
+func Verify(token string, keys JWKS, claims interface{}) error {
+	parts := strings.Split(token, ".")
+	if len(parts) != 3 {
+		return errors.New(ErrorMalformedToken)
+	}
+
+	var header Header
+	if err := decodeSegment(parts[0], &header); err != nil {
+		return err
+	}
+
+	sig, err := base64.RawURLEncoding.DecodeString(parts[2])
+	if err != nil {
+		return errors.New(ErrorMalformedToken)
+	}
+
+	jwk, ok := keys.Find(header.Kid)
+	if !ok {
+		return errors.New(ErrorUnknownKey)
+	}
+
+	pub, err := jwk.PublicKey()
+	if err != nil {
+		return err
+	}
+
+	digest := sha256.Sum256([]byte(parts[0] + "." + parts[1]))
+
+	switch header.Alg {
+	case AlgRS256:
+		k, ok := pub.(*rsa.PublicKey)
+		if !ok {
+			return errors.New(ErrorUnsupportedAlgorithm)
+		}
+		if err := rsa.VerifyPKCS1v15(k, crypto.SHA256, digest[:], sig); err != nil {
+			return errors.New(ErrorInvalidSignature)
+		}
+	case AlgES256:
+		k, ok := pub.(*ecdsa.PublicKey)
+		if !ok || len(sig) != 64 {
+			return errors.New(ErrorInvalidSignature)
+		}
+		r := new(big.Int).SetBytes(sig[:32])
+		s := new(big.Int).SetBytes(sig[32:])
+		if !ecdsa.Verify(k, digest[:], r, s) {
+			return errors.New(ErrorInvalidSignature)
+		}
+	default:
+		return errors.New(ErrorUnsupportedAlgorithm)
+	}
+
+	return decodeSegment(parts[1], claims)
+}
+
+func decodeSegment(segment string, out interface{}) error {
+	b, err := base64.RawURLEncoding.DecodeString(segment)
+	if err != nil {
+		return errors.New(ErrorMalformedToken)
+	}
+	if err := json.Unmarshal(b, out); err != nil {
+		return errors.New(ErrorMalformedToken)
+	}
+	return nil
+}
@@ -0,0 +1,38 @@
+package notify
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"sync"
+
+	"github.com/roloum/users/internal/user"
+)
+
+//Console prints the messages instead of sending them, for development
+type Console struct {
+	Out io.Writer
+
+	//Channel is the name of the channel it stands in for
+	Channel string
+
+	mu sync.Mutex
+}
+
+//Send prints the recipient and the message
+func (c *Console) Send(ctx context.Context, r Recipient, m *Message) error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	to := r.Email
+	if c.Channel == user.ChannelSMS {
+		to = r.Phone
+	}
+
+	_, err := fmt.Fprintf(c.Out, "--- %s %s to %s\nSubject: %s\n\n%s\n",
+		c.Channel, m.Category, to, m.Subject, m.Text)
+	if err == nil && m.UnsubscribeURL != "" {
+		_, err = fmt.Fprintf(c.Out, "\nUnsubscribe: %s\n", m.UnsubscribeURL)
+	}
+	return err
+}
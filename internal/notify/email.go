@@ -0,0 +1,104 @@
+package notify
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"mime"
+	"mime/multipart"
+	"net/textproto"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/ses"
+	"github.com/aws/aws-sdk-go/service/ses/sesiface"
+)
+
+//CHARSET Character encoding for email
+const CHARSET = "UTF-8"
+
+//Email sends the messages with SES
+type Email struct {
+	svc    sesiface.SESAPI
+	sender string
+}
+
+//NewEmail returns the email channel sending from sender
+func NewEmail(svc sesiface.SESAPI, sender string) *Email {
+	return &Email{svc: svc, sender: sender}
+}
+
+//Send sends the message to the email of the recipient. SendEmail can not set
+//headers, the message is built as a MIME multipart/alternative one so the
+//messages with an unsubscribe link carry the RFC 8058 List-Unsubscribe
+//headers
+func (e *Email) Send(ctx context.Context, r Recipient, m *Message) error {
+
+	raw, err := e.build(r, m)
+	if err != nil {
+		return err
+	}
+
+	_, err = e.svc.SendRawEmailWithContext(ctx, &ses.SendRawEmailInput{
+		Destinations: []*string{aws.String(r.Email)},
+		Source:       aws.String(e.sender),
+		RawMessage:   &ses.RawMessage{Data: raw},
+	})
+	return err
+}
+
+//build returns the MIME message
+func (e *Email) build(r Recipient, m *Message) ([]byte, error) {
+
+	text, html := m.Text, m.HTML
+	if html == "" {
+		html = text
+	}
+	if m.UnsubscribeURL != "" {
+		html += fmt.Sprintf("<p><a href=\"%s\">Unsubscribe</a></p>", m.UnsubscribeURL)
+		text += fmt.Sprintf("\n\nUnsubscribe: %s", m.UnsubscribeURL)
+	}
+
+	var body bytes.Buffer
+	mw := multipart.NewWriter(&body)
+	for _, part := range []struct{ contentType, content string }{
+		{"text/plain", text},
+		{"text/html", html},
+	} {
+		w, err := mw.CreatePart(textproto.MIMEHeader{
+			"Content-Type":              {part.contentType + "; charset=" + CHARSET},
+			"Content-Transfer-Encoding": {"8bit"},
+		})
+		if err != nil {
+			return nil, err
+		}
+		if _, err := w.Write([]byte(part.content)); err != nil {
+			return nil, err
+		}
+	}
+	if err := mw.Close(); err != nil {
+		return nil, err
+	}
+
+	headers := [][2]string{
+		{"From", e.sender},
+		{"To", r.Email},
+		{"Subject", mime.QEncoding.Encode(CHARSET, m.Subject)},
+		{"MIME-Version", "1.0"},
+	}
+	if m.UnsubscribeURL != "" {
+		headers = append(headers,
+			[2]string{"List-Unsubscribe", "<" + m.UnsubscribeURL + ">"},
+			[2]string{"List-Unsubscribe-Post", "List-Unsubscribe=One-Click"})
+	}
+	headers = append(headers, [2]string{"Content-Type",
+		"multipart/alternative; boundary=" + mw.Boundary()})
+
+	var msg bytes.Buffer
+	for _, h := range headers {
+		fmt.Fprintf(&msg, "%s: %s\r\n", h[0], h[1])
+	}
+	msg.WriteString("\r\n")
+	msg.Write(body.Bytes())
+
+	return msg.Bytes(), nil
+}
@@ -0,0 +1,128 @@
+package notify
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+
+	"github.com/roloum/users/internal/user"
+)
+
+//ErrorInvalidFeedback Returned when the SES notification can not be parsed
+const ErrorInvalidFeedback = "InvalidFeedback"
+
+//Suppressor tells whether the emails to an address are suppressed
+type Suppressor interface {
+	Suppressed(ctx context.Context, email string) (bool, error)
+}
+
+//SuppressorFunc adapts a function to the Suppressor interface
+type SuppressorFunc func(ctx context.Context, email string) (bool, error)
+
+//Suppressed calls f
+func (f SuppressorFunc) Suppressed(ctx context.Context, email string) (bool,
+	error) {
+	return f(ctx, email)
+}
+
+//sesNotification is the SES notification published to SNS, with the
+//notificationType of the identity notifications or the eventType of the
+//configuration set events
+type sesNotification struct {
+	NotificationType string `json:"notificationType"`
+	EventType        string `json:"eventType"`
+
+	Mail struct {
+		MessageID string `json:"messageId"`
+		Timestamp string `json:"timestamp"`
+	} `json:"mail"`
+
+	Bounce *struct {
+		BounceType        string `json:"bounceType"`
+		BounceSubType     string `json:"bounceSubType"`
+		FeedbackID        string `json:"feedbackId"`
+		Timestamp         string `json:"timestamp"`
+		BouncedRecipients []struct {
+			EmailAddress   string `json:"emailAddress"`
+			DiagnosticCode string `json:"diagnosticCode"`
+		} `json:"bouncedRecipients"`
+	} `json:"bounce"`
+
+	Complaint *struct {
+		ComplaintFeedbackType string `json:"complaintFeedbackType"`
+		FeedbackID            string `json:"feedbackId"`
+		Timestamp             string `json:"timestamp"`
+		ComplainedRecipients  []struct {
+			EmailAddress string `json:"emailAddress"`
+		} `json:"complainedRecipients"`
+	} `json:"complaint"`
+
+	Delivery *struct {
+		Timestamp    string   `json:"timestamp"`
+		SMTPResponse string   `json:"smtpResponse"`
+		Recipients   []string `json:"recipients"`
+	} `json:"delivery"`
+}
+
+//ParseFeedback returns a delivery for each recipient of the SES bounce,
+//complaint or delivery notification. Other notifications return none
+func ParseFeedback(message string) ([]*user.Delivery, error) {
+
+	var n sesNotification
+	if err := json.Unmarshal([]byte(message), &n); err != nil {
+		return nil, errors.New(ErrorInvalidFeedback)
+	}
+
+	kind := n.NotificationType
+	if kind == "" {
+		kind = n.EventType
+	}
+
+	var deliveries []*user.Delivery
+	switch {
+	case kind == user.DeliveryTypeBounce && n.Bounce != nil:
+		for _, r := range n.Bounce.BouncedRecipients {
+			deliveries = append(deliveries, &user.Delivery{
+				Email:         r.EmailAddress,
+				Type:          user.DeliveryTypeBounce,
+				BounceType:    n.Bounce.BounceType,
+				BounceSubType: n.Bounce.BounceSubType,
+				Diagnostic:    r.DiagnosticCode,
+				MessageID:     n.Mail.MessageID,
+				FeedbackID:    n.Bounce.FeedbackID,
+				Timestamp:     n.Bounce.Timestamp,
+			})
+		}
+
+	case kind == user.DeliveryTypeComplaint && n.Complaint != nil:
+		for _, r := range n.Complaint.ComplainedRecipients {
+			deliveries = append(deliveries, &user.Delivery{
+				Email:         r.EmailAddress,
+				Type:          user.DeliveryTypeComplaint,
+				ComplaintType: n.Complaint.ComplaintFeedbackType,
+				MessageID:     n.Mail.MessageID,
+				FeedbackID:    n.Complaint.FeedbackID,
+				Timestamp:     n.Complaint.Timestamp,
+			})
+		}
+
+	case kind == user.DeliveryTypeDelivery && n.Delivery != nil:
+		for _, email := range n.Delivery.Recipients {
+			deliveries = append(deliveries, &user.Delivery{
+				Email:      email,
+				Type:       user.DeliveryTypeDelivery,
+				Diagnostic: n.Delivery.SMTPResponse,
+				MessageID:  n.Mail.MessageID,
+				Timestamp:  n.Delivery.Timestamp,
+			})
+		}
+	}
+
+	for _, d := range deliveries {
+		if d.Timestamp == "" {
+			d.Timestamp = n.Mail.Timestamp
+		}
+	}
+
+	return deliveries, nil
+}
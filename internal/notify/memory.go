@@ -0,0 +1,81 @@
+package notify
+
+import (
+	"context"
+	"sync"
+	"time"
+)
+
+//Memory is the in memory queue standing in for SQS in development
+type Memory struct {
+	mu      sync.Mutex
+	pending []*memoryJob
+
+	//Dead are the jobs moved to the dead-letter queue
+	Dead []*Job
+}
+
+//memoryJob is a queued job and when it can be received
+type memoryJob struct {
+	job     *Job
+	visible time.Time
+}
+
+//NewMemory returns an empty in memory queue
+func NewMemory() *Memory {
+	return &Memory{}
+}
+
+//Enqueue adds a copy of the job
+func (q *Memory) Enqueue(ctx context.Context, job *Job, delay time.Duration) error {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+
+	j := *job
+	q.pending = append(q.pending, &memoryJob{job: &j,
+		visible: time.Now().Add(delay)})
+	return nil
+}
+
+//Receive removes and returns up to max jobs whose delay is over
+func (q *Memory) Receive(ctx context.Context, max int) ([]*Job, error) {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+
+	now := time.Now()
+
+	var jobs []*Job
+	pending := q.pending[:0]
+	for _, p := range q.pending {
+		if len(jobs) < max && !p.visible.After(now) {
+			jobs = append(jobs, p.job)
+			continue
+		}
+		pending = append(pending, p)
+	}
+	q.pending = pending
+
+	return jobs, nil
+}
+
+//Delete does nothing, Receive already removed the job
+func (q *Memory) Delete(ctx context.Context, job *Job) error {
+	return nil
+}
+
+//DeadLetter adds the job to Dead
+func (q *Memory) DeadLetter(ctx context.Context, job *Job) error {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+
+	q.Dead = append(q.Dead, job)
+	return nil
+}
+
+//Len returns the number of jobs in the queue, due or not
+func (q *Memory) Len() int {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+
+	return len(q.pending)
+}
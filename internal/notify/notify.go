@@ -0,0 +1,256 @@
+//Package notify delivers the messages of the users through channels: email
+//with SES, SMS with SNS or a Twilio compatible API, a webhook, and the
+//console for development
+package notify
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"time"
+
+	"github.com/rs/zerolog/log"
+
+	"github.com/aws/aws-sdk-go/aws/session"
+	"github.com/aws/aws-sdk-go/service/ses"
+	"github.com/aws/aws-sdk-go/service/sns"
+	"github.com/aws/aws-sdk-go/service/sqs"
+
+	"github.com/roloum/users/internal/user"
+)
+
+const (
+	//SMSProviderSNS sends the SMS with Amazon SNS
+	SMSProviderSNS = "sns"
+
+	//SMSProviderTwilio sends the SMS with the Twilio API, or a compatible one
+	SMSProviderTwilio = "twilio"
+
+	//ErrorNotDelivered Returned when the message could not be sent through
+	//any of the channels
+	ErrorNotDelivered = "NotDelivered"
+)
+
+//Config is the configuration of the channels
+type Config struct {
+	//Channels are the channels enabled, messages for the others are skipped
+	Channels []string `default:"email"`
+
+	//Console prints the messages of every channel instead of sending them
+	Console bool
+
+	SMS struct {
+		//Provider is sns or twilio
+		Provider string `default:"sns"`
+
+		//SenderID shown by SNS in the countries supporting it
+		SenderID string
+
+		Twilio struct {
+			URL        string `default:"https://api.twilio.com"`
+			AccountSID string
+			Token      string
+			From       string
+		}
+	}
+
+	Webhook struct {
+		URL    string
+		Secret string
+	}
+
+	//Queue queues the emails instead of sending them right away
+	Queue QueueConfig
+}
+
+//Recipient is who the message is sent to
+type Recipient struct {
+	ID    string `json:"id,omitempty"`
+	Email string `json:"email,omitempty"`
+	Phone string `json:"phone,omitempty"`
+}
+
+//Message is the content of a notification. The channels use the parts they
+//support: SMS only sends the text
+type Message struct {
+	Category string `json:"category"`
+	Subject  string `json:"subject"`
+	Text     string `json:"text"`
+	HTML     string `json:"html,omitempty"`
+
+	//UnsubscribeURL is the signed link of the messages the user can
+	//unsubscribe from, empty for the mandatory ones
+	UnsubscribeURL string `json:"unsubscribeUrl,omitempty"`
+
+	//SendAt schedules the email when the channel is queued, the other
+	//channels send the message right away
+	SendAt time.Time `json:"-"`
+
+	//ExpiresAt drops the queued email when it is not sent by then, for the
+	//links that would no longer work. Never when it is zero
+	ExpiresAt time.Time `json:"-"`
+}
+
+//Channel delivers messages
+type Channel interface {
+	Send(ctx context.Context, r Recipient, m *Message) error
+}
+
+//Dispatcher sends the messages through the enabled channels
+type Dispatcher struct {
+	channels   map[string]Channel
+	suppressor Suppressor
+
+	//worker sends the emails of the memory queue on Flush
+	worker *Worker
+}
+
+//RecipientOf returns the recipient of the user, the phone only once verified
+func RecipientOf(u *user.User) Recipient {
+	r := Recipient{ID: u.ID, Email: u.Email}
+	if u.PhoneVerified {
+		r.Phone = u.Phone
+	}
+	return r
+}
+
+//NewDispatcher returns a dispatcher of the channels, by name
+func NewDispatcher(channels map[string]Channel) *Dispatcher {
+	return &Dispatcher{channels: channels}
+}
+
+//SetSuppressor makes the dispatcher skip the emails to the addresses s
+//suppresses
+func (d *Dispatcher) SetSuppressor(s Suppressor) {
+	d.suppressor = s
+}
+
+//New returns the dispatcher of the enabled channels of the configuration.
+//sender is the email address of the messages
+func New(cfg Config, sender string, sess *session.Session) (*Dispatcher,
+	error) {
+
+	var worker *Worker
+
+	channels := map[string]Channel{}
+	for _, name := range cfg.Channels {
+
+		if cfg.Console {
+			channels[name] = &Console{Out: os.Stdout, Channel: name}
+			continue
+		}
+
+		switch name {
+		case user.ChannelEmail:
+			email := NewEmail(ses.New(sess), sender)
+			switch cfg.Queue.Backend {
+			case "":
+				channels[name] = email
+			case QueueSQS:
+				if cfg.Queue.URL == "" || cfg.Queue.DeadLetterURL == "" {
+					return nil, errors.New("Missing queue or dead-letter queue URL")
+				}
+				channels[name] = NewQueued(NewSQS(sqs.New(sess), cfg.Queue.URL,
+					cfg.Queue.DeadLetterURL))
+			case QueueMemory:
+				queue := NewMemory()
+				channels[name] = NewQueued(queue)
+				worker = NewWorker(queue, email, NewLimiter(cfg.Queue.Rate),
+					cfg.Queue)
+			default:
+				return nil, fmt.Errorf("Unknown queue backend: %s", cfg.Queue.Backend)
+			}
+
+		case user.ChannelSMS:
+			switch cfg.SMS.Provider {
+			case SMSProviderSNS:
+				channels[name] = NewSNS(sns.New(sess), cfg.SMS.SenderID)
+			case SMSProviderTwilio:
+				t := cfg.SMS.Twilio
+				if t.AccountSID == "" || t.Token == "" || t.From == "" {
+					return nil, errors.New("Missing Twilio account, token or sender")
+				}
+				channels[name] = NewTwilio(t.URL, t.AccountSID, t.Token, t.From)
+			default:
+				return nil, fmt.Errorf("Unknown SMS provider: %s", cfg.SMS.Provider)
+			}
+
+		case user.ChannelWebhook:
+			if cfg.Webhook.URL == "" || cfg.Webhook.Secret == "" {
+				return nil, errors.New("Missing webhook URL or secret")
+			}
+			channels[name] = NewWebhook(cfg.Webhook.URL, cfg.Webhook.Secret)
+
+		default:
+			return nil, fmt.Errorf("Unknown notification channel: %s", name)
+		}
+	}
+
+	d := NewDispatcher(channels)
+	d.worker = worker
+
+	return d, nil
+}
+
+//Send delivers the message through each of the channels. The channels that
+//are not enabled, SMS without a phone, or email to a suppressed address, are
+//skipped. Failures are logged, an error is only returned when the message
+//reached no channel and was not suppressed, so a retry does not repeat the
+//deliveries that succeeded
+func (d *Dispatcher) Send(ctx context.Context, r Recipient, m *Message,
+	channels []string) error {
+
+	delivered, suppressed := 0, 0
+	for _, name := range channels {
+
+		ch, ok := d.channels[name]
+		if !ok {
+			log.Warn().Msgf("Channel %s is not enabled, skipping %s message",
+				name, m.Category)
+			continue
+		}
+
+		if name == user.ChannelSMS && r.Phone == "" {
+			log.Warn().Msgf("%s has no verified phone, skipping SMS", r.Email)
+			continue
+		}
+
+		if name == user.ChannelEmail && d.suppressor != nil {
+			//A failed lookup sends the email rather than lose it
+			ok, err := d.suppressor.Suppressed(ctx, r.Email)
+			if err != nil {
+				log.Error().Err(err).Msgf("Checking suppression of %s", r.Email)
+			}
+			if ok {
+				log.Warn().Msgf("Emails to %s are suppressed, skipping %s message",
+					r.Email, m.Category)
+				suppressed++
+				continue
+			}
+		}
+
+		if err := ch.Send(ctx, r, m); err != nil {
+			log.Error().Err(err).Msgf("Sending %s message by %s", m.Category,
+				name)
+			continue
+		}
+
+		log.Info().Msgf("Sent %s message by %s", m.Category, name)
+		delivered++
+	}
+
+	if delivered == 0 && suppressed == 0 {
+		return errors.New(ErrorNotDelivered)
+	}
+	return nil
+}
+
+//Flush sends the due emails of the memory queue. The emails of the SQS queue
+//are sent by the mailer function, Flush does nothing then
+func (d *Dispatcher) Flush(ctx context.Context) error {
+	if d.worker == nil {
+		return nil
+	}
+	return d.worker.Drain(ctx)
+}
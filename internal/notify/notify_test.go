@@ -0,0 +1,447 @@
+package notify
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/aws/aws-sdk-go/aws/awserr"
+	"github.com/aws/aws-sdk-go/aws/request"
+	"github.com/aws/aws-sdk-go/service/ses"
+	"github.com/aws/aws-sdk-go/service/ses/sesiface"
+
+	"github.com/roloum/users/internal/user"
+)
+
+//recorder is a channel recording the recipients, failing when err is set
+type recorder struct {
+	sent []Recipient
+	err  error
+}
+
+func (r *recorder) Send(ctx context.Context, to Recipient, m *Message) error {
+	if r.err != nil {
+		return r.err
+	}
+	r.sent = append(r.sent, to)
+	return nil
+}
+
+//mockSES records the raw messages
+type mockSES struct {
+	sesiface.SESAPI
+	input *ses.SendRawEmailInput
+}
+
+func (m *mockSES) SendRawEmailWithContext(ctx context.Context,
+	input *ses.SendRawEmailInput,
+	opts ...request.Option) (*ses.SendRawEmailOutput, error) {
+	m.input = input
+	return &ses.SendRawEmailOutput{}, nil
+}
+
+//TestDispatcherSend Tests choosing the channels of a message
+func TestDispatcherSend(t *testing.T) {
+
+	tests := []struct {
+		desc      string
+		recipient Recipient
+		channels  []string
+		failing   bool
+		suppress  bool
+		emails    int
+		sms       int
+		err       error
+	}{
+		{desc: "Email", recipient: Recipient{Email: "a@user.com"},
+			channels: []string{user.ChannelEmail}, emails: 1},
+		{desc: "EmailAndSMS", recipient: Recipient{Email: "a@user.com",
+			Phone: "+34600000000"},
+			channels: []string{user.ChannelEmail, user.ChannelSMS}, emails: 1,
+			sms: 1},
+		{desc: "SMSWithoutPhone", recipient: Recipient{Email: "a@user.com"},
+			channels: []string{user.ChannelSMS},
+			err:      errors.New(ErrorNotDelivered)},
+		{desc: "NotEnabled", recipient: Recipient{Email: "a@user.com"},
+			channels: []string{user.ChannelWebhook, user.ChannelEmail}, emails: 1},
+		{desc: "OneFails", recipient: Recipient{Email: "a@user.com",
+			Phone: "+34600000000"}, failing: true,
+			channels: []string{user.ChannelSMS, user.ChannelEmail}, emails: 1},
+		{desc: "Suppressed", recipient: Recipient{Email: "a@user.com"},
+			suppress: true, channels: []string{user.ChannelEmail}},
+		{desc: "SuppressedEmailOnly", recipient: Recipient{Email: "a@user.com",
+			Phone: "+34600000000"}, suppress: true,
+			channels: []string{user.ChannelEmail, user.ChannelSMS}, sms: 1},
+	}
+
+	for _, tc := range tests {
+		t.Run(tc.desc, func(t *testing.T) {
+			email, sms := &recorder{}, &recorder{}
+			if tc.failing {
+				sms.err = errors.New("SMS failed")
+			}
+			d := NewDispatcher(map[string]Channel{user.ChannelEmail: email,
+				user.ChannelSMS: sms})
+			d.SetSuppressor(SuppressorFunc(func(context.Context, string) (bool,
+				error) {
+				return tc.suppress, nil
+			}))
+
+			err := d.Send(context.Background(), tc.recipient,
+				&Message{Category: user.NotificationProduct}, tc.channels)
+			if !reflect.DeepEqual(err, tc.err) {
+				t.Errorf("Expected: %v. Received: %v", tc.err, err)
+			}
+			if len(email.sent) != tc.emails || len(sms.sent) != tc.sms {
+				t.Errorf("Expected: %d emails, %d SMS. Received: %d, %d",
+					tc.emails, tc.sms, len(email.sent), len(sms.sent))
+			}
+		})
+	}
+}
+
+//TestRecipientOf Tests that only verified phones receive messages
+func TestRecipientOf(t *testing.T) {
+	r := RecipientOf(&user.User{ID: "1", Email: "a@user.com",
+		Phone: "+34600000000"})
+	if r.Phone != "" {
+		t.Errorf("Expected no phone. Received: %v", r.Phone)
+	}
+
+	r = RecipientOf(&user.User{ID: "1", Email: "a@user.com",
+		Phone: "+34600000000", PhoneVerified: true})
+	if r.Phone != "+34600000000" {
+		t.Errorf("Expected: +34600000000. Received: %v", r.Phone)
+	}
+}
+
+//TestEmail Tests the headers of the raw messages
+func TestEmail(t *testing.T) {
+
+	tests := []struct {
+		desc        string
+		unsubscribe string
+		expected    bool
+	}{
+		{desc: "Mandatory", expected: false},
+		{desc: "Subscribed", unsubscribe: "https://api/users/unsubscribe?token=t",
+			expected: true},
+	}
+
+	for _, tc := range tests {
+		t.Run(tc.desc, func(t *testing.T) {
+			svc := &mockSES{}
+			err := NewEmail(svc, "no-reply@user.com").Send(context.Background(),
+				Recipient{Email: "a@user.com"}, &Message{Subject: "Hola señor",
+					Text: "Hello", UnsubscribeURL: tc.unsubscribe})
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			raw := string(svc.input.RawMessage.Data)
+			header := "List-Unsubscribe: <" + tc.unsubscribe + ">\r\n" +
+				"List-Unsubscribe-Post: List-Unsubscribe=One-Click\r\n"
+			if strings.Contains(raw, header) != tc.expected {
+				t.Errorf("Expected List-Unsubscribe: %v. Received: %s",
+					tc.expected, raw)
+			}
+			if !strings.Contains(raw, "Subject: =?UTF-8?q?Hola_se=C3=B1or?=\r\n") {
+				t.Errorf("Expected an encoded subject. Received: %s", raw)
+			}
+		})
+	}
+}
+
+//TestTwilio Tests the requests to the Twilio API
+func TestTwilio(t *testing.T) {
+
+	var form map[string][]string
+	var path, account string
+	server := httptest.NewServer(http.HandlerFunc(
+		func(w http.ResponseWriter, r *http.Request) {
+			path = r.URL.Path
+			account, _, _ = r.BasicAuth()
+			r.ParseForm()
+			form = r.PostForm
+			w.WriteHeader(http.StatusCreated)
+		}))
+	defer server.Close()
+
+	err := NewTwilio(server.URL, "AC1", "token", "+15005550006").Send(
+		context.Background(), Recipient{Phone: "+34600000000"},
+		&Message{Text: "Your code is 123456"})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	expected := map[string][]string{"To": {"+34600000000"},
+		"From": {"+15005550006"}, "Body": {"Your code is 123456"}}
+	if path != "/2010-04-01/Accounts/AC1/Messages.json" || account != "AC1" ||
+		!reflect.DeepEqual(form, expected) {
+		t.Errorf("Expected: %v. Received: %s %s %v", expected, path, account,
+			form)
+	}
+}
+
+//TestWebhook Tests the signature of the webhook requests
+func TestWebhook(t *testing.T) {
+
+	var body []byte
+	var signature string
+	server := httptest.NewServer(http.HandlerFunc(
+		func(w http.ResponseWriter, r *http.Request) {
+			body, _ = ioutil.ReadAll(r.Body)
+			signature = r.Header.Get(SignatureHeader)
+		}))
+	defer server.Close()
+
+	err := NewWebhook(server.URL, "secret").Send(context.Background(),
+		Recipient{ID: "1", Email: "a@user.com"},
+		&Message{Category: user.NotificationProduct, Text: "Hello"})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var ts int64
+	for _, part := range strings.Split(signature, ",") {
+		if strings.HasPrefix(part, "t=") {
+			ts, _ = strconv.ParseInt(strings.TrimPrefix(part, "t="), 10, 64)
+		}
+	}
+	if expected := Sign("secret", ts, body); signature != expected ||
+		time.Since(time.Unix(ts, 0)) > time.Minute {
+		t.Errorf("Expected: %v. Received: %v", expected, signature)
+	}
+
+	if !strings.Contains(string(body), `"category":"product"`) {
+		t.Errorf("Unexpected body: %s", body)
+	}
+}
+
+//TestConsole Tests printing the messages
+func TestConsole(t *testing.T) {
+	var out bytes.Buffer
+	c := &Console{Out: &out, Channel: user.ChannelSMS}
+	if err := c.Send(context.Background(), Recipient{Phone: "+34600000000"},
+		&Message{Category: user.NotificationAccount,
+			Text: "Your code is 123456"}); err != nil {
+		t.Fatal(err)
+	}
+
+	if !strings.Contains(out.String(), "sms account to +34600000000") ||
+		!strings.Contains(out.String(), "Your code is 123456") {
+		t.Errorf("Unexpected output: %s", out.String())
+	}
+}
+
+//TestParseFeedback Tests the deliveries of the SES notifications
+func TestParseFeedback(t *testing.T) {
+
+	tests := []struct {
+		desc       string
+		message    string
+		deliveries []*user.Delivery
+		err        error
+	}{
+		{
+			desc: "HardBounce",
+			message: `{"notificationType":"Bounce","mail":{"messageId":"m1",
+				"timestamp":"2020-01-01T00:00:00.000Z"},"bounce":{
+				"bounceType":"Permanent","bounceSubType":"General",
+				"feedbackId":"f1","timestamp":"2020-01-01T00:00:01.000Z",
+				"bouncedRecipients":[{"emailAddress":"a@user.com",
+				"diagnosticCode":"smtp; 550 5.1.1 user unknown"}]}}`,
+			deliveries: []*user.Delivery{{Email: "a@user.com",
+				Type: user.DeliveryTypeBounce, BounceType: "Permanent",
+				BounceSubType: "General",
+				Diagnostic:    "smtp; 550 5.1.1 user unknown", MessageID: "m1",
+				FeedbackID: "f1", Timestamp: "2020-01-01T00:00:01.000Z"}},
+		},
+		{
+			desc: "Complaint",
+			message: `{"eventType":"Complaint","mail":{"messageId":"m2",
+				"timestamp":"2020-01-01T00:00:00.000Z"},"complaint":{
+				"complaintFeedbackType":"abuse","feedbackId":"f2",
+				"complainedRecipients":[{"emailAddress":"b@user.com"}]}}`,
+			deliveries: []*user.Delivery{{Email: "b@user.com",
+				Type: user.DeliveryTypeComplaint, ComplaintType: "abuse",
+				MessageID: "m2", FeedbackID: "f2",
+				Timestamp: "2020-01-01T00:00:00.000Z"}},
+		},
+		{
+			desc: "Delivery",
+			message: `{"notificationType":"Delivery","mail":{"messageId":"m3"},
+				"delivery":{"timestamp":"2020-01-01T00:00:02.000Z",
+				"smtpResponse":"250 ok","recipients":["c@user.com"]}}`,
+			deliveries: []*user.Delivery{{Email: "c@user.com",
+				Type: user.DeliveryTypeDelivery, Diagnostic: "250 ok",
+				MessageID: "m3", Timestamp: "2020-01-01T00:00:02.000Z"}},
+		},
+		{
+			desc:    "Other",
+			message: `{"notificationType":"AmazonSnsSubscriptionSucceeded"}`,
+		},
+		{
+			desc:    "Invalid",
+			message: "Bounce",
+			err:     errors.New(ErrorInvalidFeedback),
+		},
+	}
+
+	for _, tc := range tests {
+		t.Run(tc.desc, func(t *testing.T) {
+			deliveries, err := ParseFeedback(tc.message)
+			if !reflect.DeepEqual(err, tc.err) {
+				t.Fatalf("Expected: %v. Received: %v", tc.err, err)
+			}
+			if !reflect.DeepEqual(deliveries, tc.deliveries) {
+				t.Errorf("Expected: %+v. Received: %+v", tc.deliveries,
+					deliveries)
+			}
+		})
+	}
+}
+
+//TestWorkerProcess Tests sending, rescheduling, retrying and dead-lettering the
+//queued emails
+func TestWorkerProcess(t *testing.T) {
+
+	cfg := QueueConfig{MaxAttempts: 3, Backoff: time.Minute,
+		MaxBackoff: 15 * time.Minute}
+
+	tests := []struct {
+		desc     string
+		job      Job
+		err      error
+		sent     int
+		queued   int
+		dead     int
+		attempts int
+	}{
+		{desc: "Sent", job: Job{ID: "1"}, sent: 1},
+		{desc: "Scheduled", job: Job{ID: "1", SendAt: time.Now().Add(time.Hour)},
+			queued: 1},
+		{desc: "Due", job: Job{ID: "1", SendAt: time.Now().Add(-time.Minute)},
+			sent: 1},
+		{desc: "Expired", job: Job{ID: "1",
+			ExpiresAt: time.Now().Add(-time.Minute)}},
+		{desc: "NotExpired", job: Job{ID: "1",
+			ExpiresAt: time.Now().Add(time.Minute)}, sent: 1},
+		{desc: "Throttled", job: Job{ID: "1"},
+			err:    awserr.New("Throttling", "Maximum sending rate exceeded", nil),
+			queued: 1, attempts: 1},
+		{desc: "RetriesExhausted", job: Job{ID: "1", Attempts: 2},
+			err:  awserr.New("Throttling", "Maximum sending rate exceeded", nil),
+			dead: 1, attempts: 3},
+		{desc: "Rejected", job: Job{ID: "1"},
+			err:  awserr.New("MessageRejected", "Email address is not verified", nil),
+			dead: 1, attempts: 1},
+	}
+
+	for _, tc := range tests {
+		t.Run(tc.desc, func(t *testing.T) {
+			queue := NewMemory()
+			email := &recorder{err: tc.err}
+			w := NewWorker(queue, email, nil, cfg)
+
+			job := tc.job
+			if err := w.Process(context.Background(), &job); err != nil {
+				t.Fatalf("Expected: %v. Received: %v", nil, err)
+			}
+			if len(email.sent) != tc.sent {
+				t.Errorf("Expected: %v. Received: %v", tc.sent, len(email.sent))
+			}
+			if queue.Len() != tc.queued {
+				t.Errorf("Expected: %v. Received: %v", tc.queued, queue.Len())
+			}
+			if len(queue.Dead) != tc.dead {
+				t.Errorf("Expected: %v. Received: %v", tc.dead, len(queue.Dead))
+			}
+			if job.Attempts != tc.attempts {
+				t.Errorf("Expected: %v. Received: %v", tc.attempts, job.Attempts)
+			}
+		})
+	}
+}
+
+//TestWorkerBackoff Tests the jittered backoff stays within its bounds
+func TestWorkerBackoff(t *testing.T) {
+
+	w := NewWorker(nil, nil, nil, QueueConfig{Backoff: 30 * time.Second,
+		MaxBackoff: 15 * time.Minute})
+
+	tests := []struct {
+		desc     string
+		attempts int
+		max      time.Duration
+	}{
+		{desc: "First", attempts: 1, max: 30 * time.Second},
+		{desc: "Third", attempts: 3, max: 2 * time.Minute},
+		{desc: "Capped", attempts: 20, max: 15 * time.Minute},
+	}
+
+	for _, tc := range tests {
+		t.Run(tc.desc, func(t *testing.T) {
+			for i := 0; i < 100; i++ {
+				d := w.backoff(tc.attempts)
+				if d < tc.max/2 || d > tc.max {
+					t.Fatalf("Expected: %v to %v. Received: %v", tc.max/2,
+						tc.max, d)
+				}
+			}
+		})
+	}
+}
+
+//TestQueued Tests enqueueing the emails and draining the memory queue
+func TestQueued(t *testing.T) {
+
+	tests := []struct {
+		desc   string
+		sendAt time.Time
+		sent   int
+		queued int
+	}{
+		{desc: "Now", sent: 1},
+		{desc: "Scheduled", sendAt: time.Now().Add(time.Hour), queued: 1},
+	}
+
+	for _, tc := range tests {
+		t.Run(tc.desc, func(t *testing.T) {
+			queue := NewMemory()
+			email := &recorder{}
+			d := NewDispatcher(map[string]Channel{
+				user.ChannelEmail: NewQueued(queue)})
+			d.worker = NewWorker(queue, email, NewLimiter(100),
+				QueueConfig{MaxAttempts: 1})
+
+			err := d.Send(context.Background(), Recipient{Email: "a@user.com"},
+				&Message{Category: user.NotificationAccount, SendAt: tc.sendAt},
+				[]string{user.ChannelEmail})
+			if err != nil {
+				t.Fatalf("Expected: %v. Received: %v", nil, err)
+			}
+			if len(email.sent) != 0 {
+				t.Errorf("Expected: %v. Received: %v", 0, len(email.sent))
+			}
+
+			if err := d.Flush(context.Background()); err != nil {
+				t.Fatalf("Expected: %v. Received: %v", nil, err)
+			}
+			if len(email.sent) != tc.sent {
+				t.Errorf("Expected: %v. Received: %v", tc.sent, len(email.sent))
+			}
+			if queue.Len() != tc.queued {
+				t.Errorf("Expected: %v. Received: %v", tc.queued, queue.Len())
+			}
+		})
+	}
+}
@@ -0,0 +1,98 @@
+package notify
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+const (
+	//QueueSQS queues the emails in Amazon SQS, sent by the mailer function
+	QueueSQS = "sqs"
+
+	//QueueMemory queues the emails in memory, sent by Flush. It stands in for
+	//SQS in development, the jobs are lost when the process exits
+	QueueMemory = "memory"
+
+	//ErrorInvalidJob Returned when a queued message is not a job
+	ErrorInvalidJob = "InvalidJob"
+)
+
+//Job is a rendered email waiting in the queue to be sent by the Worker
+type Job struct {
+	ID        string    `json:"id"`
+	Recipient Recipient `json:"recipient"`
+	Message   Message   `json:"message"`
+
+	//SendAt is when the email is sent, now when it is zero
+	SendAt time.Time `json:"sendAt,omitempty"`
+
+	//ExpiresAt is when the email is dropped if not sent, never when it is zero
+	ExpiresAt time.Time `json:"expiresAt,omitempty"`
+
+	//Attempts are the failed sends, the last one failing with Error
+	Attempts int    `json:"attempts,omitempty"`
+	Error    string `json:"error,omitempty"`
+
+	//Handle identifies the received message to delete it
+	Handle string `json:"-"`
+}
+
+//Queue holds the email jobs until the Worker sends them
+type Queue interface {
+	//Enqueue adds the job, which is not received before delay
+	Enqueue(ctx context.Context, job *Job, delay time.Duration) error
+
+	//Receive returns up to max jobs ready to be sent
+	Receive(ctx context.Context, max int) ([]*Job, error)
+
+	//Delete removes a received job from the queue
+	Delete(ctx context.Context, job *Job) error
+
+	//DeadLetter keeps a job that could not be sent for inspection
+	DeadLetter(ctx context.Context, job *Job) error
+}
+
+//ParseJob returns the job of a queued message body
+func ParseJob(body, handle string) (*Job, error) {
+	var job Job
+	if err := json.Unmarshal([]byte(body), &job); err != nil || job.ID == "" {
+		return nil, errors.New(ErrorInvalidJob)
+	}
+	job.Handle = handle
+	return &job, nil
+}
+
+//Queued is the email channel of a dispatcher with a queue: the messages are
+//enqueued and the Worker sends them, so a slow or throttled SES does not block
+//the caller
+type Queued struct {
+	queue Queue
+}
+
+//NewQueued returns the channel enqueueing the emails in queue
+func NewQueued(queue Queue) *Queued {
+	return &Queued{queue: queue}
+}
+
+//Send enqueues the message, delayed until its SendAt
+func (q *Queued) Send(ctx context.Context, r Recipient, m *Message) error {
+
+	job := &Job{
+		ID:        uuid.New().String(),
+		Recipient: r,
+		Message:   *m,
+		SendAt:    m.SendAt,
+		ExpiresAt: m.ExpiresAt,
+	}
+
+	var delay time.Duration
+	if !m.SendAt.IsZero() {
+		delay = time.Until(m.SendAt)
+	}
+
+	return q.queue.Enqueue(ctx, job, delay)
+}
@@ -0,0 +1,100 @@
+package notify
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/sns"
+	"github.com/aws/aws-sdk-go/service/sns/snsiface"
+
+	"github.com/roloum/users/internal/user"
+)
+
+//SNS sends the messages by SMS with Amazon SNS
+type SNS struct {
+	svc      snsiface.SNSAPI
+	senderID string
+}
+
+//NewSNS returns the SMS channel of SNS
+func NewSNS(svc snsiface.SNSAPI, senderID string) *SNS {
+	return &SNS{svc: svc, senderID: senderID}
+}
+
+//Send publishes the text of the message to the phone of the recipient. The
+//marketing messages are sent as promotional, the others as transactional
+func (s *SNS) Send(ctx context.Context, r Recipient, m *Message) error {
+
+	smsType := "Transactional"
+	if m.Category == user.NotificationMarketing {
+		smsType = "Promotional"
+	}
+
+	attributes := map[string]*sns.MessageAttributeValue{
+		"AWS.SNS.SMS.SMSType": {DataType: aws.String("String"),
+			StringValue: aws.String(smsType)},
+	}
+	if s.senderID != "" {
+		attributes["AWS.SNS.SMS.SenderID"] = &sns.MessageAttributeValue{
+			DataType: aws.String("String"), StringValue: aws.String(s.senderID)}
+	}
+
+	_, err := s.svc.PublishWithContext(ctx, &sns.PublishInput{
+		PhoneNumber:       aws.String(r.Phone),
+		Message:           aws.String(m.Text),
+		MessageAttributes: attributes,
+	})
+	return err
+}
+
+//Twilio sends the messages by SMS with the Twilio Messages API, or any API
+//compatible with it at URL
+type Twilio struct {
+	URL        string
+	AccountSID string
+	Token      string
+	From       string
+	Client     *http.Client
+}
+
+//NewTwilio returns the SMS channel of the Twilio API at baseURL
+func NewTwilio(baseURL, accountSID, token, from string) *Twilio {
+	return &Twilio{URL: strings.TrimSuffix(baseURL, "/"),
+		AccountSID: accountSID, Token: token, From: from,
+		Client: &http.Client{Timeout: 10 * time.Second}}
+}
+
+//Send creates a message of the account with the text of the message
+func (t *Twilio) Send(ctx context.Context, r Recipient, m *Message) error {
+
+	form := url.Values{
+		"To":   {r.Phone},
+		"From": {t.From},
+		"Body": {m.Text},
+	}
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost,
+		fmt.Sprintf("%s/2010-04-01/Accounts/%s/Messages.json", t.URL,
+			url.PathEscape(t.AccountSID)), strings.NewReader(form.Encode()))
+	if err != nil {
+		return err
+	}
+	req.SetBasicAuth(t.AccountSID, t.Token)
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+
+	resp, err := t.Client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode >= 300 {
+		return fmt.Errorf("Twilio API: %s", resp.Status)
+	}
+	return nil
+}
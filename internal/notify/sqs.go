@@ -0,0 +1,102 @@
+package notify
+
+import (
+	"context"
+	"encoding/json"
+	"time"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/sqs"
+	"github.com/aws/aws-sdk-go/service/sqs/sqsiface"
+)
+
+//SQSMaxDelay is the longest delay of an SQS message. Jobs scheduled later are
+//enqueued again by the Worker until they are due
+const SQSMaxDelay = 15 * time.Minute
+
+//SQS is the queue of the emails in Amazon SQS, with a dead-letter queue
+type SQS struct {
+	svc           sqsiface.SQSAPI
+	url           string
+	deadLetterURL string
+}
+
+//NewSQS returns the queue at url, the jobs that can not be sent are moved to
+//the queue at deadLetterURL
+func NewSQS(svc sqsiface.SQSAPI, url, deadLetterURL string) *SQS {
+	return &SQS{svc: svc, url: url, deadLetterURL: deadLetterURL}
+}
+
+//Enqueue sends the job to the queue, delayed up to SQSMaxDelay
+func (q *SQS) Enqueue(ctx context.Context, job *Job, delay time.Duration) error {
+	if delay > SQSMaxDelay {
+		delay = SQSMaxDelay
+	}
+	if delay < 0 {
+		delay = 0
+	}
+	return q.send(ctx, q.url, job, delay)
+}
+
+//Receive long polls the queue for up to max jobs, at most 10. Messages that
+//are not jobs are deleted
+func (q *SQS) Receive(ctx context.Context, max int) ([]*Job, error) {
+	if max > 10 {
+		max = 10
+	}
+
+	out, err := q.svc.ReceiveMessageWithContext(ctx, &sqs.ReceiveMessageInput{
+		QueueUrl:            aws.String(q.url),
+		MaxNumberOfMessages: aws.Int64(int64(max)),
+		WaitTimeSeconds:     aws.Int64(1),
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	var jobs []*Job
+	for _, m := range out.Messages {
+		job, err := ParseJob(aws.StringValue(m.Body),
+			aws.StringValue(m.ReceiptHandle))
+		if err != nil {
+			if err := q.Delete(ctx, &Job{
+				Handle: aws.StringValue(m.ReceiptHandle)}); err != nil {
+				return nil, err
+			}
+			continue
+		}
+		jobs = append(jobs, job)
+	}
+
+	return jobs, nil
+}
+
+//Delete deletes the received message of the job
+func (q *SQS) Delete(ctx context.Context, job *Job) error {
+	_, err := q.svc.DeleteMessageWithContext(ctx, &sqs.DeleteMessageInput{
+		QueueUrl:      aws.String(q.url),
+		ReceiptHandle: aws.String(job.Handle),
+	})
+	return err
+}
+
+//DeadLetter sends the job to the dead-letter queue
+func (q *SQS) DeadLetter(ctx context.Context, job *Job) error {
+	return q.send(ctx, q.deadLetterURL, job, 0)
+}
+
+func (q *SQS) send(ctx context.Context, url string, job *Job,
+	delay time.Duration) error {
+
+	body, err := json.Marshal(job)
+	if err != nil {
+		return err
+	}
+
+	_, err = q.svc.SendMessageWithContext(ctx, &sqs.SendMessageInput{
+		QueueUrl:     aws.String(url),
+		MessageBody:  aws.String(string(body)),
+		DelaySeconds: aws.Int64(int64(delay / time.Second)),
+	})
+	return err
+}
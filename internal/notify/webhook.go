@@ -0,0 +1,78 @@
+package notify
+
+import (
+	"bytes"
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strconv"
+	"time"
+)
+
+//SignatureHeader carries the signature of the webhook requests:
+//t=<unix time>,v1=<hex HMAC-SHA256 of "<unix time>.<body>">. Receivers check
+//it with the shared secret and reject old timestamps to prevent replays
+const SignatureHeader = "X-Users-Signature"
+
+//Webhook posts the messages as JSON to an URL
+type Webhook struct {
+	URL    string
+	Secret string
+	Client *http.Client
+}
+
+//webhookPayload is the body of the webhook requests
+type webhookPayload struct {
+	Recipient Recipient `json:"recipient"`
+	Message   *Message  `json:"message"`
+	Sent      string    `json:"sent"`
+}
+
+//NewWebhook returns the webhook channel of the URL, signing with secret
+func NewWebhook(url, secret string) *Webhook {
+	return &Webhook{URL: url, Secret: secret,
+		Client: &http.Client{Timeout: 10 * time.Second}}
+}
+
+//Send posts the recipient and the message
+func (w *Webhook) Send(ctx context.Context, r Recipient, m *Message) error {
+
+	now := time.Now().UTC()
+	body, err := json.Marshal(&webhookPayload{Recipient: r, Message: m,
+		Sent: now.Format(time.RFC3339)})
+	if err != nil {
+		return err
+	}
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, w.URL,
+		bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set(SignatureHeader, Sign(w.Secret, now.Unix(), body))
+
+	resp, err := w.Client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode >= 300 {
+		return fmt.Errorf("Webhook: %s", resp.Status)
+	}
+	return nil
+}
+
+//Sign returns the value of the SignatureHeader of a body sent at ts
+func Sign(secret string, ts int64, body []byte) string {
+	t := strconv.FormatInt(ts, 10)
+	mac := hmac.New(sha256.New, []byte(secret))
+	mac.Write([]byte(t + "."))
+	mac.Write(body)
+	return fmt.Sprintf("t=%s,v1=%s", t, hex.EncodeToString(mac.Sum(nil)))
+}
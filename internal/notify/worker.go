@@ -0,0 +1,210 @@
+package notify
+
+import (
+	"context"
+	"math/rand"
+	"sync"
+	"time"
+
+	"github.com/rs/zerolog/log"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/ses"
+	"github.com/aws/aws-sdk-go/service/ses/sesiface"
+
+	"github.com/roloum/users/internal/apperr"
+)
+
+//QueueConfig is the configuration of the email queue and of its Worker
+type QueueConfig struct {
+	//Backend is sqs or memory, the emails are sent right away when it is empty
+	Backend string
+
+	//URL and DeadLetterURL are the SQS queues of the jobs and of the jobs that
+	//could not be sent
+	URL           string
+	DeadLetterURL string
+
+	//MaxAttempts are the sends of a job before it is dead-lettered
+	MaxAttempts int `default:"5"`
+
+	//Backoff is the delay of the first retry, doubled on every attempt up to
+	//MaxBackoff. Half of it is random so throttled jobs do not retry together
+	Backoff    time.Duration `default:"30s"`
+	MaxBackoff time.Duration `default:"15m"`
+
+	//Rate are the emails sent per second. When it is 0 the mailer function
+	//uses the MaxSendRate of the SES quota, the memory queue does not limit it
+	Rate float64
+}
+
+//Worker sends the queued emails
+type Worker struct {
+	queue   Queue
+	channel Channel
+	limiter *Limiter
+	cfg     QueueConfig
+}
+
+//NewWorker returns the worker sending the jobs of queue through channel, at
+//most at the rate of limiter
+func NewWorker(queue Queue, channel Channel, limiter *Limiter,
+	cfg QueueConfig) *Worker {
+	return &Worker{queue: queue, channel: channel, limiter: limiter, cfg: cfg}
+}
+
+//Process sends the job and deletes it from the queue. Expired jobs are deleted
+//without sending them, jobs scheduled later are enqueued again with the
+//remaining delay. A failed send is retried with a
+//jittered exponential backoff when the error is transient, and is moved to the
+//dead-letter queue after MaxAttempts or when it is not. An error is only
+//returned when the queue fails, the job is then received again
+func (w *Worker) Process(ctx context.Context, job *Job) error {
+
+	if !job.ExpiresAt.IsZero() && !time.Now().Before(job.ExpiresAt) {
+		log.Warn().Msgf("Dropping %s email %s to %s, expired at %s",
+			job.Message.Category, job.ID, job.Recipient.Email,
+			job.ExpiresAt.Format(time.RFC3339))
+		return w.queue.Delete(ctx, job)
+	}
+
+	if wait := time.Until(job.SendAt); wait > 0 {
+		log.Debug().Msgf("Job %s is scheduled at %s", job.ID,
+			job.SendAt.Format(time.RFC3339))
+		return w.requeue(ctx, job, wait)
+	}
+
+	if err := w.limiter.Wait(ctx); err != nil {
+		return err
+	}
+
+	err := w.channel.Send(ctx, job.Recipient, &job.Message)
+	if err == nil {
+		log.Info().Msgf("Sent %s email %s to %s", job.Message.Category, job.ID,
+			job.Recipient.Email)
+		return w.queue.Delete(ctx, job)
+	}
+
+	job.Attempts++
+	job.Error = err.Error()
+
+	if !apperr.IsRetryable(err) || job.Attempts >= w.cfg.MaxAttempts {
+		log.Error().Err(err).Msgf("Dead-lettering %s email %s to %s after %d attempts",
+			job.Message.Category, job.ID, job.Recipient.Email, job.Attempts)
+		if err := w.queue.DeadLetter(ctx, job); err != nil {
+			return err
+		}
+		return w.queue.Delete(ctx, job)
+	}
+
+	delay := w.backoff(job.Attempts)
+	log.Warn().Err(err).Msgf("Retrying %s email %s to %s in %s",
+		job.Message.Category, job.ID, job.Recipient.Email, delay)
+	return w.requeue(ctx, job, delay)
+}
+
+//Drain processes the jobs of the queue until none is due
+func (w *Worker) Drain(ctx context.Context) error {
+	for {
+		jobs, err := w.queue.Receive(ctx, 10)
+		if err != nil {
+			return err
+		}
+		if len(jobs) == 0 {
+			return nil
+		}
+		for _, job := range jobs {
+			if err := w.Process(ctx, job); err != nil {
+				return err
+			}
+		}
+	}
+}
+
+//requeue enqueues the job again after delay and deletes the received one
+func (w *Worker) requeue(ctx context.Context, job *Job,
+	delay time.Duration) error {
+
+	if err := w.queue.Enqueue(ctx, job, delay); err != nil {
+		return err
+	}
+	return w.queue.Delete(ctx, job)
+}
+
+//backoff returns the delay of the retry after attempts, between half and all
+//of the exponential backoff
+func (w *Worker) backoff(attempts int) time.Duration {
+
+	d := w.cfg.Backoff
+	for i := 1; i < attempts && d < w.cfg.MaxBackoff; i++ {
+		d *= 2
+	}
+	if w.cfg.MaxBackoff > 0 && d > w.cfg.MaxBackoff {
+		d = w.cfg.MaxBackoff
+	}
+	if d <= 0 {
+		return 0
+	}
+
+	return d/2 + time.Duration(rand.Int63n(int64(d/2)+1))
+}
+
+//Limiter spaces the sends to stay under a rate per second. A nil Limiter, or
+//one without rate, does not wait
+type Limiter struct {
+	mu       sync.Mutex
+	interval time.Duration
+	next     time.Time
+}
+
+//NewLimiter returns the limiter of rate sends per second
+func NewLimiter(rate float64) *Limiter {
+	l := &Limiter{}
+	if rate > 0 {
+		l.interval = time.Duration(float64(time.Second) / rate)
+	}
+	return l
+}
+
+//Wait blocks until the next send is allowed or ctx is done
+func (l *Limiter) Wait(ctx context.Context) error {
+
+	if l == nil || l.interval == 0 {
+		return nil
+	}
+
+	l.mu.Lock()
+	now := time.Now()
+	if l.next.Before(now) {
+		l.next = now
+	}
+	wait := l.next.Sub(now)
+	l.next = l.next.Add(l.interval)
+	l.mu.Unlock()
+
+	if wait <= 0 {
+		return nil
+	}
+
+	t := time.NewTimer(wait)
+	defer t.Stop()
+
+	select {
+	case <-ctx.Done():
+		return ctx.Err()
+	case <-t.C:
+		return nil
+	}
+}
+
+//SendRate returns the emails per second allowed by the SES quota of the
+//account
+func SendRate(ctx context.Context, svc sesiface.SESAPI) (float64, error) {
+
+	out, err := svc.GetSendQuotaWithContext(ctx, &ses.GetSendQuotaInput{})
+	if err != nil {
+		return 0, err
+	}
+
+	return aws.Float64Value(out.MaxSendRate), nil
+}
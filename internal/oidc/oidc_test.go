@@ -0,0 +1,155 @@
+package oidc
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/rs/zerolog"
+
+	"github.com/roloum/users/internal/test"
+)
+
+const (
+	ClientID = "client"
+	Nonce    = "nonce"
+)
+
+func init() {
+	zerolog.SetGlobalLevel(zerolog.Disabled)
+}
+
+//TestIdentity Tests the code exchange and ID token validation against a local
+//mock issuer
+func TestIdentity(t *testing.T) {
+
+	issuer, err := test.NewMockIssuer()
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	defer issuer.Server.Close()
+
+	claims := func(overrides map[string]interface{}) map[string]interface{} {
+		c := map[string]interface{}{
+			"iss":            issuer.Issuer(),
+			"sub":            "1234",
+			"aud":            ClientID,
+			"exp":            time.Now().Add(time.Minute).Unix(),
+			"iat":            time.Now().Unix(),
+			"nonce":          Nonce,
+			"email":          "Test@User.com",
+			"email_verified": true,
+			"given_name":     "Test",
+			"family_name":    "User",
+		}
+		for k, v := range overrides {
+			c[k] = v
+		}
+		return c
+	}
+
+	tests := []struct {
+		desc     string
+		claims   map[string]interface{}
+		verifier string
+		identity *Identity
+		err      error
+	}{
+		{
+			desc:   "ValidIDToken",
+			claims: claims(nil),
+			identity: &Identity{
+				Provider:      "mock",
+				Subject:       "1234",
+				Email:         "test@user.com",
+				EmailVerified: true,
+				GivenName:     "Test",
+				FamilyName:    "User",
+			},
+		},
+		{
+			desc:   "AudienceArray",
+			claims: claims(map[string]interface{}{"aud": []string{"other", ClientID}}),
+			identity: &Identity{
+				Provider:      "mock",
+				Subject:       "1234",
+				Email:         "test@user.com",
+				EmailVerified: true,
+				GivenName:     "Test",
+				FamilyName:    "User",
+			},
+		},
+		{
+			desc:   "InvalidAudience",
+			claims: claims(map[string]interface{}{"aud": "other"}),
+			err:    errors.New(ErrorInvalidIDToken),
+		},
+		{
+			desc:   "InvalidNonce",
+			claims: claims(map[string]interface{}{"nonce": "other"}),
+			err:    errors.New(ErrorInvalidIDToken),
+		},
+		{
+			desc:   "Expired",
+			claims: claims(map[string]interface{}{"exp": time.Now().Add(-time.Minute).Unix()}),
+			err:    errors.New(ErrorInvalidIDToken),
+		},
+		{
+			desc:     "InvalidVerifier",
+			claims:   claims(nil),
+			verifier: "other",
+			err:      errors.New(ErrorExchangeFailed),
+		},
+	}
+
+	for _, tc := range tests {
+		t.Run(tc.desc, func(t *testing.T) {
+			p := &Provider{
+				Name:     "mock",
+				Issuer:   issuer.Issuer(),
+				ClientID: ClientID,
+			}
+			if err := p.Discover(context.Background(), http.DefaultClient); err != nil {
+				t.Fatalf("Unexpected error: %v", err)
+			}
+
+			verifier, challenge, _ := NewPKCE()
+			issuer.Authorize(tc.desc, challenge, tc.claims)
+			if tc.verifier != "" {
+				verifier = tc.verifier
+			}
+
+			id, err := p.Identity(context.Background(), http.DefaultClient,
+				tc.desc, verifier, Nonce)
+			if !reflect.DeepEqual(err, tc.err) {
+				t.Errorf("Expected: %v. Received: %v", tc.err, err)
+			}
+			if !reflect.DeepEqual(id, tc.identity) {
+				t.Errorf("Expected: %+v. Received: %+v", tc.identity, id)
+			}
+		})
+	}
+}
+
+//TestProvidersDecode Tests loading the providers from the environment
+func TestProvidersDecode(t *testing.T) {
+
+	var p Providers
+	err := p.Decode(`[{"name":"google","issuer":"https://accounts.google.com"},
+		{"name":"github","kind":"github"}]`)
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+
+	if _, err := p.Get("github"); err != nil {
+		t.Errorf("Expected: %v. Received: %v", nil, err)
+	}
+
+	if _, err := p.Get("other"); !reflect.DeepEqual(err,
+		errors.New(ErrorUnknownProvider)) {
+		t.Errorf("Expected: %v. Received: %v", ErrorUnknownProvider, err)
+	}
+}
@@ -0,0 +1,423 @@
+//Package oidc implements the authorization code flow with PKCE against
+//external OpenID Connect and OAuth2 identity providers
+package oidc
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+
+	"github.com/rs/zerolog/log"
+
+	"github.com/roloum/users/internal/apperr"
+	"github.com/roloum/users/internal/jwt"
+)
+
+const (
+	//KindOIDC generic OpenID Connect provider, configured through discovery
+	KindOIDC = "oidc"
+
+	//KindGitHub GitHub OAuth2 app. GitHub does not issue ID tokens, the
+	//identity is read from its REST API
+	KindGitHub = "github"
+
+	//ErrorUnknownProvider Returned when the provider is not configured
+	ErrorUnknownProvider = "UnknownProvider"
+
+	//ErrorExchangeFailed Returned when the token endpoint rejects the code
+	ErrorExchangeFailed = "CodeExchangeFailed"
+
+	//ErrorInvalidIDToken Returned when the ID token claims do not validate
+	ErrorInvalidIDToken = "InvalidIDToken"
+)
+
+//init registers the HTTP status of the errors of the package
+func init() {
+	apperr.Register(http.StatusNotFound, ErrorUnknownProvider)
+	apperr.Register(http.StatusUnauthorized, ErrorInvalidState,
+		ErrorInvalidIDToken, jwt.ErrorMalformedToken,
+		jwt.ErrorUnsupportedAlgorithm, jwt.ErrorUnknownKey,
+		jwt.ErrorInvalidSignature)
+	apperr.Register(http.StatusBadGateway, ErrorExchangeFailed)
+}
+
+//Provider describes an identity provider. Endpoints of KindOIDC providers are
+//discovered from the issuer
+type Provider struct {
+	Name         string   `json:"name"`
+	Kind         string   `json:"kind"`
+	Issuer       string   `json:"issuer"`
+	ClientID     string   `json:"clientId"`
+	ClientSecret string   `json:"clientSecret"`
+	RedirectURL  string   `json:"redirectURL"`
+	Scopes       []string `json:"scopes"`
+
+	AuthURL     string `json:"authURL"`
+	TokenURL    string `json:"tokenURL"`
+	UserInfoURL string `json:"userInfoURL"`
+	JWKSURL     string `json:"jwksURL"`
+}
+
+//Providers is the list of configured providers. It is loaded by config.Load
+//from a JSON array, e.g. USERS_OIDC_PROVIDERS='[{"name":"google",...}]'
+type Providers []Provider
+
+//Decode implements envconfig.Decoder
+func (p *Providers) Decode(value string) error {
+	return json.Unmarshal([]byte(value), p)
+}
+
+//Get returns the provider by name
+func (p Providers) Get(name string) (*Provider, error) {
+	for i := range p {
+		if p[i].Name == name {
+			return &p[i], nil
+		}
+	}
+	return nil, errors.New(ErrorUnknownProvider)
+}
+
+//Identity is the information about the user returned by the provider
+type Identity struct {
+	Provider      string
+	Subject       string
+	Email         string
+	EmailVerified bool
+	GivenName     string
+	FamilyName    string
+}
+
+//discovery is the subset of the OpenID provider metadata used by the flow
+type discovery struct {
+	Issuer                string `json:"issuer"`
+	AuthorizationEndpoint string `json:"authorization_endpoint"`
+	TokenEndpoint         string `json:"token_endpoint"`
+	UserInfoEndpoint      string `json:"userinfo_endpoint"`
+	JWKSURI               string `json:"jwks_uri"`
+}
+
+//Discover loads the endpoints of an OIDC provider from
+//<issuer>/.well-known/openid-configuration. Endpoints already set are kept
+func (p *Provider) Discover(ctx context.Context, client *http.Client) error {
+
+	switch p.kind() {
+	case KindGitHub:
+		p.setDefaults("https://github.com/login/oauth/authorize",
+			"https://github.com/login/oauth/access_token",
+			"https://api.github.com/user", "")
+		return nil
+	case KindOIDC:
+	default:
+		return errors.New(ErrorUnknownProvider)
+	}
+
+	if p.AuthURL != "" && p.TokenURL != "" && p.JWKSURL != "" {
+		return nil
+	}
+
+	log.Debug().Msgf("Discovering provider: %s", p.Issuer)
+
+	var d discovery
+	if err := getJSON(ctx, client,
+		strings.TrimSuffix(p.Issuer, "/")+"/.well-known/openid-configuration",
+		"", &d); err != nil {
+		return err
+	}
+
+	if d.Issuer != p.Issuer {
+		return fmt.Errorf("issuer mismatch: %s", d.Issuer)
+	}
+
+	p.setDefaults(d.AuthorizationEndpoint, d.TokenEndpoint, d.UserInfoEndpoint,
+		d.JWKSURI)
+
+	return nil
+}
+
+//AuthCodeURL returns the URL the user is redirected to in order to sign in
+func (p *Provider) AuthCodeURL(state, nonce, challenge string) string {
+	v := url.Values{}
+	v.Set("response_type", "code")
+	v.Set("client_id", p.ClientID)
+	v.Set("redirect_uri", p.RedirectURL)
+	v.Set("scope", strings.Join(p.scopes(), " "))
+	v.Set("state", state)
+	v.Set("code_challenge", challenge)
+	v.Set("code_challenge_method", "S256")
+	if p.kind() == KindOIDC {
+		v.Set("nonce", nonce)
+	}
+
+	sep := "?"
+	if strings.Contains(p.AuthURL, "?") {
+		sep = "&"
+	}
+	return p.AuthURL + sep + v.Encode()
+}
+
+//tokenResponse is the response of the token endpoint
+type tokenResponse struct {
+	AccessToken string `json:"access_token"`
+	TokenType   string `json:"token_type"`
+	IDToken     string `json:"id_token"`
+	Error       string `json:"error"`
+}
+
+//idTokenClaims are the ID token claims used to validate and map the identity
+type idTokenClaims struct {
+	Issuer        string   `json:"iss"`
+	Subject       string   `json:"sub"`
+	Audience      Audience `json:"aud"`
+	Expires       int64    `json:"exp"`
+	IssuedAt      int64    `json:"iat"`
+	Nonce         string   `json:"nonce"`
+	Email         string   `json:"email"`
+	EmailVerified bool     `json:"email_verified"`
+	GivenName     string   `json:"given_name"`
+	FamilyName    string   `json:"family_name"`
+}
+
+//Audience is the aud claim, which can be a string or an array of strings
+type Audience []string
+
+//UnmarshalJSON accepts both forms of the aud claim
+func (a *Audience) UnmarshalJSON(b []byte) error {
+	var s string
+	if err := json.Unmarshal(b, &s); err == nil {
+		*a = Audience{s}
+		return nil
+	}
+	var l []string
+	if err := json.Unmarshal(b, &l); err != nil {
+		return err
+	}
+	*a = l
+	return nil
+}
+
+//Contains returns true when the audience includes the client
+func (a Audience) Contains(clientID string) bool {
+	for _, v := range a {
+		if v == clientID {
+			return true
+		}
+	}
+	return false
+}
+
+//Identity exchanges the authorization code and returns the identity of the
+//user. For OIDC providers the ID token is validated against the provider JWKS
+func (p *Provider) Identity(ctx context.Context, client *http.Client, code,
+	verifier, nonce string) (*Identity, error) {
+
+	log.Debug().Msgf("Exchanging code with provider: %s", p.Name)
+
+	form := url.Values{}
+	form.Set("grant_type", "authorization_code")
+	form.Set("code", code)
+	form.Set("redirect_uri", p.RedirectURL)
+	form.Set("client_id", p.ClientID)
+	form.Set("client_secret", p.ClientSecret)
+	form.Set("code_verifier", verifier)
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, p.TokenURL,
+		strings.NewReader(form.Encode()))
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	req.Header.Set("Accept", "application/json")
+
+	resp, err := client.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	var token tokenResponse
+	if err := json.NewDecoder(resp.Body).Decode(&token); err != nil {
+		return nil, err
+	}
+
+	if resp.StatusCode != http.StatusOK || token.Error != "" {
+		log.Debug().Msgf("Token endpoint error: %d %s", resp.StatusCode, token.Error)
+		return nil, errors.New(ErrorExchangeFailed)
+	}
+
+	if p.kind() == KindGitHub {
+		return p.gitHubIdentity(ctx, client, token.AccessToken)
+	}
+
+	return p.verifyIDToken(ctx, client, token.IDToken, nonce)
+}
+
+//verifyIDToken validates the signature and claims of the ID token
+func (p *Provider) verifyIDToken(ctx context.Context, client *http.Client,
+	idToken, nonce string) (*Identity, error) {
+
+	var keys jwt.JWKS
+	if err := getJSON(ctx, client, p.JWKSURL, "", &keys); err != nil {
+		return nil, err
+	}
+
+	var claims idTokenClaims
+	if err := jwt.Verify(idToken, keys, &claims); err != nil {
+		return nil, err
+	}
+
+	now := time.Now().Unix()
+	switch {
+	case claims.Issuer != p.Issuer:
+		log.Debug().Msgf("Invalid issuer: %s", claims.Issuer)
+		return nil, errors.New(ErrorInvalidIDToken)
+	case !claims.Audience.Contains(p.ClientID):
+		log.Debug().Msgf("Invalid audience: %v", claims.Audience)
+		return nil, errors.New(ErrorInvalidIDToken)
+	case claims.Expires < now:
+		log.Debug().Msg("ID token expired")
+		return nil, errors.New(ErrorInvalidIDToken)
+	case claims.Nonce != nonce:
+		log.Debug().Msg("Invalid nonce")
+		return nil, errors.New(ErrorInvalidIDToken)
+	case claims.Subject == "":
+		return nil, errors.New(ErrorInvalidIDToken)
+	}
+
+	return &Identity{
+		Provider:      p.Name,
+		Subject:       claims.Subject,
+		Email:         strings.ToLower(claims.Email),
+		EmailVerified: claims.EmailVerified,
+		GivenName:     claims.GivenName,
+		FamilyName:    claims.FamilyName,
+	}, nil
+}
+
+//gitHubIdentity reads the user and its primary email from the GitHub API
+func (p *Provider) gitHubIdentity(ctx context.Context, client *http.Client,
+	accessToken string) (*Identity, error) {
+
+	var gu struct {
+		ID   int64  `json:"id"`
+		Name string `json:"name"`
+	}
+	if err := getJSON(ctx, client, p.UserInfoURL, accessToken, &gu); err != nil {
+		return nil, err
+	}
+
+	var emails []struct {
+		Email    string `json:"email"`
+		Primary  bool   `json:"primary"`
+		Verified bool   `json:"verified"`
+	}
+	if err := getJSON(ctx, client, strings.TrimSuffix(p.UserInfoURL, "/")+"/emails",
+		accessToken, &emails); err != nil {
+		return nil, err
+	}
+
+	id := &Identity{
+		Provider: p.Name,
+		Subject:  fmt.Sprintf("%d", gu.ID),
+	}
+
+	for _, e := range emails {
+		if e.Primary {
+			id.Email = strings.ToLower(e.Email)
+			id.EmailVerified = e.Verified
+		}
+	}
+
+	names := strings.SplitN(strings.TrimSpace(gu.Name), " ", 2)
+	id.GivenName = names[0]
+	if len(names) == 2 {
+		id.FamilyName = names[1]
+	}
+
+	return id, nil
+}
+
+//NewPKCE returns a PKCE code verifier and its S256 challenge
+func NewPKCE() (string, string, error) {
+	verifier, err := RandomString(32)
+	if err != nil {
+		return "", "", err
+	}
+	sum := sha256.Sum256([]byte(verifier))
+	return verifier, base64.RawURLEncoding.EncodeToString(sum[:]), nil
+}
+
+//RandomString returns n random bytes base64url encoded, used for state and
+//nonce values
+func RandomString(n int) (string, error) {
+	b := make([]byte, n)
+	if _, err := rand.Read(b); err != nil {
+		return "", err
+	}
+	return base64.RawURLEncoding.EncodeToString(b), nil
+}
+
+func (p *Provider) kind() string {
+	if p.Kind == "" {
+		return KindOIDC
+	}
+	return p.Kind
+}
+
+func (p *Provider) scopes() []string {
+	if len(p.Scopes) > 0 {
+		return p.Scopes
+	}
+	if p.kind() == KindGitHub {
+		return []string{"read:user", "user:email"}
+	}
+	return []string{"openid", "email", "profile"}
+}
+
+func (p *Provider) setDefaults(auth, token, userInfo, jwks string) {
+	if p.AuthURL == "" {
+		p.AuthURL = auth
+	}
+	if p.TokenURL == "" {
+		p.TokenURL = token
+	}
+	if p.UserInfoURL == "" {
+		p.UserInfoURL = userInfo
+	}
+	if p.JWKSURL == "" {
+		p.JWKSURL = jwks
+	}
+}
+
+//getJSON performs a GET request and decodes the JSON response
+func getJSON(ctx context.Context, client *http.Client, url, accessToken string,
+	out interface{}) error {
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, url, nil)
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Accept", "application/json")
+	if accessToken != "" {
+		req.Header.Set("Authorization", "Bearer "+accessToken)
+	}
+
+	resp, err := client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("GET %s: %s", url, resp.Status)
+	}
+
+	return json.NewDecoder(resp.Body).Decode(out)
+}
@@ -0,0 +1,132 @@
+package oidc
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strconv"
+	"time"
+
+	"github.com/rs/zerolog/log"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/awserr"
+	"github.com/aws/aws-sdk-go/service/dynamodb"
+	"github.com/aws/aws-sdk-go/service/dynamodb/dynamodbattribute"
+	"github.com/aws/aws-sdk-go/service/dynamodb/dynamodbiface"
+)
+
+const (
+	//DynamoDBPrefixState Prefix added to the primary key of the state row
+	DynamoDBPrefixState = "OIDC"
+
+	//DynamoDBTypeState identifies the authorization state rows in dynamoDB
+	DynamoDBTypeState = "OIDCState"
+
+	//ErrorInvalidState Returned when the state is unknown, expired or was
+	//issued for another provider
+	ErrorInvalidState = "InvalidState"
+)
+
+//State is stored between the authorize redirect and the callback
+type State struct {
+	State    string `json:"state"`
+	Provider string `json:"provider"`
+	Nonce    string `json:"nonce"`
+	Verifier string `json:"verifier"`
+}
+
+//NewState generates the state, nonce and PKCE verifier for the provider
+func NewState(provider string) (*State, string, error) {
+	state, err := RandomString(32)
+	if err != nil {
+		return nil, "", err
+	}
+	nonce, err := RandomString(32)
+	if err != nil {
+		return nil, "", err
+	}
+	verifier, challenge, err := NewPKCE()
+	if err != nil {
+		return nil, "", err
+	}
+
+	return &State{
+		State:    state,
+		Provider: provider,
+		Nonce:    nonce,
+		Verifier: verifier,
+	}, challenge, nil
+}
+
+//Save stores the state, which expires after ttl
+func (s *State) Save(ctx context.Context, svc dynamodbiface.DynamoDBAPI,
+	tableName string, ttl time.Duration) error {
+
+	log.Debug().Msgf("Saving OIDC state for provider: %s", s.Provider)
+
+	_, err := svc.PutItemWithContext(ctx, &dynamodb.PutItemInput{
+		TableName: aws.String(tableName),
+		Item: map[string]*dynamodb.AttributeValue{
+			"pk":       {S: aws.String(getStatePK(s.State))},
+			"sk":       {S: aws.String(getStateSK())},
+			"state":    {S: aws.String(s.State)},
+			"provider": {S: aws.String(s.Provider)},
+			"nonce":    {S: aws.String(s.Nonce)},
+			"verifier": {S: aws.String(s.Verifier)},
+			"ttl":      {N: aws.String(strconv.FormatInt(time.Now().Add(ttl).Unix(), 10))},
+			"type":     {S: aws.String(DynamoDBTypeState)},
+		},
+		ConditionExpression: aws.String("attribute_not_exists(pk) and attribute_not_exists(sk)"),
+	})
+
+	return err
+}
+
+//ConsumeState deletes the state row and returns it. A state can only be used
+//once
+func ConsumeState(ctx context.Context, svc dynamodbiface.DynamoDBAPI,
+	tableName, state, provider string) (*State, error) {
+
+	log.Debug().Msgf("Consuming OIDC state for provider: %s", provider)
+
+	result, err := svc.DeleteItemWithContext(ctx, &dynamodb.DeleteItemInput{
+		TableName: aws.String(tableName),
+		Key: map[string]*dynamodb.AttributeValue{
+			"pk": {S: aws.String(getStatePK(state))},
+			"sk": {S: aws.String(getStateSK())},
+		},
+		ExpressionAttributeNames: map[string]*string{
+			"#P": aws.String("provider"),
+			"#T": aws.String("ttl"),
+		},
+		ExpressionAttributeValues: map[string]*dynamodb.AttributeValue{
+			":provider": {S: aws.String(provider)},
+			":now":      {N: aws.String(strconv.FormatInt(time.Now().Unix(), 10))},
+		},
+		ConditionExpression: aws.String("#P = :provider AND #T > :now"),
+		ReturnValues:        aws.String(dynamodb.ReturnValueAllOld),
+	})
+	if err != nil {
+		if aerr, ok := err.(awserr.Error); ok &&
+			aerr.Code() == dynamodb.ErrCodeConditionalCheckFailedException {
+			return nil, errors.New(ErrorInvalidState)
+		}
+		return nil, err
+	}
+
+	var s State
+	if err := dynamodbattribute.UnmarshalMap(result.Attributes, &s); err != nil {
+		return nil, err
+	}
+
+	return &s, nil
+}
+
+func getStatePK(state string) string {
+	return fmt.Sprintf("%s#%s", DynamoDBPrefixState, state)
+}
+
+func getStateSK() string {
+	return "STATE#"
+}
@@ -0,0 +1,290 @@
+//Package scim maps SCIM 2.0 (RFC 7643, RFC 7644) user resources onto the user
+//store, so identity providers can provision users
+package scim
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"regexp"
+	"strings"
+
+	"github.com/roloum/users/internal/apperr"
+	"github.com/roloum/users/internal/user"
+)
+
+const (
+	//SchemaUser core user schema
+	SchemaUser = "urn:ietf:params:scim:schemas:core:2.0:User"
+
+	//SchemaListResponse list response message
+	SchemaListResponse = "urn:ietf:params:scim:api:messages:2.0:ListResponse"
+
+	//SchemaError error message
+	SchemaError = "urn:ietf:params:scim:api:messages:2.0:Error"
+
+	//SchemaPatchOp patch operation message
+	SchemaPatchOp = "urn:ietf:params:scim:api:messages:2.0:PatchOp"
+
+	//ErrorInvalidFilter Returned for filters other than userName eq "..."
+	ErrorInvalidFilter = "invalidFilter"
+
+	//ErrorInvalidPath Returned when a patch operation targets an unknown or
+	//immutable attribute
+	ErrorInvalidPath = "invalidPath"
+
+	//ErrorInvalidValue Returned when a patch value can not be decoded
+	ErrorInvalidValue = "invalidValue"
+)
+
+//Name is the components of the user's name
+type Name struct {
+	Formatted  string `json:"formatted,omitempty"`
+	GivenName  string `json:"givenName,omitempty"`
+	FamilyName string `json:"familyName,omitempty"`
+}
+
+//Email is an email address of the user
+type Email struct {
+	Value   string `json:"value"`
+	Type    string `json:"type,omitempty"`
+	Primary bool   `json:"primary,omitempty"`
+}
+
+//Meta is the resource metadata
+type Meta struct {
+	ResourceType string `json:"resourceType"`
+	Created      string `json:"created,omitempty"`
+	Location     string `json:"location,omitempty"`
+}
+
+//User is the SCIM user resource
+type User struct {
+	Schemas    []string `json:"schemas"`
+	ID         string   `json:"id,omitempty"`
+	ExternalID string   `json:"externalId,omitempty"`
+	UserName   string   `json:"userName"`
+	Name       Name     `json:"name"`
+	Emails     []Email  `json:"emails,omitempty"`
+	Active     *bool    `json:"active,omitempty"`
+	Meta       *Meta    `json:"meta,omitempty"`
+}
+
+//ListResponse is the response of a query
+type ListResponse struct {
+	Schemas      []string `json:"schemas"`
+	TotalResults int      `json:"totalResults"`
+	StartIndex   int      `json:"startIndex"`
+	ItemsPerPage int      `json:"itemsPerPage"`
+	Resources    []*User  `json:"Resources"`
+}
+
+//Error is the SCIM error response
+type Error struct {
+	Schemas  []string `json:"schemas"`
+	Status   string   `json:"status"`
+	ScimType string   `json:"scimType,omitempty"`
+	Detail   string   `json:"detail,omitempty"`
+}
+
+//Operation is a single patch operation
+type Operation struct {
+	Op    string          `json:"op"`
+	Path  string          `json:"path,omitempty"`
+	Value json.RawMessage `json:"value,omitempty"`
+}
+
+//PatchOp is the body of a PATCH request
+type PatchOp struct {
+	Schemas    []string    `json:"schemas"`
+	Operations []Operation `json:"Operations"`
+}
+
+//NewUserResource maps an user to the SCIM resource. The userName is the email
+func NewUserResource(u *user.User, baseURL string) *User {
+	active := u.Active
+	return &User{
+		Schemas:  []string{SchemaUser},
+		ID:       u.ID,
+		UserName: u.Email,
+		Name: Name{
+			Formatted:  strings.TrimSpace(u.FirstName + " " + u.LastName),
+			GivenName:  u.FirstName,
+			FamilyName: u.LastName,
+		},
+		Emails: []Email{{Value: u.Email, Type: "work", Primary: true}},
+		Active: &active,
+		Meta: &Meta{
+			ResourceType: "User",
+			Created:      u.Created,
+			Location:     fmt.Sprintf("%s/Users/%s", baseURL, u.ID),
+		},
+	}
+}
+
+//NewUser maps the resource to the fields validated by user.Create
+func (r *User) NewUser() *user.NewUser {
+	email := r.UserName
+	for _, e := range r.Emails {
+		if e.Primary && email == "" {
+			email = e.Value
+		}
+	}
+
+	return &user.NewUser{
+		Email:     strings.ToLower(email),
+		FirstName: r.Name.GivenName,
+		LastName:  r.Name.FamilyName,
+	}
+}
+
+//NewListResponse returns the page of resources starting at startIndex, which
+//is 1-based as mandated by SCIM, out of total results
+func NewListResponse(resources []*User, total, startIndex int) *ListResponse {
+	if startIndex < 1 {
+		startIndex = 1
+	}
+
+	if resources == nil {
+		resources = []*User{}
+	}
+
+	return &ListResponse{
+		Schemas:      []string{SchemaListResponse},
+		TotalResults: total,
+		StartIndex:   startIndex,
+		ItemsPerPage: len(resources),
+		Resources:    resources,
+	}
+}
+
+//NewError returns the SCIM error response
+func NewError(status int, scimType, detail string) *Error {
+	return &Error{
+		Schemas:  []string{SchemaError},
+		Status:   fmt.Sprintf("%d", status),
+		ScimType: scimType,
+		Detail:   detail,
+	}
+}
+
+var filterRegexp = regexp.MustCompile(`(?i)^\s*userName\s+eq\s+"([^"]*)"\s*$`)
+
+//ParseFilter returns the userName of a `userName eq "..."` filter, the only
+//filter identity providers need to match existing users
+func ParseFilter(filter string) (string, error) {
+	m := filterRegexp.FindStringSubmatch(filter)
+	if m == nil {
+		return "", errors.New(ErrorInvalidFilter)
+	}
+	return strings.ToLower(m[1]), nil
+}
+
+//Apply applies the patch operations to the user. Only the name and the
+//active flag can be modified, the userName is the key of the user
+func (p *PatchOp) Apply(u *user.User) error {
+	for _, op := range p.Operations {
+		switch strings.ToLower(op.Op) {
+		case "add", "replace":
+		default:
+			return errors.New(ErrorInvalidPath)
+		}
+
+		if op.Path == "" {
+			var v struct {
+				Active     *bool  `json:"active"`
+				Name       *Name  `json:"name"`
+				GivenName  string `json:"name.givenName"`
+				FamilyName string `json:"name.familyName"`
+			}
+			if err := json.Unmarshal(op.Value, &v); err != nil {
+				return errors.New(ErrorInvalidValue)
+			}
+			if v.Active != nil {
+				u.Active = *v.Active
+			}
+			if v.Name != nil {
+				setName(u, v.Name.GivenName, v.Name.FamilyName)
+			}
+			setName(u, v.GivenName, v.FamilyName)
+			continue
+		}
+
+		switch strings.ToLower(op.Path) {
+		case "active":
+			active, err := parseBool(op.Value)
+			if err != nil {
+				return err
+			}
+			u.Active = active
+		case "name.givenname", "name.familyname":
+			var s string
+			if err := json.Unmarshal(op.Value, &s); err != nil {
+				return errors.New(ErrorInvalidValue)
+			}
+			if strings.ToLower(op.Path) == "name.givenname" {
+				u.FirstName = s
+			} else {
+				u.LastName = s
+			}
+		case "name":
+			var n Name
+			if err := json.Unmarshal(op.Value, &n); err != nil {
+				return errors.New(ErrorInvalidValue)
+			}
+			setName(u, n.GivenName, n.FamilyName)
+		default:
+			return errors.New(ErrorInvalidPath)
+		}
+	}
+
+	return nil
+}
+
+//parseBool accepts booleans and the "True"/"False" strings sent by Azure AD
+func parseBool(value json.RawMessage) (bool, error) {
+	var b bool
+	if err := json.Unmarshal(value, &b); err == nil {
+		return b, nil
+	}
+	var s string
+	if err := json.Unmarshal(value, &s); err == nil {
+		switch strings.ToLower(s) {
+		case "true":
+			return true, nil
+		case "false":
+			return false, nil
+		}
+	}
+	return false, errors.New(ErrorInvalidValue)
+}
+
+func setName(u *user.User, givenName, familyName string) {
+	if givenName != "" {
+		u.FirstName = givenName
+	}
+	if familyName != "" {
+		u.LastName = familyName
+	}
+}
+
+//StatusFromError maps the user errors to the HTTP status and scimType. SCIM
+//reports invalid values with 400, the other errors keep their apperr status
+func StatusFromError(err error) (int, string) {
+	switch err.Error() {
+	case user.ErrorDuplicateUser:
+		return http.StatusConflict, "uniqueness"
+	case user.ErrorUserDoesNotExist:
+		return http.StatusNotFound, ""
+	case user.ErrorFirstNameIsEmpty, user.ErrorLastNameIsEmpty,
+		user.ErrorEmailIsEmpty, user.ErrorInvalidEmail, ErrorInvalidValue,
+		apperr.ErrorValidation:
+		return http.StatusBadRequest, "invalidValue"
+	case ErrorInvalidFilter:
+		return http.StatusBadRequest, ErrorInvalidFilter
+	case ErrorInvalidPath:
+		return http.StatusBadRequest, ErrorInvalidPath
+	}
+	return apperr.From(err).Status, ""
+}
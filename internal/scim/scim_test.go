@@ -0,0 +1,111 @@
+package scim
+
+import (
+	"encoding/json"
+	"errors"
+	"reflect"
+	"testing"
+
+	"github.com/roloum/users/internal/user"
+)
+
+//TestParseFilter Tests the supported filter
+func TestParseFilter(t *testing.T) {
+
+	tests := []struct {
+		desc   string
+		filter string
+		email  string
+		err    error
+	}{
+		{desc: "userNameEq", filter: `userName eq "Test@User.com"`,
+			email: "test@user.com"},
+		{desc: "caseInsensitive", filter: `USERNAME Eq "test@user.com"`,
+			email: "test@user.com"},
+		{desc: "otherAttribute", filter: `emails eq "test@user.com"`,
+			err: errors.New(ErrorInvalidFilter)},
+		{desc: "otherOperator", filter: `userName sw "test"`,
+			err: errors.New(ErrorInvalidFilter)},
+	}
+
+	for _, tc := range tests {
+		t.Run(tc.desc, func(t *testing.T) {
+			email, err := ParseFilter(tc.filter)
+			if !reflect.DeepEqual(err, tc.err) {
+				t.Errorf("Expected: %v. Received: %v", tc.err, err)
+			}
+			if email != tc.email {
+				t.Errorf("Expected: %v. Received: %v", tc.email, email)
+			}
+		})
+	}
+}
+
+//TestApply Tests the patch operations sent by Okta and Azure AD
+func TestApply(t *testing.T) {
+
+	tests := []struct {
+		desc string
+		body string
+		user user.User
+		err  error
+	}{
+		{
+			desc: "OktaDeactivate",
+			body: `{"Operations":[{"op":"replace","value":{"active":false}}]}`,
+			user: user.User{FirstName: "Test", LastName: "User", Active: false},
+		},
+		{
+			desc: "AzureReplace",
+			body: `{"Operations":[{"op":"Replace","path":"active","value":"False"},
+				{"op":"Replace","path":"name.givenName","value":"New"}]}`,
+			user: user.User{FirstName: "New", LastName: "User", Active: false},
+		},
+		{
+			desc: "Name",
+			body: `{"Operations":[{"op":"replace","path":"name",
+				"value":{"familyName":"Name"}}]}`,
+			user: user.User{FirstName: "Test", LastName: "Name", Active: true},
+		},
+		{
+			desc: "UserName",
+			body: `{"Operations":[{"op":"replace","path":"userName","value":"x"}]}`,
+			user: user.User{FirstName: "Test", LastName: "User", Active: true},
+			err:  errors.New(ErrorInvalidPath),
+		},
+	}
+
+	for _, tc := range tests {
+		t.Run(tc.desc, func(t *testing.T) {
+			var op PatchOp
+			if err := json.Unmarshal([]byte(tc.body), &op); err != nil {
+				t.Fatalf("Unexpected error: %v", err)
+			}
+
+			u := user.User{FirstName: "Test", LastName: "User", Active: true}
+			err := op.Apply(&u)
+			if !reflect.DeepEqual(err, tc.err) {
+				t.Errorf("Expected: %v. Received: %v", tc.err, err)
+			}
+			if !reflect.DeepEqual(u, tc.user) {
+				t.Errorf("Expected: %+v. Received: %+v", tc.user, u)
+			}
+		})
+	}
+}
+
+//TestNewListResponse Tests the 1-based pagination
+func TestNewListResponse(t *testing.T) {
+
+	r := NewListResponse([]*User{{ID: "2"}}, 3, 2)
+	if r.TotalResults != 3 || r.StartIndex != 2 || r.ItemsPerPage != 1 ||
+		r.Resources[0].ID != "2" {
+		t.Errorf("Unexpected response: %+v", r)
+	}
+
+	r = NewListResponse(nil, 3, 0)
+	if r.TotalResults != 3 || r.StartIndex != 1 || r.ItemsPerPage != 0 ||
+		r.Resources == nil {
+		t.Errorf("Unexpected response: %+v", r)
+	}
+}
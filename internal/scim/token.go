@@ -0,0 +1,122 @@
+package scim
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/rs/zerolog/log"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/dynamodb"
+	"github.com/aws/aws-sdk-go/service/dynamodb/dynamodbiface"
+
+	"github.com/roloum/users/internal/apperr"
+)
+
+const (
+	//DynamoDBPrefixTenant Prefix added to the primary key of tenant rows
+	DynamoDBPrefixTenant = "TENANT"
+
+	//DynamoDBPrefixToken Prefix added to the sort key of a SCIM token row
+	DynamoDBPrefixToken = "SCIMTOKEN"
+
+	//DynamoDBTypeToken identifies the SCIM token rows in dynamoDB
+	DynamoDBTypeToken = "SCIMToken"
+
+	//DynamoDBIndexInverted Global secondary index with sk as partition key
+	DynamoDBIndexInverted = "InvertedIndex"
+
+	//ErrorTenantIsEmpty Returned when creating a token without tenant
+	ErrorTenantIsEmpty = "TenantIsEmpty"
+
+	//ErrorInvalidToken Returned when the bearer token is unknown
+	ErrorInvalidToken = "InvalidToken"
+)
+
+//init registers the HTTP status of the errors of the package
+func init() {
+	apperr.Register(http.StatusUnprocessableEntity, ErrorTenantIsEmpty)
+	apperr.Register(http.StatusUnauthorized, ErrorInvalidToken)
+}
+
+//CreateToken creates a bearer token for the tenant's identity provider. Only a
+//hash is stored, the token is returned once
+func CreateToken(ctx context.Context, svc dynamodbiface.DynamoDBAPI,
+	tableName, tenant string) (string, error) {
+
+	log.Info().Msgf("Creating SCIM token for tenant: %s", tenant)
+
+	if tenant == "" {
+		return "", errors.New(ErrorTenantIsEmpty)
+	}
+
+	b := make([]byte, 32)
+	if _, err := rand.Read(b); err != nil {
+		return "", err
+	}
+	token := base64.RawURLEncoding.EncodeToString(b)
+
+	_, err := svc.PutItemWithContext(ctx, &dynamodb.PutItemInput{
+		TableName: aws.String(tableName),
+		Item: map[string]*dynamodb.AttributeValue{
+			"pk":      {S: aws.String(getTenantPK(tenant))},
+			"sk":      {S: aws.String(getTokenSK(token))},
+			"tenant":  {S: aws.String(tenant)},
+			"created": {S: aws.String(time.Now().Format("2006-01-02"))},
+			"type":    {S: aws.String(DynamoDBTypeToken)},
+		},
+		ConditionExpression: aws.String("attribute_not_exists(pk) and attribute_not_exists(sk)"),
+	})
+	if err != nil {
+		return "", err
+	}
+
+	return token, nil
+}
+
+//Authenticate returns the tenant owning the bearer token
+func Authenticate(ctx context.Context, svc dynamodbiface.DynamoDBAPI,
+	tableName, token string) (string, error) {
+
+	if token == "" {
+		return "", errors.New(ErrorInvalidToken)
+	}
+
+	result, err := svc.QueryWithContext(ctx, &dynamodb.QueryInput{
+		TableName:              aws.String(tableName),
+		IndexName:              aws.String(DynamoDBIndexInverted),
+		KeyConditionExpression: aws.String("sk = :sk"),
+		ExpressionAttributeValues: map[string]*dynamodb.AttributeValue{
+			":sk": {S: aws.String(getTokenSK(token))},
+		},
+		Limit: aws.Int64(1),
+	})
+	if err != nil {
+		return "", err
+	}
+
+	if len(result.Items) == 0 {
+		return "", errors.New(ErrorInvalidToken)
+	}
+
+	pk := aws.StringValue(result.Items[0]["pk"].S)
+
+	return strings.TrimPrefix(pk, DynamoDBPrefixTenant+"#"), nil
+}
+
+func getTenantPK(tenant string) string {
+	return fmt.Sprintf("%s#%s", DynamoDBPrefixTenant, tenant)
+}
+
+func getTokenSK(token string) string {
+	sum := sha256.Sum256([]byte(token))
+	return fmt.Sprintf("%s#%s", DynamoDBPrefixToken, hex.EncodeToString(sum[:]))
+}
@@ -0,0 +1,71 @@
+//Package seal encrypts small secrets before they are stored in DynamoDB
+package seal
+
+import (
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"encoding/base64"
+	"errors"
+)
+
+const (
+	//ErrorInvalidKey Returned when the key is not a base64 encoded 32 byte key
+	ErrorInvalidKey = "InvalidEncryptionKey"
+
+	//ErrorInvalidCiphertext Returned when the sealed value can not be opened
+	ErrorInvalidCiphertext = "InvalidCiphertext"
+)
+
+//Seal encrypts plaintext with AES-256-GCM using the base64 encoded key and
+//returns the nonce and ciphertext base64 encoded
+func Seal(key string, plaintext []byte) (string, error) {
+	gcm, err := newGCM(key)
+	if err != nil {
+		return "", err
+	}
+
+	nonce := make([]byte, gcm.NonceSize())
+	if _, err := rand.Read(nonce); err != nil {
+		return "", err
+	}
+
+	sealed := gcm.Seal(nonce, nonce, plaintext, nil)
+
+	return base64.StdEncoding.EncodeToString(sealed), nil
+}
+
+//Open decrypts a value returned by Seal
+func Open(key, sealed string) ([]byte, error) {
+	gcm, err := newGCM(key)
+	if err != nil {
+		return nil, err
+	}
+
+	data, err := base64.StdEncoding.DecodeString(sealed)
+	if err != nil || len(data) < gcm.NonceSize() {
+		return nil, errors.New(ErrorInvalidCiphertext)
+	}
+
+	plaintext, err := gcm.Open(nil, data[:gcm.NonceSize()],
+		data[gcm.NonceSize():], nil)
+	if err != nil {
+		return nil, errors.New(ErrorInvalidCiphertext)
+	}
+
+	return plaintext, nil
+}
+
+func newGCM(key string) (cipher.AEAD, error) {
+	k, err := base64.StdEncoding.DecodeString(key)
+	if err != nil || len(k) != 32 {
+		return nil, errors.New(ErrorInvalidKey)
+	}
+
+	block, err := aes.NewCipher(k)
+	if err != nil {
+		return nil, err
+	}
+
+	return cipher.NewGCM(block)
+}
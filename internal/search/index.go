@@ -0,0 +1,680 @@
+package search
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/rs/zerolog/log"
+
+	"github.com/aws/aws-lambda-go/events"
+	"github.com/aws/aws-sdk-go/service/dynamodb"
+	"github.com/aws/aws-sdk-go/service/dynamodb/dynamodbattribute"
+	"github.com/aws/aws-sdk-go/service/dynamodb/dynamodbiface"
+	"github.com/blevesearch/bleve/v2"
+	"github.com/blevesearch/bleve/v2/analysis/analyzer/keyword"
+	"github.com/blevesearch/bleve/v2/analysis/analyzer/simple"
+	"github.com/blevesearch/bleve/v2/mapping"
+	"github.com/blevesearch/bleve/v2/search/query"
+
+	uaws "github.com/roloum/users/internal/aws"
+	"github.com/roloum/users/internal/table"
+	"github.com/roloum/users/internal/user"
+)
+
+const (
+	//BackendBleve searches the Bleve index fed from the DynamoDB stream
+	BackendBleve = "bleve"
+
+	//FacetActive facet counting active and inactive users
+	FacetActive = "active"
+
+	//FacetCreatedMonth facet counting users by month of creation, 2006-01
+	FacetCreatedMonth = "createdMonth"
+
+	//FacetDomain facet counting users by email domain
+	FacetDomain = "domain"
+
+	//DefaultFuzziness edit distance allowed between a name term and the name
+	DefaultFuzziness = 1
+
+	//facetSize number of terms returned by each facet
+	facetSize = 10
+
+	//batchSize number of users written to the index at once by Load
+	batchSize = 500
+
+	//ErrorIndexPathIsEmpty Returned when the index needs a path on disk
+	ErrorIndexPathIsEmpty = "SearchIndexPathIsEmpty"
+
+	//journalSuffix names the journal of the stream records applied while
+	//the index is rebuilt, next to the index
+	journalSuffix = ".journal"
+)
+
+//Index is a Bleve index of the user profiles. The document id is the email
+type Index struct {
+	index bleve.Index
+}
+
+//Query is a full-text query of the index. Text is made of terms, matched as
+//in user.Search, but names also match with Fuzziness typos. The filters and
+//the terms must all match
+type Query struct {
+	Text         string
+	Fuzziness    int
+	Active       *bool
+	Domain       string
+	CreatedMonth string
+	Limit        int
+	Offset       int
+}
+
+//Facet is the number of users matching the query with a value of the field
+type Facet struct {
+	Term  string `json:"term"`
+	Count int    `json:"count"`
+}
+
+//Result holds a page of users and the facets of every match
+type Result struct {
+	Total  uint64             `json:"total"`
+	Users  []*user.User       `json:"users"`
+	Facets map[string][]Facet `json:"facets"`
+}
+
+//indexMapping maps the documents built by document. Only the fields needed to
+//rebuild the user are stored
+func indexMapping() mapping.IndexMapping {
+
+	keywordField := func(store bool) *mapping.FieldMapping {
+		f := bleve.NewTextFieldMapping()
+		f.Analyzer = keyword.Name
+		f.Store = store
+		f.IncludeInAll = false
+		f.IncludeTermVectors = false
+		return f
+	}
+
+	storedField := func() *mapping.FieldMapping {
+		f := bleve.NewTextFieldMapping()
+		f.Index = false
+		f.IncludeInAll = false
+		f.IncludeTermVectors = false
+		return f
+	}
+
+	name := bleve.NewTextFieldMapping()
+	name.Analyzer = simple.Name
+	name.Store = false
+	name.IncludeInAll = false
+
+	active := bleve.NewBooleanFieldMapping()
+	active.IncludeInAll = false
+
+	doc := bleve.NewDocumentStaticMapping()
+	doc.AddFieldMappingsAt("id", storedField())
+	doc.AddFieldMappingsAt("email", storedField())
+	doc.AddFieldMappingsAt("firstName", storedField())
+	doc.AddFieldMappingsAt("lastName", storedField())
+	doc.AddFieldMappingsAt("created", storedField())
+	doc.AddFieldMappingsAt("emailKey", keywordField(false))
+	doc.AddFieldMappingsAt(FacetDomain, keywordField(false))
+	doc.AddFieldMappingsAt(FacetCreatedMonth, keywordField(false))
+	doc.AddFieldMappingsAt(FacetActive, active)
+	doc.AddFieldMappingsAt("name", name)
+
+	m := bleve.NewIndexMapping()
+	m.DefaultMapping = doc
+	m.DefaultAnalyzer = simple.Name
+
+	return m
+}
+
+//document converts the user into the indexed document
+func document(u *user.User) map[string]interface{} {
+
+	month := u.Created
+	if len(month) > len("2006-01") {
+		month = month[:len("2006-01")]
+	}
+
+	return map[string]interface{}{
+		"id":              u.ID,
+		"email":           u.Email,
+		"firstName":       u.FirstName,
+		"lastName":        u.LastName,
+		"created":         u.Created,
+		"emailKey":        strings.ToLower(u.Email),
+		FacetDomain:       user.Domain(u.Email),
+		FacetCreatedMonth: month,
+		FacetActive:       u.Active,
+		"name":            u.FirstName + " " + u.LastName,
+	}
+}
+
+//NewMemoryIndex returns an index living in memory
+func NewMemoryIndex() (*Index, error) {
+
+	index, err := bleve.NewMemOnly(indexMapping())
+	if err != nil {
+		return nil, err
+	}
+
+	return &Index{index: index}, nil
+}
+
+//OpenIndex opens the index stored on disk at path, creating it when it does
+//not exist. Only one process can hold the index open for writing
+func OpenIndex(path string) (*Index, error) {
+
+	if path == "" {
+		return nil, errors.New(ErrorIndexPathIsEmpty)
+	}
+
+	log.Debug().Msgf("Opening search index: %s", path)
+
+	index, err := bleve.Open(path)
+	if err == bleve.ErrorIndexPathDoesNotExist {
+		log.Info().Msgf("Creating search index: %s", path)
+		index, err = bleve.New(path, indexMapping())
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	return &Index{index: index}, nil
+}
+
+//OpenIndexReadOnly opens the index stored on disk at path for searching, it
+//can be shared with the process updating it
+func OpenIndexReadOnly(path string) (*Index, error) {
+
+	if path == "" {
+		return nil, errors.New(ErrorIndexPathIsEmpty)
+	}
+
+	index, err := bleve.OpenUsing(path, map[string]interface{}{
+		"read_only":    true,
+		"bolt_timeout": "5s",
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return &Index{index: index}, nil
+}
+
+//Close closes the index
+func (ix *Index) Close() error {
+	return ix.index.Close()
+}
+
+//Count returns the number of users in the index
+func (ix *Index) Count() (uint64, error) {
+	return ix.index.DocCount()
+}
+
+//Put adds or replaces the user in the index
+func (ix *Index) Put(u *user.User) error {
+	return ix.index.Index(u.Email, document(u))
+}
+
+//Delete removes the user from the index
+func (ix *Index) Delete(email string) error {
+	return ix.index.Delete(email)
+}
+
+//Apply applies the changes of the profile rows of a DynamoDB stream batch:
+//INSERT and MODIFY index the new image, REMOVE deletes the user. Other rows
+//are ignored
+func (ix *Index) Apply(records []events.DynamoDBEventRecord) (int, error) {
+
+	batch := ix.index.NewBatch()
+
+	for _, r := range records {
+		if !user.IsUserProfileKeys(r.Change.Keys) {
+			continue
+		}
+
+		switch events.DynamoDBOperationType(r.EventName) {
+		case events.DynamoDBOperationTypeInsert,
+			events.DynamoDBOperationTypeModify:
+
+			var u user.User
+			if err := uaws.UnmarshalStreamImage(r.Change.NewImage, &u); err != nil {
+				return 0, err
+			}
+			if err := batch.Index(u.Email, document(&u)); err != nil {
+				return 0, err
+			}
+
+		case events.DynamoDBOperationTypeRemove:
+			//The documents are indexed by the email of the profile, which
+			//may differ from the canonical email of the key
+			if email, ok := r.Change.OldImage["email"]; ok {
+				batch.Delete(email.String())
+				continue
+			}
+			pk := r.Change.Keys["pk"].String()
+			batch.Delete(strings.TrimPrefix(pk, user.DynamoDBPrefixUser+"#"))
+		}
+	}
+
+	size := batch.Size()
+	if size == 0 {
+		return 0, nil
+	}
+
+	log.Debug().Msgf("Applying %d changes to the search index", size)
+
+	return size, ix.index.Batch(batch)
+}
+
+//Load indexes every user of the table with a scan, returning the number of
+//users indexed
+func (ix *Index) Load(ctx context.Context, svc dynamodbiface.DynamoDBAPI,
+	tableName string) (int, error) {
+
+	var count int
+	batch := ix.index.NewBatch()
+
+	err := table.ForEachUser(ctx, svc, tableName,
+		func(item map[string]*dynamodb.AttributeValue) error {
+			var u user.User
+			if err := dynamodbattribute.UnmarshalMap(item, &u); err != nil {
+				return err
+			}
+			if err := batch.Index(u.Email, document(&u)); err != nil {
+				return err
+			}
+			count++
+
+			if batch.Size() < batchSize {
+				return nil
+			}
+			if err := ix.index.Batch(batch); err != nil {
+				return err
+			}
+			batch.Reset()
+			return nil
+		})
+	if err != nil {
+		return count, err
+	}
+
+	if batch.Size() > 0 {
+		if err := ix.index.Batch(batch); err != nil {
+			return count, err
+		}
+	}
+
+	log.Info().Msgf("Indexed %d users", count)
+
+	return count, nil
+}
+
+//Rebuild replaces the index stored on disk at path with a new one loaded from
+//a scan of the table. The new index is built in a directory next to it, so
+//searches keep working, and path is swapped atomically to a link to it. The
+//stream records applied with ApplyTo during the rebuild are journaled and
+//replayed on the new index, before and right after the swap
+func Rebuild(ctx context.Context, svc dynamodbiface.DynamoDBAPI, tableName,
+	path string) (int, error) {
+
+	if path == "" {
+		return 0, errors.New(ErrorIndexPathIsEmpty)
+	}
+
+	journal := path + journalSuffix
+	f, err := os.Create(journal)
+	if err != nil {
+		return 0, err
+	}
+	f.Close()
+	defer os.Remove(journal)
+
+	dir := fmt.Sprintf("%s.%d", path, time.Now().UnixNano())
+
+	ix, err := OpenIndex(dir)
+	if err != nil {
+		return 0, err
+	}
+
+	var offset int64
+	count, err := ix.Load(ctx, svc, tableName)
+	if err == nil {
+		offset, err = replayJournal(ix, journal, 0)
+	}
+	if cerr := ix.Close(); err == nil {
+		err = cerr
+	}
+	if err != nil {
+		os.RemoveAll(dir)
+		return count, err
+	}
+
+	old, err := swap(path, dir)
+	if err != nil {
+		os.RemoveAll(dir)
+		return count, err
+	}
+
+	//The records journaled since the replay are applied holding the new
+	//index, ApplyTo applies them again when they went to the old one
+	ix, err = OpenIndex(dir)
+	if err != nil {
+		return count, err
+	}
+	_, err = replayJournal(ix, journal, offset)
+	if err == nil {
+		err = os.Remove(journal)
+	}
+	if cerr := ix.Close(); err == nil {
+		err = cerr
+	}
+	if err != nil {
+		return count, err
+	}
+
+	if old != "" {
+		if err := os.RemoveAll(old); err != nil {
+			log.Warn().Err(err).Msgf("Removing previous search index: %s", old)
+		}
+	}
+
+	return count, nil
+}
+
+//swap points path to dir with a rename of a link, which is atomic, and
+//returns the directory of the previous index
+func swap(path, dir string) (string, error) {
+
+	link := path + ".link"
+	if err := os.Remove(link); err != nil && !os.IsNotExist(err) {
+		return "", err
+	}
+
+	//The link is relative, so it holds wherever the file system is mounted
+	if err := os.Symlink(filepath.Base(dir), link); err != nil {
+		return "", err
+	}
+
+	var old string
+	fi, err := os.Lstat(path)
+	switch {
+	case os.IsNotExist(err):
+	case err != nil:
+		return "", err
+	case fi.Mode()&os.ModeSymlink != 0:
+		old, _ = filepath.EvalSymlinks(path)
+	default:
+		//An index built before the swaps is a directory, which is moved aside
+		//once. Until the rename of the link, path does not exist
+		old = fmt.Sprintf("%s.%d", path, time.Now().UnixNano())
+		if err := os.Rename(path, old); err != nil {
+			return "", err
+		}
+	}
+
+	log.Info().Msgf("Swapping search index %s to %s", path, dir)
+
+	return old, os.Rename(link, path)
+}
+
+//ApplyTo applies the stream records to the index stored on disk at path.
+//While a Rebuild runs the records are also journaled for the new index, and
+//they are applied again if it was swapped in while they were applied
+func ApplyTo(path string, records []events.DynamoDBEventRecord) (int, error) {
+
+	if path == "" {
+		return 0, errors.New(ErrorIndexPathIsEmpty)
+	}
+
+	dir, err := resolve(path)
+	if err != nil {
+		return 0, err
+	}
+
+	count, err := applyAt(dir, path+journalSuffix, records)
+	if err != nil {
+		return count, err
+	}
+
+	current, err := resolve(path)
+	if err != nil || current == dir {
+		return count, err
+	}
+
+	log.Info().Msgf("Search index swapped to %s, applying the records again",
+		current)
+
+	return applyAt(current, "", records)
+}
+
+//applyAt applies the records to the index in dir and, when there is one,
+//appends them to the journal of a rebuild
+func applyAt(dir, journal string, records []events.DynamoDBEventRecord) (int,
+	error) {
+
+	ix, err := OpenIndex(dir)
+	if err != nil {
+		return 0, err
+	}
+	defer ix.Close()
+
+	count, err := ix.Apply(records)
+	if err != nil || journal == "" {
+		return count, err
+	}
+
+	f, err := os.OpenFile(journal, os.O_WRONLY|os.O_APPEND, 0)
+	if os.IsNotExist(err) {
+		return count, nil
+	}
+	if err != nil {
+		return count, err
+	}
+	defer f.Close()
+
+	enc := json.NewEncoder(f)
+	for _, r := range records {
+		if err := enc.Encode(r); err != nil {
+			return count, err
+		}
+	}
+
+	return count, nil
+}
+
+//replayJournal applies the records of the journal written from offset and
+//returns the offset of its end. A record being written is left for the next
+//replay
+func replayJournal(ix *Index, journal string, offset int64) (int64, error) {
+
+	f, err := os.Open(journal)
+	if err != nil {
+		return offset, err
+	}
+	defer f.Close()
+
+	if _, err := f.Seek(offset, io.SeekStart); err != nil {
+		return offset, err
+	}
+
+	var records []events.DynamoDBEventRecord
+	dec := json.NewDecoder(f)
+	end := offset
+	for {
+		var r events.DynamoDBEventRecord
+		err := dec.Decode(&r)
+		if err == io.EOF || err == io.ErrUnexpectedEOF {
+			break
+		}
+		if err != nil {
+			return offset, err
+		}
+		records = append(records, r)
+		end = offset + dec.InputOffset()
+	}
+
+	if len(records) == 0 {
+		return end, nil
+	}
+
+	log.Info().Msgf("Replaying %d journaled records", len(records))
+
+	if _, err := ix.Apply(records); err != nil {
+		return offset, err
+	}
+
+	return end, nil
+}
+
+//resolve returns the directory of the index at path, path itself before the
+//index exists or when it is not a link
+func resolve(path string) (string, error) {
+
+	dir, err := filepath.EvalSymlinks(path)
+	if os.IsNotExist(err) {
+		return path, nil
+	}
+
+	return dir, err
+}
+
+//Query runs the query, returning a page of users and the facets of every
+//match
+func (ix *Index) Query(ctx context.Context, q Query) (*Result, error) {
+
+	limit := q.Limit
+	if limit <= 0 {
+		limit = user.SearchDefaultLimit
+	}
+	if limit > user.SearchMaxLimit {
+		limit = user.SearchMaxLimit
+	}
+
+	bq, err := q.build()
+	if err != nil {
+		return nil, err
+	}
+
+	req := bleve.NewSearchRequestOptions(bq, limit, q.Offset, false)
+	req.Fields = []string{"id", "email", "firstName", "lastName", "created",
+		FacetActive}
+	req.SortBy([]string{"-_score", "_id"})
+	for _, f := range []string{FacetActive, FacetCreatedMonth, FacetDomain} {
+		req.AddFacet(f, bleve.NewFacetRequest(f, facetSize))
+	}
+
+	res, err := ix.index.SearchInContext(ctx, req)
+	if err != nil {
+		return nil, err
+	}
+
+	result := &Result{
+		Total:  res.Total,
+		Users:  make([]*user.User, 0, len(res.Hits)),
+		Facets: make(map[string][]Facet, len(res.Facets)),
+	}
+
+	for _, hit := range res.Hits {
+		u := &user.User{}
+		u.ID, _ = hit.Fields["id"].(string)
+		u.Email, _ = hit.Fields["email"].(string)
+		u.FirstName, _ = hit.Fields["firstName"].(string)
+		u.LastName, _ = hit.Fields["lastName"].(string)
+		u.Created, _ = hit.Fields["created"].(string)
+		u.Active, _ = hit.Fields[FacetActive].(bool)
+		result.Users = append(result.Users, u)
+	}
+
+	for name, f := range res.Facets {
+		facets := make([]Facet, 0, len(f.Terms))
+		for _, t := range f.Terms {
+			facets = append(facets, Facet{Term: t.Term, Count: t.Count})
+		}
+		result.Facets[name] = facets
+	}
+
+	return result, nil
+}
+
+//Search implements Backend with the default fuzziness and no facets
+func (ix *Index) Search(ctx context.Context, text string, limit int) (
+	[]*user.User, error) {
+
+	res, err := ix.Query(ctx, Query{Text: text, Fuzziness: DefaultFuzziness,
+		Limit: limit})
+	if err != nil {
+		return nil, err
+	}
+
+	return res.Users, nil
+}
+
+//build returns the Bleve query of the terms and filters
+func (q Query) build() (query.Query, error) {
+
+	var conjuncts []query.Query
+
+	for _, term := range strings.Fields(strings.ToLower(q.Text)) {
+		switch {
+		case strings.HasPrefix(term, "@"):
+			tq := bleve.NewTermQuery(strings.TrimPrefix(term, "@"))
+			tq.SetField(FacetDomain)
+			conjuncts = append(conjuncts, tq)
+
+		case strings.Contains(term, "@"):
+			pq := bleve.NewPrefixQuery(term)
+			pq.SetField("emailKey")
+			conjuncts = append(conjuncts, pq)
+
+		default:
+			if len([]rune(term)) < 2 {
+				return nil, errors.New(user.ErrorSearchQueryTooShort)
+			}
+
+			mq := bleve.NewMatchQuery(term)
+			mq.SetField("name")
+			mq.SetFuzziness(q.Fuzziness)
+
+			np := bleve.NewPrefixQuery(term)
+			np.SetField("name")
+
+			ep := bleve.NewPrefixQuery(term)
+			ep.SetField("emailKey")
+
+			conjuncts = append(conjuncts, bleve.NewDisjunctionQuery(mq, np, ep))
+		}
+	}
+
+	if q.Active != nil {
+		bq := bleve.NewBoolFieldQuery(*q.Active)
+		bq.SetField(FacetActive)
+		conjuncts = append(conjuncts, bq)
+	}
+	if q.Domain != "" {
+		tq := bleve.NewTermQuery(strings.ToLower(strings.TrimPrefix(q.Domain, "@")))
+		tq.SetField(FacetDomain)
+		conjuncts = append(conjuncts, tq)
+	}
+	if q.CreatedMonth != "" {
+		tq := bleve.NewTermQuery(q.CreatedMonth)
+		tq.SetField(FacetCreatedMonth)
+		conjuncts = append(conjuncts, tq)
+	}
+
+	if len(conjuncts) == 0 {
+		return bleve.NewMatchAllQuery(), nil
+	}
+
+	return bleve.NewConjunctionQuery(conjuncts...), nil
+}
@@ -0,0 +1,365 @@
+package search
+
+import (
+	"context"
+	"errors"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+
+	"github.com/aws/aws-lambda-go/events"
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/dynamodb"
+
+	"github.com/roloum/users/internal/test"
+	"github.com/roloum/users/internal/user"
+)
+
+//newTestIndex returns an in-memory index with a few users
+func newTestIndex(t *testing.T) *Index {
+
+	ix, err := NewMemoryIndex()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for _, u := range []*user.User{
+		{Email: "john@acme.com", FirstName: "John", LastName: "Smith",
+			Active: true, Created: "2020-11-02"},
+		{Email: "jane@acme.com", FirstName: "Jane", LastName: "Smyth",
+			Created: "2020-12-24"},
+		{Email: "smith@other.org", FirstName: "Mary", LastName: "Jones",
+			Active: true, Created: "2020-12-01"},
+	} {
+		if err := ix.Put(u); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	return ix
+}
+
+func emails(users []*user.User) []string {
+	var e []string
+	for _, u := range users {
+		e = append(e, u.Email)
+	}
+	return e
+}
+
+//keys returns the keys of a stream record of the user
+func keys(email, sk string) map[string]events.DynamoDBAttributeValue {
+	return map[string]events.DynamoDBAttributeValue{
+		"pk": events.NewStringAttribute("USER#" + email),
+		"sk": events.NewStringAttribute(sk),
+	}
+}
+
+//TestIndexQuery Tests the queries and facets of the index
+func TestIndexQuery(t *testing.T) {
+
+	ix := newTestIndex(t)
+	defer ix.Close()
+
+	active := true
+
+	tests := []struct {
+		desc     string
+		query    Query
+		expected []string
+		err      error
+	}{
+		{desc: "All", query: Query{},
+			expected: []string{"jane@acme.com", "john@acme.com", "smith@other.org"}},
+		{desc: "Exact", query: Query{Text: "jones"},
+			expected: []string{"smith@other.org"}},
+		{desc: "Fuzzy", query: Query{Text: "smith @acme.com", Fuzziness: 1},
+			expected: []string{"john@acme.com", "jane@acme.com"}},
+		{desc: "NotFuzzy", query: Query{Text: "smith @acme.com"},
+			expected: []string{"john@acme.com"}},
+		{desc: "NamePrefix", query: Query{Text: "ma"},
+			expected: []string{"smith@other.org"}},
+		{desc: "EmailPrefix", query: Query{Text: "jane@"},
+			expected: []string{"jane@acme.com"}},
+		{desc: "NoMatch", query: Query{Text: "ja@"}, expected: nil},
+		{desc: "EmailPrefixOrName", query: Query{Text: "smith"},
+			expected: []string{"john@acme.com", "smith@other.org"}},
+		{desc: "Filters", query: Query{Active: &active, CreatedMonth: "2020-12"},
+			expected: []string{"smith@other.org"}},
+		{desc: "Domain", query: Query{Domain: "@Other.org"},
+			expected: []string{"smith@other.org"}},
+		{desc: "TooShort", query: Query{Text: "j"},
+			err: errors.New(user.ErrorSearchQueryTooShort)},
+	}
+
+	for _, tc := range tests {
+		t.Run(tc.desc, func(t *testing.T) {
+			res, err := ix.Query(context.Background(), tc.query)
+			if !reflect.DeepEqual(err, tc.err) {
+				t.Fatalf("Expected: %v. Received: %v", tc.err, err)
+			}
+			if err != nil {
+				return
+			}
+			if e := emails(res.Users); !reflect.DeepEqual(e, tc.expected) {
+				t.Errorf("Expected: %v. Received: %v", tc.expected, e)
+			}
+		})
+	}
+
+	t.Run("Facets", func(t *testing.T) {
+		res, err := ix.Query(context.Background(), Query{})
+		if err != nil {
+			t.Fatal(err)
+		}
+		expected := map[string][]Facet{
+			FacetActive:       {{Term: "T", Count: 2}, {Term: "F", Count: 1}},
+			FacetCreatedMonth: {{Term: "2020-12", Count: 2}, {Term: "2020-11", Count: 1}},
+			FacetDomain:       {{Term: "acme.com", Count: 2}, {Term: "other.org", Count: 1}},
+		}
+		if !reflect.DeepEqual(res.Facets, expected) {
+			t.Errorf("Expected: %v. Received: %v", expected, res.Facets)
+		}
+		if res.Total != 3 {
+			t.Errorf("Expected: 3. Received: %d", res.Total)
+		}
+	})
+
+	t.Run("StoredFields", func(t *testing.T) {
+		res, err := ix.Query(context.Background(), Query{Text: "jones"})
+		if err != nil {
+			t.Fatal(err)
+		}
+		expected := &user.User{Email: "smith@other.org", FirstName: "Mary",
+			LastName: "Jones", Active: true, Created: "2020-12-01"}
+		if !reflect.DeepEqual(res.Users[0], expected) {
+			t.Errorf("Expected: %+v. Received: %+v", expected, res.Users[0])
+		}
+	})
+}
+
+//TestIndexApply Tests applying the records of the DynamoDB stream
+func TestIndexApply(t *testing.T) {
+
+	ix := newTestIndex(t)
+	defer ix.Close()
+
+	records := []events.DynamoDBEventRecord{
+		{EventName: "INSERT", Change: events.DynamoDBStreamRecord{
+			Keys: keys("bob@acme.com", "PROFILE#"),
+			NewImage: map[string]events.DynamoDBAttributeValue{
+				"email":     events.NewStringAttribute("bob@acme.com"),
+				"firstName": events.NewStringAttribute("Bob"),
+				"lastName":  events.NewStringAttribute("Builder"),
+				"active":    events.NewBooleanAttribute(false),
+			},
+		}},
+		{EventName: "MODIFY", Change: events.DynamoDBStreamRecord{
+			Keys: keys("jane@acme.com", "PROFILE#"),
+			NewImage: map[string]events.DynamoDBAttributeValue{
+				"email":     events.NewStringAttribute("jane@acme.com"),
+				"firstName": events.NewStringAttribute("Jane"),
+				"lastName":  events.NewStringAttribute("Doe"),
+				"active":    events.NewBooleanAttribute(true),
+			},
+		}},
+		{EventName: "REMOVE", Change: events.DynamoDBStreamRecord{
+			Keys: keys("john@acme.com", "PROFILE#"),
+		}},
+		{EventName: "INSERT", Change: events.DynamoDBStreamRecord{
+			Keys: keys("smith@other.org", "TOKEN#"),
+		}},
+	}
+
+	count, err := ix.Apply(records)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if count != 3 {
+		t.Errorf("Expected: 3. Received: %d", count)
+	}
+
+	res, err := ix.Query(context.Background(), Query{Domain: "acme.com"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	expected := []string{"bob@acme.com", "jane@acme.com"}
+	if e := emails(res.Users); !reflect.DeepEqual(e, expected) {
+		t.Errorf("Expected: %v. Received: %v", expected, e)
+	}
+
+	res, err = ix.Query(context.Background(), Query{Text: "doe"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(res.Users) != 1 || !res.Users[0].Active {
+		t.Errorf("Expected the modified profile. Received: %+v", res.Users)
+	}
+}
+
+//TestIndexLoad Tests loading the index from a scan of the table
+func TestIndexLoad(t *testing.T) {
+
+	ix, err := NewMemoryIndex()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer ix.Close()
+
+	mock := &test.MockDynamoDB{ScanOutput: &dynamodb.ScanOutput{
+		Items: []map[string]*dynamodb.AttributeValue{
+			{"email": {S: aws.String("john@acme.com")},
+				"firstName": {S: aws.String("John")}},
+			{"email": {S: aws.String("jane@acme.com")},
+				"firstName": {S: aws.String("Jane")}},
+		},
+	}}
+
+	count, err := ix.Load(context.Background(), mock, "users")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if count != 2 {
+		t.Errorf("Expected: 2. Received: %d", count)
+	}
+
+	if n, _ := ix.Count(); n != 2 {
+		t.Errorf("Expected: 2. Received: %d", n)
+	}
+}
+
+//TestRebuild Tests rebuilding the index stored on disk
+func TestRebuild(t *testing.T) {
+
+	path := filepath.Join(t.TempDir(), "users.bleve")
+
+	//An existing index is replaced
+	ix, err := OpenIndex(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := ix.Put(&user.User{Email: "old@acme.com"}); err != nil {
+		t.Fatal(err)
+	}
+	ix.Close()
+
+	mock := &test.MockDynamoDB{ScanOutput: &dynamodb.ScanOutput{
+		Items: []map[string]*dynamodb.AttributeValue{
+			{"email": {S: aws.String("john@acme.com")}},
+		},
+	}}
+
+	count, err := Rebuild(context.Background(), mock, "users", path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if count != 1 {
+		t.Errorf("Expected: 1. Received: %d", count)
+	}
+
+	//The directory of the first index is moved aside and removed, path is a
+	//link to the new one
+	if fi, err := os.Lstat(path); err != nil || fi.Mode()&os.ModeSymlink == 0 {
+		t.Errorf("Expected a link at %s. Received: %v, %v", path, fi, err)
+	}
+	first, err := filepath.EvalSymlinks(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := Rebuild(context.Background(), mock, "users", path); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := os.Stat(first); !os.IsNotExist(err) {
+		t.Errorf("Expected the previous index to be removed. Received: %v", err)
+	}
+	if _, err := os.Stat(path + journalSuffix); !os.IsNotExist(err) {
+		t.Errorf("Expected the journal to be removed. Received: %v", err)
+	}
+
+	ix, err = OpenIndexReadOnly(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer ix.Close()
+
+	users, err := ix.Search(context.Background(), "john@ac", 10)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if e := emails(users); !reflect.DeepEqual(e, []string{"john@acme.com"}) {
+		t.Errorf("Expected: [john@acme.com]. Received: %v", e)
+	}
+
+	t.Run("PathIsEmpty", func(t *testing.T) {
+		_, err := Rebuild(context.Background(), mock, "users", "")
+		if !reflect.DeepEqual(err, errors.New(ErrorIndexPathIsEmpty)) {
+			t.Errorf("Expected: %v. Received: %v", ErrorIndexPathIsEmpty, err)
+		}
+	})
+}
+
+//TestApplyTo Tests that the records applied during a rebuild are journaled
+//and replayed on the new index
+func TestApplyTo(t *testing.T) {
+
+	path := filepath.Join(t.TempDir(), "users.bleve")
+
+	records := []events.DynamoDBEventRecord{
+		{EventName: "INSERT", Change: events.DynamoDBStreamRecord{
+			Keys: keys("bob@acme.com", "PROFILE#"),
+			NewImage: map[string]events.DynamoDBAttributeValue{
+				"email":     events.NewStringAttribute("bob@acme.com"),
+				"firstName": events.NewStringAttribute("Bob"),
+			},
+		}},
+	}
+
+	//Without a rebuild nothing is journaled
+	if _, err := ApplyTo(path, records); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := os.Stat(path + journalSuffix); !os.IsNotExist(err) {
+		t.Errorf("Expected no journal. Received: %v", err)
+	}
+
+	f, err := os.Create(path + journalSuffix)
+	if err != nil {
+		t.Fatal(err)
+	}
+	f.Close()
+
+	count, err := ApplyTo(path, records)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if count != 1 {
+		t.Errorf("Expected: 1. Received: %d", count)
+	}
+
+	ix, err := NewMemoryIndex()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer ix.Close()
+
+	offset, err := replayJournal(ix, path+journalSuffix, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	users, err := ix.Search(context.Background(), "bob", 10)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if e := emails(users); !reflect.DeepEqual(e, []string{"bob@acme.com"}) {
+		t.Errorf("Expected: [bob@acme.com]. Received: %v", e)
+	}
+
+	//A replay from the end applies nothing
+	if end, err := replayJournal(ix, path+journalSuffix, offset); err != nil ||
+		end != offset {
+		t.Errorf("Expected: %d. Received: %d, %v", offset, end, err)
+	}
+}
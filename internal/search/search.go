@@ -0,0 +1,74 @@
+//Package search finds users for the support tools. The DynamoDB backend
+//queries the search indexes of the user table, other backends keep a full-text
+//index fed from the DynamoDB stream
+package search
+
+import (
+	"context"
+	"errors"
+
+	"github.com/aws/aws-sdk-go/service/dynamodb/dynamodbiface"
+
+	"github.com/roloum/users/internal/user"
+)
+
+const (
+	//BackendDynamoDB searches the DomainIndex and NameIndex of the user table
+	BackendDynamoDB = "dynamodb"
+
+	//ErrorUnknownBackend Returned when the configured backend does not exist
+	ErrorUnknownBackend = "UnknownSearchBackend"
+)
+
+//Backend searches users. The query is made of terms: a name prefix, an email
+//prefix or @domain. Every term must match
+type Backend interface {
+	Search(ctx context.Context, query string, limit int) ([]*user.User, error)
+	Close() error
+}
+
+//Config selects the backend, Index.Path is the directory of the Bleve index
+type Config struct {
+	Backend string `default:"dynamodb"`
+	Index   struct {
+		Path string
+	}
+}
+
+//DynamoDB is the backend querying the user table
+type DynamoDB struct {
+	svc       dynamodbiface.DynamoDBAPI
+	tableName string
+}
+
+//NewDynamoDB returns the backend querying the user table
+func NewDynamoDB(svc dynamodbiface.DynamoDBAPI, tableName string) *DynamoDB {
+	return &DynamoDB{svc: svc, tableName: tableName}
+}
+
+//Search implements Backend
+func (d *DynamoDB) Search(ctx context.Context, query string, limit int) (
+	[]*user.User, error) {
+
+	return user.Search(ctx, d.svc, d.tableName, query, limit)
+}
+
+//Close implements Backend
+func (d *DynamoDB) Close() error {
+	return nil
+}
+
+//New returns the configured backend, DynamoDB when none is. The Bleve index
+//is opened read-only and must be closed after use
+func New(cfg Config, svc dynamodbiface.DynamoDBAPI, tableName string) (
+	Backend, error) {
+
+	switch cfg.Backend {
+	case "", BackendDynamoDB:
+		return NewDynamoDB(svc, tableName), nil
+	case BackendBleve:
+		return OpenIndexReadOnly(cfg.Index.Path)
+	}
+
+	return nil, errors.New(ErrorUnknownBackend)
+}
@@ -0,0 +1,116 @@
+package table
+
+import (
+	"context"
+	"net/http"
+	"sort"
+	"strings"
+
+	"github.com/rs/zerolog/log"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/dynamodb"
+	"github.com/aws/aws-sdk-go/service/dynamodb/dynamodbiface"
+
+	"github.com/roloum/users/internal/apperr"
+	"github.com/roloum/users/internal/user"
+)
+
+//ErrorEmailCollisions Returned when different users have the same canonical
+//email
+const ErrorEmailCollisions = "EmailCollisions"
+
+func init() {
+	apperr.Register(http.StatusConflict, ErrorEmailCollisions)
+}
+
+//Collision lists the emails of the keys of different users that have the same
+//canonical email. They are not moved, one of the accounts must be merged into
+//the other or deleted first
+type Collision struct {
+	Canonical string   `json:"canonical"`
+	Emails    []string `json:"emails"`
+}
+
+//CanonicalReport is the result of CanonicalizeEmails
+type CanonicalReport struct {
+	Users      int         `json:"users"`
+	Rekeyed    []string    `json:"rekeyed,omitempty"`
+	Pending    []string    `json:"pending,omitempty"`
+	Collisions []Collision `json:"collisions,omitempty"`
+}
+
+//keyOwner is the email of a profile key and the id of its user
+type keyOwner struct {
+	email string
+	id    string
+}
+
+//CanonicalizeEmails finds the users whose key is not their canonical email,
+//the ones created before the keys were canonical. Without apply they are
+//reported as pending, with apply they are moved to the canonical key. The
+//keys of different users with the same canonical email are reported as
+//collisions and left alone. Two keys of the same user are a move that failed
+//halfway, which is resumed
+func CanonicalizeEmails(ctx context.Context, svc dynamodbiface.DynamoDBAPI,
+	tableName string, apply bool) (*CanonicalReport, error) {
+
+	report := &CanonicalReport{}
+	groups := map[string][]keyOwner{}
+
+	err := ForEachUser(ctx, svc, tableName,
+		func(item map[string]*dynamodb.AttributeValue) error {
+			email := strings.TrimPrefix(aws.StringValue(item["pk"].S),
+				user.DynamoDBPrefixUser+"#")
+			canonical := user.CanonicalEmail(email)
+			groups[canonical] = append(groups[canonical],
+				keyOwner{email: email, id: aws.StringValue(item["id"].S)})
+			return nil
+		})
+	if err != nil {
+		return nil, err
+	}
+
+	canonicals := make([]string, 0, len(groups))
+	for c := range groups {
+		canonicals = append(canonicals, c)
+	}
+	sort.Strings(canonicals)
+
+	for _, canonical := range canonicals {
+		owners := groups[canonical]
+
+		ids := map[string]bool{}
+		for _, o := range owners {
+			ids[o.id] = true
+		}
+		report.Users += len(ids)
+
+		if len(ids) > 1 {
+			c := Collision{Canonical: canonical}
+			for _, o := range owners {
+				c.Emails = append(c.Emails, o.email)
+			}
+			sort.Strings(c.Emails)
+			log.Warn().Msgf("Collision of %s: %v", canonical, c.Emails)
+			report.Collisions = append(report.Collisions, c)
+			continue
+		}
+
+		for _, o := range owners {
+			if o.email == canonical {
+				continue
+			}
+			if !apply {
+				report.Pending = append(report.Pending, o.email)
+				continue
+			}
+			if err := user.Rekey(ctx, svc, tableName, o.email); err != nil {
+				return report, err
+			}
+			report.Rekeyed = append(report.Rekeyed, o.email)
+		}
+	}
+
+	return report, nil
+}
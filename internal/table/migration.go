@@ -0,0 +1,228 @@
+package table
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"time"
+
+	"github.com/rs/zerolog/log"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/dynamodb"
+	"github.com/aws/aws-sdk-go/service/dynamodb/dynamodbattribute"
+	"github.com/aws/aws-sdk-go/service/dynamodb/dynamodbiface"
+
+	"github.com/roloum/users/internal/user"
+)
+
+const (
+	//DynamoDBPrefixMigration Prefix of the primary key of the rows recording
+	//the applied migrations
+	DynamoDBPrefixMigration = "MIGRATION"
+
+	//DynamoDBPrefixVersion Prefix of the sort key of the migration rows
+	DynamoDBPrefixVersion = "VERSION"
+)
+
+//Migration is a versioned change to the data in the table. Up must be safe to
+//run again if it fails halfway
+type Migration struct {
+	Version int
+	Name    string
+	Up      func(ctx context.Context, svc dynamodbiface.DynamoDBAPI,
+		tableName string) error
+}
+
+//AppliedMigration is the row recording a migration that was run
+type AppliedMigration struct {
+	Version int    `json:"version"`
+	Name    string `json:"name"`
+	Applied string `json:"applied"`
+}
+
+//Migrations lists every migration of the table, the version is never reused
+var Migrations = []Migration{
+	{
+		Version: 1,
+		Name:    "Backfill search attributes and name token rows",
+		Up:      backfillSearch,
+	},
+	{
+		Version: 2,
+		Name:    "Backfill the inactive index attribute",
+		Up:      backfillInactive,
+	},
+}
+
+//backfillSearch writes the search attributes of the users created before
+//search existed
+func backfillSearch(ctx context.Context, svc dynamodbiface.DynamoDBAPI,
+	tableName string) error {
+
+	return ForEachUser(ctx, svc, tableName,
+		func(item map[string]*dynamodb.AttributeValue) error {
+			var u user.User
+			if err := dynamodbattribute.UnmarshalMap(item, &u); err != nil {
+				return err
+			}
+			return u.SyncSearch(ctx, svc, tableName)
+		})
+}
+
+//backfillInactive adds the users who signed up before the sweep existed and
+//never activated the account to the inactive index
+func backfillInactive(ctx context.Context, svc dynamodbiface.DynamoDBAPI,
+	tableName string) error {
+
+	return ForEachUser(ctx, svc, tableName,
+		func(item map[string]*dynamodb.AttributeValue) error {
+			var u user.User
+			if err := dynamodbattribute.UnmarshalMap(item, &u); err != nil {
+				return err
+			}
+			if u.Active {
+				return nil
+			}
+			return u.SyncInactive(ctx, svc, tableName)
+		})
+}
+
+//Applied returns the migrations recorded in the table, by version
+func Applied(ctx context.Context, svc dynamodbiface.DynamoDBAPI,
+	tableName string) (map[int]AppliedMigration, error) {
+
+	applied := map[int]AppliedMigration{}
+
+	var err error
+	qerr := svc.QueryPagesWithContext(ctx, &dynamodb.QueryInput{
+		TableName:              aws.String(tableName),
+		KeyConditionExpression: aws.String("pk = :pk"),
+		ExpressionAttributeValues: map[string]*dynamodb.AttributeValue{
+			":pk": {S: aws.String(DynamoDBPrefixMigration + "#")},
+		},
+	}, func(page *dynamodb.QueryOutput, last bool) bool {
+		for _, item := range page.Items {
+			var m AppliedMigration
+			if err = dynamodbattribute.UnmarshalMap(item, &m); err != nil {
+				return false
+			}
+			applied[m.Version] = m
+		}
+		return true
+	})
+	if qerr != nil {
+		return nil, qerr
+	}
+
+	return applied, err
+}
+
+//Pending returns the migrations that have not been applied, by version
+func Pending(ctx context.Context, svc dynamodbiface.DynamoDBAPI,
+	tableName string, migrations []Migration) ([]Migration, error) {
+
+	applied, err := Applied(ctx, svc, tableName)
+	if err != nil {
+		return nil, err
+	}
+
+	var pending []Migration
+	for _, m := range migrations {
+		if _, ok := applied[m.Version]; !ok {
+			pending = append(pending, m)
+		}
+	}
+
+	sort.Slice(pending, func(i, j int) bool {
+		return pending[i].Version < pending[j].Version
+	})
+
+	return pending, nil
+}
+
+//Migrate runs the pending migrations in order and records each one after it
+//succeeds. With dryRun the pending migrations are only returned
+func Migrate(ctx context.Context, svc dynamodbiface.DynamoDBAPI,
+	tableName string, migrations []Migration, dryRun bool) ([]Migration, error) {
+
+	pending, err := Pending(ctx, svc, tableName, migrations)
+	if err != nil || dryRun {
+		return pending, err
+	}
+
+	for i, m := range pending {
+		log.Info().Msgf("Applying migration %d: %s", m.Version, m.Name)
+
+		if err := m.Up(ctx, svc, tableName); err != nil {
+			return pending[:i], fmt.Errorf("migration %d: %v", m.Version, err)
+		}
+
+		if err := record(ctx, svc, tableName, m); err != nil {
+			return pending[:i], err
+		}
+	}
+
+	return pending, nil
+}
+
+//MarkApplied records the migrations without running them, used when the
+//table is created and already has the shape they would produce
+func MarkApplied(ctx context.Context, svc dynamodbiface.DynamoDBAPI,
+	tableName string, migrations []Migration) ([]Migration, error) {
+
+	for i, m := range migrations {
+		if err := record(ctx, svc, tableName, m); err != nil {
+			return migrations[:i], err
+		}
+	}
+
+	return migrations, nil
+}
+
+//record inserts the row of an applied migration
+func record(ctx context.Context, svc dynamodbiface.DynamoDBAPI,
+	tableName string, m Migration) error {
+
+	_, err := svc.PutItemWithContext(ctx, &dynamodb.PutItemInput{
+		TableName: aws.String(tableName),
+		Item: map[string]*dynamodb.AttributeValue{
+			"pk":      {S: aws.String(DynamoDBPrefixMigration + "#")},
+			"sk":      {S: aws.String(fmt.Sprintf("%s#%06d", DynamoDBPrefixVersion, m.Version))},
+			"version": {N: aws.String(fmt.Sprintf("%d", m.Version))},
+			"name":    {S: aws.String(m.Name)},
+			"applied": {S: aws.String(time.Now().UTC().Format(time.RFC3339))},
+		},
+	})
+	return err
+}
+
+//ForEachUser scans the profile rows, the items of type User, and calls fn
+//with each of them. Meant to be used by migrations that backfill attributes
+func ForEachUser(ctx context.Context, svc dynamodbiface.DynamoDBAPI,
+	tableName string, fn func(item map[string]*dynamodb.AttributeValue) error) error {
+
+	var err error
+	serr := svc.ScanPagesWithContext(ctx, &dynamodb.ScanInput{
+		TableName:        aws.String(tableName),
+		FilterExpression: aws.String("#T = :type"),
+		ExpressionAttributeNames: map[string]*string{
+			"#T": aws.String("type"),
+		},
+		ExpressionAttributeValues: map[string]*dynamodb.AttributeValue{
+			":type": {S: aws.String(user.DynamoDBTypeUser)},
+		},
+	}, func(page *dynamodb.ScanOutput, last bool) bool {
+		for _, item := range page.Items {
+			if err = fn(item); err != nil {
+				return false
+			}
+		}
+		return true
+	})
+	if serr != nil {
+		return serr
+	}
+
+	return err
+}
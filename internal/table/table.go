@@ -0,0 +1,260 @@
+//Package table provisions the DynamoDB table and runs the data migrations
+package table
+
+import (
+	"context"
+	"errors"
+	"time"
+
+	"github.com/rs/zerolog/log"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/dynamodb"
+	"github.com/aws/aws-sdk-go/service/dynamodb/dynamodbiface"
+
+	"github.com/roloum/users/internal/user"
+)
+
+const (
+	//AttributeTTL attribute holding the expiration of short lived rows
+	AttributeTTL = "ttl"
+
+	//ErrorIndexNotActive Returned when a new index did not become active in time
+	ErrorIndexNotActive = "IndexNotActive"
+)
+
+//pollInterval wait between checks of the status of a new index
+var pollInterval = 5 * time.Second
+
+//Description is the current state of the table
+type Description struct {
+	Table *dynamodb.TableDescription      `json:"table"`
+	TTL   *dynamodb.TimeToLiveDescription `json:"ttl"`
+}
+
+//Definition returns the table the code needs: pk/sk keys, a stream with new
+//and old images and every global secondary index queried by the packages
+func Definition(tableName string) *dynamodb.CreateTableInput {
+	return &dynamodb.CreateTableInput{
+		TableName:   aws.String(tableName),
+		BillingMode: aws.String(dynamodb.BillingModePayPerRequest),
+		AttributeDefinitions: []*dynamodb.AttributeDefinition{
+			attribute("pk"),
+			attribute("sk"),
+			attribute("id"),
+			attribute("domain"),
+			attribute("email"),
+			attribute("namePrefix"),
+			attribute("nameToken"),
+			attribute(user.DynamoDBAttributeInactive),
+			attribute("created"),
+			attribute(user.DynamoDBAttributeTenant),
+		},
+		KeySchema: keySchema("pk", "sk"),
+		StreamSpecification: &dynamodb.StreamSpecification{
+			StreamEnabled:  aws.Bool(true),
+			StreamViewType: aws.String(dynamodb.StreamViewTypeNewAndOldImages),
+		},
+		GlobalSecondaryIndexes: []*dynamodb.GlobalSecondaryIndex{
+			index(user.DynamoDBIndexInverted, "sk", "pk"),
+			index(user.DynamoDBIndexID, "id", ""),
+			index(user.DynamoDBIndexDomain, "domain", "email"),
+			index(user.DynamoDBIndexName, "namePrefix", "nameToken"),
+			index(user.DynamoDBIndexInactive, user.DynamoDBAttributeInactive,
+				"created"),
+			index(user.DynamoDBIndexTenant, user.DynamoDBAttributeTenant, "email"),
+		},
+	}
+}
+
+//Create creates the table, waits until it is active and enables the TTL
+func Create(ctx context.Context, svc dynamodbiface.DynamoDBAPI,
+	tableName string) error {
+
+	log.Info().Msgf("Creating table: %s", tableName)
+
+	if _, err := svc.CreateTableWithContext(ctx, Definition(tableName)); err != nil {
+		return err
+	}
+
+	if err := svc.WaitUntilTableExistsWithContext(ctx, &dynamodb.DescribeTableInput{
+		TableName: aws.String(tableName),
+	}); err != nil {
+		return err
+	}
+
+	return enableTTL(ctx, svc, tableName)
+}
+
+//Describe returns the current state of the table
+func Describe(ctx context.Context, svc dynamodbiface.DynamoDBAPI,
+	tableName string) (*Description, error) {
+
+	table, err := svc.DescribeTableWithContext(ctx, &dynamodb.DescribeTableInput{
+		TableName: aws.String(tableName),
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	ttl, err := svc.DescribeTimeToLiveWithContext(ctx,
+		&dynamodb.DescribeTimeToLiveInput{TableName: aws.String(tableName)})
+	if err != nil {
+		return nil, err
+	}
+
+	return &Description{Table: table.Table, TTL: ttl.TimeToLiveDescription}, nil
+}
+
+//Sync adds the global secondary indexes of the definition that are missing
+//in the table and enables the TTL if needed. Returns the names of the indexes
+//created
+func Sync(ctx context.Context, svc dynamodbiface.DynamoDBAPI,
+	tableName string) ([]string, error) {
+
+	d, err := Describe(ctx, svc, tableName)
+	if err != nil {
+		return nil, err
+	}
+
+	existing := map[string]*dynamodb.GlobalSecondaryIndexDescription{}
+	for _, gsi := range d.Table.GlobalSecondaryIndexes {
+		existing[aws.StringValue(gsi.IndexName)] = gsi
+	}
+
+	def := Definition(tableName)
+
+	var created []string
+	for _, gsi := range def.GlobalSecondaryIndexes {
+		name := aws.StringValue(gsi.IndexName)
+		if current, ok := existing[name]; ok {
+			//The projection of an index can not be changed, it has to be
+			//deleted and created again
+			if current.Projection != nil && aws.StringValue(
+				current.Projection.ProjectionType) != aws.StringValue(
+				gsi.Projection.ProjectionType) {
+				log.Warn().Msgf("Index %s projects %s instead of %s, delete it "+
+					"and run migrate again", name,
+					aws.StringValue(current.Projection.ProjectionType),
+					aws.StringValue(gsi.Projection.ProjectionType))
+			}
+			continue
+		}
+
+		log.Info().Msgf("Creating index: %s", name)
+
+		create := &dynamodb.CreateGlobalSecondaryIndexAction{
+			IndexName:  gsi.IndexName,
+			KeySchema:  gsi.KeySchema,
+			Projection: gsi.Projection,
+		}
+		if d.Table.BillingModeSummary == nil || aws.StringValue(
+			d.Table.BillingModeSummary.BillingMode) != dynamodb.BillingModePayPerRequest {
+			create.ProvisionedThroughput = &dynamodb.ProvisionedThroughput{
+				ReadCapacityUnits:  aws.Int64(1),
+				WriteCapacityUnits: aws.Int64(1),
+			}
+		}
+
+		//DynamoDB only creates one index per request
+		if _, err := svc.UpdateTableWithContext(ctx, &dynamodb.UpdateTableInput{
+			TableName:            aws.String(tableName),
+			AttributeDefinitions: def.AttributeDefinitions,
+			GlobalSecondaryIndexUpdates: []*dynamodb.GlobalSecondaryIndexUpdate{
+				{Create: create},
+			},
+		}); err != nil {
+			return created, err
+		}
+
+		if err := waitForIndex(ctx, svc, tableName, name); err != nil {
+			return created, err
+		}
+
+		created = append(created, name)
+	}
+
+	if d.TTL == nil || aws.StringValue(d.TTL.TimeToLiveStatus) ==
+		dynamodb.TimeToLiveStatusDisabled {
+		if err := enableTTL(ctx, svc, tableName); err != nil {
+			return created, err
+		}
+	}
+
+	return created, nil
+}
+
+//waitForIndex polls the table until the index is active
+func waitForIndex(ctx context.Context, svc dynamodbiface.DynamoDBAPI,
+	tableName, indexName string) error {
+
+	for i := 0; i < 120; i++ {
+		out, err := svc.DescribeTableWithContext(ctx, &dynamodb.DescribeTableInput{
+			TableName: aws.String(tableName),
+		})
+		if err != nil {
+			return err
+		}
+
+		for _, gsi := range out.Table.GlobalSecondaryIndexes {
+			if aws.StringValue(gsi.IndexName) == indexName &&
+				aws.StringValue(gsi.IndexStatus) == dynamodb.IndexStatusActive {
+				return nil
+			}
+		}
+
+		log.Debug().Msgf("Waiting for index: %s", indexName)
+
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-time.After(pollInterval):
+		}
+	}
+
+	return errors.New(ErrorIndexNotActive)
+}
+
+func enableTTL(ctx context.Context, svc dynamodbiface.DynamoDBAPI,
+	tableName string) error {
+
+	log.Info().Msgf("Enabling TTL on attribute: %s", AttributeTTL)
+
+	_, err := svc.UpdateTimeToLiveWithContext(ctx, &dynamodb.UpdateTimeToLiveInput{
+		TableName: aws.String(tableName),
+		TimeToLiveSpecification: &dynamodb.TimeToLiveSpecification{
+			AttributeName: aws.String(AttributeTTL),
+			Enabled:       aws.Bool(true),
+		},
+	})
+	return err
+}
+
+func attribute(name string) *dynamodb.AttributeDefinition {
+	return &dynamodb.AttributeDefinition{
+		AttributeName: aws.String(name),
+		AttributeType: aws.String(dynamodb.ScalarAttributeTypeS),
+	}
+}
+
+//keySchema returns the key schema, rangeKey is optional
+func keySchema(hashKey, rangeKey string) []*dynamodb.KeySchemaElement {
+	ks := []*dynamodb.KeySchemaElement{
+		{AttributeName: aws.String(hashKey), KeyType: aws.String(dynamodb.KeyTypeHash)},
+	}
+	if rangeKey != "" {
+		ks = append(ks, &dynamodb.KeySchemaElement{
+			AttributeName: aws.String(rangeKey),
+			KeyType:       aws.String(dynamodb.KeyTypeRange),
+		})
+	}
+	return ks
+}
+
+func index(name, hashKey, rangeKey string) *dynamodb.GlobalSecondaryIndex {
+	return &dynamodb.GlobalSecondaryIndex{
+		IndexName:  aws.String(name),
+		KeySchema:  keySchema(hashKey, rangeKey),
+		Projection: &dynamodb.Projection{ProjectionType: aws.String(dynamodb.ProjectionTypeAll)},
+	}
+}
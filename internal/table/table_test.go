@@ -0,0 +1,168 @@
+package table
+
+import (
+	"context"
+	"errors"
+	"reflect"
+	"testing"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/dynamodb"
+	"github.com/aws/aws-sdk-go/service/dynamodb/dynamodbiface"
+
+	"github.com/roloum/users/internal/test"
+)
+
+const tableName = "users"
+
+//TestDefinition Tests that every index queried by the code is created
+func TestDefinition(t *testing.T) {
+
+	def := Definition(tableName)
+
+	var indexes []string
+	for _, gsi := range def.GlobalSecondaryIndexes {
+		indexes = append(indexes, aws.StringValue(gsi.IndexName))
+	}
+
+	expected := []string{"InvertedIndex", "IdIndex", "DomainIndex", "NameIndex",
+		"InactiveIndex", "TenantIndex"}
+	if !reflect.DeepEqual(indexes, expected) {
+		t.Errorf("Expected: %v. Received: %v", expected, indexes)
+	}
+
+	if aws.StringValue(def.StreamSpecification.StreamViewType) !=
+		dynamodb.StreamViewTypeNewAndOldImages {
+		t.Errorf("Expected: %v. Received: %v",
+			dynamodb.StreamViewTypeNewAndOldImages,
+			aws.StringValue(def.StreamSpecification.StreamViewType))
+	}
+}
+
+//TestMigrate Tests that only pending migrations run, in order
+func TestMigrate(t *testing.T) {
+
+	var ran []int
+	up := func(version int, err error) Migration {
+		return Migration{
+			Version: version,
+			Name:    "test",
+			Up: func(context.Context, dynamodbiface.DynamoDBAPI, string) error {
+				ran = append(ran, version)
+				return err
+			},
+		}
+	}
+
+	mock := &test.MockDynamoDB{
+		QueryOutput: &dynamodb.QueryOutput{
+			Items: []map[string]*dynamodb.AttributeValue{
+				{
+					"pk":      {S: aws.String("MIGRATION#")},
+					"sk":      {S: aws.String("VERSION#000001")},
+					"version": {N: aws.String("1")},
+					"name":    {S: aws.String("test")},
+				},
+			},
+		},
+		PutItemOutput: &dynamodb.PutItemOutput{},
+	}
+
+	tests := []struct {
+		desc       string
+		migrations []Migration
+		dryRun     bool
+		ran        []int
+		applied    int
+		err        error
+	}{
+		{
+			desc:       "Pending",
+			migrations: []Migration{up(3, nil), up(1, nil), up(2, nil)},
+			ran:        []int{2, 3},
+			applied:    2,
+		},
+		{
+			desc:       "DryRun",
+			migrations: []Migration{up(1, nil), up(2, nil)},
+			dryRun:     true,
+			applied:    1,
+		},
+		{
+			desc:       "Failed",
+			migrations: []Migration{up(2, errors.New("Failed")), up(3, nil)},
+			ran:        []int{2},
+			err:        errors.New("migration 2: Failed"),
+		},
+	}
+
+	for _, tc := range tests {
+		t.Run(tc.desc, func(t *testing.T) {
+			ran = nil
+
+			applied, err := Migrate(context.Background(), mock, tableName,
+				tc.migrations, tc.dryRun)
+			if !reflect.DeepEqual(err, tc.err) {
+				t.Fatalf("Expected: %v. Received: %v", tc.err, err)
+			}
+			if !reflect.DeepEqual(ran, tc.ran) {
+				t.Errorf("Expected: %v. Received: %v", tc.ran, ran)
+			}
+			if len(applied) != tc.applied {
+				t.Errorf("Expected: %d. Received: %d", tc.applied, len(applied))
+			}
+		})
+	}
+}
+
+//TestCanonicalizeEmails Tests finding the keys that are not canonical and the
+//collisions
+func TestCanonicalizeEmails(t *testing.T) {
+
+	profile := func(email, id string) map[string]*dynamodb.AttributeValue {
+		return map[string]*dynamodb.AttributeValue{
+			"pk": {S: aws.String("USER#" + email)},
+			"sk": {S: aws.String("PROFILE#")},
+			"id": {S: aws.String(id)},
+		}
+	}
+
+	mock := &test.MockDynamoDB{ScanOutput: &dynamodb.ScanOutput{
+		Items: []map[string]*dynamodb.AttributeValue{
+			profile("john@acme.com", "1"),
+			profile("Jane@Acme.com", "2"),
+			profile("Foo@Example.com", "3"),
+			profile("foo@example.com", "4"),
+			profile("Bob@Acme.com", "5"),
+			profile("bob@acme.com", "5"),
+		},
+	}}
+
+	tests := []struct {
+		desc     string
+		apply    bool
+		expected *CanonicalReport
+	}{
+		{desc: "DryRun", expected: &CanonicalReport{Users: 5,
+			Pending: []string{"Bob@Acme.com", "Jane@Acme.com"},
+			Collisions: []Collision{{Canonical: "foo@example.com",
+				Emails: []string{"Foo@Example.com", "foo@example.com"}}}}},
+		{desc: "Apply", apply: true, expected: &CanonicalReport{Users: 5,
+			Rekeyed: []string{"Bob@Acme.com", "Jane@Acme.com"},
+			Collisions: []Collision{{Canonical: "foo@example.com",
+				Emails: []string{"Foo@Example.com", "foo@example.com"}}}}},
+	}
+
+	for _, tc := range tests {
+		t.Run(tc.desc, func(t *testing.T) {
+			report, err := CanonicalizeEmails(context.Background(), mock,
+				tableName, tc.apply)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !reflect.DeepEqual(report, tc.expected) {
+				t.Errorf("Expected: %+v. Received: %+v", tc.expected, report)
+			}
+		})
+	}
+}
@@ -1,6 +1,8 @@
 package test
 
 import (
+	"strings"
+
 	"github.com/aws/aws-sdk-go/aws"
 	"github.com/aws/aws-sdk-go/aws/request"
 	"github.com/aws/aws-sdk-go/service/dynamodb"
@@ -11,9 +13,26 @@ import (
 type MockDynamoDB struct {
 	dynamodbiface.DynamoDBAPI
 
+	GetItemOutput            *dynamodb.GetItemOutput
 	PutItemOutput            *dynamodb.PutItemOutput
+	UpdateItemOutput         *dynamodb.UpdateItemOutput
+	DeleteItemOutput         *dynamodb.DeleteItemOutput
+	QueryOutput              *dynamodb.QueryOutput
+	ScanOutput               *dynamodb.ScanOutput
+	BatchWriteItemOutput     *dynamodb.BatchWriteItemOutput
+	BatchGetItemOutput       *dynamodb.BatchGetItemOutput
 	TransactWriteItemsOutput *dynamodb.TransactWriteItemsOutput
 	OutputError              error
+
+	//UpdateItemErrors are returned by the successive UpdateItem calls, nil
+	//ones and the calls after the last fall back to OutputError
+	UpdateItemErrors []error
+}
+
+//GetItemWithContext mocks the GetItemWithContext method
+func (m *MockDynamoDB) GetItemWithContext(aws.Context, *dynamodb.GetItemInput,
+	...request.Option) (*dynamodb.GetItemOutput, error) {
+	return m.GetItemOutput, m.OutputError
 }
 
 //PutItemWithContext mocks the PutItemWithContext method
@@ -22,9 +41,104 @@ func (m *MockDynamoDB) PutItemWithContext(aws.Context, *dynamodb.PutItemInput,
 	return m.PutItemOutput, m.OutputError
 }
 
+//UpdateItemWithContext mocks the UpdateItemWithContext method
+func (m *MockDynamoDB) UpdateItemWithContext(aws.Context,
+	*dynamodb.UpdateItemInput, ...request.Option) (*dynamodb.UpdateItemOutput,
+	error) {
+	if len(m.UpdateItemErrors) > 0 {
+		err := m.UpdateItemErrors[0]
+		m.UpdateItemErrors = m.UpdateItemErrors[1:]
+		if err != nil {
+			return nil, err
+		}
+	}
+	return m.UpdateItemOutput, m.OutputError
+}
+
+//DeleteItemWithContext mocks the DeleteItemWithContext method
+func (m *MockDynamoDB) DeleteItemWithContext(aws.Context,
+	*dynamodb.DeleteItemInput, ...request.Option) (*dynamodb.DeleteItemOutput,
+	error) {
+	return m.DeleteItemOutput, m.OutputError
+}
+
+//QueryWithContext mocks the QueryWithContext method
+func (m *MockDynamoDB) QueryWithContext(aws.Context, *dynamodb.QueryInput,
+	...request.Option) (*dynamodb.QueryOutput, error) {
+	return m.QueryOutput, m.OutputError
+}
+
+//QueryPagesWithContext mocks the QueryPagesWithContext method. QueryOutput is
+//returned as the only page
+func (m *MockDynamoDB) QueryPagesWithContext(_ aws.Context, _ *dynamodb.QueryInput,
+	fn func(*dynamodb.QueryOutput, bool) bool, _ ...request.Option) error {
+	if m.OutputError != nil {
+		return m.OutputError
+	}
+	if m.QueryOutput != nil {
+		fn(m.QueryOutput, true)
+	}
+	return nil
+}
+
+//ScanPagesWithContext mocks the ScanPagesWithContext method. ScanOutput is
+//returned as the only page of the first segment
+func (m *MockDynamoDB) ScanPagesWithContext(_ aws.Context,
+	input *dynamodb.ScanInput, fn func(*dynamodb.ScanOutput, bool) bool,
+	_ ...request.Option) error {
+	if m.OutputError != nil {
+		return m.OutputError
+	}
+	if m.ScanOutput != nil && aws.Int64Value(input.Segment) == 0 {
+		fn(m.ScanOutput, true)
+	}
+	return nil
+}
+
+//BatchGetItemWithContext mocks the BatchGetItemWithContext method
+func (m *MockDynamoDB) BatchGetItemWithContext(aws.Context,
+	*dynamodb.BatchGetItemInput, ...request.Option) (
+	*dynamodb.BatchGetItemOutput, error) {
+	if m.BatchGetItemOutput == nil {
+		return &dynamodb.BatchGetItemOutput{}, m.OutputError
+	}
+	return m.BatchGetItemOutput, m.OutputError
+}
+
+//BatchWriteItemWithContext mocks the BatchWriteItemWithContext method
+func (m *MockDynamoDB) BatchWriteItemWithContext(aws.Context,
+	*dynamodb.BatchWriteItemInput, ...request.Option) (
+	*dynamodb.BatchWriteItemOutput, error) {
+	if m.BatchWriteItemOutput == nil {
+		return &dynamodb.BatchWriteItemOutput{}, m.OutputError
+	}
+	return m.BatchWriteItemOutput, m.OutputError
+}
+
 //TransactWriteItemsWithContext mocks the TransactWriteItemsWithContext method
 func (m *MockDynamoDB) TransactWriteItemsWithContext(aws.Context,
 	*dynamodb.TransactWriteItemsInput, ...request.Option) (
 	*dynamodb.TransactWriteItemsOutput, error) {
 	return m.TransactWriteItemsOutput, m.OutputError
 }
+
+//MockTable Mock DynamoDB client returning the rows of Items by the prefix of
+//their sort key, before the first #, so a request can read several kinds of
+//rows of a partition. The other calls fall back to MockDynamoDB
+type MockTable struct {
+	*MockDynamoDB
+
+	Items map[string]map[string]*dynamodb.AttributeValue
+}
+
+//GetItemWithContext mocks the GetItemWithContext method
+func (m *MockTable) GetItemWithContext(_ aws.Context,
+	input *dynamodb.GetItemInput, _ ...request.Option) (*dynamodb.GetItemOutput,
+	error) {
+	if m.OutputError != nil {
+		return nil, m.OutputError
+	}
+	sk := aws.StringValue(input.Key["sk"].S)
+	return &dynamodb.GetItemOutput{
+		Item: m.Items[strings.SplitN(sk, "#", 2)[0]]}, nil
+}
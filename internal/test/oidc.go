@@ -0,0 +1,113 @@
+package test
+
+import (
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+
+	"github.com/roloum/users/internal/jwt"
+)
+
+//MockIssuerKeyID kid of the key that signs the mock issuer ID tokens
+const MockIssuerKeyID = "test"
+
+//MockIssuer is a local OpenID Connect provider that serves discovery, JWKS
+//and a token endpoint for codes registered with Authorize
+type MockIssuer struct {
+	Server *httptest.Server
+	Key    *rsa.PrivateKey
+
+	mu    sync.Mutex
+	codes map[string]mockCode
+}
+
+type mockCode struct {
+	challenge string
+	claims    map[string]interface{}
+}
+
+//NewMockIssuer starts a mock issuer. Close the Server when done
+func NewMockIssuer() (*MockIssuer, error) {
+	key, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		return nil, err
+	}
+
+	m := &MockIssuer{Key: key, codes: map[string]mockCode{}}
+
+	mux := http.NewServeMux()
+	mux.HandleFunc("/.well-known/openid-configuration", m.discovery)
+	mux.HandleFunc("/jwks", m.jwks)
+	mux.HandleFunc("/token", m.token)
+	m.Server = httptest.NewServer(mux)
+
+	return m, nil
+}
+
+//Issuer returns the issuer URL
+func (m *MockIssuer) Issuer() string {
+	return m.Server.URL
+}
+
+//Authorize registers an authorization code. The token endpoint returns an ID
+//token with the claims when the code verifier matches the challenge
+func (m *MockIssuer) Authorize(code, challenge string,
+	claims map[string]interface{}) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.codes[code] = mockCode{challenge: challenge, claims: claims}
+}
+
+func (m *MockIssuer) discovery(w http.ResponseWriter, r *http.Request) {
+	writeJSON(w, http.StatusOK, map[string]string{
+		"issuer":                 m.Issuer(),
+		"authorization_endpoint": m.Issuer() + "/authorize",
+		"token_endpoint":         m.Issuer() + "/token",
+		"userinfo_endpoint":      m.Issuer() + "/userinfo",
+		"jwks_uri":               m.Issuer() + "/jwks",
+	})
+}
+
+func (m *MockIssuer) jwks(w http.ResponseWriter, r *http.Request) {
+	writeJSON(w, http.StatusOK, jwt.JWKS{
+		Keys: []jwt.JWK{jwt.NewRSAJWK(MockIssuerKeyID, &m.Key.PublicKey)},
+	})
+}
+
+func (m *MockIssuer) token(w http.ResponseWriter, r *http.Request) {
+	m.mu.Lock()
+	c, ok := m.codes[r.FormValue("code")]
+	delete(m.codes, r.FormValue("code"))
+	m.mu.Unlock()
+
+	sum := sha256.Sum256([]byte(r.FormValue("code_verifier")))
+	if !ok || base64.RawURLEncoding.EncodeToString(sum[:]) != c.challenge {
+		writeJSON(w, http.StatusBadRequest, map[string]string{
+			"error": "invalid_grant",
+		})
+		return
+	}
+
+	idToken, err := jwt.Sign(m.Key, MockIssuerKeyID, c.claims)
+	if err != nil {
+		w.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+
+	writeJSON(w, http.StatusOK, map[string]string{
+		"access_token": "access",
+		"token_type":   "Bearer",
+		"id_token":     idToken,
+	})
+}
+
+func writeJSON(w http.ResponseWriter, status int, v interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	_ = json.NewEncoder(w).Encode(v)
+}
@@ -0,0 +1,69 @@
+package totp
+
+import (
+	"encoding/base32"
+	"testing"
+	"time"
+)
+
+//TestCode Tests the generated codes against the RFC 6238 SHA-1 test vectors,
+//truncated to six digits
+func TestCode(t *testing.T) {
+
+	secret := base32.StdEncoding.WithPadding(base32.NoPadding).
+		EncodeToString([]byte("12345678901234567890"))
+
+	tests := []struct {
+		desc string
+		time int64
+		code string
+	}{
+		{desc: "59", time: 59, code: "287082"},
+		{desc: "1111111109", time: 1111111109, code: "081804"},
+		{desc: "1234567890", time: 1234567890, code: "005924"},
+		{desc: "2000000000", time: 2000000000, code: "279037"},
+	}
+
+	for _, tc := range tests {
+		t.Run(tc.desc, func(t *testing.T) {
+			code, err := Code(secret, time.Unix(tc.time, 0))
+			if err != nil {
+				t.Fatalf("Unexpected error: %v", err)
+			}
+			if code != tc.code {
+				t.Errorf("Expected: %v. Received: %v", tc.code, code)
+			}
+		})
+	}
+}
+
+//TestValidate Tests the clock skew tolerance
+func TestValidate(t *testing.T) {
+
+	secret, err := GenerateSecret()
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+
+	now := time.Now()
+
+	tests := []struct {
+		desc   string
+		offset time.Duration
+		valid  bool
+	}{
+		{desc: "current", offset: 0, valid: true},
+		{desc: "previous", offset: -Period * time.Second, valid: true},
+		{desc: "next", offset: Period * time.Second, valid: true},
+		{desc: "expired", offset: -3 * Period * time.Second, valid: false},
+	}
+
+	for _, tc := range tests {
+		t.Run(tc.desc, func(t *testing.T) {
+			code, _ := Code(secret, now.Add(tc.offset))
+			if result := Validate(secret, code, now); result != tc.valid {
+				t.Errorf("Expected: %v. Received: %v", tc.valid, result)
+			}
+		})
+	}
+}
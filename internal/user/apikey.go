@@ -0,0 +1,283 @@
+package user
+
+import (
+	"context"
+	"crypto/subtle"
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/rs/zerolog/log"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/dynamodb"
+	"github.com/aws/aws-sdk-go/service/dynamodb/dynamodbattribute"
+	"github.com/aws/aws-sdk-go/service/dynamodb/dynamodbiface"
+)
+
+const (
+	//DynamoDBPrefixAPIKey Prefix added to the sort key of an API key row
+	DynamoDBPrefixAPIKey = "APIKEY"
+
+	//DynamoDBTypeAPIKey identifies the API key rows in dynamoDB
+	DynamoDBTypeAPIKey = "APIKey"
+
+	//APIKeyPrefix is prepended to every API key so they are easy to recognize
+	//in the Authorization header and by secret scanners
+	APIKeyPrefix = "uk_"
+
+	//ErrorAPIKeyNameIsEmpty Returned when creating an API key without name
+	ErrorAPIKeyNameIsEmpty = "APIKeyNameIsEmpty"
+
+	//ErrorAPIKeyDoesNotExist Returned when revoking an unknown API key
+	ErrorAPIKeyDoesNotExist = "APIKeyDoesNotExist"
+
+	//ErrorInvalidAPIKey Returned when the API key is malformed, unknown,
+	//revoked or expired
+	ErrorInvalidAPIKey = "InvalidAPIKey"
+)
+
+//APIKey describes an API key. The secret is only stored hashed
+type APIKey struct {
+	Prefix   string   `json:"prefix"`
+	Name     string   `json:"name"`
+	Scopes   []string `json:"scopes,omitempty" dynamodbav:"scopes,stringset,omitempty"`
+	Created  string   `json:"created"`
+	Expires  string   `json:"expires,omitempty"`
+	LastUsed string   `json:"lastUsed,omitempty"`
+	Hash     string   `json:"-" dynamodbav:"hash"`
+}
+
+//CreateAPIKey creates an API key with the given scopes. A ttl of zero creates
+//a key that does not expire. The key is returned once and can not be retrieved
+//later
+func (u *User) CreateAPIKey(ctx context.Context, svc dynamodbiface.DynamoDBAPI,
+	tableName, name string, scopes []string, ttl time.Duration) (*APIKey,
+	string, error) {
+
+	log.Info().Msgf("Creating API key for: %s", u.Email)
+
+	if name == "" {
+		return nil, "", errors.New(ErrorAPIKeyNameIsEmpty)
+	}
+
+	if err := u.Load(ctx, svc, tableName); err != nil {
+		return nil, "", err
+	}
+
+	prefix, err := randomToken(6)
+	if err != nil {
+		return nil, "", err
+	}
+	prefix = strings.NewReplacer("-", "0", "_", "1").Replace(prefix)
+
+	secret, err := randomToken(32)
+	if err != nil {
+		return nil, "", err
+	}
+
+	k := &APIKey{
+		Prefix:  prefix,
+		Name:    name,
+		Scopes:  scopes,
+		Created: time.Now().UTC().Format(time.RFC3339),
+		Hash:    hashToken(secret),
+	}
+
+	item := map[string]*dynamodb.AttributeValue{
+		"pk":      {S: aws.String(u.getUserPK())},
+		"sk":      {S: aws.String(getAPIKeySK(prefix))},
+		"prefix":  {S: aws.String(k.Prefix)},
+		"name":    {S: aws.String(k.Name)},
+		"hash":    {S: aws.String(k.Hash)},
+		"created": {S: aws.String(k.Created)},
+		"type":    {S: aws.String(DynamoDBTypeAPIKey)},
+	}
+	if len(scopes) > 0 {
+		item["scopes"] = &dynamodb.AttributeValue{SS: aws.StringSlice(scopes)}
+	}
+	if ttl > 0 {
+		expires := time.Now().Add(ttl).UTC()
+		k.Expires = expires.Format(time.RFC3339)
+		item["expires"] = &dynamodb.AttributeValue{S: aws.String(k.Expires)}
+		item["ttl"] = &dynamodb.AttributeValue{
+			N: aws.String(strconv.FormatInt(expires.Unix(), 10))}
+	}
+
+	_, err = svc.PutItemWithContext(ctx, &dynamodb.PutItemInput{
+		TableName:           aws.String(tableName),
+		Item:                item,
+		ConditionExpression: aws.String("attribute_not_exists(pk) and attribute_not_exists(sk)"),
+	})
+	if err != nil {
+		return nil, "", err
+	}
+
+	return k, fmt.Sprintf("%s%s_%s", APIKeyPrefix, prefix, secret), nil
+}
+
+//ListAPIKeys returns the API keys of the user
+func (u *User) ListAPIKeys(ctx context.Context, svc dynamodbiface.DynamoDBAPI,
+	tableName string) ([]*APIKey, error) {
+
+	log.Debug().Msgf("Listing API keys for: %s", u.Email)
+
+	if u.Email == "" {
+		return nil, errors.New("Email is not set")
+	}
+
+	var keys []*APIKey
+
+	err := svc.QueryPagesWithContext(ctx, &dynamodb.QueryInput{
+		TableName:              aws.String(tableName),
+		KeyConditionExpression: aws.String("pk = :pk AND begins_with(sk, :sk)"),
+		ExpressionAttributeValues: map[string]*dynamodb.AttributeValue{
+			":pk": {S: aws.String(u.getUserPK())},
+			":sk": {S: aws.String(DynamoDBPrefixAPIKey + "#")},
+		},
+	}, func(page *dynamodb.QueryOutput, last bool) bool {
+		for _, item := range page.Items {
+			var k APIKey
+			if err := dynamodbattribute.UnmarshalMap(item, &k); err != nil {
+				log.Error().Msg(err.Error())
+				continue
+			}
+			keys = append(keys, &k)
+		}
+		return true
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return keys, nil
+}
+
+//RevokeAPIKey deletes the API key with the given prefix
+func (u *User) RevokeAPIKey(ctx context.Context, svc dynamodbiface.DynamoDBAPI,
+	tableName, prefix string) error {
+
+	log.Info().Msgf("Revoking API key %s for: %s", prefix, u.Email)
+
+	if u.Email == "" {
+		return errors.New("Email is not set")
+	}
+
+	_, err := svc.DeleteItemWithContext(ctx, &dynamodb.DeleteItemInput{
+		TableName: aws.String(tableName),
+		Key: map[string]*dynamodb.AttributeValue{
+			"pk": {S: aws.String(u.getUserPK())},
+			"sk": {S: aws.String(getAPIKeySK(prefix))},
+		},
+		ConditionExpression: aws.String("attribute_exists(pk) AND attribute_exists(sk)"),
+	})
+	if err != nil {
+		if isConditionalCheckFailed(err) {
+			return errors.New(ErrorAPIKeyDoesNotExist)
+		}
+		return err
+	}
+
+	return nil
+}
+
+//AuthenticateAPIKey returns the user owning the API key, and the key. The last
+//used timestamp is updated on a best effort basis. The keys of deactivated
+//users are rejected
+func AuthenticateAPIKey(ctx context.Context, svc dynamodbiface.DynamoDBAPI,
+	tableName, key string) (*User, *APIKey, error) {
+
+	prefix, secret, err := parseAPIKey(key)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	log.Debug().Msgf("Authenticating API key: %s", prefix)
+
+	result, err := svc.QueryWithContext(ctx, &dynamodb.QueryInput{
+		TableName:              aws.String(tableName),
+		IndexName:              aws.String(DynamoDBIndexInverted),
+		KeyConditionExpression: aws.String("sk = :sk"),
+		ExpressionAttributeValues: map[string]*dynamodb.AttributeValue{
+			":sk": {S: aws.String(getAPIKeySK(prefix))},
+		},
+		Limit: aws.Int64(1),
+	})
+	if err != nil {
+		return nil, nil, err
+	}
+
+	if len(result.Items) == 0 {
+		return nil, nil, errors.New(ErrorInvalidAPIKey)
+	}
+
+	var k APIKey
+	if err := dynamodbattribute.UnmarshalMap(result.Items[0], &k); err != nil {
+		return nil, nil, err
+	}
+
+	if subtle.ConstantTimeCompare([]byte(hashToken(secret)), []byte(k.Hash)) != 1 {
+		return nil, nil, errors.New(ErrorInvalidAPIKey)
+	}
+
+	if k.Expires != "" {
+		expires, err := time.Parse(time.RFC3339, k.Expires)
+		if err != nil || expires.Before(time.Now()) {
+			return nil, nil, errors.New(ErrorInvalidAPIKey)
+		}
+	}
+
+	u := &User{
+		Email: strings.TrimPrefix(aws.StringValue(result.Items[0]["pk"].S),
+			DynamoDBPrefixUser+"#"),
+	}
+
+	k.LastUsed = time.Now().UTC().Format(time.RFC3339)
+	if _, err := svc.UpdateItemWithContext(ctx, &dynamodb.UpdateItemInput{
+		TableName: aws.String(tableName),
+		Key: map[string]*dynamodb.AttributeValue{
+			"pk": {S: aws.String(u.getUserPK())},
+			"sk": {S: aws.String(getAPIKeySK(prefix))},
+		},
+		ExpressionAttributeNames: map[string]*string{
+			"#L": aws.String("lastUsed"),
+		},
+		ExpressionAttributeValues: map[string]*dynamodb.AttributeValue{
+			":lastUsed": {S: aws.String(k.LastUsed)},
+		},
+		UpdateExpression:    aws.String("SET #L = :lastUsed"),
+		ConditionExpression: aws.String("attribute_exists(pk)"),
+	}); err != nil {
+		log.Warn().Msgf("Could not update API key last used: %s", err)
+	}
+
+	if err := u.Load(ctx, svc, tableName); err != nil {
+		return nil, nil, err
+	}
+
+	if !u.Active {
+		return nil, nil, errors.New(ErrorUserDeactivated)
+	}
+
+	return u, &k, nil
+}
+
+//parseAPIKey splits an API key into prefix and secret
+func parseAPIKey(key string) (string, string, error) {
+	if !strings.HasPrefix(key, APIKeyPrefix) {
+		return "", "", errors.New(ErrorInvalidAPIKey)
+	}
+
+	parts := strings.SplitN(strings.TrimPrefix(key, APIKeyPrefix), "_", 2)
+	if len(parts) != 2 || parts[0] == "" || parts[1] == "" {
+		return "", "", errors.New(ErrorInvalidAPIKey)
+	}
+
+	return parts[0], parts[1], nil
+}
+
+func getAPIKeySK(prefix string) string {
+	return fmt.Sprintf("%s#%s", DynamoDBPrefixAPIKey, prefix)
+}
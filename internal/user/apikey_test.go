@@ -0,0 +1,98 @@
+package user
+
+import (
+	"context"
+	"errors"
+	"reflect"
+	"testing"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/dynamodb"
+
+	"github.com/roloum/users/internal/test"
+)
+
+//TestAuthenticateAPIKey Tests the AuthenticateAPIKey functionality
+func TestAuthenticateAPIKey(t *testing.T) {
+
+	row := func(hash, expires string) *dynamodb.QueryOutput {
+		item := map[string]*dynamodb.AttributeValue{
+			"pk":     {S: aws.String("USER#test@user.com")},
+			"sk":     {S: aws.String("APIKEY#abcd")},
+			"prefix": {S: aws.String("abcd")},
+			"hash":   {S: aws.String(hash)},
+		}
+		if expires != "" {
+			item["expires"] = &dynamodb.AttributeValue{S: aws.String(expires)}
+		}
+		return &dynamodb.QueryOutput{
+			Items: []map[string]*dynamodb.AttributeValue{item},
+		}
+	}
+
+	profile := func(active bool) *dynamodb.GetItemOutput {
+		return &dynamodb.GetItemOutput{
+			Item: map[string]*dynamodb.AttributeValue{
+				"email":  {S: aws.String("test@user.com")},
+				"active": {BOOL: aws.Bool(active)},
+			},
+		}
+	}
+
+	tests := []struct {
+		desc      string
+		key       string
+		mockDBSvc *test.MockDynamoDB
+		err       error
+	}{
+		{
+			desc: "ValidKey",
+			key:  "uk_abcd_secret",
+			mockDBSvc: &test.MockDynamoDB{QueryOutput: row(hashToken("secret"), ""),
+				GetItemOutput: profile(true)},
+			err: nil,
+		},
+		{
+			desc: "Deactivated",
+			key:  "uk_abcd_secret",
+			mockDBSvc: &test.MockDynamoDB{QueryOutput: row(hashToken("secret"), ""),
+				GetItemOutput: profile(false)},
+			err: errors.New(ErrorUserDeactivated),
+		},
+		{
+			desc:      "Malformed",
+			key:       "abcd_secret",
+			mockDBSvc: &test.MockDynamoDB{},
+			err:       errors.New(ErrorInvalidAPIKey),
+		},
+		{
+			desc:      "Unknown",
+			key:       "uk_abcd_secret",
+			mockDBSvc: &test.MockDynamoDB{QueryOutput: &dynamodb.QueryOutput{}},
+			err:       errors.New(ErrorInvalidAPIKey),
+		},
+		{
+			desc:      "WrongSecret",
+			key:       "uk_abcd_other",
+			mockDBSvc: &test.MockDynamoDB{QueryOutput: row(hashToken("secret"), "")},
+			err:       errors.New(ErrorInvalidAPIKey),
+		},
+		{
+			desc: "Expired",
+			key:  "uk_abcd_secret",
+			mockDBSvc: &test.MockDynamoDB{
+				QueryOutput: row(hashToken("secret"), "2020-01-01T00:00:00Z")},
+			err: errors.New(ErrorInvalidAPIKey),
+		},
+	}
+
+	for _, tc := range tests {
+		t.Run(tc.desc, func(t *testing.T) {
+			_, _, err := AuthenticateAPIKey(context.Background(), tc.mockDBSvc,
+				UserTable, tc.key)
+			if !reflect.DeepEqual(err, tc.err) {
+				t.Errorf("Expected: %v. Received: %v", tc.err, err)
+			}
+		})
+	}
+}
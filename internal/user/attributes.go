@@ -0,0 +1,430 @@
+package user
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"reflect"
+	"regexp"
+	"sort"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/rs/zerolog/log"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/dynamodb"
+	"github.com/aws/aws-sdk-go/service/dynamodb/dynamodbattribute"
+	"github.com/aws/aws-sdk-go/service/dynamodb/dynamodbiface"
+
+	"github.com/roloum/users/internal/apperr"
+)
+
+const (
+	//DynamoDBPrefixSchema Prefix of the primary key of the schema rows
+	DynamoDBPrefixSchema = "SCHEMA"
+
+	//DynamoDBPrefixAttribute Prefix of the sort key of an attribute definition
+	DynamoDBPrefixAttribute = "ATTRIBUTE"
+
+	//DynamoDBTypeAttribute identifies the attribute definition rows
+	DynamoDBTypeAttribute = "Attribute"
+
+	//AttributeString attribute holding text, matched against the pattern
+	AttributeString = "string"
+
+	//AttributeNumber attribute holding a number
+	AttributeNumber = "number"
+
+	//AttributeBoolean attribute holding true or false
+	AttributeBoolean = "boolean"
+
+	//VisibilityPublic attribute anyone may see
+	VisibilityPublic = "public"
+
+	//VisibilityPrivate attribute only the user and the admins see
+	VisibilityPrivate = "private"
+
+	//VisibilityAdmin attribute only the admins see and set
+	VisibilityAdmin = "admin"
+
+	//ErrorAttributeIsRequired Returned for a required attribute without value
+	ErrorAttributeIsRequired = "AttributeIsRequired"
+
+	//ErrorInvalidAttribute Returned when the value does not have the type or
+	//does not match the pattern of the attribute
+	ErrorInvalidAttribute = "InvalidAttribute"
+
+	//ErrorUnknownAttribute Returned for an attribute not in the schema
+	ErrorUnknownAttribute = "UnknownAttribute"
+
+	//ErrorAttributeNotWritable Returned when the user sets an admin attribute
+	ErrorAttributeNotWritable = "AttributeNotWritable"
+
+	//ErrorInvalidAttributeDefinition Returned when a definition has an empty
+	//or reserved name, an unknown type or visibility, or a wrong pattern
+	ErrorInvalidAttributeDefinition = "InvalidAttributeDefinition"
+
+	//ErrorAttributeDoesNotExist Returned when deleting an unknown definition
+	ErrorAttributeDoesNotExist = "AttributeDoesNotExist"
+)
+
+//visibilities orders the visibilities, a level sees its own and the lower ones
+var visibilities = map[string]int{
+	VisibilityPublic:  0,
+	VisibilityPrivate: 1,
+	VisibilityAdmin:   2,
+}
+
+//attributeName is the pattern of the attribute names
+var attributeName = regexp.MustCompile(`^[a-zA-Z][a-zA-Z0-9_]{0,63}$`)
+
+//Attribute defines a custom attribute of the profiles. The type is stored as
+//attributeType, type identifies the kind of row
+type Attribute struct {
+	Name        string `json:"name"`
+	Type        string `json:"type" dynamodbav:"attributeType"`
+	Required    bool   `json:"required,omitempty"`
+	Pattern     string `json:"pattern,omitempty"`
+	Visibility  string `json:"visibility"`
+	Description string `json:"description,omitempty"`
+}
+
+//Schema is the set of attribute definitions, by name
+type Schema map[string]*Attribute
+
+//check verifies the definition, defaulting the visibility to private
+func (a *Attribute) check() error {
+
+	if a.Visibility == "" {
+		a.Visibility = VisibilityPrivate
+	}
+
+	if !attributeName.MatchString(a.Name) {
+		return errors.New(ErrorInvalidAttributeDefinition)
+	}
+
+	switch a.Type {
+	case AttributeString, AttributeNumber, AttributeBoolean:
+	default:
+		return errors.New(ErrorInvalidAttributeDefinition)
+	}
+
+	if _, ok := visibilities[a.Visibility]; !ok {
+		return errors.New(ErrorInvalidAttributeDefinition)
+	}
+
+	if a.Pattern != "" {
+		if a.Type != AttributeString {
+			return errors.New(ErrorInvalidAttributeDefinition)
+		}
+		if _, err := regexp.Compile(a.Pattern); err != nil {
+			return errors.New(ErrorInvalidAttributeDefinition)
+		}
+	}
+
+	return nil
+}
+
+//validValue tells whether v has the type of the attribute and matches its
+//pattern
+func (a *Attribute) validValue(v interface{}) bool {
+
+	switch a.Type {
+	case AttributeString:
+		s, ok := v.(string)
+		if !ok {
+			return false
+		}
+		if a.Pattern != "" {
+			return regexp.MustCompile(a.Pattern).MatchString(s)
+		}
+		return true
+	case AttributeNumber:
+		switch v.(type) {
+		case float64, float32, int, int64, int32:
+			return true
+		}
+	case AttributeBoolean:
+		_, ok := v.(bool)
+		return ok
+	}
+
+	return false
+}
+
+//Parse converts the text of a value, as typed in the command line, to the
+//type of the attribute
+func (a *Attribute) Parse(value string) (interface{}, error) {
+
+	switch a.Type {
+	case AttributeNumber:
+		n, err := strconv.ParseFloat(value, 64)
+		if err != nil {
+			return nil, errors.New(ErrorInvalidAttribute)
+		}
+		return n, nil
+	case AttributeBoolean:
+		b, err := strconv.ParseBool(value)
+		if err != nil {
+			return nil, errors.New(ErrorInvalidAttribute)
+		}
+		return b, nil
+	}
+
+	return value, nil
+}
+
+//Names returns the names of the attributes, sorted
+func (s Schema) Names() []string {
+	names := make([]string, 0, len(s))
+	for n := range s {
+		names = append(names, n)
+	}
+	sort.Strings(names)
+	return names
+}
+
+//Check validates the attributes of a new profile: every required one is set,
+//every value has the type and matches the pattern of its definition and
+//there are no attributes outside the schema. The fields are reported as
+//attributes.<name>
+func (s Schema) Check(attrs map[string]interface{}) []apperr.FieldError {
+
+	fields := s.CheckValues(attrs)
+
+	for _, n := range s.Names() {
+		if _, ok := attrs[n]; s[n].Required && !ok {
+			fields = append(fields, attributeError(n, ErrorAttributeIsRequired))
+		}
+	}
+
+	return fields
+}
+
+//CheckValues validates the attributes like Check but does not require any.
+//Used when the caller can not supply the attributes, like SCIM, the imports
+//and the sign-ups with an identity provider
+func (s Schema) CheckValues(attrs map[string]interface{}) []apperr.FieldError {
+
+	var fields []apperr.FieldError
+
+	names := make([]string, 0, len(attrs))
+	for n := range attrs {
+		names = append(names, n)
+	}
+	sort.Strings(names)
+
+	for _, n := range names {
+		a, ok := s[n]
+		switch {
+		case !ok:
+			fields = append(fields, attributeError(n, ErrorUnknownAttribute))
+		case !a.validValue(attrs[n]):
+			fields = append(fields, attributeError(n, ErrorInvalidAttribute))
+		}
+	}
+
+	return fields
+}
+
+//CheckChanges validates the attributes of a profile that had old: only the
+//attributes added or changed are checked, and only the required ones that
+//were set can not be removed. The values stored before their definition was
+//deleted, or became required, do not block the update
+func (s Schema) CheckChanges(old,
+	attrs map[string]interface{}) []apperr.FieldError {
+
+	changed := map[string]interface{}{}
+	for n, v := range attrs {
+		if ov, ok := old[n]; !ok || !reflect.DeepEqual(ov, v) {
+			changed[n] = v
+		}
+	}
+
+	fields := s.CheckValues(changed)
+
+	for _, n := range s.Names() {
+		_, had := old[n]
+		if _, ok := attrs[n]; s[n].Required && had && !ok {
+			fields = append(fields, attributeError(n, ErrorAttributeIsRequired))
+		}
+	}
+
+	return fields
+}
+
+//Writable reports the attributes the user can not set, the ones whose
+//visibility is above level
+func (s Schema) Writable(attrs map[string]interface{},
+	level string) []apperr.FieldError {
+
+	var fields []apperr.FieldError
+	for _, n := range s.Names() {
+		if _, ok := attrs[n]; ok &&
+			visibilities[s[n].Visibility] > visibilities[level] {
+			fields = append(fields, attributeError(n, ErrorAttributeNotWritable))
+		}
+	}
+	return fields
+}
+
+//Visible returns the attributes whose visibility is level or lower. The
+//attributes outside the schema are only visible to the admins
+func (s Schema) Visible(attrs map[string]interface{},
+	level string) map[string]interface{} {
+
+	if len(attrs) == 0 {
+		return nil
+	}
+
+	visible := map[string]interface{}{}
+	for n, v := range attrs {
+		a, ok := s[n]
+		if (ok && visibilities[a.Visibility] <= visibilities[level]) ||
+			level == VisibilityAdmin {
+			visible[n] = v
+		}
+	}
+
+	if len(visible) == 0 {
+		return nil
+	}
+	return visible
+}
+
+func attributeError(name, code string) apperr.FieldError {
+	return apperr.FieldError{Field: "attributes." + name, Code: code}
+}
+
+//SchemaTTL is how long Create and Update reuse the schema they loaded, so a
+//bulk import or a warm Lambda does not read it for every user
+var SchemaTTL = time.Minute
+
+//schemaCache holds the schema of each table with the time it was loaded
+var schemaCache = struct {
+	sync.Mutex
+	schemas map[string]cachedSchema
+}{schemas: map[string]cachedSchema{}}
+
+type cachedSchema struct {
+	schema Schema
+	loaded time.Time
+}
+
+//GetSchema returns the schema loaded less than SchemaTTL ago, or loads it.
+//Used by Create, Update and the handlers filtering the attributes
+func GetSchema(ctx context.Context, svc dynamodbiface.DynamoDBAPI,
+	tableName string) (Schema, error) {
+
+	schemaCache.Lock()
+	c, ok := schemaCache.schemas[tableName]
+	schemaCache.Unlock()
+	if ok && time.Since(c.loaded) < SchemaTTL {
+		return c.schema, nil
+	}
+
+	schema, err := LoadSchema(ctx, svc, tableName)
+	if err != nil {
+		return nil, err
+	}
+
+	schemaCache.Lock()
+	schemaCache.schemas[tableName] = cachedSchema{schema: schema,
+		loaded: time.Now()}
+	schemaCache.Unlock()
+
+	return schema, nil
+}
+
+//LoadSchema returns the attribute definitions stored in the table
+func LoadSchema(ctx context.Context, svc dynamodbiface.DynamoDBAPI,
+	tableName string) (Schema, error) {
+
+	schema := Schema{}
+
+	var err error
+	qerr := svc.QueryPagesWithContext(ctx, &dynamodb.QueryInput{
+		TableName:              aws.String(tableName),
+		KeyConditionExpression: aws.String("pk = :pk AND begins_with(sk, :sk)"),
+		ExpressionAttributeValues: map[string]*dynamodb.AttributeValue{
+			":pk": {S: aws.String(DynamoDBPrefixSchema + "#")},
+			":sk": {S: aws.String(DynamoDBPrefixAttribute + "#")},
+		},
+	}, func(page *dynamodb.QueryOutput, last bool) bool {
+		for _, item := range page.Items {
+			var a Attribute
+			if err = dynamodbattribute.UnmarshalMap(item, &a); err != nil {
+				return false
+			}
+			schema[a.Name] = &a
+		}
+		return true
+	})
+	if qerr != nil {
+		return nil, qerr
+	}
+
+	return schema, err
+}
+
+//PutAttribute creates or replaces an attribute definition. Values already
+//stored are not checked against the new definition
+func PutAttribute(ctx context.Context, svc dynamodbiface.DynamoDBAPI,
+	tableName string, a *Attribute) error {
+
+	if err := a.check(); err != nil {
+		return err
+	}
+
+	log.Info().Msgf("Saving attribute: %s", a.Name)
+
+	item, err := dynamodbattribute.MarshalMap(a)
+	if err != nil {
+		return err
+	}
+	item["pk"] = &dynamodb.AttributeValue{S: aws.String(DynamoDBPrefixSchema + "#")}
+	item["sk"] = &dynamodb.AttributeValue{S: aws.String(getAttributeSK(a.Name))}
+	item["type"] = &dynamodb.AttributeValue{S: aws.String(DynamoDBTypeAttribute)}
+
+	_, err = svc.PutItemWithContext(ctx, &dynamodb.PutItemInput{
+		TableName: aws.String(tableName),
+		Item:      item,
+	})
+	forgetSchema(tableName)
+	return err
+}
+
+//DeleteAttribute deletes an attribute definition. The values stored in the
+//profiles are kept, only the admins see them
+func DeleteAttribute(ctx context.Context, svc dynamodbiface.DynamoDBAPI,
+	tableName, name string) error {
+
+	log.Info().Msgf("Deleting attribute: %s", name)
+
+	_, err := svc.DeleteItemWithContext(ctx, &dynamodb.DeleteItemInput{
+		TableName: aws.String(tableName),
+		Key: map[string]*dynamodb.AttributeValue{
+			"pk": {S: aws.String(DynamoDBPrefixSchema + "#")},
+			"sk": {S: aws.String(getAttributeSK(name))},
+		},
+		ConditionExpression: aws.String("attribute_exists(pk)"),
+	})
+	forgetSchema(tableName)
+	if isConditionalCheckFailed(err) {
+		return errors.New(ErrorAttributeDoesNotExist)
+	}
+	return err
+}
+
+//forgetSchema drops the cached schema of the table after it changes
+func forgetSchema(tableName string) {
+	schemaCache.Lock()
+	delete(schemaCache.schemas, tableName)
+	schemaCache.Unlock()
+}
+
+func getAttributeSK(name string) string {
+	return fmt.Sprintf("%s#%s", DynamoDBPrefixAttribute, name)
+}
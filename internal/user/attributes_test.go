@@ -0,0 +1,207 @@
+package user
+
+import (
+	"context"
+	"errors"
+	"reflect"
+	"testing"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/dynamodb"
+
+	"github.com/roloum/users/internal/apperr"
+	"github.com/roloum/users/internal/test"
+)
+
+//testSchema has an attribute of each type and visibility
+var testSchema = Schema{
+	"phone": {Name: "phone", Type: AttributeString, Required: true,
+		Pattern: `^\+[0-9]{6,15}$`, Visibility: VisibilityPrivate},
+	"company": {Name: "company", Type: AttributeString,
+		Visibility: VisibilityPublic},
+	"seats": {Name: "seats", Type: AttributeNumber, Visibility: VisibilityAdmin},
+	"beta":  {Name: "beta", Type: AttributeBoolean, Visibility: VisibilityAdmin},
+}
+
+//TestSchemaCheck Tests validating the attributes against the schema
+func TestSchemaCheck(t *testing.T) {
+
+	tests := []struct {
+		desc     string
+		attrs    map[string]interface{}
+		expected []apperr.FieldError
+	}{
+		{desc: "Valid", attrs: map[string]interface{}{"phone": "+34600000000",
+			"company": "Acme", "seats": float64(5), "beta": true}},
+		{desc: "Required", attrs: map[string]interface{}{"company": "Acme"},
+			expected: []apperr.FieldError{{Field: "attributes.phone",
+				Code: ErrorAttributeIsRequired}}},
+		{desc: "Invalid", attrs: map[string]interface{}{"phone": "600",
+			"seats": "five", "beta": "yes"},
+			expected: []apperr.FieldError{
+				{Field: "attributes.beta", Code: ErrorInvalidAttribute},
+				{Field: "attributes.phone", Code: ErrorInvalidAttribute},
+				{Field: "attributes.seats", Code: ErrorInvalidAttribute}}},
+		{desc: "Unknown", attrs: map[string]interface{}{"phone": "+34600000000",
+			"locale": "es"},
+			expected: []apperr.FieldError{{Field: "attributes.locale",
+				Code: ErrorUnknownAttribute}}},
+	}
+
+	for _, tc := range tests {
+		t.Run(tc.desc, func(t *testing.T) {
+			fields := testSchema.Check(tc.attrs)
+			if !reflect.DeepEqual(fields, tc.expected) {
+				t.Errorf("Expected: %v. Received: %v", tc.expected, fields)
+			}
+		})
+	}
+}
+
+//TestSchemaCheckChanges Tests validating only the attributes an update
+//changes
+func TestSchemaCheckChanges(t *testing.T) {
+
+	tests := []struct {
+		desc     string
+		old      map[string]interface{}
+		attrs    map[string]interface{}
+		expected []apperr.FieldError
+	}{
+		{desc: "NewlyRequired", old: map[string]interface{}{"company": "Acme"},
+			attrs: map[string]interface{}{"company": "Acme Corp"}},
+		{desc: "Orphaned", old: map[string]interface{}{"locale": "es"},
+			attrs: map[string]interface{}{"locale": "es", "company": "Acme"}},
+		{desc: "OrphanedRemoved", old: map[string]interface{}{"locale": "es"},
+			attrs: map[string]interface{}{}},
+		{desc: "OrphanedChanged", old: map[string]interface{}{"locale": "es"},
+			attrs: map[string]interface{}{"locale": "en"},
+			expected: []apperr.FieldError{{Field: "attributes.locale",
+				Code: ErrorUnknownAttribute}}},
+		{desc: "RequiredRemoved",
+			old:   map[string]interface{}{"phone": "+34600000000"},
+			attrs: map[string]interface{}{},
+			expected: []apperr.FieldError{{Field: "attributes.phone",
+				Code: ErrorAttributeIsRequired}}},
+		{desc: "Invalid", old: map[string]interface{}{"seats": float64(5)},
+			attrs: map[string]interface{}{"seats": "five"},
+			expected: []apperr.FieldError{{Field: "attributes.seats",
+				Code: ErrorInvalidAttribute}}},
+	}
+
+	for _, tc := range tests {
+		t.Run(tc.desc, func(t *testing.T) {
+			fields := testSchema.CheckChanges(tc.old, tc.attrs)
+			if !reflect.DeepEqual(fields, tc.expected) {
+				t.Errorf("Expected: %v. Received: %v", tc.expected, fields)
+			}
+		})
+	}
+}
+
+//TestSchemaVisible Tests filtering the attributes by visibility
+func TestSchemaVisible(t *testing.T) {
+
+	attrs := map[string]interface{}{"phone": "+34600000000", "company": "Acme",
+		"seats": float64(5), "legacy": "x"}
+
+	tests := []struct {
+		level    string
+		expected map[string]interface{}
+	}{
+		{level: VisibilityPublic,
+			expected: map[string]interface{}{"company": "Acme"}},
+		{level: VisibilityPrivate,
+			expected: map[string]interface{}{"company": "Acme",
+				"phone": "+34600000000"}},
+		{level: VisibilityAdmin, expected: attrs},
+	}
+
+	for _, tc := range tests {
+		t.Run(tc.level, func(t *testing.T) {
+			visible := testSchema.Visible(attrs, tc.level)
+			if !reflect.DeepEqual(visible, tc.expected) {
+				t.Errorf("Expected: %v. Received: %v", tc.expected, visible)
+			}
+		})
+	}
+
+	t.Run("Writable", func(t *testing.T) {
+		fields := testSchema.Writable(attrs, VisibilityPrivate)
+		expected := []apperr.FieldError{{Field: "attributes.seats",
+			Code: ErrorAttributeNotWritable}}
+		if !reflect.DeepEqual(fields, expected) {
+			t.Errorf("Expected: %v. Received: %v", expected, fields)
+		}
+	})
+}
+
+//TestPutAttribute Tests the validation of the attribute definitions
+func TestPutAttribute(t *testing.T) {
+
+	tests := []struct {
+		desc string
+		attr Attribute
+		err  error
+	}{
+		{desc: "Valid", attr: Attribute{Name: "phone", Type: AttributeString,
+			Pattern: `^\+[0-9]+$`}},
+		{desc: "EmptyName", attr: Attribute{Type: AttributeString},
+			err: errors.New(ErrorInvalidAttributeDefinition)},
+		{desc: "UnknownType", attr: Attribute{Name: "phone", Type: "date"},
+			err: errors.New(ErrorInvalidAttributeDefinition)},
+		{desc: "UnknownVisibility", attr: Attribute{Name: "phone",
+			Type: AttributeString, Visibility: "friends"},
+			err: errors.New(ErrorInvalidAttributeDefinition)},
+		{desc: "InvalidPattern", attr: Attribute{Name: "phone",
+			Type: AttributeString, Pattern: "("},
+			err: errors.New(ErrorInvalidAttributeDefinition)},
+		{desc: "PatternOfNumber", attr: Attribute{Name: "seats",
+			Type: AttributeNumber, Pattern: "[0-9]"},
+			err: errors.New(ErrorInvalidAttributeDefinition)},
+	}
+
+	for _, tc := range tests {
+		t.Run(tc.desc, func(t *testing.T) {
+			err := PutAttribute(context.Background(), &test.MockDynamoDB{},
+				UserTable, &tc.attr)
+			if !reflect.DeepEqual(err, tc.err) {
+				t.Errorf("Expected: %v. Received: %v", tc.err, err)
+			}
+		})
+	}
+}
+
+//TestCreateWithSchema Tests that Create reports the invalid fields and
+//attributes together
+func TestCreateWithSchema(t *testing.T) {
+
+	forgetSchema(UserTable)
+	defer forgetSchema(UserTable)
+
+	mock := &test.MockDynamoDB{QueryOutput: &dynamodb.QueryOutput{
+		Items: []map[string]*dynamodb.AttributeValue{
+			{
+				"pk":            {S: aws.String("SCHEMA#")},
+				"sk":            {S: aws.String("ATTRIBUTE#phone")},
+				"type":          {S: aws.String(DynamoDBTypeAttribute)},
+				"name":          {S: aws.String("phone")},
+				"attributeType": {S: aws.String(AttributeString)},
+				"required":      {BOOL: aws.Bool(true)},
+				"visibility":    {S: aws.String(VisibilityPrivate)},
+			},
+		},
+	}}
+
+	_, err := Create(context.Background(), mock, &NewUser{Email: "a@user.com",
+		LastName: "User", Attributes: map[string]interface{}{"locale": "es"}},
+		UserTable)
+
+	expected := apperr.Validation(
+		apperr.FieldError{Field: "firstName", Code: ErrorFirstNameIsEmpty},
+		apperr.FieldError{Field: "attributes.locale", Code: ErrorUnknownAttribute},
+		apperr.FieldError{Field: "attributes.phone", Code: ErrorAttributeIsRequired})
+	if !reflect.DeepEqual(err, expected) {
+		t.Errorf("Expected: %v. Received: %v", expected, err)
+	}
+}
@@ -0,0 +1,70 @@
+package user
+
+import (
+	"context"
+	"errors"
+
+	"github.com/rs/zerolog/log"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/dynamodb"
+	"github.com/aws/aws-sdk-go/service/dynamodb/dynamodbattribute"
+	"github.com/aws/aws-sdk-go/service/dynamodb/dynamodbiface"
+)
+
+//SetAvatar stores the URLs of the avatar images on the profile, an empty map
+//removes the avatar. Returns the URLs replaced, so their images can be
+//deleted
+func (u *User) SetAvatar(ctx context.Context, svc dynamodbiface.DynamoDBAPI,
+	tableName string, avatar map[string]string) (map[string]string, error) {
+
+	log.Debug().Msgf("Setting avatar of user: %s", u.Email)
+
+	input := &dynamodb.UpdateItemInput{
+		TableName: aws.String(tableName),
+		Key: map[string]*dynamodb.AttributeValue{
+			"pk": {S: aws.String(u.getUserPK())},
+			"sk": {S: aws.String(u.getProfileSK())},
+		},
+		ExpressionAttributeNames: map[string]*string{
+			"#V": aws.String("avatar"),
+		},
+		UpdateExpression:    aws.String("REMOVE #V"),
+		ConditionExpression: aws.String("attribute_exists(pk) AND attribute_exists(sk)"),
+		ReturnValues:        aws.String(dynamodb.ReturnValueUpdatedOld),
+	}
+
+	if len(avatar) > 0 {
+		value, err := dynamodbattribute.Marshal(avatar)
+		if err != nil {
+			return nil, err
+		}
+		input.ExpressionAttributeValues = map[string]*dynamodb.AttributeValue{
+			":avatar": value,
+		}
+		input.UpdateExpression = aws.String("SET #V = :avatar")
+	}
+
+	result, err := svc.UpdateItemWithContext(ctx, input)
+	if err != nil {
+		if isConditionalCheckFailed(err) {
+			return nil, errors.New(ErrorUserDoesNotExist)
+		}
+		return nil, err
+	}
+
+	var old struct {
+		Avatar map[string]string `dynamodbav:"avatar"`
+	}
+	if err := dynamodbattribute.UnmarshalMap(result.Attributes, &old); err != nil {
+		return nil, err
+	}
+
+	if len(avatar) > 0 {
+		u.Avatar = avatar
+	} else {
+		u.Avatar = nil
+	}
+
+	return old.Avatar, nil
+}
@@ -0,0 +1,173 @@
+package user
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/rs/zerolog/log"
+	"golang.org/x/net/idna"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/dynamodb"
+	"github.com/aws/aws-sdk-go/service/dynamodb/dynamodbiface"
+)
+
+//canonicalGmail strips the dots and the +tag of the Gmail addresses. It is
+//read from USERS_EMAIL_CANONICAL_GMAIL when the package is loaded, so every
+//function and the CLI build the same keys without declaring it
+var canonicalGmail = os.Getenv("USERS_EMAIL_CANONICAL_GMAIL") == "true"
+
+//gmailDomains are the domains of the Gmail addresses, the canonical one first
+var gmailDomains = []string{"gmail.com", "googlemail.com"}
+
+//CanonicalEmail returns the form of the email used in the keys, so the
+//spellings of the same address are the same user:
+// - the address is lowercased
+// - the domain is converted to its IDNA ASCII form
+// - with USERS_EMAIL_CANONICAL_GMAIL, the dots and the +tag of the Gmail
+//   addresses are removed and googlemail.com becomes gmail.com
+//The email the user typed is kept in the profile for display
+func CanonicalEmail(email string) string {
+
+	email = strings.ToLower(strings.TrimSpace(email))
+
+	at := strings.LastIndex(email, "@")
+	if at < 0 {
+		return email
+	}
+	local, domain := email[:at], strings.TrimSuffix(email[at+1:], ".")
+
+	if ascii, err := idna.Lookup.ToASCII(domain); err == nil {
+		domain = ascii
+	}
+
+	if canonicalGmail && (domain == gmailDomains[0] || domain == gmailDomains[1]) {
+		local = strings.SplitN(local, "+", 2)[0]
+		local = strings.ReplaceAll(local, ".", "")
+		domain = gmailDomains[0]
+	}
+
+	return local + "@" + domain
+}
+
+//Rekey moves every row of the partition of email, a key created before the
+//keys were canonical, to the partition of its canonical email. The profile is
+//copied first, unless another user has the canonical key, then the other rows
+//that do not exist there yet, so the rows written since by the user are kept.
+//The copies are stamped in migrated, so notifyUser does not send again their
+//messages. The profile row is deleted last, so Rekey can run again if it fails
+//halfway
+func Rekey(ctx context.Context, svc dynamodbiface.DynamoDBAPI, tableName,
+	email string) error {
+
+	from := fmt.Sprintf("%s#%s", DynamoDBPrefixUser, email)
+	to := fmt.Sprintf("%s#%s", DynamoDBPrefixUser, CanonicalEmail(email))
+	if from == to {
+		return nil
+	}
+
+	log.Info().Msgf("Moving %s to %s", from, to)
+
+	var items []map[string]*dynamodb.AttributeValue
+	err := svc.QueryPagesWithContext(ctx, &dynamodb.QueryInput{
+		TableName:              aws.String(tableName),
+		KeyConditionExpression: aws.String("pk = :pk"),
+		ExpressionAttributeValues: map[string]*dynamodb.AttributeValue{
+			":pk": {S: aws.String(from)},
+		},
+	}, func(page *dynamodb.QueryOutput, last bool) bool {
+		items = append(items, page.Items...)
+		return true
+	})
+	if err != nil {
+		return err
+	}
+
+	if len(items) == 0 {
+		return nil
+	}
+
+	migrated := time.Now().UTC().Format(time.RFC3339Nano)
+
+	var moved []map[string]*dynamodb.AttributeValue
+	var keys, profile []map[string]*dynamodb.AttributeValue
+	for _, item := range items {
+		key := map[string]*dynamodb.AttributeValue{"pk": item["pk"],
+			"sk": item["sk"]}
+
+		m := make(map[string]*dynamodb.AttributeValue, len(item)+1)
+		for k, v := range item {
+			m[k] = v
+		}
+		m["pk"] = &dynamodb.AttributeValue{S: aws.String(to)}
+		m[DynamoDBAttributeMigrated] = &dynamodb.AttributeValue{
+			S: aws.String(migrated)}
+
+		if aws.StringValue(item["sk"].S) == DynamoDBPrefixProfile+"#" {
+			profile = append(profile, key)
+			moved = append([]map[string]*dynamodb.AttributeValue{m}, moved...)
+		} else {
+			keys = append(keys, key)
+			moved = append(moved, m)
+		}
+	}
+
+	for _, m := range moved {
+		if err := putMoved(ctx, svc, tableName, m); err != nil {
+			return err
+		}
+	}
+
+	if err := batchDelete(ctx, svc, tableName, keys); err != nil {
+		return err
+	}
+
+	return batchDelete(ctx, svc, tableName, profile)
+}
+
+//putMoved writes the copy of a row in the canonical partition if it does not
+//exist there. The profile may exist when it is the copy of a previous run, of
+//the same user, otherwise ErrorDuplicateUser is returned
+func putMoved(ctx context.Context, svc dynamodbiface.DynamoDBAPI,
+	tableName string, item map[string]*dynamodb.AttributeValue) error {
+
+	input := &dynamodb.PutItemInput{
+		TableName:           aws.String(tableName),
+		Item:                item,
+		ConditionExpression: aws.String("attribute_not_exists(pk)"),
+	}
+
+	isProfile := aws.StringValue(item["sk"].S) == DynamoDBPrefixProfile+"#"
+	if isProfile && item["id"] != nil {
+		input.ConditionExpression = aws.String(
+			"attribute_not_exists(pk) OR #I = :id")
+		input.ExpressionAttributeNames = map[string]*string{
+			"#I": aws.String("id")}
+		input.ExpressionAttributeValues = map[string]*dynamodb.AttributeValue{
+			":id": item["id"]}
+	}
+
+	_, err := svc.PutItemWithContext(ctx, input)
+	if err == nil {
+		return nil
+	}
+
+	if !isConditionalCheckFailed(err) {
+		return err
+	}
+
+	if isProfile {
+		log.Warn().Msgf("%s belongs to another user",
+			aws.StringValue(item["pk"].S))
+		return errors.New(ErrorDuplicateUser)
+	}
+
+	log.Debug().Msgf("Keeping %s %s", aws.StringValue(item["pk"].S),
+		aws.StringValue(item["sk"].S))
+
+	return nil
+}
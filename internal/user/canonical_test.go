@@ -0,0 +1,175 @@
+package user
+
+import (
+	"context"
+	"errors"
+	"reflect"
+	"testing"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/awserr"
+	"github.com/aws/aws-sdk-go/aws/request"
+	"github.com/aws/aws-sdk-go/service/dynamodb"
+
+	"github.com/roloum/users/internal/test"
+)
+
+//TestCanonicalEmail Tests the canonical form of the emails
+func TestCanonicalEmail(t *testing.T) {
+
+	defer func(gmail bool) { canonicalGmail = gmail }(canonicalGmail)
+
+	tests := []struct {
+		desc     string
+		email    string
+		gmail    bool
+		expected string
+	}{
+		{desc: "Lowercase", email: " Foo@Example.COM ", expected: "foo@example.com"},
+		{desc: "IDNA", email: "hans@Bücher.de", expected: "hans@xn--bcher-kva.de"},
+		{desc: "TrailingDot", email: "foo@example.com.", expected: "foo@example.com"},
+		{desc: "GmailDisabled", email: "John.Smith+news@gmail.com",
+			expected: "john.smith+news@gmail.com"},
+		{desc: "Gmail", email: "John.Smith+news@gmail.com", gmail: true,
+			expected: "johnsmith@gmail.com"},
+		{desc: "Googlemail", email: "j.smith@GoogleMail.com", gmail: true,
+			expected: "jsmith@gmail.com"},
+		{desc: "NotGmail", email: "john.smith+news@acme.com", gmail: true,
+			expected: "john.smith+news@acme.com"},
+		{desc: "NoDomain", email: "Foo", expected: "foo"},
+	}
+
+	for _, tc := range tests {
+		t.Run(tc.desc, func(t *testing.T) {
+			canonicalGmail = tc.gmail
+			c := CanonicalEmail(tc.email)
+			if c != tc.expected {
+				t.Errorf("Expected: %v. Received: %v", tc.expected, c)
+			}
+			if again := CanonicalEmail(c); again != c {
+				t.Errorf("Expected: %v. Received: %v", c, again)
+			}
+		})
+	}
+}
+
+//recordingDynamoDB records the puts and batch writes sent to the mock.
+//putErrors are returned by the puts of the rows with those sort keys
+type recordingDynamoDB struct {
+	*test.MockDynamoDB
+	writes    []*dynamodb.WriteRequest
+	puts      []*dynamodb.PutItemInput
+	putErrors map[string]error
+}
+
+func (r *recordingDynamoDB) PutItemWithContext(ctx aws.Context,
+	input *dynamodb.PutItemInput, opts ...request.Option) (
+	*dynamodb.PutItemOutput, error) {
+	r.puts = append(r.puts, input)
+	if err := r.putErrors[aws.StringValue(input.Item["sk"].S)]; err != nil {
+		return nil, err
+	}
+	r.writes = append(r.writes, &dynamodb.WriteRequest{
+		PutRequest: &dynamodb.PutRequest{Item: input.Item}})
+	return &dynamodb.PutItemOutput{}, nil
+}
+
+func (r *recordingDynamoDB) BatchWriteItemWithContext(ctx aws.Context,
+	input *dynamodb.BatchWriteItemInput, opts ...request.Option) (
+	*dynamodb.BatchWriteItemOutput, error) {
+	for _, w := range input.RequestItems {
+		r.writes = append(r.writes, w...)
+	}
+	return r.MockDynamoDB.BatchWriteItemWithContext(ctx, input, opts...)
+}
+
+//TestRekey Tests moving the rows of a key to the canonical one
+func TestRekey(t *testing.T) {
+
+	mock := &recordingDynamoDB{MockDynamoDB: &test.MockDynamoDB{
+		QueryOutput: &dynamodb.QueryOutput{
+			Items: []map[string]*dynamodb.AttributeValue{
+				{"pk": {S: aws.String("USER#Foo@Example.com")},
+					"sk": {S: aws.String("TOKEN#1")}},
+				{
+					"pk":    {S: aws.String("USER#Foo@Example.com")},
+					"sk":    {S: aws.String("PROFILE#")},
+					"id":    {S: aws.String("1")},
+					"email": {S: aws.String("Foo@Example.com")},
+				},
+				{"pk": {S: aws.String("USER#Foo@Example.com")},
+					"sk": {S: aws.String("MAGIC#1")}},
+			},
+		},
+	}}
+
+	mock.putErrors = map[string]error{
+		"MAGIC#1": awserr.New(dynamodb.ErrCodeConditionalCheckFailedException,
+			"", nil)}
+
+	if err := Rekey(context.Background(), mock, UserTable,
+		"Foo@Example.com"); err != nil {
+		t.Fatal(err)
+	}
+	mock.putErrors = nil
+
+	var ops []string
+	for _, w := range mock.writes {
+		if w.PutRequest != nil {
+			ops = append(ops, "put "+aws.StringValue(w.PutRequest.Item["pk"].S)+
+				" "+aws.StringValue(w.PutRequest.Item["sk"].S))
+		} else {
+			ops = append(ops, "delete "+aws.StringValue(w.DeleteRequest.Key["pk"].S)+
+				" "+aws.StringValue(w.DeleteRequest.Key["sk"].S))
+		}
+	}
+
+	//The profile row is copied first and deleted last, the magic link
+	//written since in the canonical partition is kept
+	expected := []string{
+		"put USER#foo@example.com PROFILE#",
+		"put USER#foo@example.com TOKEN#1",
+		"delete USER#Foo@Example.com TOKEN#1",
+		"delete USER#Foo@Example.com MAGIC#1",
+		"delete USER#Foo@Example.com PROFILE#",
+	}
+	if !reflect.DeepEqual(ops, expected) {
+		t.Errorf("Expected: %v. Received: %v", expected, ops)
+	}
+
+	for _, put := range mock.puts {
+		if put.ConditionExpression == nil {
+			t.Errorf("Expected a condition. Received: %v", put)
+		}
+		if put.Item[DynamoDBAttributeMigrated] == nil {
+			t.Errorf("Expected the migrated stamp. Received: %v", put.Item)
+		}
+	}
+
+	t.Run(ErrorDuplicateUser, func(t *testing.T) {
+		mock.writes, mock.puts = nil, nil
+		mock.putErrors = map[string]error{
+			"PROFILE#": awserr.New(dynamodb.ErrCodeConditionalCheckFailedException,
+				"", nil)}
+		defer func() { mock.putErrors = nil }()
+
+		err := Rekey(context.Background(), mock, UserTable, "Foo@Example.com")
+		if !reflect.DeepEqual(err, errors.New(ErrorDuplicateUser)) {
+			t.Errorf("Expected: %v. Received: %v", ErrorDuplicateUser, err)
+		}
+		if len(mock.writes) != 0 {
+			t.Errorf("Expected no writes. Received: %v", mock.writes)
+		}
+	})
+
+	t.Run("Canonical", func(t *testing.T) {
+		mock.writes, mock.puts = nil, nil
+		if err := Rekey(context.Background(), mock, UserTable,
+			"foo@example.com"); err != nil {
+			t.Fatal(err)
+		}
+		if len(mock.writes) != 0 {
+			t.Errorf("Expected no writes. Received: %v", mock.writes)
+		}
+	})
+}
@@ -0,0 +1,221 @@
+package user
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/rs/zerolog/log"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/dynamodb"
+	"github.com/aws/aws-sdk-go/service/dynamodb/dynamodbattribute"
+	"github.com/aws/aws-sdk-go/service/dynamodb/dynamodbiface"
+)
+
+const (
+	//DynamoDBPrefixDelivery Prefix of the sort key of the delivery history rows
+	DynamoDBPrefixDelivery = "DELIVERY"
+
+	//DynamoDBTypeDelivery identifies the delivery history rows in dynamoDB
+	DynamoDBTypeDelivery = "Delivery"
+
+	//DeliveryTypeDelivery the email reached the mail server of the recipient
+	DeliveryTypeDelivery = "Delivery"
+
+	//DeliveryTypeBounce the email was rejected
+	DeliveryTypeBounce = "Bounce"
+
+	//DeliveryTypeComplaint the recipient marked the email as spam
+	DeliveryTypeComplaint = "Complaint"
+
+	//BounceTypePermanent a hard bounce: the address does not exist or never
+	//accepts email from us
+	BounceTypePermanent = "Permanent"
+)
+
+//Delivery is the outcome of an email sent to the user, as reported by the SES
+//delivery, bounce and complaint notifications
+type Delivery struct {
+	Email string `json:"email"`
+
+	//Type is stored as deliveryType, type is the type of row
+	Type          string `json:"type" dynamodbav:"deliveryType"`
+	BounceType    string `json:"bounceType,omitempty"`
+	BounceSubType string `json:"bounceSubType,omitempty"`
+	ComplaintType string `json:"complaintType,omitempty"`
+
+	//Diagnostic is the SMTP response of the receiving server
+	Diagnostic string `json:"diagnostic,omitempty"`
+
+	MessageID  string `json:"messageId"`
+	FeedbackID string `json:"feedbackId,omitempty"`
+	Timestamp  string `json:"timestamp"`
+}
+
+//Suppresses tells whether no more email must be sent to the address: after a
+//hard bounce or a complaint
+func (d *Delivery) Suppresses() bool {
+	return d.Type == DeliveryTypeComplaint ||
+		(d.Type == DeliveryTypeBounce && d.BounceType == BounceTypePermanent)
+}
+
+//RecordDelivery adds the delivery to the history of the user, kept for ttl,
+//and saves it as the emailStatus of the profile. Hard bounces and complaints
+//also set emailSuppressed, which stops the emails sent through notify
+func RecordDelivery(ctx context.Context, svc dynamodbiface.DynamoDBAPI,
+	tableName string, d *Delivery, ttl time.Duration) error {
+
+	log.Info().Msgf("Recording %s of %s for %s", d.Type, d.MessageID, d.Email)
+
+	u := &User{Email: d.Email}
+
+	item, err := dynamodbattribute.MarshalMap(d)
+	if err != nil {
+		return err
+	}
+	item["pk"] = &dynamodb.AttributeValue{S: aws.String(u.getUserPK())}
+	item["sk"] = &dynamodb.AttributeValue{S: aws.String(fmt.Sprintf("%s#%s#%s",
+		DynamoDBPrefixDelivery, d.Timestamp, d.MessageID))}
+	item["type"] = &dynamodb.AttributeValue{S: aws.String(DynamoDBTypeDelivery)}
+	item["ttl"] = &dynamodb.AttributeValue{
+		N: aws.String(fmt.Sprintf("%d", time.Now().Add(ttl).Unix()))}
+
+	update := &dynamodb.Update{
+		TableName: aws.String(tableName),
+		Key: map[string]*dynamodb.AttributeValue{
+			"pk": {S: aws.String(u.getUserPK())},
+			"sk": {S: aws.String(u.getProfileSK())},
+		},
+		ExpressionAttributeNames: map[string]*string{
+			"#S": aws.String("emailStatus"),
+			"#U": aws.String("emailStatusUpdated"),
+		},
+		ExpressionAttributeValues: map[string]*dynamodb.AttributeValue{
+			":status":  {S: aws.String(d.Type)},
+			":updated": {S: aws.String(d.Timestamp)},
+		},
+		UpdateExpression:    aws.String("SET #S = :status, #U = :updated"),
+		ConditionExpression: aws.String("attribute_exists(pk) AND attribute_exists(sk)"),
+	}
+	if d.Suppresses() {
+		update.ExpressionAttributeNames["#X"] = aws.String("emailSuppressed")
+		update.UpdateExpression = aws.String(
+			aws.StringValue(update.UpdateExpression) + ", #X = :status")
+	}
+
+	_, err = svc.TransactWriteItemsWithContext(ctx, &dynamodb.TransactWriteItemsInput{
+		TransactItems: []*dynamodb.TransactWriteItem{
+			{Update: update},
+			{Put: &dynamodb.Put{TableName: aws.String(tableName), Item: item}},
+		},
+	})
+	if err != nil {
+		//Only the condition on the profile cancels the transaction for good,
+		//throttling and conflicts are left to be retried
+		if isConditionCanceled(err) {
+			return errors.New(ErrorUserDoesNotExist)
+		}
+		return err
+	}
+
+	return nil
+}
+
+//Unsuppress allows the emails to the address again, once the user fixed it
+//or withdrew the complaint. The history and the emailStatus are kept
+func (u *User) Unsuppress(ctx context.Context, svc dynamodbiface.DynamoDBAPI,
+	tableName string) error {
+
+	log.Info().Msgf("Unsuppressing emails to %s", u.Email)
+
+	if u.Email == "" {
+		return errors.New("Email is not set")
+	}
+
+	_, err := svc.UpdateItemWithContext(ctx, &dynamodb.UpdateItemInput{
+		TableName: aws.String(tableName),
+		Key: map[string]*dynamodb.AttributeValue{
+			"pk": {S: aws.String(u.getUserPK())},
+			"sk": {S: aws.String(u.getProfileSK())},
+		},
+		ExpressionAttributeNames: map[string]*string{
+			"#X": aws.String("emailSuppressed"),
+		},
+		UpdateExpression:    aws.String("REMOVE #X"),
+		ConditionExpression: aws.String("attribute_exists(pk) AND attribute_exists(sk)"),
+	})
+	if err != nil {
+		if isConditionalCheckFailed(err) {
+			return errors.New(ErrorUserDoesNotExist)
+		}
+		return err
+	}
+
+	u.EmailSuppressed = ""
+
+	return nil
+}
+
+//Deliveries returns the delivery history of the user, the latest first
+func (u *User) Deliveries(ctx context.Context, svc dynamodbiface.DynamoDBAPI,
+	tableName string) ([]Delivery, error) {
+
+	if u.Email == "" {
+		return nil, errors.New("Email is not set")
+	}
+
+	deliveries := []Delivery{}
+
+	var uerr error
+	err := svc.QueryPagesWithContext(ctx, &dynamodb.QueryInput{
+		TableName:              aws.String(tableName),
+		KeyConditionExpression: aws.String("pk = :pk AND begins_with(sk, :sk)"),
+		ExpressionAttributeValues: map[string]*dynamodb.AttributeValue{
+			":pk": {S: aws.String(u.getUserPK())},
+			":sk": {S: aws.String(DynamoDBPrefixDelivery + "#")},
+		},
+		ScanIndexForward: aws.Bool(false),
+	}, func(page *dynamodb.QueryOutput, last bool) bool {
+		for _, item := range page.Items {
+			var d Delivery
+			if uerr = dynamodbattribute.UnmarshalMap(item, &d); uerr != nil {
+				return false
+			}
+			deliveries = append(deliveries, d)
+		}
+		return true
+	})
+	if err != nil {
+		return nil, err
+	}
+	if uerr != nil {
+		return nil, uerr
+	}
+
+	return deliveries, nil
+}
+
+//EmailSuppressed tells whether the emails to the address are suppressed after
+//a hard bounce or a complaint. Addresses of no user are not suppressed
+func EmailSuppressed(ctx context.Context, svc dynamodbiface.DynamoDBAPI,
+	tableName, email string) (bool, error) {
+
+	u := &User{Email: email}
+
+	result, err := svc.GetItemWithContext(ctx, &dynamodb.GetItemInput{
+		TableName: aws.String(tableName),
+		Key: map[string]*dynamodb.AttributeValue{
+			"pk": {S: aws.String(u.getUserPK())},
+			"sk": {S: aws.String(u.getProfileSK())},
+		},
+		ProjectionExpression: aws.String("emailSuppressed"),
+	})
+	if err != nil {
+		return false, err
+	}
+
+	s, ok := result.Item["emailSuppressed"]
+	return ok && aws.StringValue(s.S) != "", nil
+}
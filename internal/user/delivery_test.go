@@ -0,0 +1,161 @@
+package user
+
+import (
+	"context"
+	"errors"
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/awserr"
+	"github.com/aws/aws-sdk-go/service/dynamodb"
+
+	"github.com/roloum/users/internal/test"
+)
+
+//TestRecordDelivery Tests recording the deliveries and the suppression
+func TestRecordDelivery(t *testing.T) {
+
+	canceled := func(codes ...string) error {
+		var reasons []*dynamodb.CancellationReason
+		for _, c := range codes {
+			reasons = append(reasons, &dynamodb.CancellationReason{Code: aws.String(c)})
+		}
+		return &dynamodb.TransactionCanceledException{CancellationReasons: reasons}
+	}
+
+	tests := []struct {
+		desc       string
+		delivery   *Delivery
+		mockDBSvc  *test.MockDynamoDB
+		suppresses bool
+		err        error
+	}{
+		{
+			desc: "HardBounce",
+			delivery: &Delivery{Email: "test@user.com",
+				Type: DeliveryTypeBounce, BounceType: BounceTypePermanent},
+			mockDBSvc: &test.MockDynamoDB{
+				TransactWriteItemsOutput: &dynamodb.TransactWriteItemsOutput{}},
+			suppresses: true,
+		},
+		{
+			desc: "SoftBounce",
+			delivery: &Delivery{Email: "test@user.com",
+				Type: DeliveryTypeBounce, BounceType: "Transient"},
+			mockDBSvc: &test.MockDynamoDB{
+				TransactWriteItemsOutput: &dynamodb.TransactWriteItemsOutput{}},
+		},
+		{
+			desc: "Complaint",
+			delivery: &Delivery{Email: "test@user.com",
+				Type: DeliveryTypeComplaint},
+			mockDBSvc: &test.MockDynamoDB{
+				TransactWriteItemsOutput: &dynamodb.TransactWriteItemsOutput{}},
+			suppresses: true,
+		},
+		{
+			desc: ErrorUserDoesNotExist,
+			delivery: &Delivery{Email: "nobody@user.com",
+				Type: DeliveryTypeDelivery},
+			mockDBSvc: &test.MockDynamoDB{OutputError: canceled(
+				"ConditionalCheckFailed", "None")},
+			err: errors.New(ErrorUserDoesNotExist),
+		},
+		{
+			desc: "Throttled",
+			delivery: &Delivery{Email: "test@user.com",
+				Type: DeliveryTypeDelivery},
+			mockDBSvc: &test.MockDynamoDB{OutputError: canceled(
+				"ThrottlingError", "None")},
+			err: canceled("ThrottlingError", "None"),
+		},
+	}
+
+	for _, tc := range tests {
+		t.Run(tc.desc, func(t *testing.T) {
+			if tc.delivery.Suppresses() != tc.suppresses {
+				t.Errorf("Expected: %v. Received: %v", tc.suppresses,
+					tc.delivery.Suppresses())
+			}
+
+			err := RecordDelivery(context.Background(), tc.mockDBSvc, UserTable,
+				tc.delivery, time.Hour)
+			if !reflect.DeepEqual(err, tc.err) {
+				t.Errorf("Expected: %v. Received: %v", tc.err, err)
+			}
+		})
+	}
+}
+
+//TestEmailSuppressed Tests reading the suppression of an address
+func TestEmailSuppressed(t *testing.T) {
+
+	tests := []struct {
+		desc       string
+		item       map[string]*dynamodb.AttributeValue
+		suppressed bool
+	}{
+		{
+			desc: "Suppressed",
+			item: map[string]*dynamodb.AttributeValue{
+				"emailSuppressed": {S: aws.String(DeliveryTypeComplaint)},
+			},
+			suppressed: true,
+		},
+		{
+			desc: "NotSuppressed",
+			item: map[string]*dynamodb.AttributeValue{},
+		},
+		{
+			desc: "NoUser",
+		},
+	}
+
+	for _, tc := range tests {
+		t.Run(tc.desc, func(t *testing.T) {
+			mock := &test.MockDynamoDB{
+				GetItemOutput: &dynamodb.GetItemOutput{Item: tc.item}}
+
+			suppressed, err := EmailSuppressed(context.Background(), mock,
+				UserTable, "test@user.com")
+			if err != nil {
+				t.Fatalf("Expected: %v. Received: %v", nil, err)
+			}
+			if suppressed != tc.suppressed {
+				t.Errorf("Expected: %v. Received: %v", tc.suppressed, suppressed)
+			}
+		})
+	}
+}
+
+//TestUnsuppress Tests allowing the emails to an address again
+func TestUnsuppress(t *testing.T) {
+
+	tests := []struct {
+		desc string
+		mock *test.MockDynamoDB
+		err  error
+	}{
+		{desc: "Unsuppressed", mock: &test.MockDynamoDB{
+			UpdateItemOutput: &dynamodb.UpdateItemOutput{}}},
+		{desc: ErrorUserDoesNotExist, mock: &test.MockDynamoDB{
+			OutputError: awserr.New(
+				dynamodb.ErrCodeConditionalCheckFailedException, "", nil)},
+			err: errors.New(ErrorUserDoesNotExist)},
+	}
+
+	for _, tc := range tests {
+		t.Run(tc.desc, func(t *testing.T) {
+			u := &User{Email: "test@user.com", EmailSuppressed: DeliveryTypeComplaint}
+			err := u.Unsuppress(context.Background(), tc.mock, UserTable)
+			if !reflect.DeepEqual(err, tc.err) {
+				t.Errorf("Expected: %v. Received: %v", tc.err, err)
+			}
+			if err == nil && u.EmailSuppressed != "" {
+				t.Errorf("Expected: %v. Received: %v", "", u.EmailSuppressed)
+			}
+		})
+	}
+}
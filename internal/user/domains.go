@@ -0,0 +1,140 @@
+package user
+
+import (
+	"bufio"
+	"context"
+	"errors"
+	"net"
+	"os"
+	"strings"
+
+	"github.com/rs/zerolog/log"
+)
+
+//ValidationConfig configures the rules on the email domain. Domains match
+//their subdomains too, File lists more disposable domains, one per line
+type ValidationConfig struct {
+	Domains struct {
+		Blocked    []string
+		Disposable []string
+		File       string
+	}
+	MX bool
+}
+
+//MXChecker tells whether the domain accepts email
+type MXChecker interface {
+	HasMX(ctx context.Context, domain string) (bool, error)
+}
+
+//Rules are the rules on the email domain used by Validate
+type Rules struct {
+	Blocked    map[string]bool
+	Disposable map[string]bool
+	MX         MXChecker
+}
+
+//rules are the rules set with Configure, no domain is rejected by default
+var rules = &Rules{}
+
+//NetMX looks the MX records up with the default resolver
+type NetMX struct{}
+
+//HasMX implements MXChecker. A domain that does not exist has no MX, any
+//other DNS failure is returned
+func (NetMX) HasMX(ctx context.Context, domain string) (bool, error) {
+
+	mx, err := net.DefaultResolver.LookupMX(ctx, domain)
+	if err != nil {
+		var derr *net.DNSError
+		if errors.As(err, &derr) && derr.IsNotFound {
+			return false, nil
+		}
+		return false, err
+	}
+
+	return len(mx) > 0, nil
+}
+
+//NewRules builds the rules of the configuration, reading the file of
+//disposable domains
+func NewRules(cfg ValidationConfig) (*Rules, error) {
+
+	r := &Rules{
+		Blocked:    domainSet(cfg.Domains.Blocked),
+		Disposable: domainSet(cfg.Domains.Disposable),
+	}
+
+	if cfg.Domains.File != "" {
+		f, err := os.Open(cfg.Domains.File)
+		if err != nil {
+			return nil, err
+		}
+		defer f.Close()
+
+		scanner := bufio.NewScanner(f)
+		for scanner.Scan() {
+			line := strings.TrimSpace(scanner.Text())
+			if line == "" || strings.HasPrefix(line, "#") {
+				continue
+			}
+			r.Disposable[strings.ToLower(line)] = true
+		}
+		if err := scanner.Err(); err != nil {
+			return nil, err
+		}
+	}
+
+	if cfg.MX {
+		r.MX = NetMX{}
+	}
+
+	log.Debug().Msgf("Validation rules: %d blocked, %d disposable domains",
+		len(r.Blocked), len(r.Disposable))
+
+	return r, nil
+}
+
+//SetRules replaces the rules used by Validate
+func SetRules(r *Rules) {
+	if r == nil {
+		r = &Rules{}
+	}
+	rules = r
+}
+
+//Configure sets the rules of the configuration
+func Configure(cfg ValidationConfig) error {
+	r, err := NewRules(cfg)
+	if err != nil {
+		return err
+	}
+	SetRules(r)
+	return nil
+}
+
+//domainSet returns the set of the lowercased domains
+func domainSet(domains []string) map[string]bool {
+	set := make(map[string]bool, len(domains))
+	for _, d := range domains {
+		if d = strings.ToLower(strings.TrimSpace(d)); d != "" {
+			set[strings.TrimPrefix(d, "@")] = true
+		}
+	}
+	return set
+}
+
+//inDomains tells whether the domain or one of its parents is in the set
+func inDomains(set map[string]bool, domain string) bool {
+	for domain != "" {
+		if set[domain] {
+			return true
+		}
+		dot := strings.IndexByte(domain, '.')
+		if dot < 0 {
+			break
+		}
+		domain = domain[dot+1:]
+	}
+	return false
+}
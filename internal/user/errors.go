@@ -0,0 +1,76 @@
+package user
+
+import (
+	"errors"
+	"net/http"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/awserr"
+	"github.com/aws/aws-sdk-go/service/dynamodb"
+
+	"github.com/roloum/users/internal/apperr"
+)
+
+//init registers the HTTP status of the errors of the package
+func init() {
+	apperr.Register(http.StatusNotFound, ErrorUserDoesNotExist,
+		ErrorAPIKeyDoesNotExist, ErrorMFANotEnabled, ErrorAttributeDoesNotExist)
+
+	apperr.Register(http.StatusConflict, ErrorDuplicateUser,
+		ErrorUserAlreadyActive, ErrorActivateUser, ErrorMFAAlreadyEnabled,
+		ErrorLinkIdentity, ErrorSweepConflict, ErrorTenantMismatch)
+
+	apperr.Register(http.StatusUnprocessableEntity, ErrorFirstNameIsEmpty,
+		ErrorLastNameIsEmpty, ErrorEmailIsEmpty, ErrorInvalidEmail,
+		ErrorFirstNameTooLong, ErrorLastNameTooLong, ErrorEmailTooLong,
+		ErrorInvalidFirstName, ErrorInvalidLastName, ErrorBlockedEmailDomain,
+		ErrorDisposableEmailDomain, ErrorEmailDomainHasNoMX, ErrorInvalidField,
+		ErrorAPIKeyNameIsEmpty, ErrorSearchQueryTooShort,
+		ErrorAttributeIsRequired, ErrorInvalidAttribute, ErrorUnknownAttribute,
+		ErrorInvalidAttributeDefinition, ErrorUnknownNotification,
+		ErrorMandatoryNotification, ErrorInvalidLocale, ErrorInvalidTimezone,
+		ErrorUnknownChannel, ErrorPhoneNotVerified, ErrorInvalidPhone,
+		ErrorInvalidPhoneCode)
+
+	apperr.Register(http.StatusTooManyRequests, ErrorPhoneCodeAttempts,
+		ErrorPhoneCodeTooSoon, ErrorMFALocked)
+
+	apperr.Register(http.StatusBadRequest, ErrorInvalidCursor,
+		ErrorInvalidUnsubscribeToken, ErrorInvalidSweepSchedule)
+
+	apperr.Register(http.StatusUnauthorized, ErrorInvalidSession,
+		ErrorInvalidAPIKey, ErrorInvalidMagicLink, ErrorInvalidMFACode)
+
+	apperr.Register(http.StatusForbidden, ErrorEmailNotVerified,
+		ErrorAttributeNotWritable, ErrorUserDeactivated)
+}
+
+//isConditionalCheckFailed returns true when err is a failed condition expression
+func isConditionalCheckFailed(err error) bool {
+	if aerr, ok := err.(awserr.Error); ok {
+		return aerr.Code() == dynamodb.ErrCodeConditionalCheckFailedException
+	}
+	return false
+}
+
+//reasonConditionalCheckFailed is the cancellation reason of the items whose
+//condition failed
+const reasonConditionalCheckFailed = "ConditionalCheckFailed"
+
+//isConditionCanceled returns true when the transaction was canceled because
+//the condition of one of its items failed. Transactions canceled by
+//throttling, conflicts or validation errors return false, so the caller does
+//not mistake them for a domain error
+func isConditionCanceled(err error) bool {
+	var terr *dynamodb.TransactionCanceledException
+	if !errors.As(err, &terr) {
+		return false
+	}
+
+	for _, r := range terr.CancellationReasons {
+		if aws.StringValue(r.Code) == reasonConditionalCheckFailed {
+			return true
+		}
+	}
+	return false
+}
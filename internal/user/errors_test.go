@@ -0,0 +1,45 @@
+package user
+
+import (
+	"errors"
+	"testing"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/awserr"
+	"github.com/aws/aws-sdk-go/service/dynamodb"
+)
+
+//TestIsConditionCanceled Tests that only the transactions canceled by a
+//failed condition are mapped to domain errors
+func TestIsConditionCanceled(t *testing.T) {
+
+	canceled := func(codes ...string) error {
+		var reasons []*dynamodb.CancellationReason
+		for _, c := range codes {
+			reasons = append(reasons, &dynamodb.CancellationReason{Code: aws.String(c)})
+		}
+		return &dynamodb.TransactionCanceledException{CancellationReasons: reasons}
+	}
+
+	tests := []struct {
+		desc     string
+		err      error
+		expected bool
+	}{
+		{desc: "ConditionalCheckFailed", err: canceled("None",
+			"ConditionalCheckFailed"), expected: true},
+		{desc: "ThrottlingError", err: canceled("ThrottlingError", "None")},
+		{desc: "TransactionConflict", err: canceled("TransactionConflict")},
+		{desc: "NoReasons", err: awserr.New(
+			dynamodb.ErrCodeTransactionCanceledException, "", nil)},
+		{desc: "OtherError", err: errors.New("boom")},
+	}
+
+	for _, tc := range tests {
+		t.Run(tc.desc, func(t *testing.T) {
+			if received := isConditionCanceled(tc.err); received != tc.expected {
+				t.Errorf("Expected: %v. Received: %v", tc.expected, received)
+			}
+		})
+	}
+}
@@ -0,0 +1,156 @@
+package user
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/rs/zerolog/log"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/dynamodb"
+	"github.com/aws/aws-sdk-go/service/dynamodb/dynamodbattribute"
+	"github.com/aws/aws-sdk-go/service/dynamodb/dynamodbiface"
+)
+
+const (
+	//DynamoDBPrefixTombstone Prefix added to the keys of a tombstone row
+	DynamoDBPrefixTombstone = "TOMBSTONE"
+
+	//DynamoDBTypeTombstone identifies the tombstone rows in dynamoDB
+	DynamoDBTypeTombstone = "Tombstone"
+
+	//ArchiveRedacted replaces credential material in the exported archive
+	ArchiveRedacted = "REDACTED"
+)
+
+//redactedAttributes hold credential material: hashes of tokens and keys, the
+//sealed MFA secret and magic link, and the phone code. They are not personal
+//data and are redacted from the archive
+var redactedAttributes = []string{"hash", "secret", "secretHash",
+	"recoveryCodes", "verifier", "token", "code"}
+
+//redactedKeys are the prefixes of the rows whose sort key is a token or its
+//hash: the activation token, the magic links and the sessions. Only the
+//prefix of their sort key is exported
+var redactedKeys = []string{DynamoDBPrefixToken, DynamoDBPrefixMagic,
+	DynamoDBPrefixSession}
+
+//Archive contains every item stored in the user's partition
+type Archive struct {
+	Email    string                   `json:"email"`
+	Exported string                   `json:"exported"`
+	Items    []map[string]interface{} `json:"items"`
+}
+
+//Tombstone records that the data of an user was erased. The email is only
+//stored hashed
+type Tombstone struct {
+	EmailHash string `json:"emailHash"`
+	Erased    string `json:"erased"`
+	Reason    string `json:"reason"`
+}
+
+//Export returns every item stored in the user's partition: profile, tokens,
+//sessions, keys and any other row
+func (u *User) Export(ctx context.Context, svc dynamodbiface.DynamoDBAPI,
+	tableName string) (*Archive, error) {
+
+	log.Info().Msgf("Exporting user: %s", u.Email)
+
+	if u.Email == "" {
+		return nil, errors.New("Email is not set")
+	}
+
+	archive := &Archive{
+		Email:    u.Email,
+		Exported: time.Now().UTC().Format(time.RFC3339),
+		Items:    []map[string]interface{}{},
+	}
+
+	var uerr error
+	err := svc.QueryPagesWithContext(ctx, &dynamodb.QueryInput{
+		TableName:              aws.String(tableName),
+		KeyConditionExpression: aws.String("pk = :pk"),
+		ExpressionAttributeValues: map[string]*dynamodb.AttributeValue{
+			":pk": {S: aws.String(u.getUserPK())},
+		},
+	}, func(page *dynamodb.QueryOutput, last bool) bool {
+		for _, item := range page.Items {
+			var m map[string]interface{}
+			if uerr = dynamodbattribute.UnmarshalMap(item, &m); uerr != nil {
+				return false
+			}
+			redact(m)
+			archive.Items = append(archive.Items, m)
+		}
+		return true
+	})
+	if err != nil {
+		return nil, err
+	}
+	if uerr != nil {
+		return nil, uerr
+	}
+
+	if len(archive.Items) == 0 {
+		return nil, errors.New(ErrorUserDoesNotExist)
+	}
+
+	return archive, nil
+}
+
+//redact replaces the credential material of the exported item
+func redact(m map[string]interface{}) {
+	for _, a := range redactedAttributes {
+		if _, ok := m[a]; ok {
+			m[a] = ArchiveRedacted
+		}
+	}
+
+	sk, _ := m["sk"].(string)
+	for _, prefix := range redactedKeys {
+		if strings.HasPrefix(sk, prefix+"#") {
+			m["sk"] = fmt.Sprintf("%s#%s", prefix, ArchiveRedacted)
+		}
+	}
+}
+
+//Erase deletes every item in the user's partition and records a tombstone
+//with the hashed email, the date and the reason of the erasure
+func (u *User) Erase(ctx context.Context, svc dynamodbiface.DynamoDBAPI,
+	tableName, reason string) error {
+
+	log.Info().Msgf("Erasing user: %s", u.Email)
+
+	if err := u.Delete(ctx, svc, tableName); err != nil {
+		return err
+	}
+
+	hash := HashEmail(u.Email)
+
+	_, err := svc.PutItemWithContext(ctx, &dynamodb.PutItemInput{
+		TableName: aws.String(tableName),
+		Item: map[string]*dynamodb.AttributeValue{
+			"pk":        {S: aws.String(fmt.Sprintf("%s#%s", DynamoDBPrefixTombstone, hash))},
+			"sk":        {S: aws.String(fmt.Sprintf("%s#%s", DynamoDBPrefixTombstone, time.Now().UTC().Format(time.RFC3339Nano)))},
+			"emailHash": {S: aws.String(hash)},
+			"erased":    {S: aws.String(time.Now().UTC().Format(time.RFC3339))},
+			"reason":    {S: aws.String(reason)},
+			"type":      {S: aws.String(DynamoDBTypeTombstone)},
+		},
+	})
+
+	return err
+}
+
+//HashEmail returns the hash under which the tombstone of an email is stored,
+//so support can verify an erasure without keeping the address
+func HashEmail(email string) string {
+	sum := sha256.Sum256([]byte(strings.ToLower(email)))
+	return hex.EncodeToString(sum[:])
+}
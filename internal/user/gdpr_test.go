@@ -0,0 +1,104 @@
+package user
+
+import (
+	"context"
+	"errors"
+	"reflect"
+	"testing"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/dynamodb"
+
+	"github.com/roloum/users/internal/test"
+)
+
+//TestExport Tests the Export functionality
+func TestExport(t *testing.T) {
+
+	t.Run("RedactsCredentials", func(t *testing.T) {
+		mock := &test.MockDynamoDB{QueryOutput: &dynamodb.QueryOutput{
+			Items: []map[string]*dynamodb.AttributeValue{
+				{
+					"pk":    {S: aws.String("USER#test@user.com")},
+					"sk":    {S: aws.String("PROFILE#")},
+					"email": {S: aws.String("test@user.com")},
+				},
+				{
+					"pk":   {S: aws.String("USER#test@user.com")},
+					"sk":   {S: aws.String("APIKEY#abcd")},
+					"hash": {S: aws.String("1234")},
+				},
+			},
+		}}
+
+		u := &User{Email: "test@user.com"}
+		archive, err := u.Export(context.Background(), mock, UserTable)
+		if err != nil {
+			t.Fatalf("Unexpected error: %v", err)
+		}
+
+		if len(archive.Items) != 2 {
+			t.Fatalf("Expected: 2 items. Received: %d", len(archive.Items))
+		}
+		if archive.Items[1]["hash"] != ArchiveRedacted {
+			t.Errorf("Expected: %v. Received: %v", ArchiveRedacted,
+				archive.Items[1]["hash"])
+		}
+	})
+
+	t.Run("RedactsTokens", func(t *testing.T) {
+		mock := &test.MockDynamoDB{QueryOutput: &dynamodb.QueryOutput{
+			Items: []map[string]*dynamodb.AttributeValue{
+				{
+					"pk": {S: aws.String("USER#test@user.com")},
+					"sk": {S: aws.String("TOKEN#1234")},
+				},
+				{
+					"pk":    {S: aws.String("USER#test@user.com")},
+					"sk":    {S: aws.String("MAGIC#abcd")},
+					"token": {S: aws.String("sealed")},
+				},
+				{
+					"pk":  {S: aws.String("USER#test@user.com")},
+					"sk":  {S: aws.String("SESSION#abcd")},
+					"ttl": {N: aws.String("1")},
+				},
+				{
+					"pk":    {S: aws.String("USER#test@user.com")},
+					"sk":    {S: aws.String("PHONE#")},
+					"phone": {S: aws.String("+15555550100")},
+					"code":  {S: aws.String("123456")},
+				},
+			},
+		}}
+
+		u := &User{Email: "test@user.com"}
+		archive, err := u.Export(context.Background(), mock, UserTable)
+		if err != nil {
+			t.Fatalf("Unexpected error: %v", err)
+		}
+
+		expected := []map[string]interface{}{
+			{"pk": "USER#test@user.com", "sk": "TOKEN#REDACTED"},
+			{"pk": "USER#test@user.com", "sk": "MAGIC#REDACTED",
+				"token": ArchiveRedacted},
+			{"pk": "USER#test@user.com", "sk": "SESSION#REDACTED",
+				"ttl": float64(1)},
+			{"pk": "USER#test@user.com", "sk": "PHONE#", "phone": "+15555550100",
+				"code": ArchiveRedacted},
+		}
+		if !reflect.DeepEqual(archive.Items, expected) {
+			t.Errorf("Expected: %v. Received: %v", expected, archive.Items)
+		}
+	})
+
+	t.Run(ErrorUserDoesNotExist, func(t *testing.T) {
+		mock := &test.MockDynamoDB{QueryOutput: &dynamodb.QueryOutput{}}
+
+		u := &User{Email: "test@user.com"}
+		_, err := u.Export(context.Background(), mock, UserTable)
+		if !reflect.DeepEqual(err, errors.New(ErrorUserDoesNotExist)) {
+			t.Errorf("Expected: %v. Received: %v", ErrorUserDoesNotExist, err)
+		}
+	})
+}
@@ -0,0 +1,231 @@
+package user
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/rs/zerolog/log"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/dynamodb"
+	"github.com/aws/aws-sdk-go/service/dynamodb/dynamodbiface"
+
+	"github.com/google/uuid"
+)
+
+const (
+	//DynamoDBPrefixIdentity Prefix added to the sort key of an external
+	//identity row
+	DynamoDBPrefixIdentity = "IDENTITY"
+
+	//DynamoDBTypeIdentity identifies the external identity rows in dynamoDB
+	DynamoDBTypeIdentity = "Identity"
+
+	//DynamoDBIndexInverted Global secondary index with sk as partition key and
+	//pk as sort key. Used to find the user owning a row
+	DynamoDBIndexInverted = "InvertedIndex"
+
+	//ErrorEmailNotVerified Returned when signing in with an identity that is
+	//not linked and whose email is not verified by the provider
+	ErrorEmailNotVerified = "EmailNotVerified"
+
+	//ErrorLinkIdentity Returned when the transaction linking the identity did
+	//not succeed
+	ErrorLinkIdentity = "CouldNotLinkIdentity"
+)
+
+//Identity is an account of the user at an external identity provider
+type Identity struct {
+	Provider      string
+	Subject       string
+	Email         string
+	EmailVerified bool
+	FirstName     string
+	LastName      string
+}
+
+//FindByIdentity returns the user linked to the provider subject
+func FindByIdentity(ctx context.Context, svc dynamodbiface.DynamoDBAPI,
+	tableName, provider, subject string) (*User, error) {
+
+	log.Debug().Msgf("Finding identity: %s %s", provider, subject)
+
+	result, err := svc.QueryWithContext(ctx, &dynamodb.QueryInput{
+		TableName:              aws.String(tableName),
+		IndexName:              aws.String(DynamoDBIndexInverted),
+		KeyConditionExpression: aws.String("sk = :sk"),
+		ExpressionAttributeValues: map[string]*dynamodb.AttributeValue{
+			":sk": {S: aws.String(getIdentitySK(provider, subject))},
+		},
+		Limit: aws.Int64(1),
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	if len(result.Items) == 0 {
+		return nil, errors.New(ErrorUserDoesNotExist)
+	}
+
+	pk := aws.StringValue(result.Items[0]["pk"].S)
+
+	return &User{
+		Email: strings.TrimPrefix(pk, DynamoDBPrefixUser+"#"),
+	}, nil
+}
+
+//SignInWithIdentity signs in the user owning the identity and returns a new
+//session. An identity that is not linked yet is linked to the user with the
+//same email, provided the email is verified by the provider, creating the user
+//if necessary. New users are validated like the ones created with Create.
+//Both new and pending users are activated, since the provider vouches for the
+//email, but users deactivated by an administrator are not. Users with MFA
+//enabled get a session that requires the MFA code, see CompleteMFA
+func SignInWithIdentity(ctx context.Context, svc dynamodbiface.DynamoDBAPI,
+	tableName string, id *Identity, sessionTTL time.Duration) (*User, *Session,
+	error) {
+
+	log.Info().Msgf("Signing in with identity: %s %s", id.Provider, id.Subject)
+
+	if tableName == "" {
+		return nil, nil, errors.New(ErrorUserTableNameIsEmpty)
+	}
+
+	u, err := FindByIdentity(ctx, svc, tableName, id.Provider, id.Subject)
+	if err == nil {
+		if err := u.Load(ctx, svc, tableName); err != nil {
+			return nil, nil, err
+		}
+
+		//Linking the identity activated the user, so an inactive one was
+		//deactivated since
+		if !u.Active {
+			return nil, nil, errors.New(ErrorUserDeactivated)
+		}
+
+		session, hash, err := u.signInSession(ctx, svc, tableName, sessionTTL)
+		if err != nil {
+			return nil, nil, err
+		}
+
+		put := u.sessionPut(session, hash, tableName)
+		if _, err := svc.PutItemWithContext(ctx, &dynamodb.PutItemInput{
+			TableName:           put.TableName,
+			Item:                put.Item,
+			ConditionExpression: put.ConditionExpression,
+		}); err != nil {
+			return nil, nil, err
+		}
+		return u, session, nil
+	} else if err.Error() != ErrorUserDoesNotExist {
+		return nil, nil, err
+	}
+
+	if !id.EmailVerified {
+		return nil, nil, errors.New(ErrorEmailNotVerified)
+	}
+
+	nu := &NewUser{Email: id.Email, FirstName: id.FirstName,
+		LastName: id.LastName}
+	Normalize(nu)
+
+	if err := validate.Var(nu.Email, "required,validEmail"); err != nil {
+		return nil, nil, errors.New(ErrorInvalidEmail)
+	}
+
+	u = &User{Email: nu.Email}
+
+	var items []*dynamodb.TransactWriteItem
+
+	err = u.Load(ctx, svc, tableName)
+	switch {
+	case err != nil && err.Error() == ErrorUserDoesNotExist:
+		log.Debug().Msg("Creating user from identity")
+
+		schema, err := GetSchema(ctx, svc, tableName)
+		if err != nil {
+			return nil, nil, err
+		}
+
+		//The provider does not know the custom attributes
+		if err := validateWithSchema(ctx, nu, schema, false); err != nil {
+			return nil, nil, err
+		}
+
+		u = &User{
+			Email:     nu.Email,
+			ID:        uuid.New().String(),
+			FirstName: nu.FirstName,
+			LastName:  nu.LastName,
+			Active:    true,
+			Created:   time.Now().Format("2006-01-02"),
+		}
+		items = append(items, &dynamodb.TransactWriteItem{
+			Put: u.profilePut(tableName),
+		})
+		items = append(items, u.namePuts(tableName)...)
+	case err != nil:
+		return nil, nil, err
+	case !u.Active:
+		pending, err := u.pending(ctx, svc, tableName)
+		if err != nil {
+			return nil, nil, err
+		}
+		if !pending {
+			return nil, nil, errors.New(ErrorUserDeactivated)
+		}
+
+		log.Debug().Msg("Activating user with identity")
+
+		items = append(items, u.activateItems(tableName)...)
+		u.Active = true
+	}
+
+	session, hash, err := u.signInSession(ctx, svc, tableName, sessionTTL)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	items = append(items,
+		&dynamodb.TransactWriteItem{
+			Put: &dynamodb.Put{
+				TableName: aws.String(tableName),
+				Item: map[string]*dynamodb.AttributeValue{
+					"pk":       {S: aws.String(u.getUserPK())},
+					"sk":       {S: aws.String(getIdentitySK(id.Provider, id.Subject))},
+					"provider": {S: aws.String(id.Provider)},
+					"subject":  {S: aws.String(id.Subject)},
+					"email":    {S: aws.String(u.Email)},
+					"created":  {S: aws.String(time.Now().Format("2006-01-02"))},
+					"type":     {S: aws.String(DynamoDBTypeIdentity)},
+				},
+				ConditionExpression: aws.String("attribute_not_exists(pk) and attribute_not_exists(sk)"),
+			},
+		},
+		&dynamodb.TransactWriteItem{
+			Put: u.sessionPut(session, hash, tableName),
+		})
+
+	result, err := svc.TransactWriteItemsWithContext(ctx,
+		&dynamodb.TransactWriteItemsInput{TransactItems: items})
+	if err != nil {
+
+		log.Debug().Msg(err.Error())
+
+		if isConditionCanceled(err) {
+			return nil, nil, errors.New(ErrorLinkIdentity)
+		}
+		return nil, nil, err
+	}
+
+	log.Debug().Msgf("Result: %+v", result)
+
+	return u, session, nil
+}
+
+func getIdentitySK(provider, subject string) string {
+	return fmt.Sprintf("%s#%s#%s", DynamoDBPrefixIdentity, provider, subject)
+}
@@ -0,0 +1,138 @@
+package user
+
+import (
+	"context"
+	"errors"
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/dynamodb"
+
+	"github.com/roloum/users/internal/apperr"
+	"github.com/roloum/users/internal/test"
+)
+
+//TestSignInWithIdentity Tests the SignInWithIdentity functionality
+func TestSignInWithIdentity(t *testing.T) {
+
+	tests := []struct {
+		desc      string
+		identity  *Identity
+		mockDBSvc *test.MockDynamoDB
+		err       error
+		tableName string
+		mfa       bool
+	}{
+		{
+			desc: ErrorEmailNotVerified,
+			identity: &Identity{
+				Provider: "google",
+				Subject:  "1234",
+				Email:    "test@user.com",
+			},
+			mockDBSvc: &test.MockDynamoDB{QueryOutput: &dynamodb.QueryOutput{}},
+			err:       errors.New(ErrorEmailNotVerified),
+			tableName: UserTable,
+		},
+		{
+			desc: ErrorInvalidEmail,
+			identity: &Identity{
+				Provider:      "google",
+				Subject:       "1234",
+				Email:         "yadayadayada",
+				EmailVerified: true,
+			},
+			mockDBSvc: &test.MockDynamoDB{QueryOutput: &dynamodb.QueryOutput{}},
+			err:       errors.New(ErrorInvalidEmail),
+			tableName: UserTable,
+		},
+		{
+			desc: apperr.ErrorValidation,
+			identity: &Identity{
+				Provider:      "google",
+				Subject:       "1234",
+				Email:         " new@user.com ",
+				EmailVerified: true,
+				LastName:      "User",
+			},
+			mockDBSvc: &test.MockDynamoDB{
+				QueryOutput:   &dynamodb.QueryOutput{},
+				GetItemOutput: &dynamodb.GetItemOutput{},
+			},
+			err: apperr.Validation(apperr.FieldError{Field: "firstName",
+				Code: ErrorFirstNameIsEmpty}),
+			tableName: UserTable,
+		},
+		{
+			desc: "MFARequired",
+			identity: &Identity{
+				Provider: "google",
+				Subject:  "1234",
+				Email:    "test@user.com",
+			},
+			mockDBSvc: &test.MockDynamoDB{
+				QueryOutput: &dynamodb.QueryOutput{
+					Items: []map[string]*dynamodb.AttributeValue{
+						{"pk": {S: aws.String("USER#test@user.com")}},
+					},
+				},
+				GetItemOutput: &dynamodb.GetItemOutput{
+					Item: map[string]*dynamodb.AttributeValue{
+						"email":     {S: aws.String("test@user.com")},
+						"active":    {BOOL: aws.Bool(true)},
+						"confirmed": {BOOL: aws.Bool(true)},
+					},
+				},
+				PutItemOutput: &dynamodb.PutItemOutput{},
+			},
+			tableName: UserTable,
+			mfa:       true,
+		},
+		{
+			desc: ErrorUserDeactivated,
+			identity: &Identity{
+				Provider: "google",
+				Subject:  "1234",
+				Email:    "test@user.com",
+			},
+			mockDBSvc: &test.MockDynamoDB{
+				QueryOutput: &dynamodb.QueryOutput{
+					Items: []map[string]*dynamodb.AttributeValue{
+						{"pk": {S: aws.String("USER#test@user.com")}},
+					},
+				},
+				GetItemOutput: &dynamodb.GetItemOutput{
+					Item: map[string]*dynamodb.AttributeValue{
+						"email":  {S: aws.String("test@user.com")},
+						"active": {BOOL: aws.Bool(false)},
+					},
+				},
+			},
+			err:       errors.New(ErrorUserDeactivated),
+			tableName: UserTable,
+		},
+		{
+			desc:      ErrorUserTableNameIsEmpty,
+			identity:  &Identity{},
+			mockDBSvc: &test.MockDynamoDB{},
+			err:       errors.New(ErrorUserTableNameIsEmpty),
+			tableName: "",
+		},
+	}
+
+	for _, tc := range tests {
+		t.Run(tc.desc, func(t *testing.T) {
+			_, session, err := SignInWithIdentity(context.Background(),
+				tc.mockDBSvc, tc.tableName, tc.identity, time.Hour)
+			if !reflect.DeepEqual(err, tc.err) {
+				t.Errorf("Expected: %v. Received: %v", tc.err, err)
+			}
+			if session != nil && session.MFARequired != tc.mfa {
+				t.Errorf("Expected: %v. Received: %v", tc.mfa,
+					session.MFARequired)
+			}
+		})
+	}
+}
@@ -0,0 +1,220 @@
+package user
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strconv"
+	"time"
+
+	"github.com/rs/zerolog/log"
+
+	"github.com/aws/aws-lambda-go/events"
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/dynamodb"
+	"github.com/aws/aws-sdk-go/service/dynamodb/dynamodbiface"
+
+	"github.com/roloum/users/internal/seal"
+)
+
+const (
+	//DynamoDBPrefixMagic Prefix added to the sort key of a magic link row
+	DynamoDBPrefixMagic = "MAGIC"
+
+	//DynamoDBTypeMagic identifies the magic link rows in dynamoDB
+	DynamoDBTypeMagic = "MagicLink"
+
+	//ErrorInvalidMagicLink Returned when the magic link does not exist, has
+	//already been used or has expired
+	ErrorInvalidMagicLink = "InvalidMagicLink"
+)
+
+//MagicLink contains the information needed to mail a magic link. The sort key
+//of the row is the hash of the token, Token is sealed so only the notify
+//handler, with the key, can open it
+type MagicLink struct {
+	Email string `json:"email"`
+	Token string `json:"token"`
+
+	//TTL is when the link expires, in Unix time
+	TTL int64 `json:"ttl"`
+}
+
+//OpenToken returns the token of the link, sealed with key
+func (m *MagicLink) OpenToken(key string) (string, error) {
+	token, err := seal.Open(key, m.Token)
+	if err != nil {
+		return "", err
+	}
+	return string(token), nil
+}
+
+//IsUserMagicKeys verifies that pk and sk correspond to a User's magic link row
+func IsUserMagicKeys(keys map[string]events.DynamoDBAttributeValue) bool {
+	magicKeys := isUserKeys(DynamoDBPrefixUser, DynamoDBPrefixMagic, keys)
+
+	log.Debug().Msgf("IsUserMagicKeys: %v", magicKeys)
+
+	return magicKeys
+}
+
+//RequestMagicLink inserts a magic link row that expires after ttl, with the
+//token sealed with key. The notify handler mails the link when the row shows
+//up in the stream
+func (u *User) RequestMagicLink(ctx context.Context,
+	svc dynamodbiface.DynamoDBAPI, tableName, key string,
+	ttl time.Duration) error {
+
+	log.Debug().Msgf("Requesting magic link: %s", u.Email)
+
+	if err := u.Load(ctx, svc, tableName); err != nil {
+		return err
+	}
+
+	token, err := randomToken(32)
+	if err != nil {
+		return err
+	}
+
+	sealed, err := seal.Seal(key, []byte(token))
+	if err != nil {
+		return err
+	}
+
+	expires := time.Now().Add(ttl)
+
+	result, err := svc.PutItemWithContext(ctx, &dynamodb.PutItemInput{
+		TableName: aws.String(tableName),
+		Item: map[string]*dynamodb.AttributeValue{
+			"pk":    {S: aws.String(u.getUserPK())},
+			"sk":    {S: aws.String(getMagicSK(token))},
+			"email": {S: aws.String(u.Email)},
+			"token": {S: aws.String(sealed)},
+			"ttl":   {N: aws.String(strconv.FormatInt(expires.Unix(), 10))},
+			"type":  {S: aws.String(DynamoDBTypeMagic)},
+		},
+		ConditionExpression: aws.String("attribute_not_exists(pk) and attribute_not_exists(sk)"),
+	})
+	if err != nil {
+		return err
+	}
+
+	log.Debug().Msgf("Result: %+v", result)
+
+	return nil
+}
+
+//CheckMagicLink verifies that the magic link exists and has not expired,
+//without consuming it. Callers check the link first, so the state of the
+//account is only revealed to who received the link
+func (u *User) CheckMagicLink(ctx context.Context,
+	svc dynamodbiface.DynamoDBAPI, tableName, token string) error {
+
+	if u.Email == "" {
+		return errors.New("Email is not set")
+	}
+
+	result, err := svc.GetItemWithContext(ctx, &dynamodb.GetItemInput{
+		TableName: aws.String(tableName),
+		Key: map[string]*dynamodb.AttributeValue{
+			"pk": {S: aws.String(u.getUserPK())},
+			"sk": {S: aws.String(getMagicSK(token))},
+		},
+		ProjectionExpression:     aws.String("#T"),
+		ExpressionAttributeNames: map[string]*string{"#T": aws.String("ttl")},
+	})
+	if err != nil {
+		return err
+	}
+
+	ttl, ok := result.Item["ttl"]
+	if !ok {
+		return errors.New(ErrorInvalidMagicLink)
+	}
+	expires, err := strconv.ParseInt(aws.StringValue(ttl.N), 10, 64)
+	if err != nil || expires <= time.Now().Unix() {
+		return errors.New(ErrorInvalidMagicLink)
+	}
+
+	return nil
+}
+
+//ExchangeMagicLink consumes the magic link and creates a session. The link can
+//only be exchanged once, and since it proves the ownership of the email it
+//also activates an account waiting for the activation. Accounts deactivated by
+//an administrator are not reactivated. Accounts with MFA enabled get a session
+//waiting for the code, exchanged with CompleteMFA
+func (u *User) ExchangeMagicLink(ctx context.Context,
+	svc dynamodbiface.DynamoDBAPI, tableName, token string,
+	sessionTTL time.Duration) (*Session, error) {
+
+	log.Debug().Msgf("Exchanging magic link: %s", u.Email)
+
+	if err := u.Load(ctx, svc, tableName); err != nil {
+		return nil, err
+	}
+
+	session, hash, err := u.signInSession(ctx, svc, tableName, sessionTTL)
+	if err != nil {
+		return nil, err
+	}
+
+	items := []*dynamodb.TransactWriteItem{
+		{
+			Delete: &dynamodb.Delete{
+				TableName: aws.String(tableName),
+				Key: map[string]*dynamodb.AttributeValue{
+					"pk": {S: aws.String(u.getUserPK())},
+					"sk": {S: aws.String(getMagicSK(token))},
+				},
+				ExpressionAttributeNames: map[string]*string{
+					"#T": aws.String("ttl"),
+				},
+				ExpressionAttributeValues: map[string]*dynamodb.AttributeValue{
+					":now": {N: aws.String(strconv.FormatInt(time.Now().Unix(), 10))},
+				},
+				ConditionExpression: aws.String("attribute_exists(pk) AND #T > :now"),
+			},
+		},
+		{
+			Put: u.sessionPut(session, hash, tableName),
+		},
+	}
+
+	if !u.Active {
+		pending, err := u.pending(ctx, svc, tableName)
+		if err != nil {
+			return nil, err
+		}
+		if !pending {
+			return nil, errors.New(ErrorUserDeactivated)
+		}
+
+		log.Debug().Msg("Activating user with magic link")
+
+		items = append(items, u.activateItems(tableName)...)
+	}
+
+	result, err := svc.TransactWriteItemsWithContext(ctx,
+		&dynamodb.TransactWriteItemsInput{TransactItems: items})
+	if err != nil {
+
+		log.Debug().Msg(err.Error())
+
+		if isConditionCanceled(err) {
+			return nil, errors.New(ErrorInvalidMagicLink)
+		}
+		return nil, err
+	}
+
+	log.Debug().Msgf("Result: %+v", result)
+
+	u.Active = true
+
+	return session, nil
+}
+
+//getMagicSK forms the sort key of the magic link with the hash of the token
+func getMagicSK(token string) string {
+	return fmt.Sprintf("%s#%s", DynamoDBPrefixMagic, hashToken(token))
+}
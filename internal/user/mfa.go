@@ -0,0 +1,480 @@
+package user
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base32"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/rs/zerolog/log"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/dynamodb"
+	"github.com/aws/aws-sdk-go/service/dynamodb/dynamodbattribute"
+	"github.com/aws/aws-sdk-go/service/dynamodb/dynamodbiface"
+	qrcode "github.com/skip2/go-qrcode"
+
+	"github.com/roloum/users/internal/seal"
+	"github.com/roloum/users/internal/totp"
+)
+
+const (
+	//DynamoDBPrefixMFA Prefix added to the sort key of the MFA row
+	DynamoDBPrefixMFA = "MFA"
+
+	//DynamoDBTypeMFA identifies the MFA row in dynamoDB
+	DynamoDBTypeMFA = "MFA"
+
+	//MFARecoveryCodes number of recovery codes generated on confirmation
+	MFARecoveryCodes = 10
+
+	//MFAQRCodeSize size in pixels of the enrollment QR code
+	MFAQRCodeSize = 256
+
+	//ErrorMFAAlreadyEnabled Returned when enrolling or confirming an account
+	//that already has MFA enabled
+	ErrorMFAAlreadyEnabled = "MFAAlreadyEnabled"
+
+	//ErrorMFANotEnabled Returned when the account has not enrolled or
+	//confirmed MFA
+	ErrorMFANotEnabled = "MFANotEnabled"
+
+	//ErrorInvalidMFACode Returned when the TOTP or recovery code is not valid,
+	//or the TOTP code was already used
+	ErrorInvalidMFACode = "InvalidMFACode"
+
+	//ErrorMFALocked Returned when too many codes failed and the account is
+	//locked out of MFA for a while
+	ErrorMFALocked = "MFALocked"
+
+	//MFAMaxAttempts number of codes that can be tried before the lockout
+	MFAMaxAttempts = 5
+
+	//MFALockout time the account is locked out of MFA after MFAMaxAttempts
+	MFALockout = 15 * time.Minute
+)
+
+//MFAEnrollment contains the information the user needs to add the account to
+//an authenticator app
+type MFAEnrollment struct {
+	Secret string `json:"secret"`
+	URI    string `json:"uri"`
+	QRCode []byte `json:"qrCode"`
+}
+
+//mfa represents the MFA row. The secret is sealed and the recovery codes are
+//stored as SHA-256 hashes
+type mfa struct {
+	Secret        string   `json:"secret"`
+	Confirmed     bool     `json:"confirmed"`
+	RecoveryCodes []string `dynamodbav:"recoveryCodes,stringset,omitempty"`
+	Created       string   `json:"created"`
+
+	//LastStep is the time step of the last TOTP code accepted, older or equal
+	//steps are rejected so a code is only used once
+	LastStep int64 `json:"lastStep"`
+
+	//Attempts are the codes tried since the last accepted one, LockedUntil is
+	//set once they reach MFAMaxAttempts
+	Attempts    int    `json:"attempts"`
+	LockedUntil string `json:"lockedUntil"`
+}
+
+//EnrollMFA generates a TOTP secret for the user and stores it, unconfirmed,
+//in the MFA row. Enrolling again before confirming replaces the secret
+func (u *User) EnrollMFA(ctx context.Context, svc dynamodbiface.DynamoDBAPI,
+	tableName, issuer, key string) (*MFAEnrollment, error) {
+
+	log.Debug().Msgf("Enrolling MFA: %s", u.Email)
+
+	if err := u.Load(ctx, svc, tableName); err != nil {
+		return nil, err
+	}
+
+	secret, err := totp.GenerateSecret()
+	if err != nil {
+		return nil, err
+	}
+
+	sealed, err := seal.Seal(key, []byte(secret))
+	if err != nil {
+		return nil, err
+	}
+
+	uri := totp.URI(issuer, u.Email, secret)
+
+	png, err := qrcode.Encode(uri, qrcode.Medium, MFAQRCodeSize)
+	if err != nil {
+		return nil, err
+	}
+
+	_, err = svc.PutItemWithContext(ctx, &dynamodb.PutItemInput{
+		TableName: aws.String(tableName),
+		Item: map[string]*dynamodb.AttributeValue{
+			"pk":        {S: aws.String(u.getUserPK())},
+			"sk":        {S: aws.String(u.getMFASK())},
+			"secret":    {S: aws.String(sealed)},
+			"confirmed": {BOOL: aws.Bool(false)},
+			"created":   {S: aws.String(time.Now().Format("2006-01-02"))},
+			"type":      {S: aws.String(DynamoDBTypeMFA)},
+		},
+		ExpressionAttributeNames: map[string]*string{
+			"#C": aws.String("confirmed"),
+		},
+		ExpressionAttributeValues: map[string]*dynamodb.AttributeValue{
+			":false": {BOOL: aws.Bool(false)},
+		},
+		ConditionExpression: aws.String("attribute_not_exists(pk) OR #C = :false"),
+	})
+	if err != nil {
+		if isConditionalCheckFailed(err) {
+			return nil, errors.New(ErrorMFAAlreadyEnabled)
+		}
+		return nil, err
+	}
+
+	return &MFAEnrollment{Secret: secret, URI: uri, QRCode: png}, nil
+}
+
+//ConfirmMFA verifies the first code generated by the authenticator app,
+//enables MFA and returns the recovery codes. The codes are only returned once
+func (u *User) ConfirmMFA(ctx context.Context, svc dynamodbiface.DynamoDBAPI,
+	tableName, key, code string) ([]string, error) {
+
+	log.Debug().Msgf("Confirming MFA: %s", u.Email)
+
+	m, err := u.loadMFA(ctx, svc, tableName)
+	if err != nil {
+		return nil, err
+	}
+
+	if m.Confirmed {
+		return nil, errors.New(ErrorMFAAlreadyEnabled)
+	}
+
+	step, ok, err := m.validate(key, code)
+	if err != nil {
+		return nil, err
+	} else if !ok {
+		return nil, errors.New(ErrorInvalidMFACode)
+	}
+
+	codes, hashes, err := generateRecoveryCodes(MFARecoveryCodes)
+	if err != nil {
+		return nil, err
+	}
+
+	_, err = svc.UpdateItemWithContext(ctx, &dynamodb.UpdateItemInput{
+		TableName: aws.String(tableName),
+		Key: map[string]*dynamodb.AttributeValue{
+			"pk": {S: aws.String(u.getUserPK())},
+			"sk": {S: aws.String(u.getMFASK())},
+		},
+		ExpressionAttributeNames: map[string]*string{
+			"#C": aws.String("confirmed"),
+			"#R": aws.String("recoveryCodes"),
+			"#S": aws.String("lastStep"),
+		},
+		ExpressionAttributeValues: map[string]*dynamodb.AttributeValue{
+			":true":  {BOOL: aws.Bool(true)},
+			":false": {BOOL: aws.Bool(false)},
+			":codes": {SS: aws.StringSlice(hashes)},
+			":step":  {N: aws.String(strconv.FormatInt(step, 10))},
+		},
+		UpdateExpression:    aws.String("SET #C = :true, #R = :codes, #S = :step"),
+		ConditionExpression: aws.String("#C = :false"),
+	})
+	if err != nil {
+		if isConditionalCheckFailed(err) {
+			return nil, errors.New(ErrorMFAAlreadyEnabled)
+		}
+		return nil, err
+	}
+
+	return codes, nil
+}
+
+//VerifyMFA verifies a TOTP code or, failing that, a recovery code. Recovery
+//codes are removed from the row once used, and a TOTP code is only accepted
+//once. Every code tried counts as an attempt until one is accepted: after
+//MFAMaxAttempts the account is locked out of MFA for MFALockout
+func (u *User) VerifyMFA(ctx context.Context, svc dynamodbiface.DynamoDBAPI,
+	tableName, key, code string) error {
+
+	log.Debug().Msgf("Verifying MFA: %s", u.Email)
+
+	m, err := u.loadMFA(ctx, svc, tableName)
+	if err != nil {
+		return err
+	}
+
+	if !m.Confirmed {
+		return errors.New(ErrorMFANotEnabled)
+	}
+
+	now := time.Now()
+
+	if err := u.countMFAAttempt(ctx, svc, tableName, m, now); err != nil {
+		return err
+	}
+
+	step, ok, err := m.validate(key, code)
+	if err != nil {
+		return err
+	}
+
+	var input *dynamodb.UpdateItemInput
+	if ok {
+		//The condition rejects a step already used, also by a concurrent
+		//verification of the same code
+		input = &dynamodb.UpdateItemInput{
+			ExpressionAttributeNames: map[string]*string{
+				"#S": aws.String("lastStep"),
+				"#A": aws.String("attempts"),
+			},
+			ExpressionAttributeValues: map[string]*dynamodb.AttributeValue{
+				":step": {N: aws.String(strconv.FormatInt(step, 10))},
+				":zero": {N: aws.String("0")},
+			},
+			UpdateExpression:    aws.String("SET #S = :step, #A = :zero"),
+			ConditionExpression: aws.String("attribute_not_exists(#S) OR #S < :step"),
+		}
+	} else {
+		hash := hashRecoveryCode(code)
+		input = &dynamodb.UpdateItemInput{
+			ExpressionAttributeNames: map[string]*string{
+				"#R": aws.String("recoveryCodes"),
+				"#A": aws.String("attempts"),
+			},
+			ExpressionAttributeValues: map[string]*dynamodb.AttributeValue{
+				":code": {SS: aws.StringSlice([]string{hash})},
+				":hash": {S: aws.String(hash)},
+				":zero": {N: aws.String("0")},
+			},
+			UpdateExpression:    aws.String("SET #A = :zero DELETE #R :code"),
+			ConditionExpression: aws.String("contains(#R, :hash)"),
+		}
+	}
+	input.TableName = aws.String(tableName)
+	input.Key = map[string]*dynamodb.AttributeValue{
+		"pk": {S: aws.String(u.getUserPK())},
+		"sk": {S: aws.String(u.getMFASK())},
+	}
+
+	if _, err := svc.UpdateItemWithContext(ctx, input); err != nil {
+		if isConditionalCheckFailed(err) {
+			return errors.New(ErrorInvalidMFACode)
+		}
+		return err
+	}
+
+	if !ok {
+		log.Info().Msgf("Recovery code used: %s", u.Email)
+	}
+
+	return nil
+}
+
+//countMFAAttempt counts the attempt before the code is checked, so concurrent
+//guesses can not exceed MFAMaxAttempts. Once they are reached the lockout
+//starts, and when it is over the attempts start again
+func (u *User) countMFAAttempt(ctx context.Context,
+	svc dynamodbiface.DynamoDBAPI, tableName string, m *mfa,
+	now time.Time) error {
+
+	key := map[string]*dynamodb.AttributeValue{
+		"pk": {S: aws.String(u.getUserPK())},
+		"sk": {S: aws.String(u.getMFASK())},
+	}
+
+	_, err := svc.UpdateItemWithContext(ctx, &dynamodb.UpdateItemInput{
+		TableName: aws.String(tableName),
+		Key:       key,
+		ExpressionAttributeNames: map[string]*string{
+			"#A": aws.String("attempts"),
+		},
+		ExpressionAttributeValues: map[string]*dynamodb.AttributeValue{
+			":one": {N: aws.String("1")},
+			":max": {N: aws.String(strconv.Itoa(MFAMaxAttempts))},
+		},
+		UpdateExpression:    aws.String("ADD #A :one"),
+		ConditionExpression: aws.String("attribute_not_exists(#A) OR #A < :max"),
+	})
+	if err == nil {
+		return nil
+	}
+	if !isConditionalCheckFailed(err) {
+		return err
+	}
+
+	input := &dynamodb.UpdateItemInput{
+		TableName: aws.String(tableName),
+		Key:       key,
+		ExpressionAttributeNames: map[string]*string{
+			"#A": aws.String("attempts"),
+			"#L": aws.String("lockedUntil"),
+		},
+	}
+
+	locked, perr := time.Parse(time.RFC3339, m.LockedUntil)
+	switch {
+	case perr != nil:
+		//The attempts just ran out, the lockout starts
+		input.ExpressionAttributeValues = map[string]*dynamodb.AttributeValue{
+			":until": {S: aws.String(now.Add(MFALockout).UTC().Format(time.RFC3339))},
+		}
+		input.UpdateExpression = aws.String("SET #L = :until")
+		input.ConditionExpression = aws.String("attribute_not_exists(#L)")
+
+	case now.Before(locked):
+		return errors.New(ErrorMFALocked)
+
+	default:
+		//The lockout is over, this is the first attempt of the next round.
+		//The condition lets only one of concurrent attempts start it
+		input.ExpressionAttributeValues = map[string]*dynamodb.AttributeValue{
+			":one":    {N: aws.String("1")},
+			":locked": {S: aws.String(m.LockedUntil)},
+		}
+		input.UpdateExpression = aws.String("SET #A = :one REMOVE #L")
+		input.ConditionExpression = aws.String("#L = :locked")
+	}
+
+	_, err = svc.UpdateItemWithContext(ctx, input)
+	if err != nil && !isConditionalCheckFailed(err) {
+		return err
+	}
+
+	if perr != nil || err != nil {
+		log.Warn().Msgf("MFA locked: %s", u.Email)
+		return errors.New(ErrorMFALocked)
+	}
+
+	return nil
+}
+
+//MFAEnabled returns true when the user has a confirmed MFA row
+func (u *User) MFAEnabled(ctx context.Context, svc dynamodbiface.DynamoDBAPI,
+	tableName string) (bool, error) {
+
+	m, err := u.loadMFA(ctx, svc, tableName)
+	if err != nil {
+		if err.Error() == ErrorMFANotEnabled {
+			return false, nil
+		}
+		return false, err
+	}
+
+	return m.Confirmed, nil
+}
+
+//ResetMFA deletes the MFA row, disabling MFA for the account
+func (u *User) ResetMFA(ctx context.Context, svc dynamodbiface.DynamoDBAPI,
+	tableName string) error {
+
+	log.Debug().Msgf("Resetting MFA: %s", u.Email)
+
+	if u.Email == "" {
+		return errors.New("Email is not set")
+	}
+
+	_, err := svc.DeleteItemWithContext(ctx, &dynamodb.DeleteItemInput{
+		TableName: aws.String(tableName),
+		Key: map[string]*dynamodb.AttributeValue{
+			"pk": {S: aws.String(u.getUserPK())},
+			"sk": {S: aws.String(u.getMFASK())},
+		},
+		ConditionExpression: aws.String("attribute_exists(pk) AND attribute_exists(sk)"),
+	})
+	if err != nil {
+		if isConditionalCheckFailed(err) {
+			return errors.New(ErrorMFANotEnabled)
+		}
+		return err
+	}
+
+	return nil
+}
+
+//loadMFA loads the MFA row of the user
+func (u *User) loadMFA(ctx context.Context, svc dynamodbiface.DynamoDBAPI,
+	tableName string) (*mfa, error) {
+
+	if u.Email == "" {
+		return nil, errors.New("Email is not set")
+	}
+
+	result, err := svc.GetItemWithContext(ctx, &dynamodb.GetItemInput{
+		TableName: aws.String(tableName),
+		Key: map[string]*dynamodb.AttributeValue{
+			"pk": {S: aws.String(u.getUserPK())},
+			"sk": {S: aws.String(u.getMFASK())},
+		},
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	if result.Item == nil {
+		return nil, errors.New(ErrorMFANotEnabled)
+	}
+
+	var m mfa
+	if err := dynamodbattribute.UnmarshalMap(result.Item, &m); err != nil {
+		return nil, err
+	}
+
+	return &m, nil
+}
+
+//validate opens the secret and validates the TOTP code, returning its time
+//step. Codes of the last accepted step or older are not valid
+func (m *mfa) validate(key, code string) (int64, bool, error) {
+	secret, err := seal.Open(key, m.Secret)
+	if err != nil {
+		return 0, false, err
+	}
+
+	step, ok := totp.ValidateStep(string(secret), code, time.Now())
+	if !ok || step <= m.LastStep {
+		return 0, false, nil
+	}
+
+	return step, true, nil
+}
+
+func (u *User) getMFASK() string {
+	return fmt.Sprintf("%s#", DynamoDBPrefixMFA)
+}
+
+//generateRecoveryCodes returns n recovery codes and their hashes
+func generateRecoveryCodes(n int) ([]string, []string, error) {
+	codes := make([]string, n)
+	hashes := make([]string, n)
+
+	for i := range codes {
+		b := make([]byte, 6)
+		if _, err := rand.Read(b); err != nil {
+			return nil, nil, err
+		}
+		c := strings.ToLower(base32.StdEncoding.EncodeToString(b))[:10]
+
+		codes[i] = fmt.Sprintf("%s-%s", c[:5], c[5:])
+		hashes[i] = hashRecoveryCode(codes[i])
+	}
+
+	return codes, hashes, nil
+}
+
+//hashRecoveryCode normalizes and hashes a recovery code
+func hashRecoveryCode(code string) string {
+	code = strings.ToLower(strings.ReplaceAll(strings.TrimSpace(code), "-", ""))
+	sum := sha256.Sum256([]byte(code))
+	return hex.EncodeToString(sum[:])
+}
@@ -0,0 +1,207 @@
+package user
+
+import (
+	"context"
+	"encoding/base64"
+	"errors"
+	"reflect"
+	"strconv"
+	"testing"
+	"time"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/awserr"
+	"github.com/aws/aws-sdk-go/service/dynamodb"
+
+	"github.com/roloum/users/internal/seal"
+	"github.com/roloum/users/internal/test"
+	"github.com/roloum/users/internal/totp"
+)
+
+//TestVerifyMFA Tests the VerifyMFA functionality
+func TestVerifyMFA(t *testing.T) {
+
+	key := base64.StdEncoding.EncodeToString(make([]byte, 32))
+
+	secret, _ := totp.GenerateSecret()
+	sealed, _ := seal.Seal(key, []byte(secret))
+	code, _ := totp.Code(secret, time.Now())
+
+	step := strconv.FormatInt(time.Now().Unix()/totp.Period, 10)
+	recovery := "abcde-fghij"
+	failed := awserr.New(dynamodb.ErrCodeConditionalCheckFailedException,
+		"The conditional request failed", nil)
+
+	row := func(confirmed bool, attrs ...string) *dynamodb.GetItemOutput {
+		item := map[string]*dynamodb.AttributeValue{
+			"secret":        {S: aws.String(sealed)},
+			"confirmed":     {BOOL: aws.Bool(confirmed)},
+			"recoveryCodes": {SS: aws.StringSlice([]string{hashRecoveryCode(recovery)})},
+		}
+		for i := 0; i+1 < len(attrs); i += 2 {
+			if attrs[i] == "lastStep" {
+				item[attrs[i]] = &dynamodb.AttributeValue{N: aws.String(attrs[i+1])}
+				continue
+			}
+			item[attrs[i]] = &dynamodb.AttributeValue{S: aws.String(attrs[i+1])}
+		}
+		return &dynamodb.GetItemOutput{Item: item}
+	}
+
+	future := time.Now().Add(time.Minute).UTC().Format(time.RFC3339)
+	past := time.Now().Add(-time.Minute).UTC().Format(time.RFC3339)
+
+	tests := []struct {
+		desc      string
+		code      string
+		mockDBSvc *test.MockDynamoDB
+		err       error
+	}{
+		{
+			desc:      "ValidCode",
+			code:      code,
+			mockDBSvc: &test.MockDynamoDB{GetItemOutput: row(true)},
+			err:       nil,
+		},
+		{
+			desc:      ErrorMFANotEnabled,
+			code:      code,
+			mockDBSvc: &test.MockDynamoDB{GetItemOutput: &dynamodb.GetItemOutput{}},
+			err:       errors.New(ErrorMFANotEnabled),
+		},
+		{
+			desc:      "NotConfirmed",
+			code:      code,
+			mockDBSvc: &test.MockDynamoDB{GetItemOutput: row(false)},
+			err:       errors.New(ErrorMFANotEnabled),
+		},
+		{
+			desc: "Replayed",
+			code: code,
+			mockDBSvc: &test.MockDynamoDB{GetItemOutput: row(true, "lastStep", step),
+				UpdateItemErrors: []error{nil, failed}},
+			err: errors.New(ErrorInvalidMFACode),
+		},
+		{
+			desc: "ReplayedConcurrently",
+			code: code,
+			mockDBSvc: &test.MockDynamoDB{GetItemOutput: row(true),
+				UpdateItemErrors: []error{nil, failed}},
+			err: errors.New(ErrorInvalidMFACode),
+		},
+		{
+			desc:      "RecoveryCode",
+			code:      "ABCDE-FGHIJ",
+			mockDBSvc: &test.MockDynamoDB{GetItemOutput: row(true)},
+			err:       nil,
+		},
+		{
+			desc: "UsedRecoveryCode",
+			code: recovery,
+			mockDBSvc: &test.MockDynamoDB{GetItemOutput: row(true),
+				UpdateItemErrors: []error{nil, failed}},
+			err: errors.New(ErrorInvalidMFACode),
+		},
+		{
+			desc: "LockoutStarts",
+			code: code,
+			mockDBSvc: &test.MockDynamoDB{GetItemOutput: row(true),
+				UpdateItemErrors: []error{failed}},
+			err: errors.New(ErrorMFALocked),
+		},
+		{
+			desc: "Locked",
+			code: code,
+			mockDBSvc: &test.MockDynamoDB{
+				GetItemOutput:    row(true, "lockedUntil", future),
+				UpdateItemErrors: []error{failed}},
+			err: errors.New(ErrorMFALocked),
+		},
+		{
+			desc: "LockoutOver",
+			code: code,
+			mockDBSvc: &test.MockDynamoDB{
+				GetItemOutput:    row(true, "lockedUntil", past),
+				UpdateItemErrors: []error{failed}},
+			err: nil,
+		},
+		{
+			desc: "LockoutOverConcurrently",
+			code: code,
+			mockDBSvc: &test.MockDynamoDB{
+				GetItemOutput:    row(true, "lockedUntil", past),
+				UpdateItemErrors: []error{failed, failed}},
+			err: errors.New(ErrorMFALocked),
+		},
+	}
+
+	for _, tc := range tests {
+		t.Run(tc.desc, func(t *testing.T) {
+			u := &User{Email: "test@user.com"}
+			err := u.VerifyMFA(context.Background(), tc.mockDBSvc, UserTable,
+				key, tc.code)
+			if !reflect.DeepEqual(err, tc.err) {
+				t.Errorf("Expected: %v. Received: %v", tc.err, err)
+			}
+		})
+	}
+}
+
+//TestCompleteMFA Tests that only the sessions waiting for the MFA code can be
+//completed
+func TestCompleteMFA(t *testing.T) {
+
+	key := base64.StdEncoding.EncodeToString(make([]byte, 32))
+	u := &User{Email: "test@user.com"}
+	pending, _, _ := u.newSession(MFAChallengeTTL)
+
+	row := func(mfaRequired bool, expires time.Duration) *dynamodb.GetItemOutput {
+		return &dynamodb.GetItemOutput{Item: map[string]*dynamodb.AttributeValue{
+			"ttl": {N: aws.String(strconv.FormatInt(
+				time.Now().Add(expires).Unix(), 10))},
+			"mfaRequired": {BOOL: aws.Bool(mfaRequired)},
+		}}
+	}
+
+	tests := []struct {
+		desc  string
+		token string
+		mock  *test.MockDynamoDB
+		err   error
+	}{
+		{
+			desc:  "Malformed",
+			token: "token",
+			mock:  &test.MockDynamoDB{},
+			err:   errors.New(ErrorInvalidSession),
+		},
+		{
+			desc:  "DoesNotExist",
+			token: pending.Token,
+			mock:  &test.MockDynamoDB{GetItemOutput: &dynamodb.GetItemOutput{}},
+			err:   errors.New(ErrorInvalidSession),
+		},
+		{
+			desc:  "FullSession",
+			token: pending.Token,
+			mock:  &test.MockDynamoDB{GetItemOutput: row(false, time.Minute)},
+			err:   errors.New(ErrorInvalidSession),
+		},
+		{
+			desc:  "Expired",
+			token: pending.Token,
+			mock:  &test.MockDynamoDB{GetItemOutput: row(true, -time.Minute)},
+			err:   errors.New(ErrorInvalidSession),
+		},
+	}
+
+	for _, tc := range tests {
+		t.Run(tc.desc, func(t *testing.T) {
+			_, err := CompleteMFA(context.Background(), tc.mock, UserTable,
+				tc.token, key, "123456", time.Hour)
+			if !reflect.DeepEqual(err, tc.err) {
+				t.Errorf("Expected: %v. Received: %v", tc.err, err)
+			}
+		})
+	}
+}
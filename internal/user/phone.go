@@ -0,0 +1,350 @@
+package user
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/subtle"
+	"errors"
+	"fmt"
+	"math/big"
+	"regexp"
+	"strconv"
+	"time"
+
+	"github.com/rs/zerolog/log"
+
+	"github.com/aws/aws-lambda-go/events"
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/dynamodb"
+	"github.com/aws/aws-sdk-go/service/dynamodb/dynamodbattribute"
+	"github.com/aws/aws-sdk-go/service/dynamodb/dynamodbiface"
+
+	"github.com/roloum/users/internal/seal"
+)
+
+const (
+	//DynamoDBPrefixPhone Prefix of the sort key of the pending phone
+	//verification row
+	DynamoDBPrefixPhone = "PHONE"
+
+	//DynamoDBTypePhone identifies the phone verification row in dynamoDB
+	DynamoDBTypePhone = "PhoneVerification"
+
+	//PhoneCodeDigits length of the verification codes
+	PhoneCodeDigits = 6
+
+	//PhoneCodeAttempts wrong codes accepted before the verification must be
+	//requested again
+	PhoneCodeAttempts = 5
+
+	//PhoneCodeInterval is the minimum time between two codes sent to an user
+	PhoneCodeInterval = time.Minute
+
+	//ErrorInvalidPhone Returned when the number is not in E.164 format
+	ErrorInvalidPhone = "InvalidPhone"
+
+	//ErrorInvalidPhoneCode Returned when the code is wrong, expired or there
+	//is no pending verification
+	ErrorInvalidPhoneCode = "InvalidPhoneCode"
+
+	//ErrorPhoneCodeAttempts Returned after PhoneCodeAttempts wrong codes
+	ErrorPhoneCodeAttempts = "TooManyPhoneCodeAttempts"
+
+	//ErrorPhoneCodeTooSoon Returned when a code is requested less than
+	//PhoneCodeInterval after the previous one
+	ErrorPhoneCodeTooSoon = "PhoneCodeTooSoon"
+)
+
+//e164 is the pattern of the E.164 numbers: a plus sign and up to 15 digits,
+//starting with the country code
+var e164 = regexp.MustCompile(`^\+[1-9][0-9]{6,14}$`)
+
+//PhoneVerification is the pending verification of a phone number. The notify
+//handler sends the code when the row shows up in the stream
+type PhoneVerification struct {
+	Email string `json:"email"`
+	Phone string `json:"phone"`
+	//Code is sealed, only the notify handler opens it to send the SMS
+	Code      string `json:"code"`
+	Attempts  int    `json:"attempts"`
+	Requested int64  `json:"requested"`
+	TTL       int64  `json:"ttl"`
+}
+
+//OpenCode returns the verification code, sealed with key
+func (v *PhoneVerification) OpenCode(key string) (string, error) {
+	code, err := seal.Open(key, v.Code)
+	if err != nil {
+		return "", err
+	}
+	return string(code), nil
+}
+
+//IsValidPhone tells whether the number is in E.164 format
+func IsValidPhone(phone string) bool {
+	return e164.MatchString(phone)
+}
+
+//IsUserPhoneKeys verifies that pk and sk correspond to a User's phone
+//verification row
+func IsUserPhoneKeys(keys map[string]events.DynamoDBAttributeValue) bool {
+	phoneKeys := isUserKeys(DynamoDBPrefixUser, DynamoDBPrefixPhone, keys)
+
+	log.Debug().Msgf("IsUserPhoneKeys: %v", phoneKeys)
+
+	return phoneKeys
+}
+
+//RequestPhoneVerification stores a verification code for the number, sealed
+//with key, that expires after ttl, replacing a pending one. Codes can only be
+//requested every PhoneCodeInterval
+func (u *User) RequestPhoneVerification(ctx context.Context,
+	svc dynamodbiface.DynamoDBAPI, tableName, phone, key string,
+	ttl time.Duration) error {
+
+	log.Debug().Msgf("Requesting phone verification: %s", u.Email)
+
+	if !IsValidPhone(phone) {
+		return errors.New(ErrorInvalidPhone)
+	}
+
+	code, err := randomDigits(PhoneCodeDigits)
+	if err != nil {
+		return err
+	}
+
+	sealed, err := seal.Seal(key, []byte(code))
+	if err != nil {
+		return err
+	}
+
+	now := time.Now()
+	v := &PhoneVerification{Email: u.Email, Phone: phone, Code: sealed,
+		Requested: now.Unix(), TTL: now.Add(ttl).Unix()}
+
+	item, err := dynamodbattribute.MarshalMap(v)
+	if err != nil {
+		return err
+	}
+	item["pk"] = &dynamodb.AttributeValue{S: aws.String(u.getUserPK())}
+	item["sk"] = &dynamodb.AttributeValue{S: aws.String(u.getPhoneSK())}
+	item["type"] = &dynamodb.AttributeValue{S: aws.String(DynamoDBTypePhone)}
+
+	_, err = svc.PutItemWithContext(ctx, &dynamodb.PutItemInput{
+		TableName: aws.String(tableName),
+		Item:      item,
+		ExpressionAttributeNames: map[string]*string{
+			"#R": aws.String("requested"),
+		},
+		ExpressionAttributeValues: map[string]*dynamodb.AttributeValue{
+			":since": {N: aws.String(strconv.FormatInt(
+				now.Add(-PhoneCodeInterval).Unix(), 10))},
+		},
+		ConditionExpression: aws.String("attribute_not_exists(pk) OR #R < :since"),
+	})
+	if isConditionalCheckFailed(err) {
+		return errors.New(ErrorPhoneCodeTooSoon)
+	}
+	return err
+}
+
+//VerifyPhone checks the code of the pending verification, sealed with key,
+//and, when it matches, stores the number as the verified phone of the
+//profile. Every code checked, right or wrong, first takes one of the
+//PhoneCodeAttempts, so concurrent guesses can not go over the limit
+func (u *User) VerifyPhone(ctx context.Context, svc dynamodbiface.DynamoDBAPI,
+	tableName, key, code string) error {
+
+	log.Debug().Msgf("Verifying phone: %s", u.Email)
+
+	result, err := svc.GetItemWithContext(ctx, &dynamodb.GetItemInput{
+		TableName: aws.String(tableName),
+		Key: map[string]*dynamodb.AttributeValue{
+			"pk": {S: aws.String(u.getUserPK())},
+			"sk": {S: aws.String(u.getPhoneSK())},
+		},
+	})
+	if err != nil {
+		return err
+	}
+	if result.Item == nil {
+		return errors.New(ErrorInvalidPhoneCode)
+	}
+
+	var v PhoneVerification
+	if err := dynamodbattribute.UnmarshalMap(result.Item, &v); err != nil {
+		return err
+	}
+
+	//The rows are removed by the TTL some time after they expire
+	if v.TTL <= time.Now().Unix() {
+		return errors.New(ErrorInvalidPhoneCode)
+	}
+
+	if v.Attempts >= PhoneCodeAttempts {
+		return errors.New(ErrorPhoneCodeAttempts)
+	}
+
+	//The attempt is counted before the code is compared, a failed count
+	//rejects the code
+	_, err = svc.UpdateItemWithContext(ctx, &dynamodb.UpdateItemInput{
+		TableName: aws.String(tableName),
+		Key: map[string]*dynamodb.AttributeValue{
+			"pk": {S: aws.String(u.getUserPK())},
+			"sk": {S: aws.String(u.getPhoneSK())},
+		},
+		ExpressionAttributeNames: map[string]*string{
+			"#A": aws.String("attempts"),
+		},
+		ExpressionAttributeValues: map[string]*dynamodb.AttributeValue{
+			":one": {N: aws.String("1")},
+			":max": {N: aws.String(strconv.Itoa(PhoneCodeAttempts))},
+		},
+		UpdateExpression:    aws.String("ADD #A :one"),
+		ConditionExpression: aws.String("attribute_exists(pk) AND #A < :max"),
+	})
+	if err != nil {
+		if isConditionalCheckFailed(err) {
+			return errors.New(ErrorPhoneCodeAttempts)
+		}
+		return err
+	}
+
+	expected, err := v.OpenCode(key)
+	if err != nil {
+		return err
+	}
+	if subtle.ConstantTimeCompare([]byte(expected), []byte(code)) != 1 {
+		return errors.New(ErrorInvalidPhoneCode)
+	}
+
+	//The row is only removed while it holds the sealed code just checked, a
+	//code requested meanwhile is kept
+	_, err = svc.TransactWriteItemsWithContext(ctx,
+		&dynamodb.TransactWriteItemsInput{TransactItems: []*dynamodb.TransactWriteItem{
+			{
+				Delete: &dynamodb.Delete{
+					TableName: aws.String(tableName),
+					Key: map[string]*dynamodb.AttributeValue{
+						"pk": {S: aws.String(u.getUserPK())},
+						"sk": {S: aws.String(u.getPhoneSK())},
+					},
+					ExpressionAttributeNames: map[string]*string{
+						"#C": aws.String("code"),
+					},
+					ExpressionAttributeValues: map[string]*dynamodb.AttributeValue{
+						":code": {S: aws.String(v.Code)},
+					},
+					ConditionExpression: aws.String("#C = :code"),
+				},
+			},
+			{
+				Update: &dynamodb.Update{
+					TableName: aws.String(tableName),
+					Key: map[string]*dynamodb.AttributeValue{
+						"pk": {S: aws.String(u.getUserPK())},
+						"sk": {S: aws.String(u.getProfileSK())},
+					},
+					ExpressionAttributeNames: map[string]*string{
+						"#P": aws.String("phone"),
+						"#V": aws.String("phoneVerified"),
+					},
+					ExpressionAttributeValues: map[string]*dynamodb.AttributeValue{
+						":phone":    {S: aws.String(v.Phone)},
+						":verified": {BOOL: aws.Bool(true)},
+					},
+					UpdateExpression:    aws.String("SET #P = :phone, #V = :verified"),
+					ConditionExpression: aws.String("attribute_exists(pk)"),
+				},
+			},
+		}})
+	if err != nil {
+		if isConditionCanceled(err) {
+			return errors.New(ErrorInvalidPhoneCode)
+		}
+		return err
+	}
+
+	u.Phone = v.Phone
+	u.PhoneVerified = true
+
+	return nil
+}
+
+//RemovePhone removes the phone of the profile and the SMS channel from the
+//preferences, the categories left without a channel fall back to email
+func (u *User) RemovePhone(ctx context.Context, svc dynamodbiface.DynamoDBAPI,
+	tableName string) error {
+
+	log.Debug().Msgf("Removing phone: %s", u.Email)
+
+	_, err := svc.UpdateItemWithContext(ctx, &dynamodb.UpdateItemInput{
+		TableName: aws.String(tableName),
+		Key: map[string]*dynamodb.AttributeValue{
+			"pk": {S: aws.String(u.getUserPK())},
+			"sk": {S: aws.String(u.getProfileSK())},
+		},
+		ExpressionAttributeNames: map[string]*string{
+			"#P": aws.String("phone"),
+			"#V": aws.String("phoneVerified"),
+		},
+		UpdateExpression:    aws.String("REMOVE #P, #V"),
+		ConditionExpression: aws.String("attribute_exists(pk) AND attribute_exists(sk)"),
+	})
+	if err != nil {
+		if isConditionalCheckFailed(err) {
+			return errors.New(ErrorUserDoesNotExist)
+		}
+		return err
+	}
+
+	u.Phone = ""
+	u.PhoneVerified = false
+
+	p, err := u.LoadPreferences(ctx, svc, tableName)
+	if err != nil {
+		return err
+	}
+
+	removed := false
+	for c, chs := range p.Channels {
+		kept := make([]string, 0, len(chs))
+		for _, ch := range chs {
+			if ch != ChannelSMS {
+				kept = append(kept, ch)
+			}
+		}
+		if len(kept) == len(chs) {
+			continue
+		}
+
+		removed = true
+		if len(kept) == 0 {
+			delete(p.Channels, c)
+		} else {
+			p.Channels[c] = kept
+		}
+	}
+	if !removed {
+		return nil
+	}
+
+	return u.SavePreferences(ctx, svc, tableName, p)
+}
+
+//randomDigits returns a random code of n decimal digits
+func randomDigits(n int) (string, error) {
+	code := make([]byte, n)
+	for i := range code {
+		d, err := rand.Int(rand.Reader, big.NewInt(10))
+		if err != nil {
+			return "", err
+		}
+		code[i] = byte('0' + d.Int64())
+	}
+	return string(code), nil
+}
+
+func (u *User) getPhoneSK() string {
+	return fmt.Sprintf("%s#", DynamoDBPrefixPhone)
+}
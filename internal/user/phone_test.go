@@ -0,0 +1,178 @@
+package user
+
+import (
+	"context"
+	"encoding/base64"
+	"errors"
+	"reflect"
+	"strconv"
+	"testing"
+	"time"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/awserr"
+	"github.com/aws/aws-sdk-go/service/dynamodb"
+	"github.com/aws/aws-sdk-go/service/dynamodb/dynamodbattribute"
+
+	"github.com/roloum/users/internal/seal"
+	"github.com/roloum/users/internal/test"
+)
+
+//TestIsValidPhone Tests the E.164 validation
+func TestIsValidPhone(t *testing.T) {
+
+	tests := []struct {
+		phone    string
+		expected bool
+	}{
+		{phone: "+34600000000", expected: true},
+		{phone: "+14155552671", expected: true},
+		{phone: "34600000000", expected: false},
+		{phone: "+0600000000", expected: false},
+		{phone: "+34 600 000 000", expected: false},
+		{phone: "+1234567890123456", expected: false},
+		{phone: "+12345", expected: false},
+	}
+
+	for _, tc := range tests {
+		t.Run(tc.phone, func(t *testing.T) {
+			if valid := IsValidPhone(tc.phone); valid != tc.expected {
+				t.Errorf("Expected: %v. Received: %v", tc.expected, valid)
+			}
+		})
+	}
+}
+
+//TestRequestPhoneVerification Tests the errors requesting a code
+func TestRequestPhoneVerification(t *testing.T) {
+
+	key := base64.StdEncoding.EncodeToString(make([]byte, 32))
+
+	tests := []struct {
+		desc  string
+		phone string
+		mock  *test.MockDynamoDB
+		err   error
+	}{
+		{desc: "Valid", phone: "+34600000000", mock: &test.MockDynamoDB{}},
+		{desc: ErrorInvalidPhone, phone: "600000000", mock: &test.MockDynamoDB{},
+			err: errors.New(ErrorInvalidPhone)},
+		{desc: ErrorPhoneCodeTooSoon, phone: "+34600000000",
+			mock: &test.MockDynamoDB{OutputError: awserr.New(
+				dynamodb.ErrCodeConditionalCheckFailedException, "", nil)},
+			err: errors.New(ErrorPhoneCodeTooSoon)},
+	}
+
+	for _, tc := range tests {
+		t.Run(tc.desc, func(t *testing.T) {
+			u := &User{Email: "a@user.com"}
+			err := u.RequestPhoneVerification(context.Background(), tc.mock,
+				UserTable, tc.phone, key, time.Minute)
+			if !reflect.DeepEqual(err, tc.err) {
+				t.Errorf("Expected: %v. Received: %v", tc.err, err)
+			}
+		})
+	}
+}
+
+//TestVerifyPhone Tests checking the verification code
+func TestVerifyPhone(t *testing.T) {
+
+	key := base64.StdEncoding.EncodeToString(make([]byte, 32))
+	sealed, err := seal.Seal(key, []byte("123456"))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	row := func(attempts int, expires time.Time) *dynamodb.GetItemOutput {
+		return &dynamodb.GetItemOutput{Item: map[string]*dynamodb.AttributeValue{
+			"phone":    {S: aws.String("+34600000000")},
+			"code":     {S: aws.String(sealed)},
+			"attempts": {N: aws.String(strconv.Itoa(attempts))},
+			"ttl":      {N: aws.String(strconv.FormatInt(expires.Unix(), 10))},
+		}}
+	}
+	later := time.Now().Add(time.Minute)
+
+	tests := []struct {
+		desc string
+		code string
+		mock *test.MockDynamoDB
+		err  error
+	}{
+		{desc: "Valid", code: "123456",
+			mock: &test.MockDynamoDB{GetItemOutput: row(0, later)}},
+		{desc: "WrongCode", code: "654321",
+			mock: &test.MockDynamoDB{GetItemOutput: row(0, later)},
+			err:  errors.New(ErrorInvalidPhoneCode)},
+		{desc: "Expired", code: "123456",
+			mock: &test.MockDynamoDB{GetItemOutput: row(0,
+				time.Now().Add(-time.Minute))},
+			err: errors.New(ErrorInvalidPhoneCode)},
+		{desc: "NotRequested", code: "123456",
+			mock: &test.MockDynamoDB{GetItemOutput: &dynamodb.GetItemOutput{}},
+			err:  errors.New(ErrorInvalidPhoneCode)},
+		{desc: ErrorPhoneCodeAttempts, code: "123456",
+			mock: &test.MockDynamoDB{GetItemOutput: row(PhoneCodeAttempts, later)},
+			err:  errors.New(ErrorPhoneCodeAttempts)},
+		{desc: "ConcurrentAttempts", code: "123456",
+			mock: &test.MockDynamoDB{GetItemOutput: row(PhoneCodeAttempts-1, later),
+				UpdateItemErrors: []error{awserr.New(
+					dynamodb.ErrCodeConditionalCheckFailedException, "", nil)}},
+			err: errors.New(ErrorPhoneCodeAttempts)},
+		{desc: "CountFailed", code: "123456",
+			mock: &test.MockDynamoDB{GetItemOutput: row(0, later),
+				UpdateItemErrors: []error{errors.New("Throttled")}},
+			err: errors.New("Throttled")},
+	}
+
+	for _, tc := range tests {
+		t.Run(tc.desc, func(t *testing.T) {
+			u := &User{Email: "a@user.com"}
+			err := u.VerifyPhone(context.Background(), tc.mock, UserTable, key,
+				tc.code)
+			if !reflect.DeepEqual(err, tc.err) {
+				t.Fatalf("Expected: %v. Received: %v", tc.err, err)
+			}
+			if err == nil && (u.Phone != "+34600000000" || !u.PhoneVerified) {
+				t.Errorf("Expected the phone to be verified. Received: %v %v",
+					u.Phone, u.PhoneVerified)
+			}
+		})
+	}
+}
+
+//TestRemovePhone Tests removing the SMS channel with the phone
+func TestRemovePhone(t *testing.T) {
+
+	mock := &recordingDynamoDB{MockDynamoDB: &test.MockDynamoDB{
+		GetItemOutput: &dynamodb.GetItemOutput{
+			Item: map[string]*dynamodb.AttributeValue{
+				"channels": {M: map[string]*dynamodb.AttributeValue{
+					NotificationAccount: {L: []*dynamodb.AttributeValue{
+						{S: aws.String(ChannelSMS)},
+					}},
+					NotificationProduct: {L: []*dynamodb.AttributeValue{
+						{S: aws.String(ChannelEmail)}, {S: aws.String(ChannelSMS)},
+					}},
+				}},
+			},
+		}}}
+
+	u := &User{Email: "a@user.com", Phone: "+34600000000", PhoneVerified: true}
+	if err := u.RemovePhone(context.Background(), mock, UserTable); err != nil {
+		t.Fatal(err)
+	}
+
+	if len(mock.puts) != 1 {
+		t.Fatalf("Expected: %v. Received: %v", 1, len(mock.puts))
+	}
+	var p Preferences
+	if err := dynamodbattribute.UnmarshalMap(mock.puts[0].Item, &p); err != nil {
+		t.Fatal(err)
+	}
+	expected := map[string][]string{NotificationProduct: {ChannelEmail}}
+	if !reflect.DeepEqual(p.Channels, expected) {
+		t.Errorf("Expected: %v. Received: %v", expected, p.Channels)
+	}
+}
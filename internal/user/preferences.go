@@ -0,0 +1,424 @@
+package user
+
+import (
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/rs/zerolog/log"
+	"golang.org/x/text/language"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/dynamodb"
+	"github.com/aws/aws-sdk-go/service/dynamodb/dynamodbattribute"
+	"github.com/aws/aws-sdk-go/service/dynamodb/dynamodbiface"
+
+	"github.com/roloum/users/internal/apperr"
+)
+
+const (
+	//DynamoDBPrefixPreferences Prefix of the sort key of the preferences row
+	DynamoDBPrefixPreferences = "PREFS"
+
+	//DynamoDBTypePreferences identifies the preferences row in dynamoDB
+	DynamoDBTypePreferences = "Preferences"
+
+	//NotificationAccount activation, sign in and security messages. They are
+	//mandatory and can not be disabled
+	NotificationAccount = "account"
+
+	//NotificationProduct welcome and product update messages
+	NotificationProduct = "product"
+
+	//NotificationMarketing promotional messages, only sent with the marketing
+	//consent of the user
+	NotificationMarketing = "marketing"
+
+	//ChannelEmail delivers the notifications by email
+	ChannelEmail = "email"
+
+	//ChannelSMS delivers the notifications by SMS to the verified phone
+	ChannelSMS = "sms"
+
+	//ChannelWebhook posts the notifications to the configured webhook
+	ChannelWebhook = "webhook"
+
+	//ErrorUnknownChannel Returned for a notification channel that does not
+	//exist
+	ErrorUnknownChannel = "UnknownChannel"
+
+	//ErrorPhoneNotVerified Returned when choosing the SMS channel without a
+	//verified phone
+	ErrorPhoneNotVerified = "PhoneNotVerified"
+
+	//ErrorUnknownNotification Returned for a notification category that does
+	//not exist
+	ErrorUnknownNotification = "UnknownNotification"
+
+	//ErrorMandatoryNotification Returned when disabling a mandatory category
+	ErrorMandatoryNotification = "MandatoryNotification"
+
+	//ErrorInvalidLocale Returned when the locale is not a BCP 47 language tag
+	ErrorInvalidLocale = "InvalidLocale"
+
+	//ErrorInvalidTimezone Returned when the timezone is not an IANA time zone
+	ErrorInvalidTimezone = "InvalidTimezone"
+
+	//ErrorInvalidUnsubscribeToken Returned when the signature of the
+	//unsubscribe link does not match
+	ErrorInvalidUnsubscribeToken = "InvalidUnsubscribeToken"
+
+	//ErrorInvalidUnsubscribeKey Returned when the signing key is not a base64
+	//encoded key of at least 32 bytes
+	ErrorInvalidUnsubscribeKey = "InvalidUnsubscribeKey"
+)
+
+//notificationDefaults are the categories and whether they are enabled when
+//the user has not chosen
+var notificationDefaults = map[string]bool{
+	NotificationAccount:   true,
+	NotificationProduct:   true,
+	NotificationMarketing: false,
+}
+
+//channels are the notification channels the users can choose
+var channels = map[string]bool{
+	ChannelEmail:   true,
+	ChannelSMS:     true,
+	ChannelWebhook: true,
+}
+
+//Preferences are the settings of the user, stored in the PREFS# row
+type Preferences struct {
+	//Notifications enables or disables each category
+	Notifications map[string]bool `json:"notifications"`
+
+	//Channels lists the channels of each category, email when not set
+	Channels map[string][]string `json:"channels,omitempty"`
+
+	Locale   string `json:"locale,omitempty"`
+	Timezone string `json:"timezone,omitempty"`
+
+	MarketingConsent bool `json:"marketingConsent"`
+
+	//MarketingConsentUpdated is when the consent was last given or withdrawn
+	MarketingConsentUpdated string `json:"marketingConsentUpdated,omitempty"`
+
+	Updated string `json:"updated,omitempty"`
+}
+
+//DefaultPreferences returns the preferences of an user who has not saved any
+func DefaultPreferences() *Preferences {
+	p := &Preferences{Notifications: map[string]bool{}}
+	for c, enabled := range notificationDefaults {
+		p.Notifications[c] = enabled
+	}
+	return p
+}
+
+//NotificationCategories returns the categories, sorted
+func NotificationCategories() []string {
+	categories := make([]string, 0, len(notificationDefaults))
+	for c := range notificationDefaults {
+		categories = append(categories, c)
+	}
+	sort.Strings(categories)
+	return categories
+}
+
+//IsMandatory tells whether the messages of the category are always sent
+func IsMandatory(category string) bool {
+	return category == NotificationAccount
+}
+
+//Allows tells whether the user receives the messages of the category.
+//Marketing messages also need the marketing consent
+func (p *Preferences) Allows(category string) bool {
+	if IsMandatory(category) {
+		return true
+	}
+
+	enabled, ok := p.Notifications[category]
+	if !ok {
+		enabled = notificationDefaults[category]
+	}
+
+	if category == NotificationMarketing {
+		return enabled && p.MarketingConsent
+	}
+	return enabled
+}
+
+//ChannelsFor returns the channels the messages of the category are sent to
+func (p *Preferences) ChannelsFor(category string) []string {
+	if c := p.Channels[category]; len(c) > 0 {
+		return c
+	}
+	return []string{ChannelEmail}
+}
+
+//Check validates the preferences, reporting every invalid field
+func (p *Preferences) Check() []apperr.FieldError {
+
+	var fields []apperr.FieldError
+
+	categories := make([]string, 0, len(p.Notifications))
+	for c := range p.Notifications {
+		categories = append(categories, c)
+	}
+	sort.Strings(categories)
+
+	for _, c := range categories {
+		field := "notifications." + c
+		if _, ok := notificationDefaults[c]; !ok {
+			fields = append(fields, apperr.FieldError{Field: field,
+				Code: ErrorUnknownNotification})
+		} else if IsMandatory(c) && !p.Notifications[c] {
+			fields = append(fields, apperr.FieldError{Field: field,
+				Code: ErrorMandatoryNotification})
+		}
+	}
+
+	categories = categories[:0]
+	for c := range p.Channels {
+		categories = append(categories, c)
+	}
+	sort.Strings(categories)
+
+	for _, c := range categories {
+		field := "channels." + c
+		if _, ok := notificationDefaults[c]; !ok {
+			fields = append(fields, apperr.FieldError{Field: field,
+				Code: ErrorUnknownNotification})
+			continue
+		}
+		for _, ch := range p.Channels[c] {
+			if !channels[ch] {
+				fields = append(fields, apperr.FieldError{Field: field,
+					Code: ErrorUnknownChannel})
+				break
+			}
+		}
+	}
+
+	if p.Locale != "" {
+		if _, err := language.Parse(p.Locale); err != nil {
+			fields = append(fields, apperr.FieldError{Field: "locale",
+				Code: ErrorInvalidLocale})
+		}
+	}
+
+	if p.Timezone != "" {
+		if _, err := time.LoadLocation(p.Timezone); err != nil ||
+			p.Timezone == "Local" {
+			fields = append(fields, apperr.FieldError{Field: "timezone",
+				Code: ErrorInvalidTimezone})
+		}
+	}
+
+	return fields
+}
+
+//LoadPreferences returns the preferences of the user, or the defaults when
+//none were saved
+func (u *User) LoadPreferences(ctx context.Context,
+	svc dynamodbiface.DynamoDBAPI, tableName string) (*Preferences, error) {
+
+	if u.Email == "" {
+		return nil, errors.New("Email is not set")
+	}
+
+	result, err := svc.GetItemWithContext(ctx, &dynamodb.GetItemInput{
+		TableName: aws.String(tableName),
+		Key: map[string]*dynamodb.AttributeValue{
+			"pk": {S: aws.String(u.getUserPK())},
+			"sk": {S: aws.String(u.getPreferencesSK())},
+		},
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	p := DefaultPreferences()
+	if result.Item == nil {
+		return p, nil
+	}
+
+	var saved Preferences
+	if err := dynamodbattribute.UnmarshalMap(result.Item, &saved); err != nil {
+		return nil, err
+	}
+
+	//Categories added after the preferences were saved keep their default
+	for c, enabled := range saved.Notifications {
+		p.Notifications[c] = enabled
+	}
+	saved.Notifications = p.Notifications
+
+	return &saved, nil
+}
+
+//SavePreferences validates and stores the preferences, replacing the saved
+//ones: the categories not included take their default notification and the
+//email channel, like every other field left out. The time of the marketing
+//consent is recorded when it changes. The SMS channel needs the verified
+//phone of u
+func (u *User) SavePreferences(ctx context.Context,
+	svc dynamodbiface.DynamoDBAPI, tableName string, p *Preferences) error {
+
+	log.Debug().Msgf("Saving preferences of user: %s", u.Email)
+
+	if fields := p.Check(); len(fields) > 0 {
+		return apperr.Validation(fields...)
+	}
+
+	current, err := u.LoadPreferences(ctx, svc, tableName)
+	if err != nil {
+		return err
+	}
+
+	notifications := DefaultPreferences().Notifications
+	for c, enabled := range p.Notifications {
+		notifications[c] = enabled
+	}
+	p.Notifications = notifications
+
+	for c, chs := range p.Channels {
+		for _, ch := range chs {
+			if ch == ChannelSMS && !u.PhoneVerified {
+				return apperr.Validation(apperr.FieldError{
+					Field: "channels." + c, Code: ErrorPhoneNotVerified})
+			}
+		}
+	}
+
+	now := time.Now().UTC().Format(time.RFC3339)
+	p.MarketingConsentUpdated = current.MarketingConsentUpdated
+	if p.MarketingConsent != current.MarketingConsent {
+		p.MarketingConsentUpdated = now
+	}
+	p.Updated = now
+
+	item, err := dynamodbattribute.MarshalMap(p)
+	if err != nil {
+		return err
+	}
+	item["pk"] = &dynamodb.AttributeValue{S: aws.String(u.getUserPK())}
+	item["sk"] = &dynamodb.AttributeValue{S: aws.String(u.getPreferencesSK())}
+	item["type"] = &dynamodb.AttributeValue{S: aws.String(DynamoDBTypePreferences)}
+
+	_, err = svc.PutItemWithContext(ctx, &dynamodb.PutItemInput{
+		TableName: aws.String(tableName),
+		Item:      item,
+	})
+	return err
+}
+
+//Unsubscribe disables the category of the signed unsubscribe token, also
+//withdrawing the marketing consent for the marketing category. Returns the
+//user and the category
+func Unsubscribe(ctx context.Context, svc dynamodbiface.DynamoDBAPI,
+	tableName, key, token string) (*User, string, error) {
+
+	id, category, err := ParseUnsubscribeToken(key, token)
+	if err != nil {
+		return nil, "", err
+	}
+
+	u, err := LoadByID(ctx, svc, tableName, id)
+	if err != nil {
+		return nil, "", err
+	}
+
+	p, err := u.LoadPreferences(ctx, svc, tableName)
+	if err != nil {
+		return nil, "", err
+	}
+
+	p.Notifications[category] = false
+	if category == NotificationMarketing {
+		p.MarketingConsent = false
+	}
+
+	log.Info().Msgf("Unsubscribing %s from %s", u.Email, category)
+
+	if err := u.SavePreferences(ctx, svc, tableName, p); err != nil {
+		return nil, "", err
+	}
+
+	return u, category, nil
+}
+
+//UnsubscribeToken returns the token of the unsubscribe links of a category:
+//the user id and the category signed with HMAC-SHA256 using the base64
+//encoded key. It does not expire, links in old messages keep working
+func UnsubscribeToken(key, id, category string) (string, error) {
+
+	if IsMandatory(category) {
+		return "", errors.New(ErrorMandatoryNotification)
+	}
+
+	payload := id + "." + category
+	sig, err := signUnsubscribe(key, payload)
+	if err != nil {
+		return "", err
+	}
+
+	return base64.RawURLEncoding.EncodeToString([]byte(payload)) + "." + sig,
+		nil
+}
+
+//ParseUnsubscribeToken verifies the token and returns the user id and the
+//category
+func ParseUnsubscribeToken(key, token string) (string, string, error) {
+
+	parts := strings.Split(token, ".")
+	if len(parts) != 2 {
+		return "", "", errors.New(ErrorInvalidUnsubscribeToken)
+	}
+
+	payload, err := base64.RawURLEncoding.DecodeString(parts[0])
+	if err != nil {
+		return "", "", errors.New(ErrorInvalidUnsubscribeToken)
+	}
+
+	sig, err := signUnsubscribe(key, string(payload))
+	if err != nil {
+		return "", "", err
+	}
+	if !hmac.Equal([]byte(sig), []byte(parts[1])) {
+		return "", "", errors.New(ErrorInvalidUnsubscribeToken)
+	}
+
+	fields := strings.SplitN(string(payload), ".", 2)
+	if len(fields) != 2 || IsMandatory(fields[1]) {
+		return "", "", errors.New(ErrorInvalidUnsubscribeToken)
+	}
+	if _, ok := notificationDefaults[fields[1]]; !ok {
+		return "", "", errors.New(ErrorInvalidUnsubscribeToken)
+	}
+
+	return fields[0], fields[1], nil
+}
+
+//signUnsubscribe returns the base64 encoded HMAC-SHA256 of the payload
+func signUnsubscribe(key, payload string) (string, error) {
+	k, err := base64.StdEncoding.DecodeString(key)
+	if err != nil || len(k) < 32 {
+		return "", errors.New(ErrorInvalidUnsubscribeKey)
+	}
+
+	mac := hmac.New(sha256.New, k)
+	mac.Write([]byte(payload))
+	return base64.RawURLEncoding.EncodeToString(mac.Sum(nil)), nil
+}
+
+func (u *User) getPreferencesSK() string {
+	return fmt.Sprintf("%s#", DynamoDBPrefixPreferences)
+}
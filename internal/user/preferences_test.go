@@ -0,0 +1,190 @@
+package user
+
+import (
+	"context"
+	"encoding/base64"
+	"errors"
+	"reflect"
+	"testing"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/dynamodb"
+
+	"github.com/roloum/users/internal/apperr"
+	"github.com/roloum/users/internal/test"
+)
+
+//TestPreferencesAllows Tests which categories are sent
+func TestPreferencesAllows(t *testing.T) {
+
+	tests := []struct {
+		desc     string
+		prefs    *Preferences
+		category string
+		expected bool
+	}{
+		{desc: "Defaults", prefs: DefaultPreferences(),
+			category: NotificationProduct, expected: true},
+		{desc: "MarketingWithoutConsent", prefs: DefaultPreferences(),
+			category: NotificationMarketing, expected: false},
+		{desc: "MarketingWithConsent", prefs: &Preferences{
+			Notifications:    map[string]bool{NotificationMarketing: true},
+			MarketingConsent: true}, category: NotificationMarketing,
+			expected: true},
+		{desc: "Disabled", prefs: &Preferences{
+			Notifications: map[string]bool{NotificationProduct: false}},
+			category: NotificationProduct, expected: false},
+		{desc: "Mandatory", prefs: &Preferences{
+			Notifications: map[string]bool{NotificationAccount: false}},
+			category: NotificationAccount, expected: true},
+	}
+
+	for _, tc := range tests {
+		t.Run(tc.desc, func(t *testing.T) {
+			if allows := tc.prefs.Allows(tc.category); allows != tc.expected {
+				t.Errorf("Expected: %v. Received: %v", tc.expected, allows)
+			}
+		})
+	}
+}
+
+//TestSavePreferences Tests the validation of the preferences
+func TestSavePreferences(t *testing.T) {
+
+	tests := []struct {
+		desc  string
+		prefs *Preferences
+		err   error
+	}{
+		{desc: "Valid", prefs: &Preferences{Locale: "es-ES",
+			Timezone: "Europe/Madrid", MarketingConsent: true,
+			Notifications: map[string]bool{NotificationProduct: false}}},
+		{desc: "Invalid", prefs: &Preferences{Locale: "not a locale",
+			Timezone: "Mars/Olympus",
+			Notifications: map[string]bool{NotificationAccount: false,
+				"digest": true},
+			Channels: map[string][]string{NotificationProduct: {"pigeon"}}},
+			err: apperr.Validation(
+				apperr.FieldError{Field: "notifications.account",
+					Code: ErrorMandatoryNotification},
+				apperr.FieldError{Field: "notifications.digest",
+					Code: ErrorUnknownNotification},
+				apperr.FieldError{Field: "channels.product",
+					Code: ErrorUnknownChannel},
+				apperr.FieldError{Field: "locale", Code: ErrorInvalidLocale},
+				apperr.FieldError{Field: "timezone", Code: ErrorInvalidTimezone})},
+	}
+
+	for _, tc := range tests {
+		t.Run(tc.desc, func(t *testing.T) {
+			u := &User{Email: "a@user.com"}
+			err := u.SavePreferences(context.Background(),
+				&test.MockDynamoDB{GetItemOutput: &dynamodb.GetItemOutput{}},
+				UserTable, tc.prefs)
+			if !reflect.DeepEqual(err, tc.err) {
+				t.Errorf("Expected: %v. Received: %v", tc.err, err)
+			}
+		})
+	}
+
+	t.Run(ErrorPhoneNotVerified, func(t *testing.T) {
+		u := &User{Email: "a@user.com"}
+		err := u.SavePreferences(context.Background(),
+			&test.MockDynamoDB{GetItemOutput: &dynamodb.GetItemOutput{}},
+			UserTable, &Preferences{Channels: map[string][]string{
+				NotificationProduct: {ChannelEmail, ChannelSMS}}})
+		expected := apperr.Validation(apperr.FieldError{
+			Field: "channels.product", Code: ErrorPhoneNotVerified})
+		if !reflect.DeepEqual(err, expected) {
+			t.Errorf("Expected: %v. Received: %v", expected, err)
+		}
+	})
+
+	t.Run("ReplacesCategories", func(t *testing.T) {
+		mock := &test.MockDynamoDB{GetItemOutput: &dynamodb.GetItemOutput{
+			Item: map[string]*dynamodb.AttributeValue{
+				"notifications": {M: map[string]*dynamodb.AttributeValue{
+					NotificationProduct: {BOOL: aws.Bool(false)},
+				}},
+				"channels": {M: map[string]*dynamodb.AttributeValue{
+					NotificationProduct: {L: []*dynamodb.AttributeValue{
+						{S: aws.String(ChannelWebhook)},
+					}},
+				}},
+				"locale":                  {S: aws.String("es-ES")},
+				"marketingConsent":        {BOOL: aws.Bool(true)},
+				"marketingConsentUpdated": {S: aws.String("2020-01-01T00:00:00Z")},
+			},
+		}}
+
+		u := &User{Email: "a@user.com"}
+		p := &Preferences{MarketingConsent: true,
+			Notifications: map[string]bool{NotificationMarketing: true}}
+		if err := u.SavePreferences(context.Background(), mock, UserTable,
+			p); err != nil {
+			t.Fatal(err)
+		}
+
+		expected := map[string]bool{NotificationAccount: true,
+			NotificationProduct: true, NotificationMarketing: true}
+		if !reflect.DeepEqual(p.Notifications, expected) {
+			t.Errorf("Expected: %v. Received: %v", expected, p.Notifications)
+		}
+		if p.Channels != nil || p.Locale != "" {
+			t.Errorf("Expected: %v. Received: %v %v", nil, p.Channels, p.Locale)
+		}
+		if p.MarketingConsentUpdated != "2020-01-01T00:00:00Z" {
+			t.Errorf("Expected the consent time to be kept. Received: %v",
+				p.MarketingConsentUpdated)
+		}
+	})
+}
+
+//TestUnsubscribeToken Tests signing and verifying the unsubscribe tokens
+func TestUnsubscribeToken(t *testing.T) {
+
+	key := base64.StdEncoding.EncodeToString(make([]byte, 32))
+	other := base64.StdEncoding.EncodeToString([]byte(
+		"another key of thirty two bytes!"))
+
+	token, err := UnsubscribeToken(key, "8a6e0804-2bd0-4672", NotificationProduct)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		desc     string
+		key      string
+		token    string
+		id       string
+		category string
+		err      error
+	}{
+		{desc: "Valid", key: key, token: token, id: "8a6e0804-2bd0-4672",
+			category: NotificationProduct},
+		{desc: "OtherKey", key: other, token: token,
+			err: errors.New(ErrorInvalidUnsubscribeToken)},
+		{desc: "Tampered", key: key, token: "x" + token,
+			err: errors.New(ErrorInvalidUnsubscribeToken)},
+		{desc: "Malformed", key: key, token: "token",
+			err: errors.New(ErrorInvalidUnsubscribeToken)},
+		{desc: "InvalidKey", key: "short", token: token,
+			err: errors.New(ErrorInvalidUnsubscribeKey)},
+	}
+
+	for _, tc := range tests {
+		t.Run(tc.desc, func(t *testing.T) {
+			id, category, err := ParseUnsubscribeToken(tc.key, tc.token)
+			if id != tc.id || category != tc.category ||
+				!reflect.DeepEqual(err, tc.err) {
+				t.Errorf("Expected: %v %v %v. Received: %v %v %v", tc.id,
+					tc.category, tc.err, id, category, err)
+			}
+		})
+	}
+
+	_, err = UnsubscribeToken(key, "id", NotificationAccount)
+	if !reflect.DeepEqual(err, errors.New(ErrorMandatoryNotification)) {
+		t.Errorf("Expected: %v. Received: %v", ErrorMandatoryNotification, err)
+	}
+}
@@ -0,0 +1,447 @@
+package user
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sort"
+	"strings"
+	"unicode"
+	"unicode/utf8"
+
+	"github.com/rs/zerolog/log"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/dynamodb"
+	"github.com/aws/aws-sdk-go/service/dynamodb/dynamodbattribute"
+	"github.com/aws/aws-sdk-go/service/dynamodb/dynamodbiface"
+)
+
+const (
+	//DynamoDBPrefixName Prefix added to the sort key of the name token rows
+	DynamoDBPrefixName = "NAME"
+
+	//DynamoDBTypeName identifies the name token rows
+	DynamoDBTypeName = "Name"
+
+	//DynamoDBIndexDomain Global secondary index on the email domain of the
+	//profile rows
+	DynamoDBIndexDomain = "DomainIndex"
+
+	//DynamoDBIndexName Global secondary index on the name token rows
+	DynamoDBIndexName = "NameIndex"
+
+	//SearchDefaultLimit number of users returned when no limit is given
+	SearchDefaultLimit = 25
+
+	//SearchMaxLimit maximum number of users returned
+	SearchMaxLimit = 100
+
+	//searchMaxCandidates items read per term of the query
+	searchMaxCandidates = 1000
+
+	//searchMaxTokens name tokens indexed per user
+	searchMaxTokens = 8
+
+	//namePrefixLength runes of the token used as partition key of NameIndex,
+	//which is also the minimum length of a name term
+	namePrefixLength = 2
+
+	//dynamoDBBatchGetSize maximum number of keys in a BatchGetItem request
+	dynamoDBBatchGetSize = 100
+
+	//ErrorSearchQueryTooShort Returned when a term of the query is shorter than
+	//two characters
+	ErrorSearchQueryTooShort = "SearchQueryTooShort"
+)
+
+//Domain returns the lowercased domain of the email
+func Domain(email string) string {
+	at := strings.LastIndex(email, "@")
+	if at < 0 {
+		return ""
+	}
+	return strings.ToLower(email[at+1:])
+}
+
+//NameTokens returns the lowercased words of the first and last name, without
+//duplicates
+func NameTokens(firstName, lastName string) []string {
+
+	words := strings.FieldsFunc(strings.ToLower(firstName+" "+lastName),
+		func(r rune) bool {
+			return !unicode.IsLetter(r) && !unicode.IsDigit(r)
+		})
+
+	seen := map[string]bool{}
+	var tokens []string
+	for _, w := range words {
+		if seen[w] || len(tokens) == searchMaxTokens {
+			continue
+		}
+		seen[w] = true
+		tokens = append(tokens, w)
+	}
+
+	return tokens
+}
+
+//namePrefix returns the partition key of the token in NameIndex
+func namePrefix(token string) string {
+	if utf8.RuneCountInString(token) <= namePrefixLength {
+		return token
+	}
+	return string([]rune(token)[:namePrefixLength])
+}
+
+//searchAttributes adds the normalized search attributes to the profile item
+func (u *User) searchAttributes(item map[string]*dynamodb.AttributeValue) {
+
+	if domain := Domain(u.Email); domain != "" {
+		item["domain"] = &dynamodb.AttributeValue{S: aws.String(domain)}
+	}
+
+	//String sets can not be empty
+	if tokens := NameTokens(u.FirstName, u.LastName); len(tokens) > 0 {
+		item["nameTokens"] = &dynamodb.AttributeValue{SS: aws.StringSlice(tokens)}
+	}
+}
+
+//nameItem returns the row that indexes a name token in NameIndex
+func (u *User) nameItem(token string) map[string]*dynamodb.AttributeValue {
+	return map[string]*dynamodb.AttributeValue{
+		"pk":         {S: aws.String(u.getUserPK())},
+		"sk":         {S: aws.String(fmt.Sprintf("%s#%s", DynamoDBPrefixName, token))},
+		"namePrefix": {S: aws.String(namePrefix(token))},
+		"nameToken":  {S: aws.String(token)},
+		"email":      {S: aws.String(u.Email)},
+		"type":       {S: aws.String(DynamoDBTypeName)},
+	}
+}
+
+//namePuts returns the transaction items that insert the name token rows
+func (u *User) namePuts(tableName string) []*dynamodb.TransactWriteItem {
+
+	var items []*dynamodb.TransactWriteItem
+	for _, token := range NameTokens(u.FirstName, u.LastName) {
+		items = append(items, &dynamodb.TransactWriteItem{
+			Put: &dynamodb.Put{
+				TableName: aws.String(tableName),
+				Item:      u.nameItem(token),
+			},
+		})
+	}
+
+	return items
+}
+
+//SyncSearch writes the search attributes of the profile row and replaces the
+//name token rows, so that they match the current name. Used after the name
+//changes and to backfill users created before search existed
+func (u *User) SyncSearch(ctx context.Context, svc dynamodbiface.DynamoDBAPI,
+	tableName string) error {
+
+	log.Debug().Msgf("Syncing search attributes: %s", u.Email)
+
+	attributes := map[string]*dynamodb.AttributeValue{}
+	u.searchAttributes(attributes)
+
+	update := &dynamodb.UpdateItemInput{
+		TableName: aws.String(tableName),
+		Key: map[string]*dynamodb.AttributeValue{
+			"pk": {S: aws.String(u.getUserPK())},
+			"sk": {S: aws.String(u.getProfileSK())},
+		},
+		ExpressionAttributeNames: map[string]*string{
+			"#D": aws.String("domain"),
+			"#N": aws.String("nameTokens"),
+		},
+		ConditionExpression: aws.String("attribute_exists(pk) AND attribute_exists(sk)"),
+	}
+	if tokens, ok := attributes["nameTokens"]; ok {
+		update.ExpressionAttributeValues = map[string]*dynamodb.AttributeValue{
+			":domain": attributes["domain"],
+			":tokens": tokens,
+		}
+		update.UpdateExpression = aws.String("SET #D = :domain, #N = :tokens")
+	} else {
+		update.ExpressionAttributeValues = map[string]*dynamodb.AttributeValue{
+			":domain": attributes["domain"],
+		}
+		update.UpdateExpression = aws.String("SET #D = :domain REMOVE #N")
+	}
+
+	if _, err := svc.UpdateItemWithContext(ctx, update); err != nil {
+		if isConditionalCheckFailed(err) {
+			return errors.New(ErrorUserDoesNotExist)
+		}
+		return err
+	}
+
+	existing := map[string]bool{}
+	err := svc.QueryPagesWithContext(ctx, &dynamodb.QueryInput{
+		TableName:              aws.String(tableName),
+		KeyConditionExpression: aws.String("pk = :pk AND begins_with(sk, :sk)"),
+		ExpressionAttributeValues: map[string]*dynamodb.AttributeValue{
+			":pk": {S: aws.String(u.getUserPK())},
+			":sk": {S: aws.String(DynamoDBPrefixName + "#")},
+		},
+		ProjectionExpression: aws.String("nameToken"),
+	}, func(page *dynamodb.QueryOutput, last bool) bool {
+		for _, item := range page.Items {
+			if t, ok := item["nameToken"]; ok {
+				existing[aws.StringValue(t.S)] = true
+			}
+		}
+		return true
+	})
+	if err != nil {
+		return err
+	}
+
+	var requests []*dynamodb.WriteRequest
+	for _, token := range NameTokens(u.FirstName, u.LastName) {
+		if existing[token] {
+			delete(existing, token)
+			continue
+		}
+		requests = append(requests, &dynamodb.WriteRequest{
+			PutRequest: &dynamodb.PutRequest{Item: u.nameItem(token)},
+		})
+	}
+	for token := range existing {
+		requests = append(requests, &dynamodb.WriteRequest{
+			DeleteRequest: &dynamodb.DeleteRequest{
+				Key: map[string]*dynamodb.AttributeValue{
+					"pk": {S: aws.String(u.getUserPK())},
+					"sk": {S: aws.String(fmt.Sprintf("%s#%s", DynamoDBPrefixName, token))},
+				},
+			},
+		})
+	}
+
+	return batchWrite(ctx, svc, tableName, requests)
+}
+
+//Search returns the users matching every term of the query, sorted by email.
+//A term is matched as follows:
+//- @domain: users whose email domain is domain
+//- containing @: users whose email starts with the term
+//- otherwise: users with a name word or an email starting with the term
+func Search(ctx context.Context, svc dynamodbiface.DynamoDBAPI, tableName,
+	query string, limit int) ([]*User, error) {
+
+	log.Debug().Msgf("Searching users: %s", query)
+
+	if limit <= 0 {
+		limit = SearchDefaultLimit
+	}
+	if limit > SearchMaxLimit {
+		limit = SearchMaxLimit
+	}
+
+	terms := strings.Fields(strings.ToLower(query))
+	if len(terms) == 0 {
+		return nil, errors.New(ErrorSearchQueryTooShort)
+	}
+
+	//Profiles read along the way, the name index only has the email
+	profiles := map[string]*User{}
+
+	var matches map[string]bool
+	for _, term := range terms {
+
+		var found map[string]bool
+		var err error
+
+		switch {
+		case strings.HasPrefix(term, "@"):
+			found, err = searchDomain(ctx, svc, tableName, term[1:], profiles)
+		case strings.Contains(term, "@"):
+			found, err = searchEmailPrefix(ctx, svc, tableName, term, profiles)
+		default:
+			if utf8.RuneCountInString(term) < namePrefixLength {
+				return nil, errors.New(ErrorSearchQueryTooShort)
+			}
+			found, err = searchNamePrefix(ctx, svc, tableName, term)
+			if err == nil {
+				var byEmail map[string]bool
+				byEmail, err = searchEmailPrefix(ctx, svc, tableName, term, profiles)
+				for email := range byEmail {
+					found[email] = true
+				}
+			}
+		}
+		if err != nil {
+			return nil, err
+		}
+
+		if matches == nil {
+			matches = found
+			continue
+		}
+		for email := range matches {
+			if !found[email] {
+				delete(matches, email)
+			}
+		}
+	}
+
+	emails := make([]string, 0, len(matches))
+	for email := range matches {
+		emails = append(emails, email)
+	}
+	sort.Strings(emails)
+	if len(emails) > limit {
+		emails = emails[:limit]
+	}
+
+	if err := loadProfiles(ctx, svc, tableName, emails, profiles); err != nil {
+		return nil, err
+	}
+
+	users := make([]*User, 0, len(emails))
+	for _, email := range emails {
+		//The profile can be gone if the user was deleted after being indexed
+		if u, ok := profiles[email]; ok {
+			users = append(users, u)
+		}
+	}
+
+	return users, nil
+}
+
+//searchDomain queries DomainIndex
+func searchDomain(ctx context.Context, svc dynamodbiface.DynamoDBAPI,
+	tableName, domain string, profiles map[string]*User) (map[string]bool, error) {
+
+	return queryProfiles(ctx, svc, &dynamodb.QueryInput{
+		TableName:              aws.String(tableName),
+		IndexName:              aws.String(DynamoDBIndexDomain),
+		KeyConditionExpression: aws.String("#D = :domain"),
+		ExpressionAttributeNames: map[string]*string{
+			"#D": aws.String("domain"),
+		},
+		ExpressionAttributeValues: map[string]*dynamodb.AttributeValue{
+			":domain": {S: aws.String(domain)},
+		},
+	}, profiles)
+}
+
+//searchEmailPrefix queries the profile rows in InvertedIndex, whose range key
+//is USER#[email]
+func searchEmailPrefix(ctx context.Context, svc dynamodbiface.DynamoDBAPI,
+	tableName, prefix string, profiles map[string]*User) (map[string]bool, error) {
+
+	return queryProfiles(ctx, svc, &dynamodb.QueryInput{
+		TableName:              aws.String(tableName),
+		IndexName:              aws.String(DynamoDBIndexInverted),
+		KeyConditionExpression: aws.String("sk = :sk AND begins_with(pk, :pk)"),
+		ExpressionAttributeValues: map[string]*dynamodb.AttributeValue{
+			":sk": {S: aws.String(fmt.Sprintf("%s#", DynamoDBPrefixProfile))},
+			":pk": {S: aws.String(fmt.Sprintf("%s#%s", DynamoDBPrefixUser, prefix))},
+		},
+	}, profiles)
+}
+
+//queryProfiles runs a query returning profile rows and keeps the profiles
+func queryProfiles(ctx context.Context, svc dynamodbiface.DynamoDBAPI,
+	input *dynamodb.QueryInput, profiles map[string]*User) (map[string]bool, error) {
+
+	found := map[string]bool{}
+
+	var err error
+	qerr := svc.QueryPagesWithContext(ctx, input,
+		func(page *dynamodb.QueryOutput, last bool) bool {
+			for _, item := range page.Items {
+				var u User
+				if err = dynamodbattribute.UnmarshalMap(item, &u); err != nil {
+					return false
+				}
+				profiles[u.Email] = &u
+				found[u.Email] = true
+			}
+			return len(found) < searchMaxCandidates
+		})
+	if qerr != nil {
+		return nil, qerr
+	}
+
+	return found, err
+}
+
+//searchNamePrefix queries NameIndex
+func searchNamePrefix(ctx context.Context, svc dynamodbiface.DynamoDBAPI,
+	tableName, prefix string) (map[string]bool, error) {
+
+	found := map[string]bool{}
+
+	err := svc.QueryPagesWithContext(ctx, &dynamodb.QueryInput{
+		TableName:              aws.String(tableName),
+		IndexName:              aws.String(DynamoDBIndexName),
+		KeyConditionExpression: aws.String("namePrefix = :prefix AND begins_with(nameToken, :token)"),
+		ExpressionAttributeValues: map[string]*dynamodb.AttributeValue{
+			":prefix": {S: aws.String(namePrefix(prefix))},
+			":token":  {S: aws.String(prefix)},
+		},
+	}, func(page *dynamodb.QueryOutput, last bool) bool {
+		for _, item := range page.Items {
+			if email, ok := item["email"]; ok {
+				found[aws.StringValue(email.S)] = true
+			}
+		}
+		return len(found) < searchMaxCandidates
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return found, nil
+}
+
+//loadProfiles reads the profiles of the emails that are not in profiles yet
+func loadProfiles(ctx context.Context, svc dynamodbiface.DynamoDBAPI,
+	tableName string, emails []string, profiles map[string]*User) error {
+
+	var keys []map[string]*dynamodb.AttributeValue
+	for _, email := range emails {
+		if _, ok := profiles[email]; ok {
+			continue
+		}
+		u := &User{Email: email}
+		keys = append(keys, map[string]*dynamodb.AttributeValue{
+			"pk": {S: aws.String(u.getUserPK())},
+			"sk": {S: aws.String(u.getProfileSK())},
+		})
+	}
+
+	for start := 0; start < len(keys); start += dynamoDBBatchGetSize {
+		end := start + dynamoDBBatchGetSize
+		if end > len(keys) {
+			end = len(keys)
+		}
+
+		request := map[string]*dynamodb.KeysAndAttributes{
+			tableName: {Keys: keys[start:end]},
+		}
+		for len(request) > 0 {
+			result, err := svc.BatchGetItemWithContext(ctx,
+				&dynamodb.BatchGetItemInput{RequestItems: request})
+			if err != nil {
+				return err
+			}
+
+			for _, item := range result.Responses[tableName] {
+				var u User
+				if err := dynamodbattribute.UnmarshalMap(item, &u); err != nil {
+					return err
+				}
+				profiles[u.Email] = &u
+			}
+
+			request = result.UnprocessedKeys
+		}
+	}
+
+	return nil
+}
@@ -0,0 +1,101 @@
+package user
+
+import (
+	"context"
+	"errors"
+	"reflect"
+	"testing"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/dynamodb"
+
+	"github.com/roloum/users/internal/test"
+)
+
+//TestNameTokens Tests the normalization of names into search tokens
+func TestNameTokens(t *testing.T) {
+
+	tests := []struct {
+		desc      string
+		firstName string
+		lastName  string
+		expected  []string
+	}{
+		{desc: "Simple", firstName: "John", lastName: "Smith",
+			expected: []string{"john", "smith"}},
+		{desc: "Compound", firstName: "Mary-Ann", lastName: "van der Berg",
+			expected: []string{"mary", "ann", "van", "der", "berg"}},
+		{desc: "Unicode", firstName: "José", lastName: "Ñúñez",
+			expected: []string{"josé", "ñúñez"}},
+		{desc: "Duplicates", firstName: "Smith", lastName: "SMITH",
+			expected: []string{"smith"}},
+		{desc: "Empty", expected: nil},
+	}
+
+	for _, tc := range tests {
+		t.Run(tc.desc, func(t *testing.T) {
+			tokens := NameTokens(tc.firstName, tc.lastName)
+			if !reflect.DeepEqual(tokens, tc.expected) {
+				t.Errorf("Expected: %v. Received: %v", tc.expected, tokens)
+			}
+		})
+	}
+}
+
+//TestSearch Tests the Search functionality
+func TestSearch(t *testing.T) {
+
+	profile := func(email, firstName, lastName string) map[string]*dynamodb.AttributeValue {
+		return map[string]*dynamodb.AttributeValue{
+			"pk":        {S: aws.String("USER#" + email)},
+			"sk":        {S: aws.String("PROFILE#")},
+			"email":     {S: aws.String(email)},
+			"firstName": {S: aws.String(firstName)},
+			"lastName":  {S: aws.String(lastName)},
+		}
+	}
+
+	mock := &test.MockDynamoDB{QueryOutput: &dynamodb.QueryOutput{
+		Items: []map[string]*dynamodb.AttributeValue{
+			profile("john@acme.com", "John", "Smith"),
+			profile("jane@acme.com", "Jane", "Smith"),
+		},
+	}}
+
+	tests := []struct {
+		desc     string
+		query    string
+		limit    int
+		expected []string
+		err      error
+	}{
+		{desc: "Domain", query: "@acme.com",
+			expected: []string{"jane@acme.com", "john@acme.com"}},
+		{desc: "NameAndDomain", query: "Smith @acme.com",
+			expected: []string{"jane@acme.com", "john@acme.com"}},
+		{desc: "Limit", query: "smith", limit: 1,
+			expected: []string{"jane@acme.com"}},
+		{desc: ErrorSearchQueryTooShort, query: "s",
+			err: errors.New(ErrorSearchQueryTooShort)},
+		{desc: "Empty", query: "  ",
+			err: errors.New(ErrorSearchQueryTooShort)},
+	}
+
+	for _, tc := range tests {
+		t.Run(tc.desc, func(t *testing.T) {
+			users, err := Search(context.Background(), mock, UserTable,
+				tc.query, tc.limit)
+			if !reflect.DeepEqual(err, tc.err) {
+				t.Fatalf("Expected: %v. Received: %v", tc.err, err)
+			}
+
+			var emails []string
+			for _, u := range users {
+				emails = append(emails, u.Email)
+			}
+			if !reflect.DeepEqual(emails, tc.expected) {
+				t.Errorf("Expected: %v. Received: %v", tc.expected, emails)
+			}
+		})
+	}
+}
@@ -0,0 +1,302 @@
+package user
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/rs/zerolog/log"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/dynamodb"
+	"github.com/aws/aws-sdk-go/service/dynamodb/dynamodbiface"
+)
+
+const (
+	//DynamoDBPrefixSession Prefix added to the sort key of a session row
+	DynamoDBPrefixSession = "SESSION"
+
+	//DynamoDBTypeSession identifies the session rows in dynamoDB
+	DynamoDBTypeSession = "Session"
+
+	//ErrorInvalidSession Returned when the session token is malformed, unknown
+	//or expired
+	ErrorInvalidSession = "InvalidSession"
+
+	//MFAChallengeTTL is how long the session of a sign in waiting for the MFA
+	//code is valid
+	MFAChallengeTTL = 5 * time.Minute
+)
+
+//Session is the token handed to the user after signing in. The token carries
+//the email so the session row can be found in the user's partition, and only
+//a hash of the secret part is stored
+type Session struct {
+	Token   string    `json:"token"`
+	Email   string    `json:"email"`
+	Expires time.Time `json:"expires"`
+
+	//MFARequired sessions are not valid until CompleteMFA exchanges them, with
+	//the MFA code, for a full session
+	MFARequired bool `json:"mfaRequired,omitempty"`
+}
+
+//newSession generates a session for the user that expires after ttl
+func (u *User) newSession(ttl time.Duration) (*Session, string, error) {
+	secret, err := randomToken(32)
+	if err != nil {
+		return nil, "", err
+	}
+
+	s := &Session{
+		Token: fmt.Sprintf("%s.%s",
+			base64.RawURLEncoding.EncodeToString([]byte(u.Email)), secret),
+		Email:   u.Email,
+		Expires: time.Now().Add(ttl).UTC(),
+	}
+
+	return s, hashToken(secret), nil
+}
+
+//CreateSession stores a new session for the user that expires after ttl
+func (u *User) CreateSession(ctx context.Context, svc dynamodbiface.DynamoDBAPI,
+	tableName string, ttl time.Duration) (*Session, error) {
+
+	log.Debug().Msgf("Creating session: %s", u.Email)
+
+	session, hash, err := u.newSession(ttl)
+	if err != nil {
+		return nil, err
+	}
+
+	put := u.sessionPut(session, hash, tableName)
+
+	_, err = svc.PutItemWithContext(ctx, &dynamodb.PutItemInput{
+		TableName:           put.TableName,
+		Item:                put.Item,
+		ConditionExpression: put.ConditionExpression,
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return session, nil
+}
+
+//LoadSession returns the user owning the session token. Expired sessions are
+//rejected even if DynamoDB has not removed the row yet, and so are the
+//sessions of deactivated users
+func LoadSession(ctx context.Context, svc dynamodbiface.DynamoDBAPI,
+	tableName, token string) (*User, error) {
+
+	email, secret, err := parseSessionToken(token)
+	if err != nil {
+		return nil, err
+	}
+
+	log.Debug().Msgf("Loading session: %s", email)
+
+	u := &User{Email: email}
+
+	result, err := svc.GetItemWithContext(ctx, &dynamodb.GetItemInput{
+		TableName: aws.String(tableName),
+		Key: map[string]*dynamodb.AttributeValue{
+			"pk": {S: aws.String(u.getUserPK())},
+			"sk": {S: aws.String(getSessionSK(hashToken(secret)))},
+		},
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	if !validSession(result.Item, false) {
+		return nil, errors.New(ErrorInvalidSession)
+	}
+
+	if err := u.Load(ctx, svc, tableName); err != nil {
+		return nil, err
+	}
+
+	if !u.Active {
+		return nil, errors.New(ErrorUserDeactivated)
+	}
+
+	return u, nil
+}
+
+//signInSession returns a new session of the user, or the session waiting for
+//the MFA code when the user has MFA enabled
+func (u *User) signInSession(ctx context.Context, svc dynamodbiface.DynamoDBAPI,
+	tableName string, ttl time.Duration) (*Session, string, error) {
+
+	mfa, err := u.MFAEnabled(ctx, svc, tableName)
+	if err != nil {
+		return nil, "", err
+	}
+
+	if !mfa {
+		return u.newSession(ttl)
+	}
+
+	session, hash, err := u.newSession(MFAChallengeTTL)
+	if err != nil {
+		return nil, "", err
+	}
+	session.MFARequired = true
+
+	return session, hash, nil
+}
+
+//CompleteMFA verifies the MFA code of a session waiting for it and replaces it
+//with a full session that expires after ttl
+func CompleteMFA(ctx context.Context, svc dynamodbiface.DynamoDBAPI,
+	tableName, token, key, code string, ttl time.Duration) (*Session, error) {
+
+	email, secret, err := parseSessionToken(token)
+	if err != nil {
+		return nil, err
+	}
+
+	log.Debug().Msgf("Completing MFA: %s", email)
+
+	u := &User{Email: email}
+	sk := getSessionSK(hashToken(secret))
+
+	result, err := svc.GetItemWithContext(ctx, &dynamodb.GetItemInput{
+		TableName: aws.String(tableName),
+		Key: map[string]*dynamodb.AttributeValue{
+			"pk": {S: aws.String(u.getUserPK())},
+			"sk": {S: aws.String(sk)},
+		},
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	if !validSession(result.Item, true) {
+		return nil, errors.New(ErrorInvalidSession)
+	}
+
+	if err := u.Load(ctx, svc, tableName); err != nil {
+		return nil, err
+	}
+
+	if !u.Active {
+		return nil, errors.New(ErrorUserDeactivated)
+	}
+
+	if err := u.VerifyMFA(ctx, svc, tableName, key, code); err != nil {
+		return nil, err
+	}
+
+	session, hash, err := u.newSession(ttl)
+	if err != nil {
+		return nil, err
+	}
+
+	//The pending session is consumed with the creation of the full one, so
+	//it can only be completed once
+	_, err = svc.TransactWriteItemsWithContext(ctx, &dynamodb.TransactWriteItemsInput{
+		TransactItems: []*dynamodb.TransactWriteItem{
+			{
+				Delete: &dynamodb.Delete{
+					TableName: aws.String(tableName),
+					Key: map[string]*dynamodb.AttributeValue{
+						"pk": {S: aws.String(u.getUserPK())},
+						"sk": {S: aws.String(sk)},
+					},
+					ConditionExpression: aws.String("attribute_exists(pk)"),
+				},
+			},
+			{Put: u.sessionPut(session, hash, tableName)},
+		},
+	})
+	if err != nil {
+		if isConditionCanceled(err) {
+			return nil, errors.New(ErrorInvalidSession)
+		}
+		return nil, err
+	}
+
+	return session, nil
+}
+
+//validSession tells whether the session row exists, has not expired and is
+//waiting for the MFA code or not, as expected
+func validSession(item map[string]*dynamodb.AttributeValue,
+	mfaRequired bool) bool {
+
+	if item == nil || item["ttl"] == nil {
+		return false
+	}
+
+	expires, err := strconv.ParseInt(aws.StringValue(item["ttl"].N), 10, 64)
+	if err != nil || expires < time.Now().Unix() {
+		return false
+	}
+
+	pending := item["mfaRequired"] != nil && aws.BoolValue(item["mfaRequired"].BOOL)
+
+	return pending == mfaRequired
+}
+
+//sessionPut returns the transaction item that stores the session
+func (u *User) sessionPut(s *Session, hash, tableName string) *dynamodb.Put {
+	put := &dynamodb.Put{
+		TableName: aws.String(tableName),
+		Item: map[string]*dynamodb.AttributeValue{
+			"pk":      {S: aws.String(u.getUserPK())},
+			"sk":      {S: aws.String(getSessionSK(hash))},
+			"email":   {S: aws.String(u.Email)},
+			"expires": {S: aws.String(s.Expires.Format(time.RFC3339))},
+			"ttl":     {N: aws.String(strconv.FormatInt(s.Expires.Unix(), 10))},
+			"type":    {S: aws.String(DynamoDBTypeSession)},
+		},
+		ConditionExpression: aws.String("attribute_not_exists(pk) and attribute_not_exists(sk)"),
+	}
+	if s.MFARequired {
+		put.Item["mfaRequired"] = &dynamodb.AttributeValue{BOOL: aws.Bool(true)}
+	}
+	return put
+}
+
+//parseSessionToken splits a session token into email and secret
+func parseSessionToken(token string) (string, string, error) {
+	parts := strings.SplitN(token, ".", 2)
+	if len(parts) != 2 || parts[1] == "" {
+		return "", "", errors.New(ErrorInvalidSession)
+	}
+
+	email, err := base64.RawURLEncoding.DecodeString(parts[0])
+	if err != nil || len(email) == 0 {
+		return "", "", errors.New(ErrorInvalidSession)
+	}
+
+	return string(email), parts[1], nil
+}
+
+func getSessionSK(hash string) string {
+	return fmt.Sprintf("%s#%s", DynamoDBPrefixSession, hash)
+}
+
+//randomToken returns n random bytes base64url encoded
+func randomToken(n int) (string, error) {
+	b := make([]byte, n)
+	if _, err := rand.Read(b); err != nil {
+		return "", err
+	}
+	return base64.RawURLEncoding.EncodeToString(b), nil
+}
+
+//hashToken returns the hex encoded SHA-256 hash of a token
+func hashToken(token string) string {
+	sum := sha256.Sum256([]byte(token))
+	return hex.EncodeToString(sum[:])
+}
@@ -0,0 +1,511 @@
+package user
+
+import (
+	"context"
+	"errors"
+	"strconv"
+	"time"
+
+	"github.com/rs/zerolog/log"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/dynamodb"
+	"github.com/aws/aws-sdk-go/service/dynamodb/dynamodbattribute"
+	"github.com/aws/aws-sdk-go/service/dynamodb/dynamodbiface"
+)
+
+const (
+	//DynamoDBIndexInactive Global secondary index on the profiles of the users
+	//who signed up and never activated the account
+	DynamoDBIndexInactive = "InactiveIndex"
+
+	//DynamoDBAttributeInactive hash key of the inactive index. The profile has
+	//it, with the value DynamoDBTypeUser, until the account is activated.
+	//Users created silently or deactivated later are not in the index
+	DynamoDBAttributeInactive = "inactive"
+
+	//SweepActionRemind the user was sent an activation reminder
+	SweepActionRemind = "Remind"
+
+	//SweepActionDelete the account was deleted, freeing the email
+	SweepActionDelete = "Delete"
+
+	//ErrorInvalidSweepSchedule Returned when the reminders are not increasing
+	//or not before the cutoff
+	ErrorInvalidSweepSchedule = "InvalidSweepSchedule"
+
+	//ErrorSweepConflict Returned when the profile changed since the sweep read
+	//it: the account was activated or handled by another sweep
+	ErrorSweepConflict = "SweepConflict"
+)
+
+//SweepConfig is the schedule of the sweep of inactive users. Reminders are
+//the ages of the account, since creation, at which each reminder is due, and
+//Cutoff is the age after which the account is deleted
+type SweepConfig struct {
+	Reminders []time.Duration `default:"72h,168h,336h"`
+	Cutoff    time.Duration   `default:"720h"`
+	DryRun    bool
+
+	//DeleteAvatar deletes the images of the avatar of a deleted account. Set
+	//by the callers holding the avatar storage
+	DeleteAvatar func(ctx context.Context, urls map[string]string) `ignored:"true"`
+}
+
+//InactiveUser is the profile of an user who never activated the account, as
+//read by Sweep and by the notify handler mailing the reminders
+type InactiveUser struct {
+	ID        string `json:"id"`
+	Email     string `json:"email"`
+	FirstName string `json:"firstName"`
+	Active    bool   `json:"active"`
+	Created   string `json:"created"`
+
+	//Reminders is the number of reminders sent, the last one at Reminded
+	Reminders int    `json:"reminders,omitempty"`
+	Reminded  string `json:"reminded,omitempty"`
+
+	//Deletion is when the account is deleted if it is still inactive
+	Deletion string `json:"deletion,omitempty"`
+}
+
+//SweepAction is a reminder or a deletion done by the sweep, or that would be
+//done in a dry run
+type SweepAction struct {
+	Action   string `json:"action"`
+	Email    string `json:"email"`
+	ID       string `json:"id"`
+	Created  string `json:"created"`
+	Reminder int    `json:"reminder,omitempty"`
+	Deletion string `json:"deletion,omitempty"`
+	Error    string `json:"error,omitempty"`
+}
+
+//SweepSummary reports a sweep. Scanned counts the inactive users old enough
+//to have something due, Failed the actions that returned an error
+type SweepSummary struct {
+	Started  string        `json:"started"`
+	Finished string        `json:"finished"`
+	DryRun   bool          `json:"dryRun"`
+	Scanned  int           `json:"scanned"`
+	Reminded int           `json:"reminded"`
+	Deleted  int           `json:"deleted"`
+	Failed   int           `json:"failed"`
+	Actions  []SweepAction `json:"actions"`
+}
+
+//Validate verifies that the reminders are positive, increasing and before
+//the cutoff
+func (c SweepConfig) Validate() error {
+	if c.Cutoff <= 0 {
+		return errors.New(ErrorInvalidSweepSchedule)
+	}
+
+	var last time.Duration
+	for _, r := range c.Reminders {
+		if r <= last || r >= c.Cutoff {
+			return errors.New(ErrorInvalidSweepSchedule)
+		}
+		last = r
+	}
+
+	return nil
+}
+
+//Sweep reminds the users who did not activate the account on the schedule of
+//cfg and deletes the accounts still inactive after the cutoff, freeing the
+//email for a new sign up. The reminders are mailed by the notify handler when
+//the profile records them. Each action is logged and reported in the summary;
+//a failed action does not stop the sweep
+func Sweep(ctx context.Context, svc dynamodbiface.DynamoDBAPI, tableName string,
+	cfg SweepConfig, now time.Time) (*SweepSummary, error) {
+
+	log.Info().Msgf("Sweeping inactive users, dry run: %v", cfg.DryRun)
+
+	if tableName == "" {
+		return nil, errors.New(ErrorUserTableNameIsEmpty)
+	}
+
+	if err := cfg.Validate(); err != nil {
+		return nil, err
+	}
+
+	now = now.UTC()
+
+	summary := &SweepSummary{
+		Started: now.Format(time.RFC3339),
+		DryRun:  cfg.DryRun,
+		Actions: []SweepAction{},
+	}
+
+	//Nothing is due for the users younger than the first reminder
+	first := cfg.Cutoff
+	if len(cfg.Reminders) > 0 {
+		first = cfg.Reminders[0]
+	}
+
+	users, err := inactiveUsers(ctx, svc, tableName,
+		now.Add(-first).Format("2006-01-02"))
+	if err != nil {
+		return nil, err
+	}
+
+	for _, p := range users {
+		summary.Scanned++
+
+		a, deletion := cfg.next(p, now)
+		if a == nil {
+			continue
+		}
+
+		if !cfg.DryRun {
+			var err error
+			switch a.Action {
+			case SweepActionRemind:
+				err = p.remind(ctx, svc, tableName, a.Reminder, now, deletion)
+			case SweepActionDelete:
+				err = p.delete(ctx, svc, tableName, cfg.DeleteAvatar)
+			}
+			if err != nil {
+				a.Error = err.Error()
+			}
+		}
+
+		if a.Error != "" {
+			summary.Failed++
+			log.Error().Str("action", a.Action).Str("email", a.Email).
+				Str("error", a.Error).Msg("Sweep action failed")
+		} else {
+			if a.Action == SweepActionRemind {
+				summary.Reminded++
+			} else {
+				summary.Deleted++
+			}
+			log.Info().Str("action", a.Action).Str("email", a.Email).
+				Int("reminder", a.Reminder).Bool("dryRun", cfg.DryRun).
+				Msg("Sweep action")
+		}
+
+		summary.Actions = append(summary.Actions, *a)
+	}
+
+	summary.Finished = time.Now().UTC().Format(time.RFC3339)
+
+	log.Info().Msgf("Sweep done: %d scanned, %d reminded, %d deleted, %d failed",
+		summary.Scanned, summary.Reminded, summary.Deleted, summary.Failed)
+
+	return summary, nil
+}
+
+//next returns the action due for the user, if any, and the deletion time
+//announced by a reminder. Each reminder keeps the notice between its nominal
+//age and the cutoff, so the users created before the sweep existed, or missed
+//by a failed run, are never deleted without it. Only one reminder is sent per
+//run
+func (c SweepConfig) next(p InactiveUser, now time.Time) (*SweepAction,
+	time.Time) {
+
+	created, err := time.Parse("2006-01-02", p.Created)
+	if err != nil {
+		log.Warn().Msgf("Invalid creation date of %s: %s", p.Email, p.Created)
+		return nil, time.Time{}
+	}
+
+	a := &SweepAction{
+		Email:   p.Email,
+		ID:      p.ID,
+		Created: p.Created,
+	}
+
+	if p.Reminders < len(c.Reminders) {
+		if now.Sub(created) < c.Reminders[p.Reminders] {
+			return nil, time.Time{}
+		}
+
+		deletion := created.Add(c.Cutoff)
+		if notice := now.Add(c.Cutoff - c.Reminders[p.Reminders]); notice.After(deletion) {
+			deletion = notice
+		}
+
+		a.Action = SweepActionRemind
+		a.Reminder = p.Reminders + 1
+		a.Deletion = deletion.Format(time.RFC3339)
+
+		return a, deletion
+	}
+
+	deletion := created.Add(c.Cutoff)
+	if p.Deletion != "" {
+		if d, err := time.Parse(time.RFC3339, p.Deletion); err == nil {
+			deletion = d
+		}
+	}
+
+	if now.Before(deletion) {
+		return nil, time.Time{}
+	}
+
+	a.Action = SweepActionDelete
+
+	return a, deletion
+}
+
+//inactiveUsers queries the inactive index for the users created on or before
+//the date
+func inactiveUsers(ctx context.Context, svc dynamodbiface.DynamoDBAPI,
+	tableName, created string) ([]InactiveUser, error) {
+
+	var users []InactiveUser
+
+	var uerr error
+	err := svc.QueryPagesWithContext(ctx, &dynamodb.QueryInput{
+		TableName:              aws.String(tableName),
+		IndexName:              aws.String(DynamoDBIndexInactive),
+		KeyConditionExpression: aws.String("#I = :inactive AND #C <= :created"),
+		ExpressionAttributeNames: map[string]*string{
+			"#I": aws.String(DynamoDBAttributeInactive),
+			"#C": aws.String("created"),
+		},
+		ExpressionAttributeValues: map[string]*dynamodb.AttributeValue{
+			":inactive": {S: aws.String(DynamoDBTypeUser)},
+			":created":  {S: aws.String(created)},
+		},
+	}, func(page *dynamodb.QueryOutput, last bool) bool {
+		var p []InactiveUser
+		if uerr = dynamodbattribute.UnmarshalListOfMaps(page.Items, &p); uerr != nil {
+			return false
+		}
+		users = append(users, p...)
+		return true
+	})
+	if err != nil {
+		return nil, err
+	}
+	if uerr != nil {
+		return nil, uerr
+	}
+
+	return users, nil
+}
+
+//remind records the reminder on the profile, unless the account was activated
+//or reminded by another sweep since it was read. The change of the profile is
+//what the notify handler mails
+func (p InactiveUser) remind(ctx context.Context, svc dynamodbiface.DynamoDBAPI,
+	tableName string, reminder int, now, deletion time.Time) error {
+
+	u := &User{Email: p.Email}
+
+	_, err := svc.UpdateItemWithContext(ctx, &dynamodb.UpdateItemInput{
+		TableName: aws.String(tableName),
+		Key: map[string]*dynamodb.AttributeValue{
+			"pk": {S: aws.String(u.getUserPK())},
+			"sk": {S: aws.String(u.getProfileSK())},
+		},
+		ExpressionAttributeNames: map[string]*string{
+			"#A": aws.String("active"),
+			"#I": aws.String(DynamoDBAttributeInactive),
+			"#R": aws.String("reminders"),
+			"#T": aws.String("reminded"),
+			"#D": aws.String("deletion"),
+		},
+		ExpressionAttributeValues: map[string]*dynamodb.AttributeValue{
+			":inactive": {BOOL: aws.Bool(false)},
+			":previous": {N: aws.String(strconv.Itoa(p.Reminders))},
+			":reminder": {N: aws.String(strconv.Itoa(reminder))},
+			":reminded": {S: aws.String(now.Format(time.RFC3339))},
+			":deletion": {S: aws.String(deletion.Format(time.RFC3339))},
+		},
+		UpdateExpression: aws.String("SET #R = :reminder, #T = :reminded, #D = :deletion"),
+		ConditionExpression: aws.String("#A = :inactive AND attribute_exists(#I) " +
+			"AND (attribute_not_exists(#R) OR #R = :previous)"),
+	})
+	if err != nil {
+		if isConditionalCheckFailed(err) {
+			return errors.New(ErrorSweepConflict)
+		}
+		return err
+	}
+
+	return nil
+}
+
+//delete deletes the user's partition and the images of its avatar if the
+//account is still inactive. The rows are deleted in transactions conditioned
+//on the inactive profile, which goes in the last one, so an activation racing
+//the sweep either wins or finds no account, and the rows of an user signing
+//up again with the email are never deleted
+func (p InactiveUser) delete(ctx context.Context, svc dynamodbiface.DynamoDBAPI,
+	tableName string, deleteAvatar func(context.Context,
+		map[string]string)) error {
+
+	u := &User{Email: p.Email}
+
+	profile := map[string]*dynamodb.AttributeValue{
+		"pk": {S: aws.String(u.getUserPK())},
+		"sk": {S: aws.String(u.getProfileSK())},
+	}
+	names := map[string]*string{
+		"#A": aws.String("active"),
+		"#I": aws.String(DynamoDBAttributeInactive),
+	}
+	values := map[string]*dynamodb.AttributeValue{
+		":inactive": {BOOL: aws.Bool(false)},
+	}
+	condition := aws.String("#A = :inactive AND attribute_exists(#I)")
+
+	var rows []map[string]*dynamodb.AttributeValue
+	var old struct {
+		Avatar map[string]string `dynamodbav:"avatar"`
+	}
+
+	var uerr error
+	err := svc.QueryPagesWithContext(ctx, &dynamodb.QueryInput{
+		TableName:              aws.String(tableName),
+		KeyConditionExpression: aws.String("pk = :pk"),
+		ExpressionAttributeNames: map[string]*string{
+			"#V": aws.String("avatar"),
+		},
+		ExpressionAttributeValues: map[string]*dynamodb.AttributeValue{
+			":pk": {S: aws.String(u.getUserPK())},
+		},
+		ProjectionExpression: aws.String("pk, sk, #V"),
+	}, func(page *dynamodb.QueryOutput, last bool) bool {
+		for _, item := range page.Items {
+			if aws.StringValue(item["sk"].S) == u.getProfileSK() {
+				if uerr = dynamodbattribute.UnmarshalMap(item, &old); uerr != nil {
+					return false
+				}
+				continue
+			}
+			rows = append(rows, map[string]*dynamodb.AttributeValue{
+				"pk": item["pk"], "sk": item["sk"]})
+		}
+		return true
+	})
+	if err != nil {
+		return err
+	}
+	if uerr != nil {
+		return uerr
+	}
+
+	for start := 0; ; start += DynamoDBTransactSize - 1 {
+		end := start + DynamoDBTransactSize - 1
+		if end > len(rows) {
+			end = len(rows)
+		}
+
+		items := make([]*dynamodb.TransactWriteItem, 0, end-start+1)
+		for _, key := range rows[start:end] {
+			items = append(items, &dynamodb.TransactWriteItem{
+				Delete: &dynamodb.Delete{
+					TableName: aws.String(tableName),
+					Key:       key,
+				},
+			})
+		}
+
+		last := end == len(rows)
+		if last {
+			items = append(items, &dynamodb.TransactWriteItem{
+				Delete: &dynamodb.Delete{
+					TableName:                 aws.String(tableName),
+					Key:                       profile,
+					ExpressionAttributeNames:  names,
+					ExpressionAttributeValues: values,
+					ConditionExpression:       condition,
+				},
+			})
+		} else {
+			items = append(items, &dynamodb.TransactWriteItem{
+				ConditionCheck: &dynamodb.ConditionCheck{
+					TableName:                 aws.String(tableName),
+					Key:                       profile,
+					ExpressionAttributeNames:  names,
+					ExpressionAttributeValues: values,
+					ConditionExpression:       condition,
+				},
+			})
+		}
+
+		_, err := svc.TransactWriteItemsWithContext(ctx,
+			&dynamodb.TransactWriteItemsInput{TransactItems: items})
+		if err != nil {
+			if isConditionCanceled(err) {
+				return errors.New(ErrorSweepConflict)
+			}
+			return err
+		}
+
+		if last {
+			break
+		}
+	}
+
+	if deleteAvatar != nil && len(old.Avatar) > 0 {
+		deleteAvatar(ctx, old.Avatar)
+	}
+
+	return nil
+}
+
+//SyncInactive sets the inactive index attribute of an user who signed up and
+//has not activated the account, that is an inactive user with an activation
+//token that is not silent, and removes it from any other user
+func (u *User) SyncInactive(ctx context.Context, svc dynamodbiface.DynamoDBAPI,
+	tableName string) error {
+
+	pending := false
+	if !u.Active {
+		out, err := svc.GetItemWithContext(ctx, &dynamodb.GetItemInput{
+			TableName: aws.String(tableName),
+			Key: map[string]*dynamodb.AttributeValue{
+				"pk": {S: aws.String(u.getUserPK())},
+				"sk": {S: aws.String(u.getTokenSK())},
+			},
+		})
+		if err != nil {
+			return err
+		}
+		if out != nil && len(out.Item) > 0 {
+			silent, ok := out.Item[DynamoDBAttributeSilent]
+			pending = !ok || !aws.BoolValue(silent.BOOL)
+		}
+	}
+
+	update := &dynamodb.UpdateItemInput{
+		TableName: aws.String(tableName),
+		Key: map[string]*dynamodb.AttributeValue{
+			"pk": {S: aws.String(u.getUserPK())},
+			"sk": {S: aws.String(u.getProfileSK())},
+		},
+		ExpressionAttributeNames: map[string]*string{
+			"#I": aws.String(DynamoDBAttributeInactive),
+		},
+		ConditionExpression: aws.String("attribute_exists(pk) AND attribute_exists(sk)"),
+	}
+	if pending {
+		update.ExpressionAttributeValues = map[string]*dynamodb.AttributeValue{
+			":inactive": {S: aws.String(DynamoDBTypeUser)},
+		}
+		update.UpdateExpression = aws.String("SET #I = :inactive")
+	} else {
+		update.UpdateExpression = aws.String("REMOVE #I")
+	}
+
+	if _, err := svc.UpdateItemWithContext(ctx, update); err != nil {
+		if isConditionalCheckFailed(err) {
+			return errors.New(ErrorUserDoesNotExist)
+		}
+		return err
+	}
+
+	return nil
+}
+
+//IsReminder tells whether the change of the profile is a reminder recorded by
+//Sweep
+func IsReminder(old, new *InactiveUser) bool {
+	return !new.Active && new.Reminders > old.Reminders
+}
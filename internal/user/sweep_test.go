@@ -0,0 +1,289 @@
+package user
+
+import (
+	"context"
+	"errors"
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/request"
+	"github.com/aws/aws-sdk-go/service/dynamodb"
+
+	"github.com/roloum/users/internal/test"
+)
+
+//TestSweepConfigValidate Tests the validation of the sweep schedule
+func TestSweepConfigValidate(t *testing.T) {
+
+	day := 24 * time.Hour
+
+	tests := []struct {
+		desc string
+		cfg  SweepConfig
+		err  error
+	}{
+		{
+			desc: "Default",
+			cfg: SweepConfig{Reminders: []time.Duration{3 * day, 7 * day,
+				14 * day}, Cutoff: 30 * day},
+		},
+		{
+			desc: "NoReminders",
+			cfg:  SweepConfig{Cutoff: 30 * day},
+		},
+		{
+			desc: "NoCutoff",
+			cfg:  SweepConfig{Reminders: []time.Duration{3 * day}},
+			err:  errors.New(ErrorInvalidSweepSchedule),
+		},
+		{
+			desc: "NotIncreasing",
+			cfg: SweepConfig{Reminders: []time.Duration{7 * day, 3 * day},
+				Cutoff: 30 * day},
+			err: errors.New(ErrorInvalidSweepSchedule),
+		},
+		{
+			desc: "AfterCutoff",
+			cfg: SweepConfig{Reminders: []time.Duration{3 * day, 30 * day},
+				Cutoff: 30 * day},
+			err: errors.New(ErrorInvalidSweepSchedule),
+		},
+	}
+
+	for _, tc := range tests {
+		t.Run(tc.desc, func(t *testing.T) {
+			err := tc.cfg.Validate()
+			if !reflect.DeepEqual(err, tc.err) {
+				t.Errorf("Expected: %v. Received: %v", tc.err, err)
+			}
+		})
+	}
+}
+
+//TestSweepNext Tests the action due for an inactive user
+func TestSweepNext(t *testing.T) {
+
+	day := 24 * time.Hour
+	cfg := SweepConfig{Reminders: []time.Duration{3 * day, 7 * day},
+		Cutoff: 30 * day}
+	now := time.Date(2020, 3, 1, 3, 0, 0, 0, time.UTC)
+
+	tests := []struct {
+		desc     string
+		user     InactiveUser
+		action   string
+		reminder int
+		deletion string
+	}{
+		{
+			desc: "TooYoung",
+			user: InactiveUser{Created: "2020-02-28"},
+		},
+		{
+			desc:     "FirstReminder",
+			user:     InactiveUser{Created: "2020-02-25"},
+			action:   SweepActionRemind,
+			reminder: 1,
+			deletion: "2020-03-28T03:00:00Z",
+		},
+		{
+			desc: "SecondReminderNotDue",
+			user: InactiveUser{Created: "2020-02-25", Reminders: 1},
+		},
+		{
+			desc:     "SecondReminder",
+			user:     InactiveUser{Created: "2020-02-20", Reminders: 1},
+			action:   SweepActionRemind,
+			reminder: 2,
+			deletion: "2020-03-24T03:00:00Z",
+		},
+		{
+			desc:     "LateReminderKeepsNotice",
+			user:     InactiveUser{Created: "2020-01-01"},
+			action:   SweepActionRemind,
+			reminder: 1,
+			deletion: "2020-03-28T03:00:00Z",
+		},
+		{
+			desc: "NotDeletedBeforeNotice",
+			user: InactiveUser{Created: "2020-01-01", Reminders: 2,
+				Deletion: "2020-03-05T03:00:00Z"},
+		},
+		{
+			desc: "Delete",
+			user: InactiveUser{Created: "2020-01-01", Reminders: 2,
+				Deletion: "2020-02-28T03:00:00Z"},
+			action: SweepActionDelete,
+		},
+		{
+			desc: "InvalidCreated",
+			user: InactiveUser{Created: "yesterday"},
+		},
+	}
+
+	for _, tc := range tests {
+		t.Run(tc.desc, func(t *testing.T) {
+			a, _ := cfg.next(tc.user, now)
+
+			action, reminder, deletion := "", 0, ""
+			if a != nil {
+				action, reminder, deletion = a.Action, a.Reminder, a.Deletion
+			}
+			if action != tc.action {
+				t.Errorf("Expected: %v. Received: %v", tc.action, action)
+			}
+			if reminder != tc.reminder {
+				t.Errorf("Expected: %v. Received: %v", tc.reminder, reminder)
+			}
+			if deletion != tc.deletion {
+				t.Errorf("Expected: %v. Received: %v", tc.deletion, deletion)
+			}
+		})
+	}
+}
+
+//sweepDynamoDB returns partition for the queries of a partition, instead of
+//the inactive users of the index, and records the transactions
+type sweepDynamoDB struct {
+	*test.MockDynamoDB
+	partition    *dynamodb.QueryOutput
+	transactions []*dynamodb.TransactWriteItemsInput
+}
+
+func (s *sweepDynamoDB) QueryPagesWithContext(ctx aws.Context,
+	input *dynamodb.QueryInput, fn func(*dynamodb.QueryOutput, bool) bool,
+	opts ...request.Option) error {
+	if input.IndexName == nil {
+		fn(s.partition, true)
+		return nil
+	}
+	return s.MockDynamoDB.QueryPagesWithContext(ctx, input, fn, opts...)
+}
+
+func (s *sweepDynamoDB) TransactWriteItemsWithContext(ctx aws.Context,
+	input *dynamodb.TransactWriteItemsInput, opts ...request.Option) (
+	*dynamodb.TransactWriteItemsOutput, error) {
+	s.transactions = append(s.transactions, input)
+	return s.MockDynamoDB.TransactWriteItemsWithContext(ctx, input, opts...)
+}
+
+//TestSweep Tests the summary of a sweep
+func TestSweep(t *testing.T) {
+
+	day := 24 * time.Hour
+	now := time.Date(2020, 3, 1, 3, 0, 0, 0, time.UTC)
+
+	mock := &test.MockDynamoDB{
+		QueryOutput: &dynamodb.QueryOutput{
+			Items: []map[string]*dynamodb.AttributeValue{
+				{
+					"email":   {S: aws.String("new@user.com")},
+					"created": {S: aws.String("2020-02-25")},
+				},
+				{
+					"email":     {S: aws.String("old@user.com")},
+					"created":   {S: aws.String("2020-01-01")},
+					"reminders": {N: aws.String("1")},
+					"deletion":  {S: aws.String("2020-02-28T03:00:00Z")},
+				},
+				{
+					"email":   {S: aws.String("recent@user.com")},
+					"created": {S: aws.String("2020-02-28")},
+				},
+			},
+		},
+		UpdateItemOutput: &dynamodb.UpdateItemOutput{},
+	}
+
+	u := &User{Email: "old@user.com"}
+	partition := &dynamodb.QueryOutput{
+		Items: []map[string]*dynamodb.AttributeValue{
+			{
+				"pk": {S: aws.String(u.getUserPK())},
+				"sk": {S: aws.String(u.getProfileSK())},
+				"avatar": {M: map[string]*dynamodb.AttributeValue{
+					"64": {S: aws.String("http://cdn/avatars/u1/a-64.jpg")},
+				}},
+			},
+			{
+				"pk": {S: aws.String(u.getUserPK())},
+				"sk": {S: aws.String(u.getTokenSK())},
+			},
+		},
+	}
+
+	tests := []struct {
+		desc     string
+		dryRun   bool
+		reminded int
+		deleted  int
+		avatars  int
+		deletes  int
+	}{
+		{
+			desc:     "Sweep",
+			reminded: 1,
+			deleted:  1,
+			avatars:  1,
+			deletes:  2,
+		},
+		{
+			desc:     "DryRun",
+			dryRun:   true,
+			reminded: 1,
+			deleted:  1,
+		},
+	}
+
+	for _, tc := range tests {
+		t.Run(tc.desc, func(t *testing.T) {
+			avatars := 0
+			cfg := SweepConfig{Reminders: []time.Duration{3 * day},
+				Cutoff: 30 * day, DryRun: tc.dryRun,
+				DeleteAvatar: func(ctx context.Context, urls map[string]string) {
+					avatars += len(urls)
+				}}
+
+			svc := &sweepDynamoDB{MockDynamoDB: mock, partition: partition}
+			summary, err := Sweep(context.Background(), svc, UserTable, cfg,
+				now)
+			if err != nil {
+				t.Fatalf("Expected: %v. Received: %v", nil, err)
+			}
+			if summary.Scanned != 3 {
+				t.Errorf("Expected: %v. Received: %v", 3, summary.Scanned)
+			}
+			if summary.Reminded != tc.reminded {
+				t.Errorf("Expected: %v. Received: %v", tc.reminded,
+					summary.Reminded)
+			}
+			if summary.Deleted != tc.deleted {
+				t.Errorf("Expected: %v. Received: %v", tc.deleted,
+					summary.Deleted)
+			}
+			if summary.Failed != 0 {
+				t.Errorf("Expected: %v. Received: %v", 0, summary.Failed)
+			}
+			if avatars != tc.avatars {
+				t.Errorf("Expected: %v. Received: %v", tc.avatars, avatars)
+			}
+
+			//The profile is deleted last, on condition, with the other rows
+			deletes := 0
+			for _, tx := range svc.transactions {
+				deletes += len(tx.TransactItems)
+				profile := tx.TransactItems[len(tx.TransactItems)-1].Delete
+				if profile == nil || profile.ConditionExpression == nil ||
+					aws.StringValue(profile.Key["sk"].S) != u.getProfileSK() {
+					t.Errorf("Expected the conditional profile delete last. Received: %v",
+						tx.TransactItems)
+				}
+			}
+			if deletes != tc.deletes {
+				t.Errorf("Expected: %v. Received: %v", tc.deletes, deletes)
+			}
+		})
+	}
+}
@@ -0,0 +1,192 @@
+package user
+
+import (
+	"context"
+	"errors"
+
+	"github.com/rs/zerolog/log"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/dynamodb"
+	"github.com/aws/aws-sdk-go/service/dynamodb/dynamodbattribute"
+	"github.com/aws/aws-sdk-go/service/dynamodb/dynamodbiface"
+)
+
+const (
+	//DynamoDBIndexTenant Global secondary index on the tenant of the profiles,
+	//sorted by email
+	DynamoDBIndexTenant = "TenantIndex"
+
+	//DynamoDBAttributeTenant hash key of the tenant index. Only the profiles
+	//of the users provisioned with SCIM have it
+	DynamoDBAttributeTenant = "tenant"
+
+	//ErrorTenantMismatch Returned when assigning an user that belongs to
+	//another tenant
+	ErrorTenantMismatch = "TenantMismatch"
+)
+
+//Provision creates an user owned by the tenant, active or deactivated. The
+//email is verified by the tenant's identity provider, so no activation token
+//is created and no email is sent. The provider does not know the custom
+//attributes, so the required ones are not required
+func Provision(ctx context.Context, svc dynamodbiface.DynamoDBAPI,
+	nu *NewUser, tableName, tenant string, active bool) (*User, error) {
+
+	if tenant == "" {
+		return nil, errors.New("Tenant is not set")
+	}
+
+	return create(ctx, svc, nu, tableName, createOptions{active: active,
+		deactivated: !active, tenant: tenant, partial: true})
+}
+
+//LoadByTenant loads the user by ID, provided it belongs to the tenant. The
+//users of other tenants do not exist for it
+func LoadByTenant(ctx context.Context, svc dynamodbiface.DynamoDBAPI,
+	tableName, tenant, id string) (*User, error) {
+
+	u, err := LoadByID(ctx, svc, tableName, id)
+	if err != nil {
+		return nil, err
+	}
+
+	if !u.InTenant(tenant) {
+		return nil, errors.New(ErrorUserDoesNotExist)
+	}
+
+	return u, nil
+}
+
+//InTenant tells whether the user belongs to the tenant
+func (u *User) InTenant(tenant string) bool {
+	return tenant != "" && u.Tenant == tenant
+}
+
+//ListByTenant returns count users of the tenant, by email, starting at the
+//1-based startIndex, and the number of users of the tenant
+func ListByTenant(ctx context.Context, svc dynamodbiface.DynamoDBAPI,
+	tableName, tenant string, startIndex, count int) ([]*User, int, error) {
+
+	log.Debug().Msgf("Listing users of tenant: %s, start: %d, count: %d",
+		tenant, startIndex, count)
+
+	query := func(key map[string]*dynamodb.AttributeValue) *dynamodb.QueryInput {
+		return &dynamodb.QueryInput{
+			TableName:              aws.String(tableName),
+			IndexName:              aws.String(DynamoDBIndexTenant),
+			KeyConditionExpression: aws.String("#T = :tenant"),
+			ExpressionAttributeNames: map[string]*string{
+				"#T": aws.String(DynamoDBAttributeTenant),
+			},
+			ExpressionAttributeValues: map[string]*dynamodb.AttributeValue{
+				":tenant": {S: aws.String(tenant)},
+			},
+			ExclusiveStartKey: key,
+		}
+	}
+
+	total := 0
+	input := query(nil)
+	input.Select = aws.String(dynamodb.SelectCount)
+	err := svc.QueryPagesWithContext(ctx, input,
+		func(page *dynamodb.QueryOutput, last bool) bool {
+			total += int(aws.Int64Value(page.Count))
+			return true
+		})
+	if err != nil {
+		return nil, 0, err
+	}
+
+	users := []*User{}
+
+	//The users before the page are only counted, following the pages of the
+	//index until startIndex is reached
+	var key map[string]*dynamodb.AttributeValue
+	for skip := startIndex - 1; skip > 0; {
+		input := query(key)
+		input.Select = aws.String(dynamodb.SelectCount)
+		input.Limit = aws.Int64(int64(skip))
+
+		result, err := svc.QueryWithContext(ctx, input)
+		if err != nil {
+			return nil, 0, err
+		}
+
+		skip -= int(aws.Int64Value(result.Count))
+		key = result.LastEvaluatedKey
+		if len(key) == 0 {
+			return users, total, nil
+		}
+	}
+
+	for len(users) < count {
+		input := query(key)
+		input.Limit = aws.Int64(int64(count - len(users)))
+
+		result, err := svc.QueryWithContext(ctx, input)
+		if err != nil {
+			return nil, 0, err
+		}
+
+		for _, item := range result.Items {
+			var u User
+			if err := dynamodbattribute.UnmarshalMap(item, &u); err != nil {
+				return nil, 0, err
+			}
+			users = append(users, &u)
+		}
+
+		key = result.LastEvaluatedKey
+		if len(key) == 0 {
+			break
+		}
+	}
+
+	return users, total, nil
+}
+
+//AssignTenant makes the tenant the owner of an user created by other means,
+//so its identity provider can manage it with SCIM
+func (u *User) AssignTenant(ctx context.Context, svc dynamodbiface.DynamoDBAPI,
+	tableName, tenant string) error {
+
+	log.Info().Msgf("Assigning %s to tenant: %s", u.Email, tenant)
+
+	if u.Email == "" {
+		return errors.New("Email is not set")
+	}
+
+	if tenant == "" {
+		return errors.New("Tenant is not set")
+	}
+
+	_, err := svc.UpdateItemWithContext(ctx, &dynamodb.UpdateItemInput{
+		TableName: aws.String(tableName),
+		Key: map[string]*dynamodb.AttributeValue{
+			"pk": {S: aws.String(u.getUserPK())},
+			"sk": {S: aws.String(u.getProfileSK())},
+		},
+		ExpressionAttributeNames: map[string]*string{
+			"#T": aws.String(DynamoDBAttributeTenant),
+		},
+		ExpressionAttributeValues: map[string]*dynamodb.AttributeValue{
+			":tenant": {S: aws.String(tenant)},
+		},
+		UpdateExpression:    aws.String("SET #T = :tenant"),
+		ConditionExpression: aws.String("attribute_exists(pk) AND (attribute_not_exists(#T) OR #T = :tenant)"),
+	})
+	if err != nil {
+		if isConditionalCheckFailed(err) {
+			if err := u.Load(ctx, svc, tableName); err != nil {
+				return err
+			}
+			return errors.New(ErrorTenantMismatch)
+		}
+		return err
+	}
+
+	u.Tenant = tenant
+
+	return nil
+}
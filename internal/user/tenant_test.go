@@ -0,0 +1,110 @@
+package user
+
+import (
+	"context"
+	"errors"
+	"reflect"
+	"testing"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/dynamodb"
+
+	"github.com/roloum/users/internal/test"
+)
+
+//TestLoadByTenant Tests that the users of other tenants are not found
+func TestLoadByTenant(t *testing.T) {
+
+	profile := func(tenant string) *dynamodb.QueryOutput {
+		item := map[string]*dynamodb.AttributeValue{
+			"id":    {S: aws.String("1234")},
+			"email": {S: aws.String("test@user.com")},
+		}
+		if tenant != "" {
+			item["tenant"] = &dynamodb.AttributeValue{S: aws.String(tenant)}
+		}
+		return &dynamodb.QueryOutput{
+			Items: []map[string]*dynamodb.AttributeValue{item},
+		}
+	}
+
+	tests := []struct {
+		desc      string
+		tenant    string
+		mockDBSvc *test.MockDynamoDB
+		err       error
+	}{
+		{
+			desc:      "SameTenant",
+			tenant:    "acme",
+			mockDBSvc: &test.MockDynamoDB{QueryOutput: profile("acme")},
+		},
+		{
+			desc:      "OtherTenant",
+			tenant:    "globex",
+			mockDBSvc: &test.MockDynamoDB{QueryOutput: profile("acme")},
+			err:       errors.New(ErrorUserDoesNotExist),
+		},
+		{
+			desc:      "NoTenant",
+			tenant:    "acme",
+			mockDBSvc: &test.MockDynamoDB{QueryOutput: profile("")},
+			err:       errors.New(ErrorUserDoesNotExist),
+		},
+		{
+			desc:      "EmptyTenant",
+			tenant:    "",
+			mockDBSvc: &test.MockDynamoDB{QueryOutput: profile("")},
+			err:       errors.New(ErrorUserDoesNotExist),
+		},
+	}
+
+	for _, tc := range tests {
+		t.Run(tc.desc, func(t *testing.T) {
+			_, err := LoadByTenant(context.Background(), tc.mockDBSvc, UserTable,
+				tc.tenant, "1234")
+			if !reflect.DeepEqual(err, tc.err) {
+				t.Errorf("Expected: %v. Received: %v", tc.err, err)
+			}
+		})
+	}
+}
+
+//TestListByTenant Tests the pages of the users of a tenant
+func TestListByTenant(t *testing.T) {
+
+	page := &dynamodb.QueryOutput{
+		Count: aws.Int64(1),
+		Items: []map[string]*dynamodb.AttributeValue{
+			{"email": {S: aws.String("test@user.com")},
+				"tenant": {S: aws.String("acme")}},
+		},
+	}
+
+	tests := []struct {
+		desc       string
+		startIndex int
+		count      int
+		users      int
+		total      int
+	}{
+		{desc: "FirstPage", startIndex: 1, count: 10, users: 1, total: 1},
+		{desc: "PastTheEnd", startIndex: 5, count: 10, users: 0, total: 1},
+		{desc: "CountOnly", startIndex: 1, count: 0, users: 0, total: 1},
+	}
+
+	for _, tc := range tests {
+		t.Run(tc.desc, func(t *testing.T) {
+			users, total, err := ListByTenant(context.Background(),
+				&test.MockDynamoDB{QueryOutput: page}, UserTable, "acme",
+				tc.startIndex, tc.count)
+			if err != nil {
+				t.Fatalf("Unexpected error: %v", err)
+			}
+			if len(users) != tc.users || total != tc.total {
+				t.Errorf("Expected: %d/%d. Received: %d/%d", tc.users, tc.total,
+					len(users), total)
+			}
+		})
+	}
+}
@@ -2,8 +2,11 @@ package user
 
 import (
 	"context"
+	"encoding/base64"
+	"encoding/json"
 	"errors"
 	"fmt"
+	"strconv"
 	"strings"
 	"time"
 
@@ -11,12 +14,13 @@ import (
 
 	"github.com/aws/aws-lambda-go/events"
 	"github.com/aws/aws-sdk-go/aws"
-	"github.com/aws/aws-sdk-go/aws/awserr"
 	"github.com/aws/aws-sdk-go/service/dynamodb"
 	"github.com/aws/aws-sdk-go/service/dynamodb/dynamodbattribute"
 	"github.com/aws/aws-sdk-go/service/dynamodb/dynamodbiface"
 
 	"github.com/google/uuid"
+
+	"github.com/roloum/users/internal/apperr"
 )
 
 const (
@@ -32,6 +36,25 @@ const (
 	//DynamoDBTypeUser identifies the type of row in dynamoDB
 	DynamoDBTypeUser = "User"
 
+	//DynamoDBIndexID Global secondary index on the id attribute
+	DynamoDBIndexID = "IdIndex"
+
+	//DynamoDBAttributeSilent flags a token row whose activation email must not
+	//be sent
+	DynamoDBAttributeSilent = "silent"
+
+	//DynamoDBAttributeMigrated holds when a row was copied by a restore or a
+	//migration instead of being written by the user, so the messages of the
+	//row are not sent again
+	DynamoDBAttributeMigrated = "migrated"
+
+	//DynamoDBBatchSize maximum number of items in a BatchWriteItem request
+	DynamoDBBatchSize = 25
+
+	//DynamoDBTransactSize maximum number of items in a TransactWriteItems
+	//request
+	DynamoDBTransactSize = 100
+
 	//ErrorDuplicateUser Returned when the user already exists in the table
 	ErrorDuplicateUser = "DuplicatedUser"
 
@@ -48,6 +71,13 @@ const (
 	//ErrorUserAlreadyActive Error displayed when attempting to activate an account
 	//That is already active
 	ErrorUserAlreadyActive = "UserAlreadyActive"
+
+	//ErrorInvalidCursor Returned when the pagination cursor can not be decoded
+	ErrorInvalidCursor = "InvalidCursor"
+
+	//ErrorUserDeactivated Returned when an user deactivated by an administrator
+	//tries to sign in or use a session or an API key
+	ErrorUserDeactivated = "UserDeactivated"
 )
 
 //User contains information about the user
@@ -58,13 +88,33 @@ type User struct {
 	Email     string `json:"email,omitempty"`
 	Active    bool   `json:"active,omitempty"`
 	Created   string `json:"created,omitempty"`
+
+	//Attributes holds the custom attributes defined by the Schema
+	Attributes map[string]interface{} `json:"attributes,omitempty"`
+
+	//Avatar has the URL of the avatar image of each size, by size in pixels
+	Avatar map[string]string `json:"avatar,omitempty"`
+
+	//Phone is the E.164 number verified with VerifyPhone
+	Phone         string `json:"phone,omitempty"`
+	PhoneVerified bool   `json:"phoneVerified,omitempty"`
+
+	//EmailStatus is the type of the last delivery reported by SES, and
+	//EmailSuppressed the hard bounce or complaint that stopped the emails
+	EmailStatus     string `json:"emailStatus,omitempty"`
+	EmailSuppressed string `json:"emailSuppressed,omitempty"`
+
+	//Tenant owns the users provisioned with SCIM, empty for the others
+	Tenant string `json:"tenant,omitempty"`
 }
 
 //NewUser contains information to create new user
 type NewUser struct {
-	Email     string `json:"email" validate:"required,validEmail"`
-	FirstName string `json:"firstName" validate:"required"`
-	LastName  string `json:"lastName" validate:"required"`
+	Email     string `json:"email" validate:"required,max=254,validEmail,blockedDomain,disposableDomain,mx"`
+	FirstName string `json:"firstName" validate:"required,max=64,validName"`
+	LastName  string `json:"lastName" validate:"required,max=64,validName"`
+
+	Attributes map[string]interface{} `json:"attributes,omitempty" validate:"-"`
 }
 
 //IsUserProfileKeys verifies that pk and sk correspond to a User's profile row
@@ -85,6 +135,23 @@ func IsUserTokenKeys(keys map[string]events.DynamoDBAttributeValue) bool {
 	return tokenKeys
 }
 
+//IsMigratedChange tells whether the stream record was written by a restore or
+//a migration: its new image has a migrated time that the old image does not
+//have. The later changes of a migrated row keep the same time
+func IsMigratedChange(change events.DynamoDBStreamRecord) bool {
+	n, ok := change.NewImage[DynamoDBAttributeMigrated]
+	if !ok || n.DataType() != events.DataTypeString {
+		return false
+	}
+
+	o, ok := change.OldImage[DynamoDBAttributeMigrated]
+	if !ok || o.DataType() != events.DataTypeString {
+		return true
+	}
+
+	return o.String() != n.String()
+}
+
 func isUserKeys(primaryKey, sortKey string,
 	keys map[string]events.DynamoDBAttributeValue) bool {
 
@@ -114,6 +181,56 @@ func isUserKeys(primaryKey, sortKey string,
 // - pk: USER#[email], sk: TOKEN#[token] ... activation token (using id for now)
 func Create(ctx context.Context, svc dynamodbiface.DynamoDBAPI, nu *NewUser,
 	tableName string) (*User, error) {
+	return create(ctx, svc, nu, tableName, createOptions{})
+}
+
+//CreateActive creates an user whose email has been verified by other means,
+//such as a provisioning system. Only the profile row is inserted, so no
+//activation email is sent
+func CreateActive(ctx context.Context, svc dynamodbiface.DynamoDBAPI,
+	nu *NewUser, tableName string) (*User, error) {
+	return create(ctx, svc, nu, tableName, createOptions{active: true})
+}
+
+//CreateSilent creates an inactive user whose token row is flagged as silent,
+//so the notify handler does not send the activation email. The account can
+//still be activated with the token or a magic link
+func CreateSilent(ctx context.Context, svc dynamodbiface.DynamoDBAPI,
+	nu *NewUser, tableName string) (*User, error) {
+	return create(ctx, svc, nu, tableName, createOptions{silent: true})
+}
+
+//Import creates an user of a bulk import like Create, or like CreateActive
+//or CreateSilent. The files do not have every required attribute, so they
+//are not required
+func Import(ctx context.Context, svc dynamodbiface.DynamoDBAPI, nu *NewUser,
+	tableName string, active, silent bool) (*User, error) {
+	return create(ctx, svc, nu, tableName, createOptions{active: active,
+		silent: silent, partial: true})
+}
+
+//createOptions are the variants of the creation of an user
+type createOptions struct {
+	//active creates the user active, without activation token
+	active bool
+
+	//silent flags the activation token so its email is not sent
+	silent bool
+
+	//deactivated creates the user inactive and without activation token, as
+	//if an administrator had deactivated it
+	deactivated bool
+
+	//tenant is the tenant owning the users provisioned with SCIM
+	tenant string
+
+	//partial does not require the required attributes, for the callers that
+	//can not supply them
+	partial bool
+}
+
+func create(ctx context.Context, svc dynamodbiface.DynamoDBAPI, nu *NewUser,
+	tableName string, opts createOptions) (*User, error) {
 	log.Info().Msgf("Creating user: %s", nu.Email)
 
 	if tableName == "" {
@@ -122,72 +239,61 @@ func Create(ctx context.Context, svc dynamodbiface.DynamoDBAPI, nu *NewUser,
 
 	log.Debug().Msg("Validating NewUser struct")
 
-	if err := validate.Struct(nu); err != nil {
-		return nil, getValidationError(err)
+	schema, err := GetSchema(ctx, svc, tableName)
+	if err != nil {
+		return nil, err
+	}
+
+	if err := validateWithSchema(ctx, nu, schema, !opts.partial); err != nil {
+		return nil, err
 	}
 
 	userID := uuid.New()
 	log.Debug().Msgf("Generated UUID: %s", userID.String())
 
 	u := User{
-		Email:     nu.Email,
-		ID:        userID.String(),
-		FirstName: nu.FirstName,
-		LastName:  nu.LastName,
-		Active:    false,
-		Created:   time.Now().Format("2006-01-02"),
+		Email:      nu.Email,
+		ID:         userID.String(),
+		FirstName:  nu.FirstName,
+		LastName:   nu.LastName,
+		Active:     opts.active,
+		Created:    time.Now().Format("2006-01-02"),
+		Attributes: nu.Attributes,
+		Tenant:     opts.tenant,
 	}
 
 	log.Debug().Msgf("Creating row: %+v", u)
 
-	result, err := svc.TransactWriteItemsWithContext(ctx, &dynamodb.TransactWriteItemsInput{
-		TransactItems: []*dynamodb.TransactWriteItem{
-			{
-				Put: &dynamodb.Put{
-					Item: map[string]*dynamodb.AttributeValue{
-						"pk":        {S: aws.String(u.getUserPK())},
-						"sk":        {S: aws.String(u.getProfileSK())},
-						"id":        {S: aws.String(u.ID)},
-						"firstName": {S: aws.String(u.FirstName)},
-						"lastName":  {S: aws.String(u.LastName)},
-						"email":     {S: aws.String(u.Email)},
-						"active":    {BOOL: aws.Bool(u.Active)},
-						"created":   {S: aws.String(u.Created)},
-						"type":      {S: aws.String(DynamoDBTypeUser)},
-					},
-					TableName:           aws.String(tableName),
-					ConditionExpression: aws.String("attribute_not_exists(pk) and attribute_not_exists(sk)"),
-				},
-			},
-			{
-				Put: &dynamodb.Put{
-					Item: map[string]*dynamodb.AttributeValue{
-						"pk":        {S: aws.String(u.getUserPK())},
-						"sk":        {S: aws.String(u.getTokenSK())},
-						"id":        {S: aws.String(u.ID)},
-						"firstName": {S: aws.String(u.FirstName)},
-						"lastName":  {S: aws.String(u.LastName)},
-						"email":     {S: aws.String(u.Email)},
-					},
-					TableName:           aws.String(tableName),
-					ConditionExpression: aws.String("attribute_not_exists(pk) and attribute_not_exists(sk)"),
-				},
-			},
+	profile := u.profilePut(tableName)
+	//Only the users who sign up themselves are reminded and swept
+	if !opts.active && !opts.silent && !opts.deactivated {
+		profile.Item[DynamoDBAttributeInactive] = &dynamodb.AttributeValue{
+			S: aws.String(DynamoDBTypeUser)}
+	}
+
+	items := []*dynamodb.TransactWriteItem{
+		{
+			Put: profile,
 		},
+	}
+	items = append(items, u.namePuts(tableName)...)
+
+	if !opts.active && !opts.deactivated {
+		items = append(items, &dynamodb.TransactWriteItem{
+			Put: u.tokenPut(tableName, opts.silent),
+		})
+	}
+
+	result, err := svc.TransactWriteItemsWithContext(ctx, &dynamodb.TransactWriteItemsInput{
+		TransactItems: items,
 	})
 
 	if err != nil {
 
 		log.Debug().Msg(err.Error())
 
-		if aerr, ok := err.(awserr.Error); ok {
-
-			// TODO:
-			//Error returned is TransactionCanceledException. Still trying to figure
-			//out if there's a way to extract the Cancellation reasons
-			if aerr.Code() == dynamodb.ErrCodeTransactionCanceledException {
-				return nil, errors.New(ErrorDuplicateUser)
-			}
+		if isConditionCanceled(err) {
+			return nil, errors.New(ErrorDuplicateUser)
 		}
 
 		return nil, err
@@ -226,12 +332,13 @@ func (u *User) Activate(ctx context.Context, svc dynamodbiface.DynamoDBAPI,
 					},
 					ExpressionAttributeNames: map[string]*string{
 						"#A": aws.String("active"),
+						"#I": aws.String(DynamoDBAttributeInactive),
 					},
 					ExpressionAttributeValues: map[string]*dynamodb.AttributeValue{
 						":active":   {BOOL: aws.Bool(true)},
 						":inactive": {BOOL: aws.Bool(false)},
 					},
-					UpdateExpression:                    aws.String("SET #A = :active"),
+					UpdateExpression:                    aws.String("SET #A = :active REMOVE #I"),
 					ConditionExpression:                 aws.String("#A = :inactive"),
 					ReturnValuesOnConditionCheckFailure: aws.String(dynamodb.ReturnValueNone),
 				},
@@ -254,17 +361,131 @@ func (u *User) Activate(ctx context.Context, svc dynamodbiface.DynamoDBAPI,
 
 		log.Debug().Msg(err.Error())
 
-		if aerr, ok := err.(awserr.Error); ok {
+		if isConditionCanceled(err) {
+			return errors.New(ErrorActivateUser)
+		}
+		return err
+	}
+
+	log.Debug().Msgf("Result: %+v", result)
+
+	return nil
+}
+
+//PendingToken is an unused activation token or magic link of the user
+type PendingToken struct {
+	Kind    string `json:"kind"`
+	Token   string `json:"token,omitempty"`
+	Expires string `json:"expires,omitempty"`
+}
 
-			if aerr.Code() == dynamodb.ErrCodeTransactionCanceledException {
+//PendingTokens returns the activation tokens and magic links that have not
+//been used. Magic links are bearer credentials, so only their expiration is
+//returned
+func (u *User) PendingTokens(ctx context.Context, svc dynamodbiface.DynamoDBAPI,
+	tableName string) ([]PendingToken, error) {
 
-				return errors.New(ErrorActivateUser)
+	if u.Email == "" {
+		return nil, errors.New("Email is not set")
+	}
+
+	var tokens []PendingToken
+
+	err := svc.QueryPagesWithContext(ctx, &dynamodb.QueryInput{
+		TableName:              aws.String(tableName),
+		KeyConditionExpression: aws.String("pk = :pk"),
+		ExpressionAttributeValues: map[string]*dynamodb.AttributeValue{
+			":pk": {S: aws.String(u.getUserPK())},
+		},
+	}, func(page *dynamodb.QueryOutput, last bool) bool {
+		for _, item := range page.Items {
+			sk := aws.StringValue(item["sk"].S)
+
+			switch {
+			case strings.HasPrefix(sk, DynamoDBPrefixToken+"#"):
+				tokens = append(tokens, PendingToken{
+					Kind:  DynamoDBPrefixToken,
+					Token: strings.TrimPrefix(sk, DynamoDBPrefixToken+"#"),
+				})
+			case strings.HasPrefix(sk, DynamoDBPrefixMagic+"#"):
+				t := PendingToken{Kind: DynamoDBPrefixMagic}
+				if ttl, ok := item["ttl"]; ok && ttl.N != nil {
+					if sec, err := strconv.ParseInt(*ttl.N, 10, 64); err == nil {
+						t.Expires = time.Unix(sec, 0).UTC().Format(time.RFC3339)
+					}
+				}
+				tokens = append(tokens, t)
 			}
 		}
+		return true
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return tokens, nil
+}
+
+//ResendActivation replaces the activation token row of an inactive user. The
+//row is deleted first so that the new one is an INSERT in the stream, which
+//the notify handler mails as it does for new users
+func (u *User) ResendActivation(ctx context.Context,
+	svc dynamodbiface.DynamoDBAPI, tableName string) error {
+
+	log.Info().Msgf("Resending activation: %s", u.Email)
+
+	if err := u.Load(ctx, svc, tableName); err != nil {
+		return err
+	}
+
+	if u.Active {
+		return errors.New(ErrorUserAlreadyActive)
+	}
+
+	//The users deactivated by an administrator have no token to replace
+	if _, err := svc.DeleteItemWithContext(ctx, &dynamodb.DeleteItemInput{
+		TableName: aws.String(tableName),
+		Key: map[string]*dynamodb.AttributeValue{
+			"pk": {S: aws.String(u.getUserPK())},
+			"sk": {S: aws.String(u.getTokenSK())},
+		},
+		ConditionExpression: aws.String("attribute_exists(pk)"),
+	}); err != nil {
+		if isConditionalCheckFailed(err) {
+			return errors.New(ErrorUserDeactivated)
+		}
 		return err
 	}
 
-	log.Debug().Msgf("Result: %+v", result)
+	_, err := svc.TransactWriteItemsWithContext(ctx, &dynamodb.TransactWriteItemsInput{
+		TransactItems: []*dynamodb.TransactWriteItem{
+			{
+				ConditionCheck: &dynamodb.ConditionCheck{
+					TableName: aws.String(tableName),
+					Key: map[string]*dynamodb.AttributeValue{
+						"pk": {S: aws.String(u.getUserPK())},
+						"sk": {S: aws.String(u.getProfileSK())},
+					},
+					ExpressionAttributeNames: map[string]*string{
+						"#A": aws.String("active"),
+					},
+					ExpressionAttributeValues: map[string]*dynamodb.AttributeValue{
+						":inactive": {BOOL: aws.Bool(false)},
+					},
+					ConditionExpression: aws.String("#A = :inactive"),
+				},
+			},
+			{
+				Put: u.tokenPut(tableName, false),
+			},
+		},
+	})
+	if err != nil {
+		if isConditionCanceled(err) {
+			return errors.New(ErrorUserAlreadyActive)
+		}
+		return err
+	}
 
 	return nil
 }
@@ -304,8 +525,481 @@ func (u *User) Load(ctx context.Context, svc dynamodbiface.DynamoDBAPI,
 	return nil
 }
 
+//LoadByID Loads the profile information of the User based on the ID
+func LoadByID(ctx context.Context, svc dynamodbiface.DynamoDBAPI,
+	tableName, id string) (*User, error) {
+
+	log.Debug().Msgf("Loading profile by id: %s", id)
+
+	result, err := svc.QueryWithContext(ctx, &dynamodb.QueryInput{
+		TableName:              aws.String(tableName),
+		IndexName:              aws.String(DynamoDBIndexID),
+		KeyConditionExpression: aws.String("id = :id"),
+		FilterExpression:       aws.String("#T = :type"),
+		ExpressionAttributeNames: map[string]*string{
+			"#T": aws.String("type"),
+		},
+		ExpressionAttributeValues: map[string]*dynamodb.AttributeValue{
+			":id":   {S: aws.String(id)},
+			":type": {S: aws.String(DynamoDBTypeUser)},
+		},
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	if len(result.Items) == 0 {
+		return nil, errors.New(ErrorUserDoesNotExist)
+	}
+
+	var u User
+	if err := dynamodbattribute.UnmarshalMap(result.Items[0], &u); err != nil {
+		return nil, err
+	}
+
+	return &u, nil
+}
+
+//Filter restricts the users returned by ListFiltered. Empty fields do not
+//filter
+type Filter struct {
+	//Active filters by the active flag when set
+	Active *bool
+	//Domain filters by the domain of the email
+	Domain string
+	//CreatedFrom and CreatedTo filter by creation date, YYYY-MM-DD inclusive
+	CreatedFrom string
+	CreatedTo   string
+}
+
+//List returns a page of at most limit users. The cursor returned is passed to
+//get the next page and is empty on the last one
+func List(ctx context.Context, svc dynamodbiface.DynamoDBAPI, tableName string,
+	limit int64, cursor string) ([]*User, string, error) {
+	return ListFiltered(ctx, svc, tableName, Filter{}, limit, cursor)
+}
+
+//ListFiltered returns a page of the users matching the filter. The filter is
+//applied after reading limit users, so a page can have fewer users even if
+//more pages follow
+func ListFiltered(ctx context.Context, svc dynamodbiface.DynamoDBAPI,
+	tableName string, filter Filter, limit int64, cursor string) ([]*User,
+	string, error) {
+
+	log.Debug().Msgf("Listing users, limit: %d, filter: %+v", limit, filter)
+
+	input := &dynamodb.QueryInput{
+		TableName:              aws.String(tableName),
+		IndexName:              aws.String(DynamoDBIndexInverted),
+		KeyConditionExpression: aws.String("sk = :sk"),
+		ExpressionAttributeValues: map[string]*dynamodb.AttributeValue{
+			":sk": {S: aws.String(fmt.Sprintf("%s#", DynamoDBPrefixProfile))},
+		},
+	}
+	if limit > 0 {
+		input.Limit = aws.Int64(limit)
+	}
+
+	filter.apply(input)
+
+	if cursor != "" {
+		key, err := decodeCursor(cursor)
+		if err != nil {
+			return nil, "", err
+		}
+		input.ExclusiveStartKey = key
+	}
+
+	result, err := svc.QueryWithContext(ctx, input)
+	if err != nil {
+		return nil, "", err
+	}
+
+	users := make([]*User, 0, len(result.Items))
+	for _, item := range result.Items {
+		var u User
+		if err := dynamodbattribute.UnmarshalMap(item, &u); err != nil {
+			return nil, "", err
+		}
+		users = append(users, &u)
+	}
+
+	next, err := encodeCursor(result.LastEvaluatedKey)
+	if err != nil {
+		return nil, "", err
+	}
+
+	return users, next, nil
+}
+
+//apply adds the filter expression to the query
+func (f Filter) apply(input *dynamodb.QueryInput) {
+
+	var conditions []string
+	names := map[string]*string{}
+
+	if f.Active != nil {
+		names["#A"] = aws.String("active")
+		input.ExpressionAttributeValues[":active"] = &dynamodb.AttributeValue{
+			BOOL: f.Active}
+		conditions = append(conditions, "#A = :active")
+	}
+
+	if f.Domain != "" {
+		//DynamoDB has no ends_with, the @ keeps the match on the domain
+		names["#E"] = aws.String("email")
+		input.ExpressionAttributeValues[":domain"] = &dynamodb.AttributeValue{
+			S: aws.String("@" + strings.TrimPrefix(f.Domain, "@"))}
+		conditions = append(conditions, "contains(#E, :domain)")
+	}
+
+	if f.CreatedFrom != "" {
+		names["#C"] = aws.String("created")
+		input.ExpressionAttributeValues[":from"] = &dynamodb.AttributeValue{
+			S: aws.String(f.CreatedFrom)}
+		conditions = append(conditions, "#C >= :from")
+	}
+
+	if f.CreatedTo != "" {
+		names["#C"] = aws.String("created")
+		input.ExpressionAttributeValues[":to"] = &dynamodb.AttributeValue{
+			S: aws.String(f.CreatedTo)}
+		conditions = append(conditions, "#C <= :to")
+	}
+
+	if len(conditions) == 0 {
+		return
+	}
+
+	input.ExpressionAttributeNames = names
+	input.FilterExpression = aws.String(strings.Join(conditions, " and "))
+}
+
+//Update saves the first name, last name, active flag and custom attributes
+//of the user, and refreshes its search attributes. Only the attributes that
+//changed are validated against the Schema, see CheckChanges
+func (u *User) Update(ctx context.Context, svc dynamodbiface.DynamoDBAPI,
+	tableName string) error {
+
+	log.Debug().Msgf("Updating user: %s", u.Email)
+
+	if u.Email == "" {
+		return errors.New("Email is not set")
+	}
+
+	if u.FirstName == "" {
+		return errors.New(ErrorFirstNameIsEmpty)
+	}
+
+	if u.LastName == "" {
+		return errors.New(ErrorLastNameIsEmpty)
+	}
+
+	schema, err := GetSchema(ctx, svc, tableName)
+	if err != nil {
+		return err
+	}
+
+	old := &User{Email: u.Email}
+	if err := old.Load(ctx, svc, tableName); err != nil {
+		return err
+	}
+	if fields := schema.CheckChanges(old.Attributes,
+		u.Attributes); len(fields) > 0 {
+		return apperr.Validation(fields...)
+	}
+
+	attributes, err := dynamodbattribute.Marshal(u.attributes())
+	if err != nil {
+		return err
+	}
+
+	update := &dynamodb.UpdateItemInput{
+		TableName: aws.String(tableName),
+		Key: map[string]*dynamodb.AttributeValue{
+			"pk": {S: aws.String(u.getUserPK())},
+			"sk": {S: aws.String(u.getProfileSK())},
+		},
+		ExpressionAttributeNames: map[string]*string{
+			"#F": aws.String("firstName"),
+			"#L": aws.String("lastName"),
+			"#A": aws.String("active"),
+			"#X": aws.String("attributes"),
+		},
+		ExpressionAttributeValues: map[string]*dynamodb.AttributeValue{
+			":firstName":  {S: aws.String(u.FirstName)},
+			":lastName":   {S: aws.String(u.LastName)},
+			":active":     {BOOL: aws.Bool(u.Active)},
+			":attributes": attributes,
+		},
+		UpdateExpression:    aws.String("SET #F = :firstName, #L = :lastName, #A = :active, #X = :attributes"),
+		ConditionExpression: aws.String("attribute_exists(pk) AND attribute_exists(sk)"),
+		ReturnValues:        aws.String(dynamodb.ReturnValueAllNew),
+	}
+	//An account activated by an administrator is no longer swept
+	if u.Active {
+		update.ExpressionAttributeNames["#I"] = aws.String(DynamoDBAttributeInactive)
+		update.UpdateExpression = aws.String(
+			aws.StringValue(update.UpdateExpression) + " REMOVE #I")
+	}
+
+	result, err := svc.UpdateItemWithContext(ctx, update)
+	if err != nil {
+		if isConditionalCheckFailed(err) {
+			return errors.New(ErrorUserDoesNotExist)
+		}
+		return err
+	}
+
+	if err := dynamodbattribute.UnmarshalMap(result.Attributes, u); err != nil {
+		return err
+	}
+
+	return u.SyncSearch(ctx, svc, tableName)
+}
+
+//Delete deletes every row in the user's partition: profile, tokens, sessions
+//and any other item stored under USER#[email]
+func (u *User) Delete(ctx context.Context, svc dynamodbiface.DynamoDBAPI,
+	tableName string) error {
+
+	log.Info().Msgf("Deleting user: %s", u.Email)
+
+	if u.Email == "" {
+		return errors.New("Email is not set")
+	}
+
+	var keys []map[string]*dynamodb.AttributeValue
+
+	err := svc.QueryPagesWithContext(ctx, &dynamodb.QueryInput{
+		TableName:              aws.String(tableName),
+		KeyConditionExpression: aws.String("pk = :pk"),
+		ExpressionAttributeValues: map[string]*dynamodb.AttributeValue{
+			":pk": {S: aws.String(u.getUserPK())},
+		},
+		ProjectionExpression: aws.String("pk, sk"),
+	}, func(page *dynamodb.QueryOutput, last bool) bool {
+		keys = append(keys, page.Items...)
+		return true
+	})
+	if err != nil {
+		return err
+	}
+
+	if len(keys) == 0 {
+		return errors.New(ErrorUserDoesNotExist)
+	}
+
+	return batchDelete(ctx, svc, tableName, keys)
+}
+
+//batchDelete deletes the keys in batches, retrying unprocessed items
+func batchDelete(ctx context.Context, svc dynamodbiface.DynamoDBAPI,
+	tableName string, keys []map[string]*dynamodb.AttributeValue) error {
+
+	requests := make([]*dynamodb.WriteRequest, 0, len(keys))
+	for _, key := range keys {
+		requests = append(requests, &dynamodb.WriteRequest{
+			DeleteRequest: &dynamodb.DeleteRequest{Key: key},
+		})
+	}
+
+	return batchWrite(ctx, svc, tableName, requests)
+}
+
+//batchWrite sends the requests in batches of DynamoDBBatchSize, retrying the
+//unprocessed items
+func batchWrite(ctx context.Context, svc dynamodbiface.DynamoDBAPI,
+	tableName string, requests []*dynamodb.WriteRequest) error {
+
+	for start := 0; start < len(requests); start += DynamoDBBatchSize {
+		end := start + DynamoDBBatchSize
+		if end > len(requests) {
+			end = len(requests)
+		}
+
+		items := map[string][]*dynamodb.WriteRequest{tableName: requests[start:end]}
+		for retry := 0; len(items) > 0; retry++ {
+			if retry > 0 {
+				time.Sleep(time.Duration(retry*retry) * 50 * time.Millisecond)
+			}
+
+			result, err := svc.BatchWriteItemWithContext(ctx,
+				&dynamodb.BatchWriteItemInput{RequestItems: items})
+			if err != nil {
+				return err
+			}
+			items = result.UnprocessedItems
+		}
+	}
+
+	return nil
+}
+
+//encodeCursor encodes the LastEvaluatedKey of a query as an opaque string
+func encodeCursor(key map[string]*dynamodb.AttributeValue) (string, error) {
+	if len(key) == 0 {
+		return "", nil
+	}
+
+	values := map[string]string{}
+	for k, v := range key {
+		values[k] = aws.StringValue(v.S)
+	}
+
+	b, err := json.Marshal(values)
+	if err != nil {
+		return "", err
+	}
+
+	return base64.RawURLEncoding.EncodeToString(b), nil
+}
+
+//decodeCursor decodes a cursor returned by encodeCursor
+func decodeCursor(cursor string) (map[string]*dynamodb.AttributeValue, error) {
+	b, err := base64.RawURLEncoding.DecodeString(cursor)
+	if err != nil {
+		return nil, errors.New(ErrorInvalidCursor)
+	}
+
+	var values map[string]string
+	if err := json.Unmarshal(b, &values); err != nil {
+		return nil, errors.New(ErrorInvalidCursor)
+	}
+
+	key := map[string]*dynamodb.AttributeValue{}
+	for k, v := range values {
+		key[k] = &dynamodb.AttributeValue{S: aws.String(v)}
+	}
+
+	return key, nil
+}
+
+//pending tells whether the inactive user still has its activation token, so
+//it is waiting to be activated. An inactive user without the token was
+//deactivated by an administrator
+func (u *User) pending(ctx context.Context, svc dynamodbiface.DynamoDBAPI,
+	tableName string) (bool, error) {
+
+	result, err := svc.GetItemWithContext(ctx, &dynamodb.GetItemInput{
+		TableName: aws.String(tableName),
+		Key: map[string]*dynamodb.AttributeValue{
+			"pk": {S: aws.String(u.getUserPK())},
+			"sk": {S: aws.String(u.getTokenSK())},
+		},
+		ProjectionExpression: aws.String("pk"),
+	})
+	if err != nil {
+		return false, err
+	}
+
+	return result.Item != nil, nil
+}
+
+//activateItems returns the transaction items that activate an user whose
+//ownership of the email was proven by other means than the activation token.
+//Only users waiting for the activation have the token row, so the items fail
+//for the users deactivated by an administrator
+func (u *User) activateItems(tableName string) []*dynamodb.TransactWriteItem {
+	return []*dynamodb.TransactWriteItem{
+		{
+			Update: &dynamodb.Update{
+				TableName: aws.String(tableName),
+				Key: map[string]*dynamodb.AttributeValue{
+					"pk": {S: aws.String(u.getUserPK())},
+					"sk": {S: aws.String(u.getProfileSK())},
+				},
+				ExpressionAttributeNames: map[string]*string{
+					"#A": aws.String("active"),
+					"#I": aws.String(DynamoDBAttributeInactive),
+				},
+				ExpressionAttributeValues: map[string]*dynamodb.AttributeValue{
+					":active":   {BOOL: aws.Bool(true)},
+					":inactive": {BOOL: aws.Bool(false)},
+				},
+				UpdateExpression:    aws.String("SET #A = :active REMOVE #I"),
+				ConditionExpression: aws.String("#A = :inactive"),
+			},
+		},
+		{
+			Delete: &dynamodb.Delete{
+				TableName: aws.String(tableName),
+				Key: map[string]*dynamodb.AttributeValue{
+					"pk": {S: aws.String(u.getUserPK())},
+					"sk": {S: aws.String(u.getTokenSK())},
+				},
+				ConditionExpression: aws.String("attribute_exists(pk)"),
+			},
+		},
+	}
+}
+
+//profilePut returns the transaction item that inserts the profile row
+func (u *User) profilePut(tableName string) *dynamodb.Put {
+	item := map[string]*dynamodb.AttributeValue{
+		"pk":        {S: aws.String(u.getUserPK())},
+		"sk":        {S: aws.String(u.getProfileSK())},
+		"id":        {S: aws.String(u.ID)},
+		"firstName": {S: aws.String(u.FirstName)},
+		"lastName":  {S: aws.String(u.LastName)},
+		"email":     {S: aws.String(u.Email)},
+		"active":    {BOOL: aws.Bool(u.Active)},
+		"created":   {S: aws.String(u.Created)},
+		"type":      {S: aws.String(DynamoDBTypeUser)},
+	}
+	if u.Tenant != "" {
+		item[DynamoDBAttributeTenant] = &dynamodb.AttributeValue{
+			S: aws.String(u.Tenant)}
+	}
+	if len(u.Attributes) > 0 {
+		if attributes, err := dynamodbattribute.Marshal(u.Attributes); err == nil {
+			item["attributes"] = attributes
+		}
+	}
+	u.searchAttributes(item)
+
+	return &dynamodb.Put{
+		Item:                item,
+		TableName:           aws.String(tableName),
+		ConditionExpression: aws.String("attribute_not_exists(pk) and attribute_not_exists(sk)"),
+	}
+}
+
+//tokenPut returns the transaction item that inserts the activation token row.
+//The notify handler mails the token unless the row is silent
+func (u *User) tokenPut(tableName string, silent bool) *dynamodb.Put {
+	token := map[string]*dynamodb.AttributeValue{
+		"pk":        {S: aws.String(u.getUserPK())},
+		"sk":        {S: aws.String(u.getTokenSK())},
+		"id":        {S: aws.String(u.ID)},
+		"firstName": {S: aws.String(u.FirstName)},
+		"lastName":  {S: aws.String(u.LastName)},
+		"email":     {S: aws.String(u.Email)},
+	}
+	if silent {
+		token[DynamoDBAttributeSilent] = &dynamodb.AttributeValue{
+			BOOL: aws.Bool(true)}
+	}
+
+	return &dynamodb.Put{
+		Item:                token,
+		TableName:           aws.String(tableName),
+		ConditionExpression: aws.String("attribute_not_exists(pk) and attribute_not_exists(sk)"),
+	}
+}
+
+//attributes returns the custom attributes, an empty map when there are none
+//so the profile always has a map to update
+func (u *User) attributes() map[string]interface{} {
+	if u.Attributes == nil {
+		return map[string]interface{}{}
+	}
+	return u.Attributes
+}
+
+//getUserPK forms the primary key with the canonical form of the email
 func (u *User) getUserPK() string {
-	return fmt.Sprintf("%s#%s", DynamoDBPrefixUser, u.Email)
+	return fmt.Sprintf("%s#%s", DynamoDBPrefixUser, CanonicalEmail(u.Email))
 }
 
 func (u *User) getProfileSK() string {
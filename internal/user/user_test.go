@@ -2,12 +2,20 @@ package user
 
 import (
 	"context"
+	"encoding/base64"
 	"errors"
 	"reflect"
+	"strconv"
+	"strings"
 	"testing"
+	"time"
 
 	"github.com/aws/aws-lambda-go/events"
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/dynamodb"
 
+	"github.com/roloum/users/internal/apperr"
+	"github.com/roloum/users/internal/seal"
 	"github.com/roloum/users/internal/test"
 	"github.com/rs/zerolog"
 )
@@ -49,7 +57,8 @@ func TestCreateUser(t *testing.T) {
 				Email:    "test@user.com",
 			},
 			mockDBSvc: &test.MockDynamoDB{},
-			err:       errors.New(ErrorFirstNameIsEmpty),
+			err: apperr.Validation(apperr.FieldError{Field: "firstName",
+				Code: ErrorFirstNameIsEmpty}),
 			tableName: UserTable,
 		},
 		{
@@ -59,7 +68,8 @@ func TestCreateUser(t *testing.T) {
 				Email:     "test@user.com",
 			},
 			mockDBSvc: &test.MockDynamoDB{},
-			err:       errors.New(ErrorLastNameIsEmpty),
+			err: apperr.Validation(apperr.FieldError{Field: "lastName",
+				Code: ErrorLastNameIsEmpty}),
 			tableName: UserTable,
 		},
 		{
@@ -69,7 +79,8 @@ func TestCreateUser(t *testing.T) {
 				LastName:  "User",
 			},
 			mockDBSvc: &test.MockDynamoDB{},
-			err:       errors.New(ErrorEmailIsEmpty),
+			err: apperr.Validation(apperr.FieldError{Field: "email",
+				Code: ErrorEmailIsEmpty}),
 			tableName: UserTable,
 		},
 		{
@@ -80,7 +91,20 @@ func TestCreateUser(t *testing.T) {
 				Email:     "yadayadayada",
 			},
 			mockDBSvc: &test.MockDynamoDB{},
-			err:       errors.New(ErrorInvalidEmail),
+			err: apperr.Validation(apperr.FieldError{Field: "email",
+				Code: ErrorInvalidEmail}),
+			tableName: UserTable,
+		},
+		{
+			desc: "AllFields",
+			user: &NewUser{
+				Email: "yadayadayada",
+			},
+			mockDBSvc: &test.MockDynamoDB{},
+			err: apperr.Validation(
+				apperr.FieldError{Field: "email", Code: ErrorInvalidEmail},
+				apperr.FieldError{Field: "firstName", Code: ErrorFirstNameIsEmpty},
+				apperr.FieldError{Field: "lastName", Code: ErrorLastNameIsEmpty}),
 			tableName: UserTable,
 		},
 		{
@@ -130,6 +154,16 @@ func TestIsUserProfileKeys(t *testing.T) {
 		}
 	})
 
+	t.Run("magicKeys", func(t *testing.T) {
+		keys := map[string]events.DynamoDBAttributeValue{
+			"pk": events.NewStringAttribute("USER#"),
+			"sk": events.NewStringAttribute("MAGIC#"),
+		}
+		if result := IsUserMagicKeys(keys); !result {
+			t.Errorf("Expected: %v.", result)
+		}
+	})
+
 	t.Run("differentKeys", func(t *testing.T) {
 		keys := map[string]events.DynamoDBAttributeValue{
 			"pk": events.NewStringAttribute("USER#"),
@@ -140,3 +174,227 @@ func TestIsUserProfileKeys(t *testing.T) {
 		}
 	})
 }
+
+//TestIsMigratedChange Tests that only the rows written by a restore or a
+//migration are skipped by the notify handler
+func TestIsMigratedChange(t *testing.T) {
+
+	image := func(migrated string) map[string]events.DynamoDBAttributeValue {
+		m := map[string]events.DynamoDBAttributeValue{
+			"pk": events.NewStringAttribute("USER#test@user.com"),
+			"sk": events.NewStringAttribute("TOKEN#1234"),
+		}
+		if migrated != "" {
+			m[DynamoDBAttributeMigrated] = events.NewStringAttribute(migrated)
+		}
+		return m
+	}
+
+	tests := []struct {
+		desc     string
+		change   events.DynamoDBStreamRecord
+		expected bool
+	}{
+		{desc: "Written", change: events.DynamoDBStreamRecord{
+			NewImage: image("")}},
+		{desc: "Restored", change: events.DynamoDBStreamRecord{
+			NewImage: image("2020-01-01T00:00:00Z")}, expected: true},
+		{desc: "RestoredAgain", change: events.DynamoDBStreamRecord{
+			OldImage: image("2020-01-01T00:00:00Z"),
+			NewImage: image("2020-02-01T00:00:00Z")}, expected: true},
+		{desc: "ModifiedLater", change: events.DynamoDBStreamRecord{
+			OldImage: image("2020-01-01T00:00:00Z"),
+			NewImage: image("2020-01-01T00:00:00Z")}},
+	}
+
+	for _, tc := range tests {
+		t.Run(tc.desc, func(t *testing.T) {
+			if result := IsMigratedChange(tc.change); result != tc.expected {
+				t.Errorf("Expected: %v. Received: %v", tc.expected, result)
+			}
+		})
+	}
+}
+
+//TestFilter Tests the filter expression built for ListFiltered
+func TestFilter(t *testing.T) {
+
+	active := true
+
+	tests := []struct {
+		desc     string
+		filter   Filter
+		expected string
+	}{
+		{
+			desc:   "Empty",
+			filter: Filter{},
+		},
+		{
+			desc:     "Active",
+			filter:   Filter{Active: &active},
+			expected: "#A = :active",
+		},
+		{
+			desc: "All",
+			filter: Filter{Active: &active, Domain: "@acme.com",
+				CreatedFrom: "2020-01-01", CreatedTo: "2020-12-31"},
+			expected: "#A = :active and contains(#E, :domain) and #C >= :from and #C <= :to",
+		},
+	}
+
+	for _, tc := range tests {
+		t.Run(tc.desc, func(t *testing.T) {
+			input := &dynamodb.QueryInput{
+				ExpressionAttributeValues: map[string]*dynamodb.AttributeValue{},
+			}
+			tc.filter.apply(input)
+
+			if aws.StringValue(input.FilterExpression) != tc.expected {
+				t.Errorf("Expected: %v. Received: %v", tc.expected,
+					aws.StringValue(input.FilterExpression))
+			}
+			if tc.filter.Domain != "" &&
+				aws.StringValue(input.ExpressionAttributeValues[":domain"].S) != "@acme.com" {
+				t.Errorf("Expected: @acme.com. Received: %v",
+					aws.StringValue(input.ExpressionAttributeValues[":domain"].S))
+			}
+		})
+	}
+}
+
+//TestPendingTokens Tests listing the unused activation tokens and magic links
+func TestPendingTokens(t *testing.T) {
+
+	mock := &test.MockDynamoDB{QueryOutput: &dynamodb.QueryOutput{
+		Items: []map[string]*dynamodb.AttributeValue{
+			{"sk": {S: aws.String("PROFILE#")}},
+			{"sk": {S: aws.String("TOKEN#1234")}},
+			{"sk": {S: aws.String("MAGIC#secret")}, "ttl": {N: aws.String("0")}},
+		},
+	}}
+
+	u := &User{Email: "test@user.com"}
+	tokens, err := u.PendingTokens(context.Background(), mock, UserTable)
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+
+	expected := []PendingToken{
+		{Kind: DynamoDBPrefixToken, Token: "1234"},
+		{Kind: DynamoDBPrefixMagic, Expires: "1970-01-01T00:00:00Z"},
+	}
+	if !reflect.DeepEqual(tokens, expected) {
+		t.Errorf("Expected: %+v. Received: %+v", expected, tokens)
+	}
+}
+
+//TestResendActivation Tests replacing the activation token row
+func TestResendActivation(t *testing.T) {
+
+	profile := func(active bool) *dynamodb.GetItemOutput {
+		return &dynamodb.GetItemOutput{Item: map[string]*dynamodb.AttributeValue{
+			"id":     {S: aws.String("1234")},
+			"email":  {S: aws.String("test@user.com")},
+			"active": {BOOL: aws.Bool(active)},
+		}}
+	}
+
+	tests := []struct {
+		desc string
+		mock *test.MockDynamoDB
+		err  error
+	}{
+		{
+			desc: "Resent",
+			mock: &test.MockDynamoDB{
+				GetItemOutput:            profile(false),
+				DeleteItemOutput:         &dynamodb.DeleteItemOutput{},
+				TransactWriteItemsOutput: &dynamodb.TransactWriteItemsOutput{},
+			},
+		},
+		{
+			desc: ErrorUserAlreadyActive,
+			mock: &test.MockDynamoDB{GetItemOutput: profile(true)},
+			err:  errors.New(ErrorUserAlreadyActive),
+		},
+		{
+			desc: ErrorUserDoesNotExist,
+			mock: &test.MockDynamoDB{GetItemOutput: &dynamodb.GetItemOutput{}},
+			err:  errors.New(ErrorUserDoesNotExist),
+		},
+	}
+
+	for _, tc := range tests {
+		t.Run(tc.desc, func(t *testing.T) {
+			u := &User{Email: "test@user.com"}
+			err := u.ResendActivation(context.Background(), tc.mock, UserTable)
+			if !reflect.DeepEqual(err, tc.err) {
+				t.Errorf("Expected: %v. Received: %v", tc.err, err)
+			}
+		})
+	}
+}
+
+//TestCheckMagicLink Tests validating a magic link before it is exchanged
+func TestCheckMagicLink(t *testing.T) {
+
+	expires := func(d time.Duration) *dynamodb.GetItemOutput {
+		return &dynamodb.GetItemOutput{Item: map[string]*dynamodb.AttributeValue{
+			"ttl": {N: aws.String(strconv.FormatInt(time.Now().Add(d).Unix(), 10))},
+		}}
+	}
+
+	tests := []struct {
+		desc string
+		mock *test.MockDynamoDB
+		err  error
+	}{
+		{
+			desc: "Valid",
+			mock: &test.MockDynamoDB{GetItemOutput: expires(time.Minute)},
+		},
+		{
+			desc: "Expired",
+			mock: &test.MockDynamoDB{GetItemOutput: expires(-time.Minute)},
+			err:  errors.New(ErrorInvalidMagicLink),
+		},
+		{
+			desc: "DoesNotExist",
+			mock: &test.MockDynamoDB{GetItemOutput: &dynamodb.GetItemOutput{}},
+			err:  errors.New(ErrorInvalidMagicLink),
+		},
+	}
+
+	for _, tc := range tests {
+		t.Run(tc.desc, func(t *testing.T) {
+			u := &User{Email: "test@user.com"}
+			err := u.CheckMagicLink(context.Background(), tc.mock, UserTable,
+				"token")
+			if !reflect.DeepEqual(err, tc.err) {
+				t.Errorf("Expected: %v. Received: %v", tc.err, err)
+			}
+		})
+	}
+}
+
+//TestMagicLinkToken Tests that the token is only stored hashed and sealed
+func TestMagicLinkToken(t *testing.T) {
+
+	key := base64.StdEncoding.EncodeToString(make([]byte, 32))
+
+	if sk := getMagicSK("token"); strings.Contains(sk, "token") {
+		t.Errorf("Expected the hash of the token. Received: %v", sk)
+	}
+
+	sealed, err := seal.Seal(key, []byte("token"))
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+
+	m := &MagicLink{Email: "test@user.com", Token: sealed}
+	token, err := m.OpenToken(key)
+	if err != nil || token != "token" {
+		t.Errorf("Expected: %v. Received: %v, %v", "token", token, err)
+	}
+}
@@ -1,10 +1,18 @@
 package user
 
 import (
+	"context"
 	"errors"
+	"reflect"
+	"strings"
+	"unicode"
 
 	validator "github.com/go-playground/validator/v10"
 	emailaddress "github.com/mcnijman/go-emailaddress"
+	"github.com/rs/zerolog/log"
+	"golang.org/x/text/unicode/norm"
+
+	"github.com/roloum/users/internal/apperr"
 )
 
 const (
@@ -19,41 +27,153 @@ const (
 
 	//ErrorInvalidEmail Error describes email being invalid
 	ErrorInvalidEmail = "InvalidEmail"
+
+	//ErrorFirstNameTooLong Error describes first name exceeding MaxNameLength
+	ErrorFirstNameTooLong = "FirstNameTooLong"
+
+	//ErrorLastNameTooLong Error describes last name exceeding MaxNameLength
+	ErrorLastNameTooLong = "LastNameTooLong"
+
+	//ErrorEmailTooLong Error describes email exceeding MaxEmailLength
+	ErrorEmailTooLong = "EmailTooLong"
+
+	//ErrorInvalidFirstName Error describes first name having characters that
+	//are not allowed in a name
+	ErrorInvalidFirstName = "InvalidFirstName"
+
+	//ErrorInvalidLastName Error describes last name having characters that are
+	//not allowed in a name
+	ErrorInvalidLastName = "InvalidLastName"
+
+	//ErrorBlockedEmailDomain Error describes email domain being blocked
+	ErrorBlockedEmailDomain = "BlockedEmailDomain"
+
+	//ErrorDisposableEmailDomain Error describes email domain being disposable
+	ErrorDisposableEmailDomain = "DisposableEmailDomain"
+
+	//ErrorEmailDomainHasNoMX Error describes email domain not accepting email
+	ErrorEmailDomainHasNoMX = "EmailDomainHasNoMX"
+
+	//ErrorInvalidField Error describes a field breaking a rule without a code
+	//of its own
+	ErrorInvalidField = "InvalidField"
+
+	//MaxNameLength Maximum number of characters of the first and last names
+	MaxNameLength = 64
+
+	//MaxEmailLength Maximum number of characters of an email address, RFC 5321
+	MaxEmailLength = 254
 )
 
+//validationCodes maps the field and the tag of the rule to the error code
+var validationCodes = map[string]string{
+	"firstName.required":     ErrorFirstNameIsEmpty,
+	"firstName.max":          ErrorFirstNameTooLong,
+	"firstName.validName":    ErrorInvalidFirstName,
+	"lastName.required":      ErrorLastNameIsEmpty,
+	"lastName.max":           ErrorLastNameTooLong,
+	"lastName.validName":     ErrorInvalidLastName,
+	"email.required":         ErrorEmailIsEmpty,
+	"email.max":              ErrorEmailTooLong,
+	"email.validEmail":       ErrorInvalidEmail,
+	"email.blockedDomain":    ErrorBlockedEmailDomain,
+	"email.disposableDomain": ErrorDisposableEmailDomain,
+	"email.mx":               ErrorEmailDomainHasNoMX,
+}
+
 var validate *validator.Validate
 
 //init instantiates a validator
 func init() {
 	validate = validator.New()
 
+	//Fields are reported with their JSON name
+	validate.RegisterTagNameFunc(func(f reflect.StructField) string {
+		return strings.SplitN(f.Tag.Get("json"), ",", 2)[0]
+	})
+
 	validate.RegisterValidation("validEmail", isValidEmail)
+	validate.RegisterValidation("validName", isValidName)
+	validate.RegisterValidation("blockedDomain", isNotBlockedDomain)
+	validate.RegisterValidation("disposableDomain", isNotDisposableDomain)
+	validate.RegisterValidationCtx("mx", hasMX)
 }
 
-//getValidationError Returns the first error reported by the validator
-func getValidationError(verr error) error {
+//Normalize trims the fields and converts them to NFC, so the same name is
+//stored with the same bytes. Whitespace inside the names is collapsed. The
+//email keeps its case for display, the keys use CanonicalEmail
+func Normalize(nu *NewUser) {
+	nu.FirstName = norm.NFC.String(strings.Join(strings.Fields(nu.FirstName), " "))
+	nu.LastName = norm.NFC.String(strings.Join(strings.Fields(nu.LastName), " "))
+	nu.Email = norm.NFC.String(strings.TrimSpace(nu.Email))
+}
+
+//Validate normalizes nu and validates it with the same rules used by Create.
+//The apperr.ErrorValidation returned lists every invalid field
+func Validate(ctx context.Context, nu *NewUser) error {
+
+	Normalize(nu)
+
+	if err := validate.StructCtx(ctx, nu); err != nil {
+		return getValidationError(err)
+	}
+	return nil
+}
+
+//ValidateWithSchema validates nu like Validate and its custom attributes
+//against the schema, reporting the fields of both in the same error
+func ValidateWithSchema(ctx context.Context, nu *NewUser, schema Schema) error {
+	return validateWithSchema(ctx, nu, schema, true)
+}
+
+//validateWithSchema is ValidateWithSchema, without the required attributes
+//for the callers that can not supply them
+func validateWithSchema(ctx context.Context, nu *NewUser, schema Schema,
+	required bool) error {
 
-	//Retrieve first error
-	err := verr.(validator.ValidationErrors)[0]
-
-	switch err.Field() {
-	case "FirstName":
-		return errors.New(ErrorFirstNameIsEmpty)
-	case "LastName":
-		return errors.New(ErrorLastNameIsEmpty)
-	case "Email":
-		switch err.Tag() {
-		case "required":
-			return errors.New(ErrorEmailIsEmpty)
-			//		case "email":
-			//			return errors.New(ErrorInvalidEmail)
-		case "validEmail":
-			return errors.New(ErrorInvalidEmail)
+	var fields []apperr.FieldError
+
+	if err := Validate(ctx, nu); err != nil {
+		var verr *apperr.Error
+		if !errors.As(err, &verr) {
+			return err
 		}
+		fields = append(fields, verr.Fields...)
+	}
+
+	if required {
+		fields = append(fields, schema.Check(nu.Attributes)...)
+	} else {
+		fields = append(fields, schema.CheckValues(nu.Attributes)...)
 	}
+	if len(fields) > 0 {
+		return apperr.Validation(fields...)
+	}
+
 	return nil
 }
 
+//getValidationError converts the errors reported by the validator. A rule
+//without a code is still reported, as ErrorInvalidField
+func getValidationError(verr error) error {
+
+	verrs, ok := verr.(validator.ValidationErrors)
+	if !ok {
+		return verr
+	}
+
+	var fields []apperr.FieldError
+	for _, err := range verrs {
+		code, ok := validationCodes[err.Field()+"."+err.Tag()]
+		if !ok {
+			code = ErrorInvalidField
+		}
+		fields = append(fields, apperr.FieldError{Field: err.Field(), Code: code})
+	}
+
+	return apperr.Validation(fields...)
+}
+
 //isValidEmail validates an email address using go-emailaddress
 //From validator: This validates that a string value contains a valid email
 //This may not conform to all possibilities of any rfc standard, but neither
@@ -64,3 +184,50 @@ func isValidEmail(fl validator.FieldLevel) bool {
 	}
 	return true
 }
+
+//isValidName accepts letters of any script with their combining marks,
+//separated by spaces, hyphens, apostrophes or periods. A name starts with a
+//letter
+func isValidName(fl validator.FieldLevel) bool {
+
+	for i, r := range fl.Field().String() {
+		switch {
+		case unicode.IsLetter(r):
+		case i == 0:
+			return false
+		case unicode.Is(unicode.M, r):
+		case r == ' ', r == '-', r == '\'', r == '’', r == '.':
+		default:
+			return false
+		}
+	}
+	return true
+}
+
+//isNotBlockedDomain rejects the domains blocked by the rules
+func isNotBlockedDomain(fl validator.FieldLevel) bool {
+	return !inDomains(rules.Blocked, Domain(fl.Field().String()))
+}
+
+//isNotDisposableDomain rejects the disposable domains of the rules
+func isNotDisposableDomain(fl validator.FieldLevel) bool {
+	return !inDomains(rules.Disposable, Domain(fl.Field().String()))
+}
+
+//hasMX rejects the domains without MX records when the rules check them. A
+//failed lookup does not reject the email
+func hasMX(ctx context.Context, fl validator.FieldLevel) bool {
+
+	if rules.MX == nil {
+		return true
+	}
+
+	domain := Domain(CanonicalEmail(fl.Field().String()))
+	ok, err := rules.MX.HasMX(ctx, domain)
+	if err != nil {
+		log.Warn().Msgf("MX lookup of %s failed: %s", domain, err)
+		return true
+	}
+
+	return ok
+}
@@ -0,0 +1,144 @@
+package user
+
+import (
+	"context"
+	"errors"
+	"io/ioutil"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/roloum/users/internal/apperr"
+)
+
+//mockMX answers HasMX from a map, returning err when it is set
+type mockMX struct {
+	domains map[string]bool
+	err     error
+}
+
+func (m mockMX) HasMX(ctx context.Context, domain string) (bool, error) {
+	return m.domains[domain], m.err
+}
+
+//TestValidate Tests the rules and the normalization of NewUser
+func TestValidate(t *testing.T) {
+
+	defer SetRules(nil)
+
+	rules := &Rules{
+		Blocked:    domainSet([]string{"blocked.com"}),
+		Disposable: domainSet([]string{"@mailinator.com"}),
+		MX:         mockMX{domains: map[string]bool{"user.com": true}},
+	}
+
+	tests := []struct {
+		desc     string
+		user     NewUser
+		rules    *Rules
+		expected NewUser
+		err      error
+	}{
+		{desc: "Normalized",
+			user: NewUser{Email: " Test@User.COM ", FirstName: "  John ",
+				LastName: "de  la Cruz"},
+			expected: NewUser{Email: "Test@User.COM", FirstName: "John",
+				LastName: "de la Cruz"}},
+		{desc: "NFC",
+			user: NewUser{Email: "a@user.com", FirstName: "Jose\u0301",
+				LastName: "Zoe\u0308"},
+			expected: NewUser{Email: "a@user.com", FirstName: "Jos\u00e9",
+				LastName: "Zo\u00eb"}},
+		{desc: "Unicode",
+			user: NewUser{Email: "a@user.com", FirstName: "Владимир",
+				LastName: "O’Brien-Smith Jr."},
+			expected: NewUser{Email: "a@user.com", FirstName: "Владимир",
+				LastName: "O’Brien-Smith Jr."}},
+		{desc: "InvalidNames",
+			user: NewUser{Email: "a@user.com", FirstName: "-John",
+				LastName: "Smith2"},
+			err: apperr.Validation(
+				apperr.FieldError{Field: "firstName", Code: ErrorInvalidFirstName},
+				apperr.FieldError{Field: "lastName", Code: ErrorInvalidLastName})},
+		{desc: "TooLong",
+			user: NewUser{Email: strings.Repeat("a", 250) + "@user.com",
+				FirstName: strings.Repeat("é", MaxNameLength+1), LastName: "Smith"},
+			err: apperr.Validation(
+				apperr.FieldError{Field: "email", Code: ErrorEmailTooLong},
+				apperr.FieldError{Field: "firstName", Code: ErrorFirstNameTooLong})},
+		{desc: "MaxNameLength",
+			user: NewUser{Email: "a@user.com",
+				FirstName: strings.Repeat("é", MaxNameLength), LastName: "Smith"},
+			expected: NewUser{Email: "a@user.com",
+				FirstName: strings.Repeat("é", MaxNameLength), LastName: "Smith"}},
+		{desc: "Blocked", rules: rules,
+			user: NewUser{Email: "a@mail.blocked.com", FirstName: "A", LastName: "B"},
+			err: apperr.Validation(apperr.FieldError{Field: "email",
+				Code: ErrorBlockedEmailDomain})},
+		{desc: "Disposable", rules: rules,
+			user: NewUser{Email: "a@Mailinator.com", FirstName: "A", LastName: "B"},
+			err: apperr.Validation(apperr.FieldError{Field: "email",
+				Code: ErrorDisposableEmailDomain})},
+		{desc: "NoMX", rules: rules,
+			user: NewUser{Email: "a@nomx.com", FirstName: "A", LastName: "B"},
+			err: apperr.Validation(apperr.FieldError{Field: "email",
+				Code: ErrorEmailDomainHasNoMX})},
+		{desc: "MX", rules: rules,
+			user:     NewUser{Email: "a@user.com", FirstName: "A", LastName: "B"},
+			expected: NewUser{Email: "a@user.com", FirstName: "A", LastName: "B"}},
+		{desc: "MXLookupFailed",
+			rules: &Rules{MX: mockMX{err: errors.New("timeout")}},
+			user:  NewUser{Email: "a@nomx.com", FirstName: "A", LastName: "B"},
+			expected: NewUser{Email: "a@nomx.com", FirstName: "A",
+				LastName: "B"}},
+	}
+
+	for _, tc := range tests {
+		t.Run(tc.desc, func(t *testing.T) {
+			SetRules(tc.rules)
+			err := Validate(context.Background(), &tc.user)
+			if !reflect.DeepEqual(err, tc.err) {
+				t.Fatalf("Expected: %v. Received: %v", tc.err, err)
+			}
+			if err == nil && !reflect.DeepEqual(tc.user, tc.expected) {
+				t.Errorf("Expected: %+v. Received: %+v", tc.expected, tc.user)
+			}
+		})
+	}
+}
+
+//TestNewRules Tests building the rules of the configuration
+func TestNewRules(t *testing.T) {
+
+	file := filepath.Join(t.TempDir(), "disposable.txt")
+	err := ioutil.WriteFile(file, []byte("# disposable\nYopmail.com\n\n"), 0600)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var cfg ValidationConfig
+	cfg.Domains.Blocked = []string{"Blocked.com", " "}
+	cfg.Domains.Disposable = []string{"mailinator.com"}
+	cfg.Domains.File = file
+
+	r, err := NewRules(cfg)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	expected := &Rules{
+		Blocked:    map[string]bool{"blocked.com": true},
+		Disposable: map[string]bool{"mailinator.com": true, "yopmail.com": true},
+	}
+	if !reflect.DeepEqual(r, expected) {
+		t.Errorf("Expected: %+v. Received: %+v", expected, r)
+	}
+
+	t.Run("FileDoesNotExist", func(t *testing.T) {
+		cfg.Domains.File = filepath.Join(t.TempDir(), "missing.txt")
+		if _, err := NewRules(cfg); err == nil {
+			t.Error("Expected an error")
+		}
+	})
+}